@@ -0,0 +1,132 @@
+;(function (w, d) {
+  if (!w.fetch || !w.PublicKeyCredential || !w.navigator.credentials) return
+
+  const loginButton = d.getElementById('webauthn-login')
+  const twofaButton = d.getElementById('webauthn-twofactor')
+  const storage = w.localStorage
+
+  const fromBase64 = function (str) {
+    const base64 = str.replace(/-/g, '+').replace(/_/g, '/')
+    return Uint8Array.from(w.atob(base64), (c) => c.charCodeAt(0))
+  }
+
+  const toBase64 = function (buffer) {
+    const bytes = new Uint8Array(buffer)
+    let str = ''
+    for (let i = 0; i < bytes.length; i++) {
+      str += String.fromCharCode(bytes[i])
+    }
+    return w
+      .btoa(str)
+      .replace(/\+/g, '-')
+      .replace(/\//g, '_')
+      .replace(/=+$/, '')
+  }
+
+  // Asks the authenticator to sign the challenge, and returns the credential
+  // serialized in JSON.
+  const getAssertion = function (button) {
+    const options = JSON.parse(button.dataset.options)
+    options.challenge = fromBase64(options.challenge)
+    options.allowCredentials = (options.allowCredentials || []).map((c) => {
+      return { type: c.type, id: fromBase64(c.id) }
+    })
+    return w.navigator.credentials
+      .get({ publicKey: options })
+      .then(function (cred) {
+        const response = {
+          clientDataJSON: toBase64(cred.response.clientDataJSON),
+          authenticatorData: toBase64(cred.response.authenticatorData),
+          signature: toBase64(cred.response.signature),
+        }
+        if (cred.response.userHandle) {
+          response.userHandle = toBase64(cred.response.userHandle)
+        }
+        return JSON.stringify({ id: cred.id, type: cred.type, response })
+      })
+  }
+
+  const valueOf = function (id) {
+    const input = d.getElementById(id)
+    return input ? input.value : ''
+  }
+
+  const isChecked = function (id) {
+    const input = d.getElementById(id)
+    if (!input) return '0'
+    if (input.type !== 'checkbox') return input.value === 'true' ? '1' : '0'
+    return input.checked ? '1' : '0'
+  }
+
+  const submit = function (button, url, data, errorField) {
+    button.setAttribute('disabled', true)
+    return getAssertion(button)
+      .then(function (credential) {
+        data.append('webauthn-token', button.dataset.token)
+        data.append('webauthn-credential', credential)
+        const headers = new Headers()
+        headers.append('Content-Type', 'application/x-www-form-urlencoded')
+        headers.append('Accept', 'application/json')
+        return fetch(url, {
+          method: 'POST',
+          headers: headers,
+          body: data,
+          credentials: 'same-origin',
+        })
+      })
+      .then(function (response) {
+        return response.json().then(function (body) {
+          if (response.status >= 400) {
+            throw new Error(body.error)
+          }
+          if (
+            storage &&
+            typeof body.two_factor_trusted_device_token == 'string'
+          ) {
+            storage.setItem(
+              'trusted-device-token',
+              body.two_factor_trusted_device_token,
+            )
+          }
+          w.location = body.redirect
+        })
+      })
+      .catch(function (err) {
+        button.removeAttribute('disabled')
+        w.showError(errorField, err.message || err)
+      })
+  }
+
+  if (loginButton) {
+    loginButton.classList.remove('d-none')
+    loginButton.addEventListener('click', function () {
+      const data = new URLSearchParams()
+      data.append('csrf_token', valueOf('csrf_token'))
+      data.append('redirect', valueOf('redirect') + w.location.hash)
+      data.append('long-run-session', isChecked('long-run-session'))
+      const field = d.getElementById('login-field') || loginButton.parentNode
+      submit(loginButton, '/auth/webauthn/login', data, field)
+    })
+  }
+
+  if (twofaButton) {
+    twofaButton.classList.remove('d-none')
+    twofaButton.addEventListener('click', function () {
+      const data = new URLSearchParams()
+      data.append('two-factor-token', valueOf('two-factor-token'))
+      data.append('long-run-session', isChecked('long-run-session'))
+      data.append(
+        'two-factor-generate-trusted-device-token',
+        isChecked('two-factor-trust-device'),
+      )
+      data.append('redirect', valueOf('redirect') + w.location.hash)
+      data.append('state', valueOf('state'))
+      data.append('client_id', valueOf('client_id'))
+      if (valueOf('confirm') === 'true') {
+        data.append('confirm', true)
+      }
+      const field = d.getElementById('two-factor-field') || twofaButton.parentNode
+      submit(twofaButton, '/auth/twofactor', data, field)
+    })
+  }
+})(window, document)
@@ -124,6 +124,12 @@ type ImportOptions struct {
 	ManifestURL string
 }
 
+// RestoreOptions is a struct with the options for restoring a backup.
+type RestoreOptions struct {
+	From     string
+	BackupID string
+}
+
 // DBPrefix returns the database prefix for the instance
 func (i *Instance) DBPrefix() string {
 	if i.Attrs.Prefix != "" {
@@ -345,6 +351,20 @@ func (ac *AdminClient) CleanSessions(domain string) error {
 	return err
 }
 
+// ResetTOTP removes the authenticator app and the recovery codes used for the
+// two factor authentication of an instance.
+func (ac *AdminClient) ResetTOTP(domain string) error {
+	if !validDomain(domain) {
+		return fmt.Errorf("Invalid domain: %s", domain)
+	}
+	_, err := ac.Req(&request.Options{
+		Method:     "DELETE",
+		Path:       "/instances/" + domain + "/totp",
+		NoResponse: true,
+	})
+	return err
+}
+
 // DisableDebug disables the debug mode for the logger of an instance.
 func (ac *AdminClient) DisableDebug(domain string) error {
 	if !validDomain(domain) {
@@ -540,6 +560,82 @@ func (ac *AdminClient) Import(domain string, opts *ImportOptions) error {
 	return err
 }
 
+// Backup starts a backup of the instance. It is an incremental backup, unless
+// full is true or the current chain of backups is complete.
+func (ac *AdminClient) Backup(domain string, full bool) error {
+	if !validDomain(domain) {
+		return fmt.Errorf("Invalid domain: %s", domain)
+	}
+	_, err := ac.Req(&request.Options{
+		Method: "POST",
+		Path:   "/instances/" + url.PathEscape(domain) + "/backups",
+		Queries: url.Values{
+			"full": {strconv.FormatBool(full)},
+		},
+		NoResponse: true,
+	})
+	return err
+}
+
+// ScheduleBackups enables or disables the daily backups of the instance.
+func (ac *AdminClient) ScheduleBackups(domain string, enabled bool) error {
+	if !validDomain(domain) {
+		return fmt.Errorf("Invalid domain: %s", domain)
+	}
+	method := "POST"
+	if !enabled {
+		method = "DELETE"
+	}
+	_, err := ac.Req(&request.Options{
+		Method:     method,
+		Path:       "/instances/" + url.PathEscape(domain) + "/backups/schedule",
+		NoResponse: true,
+	})
+	return err
+}
+
+// ListBackups returns the backups of the instance, from the oldest to the
+// most recent.
+func (ac *AdminClient) ListBackups(domain string) ([]*move.Backup, error) {
+	if !validDomain(domain) {
+		return nil, fmt.Errorf("Invalid domain: %s", domain)
+	}
+	res, err := ac.Req(&request.Options{
+		Method: "GET",
+		Path:   "/instances/" + url.PathEscape(domain) + "/backups",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var backups []*move.Backup
+	if err = json.NewDecoder(res.Body).Decode(&backups); err != nil {
+		return nil, err
+	}
+	return backups, nil
+}
+
+// Restore resets the instance and restores the data from a chain of backups.
+func (ac *AdminClient) Restore(domain string, opts *RestoreOptions) error {
+	if !validDomain(domain) {
+		return fmt.Errorf("Invalid domain: %s", domain)
+	}
+	q := url.Values{}
+	if opts.From != "" {
+		q.Set("from", opts.From)
+	}
+	if opts.BackupID != "" {
+		q.Set("backup", opts.BackupID)
+	}
+	_, err := ac.Req(&request.Options{
+		Method:     "POST",
+		Path:       "/instances/" + url.PathEscape(domain) + "/restore",
+		Queries:    q,
+		NoResponse: true,
+	})
+	return err
+}
+
 // RebuildRedis puts the triggers in redis.
 func (ac *AdminClient) RebuildRedis() error {
 	_, err := ac.Req(&request.Options{
@@ -16,6 +16,7 @@ var flagCheckFSIndexIntegrity bool
 var flagCheckFSFilesConsistensy bool
 var flagCheckFSFailFast bool
 var flagCheckSharingsFast bool
+var flagCheckSchemasDoctype string
 
 var checkCmdGroup = &cobra.Command{
 	Use:   "check <command>",
@@ -228,15 +229,63 @@ check via the flags.
 	},
 }
 
+var checkSchemasCmd = &cobra.Command{
+	Use:   "schemas <domain>",
+	Short: "Check the documents with the JSON schemas of their doctypes",
+	Long: `
+This command reports the existing documents that don't respect the JSON schema
+of their doctype, for the schemas registered by the stack and the schemas
+declared in the manifests of the installed apps. The strictness configured for
+the doctypes is ignored: even the documents that would be accepted with a
+warning are reported.
+`,
+	Example: `$ cozy-stack check schemas alice.cozy.localhost:8080 --doctype io.cozy.bills`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return cmd.Usage()
+		}
+		domain := args[0]
+
+		ac := newAdminClient()
+		res, err := ac.Req(&request.Options{
+			Method: "POST",
+			Path:   "/instances/" + url.PathEscape(domain) + "/checks/schemas",
+			Queries: url.Values{
+				"Doctype": {flagCheckSchemasDoctype},
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		var result []map[string]interface{}
+		err = json.NewDecoder(res.Body).Decode(&result)
+		if err != nil {
+			return err
+		}
+
+		if len(result) > 0 {
+			for _, r := range result {
+				j, _ := json.Marshal(r)
+				fmt.Fprintf(os.Stdout, "%s\n", j)
+			}
+			os.Exit(1)
+		}
+		return nil
+	},
+}
+
 func init() {
 	checkCmdGroup.AddCommand(checkFSCmd)
 	checkCmdGroup.AddCommand(checkTriggers)
 	checkCmdGroup.AddCommand(checkSharedCmd)
 	checkCmdGroup.AddCommand(checkSharingsCmd)
+	checkCmdGroup.AddCommand(checkSchemasCmd)
 	checkFSCmd.Flags().BoolVar(&flagCheckFSIndexIntegrity, "index-integrity", false, "Check the index integrity only")
 	checkFSCmd.Flags().BoolVar(&flagCheckFSFilesConsistensy, "files-consistency", false, "Check the files consistency only (between CouchDB and Swift)")
 	checkFSCmd.Flags().BoolVar(&flagCheckFSFailFast, "fail-fast", false, "Stop the FSCK on the first error")
 	checkSharingsCmd.Flags().BoolVar(&flagCheckSharingsFast, "fast", false, "Skip the sharings FS consistency check")
+	checkSchemasCmd.Flags().StringVar(&flagCheckSchemasDoctype, "doctype", "", "Check only the documents of this doctype")
 
 	RootCmd.AddCommand(checkCmdGroup)
 }
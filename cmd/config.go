@@ -15,6 +15,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/pkg/keyring"
 	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/cozy/cozy-stack/pkg/webpush"
 	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
@@ -150,6 +151,30 @@ keyfiles written in:
 	},
 }
 
+var genVAPIDKeysCmd = &cobra.Command{
+	Use:   "gen-vapid-keys",
+	Short: "Generate a key pair for Web Push notifications",
+	Long: `
+cozy-stack config gen-vapid-keys generates a key pair for the identification of
+the stack by the push services of the browsers (VAPID).
+
+The private key can be used for the notifications.vapid_private_key parameter
+of the configuration file. It must be kept secret: each stack should use its
+own keys, generated with this command, and not the ones from an example.`,
+	Example: `$ cozy-stack config gen-vapid-keys
+private key: <base64url-encoded private key>
+public key:  <base64url-encoded public key>
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		privateKey, publicKey, err := webpush.GenerateVAPIDKeys()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("private key: %s\npublic key:  %s\n", privateKey, publicKey)
+		return nil
+	},
+}
+
 var encryptCredentialsDataCmd = &cobra.Command{
 	Use:   "encrypt-data <encoding keyfile> <text>",
 	Short: "Encrypt data with the specified encryption keyfile.",
@@ -406,6 +431,7 @@ var listContextsCmd = &cobra.Command{
 func init() {
 	configCmdGroup.AddCommand(adminPasswdCmd)
 	configCmdGroup.AddCommand(genKeysCmd)
+	configCmdGroup.AddCommand(genVAPIDKeysCmd)
 	configCmdGroup.AddCommand(encryptCredentialsDataCmd)
 	configCmdGroup.AddCommand(decryptCredentialsDataCmd)
 	configCmdGroup.AddCommand(encryptCredentialsCmd)
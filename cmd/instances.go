@@ -65,6 +65,11 @@ var flagOnboardingApp string
 var flagOnboardingPermissions string
 var flagOnboardingState string
 var flagPath string
+var flagFull bool
+var flagSchedule bool
+var flagUnschedule bool
+var flagFrom string
+var flagBackupID string
 
 // instanceCmdGroup represents the instances command
 var instanceCmdGroup = &cobra.Command{
@@ -920,6 +925,88 @@ var importCmd = &cobra.Command{
 	},
 }
 
+var backupInstanceCmd = &cobra.Command{
+	Use:   "backup",
+	Short: "Backup an instance",
+	Long: `Create a backup of the instance on the target configured in the backups
+section of the config file.
+
+The backup is an incremental backup of the previous one: it contains only the
+documents changed since the previous backup, and the content of the files
+uploaded since then. After a configured number of incremental backups, a new
+full backup is made. The --full flag can be used to force a full backup.
+
+The --schedule flag enables a daily backup of the instance, and --unschedule
+disables it.`,
+	Example: "$ cozy-stack instances backup --domain cozy.localhost:8080 --schedule",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ac := newAdminClient()
+		if flagSchedule || flagUnschedule {
+			return ac.ScheduleBackups(flagDomain, flagSchedule)
+		}
+		return ac.Backup(flagDomain, flagFull)
+	},
+}
+
+var lsBackupsInstanceCmd = &cobra.Command{
+	Use:     "ls-backups",
+	Short:   "List the backups of an instance",
+	Example: "$ cozy-stack instances ls-backups --domain cozy.localhost:8080",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ac := newAdminClient()
+		backups, err := ac.ListBackups(flagDomain)
+		if err != nil {
+			return err
+		}
+		if flagJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			for _, backup := range backups {
+				if err := encoder.Encode(backup); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, backup := range backups {
+			kind := "incremental"
+			if backup.IsFull() {
+				kind = "full"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d docs\t%d files\t%d bytes\n",
+				backup.ID, kind, backup.CreatedAt.Format(time.RFC3339),
+				backup.NbDocs, backup.NbFiles, backup.Size)
+		}
+		return w.Flush()
+	},
+}
+
+var restoreInstanceCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore an instance from its backups",
+	Long: `This command will reset the Cozy instance and restore the data from a
+backup. The full backup and the incremental backups of its chain are replayed
+in order.
+
+By default, the most recent backup of the instance is restored. The --backup
+flag can be used to restore an older one, and the --from flag to restore the
+backups of another domain (for example, on a fresh instance after the original
+one has been destroyed).`,
+	Example: "$ cozy-stack instances restore --domain cozy.localhost:8080 --backup 20240312T031500Z",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ac := newAdminClient()
+		if !flagForce {
+			if err := confirmDomain("reset", flagDomain); err != nil {
+				return err
+			}
+		}
+		return ac.Restore(flagDomain, &client.RestoreOptions{
+			From:     flagFrom,
+			BackupID: flagBackupID,
+		})
+	},
+}
+
 var showSwiftPrefixInstanceCmd = &cobra.Command{
 	Use:     "show-swift-prefix <domain>",
 	Short:   "Show the instance swift prefix of the specified domain",
@@ -999,8 +1086,10 @@ var setAuthModeCmd = &cobra.Command{
 	Use:     "auth-mode [domain] [auth-mode]",
 	Short:   `Set instance auth-mode`,
 	Example: "$ cozy-stack instances auth-mode cozy.localhost:8080 two_factor_mail",
-	Long: `Change the authentication mode for an instance. Two options are allowed:
+	Long: `Change the authentication mode for an instance. Those options are allowed:
 - two_factor_mail
+- two_factor_webauthn (a passkey must have been registered)
+- two_factor_totp (an authenticator app must have been enrolled)
 - basic
 `,
 	RunE: func(cmd *cobra.Command, args []string) error {
@@ -1060,6 +1149,24 @@ var cleanSessionsCmd = &cobra.Command{
 	},
 }
 
+var resetTOTPCmd = &cobra.Command{
+	Use:   "reset-totp <domain>",
+	Short: "Remove the authenticator app and the recovery codes of an instance",
+	Long: `Remove the authenticator app and the recovery codes used for the two factor
+authentication of an instance, for example when the user has lost their phone.
+If the authenticator app was used for the 2FA, the passcode is sent by mail
+instead.`,
+	Example: "$ cozy-stack instances reset-totp cozy.localhost:8080",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			return cmd.Usage()
+		}
+		domain := args[0]
+		ac := newAdminClient()
+		return ac.ResetTOTP(domain)
+	},
+}
+
 func init() {
 	instanceCmdGroup.AddCommand(showInstanceCmd)
 	instanceCmdGroup.AddCommand(showDBPrefixInstanceCmd)
@@ -1080,11 +1187,15 @@ func init() {
 	instanceCmdGroup.AddCommand(findOauthClientCmd)
 	instanceCmdGroup.AddCommand(exportCmd)
 	instanceCmdGroup.AddCommand(importCmd)
+	instanceCmdGroup.AddCommand(backupInstanceCmd)
+	instanceCmdGroup.AddCommand(lsBackupsInstanceCmd)
+	instanceCmdGroup.AddCommand(restoreInstanceCmd)
 	instanceCmdGroup.AddCommand(showSwiftPrefixInstanceCmd)
 	instanceCmdGroup.AddCommand(instanceAppVersionCmd)
 	instanceCmdGroup.AddCommand(updateInstancePassphraseCmd)
 	instanceCmdGroup.AddCommand(setAuthModeCmd)
 	instanceCmdGroup.AddCommand(cleanSessionsCmd)
+	instanceCmdGroup.AddCommand(resetTOTPCmd)
 	addInstanceCmd.Flags().StringSliceVar(&flagDomainAliases, "domain-aliases", nil, "Specify one or more aliases domain for the instance (separated by ',')")
 	addInstanceCmd.Flags().StringVar(&flagOldDomain, "old-domain", "", "Old domain of the cozy instance")
 	addInstanceCmd.Flags().StringVar(&flagLocale, "locale", consts.DefaultLocale, "Locale of the new cozy instance")
@@ -1152,5 +1263,19 @@ func init() {
 	importCmd.Flags().BoolVar(&flagForce, "force", false, "Force the import without asking for confirmation")
 	_ = exportCmd.MarkFlagRequired("domain")
 	_ = importCmd.MarkFlagRequired("domain")
+	backupInstanceCmd.Flags().StringVar(&flagDomain, "domain", "", "Specify the domain name of the instance")
+	backupInstanceCmd.Flags().BoolVar(&flagFull, "full", false, "Make a full backup instead of an incremental one")
+	backupInstanceCmd.Flags().BoolVar(&flagSchedule, "schedule", false, "Enable the daily backups of the instance")
+	backupInstanceCmd.Flags().BoolVar(&flagUnschedule, "unschedule", false, "Disable the daily backups of the instance")
+	backupInstanceCmd.MarkFlagsMutuallyExclusive("schedule", "unschedule")
+	lsBackupsInstanceCmd.Flags().StringVar(&flagDomain, "domain", "", "Specify the domain name of the instance")
+	lsBackupsInstanceCmd.Flags().BoolVar(&flagJSON, "json", false, "Show each line as a json representation of the backup")
+	restoreInstanceCmd.Flags().StringVar(&flagDomain, "domain", "", "Specify the domain name of the instance")
+	restoreInstanceCmd.Flags().StringVar(&flagFrom, "from", "", "Specify the domain of the backed up instance (default: the restored instance)")
+	restoreInstanceCmd.Flags().StringVar(&flagBackupID, "backup", "", "Specify the identifier of the backup to restore (default: the most recent)")
+	restoreInstanceCmd.Flags().BoolVar(&flagForce, "force", false, "Force the restore without asking for confirmation")
+	_ = backupInstanceCmd.MarkFlagRequired("domain")
+	_ = lsBackupsInstanceCmd.MarkFlagRequired("domain")
+	_ = restoreInstanceCmd.MarkFlagRequired("domain")
 	RootCmd.AddCommand(instanceCmdGroup)
 }
@@ -13,6 +13,7 @@ import (
 	semver "github.com/Masterminds/semver/v3"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/schema"
 	"github.com/cozy/cozy-stack/pkg/appfs"
 	build "github.com/cozy/cozy-stack/pkg/config"
 	"github.com/cozy/cozy-stack/pkg/config/config"
@@ -286,6 +287,7 @@ func (i *Installer) run() (err error) {
 			i.log.Errorf("Could not commit installer process: %s", err)
 		} else {
 			i.log.Infof("Successful installer process: %s", i.man.Version())
+			schema.ClearCache(i.db)
 		}
 	}()
 	i.log.Infof("Start installer process: %s", i.man.Version())
@@ -599,6 +601,23 @@ func (i *Installer) ReadManifest(state State) (Manifest, error) {
 		}
 	}
 
+	// Checking the JSON schemas that the app declares for its doctypes, as
+	// they will be used to validate the documents written by all the apps
+	declared := struct {
+		Schemas map[string]json.RawMessage `json:"schemas"`
+	}{}
+	if err = json.Unmarshal(buf.Bytes(), &declared); err == nil {
+		for doctype, raw := range declared.Schemas {
+			if err := permission.CheckDoctypeName(doctype, false); err != nil {
+				return nil, err
+			}
+			if _, err := schema.Compile(raw); err != nil {
+				return nil, fmt.Errorf("[%s] %w: invalid schema for %s: %s",
+					i.man.Slug(), ErrBadManifest, doctype, err)
+			}
+		}
+	}
+
 	appTypesEmpty := i.man.AppType() == 0 || newManifestAppType == 0
 	appTypesMismatch := i.man.AppType() != newManifestAppType
 
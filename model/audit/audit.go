@@ -0,0 +1,262 @@
+// Package audit is for the security audit log of an instance: the sensitive
+// operations, like a change of passphrase or the creation of a sharing link,
+// are saved in the io.cozy.audit doctype, with who made them and from where.
+package audit
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/justincampbell/bigduration"
+)
+
+// The events that are saved in the audit log.
+const (
+	PassphraseUpdated         = "passphrase.updated"
+	PassphraseReset           = "passphrase.reset"
+	AuthModeUpdated           = "auth_mode.updated"
+	TOTPEnrolled              = "totp.enrolled"
+	TOTPRemoved               = "totp.removed"
+	TOTPRecoveryCodesRenewed  = "totp.recovery_codes_renewed"
+	WebAuthnCredentialAdded   = "webauthn.credential_added"
+	WebAuthnCredentialRemoved = "webauthn.credential_removed"
+	OAuthClientRegistered     = "oauth_client.registered"
+	OAuthClientRevoked        = "oauth_client.revoked"
+	PermissionCreated         = "permission.created"
+	ShareLinkCreated          = "share_link.created"
+	SharingMemberAdded        = "sharing.member_added"
+	SharingMemberRevoked      = "sharing.member_revoked"
+	InstanceExported          = "instance.exported"
+	InstanceImported          = "instance.imported"
+	InstanceRestored          = "instance.restored"
+)
+
+// DefaultRetention is how long the entries are kept when it is not
+// configured.
+const DefaultRetention = "1Y"
+
+// CleanWorker is the type of the worker that removes the old entries.
+const CleanWorker = "clean-audit-log"
+
+// DefaultListLimit is the default number of entries in a page of the audit
+// log, and MaxListLimit is the maximal number.
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+const maxEntriesDeletedPerBatch = 200
+
+// The kinds of actors.
+const (
+	ActorOwner     = "owner"
+	ActorApp       = "app"
+	ActorKonnector = "konnector"
+	ActorOAuth     = "oauth"
+	ActorCLI       = "cli"
+	ActorMember    = "member"
+	ActorAnonymous = "anonymous"
+	ActorAdmin     = "admin"
+	ActorSystem    = "system"
+)
+
+// Actor is who has made the operation.
+type Actor struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name,omitempty"`
+	Domain string `json:"domain,omitempty"`
+	// Client is the slug of the application or konnector, or the identifier
+	// of the OAuth client.
+	Client string `json:"client,omitempty"`
+}
+
+// Entry is an event of the audit log.
+type Entry struct {
+	DocID     string                 `json:"_id,omitempty"`
+	DocRev    string                 `json:"_rev,omitempty"`
+	Event     string                 `json:"event"`
+	Actor     Actor                  `json:"actor"`
+	IP        string                 `json:"ip,omitempty"`
+	UserAgent string                 `json:"user_agent,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	CreatedAt time.Time              `json:"created_at"`
+}
+
+// ID implements couchdb.Doc
+func (e *Entry) ID() string { return e.DocID }
+
+// Rev implements couchdb.Doc
+func (e *Entry) Rev() string { return e.DocRev }
+
+// DocType implements couchdb.Doc
+func (e *Entry) DocType() string { return consts.Audit }
+
+// Clone implements couchdb.Doc
+func (e *Entry) Clone() couchdb.Doc {
+	cloned := *e
+	if e.Details != nil {
+		cloned.Details = make(map[string]interface{}, len(e.Details))
+		for k, v := range e.Details {
+			cloned.Details[k] = v
+		}
+	}
+	return &cloned
+}
+
+// SetID implements couchdb.Doc
+func (e *Entry) SetID(id string) { e.DocID = id }
+
+// SetRev implements couchdb.Doc
+func (e *Entry) SetRev(rev string) { e.DocRev = rev }
+
+// Included is part of jsonapi.Object interface
+func (e *Entry) Included() []jsonapi.Object { return nil }
+
+// Relationships is part of jsonapi.Object interface
+func (e *Entry) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Links is part of jsonapi.Object interface
+func (e *Entry) Links() *jsonapi.LinksList { return nil }
+
+// Log saves the entry in the audit log of the instance. An error is only
+// logged, as it must not make the operation fail.
+func Log(inst *instance.Instance, entry *Entry) {
+	entry.DocID = ""
+	entry.DocRev = ""
+	entry.CreatedAt = time.Now().UTC()
+	log := inst.Logger().WithNamespace("loginaudit")
+	log.Infof("Audit: %s by %s %s from %s", entry.Event, entry.Actor.Kind, entry.Actor.Client, entry.IP)
+	if err := couchdb.CreateDoc(inst, entry); err != nil {
+		log.Errorf("Cannot save the audit entry %s: %s", entry.Event, err)
+		return
+	}
+	ensureCleanTrigger(inst)
+}
+
+// ListOptions are the options for listing the entries of the audit log.
+type ListOptions struct {
+	Event    string
+	Limit    int
+	Bookmark string
+}
+
+// List returns a page of the audit log, the most recent entries first, and
+// the bookmark for the next page (empty for the last page).
+func List(db prefixer.Prefixer, opts ListOptions) ([]*Entry, string, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	selector := mango.Exists("created_at")
+	if opts.Event != "" {
+		selector = mango.And(selector, mango.Equal("event", opts.Event))
+	}
+	req := &couchdb.FindRequest{
+		UseIndex: "by-created-at",
+		Selector: selector,
+		Sort:     mango.SortBy{{Field: "created_at", Direction: mango.Desc}},
+		Limit:    limit,
+		Bookmark: opts.Bookmark,
+	}
+	var entries []*Entry
+	res, err := couchdb.FindDocsRaw(db, consts.Audit, req, &entries)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	bookmark := ""
+	if len(entries) == limit {
+		bookmark = res.Bookmark
+	}
+	return entries, bookmark, nil
+}
+
+// Retention returns how long the entries are kept in the audit log.
+func Retention() time.Duration {
+	retention := config.GetConfig().Audit.Retention
+	if retention == "" {
+		retention = DefaultRetention
+	}
+	d, err := bigduration.ParseDuration(retention)
+	if err != nil || d <= 0 {
+		d, _ = bigduration.ParseDuration(DefaultRetention)
+	}
+	return d
+}
+
+// CleanOldEntries deletes the entries older than the retention, and returns
+// how many entries have been deleted.
+func CleanOldEntries(db prefixer.Prefixer, now time.Time) (int, error) {
+	before := now.Add(-Retention())
+	count := 0
+	for {
+		var entries []*Entry
+		req := &couchdb.FindRequest{
+			UseIndex: "by-created-at",
+			Selector: mango.Lt("created_at", before),
+			Sort:     mango.SortBy{{Field: "created_at", Direction: mango.Asc}},
+			Limit:    maxEntriesDeletedPerBatch,
+		}
+		if err := couchdb.FindDocs(db, consts.Audit, req, &entries); err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return count, nil
+			}
+			return count, err
+		}
+		if len(entries) == 0 {
+			return count, nil
+		}
+		docs := make([]couchdb.Doc, len(entries))
+		for i, e := range entries {
+			docs[i] = e
+		}
+		if err := couchdb.BulkDeleteDocs(db, consts.Audit, docs); err != nil {
+			return count, err
+		}
+		count += len(entries)
+		if len(entries) < maxEntriesDeletedPerBatch {
+			return count, nil
+		}
+	}
+}
+
+// ensureCleanTrigger creates the @daily trigger that removes the old entries,
+// if it doesn't exist yet.
+func ensureCleanTrigger(inst *instance.Instance) {
+	sched := job.System()
+	infos := job.TriggerInfos{
+		Type:       "@daily",
+		WorkerType: CleanWorker,
+		Arguments:  "between 3am and 6am",
+	}
+	if sched.HasTrigger(inst, infos) {
+		return
+	}
+	trigger, err := job.NewTrigger(inst, infos, nil)
+	if err != nil {
+		inst.Logger().WithNamespace("loginaudit").
+			Errorf("Cannot create the %s trigger: %s", CleanWorker, err)
+		return
+	}
+	if err := sched.AddTrigger(trigger); err != nil {
+		inst.Logger().WithNamespace("loginaudit").
+			Errorf("Cannot create the %s trigger: %s", CleanWorker, err)
+	}
+}
+
+var (
+	_ couchdb.Doc    = &Entry{}
+	_ jsonapi.Object = &Entry{}
+)
@@ -0,0 +1,119 @@
+package audit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetention(t *testing.T) {
+	config.UseTestFile(t)
+
+	config.GetConfig().Audit.Retention = ""
+	assert.Equal(t, 365*24*time.Hour, Retention())
+
+	config.GetConfig().Audit.Retention = "3M"
+	assert.Equal(t, 90*24*time.Hour, Retention())
+
+	config.GetConfig().Audit.Retention = "invalid"
+	assert.Equal(t, 365*24*time.Hour, Retention())
+}
+
+func TestEntryClone(t *testing.T) {
+	entry := &Entry{
+		Event:   PassphraseUpdated,
+		Actor:   Actor{Kind: ActorOwner},
+		Details: map[string]interface{}{"forced": true},
+	}
+	cloned := entry.Clone().(*Entry)
+	cloned.Details["forced"] = false
+	assert.Equal(t, true, entry.Details["forced"])
+	assert.Equal(t, ActorOwner, cloned.Actor.Kind)
+}
+
+func TestAuditLog(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	inst := setup.GetTestInstance()
+
+	now := time.Now().UTC()
+	create := func(event string, createdAt time.Time) {
+		entry := &Entry{
+			Event:     event,
+			Actor:     Actor{Kind: ActorOwner},
+			CreatedAt: createdAt,
+		}
+		require.NoError(t, couchdb.CreateDoc(inst, entry))
+	}
+
+	t.Run("ListWithoutEntries", func(t *testing.T) {
+		entries, bookmark, err := List(inst, ListOptions{})
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+		assert.Empty(t, bookmark)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		create(PassphraseUpdated, now.Add(-4*time.Hour))
+		create(ShareLinkCreated, now.Add(-3*time.Hour))
+		create(PassphraseUpdated, now.Add(-2*time.Hour))
+		create(ShareLinkCreated, now.Add(-1*time.Hour))
+
+		entries, bookmark, err := List(inst, ListOptions{Limit: 3})
+		require.NoError(t, err)
+		require.Len(t, entries, 3)
+		assert.NotEmpty(t, bookmark)
+		assert.Equal(t, ShareLinkCreated, entries[0].Event)
+		assert.True(t, entries[0].CreatedAt.After(entries[1].CreatedAt))
+		assert.True(t, entries[1].CreatedAt.After(entries[2].CreatedAt))
+
+		entries, bookmark, err = List(inst, ListOptions{Limit: 3, Bookmark: bookmark})
+		require.NoError(t, err)
+		require.Len(t, entries, 1)
+		assert.Empty(t, bookmark)
+		assert.Equal(t, PassphraseUpdated, entries[0].Event)
+		assert.WithinDuration(t, now.Add(-4*time.Hour), entries[0].CreatedAt, time.Second)
+
+		entries, bookmark, err = List(inst, ListOptions{Event: PassphraseUpdated})
+		require.NoError(t, err)
+		assert.Len(t, entries, 2)
+		assert.Empty(t, bookmark)
+		for _, e := range entries {
+			assert.Equal(t, PassphraseUpdated, e.Event)
+		}
+	})
+
+	t.Run("CleanOldEntries", func(t *testing.T) {
+		config.GetConfig().Audit.Retention = "1M"
+		t.Cleanup(func() { config.GetConfig().Audit.Retention = "" })
+
+		create(InstanceExported, now.Add(-60*24*time.Hour))
+		create(InstanceImported, now.Add(-45*24*time.Hour))
+
+		count, err := CleanOldEntries(inst, now)
+		require.NoError(t, err)
+		assert.Equal(t, 2, count)
+
+		entries, _, err := List(inst, ListOptions{Limit: MaxListLimit})
+		require.NoError(t, err)
+		assert.Len(t, entries, 4)
+		for _, e := range entries {
+			assert.NotEqual(t, InstanceExported, e.Event)
+			assert.NotEqual(t, InstanceImported, e.Event)
+		}
+
+		count, err = CleanOldEntries(inst, now)
+		require.NoError(t, err)
+		assert.Equal(t, 0, count)
+	})
+}
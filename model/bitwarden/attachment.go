@@ -0,0 +1,125 @@
+package bitwarden
+
+import (
+	"errors"
+	"path"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// AttachmentsDirName is the path of the hidden directory of the VFS where the
+// (encrypted) contents of the attachments of the ciphers are stored.
+const AttachmentsDirName = "/.cozy_bitwarden_attachments"
+
+// MaxAttachmentSize is the maximal size of an attachment.
+const MaxAttachmentSize = 500 * 1024 * 1024
+
+// ErrAttachmentNotUploaded is used when the content of an attachment is
+// requested before it has been uploaded.
+var ErrAttachmentNotUploaded = errors.New("Attachment has not been uploaded")
+
+// Attachment is a file attached to a cipher. Its name and key are encrypted,
+// and so is the content stored in the VFS.
+type Attachment struct {
+	ID       string `json:"id"`
+	FileName string `json:"file_name"`
+	Key      string `json:"key,omitempty"`
+	Size     int64  `json:"size"`
+	VFSID    string `json:"vfs_id,omitempty"`
+}
+
+// IsUploaded returns true if the content of the attachment has been uploaded
+// to the VFS.
+func (a *Attachment) IsUploaded() bool {
+	return a.VFSID != ""
+}
+
+// AddAttachment adds the description of a new attachment to the cipher. Its
+// content can then be uploaded with CreateAttachmentFile.
+func (c *Cipher) AddAttachment(fileName, key string, size int64) *Attachment {
+	c.Attachments = append(c.Attachments, Attachment{
+		ID:       crypto.GenerateRandomString(20),
+		FileName: fileName,
+		Key:      key,
+		Size:     size,
+	})
+	return &c.Attachments[len(c.Attachments)-1]
+}
+
+// FindAttachment returns the attachment of the cipher with the given
+// identifier, or nil if there is no such attachment.
+func (c *Cipher) FindAttachment(id string) *Attachment {
+	for i := range c.Attachments {
+		if c.Attachments[i].ID == id {
+			return &c.Attachments[i]
+		}
+	}
+	return nil
+}
+
+// RemoveAttachment removes the attachment with the given identifier from the
+// cipher, and returns it. The content in the VFS is not destroyed.
+func (c *Cipher) RemoveAttachment(id string) *Attachment {
+	for i := range c.Attachments {
+		if c.Attachments[i].ID == id {
+			removed := c.Attachments[i]
+			c.Attachments = append(c.Attachments[:i], c.Attachments[i+1:]...)
+			return &removed
+		}
+	}
+	return nil
+}
+
+// AttachmentFilePath returns the path in the VFS of the file where the
+// content of the attachment is stored.
+func AttachmentFilePath(c *Cipher, a *Attachment) string {
+	return path.Join(AttachmentsDirName, attachmentFileName(c, a))
+}
+
+func attachmentFileName(c *Cipher, a *Attachment) string {
+	return c.CouchID + "-" + a.ID
+}
+
+// CreateAttachmentFile returns a file handle for writing the (encrypted)
+// content of an attachment in the VFS, and the file document, whose identifier
+// must be kept in the attachment after the file has been closed. The previous
+// content, if any, is replaced.
+func CreateAttachmentFile(inst *instance.Instance, c *Cipher, a *Attachment) (vfs.File, *vfs.FileDoc, error) {
+	return createVaultFile(inst, AttachmentsDirName, attachmentFileName(c, a), a.Size, a.VFSID)
+}
+
+// OpenAttachmentFile returns the file document where the content of an
+// attachment is stored.
+func OpenAttachmentFile(inst *instance.Instance, a *Attachment) (*vfs.FileDoc, error) {
+	if !a.IsUploaded() {
+		return nil, ErrAttachmentNotUploaded
+	}
+	return inst.VFS().FileByID(a.VFSID)
+}
+
+// DeleteAttachmentFile destroys the content of an attachment in the VFS.
+func DeleteAttachmentFile(inst *instance.Instance, a *Attachment) error {
+	return destroyVaultFile(inst, a.VFSID)
+}
+
+// DeleteCiphersAttachments destroys the contents of the attachments of the
+// given ciphers. It must be called when the ciphers are deleted (not when
+// they are moved to the trash). The errors are only logged, as the ciphers
+// have already been deleted.
+func DeleteCiphersAttachments(inst *instance.Instance, ciphers ...*Cipher) {
+	var errm error
+	for _, c := range ciphers {
+		for i := range c.Attachments {
+			if err := DeleteAttachmentFile(inst, &c.Attachments[i]); err != nil {
+				errm = multierror.Append(errm, err)
+			}
+		}
+	}
+	if errm != nil {
+		inst.Logger().WithNamespace("bitwarden").
+			Warnf("Cannot delete the attachments: %s", errm)
+	}
+}
@@ -0,0 +1,38 @@
+package bitwarden
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCipherAttachments(t *testing.T) {
+	c := &Cipher{CouchID: "4c2869dd0e1c499fb116a824016df251"}
+	first := c.AddAttachment("2.foo", "2.key", 42)
+	assert.Len(t, first.ID, 20)
+	assert.False(t, first.IsUploaded())
+	second := c.AddAttachment("2.bar", "2.key", 12)
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Len(t, c.Attachments, 2)
+
+	found := c.FindAttachment(second.ID)
+	require.NotNil(t, found)
+	assert.Equal(t, "2.bar", found.FileName)
+	assert.Nil(t, c.FindAttachment("unknown"))
+
+	assert.Equal(t, AttachmentsDirName+"/"+c.CouchID+"-"+found.ID,
+		AttachmentFilePath(c, found))
+
+	cloned := c.Clone().(*Cipher)
+	cloned.Attachments[0].FileName = "2.changed"
+	assert.Equal(t, "2.foo", c.Attachments[0].FileName)
+
+	id := c.Attachments[0].ID
+	removed := c.RemoveAttachment(id)
+	require.NotNil(t, removed)
+	assert.Equal(t, "2.foo", removed.FileName)
+	assert.Len(t, c.Attachments, 1)
+	assert.Nil(t, c.FindAttachment(id))
+	assert.Nil(t, c.RemoveAttachment(id))
+}
@@ -83,6 +83,7 @@ type Cipher struct {
 	Login          *LoginData             `json:"login,omitempty"`
 	Data           *MapData               `json:"data,omitempty"`
 	Fields         []Field                `json:"fields"`
+	Attachments    []Attachment           `json:"attachments,omitempty"`
 	Metadata       *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
 	DeletedDate    *time.Time             `json:"deletedDate,omitempty"`
 }
@@ -112,6 +113,10 @@ func (c *Cipher) Clone() couchdb.Doc {
 	}
 	cloned.Fields = make([]Field, len(c.Fields))
 	copy(cloned.Fields, c.Fields)
+	if c.Attachments != nil {
+		cloned.Attachments = make([]Attachment, len(c.Attachments))
+		copy(cloned.Attachments, c.Attachments)
+	}
 	if c.Metadata != nil {
 		cloned.Metadata = c.Metadata.Clone()
 	}
@@ -166,7 +171,7 @@ func FindCiphersInFolder(inst *instance.Instance, folderID string) ([]*Cipher, e
 // with the cozy organization. It should be called when the master password is
 // lost, as there are no ways to recover those encrypted ciphers.
 func DeleteUnrecoverableCiphers(inst *instance.Instance) error {
-	var ciphers []couchdb.Doc
+	var ciphers []*Cipher
 	err := couchdb.ForeachDocs(inst, consts.BitwardenCiphers, func(_ string, data json.RawMessage) error {
 		var c Cipher
 		if err := json.Unmarshal(data, &c); err != nil {
@@ -183,7 +188,15 @@ func DeleteUnrecoverableCiphers(inst *instance.Instance) error {
 		}
 		return err
 	}
-	return couchdb.BulkDeleteDocs(inst, consts.BitwardenCiphers, ciphers)
+	docs := make([]couchdb.Doc, len(ciphers))
+	for i := range ciphers {
+		docs[i] = ciphers[i]
+	}
+	if err := couchdb.BulkDeleteDocs(inst, consts.BitwardenCiphers, docs); err != nil {
+		return err
+	}
+	DeleteCiphersAttachments(inst, ciphers...)
+	return nil
 }
 
 var _ couchdb.Doc = &Cipher{}
@@ -0,0 +1,712 @@
+package bitwarden
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/cozy/cozy-stack/client/request"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/labstack/echo/v4"
+)
+
+// EmergencyAccessWorker is the type of the worker that approves the recovery
+// requests of the emergency accesses, either when the grantor approves it, or
+// when the waiting period has expired.
+const EmergencyAccessWorker = "bitwarden-emergency-access"
+
+// MaxEmergencyAccessWaitTimeDays is the maximal number of days that a grantee
+// must wait before accessing the vault of the grantor.
+const MaxEmergencyAccessWaitTimeDays = 90
+
+// EmergencyAccessType is used to know what the grantee can do with the vault
+// of the grantor once the recovery has been approved.
+type EmergencyAccessType int
+
+// EmergencyAccessView and EmergencyAccessTakeover are the 2 possible types of
+// emergency accesses.
+// See https://github.com/bitwarden/clients/blob/main/apps/web/src/app/auth/emergency-access/enums/emergency-access-type.ts
+const (
+	// EmergencyAccessView allows the grantee to read the ciphers.
+	EmergencyAccessView EmergencyAccessType = 0
+	// EmergencyAccessTakeover allows the grantee to change the master
+	// password of the grantor.
+	EmergencyAccessTakeover EmergencyAccessType = 1
+)
+
+// EmergencyAccessStatus is the status of an emergency access.
+type EmergencyAccessStatus int
+
+const (
+	// EmergencyAccessInvited is used when the grantee has been invited, but
+	// has not yet accepted.
+	EmergencyAccessInvited EmergencyAccessStatus = 0
+	// EmergencyAccessAccepted is used when the grantee has accepted, but the
+	// grantor has not yet confirmed that the fingerprint is OK.
+	EmergencyAccessAccepted EmergencyAccessStatus = 1
+	// EmergencyAccessConfirmed is used when the grantor has given the key,
+	// encrypted with the public key of the grantee.
+	EmergencyAccessConfirmed EmergencyAccessStatus = 2
+	// EmergencyAccessRecoveryInitiated is used when the grantee has asked to
+	// access the vault, and the waiting period is running.
+	EmergencyAccessRecoveryInitiated EmergencyAccessStatus = 3
+	// EmergencyAccessRecoveryApproved is used when the grantee can access
+	// the vault.
+	EmergencyAccessRecoveryApproved EmergencyAccessStatus = 4
+)
+
+// The actions for the messages of the emergency access worker.
+const (
+	EmergencyAccessApproveAction = "approve"
+	EmergencyAccessTimeoutAction = "timeout"
+)
+
+var (
+	// ErrEmergencyAccessInvalidStatus is used when an action is not possible
+	// with the current status of the emergency access.
+	ErrEmergencyAccessInvalidStatus = errors.New("Emergency access not valid")
+	// ErrEmergencyAccessInvalidType is used when the grantee tries to view
+	// the vault with a takeover access, or the opposite.
+	ErrEmergencyAccessInvalidType = errors.New("Emergency access type not valid")
+	// ErrEmergencyAccessTakeoverNotAllowed is used when the grantee tries a
+	// takeover, but the grantor has not allowed it to replace their Cozy
+	// passphrase.
+	ErrEmergencyAccessTakeoverNotAllowed = errors.New("Takeover not allowed by the grantor")
+	// ErrEmergencyAccessInvalidWaitTime is used when the waiting period is
+	// out of bounds.
+	ErrEmergencyAccessInvalidWaitTime = errors.New("Invalid wait time days")
+	// ErrEmergencyAccessInvalidToken is used when the other instance doesn't
+	// give the right token.
+	ErrEmergencyAccessInvalidToken = errors.New("Invalid emergency access token")
+	// ErrEmergencyAccessRequestFailed is used when the other instance cannot
+	// be reached.
+	ErrEmergencyAccessRequestFailed = errors.New("Cannot reach the other Cozy")
+)
+
+// EmergencyAccessUser is the grantor or the grantee of an emergency access.
+type EmergencyAccessUser struct {
+	UserID    string `json:"user_id,omitempty"`
+	Email     string `json:"email"`
+	Name      string `json:"name,omitempty"`
+	Instance  string `json:"instance"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// EmergencyAccess is used by a user (the grantor) to give to a trusted contact
+// (the grantee) an access to their vault, after a waiting period. The
+// document exists on the Cozy of the grantor and on the Cozy of the grantee,
+// with the same identifier, and the two Cozy instances send each other the
+// changes, authenticated by a token only known by them.
+type EmergencyAccess struct {
+	CouchID             string                 `json:"_id,omitempty"`
+	CouchRev            string                 `json:"_rev,omitempty"`
+	Type                EmergencyAccessType    `json:"type"`
+	Status              EmergencyAccessStatus  `json:"status"`
+	WaitTimeDays        int                    `json:"wait_time_days"`
+	Grantor             EmergencyAccessUser    `json:"grantor"`
+	Grantee             EmergencyAccessUser    `json:"grantee"`
+	KeyEncrypted        string                 `json:"key_encrypted,omitempty"`
+	Kdf                 int                    `json:"kdf"`
+	KdfIterations       int                    `json:"kdf_iterations,omitempty"`
+	FullTakeover        bool                   `json:"full_takeover,omitempty"`
+	RecoveryInitiatedAt *time.Time             `json:"recovery_initiated_at,omitempty"`
+	Token               string                 `json:"token"`
+	TriggerID           string                 `json:"trigger_id,omitempty"`
+	Metadata            *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
+}
+
+// ID returns the emergency access qualified identifier
+func (e *EmergencyAccess) ID() string { return e.CouchID }
+
+// Rev returns the emergency access revision
+func (e *EmergencyAccess) Rev() string { return e.CouchRev }
+
+// DocType returns the emergency access document type
+func (e *EmergencyAccess) DocType() string { return consts.BitwardenEmergencyAccess }
+
+// Clone implements couchdb.Doc
+func (e *EmergencyAccess) Clone() couchdb.Doc {
+	cloned := *e
+	if e.RecoveryInitiatedAt != nil {
+		at := *e.RecoveryInitiatedAt
+		cloned.RecoveryInitiatedAt = &at
+	}
+	if e.Metadata != nil {
+		cloned.Metadata = e.Metadata.Clone()
+	}
+	return &cloned
+}
+
+// SetID changes the emergency access qualified identifier
+func (e *EmergencyAccess) SetID(id string) { e.CouchID = id }
+
+// SetRev changes the emergency access revision
+func (e *EmergencyAccess) SetRev(rev string) { e.CouchRev = rev }
+
+// EmergencyAccessMessage is the message for the emergency access worker.
+type EmergencyAccessMessage struct {
+	EmergencyAccessID string `json:"emergency_access_id"`
+	Action            string `json:"action"`
+}
+
+// NewEmergencyAccess returns an emergency access where the instance is the
+// grantor, and the grantee is invited.
+func NewEmergencyAccess(inst *instance.Instance, grantee EmergencyAccessUser, typ EmergencyAccessType, waitTimeDays int) (*EmergencyAccess, error) {
+	if waitTimeDays < 1 || waitTimeDays > MaxEmergencyAccessWaitTimeDays {
+		return nil, ErrEmergencyAccessInvalidWaitTime
+	}
+	if typ != EmergencyAccessView && typ != EmergencyAccessTakeover {
+		return nil, ErrEmergencyAccessInvalidType
+	}
+	doc, err := inst.SettingsDocument()
+	if err != nil {
+		return nil, err
+	}
+	email, _ := doc.M["email"].(string)
+	name, _ := doc.M["public_name"].(string)
+	md := metadata.New()
+	md.DocTypeVersion = DocTypeVersion
+	return &EmergencyAccess{
+		Type:         typ,
+		Status:       EmergencyAccessInvited,
+		WaitTimeDays: waitTimeDays,
+		Grantor: EmergencyAccessUser{
+			UserID:   inst.ID(),
+			Email:    email,
+			Name:     name,
+			Instance: inst.PageURL("", nil),
+		},
+		Grantee:  grantee,
+		Token:    crypto.GenerateRandomString(32),
+		Metadata: md,
+	}, nil
+}
+
+// IsGrantor returns true if the instance is the grantor of the emergency
+// access.
+func (e *EmergencyAccess) IsGrantor(inst *instance.Instance) bool {
+	return isSameInstance(inst, e.Grantor.Instance)
+}
+
+// IsGrantee returns true if the instance is the grantee of the emergency
+// access.
+func (e *EmergencyAccess) IsGrantee(inst *instance.Instance) bool {
+	return isSameInstance(inst, e.Grantee.Instance)
+}
+
+func isSameInstance(inst *instance.Instance, instanceURL string) bool {
+	u, err := url.Parse(instanceURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	if u.Host == inst.Domain || u.Host == inst.ContextualDomain() {
+		return true
+	}
+	for _, alias := range inst.DomainAliases {
+		if u.Host == alias {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckToken returns true if the given token is the one shared by the two
+// instances for this emergency access.
+func (e *EmergencyAccess) CheckToken(token string) bool {
+	return e.Token != "" &&
+		subtle.ConstantTimeCompare([]byte(e.Token), []byte(token)) == 1
+}
+
+// RecoveryEndsAt returns when the waiting period ends, and the recovery is
+// automatically approved.
+func (e *EmergencyAccess) RecoveryEndsAt() time.Time {
+	if e.RecoveryInitiatedAt == nil {
+		return time.Time{}
+	}
+	return e.RecoveryInitiatedAt.Add(time.Duration(e.WaitTimeDays) * 24 * time.Hour)
+}
+
+// Accept is called on the Cozy of the grantee when they accept the
+// invitation.
+func (e *EmergencyAccess) Accept(inst *instance.Instance, setting *settings.Settings) error {
+	if e.Status != EmergencyAccessInvited {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	e.Status = EmergencyAccessAccepted
+	e.Grantee.UserID = inst.ID()
+	e.Grantee.PublicKey = setting.PublicKey
+	if doc, err := inst.SettingsDocument(); err == nil {
+		e.Grantee.Name, _ = doc.M["public_name"].(string)
+	}
+	return nil
+}
+
+// Confirm is called on the Cozy of the grantor to give the key of their
+// vault, encrypted with the public key of the grantee.
+func (e *EmergencyAccess) Confirm(key string, setting *settings.Settings) error {
+	if e.Status != EmergencyAccessAccepted {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	e.Status = EmergencyAccessConfirmed
+	e.KeyEncrypted = key
+	e.Kdf = setting.PassphraseKdf
+	e.KdfIterations = setting.PassphraseKdfIterations
+	return nil
+}
+
+// Initiate is called on the Cozy of the grantee when they ask to access the
+// vault of the grantor.
+func (e *EmergencyAccess) Initiate(now time.Time) error {
+	if e.Status != EmergencyAccessConfirmed {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	e.Status = EmergencyAccessRecoveryInitiated
+	e.RecoveryInitiatedAt = &now
+	return nil
+}
+
+// Approve gives to the grantee the access to the vault of the grantor.
+func (e *EmergencyAccess) Approve() error {
+	if e.Status != EmergencyAccessRecoveryInitiated {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	e.Status = EmergencyAccessRecoveryApproved
+	return nil
+}
+
+// Reject is called on the Cozy of the grantor to refuse or revoke the access
+// of the grantee to the vault.
+func (e *EmergencyAccess) Reject() error {
+	if e.Status != EmergencyAccessRecoveryInitiated && e.Status != EmergencyAccessRecoveryApproved {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	e.Status = EmergencyAccessConfirmed
+	e.RecoveryInitiatedAt = nil
+	return nil
+}
+
+// Merge applies the changes sent by the other instance. Only the changes that
+// the other side is allowed to make are taken.
+func (e *EmergencyAccess) Merge(inst *instance.Instance, other *EmergencyAccess) error {
+	if e.IsGrantor(inst) {
+		// The grantee can only accept the invitation and initiate a recovery
+		switch {
+		case e.Status == EmergencyAccessInvited && other.Status == EmergencyAccessAccepted:
+			e.Status = EmergencyAccessAccepted
+			e.Grantee.UserID = other.Grantee.UserID
+			e.Grantee.Name = other.Grantee.Name
+			e.Grantee.PublicKey = other.Grantee.PublicKey
+		case e.Status == EmergencyAccessConfirmed && other.Status == EmergencyAccessRecoveryInitiated:
+			return e.Initiate(time.Now())
+		case e.Status == other.Status:
+		default:
+			return ErrEmergencyAccessInvalidStatus
+		}
+		return nil
+	}
+
+	if !e.IsGrantee(inst) {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	// The grantor is the reference for everything else, except the fields
+	// that identify the document and the two instances.
+	kept := *e
+	*e = *other
+	e.CouchID, e.CouchRev = kept.CouchID, kept.CouchRev
+	e.Grantor.Instance = kept.Grantor.Instance
+	e.Grantee.Instance = kept.Grantee.Instance
+	if e.Grantee.UserID == "" {
+		e.Grantee.UserID = kept.Grantee.UserID
+		e.Grantee.PublicKey = kept.Grantee.PublicKey
+	}
+	e.Token, e.TriggerID, e.Metadata = kept.Token, kept.TriggerID, kept.Metadata
+	return nil
+}
+
+// FindAllEmergencyAccesses returns all the emergency accesses of the
+// instance, as grantor or as grantee.
+func FindAllEmergencyAccesses(inst *instance.Instance) ([]*EmergencyAccess, error) {
+	var accesses []*EmergencyAccess
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenEmergencyAccess, req, &accesses); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return accesses, nil
+}
+
+// SaveEmergencyAccess persists the emergency access in CouchDB.
+func SaveEmergencyAccess(inst *instance.Instance, e *EmergencyAccess) error {
+	if e.Metadata != nil {
+		e.Metadata.ChangeUpdatedAt()
+	}
+	if e.CouchRev != "" {
+		return couchdb.UpdateDoc(inst, e)
+	}
+	if e.CouchID != "" {
+		// An invitation received from the Cozy of the grantor
+		return couchdb.CreateNamedDocWithDB(inst, e)
+	}
+	err := couchdb.CreateDoc(inst, e)
+	if couchdb.IsNoDatabaseError(err) {
+		if err = couchdb.CreateDB(inst, consts.BitwardenEmergencyAccess); err == nil {
+			err = couchdb.CreateDoc(inst, e)
+		}
+	}
+	return err
+}
+
+// DeleteEmergencyAccess removes the emergency access from the instance, and
+// tells the other instance to do the same.
+func DeleteEmergencyAccess(inst *instance.Instance, e *EmergencyAccess) error {
+	UnscheduleEmergencyAccessTimeout(inst, e)
+	if err := couchdb.DeleteDoc(inst, e); err != nil {
+		return err
+	}
+	if err := sendToOtherInstance(inst, e, http.MethodDelete, "", nil); err != nil {
+		inst.Logger().WithNamespace("bitwarden").
+			Warnf("Cannot delete the emergency access on the other Cozy: %s", err)
+	}
+	return nil
+}
+
+// PushEmergencyAccess sends the emergency access to the other instance, to
+// keep the two documents in sync.
+func PushEmergencyAccess(inst *instance.Instance, e *EmergencyAccess) error {
+	payload := *e
+	payload.CouchRev = ""
+	payload.TriggerID = ""
+	payload.Metadata = nil
+	body, err := json.Marshal(&payload)
+	if err != nil {
+		return err
+	}
+	return sendToOtherInstance(inst, e, http.MethodPut, "", body)
+}
+
+// ReceiveEmergencyAccess is called when the other instance sends the
+// emergency access. It can be a new invitation, or an update for an existing
+// one.
+func ReceiveEmergencyAccess(inst *instance.Instance, token string, other *EmergencyAccess) error {
+	e := &EmergencyAccess{}
+	err := couchdb.GetDoc(inst, consts.BitwardenEmergencyAccess, other.CouchID, e)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		if other.Status != EmergencyAccessInvited || !other.IsGrantee(inst) || !other.CheckToken(token) {
+			return ErrEmergencyAccessInvalidStatus
+		}
+		e = other
+		e.CouchRev = ""
+		e.TriggerID = ""
+		md := metadata.New()
+		md.DocTypeVersion = DocTypeVersion
+		e.Metadata = md
+		return SaveEmergencyAccess(inst, e)
+	}
+	if err != nil {
+		return err
+	}
+	if !e.CheckToken(token) {
+		return ErrEmergencyAccessInvalidToken
+	}
+
+	before := e.Status
+	if err := e.Merge(inst, other); err != nil {
+		return err
+	}
+	if err := SaveEmergencyAccess(inst, e); err != nil {
+		return err
+	}
+	if before == e.Status {
+		return nil
+	}
+
+	switch {
+	case e.IsGrantor(inst) && e.Status == EmergencyAccessAccepted:
+		return saveGranteeContact(inst, &e.Grantee)
+	case e.IsGrantor(inst) && e.Status == EmergencyAccessRecoveryInitiated:
+		if err := scheduleEmergencyAccessTimeout(inst, e); err != nil {
+			return err
+		}
+		notifyEmergencyAccess(inst, e, []string{"mobile"}, "Emergency Access Initiated Message",
+			e.Grantee.Name, e.RecoveryEndsAt().Format("2006-01-02"))
+	case e.IsGrantee(inst) && e.Status == EmergencyAccessRecoveryApproved:
+		notifyEmergencyAccess(inst, e, []string{"mobile"}, "Emergency Access Approved Message", e.Grantor.Name)
+	}
+	return nil
+}
+
+// saveGranteeContact keeps the public key of the grantee in a contact, as
+// the clients ask it to the stack for encrypting the key of the vault.
+func saveGranteeContact(inst *instance.Instance, grantee *EmergencyAccessUser) error {
+	if grantee.UserID == "" || grantee.PublicKey == "" {
+		return nil
+	}
+	contact := &Contact{}
+	err := couchdb.GetDoc(inst, consts.BitwardenContacts, grantee.UserID, contact)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		md := metadata.New()
+		md.DocTypeVersion = DocTypeVersion
+		contact.UserID = grantee.UserID
+		contact.Email = grantee.Email
+		contact.PublicKey = grantee.PublicKey
+		contact.Metadata = *md
+		return couchdb.CreateNamedDocWithDB(inst, contact)
+	}
+	if err != nil || contact.PublicKey == grantee.PublicKey {
+		return err
+	}
+	contact.PublicKey = grantee.PublicKey
+	contact.Confirmed = false
+	contact.Metadata.UpdatedAt = time.Now()
+	return couchdb.UpdateDoc(inst, contact)
+}
+
+// ReceiveEmergencyAccessDeletion is called when the other instance has
+// deleted the emergency access.
+func ReceiveEmergencyAccessDeletion(inst *instance.Instance, token, id string) error {
+	e := &EmergencyAccess{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenEmergencyAccess, id, e); err != nil {
+		return err
+	}
+	if !e.CheckToken(token) {
+		return ErrEmergencyAccessInvalidToken
+	}
+	UnscheduleEmergencyAccessTimeout(inst, e)
+	return couchdb.DeleteDoc(inst, e)
+}
+
+// PushEmergencyAccessJob pushes a job for the emergency access worker.
+func PushEmergencyAccessJob(inst *instance.Instance, e *EmergencyAccess, action string) error {
+	msg, err := job.NewMessage(&EmergencyAccessMessage{
+		EmergencyAccessID: e.CouchID,
+		Action:            action,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: EmergencyAccessWorker,
+		Message:    msg,
+	})
+	return err
+}
+
+// RunEmergencyAccessJob is called by the emergency access worker on the Cozy
+// of the grantor to approve a recovery: either the grantor has approved it,
+// or the waiting period has expired without a rejection.
+func RunEmergencyAccessJob(inst *instance.Instance, msg *EmergencyAccessMessage) error {
+	e := &EmergencyAccess{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenEmergencyAccess, msg.EmergencyAccessID, e); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		return err
+	}
+	if !e.IsGrantor(inst) || e.Status != EmergencyAccessRecoveryInitiated {
+		return nil
+	}
+
+	switch msg.Action {
+	case EmergencyAccessApproveAction:
+		UnscheduleEmergencyAccessTimeout(inst, e)
+	case EmergencyAccessTimeoutAction:
+		// The recovery may have been rejected and initiated again since this
+		// trigger was created
+		if time.Now().Before(e.RecoveryEndsAt()) {
+			return nil
+		}
+		e.TriggerID = ""
+	default:
+		return nil
+	}
+
+	if err := e.Approve(); err != nil {
+		return err
+	}
+	if err := SaveEmergencyAccess(inst, e); err != nil {
+		return err
+	}
+	return PushEmergencyAccess(inst, e)
+}
+
+// scheduleEmergencyAccessTimeout adds an @at trigger for approving the
+// recovery at the end of the waiting period.
+func scheduleEmergencyAccessTimeout(inst *instance.Instance, e *EmergencyAccess) error {
+	UnscheduleEmergencyAccessTimeout(inst, e)
+	msg := &EmergencyAccessMessage{
+		EmergencyAccessID: e.CouchID,
+		Action:            EmergencyAccessTimeoutAction,
+	}
+	t, err := job.NewTrigger(inst, job.TriggerInfos{
+		Type:       "@at",
+		WorkerType: EmergencyAccessWorker,
+		Arguments:  e.RecoveryEndsAt().UTC().Format(time.RFC3339),
+	}, msg)
+	if err != nil {
+		return err
+	}
+	if err := job.System().AddTrigger(t); err != nil {
+		return err
+	}
+	e.TriggerID = t.ID()
+	return SaveEmergencyAccess(inst, e)
+}
+
+// UnscheduleEmergencyAccessTimeout removes the @at trigger of the waiting
+// period, if any. The document is not saved.
+func UnscheduleEmergencyAccessTimeout(inst *instance.Instance, e *EmergencyAccess) {
+	if e.TriggerID == "" {
+		return
+	}
+	err := job.System().DeleteTrigger(inst, e.TriggerID)
+	if err != nil && !errors.Is(err, job.ErrNotFoundTrigger) {
+		inst.Logger().WithNamespace("bitwarden").
+			Warnf("Cannot delete the trigger %s: %s", e.TriggerID, err)
+	}
+	e.TriggerID = ""
+}
+
+// FetchEmergencyAccessCiphers is called on the Cozy of the grantee to get
+// the ciphers of the grantor, once the recovery has been approved. The
+// ciphers are returned as sent by the Cozy of the grantor, in the format of
+// the Bitwarden API.
+func FetchEmergencyAccessCiphers(inst *instance.Instance, e *EmergencyAccess) (json.RawMessage, error) {
+	if !e.IsGrantee(inst) || e.Status != EmergencyAccessRecoveryApproved {
+		return nil, ErrEmergencyAccessInvalidStatus
+	}
+	if e.Type != EmergencyAccessView {
+		return nil, ErrEmergencyAccessInvalidType
+	}
+	var ciphers json.RawMessage
+	err := sendToOtherInstance(inst, e, http.MethodPost, "/view", nil, &ciphers)
+	return ciphers, err
+}
+
+// EmergencyAccessPassword is the new master password of the grantor, set by
+// the grantee with a takeover access.
+type EmergencyAccessPassword struct {
+	NewMasterPasswordHash string `json:"newMasterPasswordHash"`
+	Key                   string `json:"key"`
+}
+
+// CheckTakeover returns an error if the grantee cannot choose a new
+// passphrase for the grantor. The master password of the vault is also the
+// passphrase of the Cozy, so a takeover gives an access to the whole Cozy of
+// the grantor: it must have been explicitly allowed by the grantor, in
+// addition to the takeover type.
+func (e *EmergencyAccess) CheckTakeover() error {
+	if e.Type != EmergencyAccessTakeover {
+		return ErrEmergencyAccessInvalidType
+	}
+	if !e.FullTakeover {
+		return ErrEmergencyAccessTakeoverNotAllowed
+	}
+	return nil
+}
+
+// TakeoverEmergencyAccess is called on the Cozy of the grantee to change the
+// master password of the grantor, once the recovery has been approved.
+func TakeoverEmergencyAccess(inst *instance.Instance, e *EmergencyAccess, password *EmergencyAccessPassword) error {
+	if !e.IsGrantee(inst) || e.Status != EmergencyAccessRecoveryApproved {
+		return ErrEmergencyAccessInvalidStatus
+	}
+	if err := e.CheckTakeover(); err != nil {
+		return err
+	}
+	body, err := json.Marshal(password)
+	if err != nil {
+		return err
+	}
+	return sendToOtherInstance(inst, e, http.MethodPost, "/password", body)
+}
+
+// sendToOtherInstance makes a request to the stack of the other side of the
+// emergency access. The response is decoded in out, if given.
+func sendToOtherInstance(inst *instance.Instance, e *EmergencyAccess, method, suffix string, body []byte, out ...interface{}) error {
+	other := e.Grantee.Instance
+	if e.IsGrantee(inst) {
+		other = e.Grantor.Instance
+	}
+	u, err := url.Parse(other)
+	if err != nil || u.Host == "" {
+		return ErrEmergencyAccessRequestFailed
+	}
+	opts := &request.Options{
+		Method: method,
+		Scheme: u.Scheme,
+		Domain: u.Host,
+		Path:   "/bitwarden/emergency-access/" + e.CouchID + suffix,
+		Headers: request.Headers{
+			echo.HeaderAccept:        echo.MIMEApplicationJSON,
+			echo.HeaderAuthorization: "Bearer " + e.Token,
+		},
+	}
+	if body != nil {
+		opts.Headers[echo.HeaderContentType] = echo.MIMEApplicationJSON
+		opts.Body = bytes.NewReader(body)
+	}
+	res, err := request.Req(opts)
+	if err != nil {
+		inst.Logger().WithNamespace("bitwarden").
+			Infof("Emergency access request to %s failed: %s", u.Host, err)
+		return ErrEmergencyAccessRequestFailed
+	}
+	defer res.Body.Close()
+	if len(out) == 0 {
+		_, _ = io.Copy(io.Discard, res.Body)
+		return nil
+	}
+	return json.NewDecoder(res.Body).Decode(out[0])
+}
+
+// NotifyEmergencyAccessTakeover is called on the Cozy of the grantor when the
+// grantee has changed their passphrase. The notification is sent by mail, as
+// the grantor has been logged out of their devices.
+func NotifyEmergencyAccessTakeover(inst *instance.Instance, e *EmergencyAccess) {
+	name := e.Grantee.Name
+	if name == "" {
+		name = e.Grantee.Email
+	}
+	notifyEmergencyAccess(inst, e, []string{"mail"}, "Emergency Access Takeover Message", name)
+}
+
+// notifyEmergencyAccess pushes a notification about a change of the recovery
+// status of an emergency access.
+func notifyEmergencyAccess(inst *instance.Instance, e *EmergencyAccess, channels []string, key string, args ...interface{}) {
+	title := inst.Translate("Emergency Access Title")
+	message := inst.Translate(key, args...)
+	n := &notification.Notification{
+		Title:       title,
+		Message:     message,
+		Content:     message,
+		ContentHTML: "<p>" + html.EscapeString(message) + "</p>",
+		Slug:        consts.PassSlug,
+		Data: map[string]interface{}{
+			"emergency_access_id": e.CouchID,
+			"status":              e.Status,
+		},
+		PreferredChannels: channels,
+	}
+	if err := center.PushStack(inst.DomainName(), center.NotificationBitwardenEmergencyAccess, n); err != nil {
+		inst.Logger().WithNamespace("bitwarden").
+			Warnf("Cannot notify for the emergency access: %s", err)
+	}
+}
+
+var _ couchdb.Doc = &EmergencyAccess{}
@@ -0,0 +1,142 @@
+package bitwarden
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmergencyAccessTransitions(t *testing.T) {
+	grantor := &instance.Instance{Domain: "alice.cozy.example"}
+	grantee := &instance.Instance{Domain: "bob.cozy.example"}
+	e := &EmergencyAccess{
+		CouchID:      "2a1f5d40e3c1013b2c8d543d7eb8149c",
+		Type:         EmergencyAccessView,
+		Status:       EmergencyAccessInvited,
+		WaitTimeDays: 7,
+		Grantor:      EmergencyAccessUser{Instance: "https://alice.cozy.example/"},
+		Grantee:      EmergencyAccessUser{Instance: "https://bob.cozy.example/"},
+		Token:        "a-secret-token",
+	}
+	assert.True(t, e.IsGrantor(grantor))
+	assert.False(t, e.IsGrantee(grantor))
+	assert.True(t, e.IsGrantee(grantee))
+	assert.True(t, e.CheckToken("a-secret-token"))
+	assert.False(t, e.CheckToken("another-token"))
+
+	assert.ErrorIs(t, e.Initiate(time.Now()), ErrEmergencyAccessInvalidStatus)
+	assert.ErrorIs(t, e.Confirm("4.key", &settings.Settings{}), ErrEmergencyAccessInvalidStatus)
+
+	e.Status = EmergencyAccessAccepted
+	setting := &settings.Settings{PassphraseKdfIterations: 650000}
+	require.NoError(t, e.Confirm("4.key", setting))
+	assert.Equal(t, EmergencyAccessConfirmed, e.Status)
+	assert.Equal(t, "4.key", e.KeyEncrypted)
+	assert.Equal(t, 650000, e.KdfIterations)
+
+	assert.ErrorIs(t, e.Approve(), ErrEmergencyAccessInvalidStatus)
+	now := time.Now()
+	require.NoError(t, e.Initiate(now))
+	assert.Equal(t, EmergencyAccessRecoveryInitiated, e.Status)
+	assert.Equal(t, now.Add(7*24*time.Hour), e.RecoveryEndsAt())
+
+	require.NoError(t, e.Reject())
+	assert.Equal(t, EmergencyAccessConfirmed, e.Status)
+	assert.Nil(t, e.RecoveryInitiatedAt)
+	assert.True(t, e.RecoveryEndsAt().IsZero())
+
+	require.NoError(t, e.Initiate(now))
+	require.NoError(t, e.Approve())
+	assert.Equal(t, EmergencyAccessRecoveryApproved, e.Status)
+	require.NoError(t, e.Reject())
+	assert.Equal(t, EmergencyAccessConfirmed, e.Status)
+}
+
+func TestEmergencyAccessMerge(t *testing.T) {
+	grantor := &instance.Instance{Domain: "alice.cozy.example"}
+	grantee := &instance.Instance{Domain: "bob.cozy.example"}
+	newDoc := func(status EmergencyAccessStatus) *EmergencyAccess {
+		return &EmergencyAccess{
+			CouchID:      "2a1f5d40e3c1013b2c8d543d7eb8149c",
+			Type:         EmergencyAccessTakeover,
+			Status:       status,
+			WaitTimeDays: 3,
+			Grantor:      EmergencyAccessUser{Instance: "https://alice.cozy.example/"},
+			Grantee:      EmergencyAccessUser{Instance: "https://bob.cozy.example/"},
+			Token:        "a-secret-token",
+		}
+	}
+
+	// On the Cozy of the grantor
+	local := newDoc(EmergencyAccessInvited)
+	local.CouchRev = "1-abc"
+	other := newDoc(EmergencyAccessAccepted)
+	other.Grantee.UserID = "89d99af0db1c0139605b543d7eb8149c"
+	other.Grantee.PublicKey = "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA"
+	other.WaitTimeDays = 1
+	require.NoError(t, local.Merge(grantor, other))
+	assert.Equal(t, EmergencyAccessAccepted, local.Status)
+	assert.Equal(t, other.Grantee.PublicKey, local.Grantee.PublicKey)
+	assert.Equal(t, 3, local.WaitTimeDays)
+	assert.Equal(t, "1-abc", local.CouchRev)
+
+	// The grantee cannot approve their own request
+	local.Status = EmergencyAccessRecoveryInitiated
+	other = newDoc(EmergencyAccessRecoveryApproved)
+	assert.ErrorIs(t, local.Merge(grantor, other), ErrEmergencyAccessInvalidStatus)
+
+	local.Status = EmergencyAccessConfirmed
+	other = newDoc(EmergencyAccessRecoveryInitiated)
+	require.NoError(t, local.Merge(grantor, other))
+	assert.Equal(t, EmergencyAccessRecoveryInitiated, local.Status)
+	assert.NotNil(t, local.RecoveryInitiatedAt)
+
+	// On the Cozy of the grantee
+	local = newDoc(EmergencyAccessRecoveryInitiated)
+	local.CouchRev = "3-def"
+	local.Grantee.UserID = "89d99af0db1c0139605b543d7eb8149c"
+	other = newDoc(EmergencyAccessRecoveryApproved)
+	other.CouchRev = "5-ghi"
+	other.KeyEncrypted = "4.key"
+	other.Token = "forged-token"
+	require.NoError(t, local.Merge(grantee, other))
+	assert.Equal(t, EmergencyAccessRecoveryApproved, local.Status)
+	assert.Equal(t, "4.key", local.KeyEncrypted)
+	assert.Equal(t, "3-def", local.CouchRev)
+	assert.Equal(t, "a-secret-token", local.Token)
+	assert.Equal(t, "89d99af0db1c0139605b543d7eb8149c", local.Grantee.UserID)
+
+	stranger := &instance.Instance{Domain: "eve.cozy.example"}
+	assert.ErrorIs(t, local.Merge(stranger, other), ErrEmergencyAccessInvalidStatus)
+}
+
+func TestEmergencyAccessCheckTakeover(t *testing.T) {
+	e := &EmergencyAccess{Type: EmergencyAccessView, FullTakeover: true}
+	assert.ErrorIs(t, e.CheckTakeover(), ErrEmergencyAccessInvalidType)
+
+	// The takeover type is not enough, as it would replace the passphrase
+	// of the whole Cozy
+	e = &EmergencyAccess{Type: EmergencyAccessTakeover}
+	assert.ErrorIs(t, e.CheckTakeover(), ErrEmergencyAccessTakeoverNotAllowed)
+
+	e.FullTakeover = true
+	assert.NoError(t, e.CheckTakeover())
+
+	// The grantee cannot allow the takeover themself
+	grantor := &instance.Instance{Domain: "alice.cozy.example"}
+	local := &EmergencyAccess{
+		Type:    EmergencyAccessTakeover,
+		Status:  EmergencyAccessConfirmed,
+		Grantor: EmergencyAccessUser{Instance: "https://alice.cozy.example/"},
+		Grantee: EmergencyAccessUser{Instance: "https://bob.cozy.example/"},
+	}
+	other := *local
+	other.Status = EmergencyAccessRecoveryInitiated
+	other.FullTakeover = true
+	require.NoError(t, local.Merge(grantor, &other))
+	assert.ErrorIs(t, local.CheckTakeover(), ErrEmergencyAccessTakeoverNotAllowed)
+}
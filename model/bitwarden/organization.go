@@ -55,6 +55,7 @@ type Organization struct {
 	Name       string                `json:"name"`
 	Members    map[string]OrgMember  `json:"members"` // the keys are the instances domains
 	Collection Collection            `json:"defaultCollection"`
+	Policies   []Policy              `json:"policies,omitempty"`
 	Metadata   metadata.CozyMetadata `json:"cozyMetadata"`
 }
 
@@ -80,6 +81,18 @@ func (o *Organization) Clone() couchdb.Doc {
 	for k, v := range o.Members {
 		cloned.Members[k] = v
 	}
+	if o.Policies != nil {
+		cloned.Policies = make([]Policy, len(o.Policies))
+		for i, p := range o.Policies {
+			cloned.Policies[i] = p
+			if p.Data != nil {
+				cloned.Policies[i].Data = make(map[string]interface{}, len(p.Data))
+				for k, v := range p.Data {
+					cloned.Policies[i].Data[k] = v
+				}
+			}
+		}
+	}
 	return &cloned
 }
 
@@ -145,6 +158,7 @@ func (o *Organization) Delete(inst *instance.Instance) error {
 	if err := couchdb.BulkDeleteDocs(inst, consts.BitwardenCiphers, docs); err != nil {
 		return err
 	}
+	DeleteCiphersAttachments(inst, ciphers...)
 
 	return couchdb.DeleteDoc(inst, o)
 }
@@ -0,0 +1,162 @@
+package bitwarden
+
+import (
+	"errors"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// PolicyType is used to know the kind of rule enforced by a policy.
+type PolicyType int
+
+// The supported types of policies.
+// See https://github.com/bitwarden/clients/blob/main/libs/common/src/admin-console/enums/policy-type.enum.ts
+const (
+	// PolicyTwoFactorAuthentication requires the members to have enabled the
+	// two-factor authentication on their Cozy.
+	PolicyTwoFactorAuthentication PolicyType = 0
+	// PolicyMasterPassword sets requirements for the master password of the
+	// members.
+	PolicyMasterPassword PolicyType = 1
+	// PolicyPersonalOwnership disallows the members to save items in their
+	// personal vault.
+	PolicyPersonalOwnership PolicyType = 5
+)
+
+var (
+	// ErrUnsupportedPolicy is used when a policy of an unknown type is set.
+	ErrUnsupportedPolicy = errors.New("Unsupported policy type")
+	// ErrPersonalOwnershipPolicy is used when a member tries to save an item
+	// in their personal vault while a policy disallows it.
+	ErrPersonalOwnershipPolicy = errors.New("Due to an Enterprise Policy, you are restricted from saving items to your personal vault.")
+	// ErrTwoFactorPolicy is used when a member without the two-factor
+	// authentication tries to log in while a policy requires it.
+	ErrTwoFactorPolicy = errors.New("Two-step login is required by a policy of your organization.")
+)
+
+// IsSupportedPolicy returns true if the policy type is known by the stack.
+func IsSupportedPolicy(typ PolicyType) bool {
+	switch typ {
+	case PolicyTwoFactorAuthentication, PolicyMasterPassword, PolicyPersonalOwnership:
+		return true
+	}
+	return false
+}
+
+// Policy is a rule that the owner of an organization enforces on its members.
+type Policy struct {
+	Type    PolicyType             `json:"type"`
+	Enabled bool                   `json:"enabled"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+}
+
+// Policy returns the policy of the given type for the organization, or nil if
+// it has never been set.
+func (o *Organization) Policy(typ PolicyType) *Policy {
+	for i := range o.Policies {
+		if o.Policies[i].Type == typ {
+			return &o.Policies[i]
+		}
+	}
+	return nil
+}
+
+// SetPolicy adds or replaces the policy of the organization with the same
+// type.
+func (o *Organization) SetPolicy(p Policy) error {
+	if !IsSupportedPolicy(p.Type) {
+		return ErrUnsupportedPolicy
+	}
+	if existing := o.Policy(p.Type); existing != nil {
+		*existing = p
+		return nil
+	}
+	o.Policies = append(o.Policies, p)
+	return nil
+}
+
+// EnforcedPolicies returns the enabled policies of the given type that apply
+// to the instance. The owners of an organization are exempted from its
+// policies, like the invited members that have not yet accepted.
+func EnforcedPolicies(inst *instance.Instance, typ PolicyType) ([]*Policy, error) {
+	var orgs []*Organization
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenOrganizations, req, &orgs); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var policies []*Policy
+	for _, org := range orgs {
+		m := org.Member(inst)
+		if m.Owner || m.Status == OrgMemberInvited {
+			continue
+		}
+		if p := org.Policy(typ); p != nil && p.Enabled {
+			policies = append(policies, p)
+		}
+	}
+	return policies, nil
+}
+
+// CheckPersonalOwnership returns an error if a policy disallows the user to
+// save items in their personal vault.
+func CheckPersonalOwnership(inst *instance.Instance) error {
+	policies, err := EnforcedPolicies(inst, PolicyPersonalOwnership)
+	if err != nil {
+		return err
+	}
+	if len(policies) > 0 {
+		return ErrPersonalOwnershipPolicy
+	}
+	return nil
+}
+
+// CheckTwoFactorPolicy returns an error if a policy requires the two-factor
+// authentication and the instance has not enabled it.
+func CheckTwoFactorPolicy(inst *instance.Instance) error {
+	if inst.HasTwoFactor() {
+		return nil
+	}
+	policies, err := EnforcedPolicies(inst, PolicyTwoFactorAuthentication)
+	if err != nil {
+		return err
+	}
+	if len(policies) > 0 {
+		return ErrTwoFactorPolicy
+	}
+	return nil
+}
+
+// MasterPasswordRequirements returns the combination of the master password
+// policies that apply to the instance, or nil if there are none. Like on the
+// official server, the strictest value of each requirement is kept.
+func MasterPasswordRequirements(inst *instance.Instance) (map[string]interface{}, error) {
+	policies, err := EnforcedPolicies(inst, PolicyMasterPassword)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+
+	reqs := map[string]interface{}{}
+	for _, p := range policies {
+		for k, v := range p.Data {
+			switch v := v.(type) {
+			case bool:
+				if v {
+					reqs[k] = true
+				} else if _, ok := reqs[k]; !ok {
+					reqs[k] = false
+				}
+			case float64:
+				if prev, ok := reqs[k].(float64); !ok || v > prev {
+					reqs[k] = v
+				}
+			}
+		}
+	}
+	return reqs, nil
+}
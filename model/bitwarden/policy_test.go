@@ -0,0 +1,35 @@
+package bitwarden
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrganizationPolicies(t *testing.T) {
+	org := &Organization{}
+	assert.Nil(t, org.Policy(PolicyPersonalOwnership))
+
+	err := org.SetPolicy(Policy{Type: PolicyType(42), Enabled: true})
+	assert.ErrorIs(t, err, ErrUnsupportedPolicy)
+	assert.Empty(t, org.Policies)
+
+	require.NoError(t, org.SetPolicy(Policy{Type: PolicyPersonalOwnership, Enabled: true}))
+	require.NoError(t, org.SetPolicy(Policy{
+		Type:    PolicyMasterPassword,
+		Enabled: true,
+		Data:    map[string]interface{}{"minLength": float64(12)},
+	}))
+	assert.Len(t, org.Policies, 2)
+
+	require.NoError(t, org.SetPolicy(Policy{Type: PolicyPersonalOwnership, Enabled: false}))
+	assert.Len(t, org.Policies, 2)
+	p := org.Policy(PolicyPersonalOwnership)
+	require.NotNil(t, p)
+	assert.False(t, p.Enabled)
+
+	cloned := org.Clone().(*Organization)
+	cloned.Policies[1].Enabled = false
+	assert.True(t, org.Policy(PolicyMasterPassword).Enabled)
+}
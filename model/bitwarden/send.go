@@ -0,0 +1,268 @@
+package bitwarden
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// SendsDirName is the path of the hidden directory of the VFS where the
+// (encrypted) contents of the file sends are stored.
+const SendsDirName = "/.cozy_bitwarden_sends"
+
+// SendType is used to know if a send is for a text or for a file.
+type SendType int
+
+// SendTypeText and SendTypeFile are the 2 possible types of sends.
+// See https://github.com/bitwarden/clients/blob/main/libs/common/src/tools/send/enums/send-type.ts
+const (
+	SendTypeText SendType = 0
+	SendTypeFile SendType = 1
+)
+
+// MaxSendFileSize is the maximal size of the content of a file send.
+const MaxSendFileSize = 500 * 1024 * 1024
+
+// MaxSendDeletionDelay is how far in the future the deletion date of a send
+// can be.
+const MaxSendDeletionDelay = 31 * 24 * time.Hour
+
+var (
+	// ErrSendNotAccessible is used when a send is disabled, expired, or when
+	// its maximal number of accesses has been reached.
+	ErrSendNotAccessible = errors.New("Send is not accessible")
+	// ErrSendFileNotUploaded is used when the content of a file send is
+	// requested before it has been uploaded.
+	ErrSendFileNotUploaded = errors.New("Send file has not been uploaded")
+)
+
+// SendText is the (encrypted) text of a send with the text type.
+type SendText struct {
+	Text   string `json:"text,omitempty"`
+	Hidden bool   `json:"hidden,omitempty"`
+}
+
+// SendFile is the description of the file of a send with the file type. The
+// file name is encrypted, and so is the content stored in the VFS.
+type SendFile struct {
+	ID       string `json:"id"`
+	FileName string `json:"file_name"`
+	Size     int64  `json:"size"`
+	VFSID    string `json:"vfs_id,omitempty"`
+}
+
+// Send is a text or a file that the user can share with anyone via a link.
+// The content is encrypted on the client side with a key that is only in the
+// fragment of the link, and the access can be protected by a password.
+type Send struct {
+	CouchID        string                 `json:"_id,omitempty"`
+	CouchRev       string                 `json:"_rev,omitempty"`
+	Type           SendType               `json:"type"`
+	Name           string                 `json:"name"`
+	Notes          string                 `json:"notes,omitempty"`
+	Key            string                 `json:"key"`
+	Text           *SendText              `json:"text,omitempty"`
+	File           *SendFile              `json:"file,omitempty"`
+	PasswordHash   string                 `json:"password_hash,omitempty"`
+	MaxAccessCount *int                   `json:"max_access_count,omitempty"`
+	AccessCount    int                    `json:"access_count"`
+	ExpirationDate *time.Time             `json:"expiration_date,omitempty"`
+	DeletionDate   time.Time              `json:"deletion_date"`
+	Disabled       bool                   `json:"disabled,omitempty"`
+	HideEmail      bool                   `json:"hide_email,omitempty"`
+	Metadata       *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
+}
+
+// ID returns the send qualified identifier
+func (s *Send) ID() string { return s.CouchID }
+
+// Rev returns the send revision
+func (s *Send) Rev() string { return s.CouchRev }
+
+// DocType returns the send document type
+func (s *Send) DocType() string { return consts.BitwardenSends }
+
+// Clone implements couchdb.Doc
+func (s *Send) Clone() couchdb.Doc {
+	cloned := *s
+	if s.Text != nil {
+		text := *s.Text
+		cloned.Text = &text
+	}
+	if s.File != nil {
+		file := *s.File
+		cloned.File = &file
+	}
+	if s.MaxAccessCount != nil {
+		count := *s.MaxAccessCount
+		cloned.MaxAccessCount = &count
+	}
+	if s.ExpirationDate != nil {
+		date := *s.ExpirationDate
+		cloned.ExpirationDate = &date
+	}
+	if s.Metadata != nil {
+		cloned.Metadata = s.Metadata.Clone()
+	}
+	return &cloned
+}
+
+// SetID changes the send qualified identifier
+func (s *Send) SetID(id string) { s.CouchID = id }
+
+// SetRev changes the send revision
+func (s *Send) SetRev(rev string) { s.CouchRev = rev }
+
+// AccessID returns the identifier used in the links for accessing the send
+// anonymously. Like on the official server, it is the base64url encoding of
+// the bytes of the UUID.
+func (s *Send) AccessID() string {
+	if raw, err := hex.DecodeString(s.CouchID); err == nil && len(raw) == 16 {
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(s.CouchID))
+}
+
+// SendIDFromAccessID returns the identifier of the send document for the
+// given access identifier.
+func SendIDFromAccessID(accessID string) (string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(accessID)
+	if err != nil {
+		return "", err
+	}
+	if len(raw) == 16 {
+		return hex.EncodeToString(raw), nil
+	}
+	return string(raw), nil
+}
+
+// SetPassword protects the access to the send with a password. The client
+// sends a hash of the password, that is hashed again before being persisted.
+// An empty password removes the protection.
+func (s *Send) SetPassword(password string) error {
+	if password == "" {
+		s.PasswordHash = ""
+		return nil
+	}
+	hash, err := crypto.GenerateFromPassphrase([]byte(password))
+	if err != nil {
+		return err
+	}
+	s.PasswordHash = string(hash)
+	return nil
+}
+
+// HasPassword returns true if the access to the send is protected by a
+// password.
+func (s *Send) HasPassword() bool {
+	return s.PasswordHash != ""
+}
+
+// CheckPassword returns true if the given (hashed) password matches the one
+// of the send.
+func (s *Send) CheckPassword(password string) bool {
+	if !s.HasPassword() {
+		return true
+	}
+	if password == "" {
+		return false
+	}
+	_, err := crypto.CompareHashAndPassphrase([]byte(s.PasswordHash), []byte(password))
+	return err == nil
+}
+
+// IsDeleted returns true if the deletion date of the send has passed.
+func (s *Send) IsDeleted(now time.Time) bool {
+	return !s.DeletionDate.After(now)
+}
+
+// IsAccessible returns true if the send can be accessed anonymously.
+func (s *Send) IsAccessible(now time.Time) bool {
+	if s.Disabled || s.IsDeleted(now) {
+		return false
+	}
+	if s.ExpirationDate != nil && !s.ExpirationDate.After(now) {
+		return false
+	}
+	if s.MaxAccessCount != nil && s.AccessCount >= *s.MaxAccessCount {
+		return false
+	}
+	return true
+}
+
+// IsFileUploaded returns true if the content of a file send has been
+// uploaded to the VFS.
+func (s *Send) IsFileUploaded() bool {
+	return s.Type == SendTypeFile && s.File != nil && s.File.VFSID != ""
+}
+
+// FindAllSends returns all the sends of the instance, except those whose
+// deletion date has passed (they are destroyed).
+func FindAllSends(inst *instance.Instance) ([]*Send, error) {
+	var sends []*Send
+	req := &couchdb.AllDocsRequest{}
+	if err := couchdb.GetAllDocs(inst, consts.BitwardenSends, req, &sends); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			_ = couchdb.CreateDB(inst, consts.BitwardenSends)
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	var errm error
+	kept := sends[:0]
+	for _, s := range sends {
+		if s.IsDeleted(now) {
+			if err := DeleteSend(inst, s); err != nil {
+				errm = multierror.Append(errm, err)
+			}
+			continue
+		}
+		kept = append(kept, s)
+	}
+	if errm != nil {
+		inst.Logger().WithNamespace("bitwarden").
+			Warnf("Cannot purge the deleted sends: %s", errm)
+	}
+	return kept, nil
+}
+
+// DeleteSend destroys a send, and the content of its file if any.
+func DeleteSend(inst *instance.Instance, s *Send) error {
+	if s.IsFileUploaded() {
+		if err := destroyVaultFile(inst, s.File.VFSID); err != nil {
+			return err
+		}
+	}
+	return couchdb.DeleteDoc(inst, s)
+}
+
+// CreateSendFile returns a file handle for writing the (encrypted) content of
+// a file send in the VFS, and the file document, whose identifier must be
+// kept in the send after the file has been closed. The previous content, if
+// any, is replaced.
+func CreateSendFile(inst *instance.Instance, s *Send) (vfs.File, *vfs.FileDoc, error) {
+	name := s.CouchID + "-" + s.File.ID
+	return createVaultFile(inst, SendsDirName, name, s.File.Size, s.File.VFSID)
+}
+
+// OpenSendFile returns the file document where the content of a file send is
+// stored.
+func OpenSendFile(inst *instance.Instance, s *Send) (*vfs.FileDoc, error) {
+	if !s.IsFileUploaded() {
+		return nil, ErrSendFileNotUploaded
+	}
+	return inst.VFS().FileByID(s.File.VFSID)
+}
+
+var _ couchdb.Doc = &Send{}
@@ -0,0 +1,68 @@
+package bitwarden
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendAccessID(t *testing.T) {
+	send := &Send{CouchID: "6f1e1c1ad4a74fc0b1e4b04f5d3f4a7e"}
+	accessID := send.AccessID()
+	assert.Equal(t, "bx4cGtSnT8Cx5LBPXT9Kfg", accessID)
+	id, err := SendIDFromAccessID(accessID)
+	require.NoError(t, err)
+	assert.Equal(t, send.CouchID, id)
+
+	send = &Send{CouchID: "not-an-uuid"}
+	id, err = SendIDFromAccessID(send.AccessID())
+	require.NoError(t, err)
+	assert.Equal(t, send.CouchID, id)
+
+	_, err = SendIDFromAccessID("not base64!")
+	assert.Error(t, err)
+}
+
+func TestSendIsAccessible(t *testing.T) {
+	now := time.Now()
+	send := &Send{DeletionDate: now.Add(time.Hour)}
+	assert.True(t, send.IsAccessible(now))
+
+	send.Disabled = true
+	assert.False(t, send.IsAccessible(now))
+	send.Disabled = false
+
+	past := now.Add(-time.Minute)
+	send.ExpirationDate = &past
+	assert.False(t, send.IsAccessible(now))
+	send.ExpirationDate = nil
+
+	max := 2
+	send.MaxAccessCount = &max
+	send.AccessCount = 1
+	assert.True(t, send.IsAccessible(now))
+	send.AccessCount = 2
+	assert.False(t, send.IsAccessible(now))
+	send.MaxAccessCount = nil
+
+	send.DeletionDate = past
+	assert.True(t, send.IsDeleted(now))
+	assert.False(t, send.IsAccessible(now))
+}
+
+func TestSendPassword(t *testing.T) {
+	send := &Send{}
+	assert.False(t, send.HasPassword())
+	assert.True(t, send.CheckPassword(""))
+
+	require.NoError(t, send.SetPassword("cY3zOhLAa8hD1W0S/1zF8pYq/3dVQ3hC0H3v3T5VJ0c="))
+	assert.True(t, send.HasPassword())
+	assert.False(t, send.CheckPassword(""))
+	assert.False(t, send.CheckPassword("wrong"))
+	assert.True(t, send.CheckPassword("cY3zOhLAa8hD1W0S/1zF8pYq/3dVQ3hC0H3v3T5VJ0c="))
+
+	require.NoError(t, send.SetPassword(""))
+	assert.False(t, send.HasPassword())
+}
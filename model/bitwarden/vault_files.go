@@ -0,0 +1,74 @@
+package bitwarden
+
+import (
+	"os"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+)
+
+// The contents of the file sends and of the attachments are encrypted on the
+// client side. They are stored as files in hidden directories of the VFS, so
+// that they count in the disk usage and are included in the exports.
+
+// CheckVaultFileSize returns an error if a file of the given size cannot be
+// stored in the VFS, because of the disk quota for example.
+func CheckVaultFileSize(inst *instance.Instance, size int64) error {
+	doc := &vfs.FileDoc{ByteSize: size}
+	_, _, _, err := vfs.CheckAvailableDiskSpace(inst.VFS(), doc)
+	return err
+}
+
+// createVaultFile returns a file handle for writing the content of a file in
+// the given hidden directory, and the file document. If vfsID is not empty,
+// the content of this file is replaced.
+func createVaultFile(inst *instance.Instance, dirName, name string, size int64, vfsID string) (vfs.File, *vfs.FileDoc, error) {
+	fs := inst.VFS()
+	dir, err := vfs.MkdirAll(fs, dirName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var olddoc *vfs.FileDoc
+	if vfsID != "" {
+		olddoc, err = fs.FileByID(vfsID)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, nil, err
+		}
+	}
+
+	newdoc, err := vfs.NewFileDoc(name, dir.ID(), size, nil,
+		"application/octet-stream", "files", time.Now(), false, false, true, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if olddoc != nil {
+		newdoc.SetID(olddoc.ID())
+		newdoc.SetRev(olddoc.Rev())
+		newdoc.CreatedAt = olddoc.CreatedAt
+	}
+	newdoc.CozyMetadata = vfs.NewCozyMetadata(inst.PageURL("/", nil))
+	file, err := fs.CreateFile(newdoc, olddoc)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, newdoc, nil
+}
+
+// destroyVaultFile removes a file from a hidden directory. It is not an error
+// if the file has already been removed.
+func destroyVaultFile(inst *instance.Instance, vfsID string) error {
+	if vfsID == "" {
+		return nil
+	}
+	fs := inst.VFS()
+	file, err := fs.FileByID(vfsID)
+	if err == nil {
+		err = fs.DestroyFile(file)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,245 @@
+// Package calendar is for managing the events (io.cozy.calendar.events) and
+// the tasks (io.cozy.calendar.todos) of a calendar. They are stored as JSON in
+// CouchDB, and can be converted from and to iCalendar (RFC 5545). The
+// recurring events are stored in a single document, and are expanded when
+// needed.
+package calendar
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+var (
+	// ErrInvalidICal is used when an iCalendar object can't be parsed, or
+	// doesn't have the expected component.
+	ErrInvalidICal = errors.New("Invalid iCalendar object")
+	// ErrUnknownDocType is used for a doctype that is not an event or a todo.
+	ErrUnknownDocType = errors.New("Unknown calendar doctype")
+)
+
+// The status of the events and todos.
+const (
+	StatusTentative   = "TENTATIVE"
+	StatusConfirmed   = "CONFIRMED"
+	StatusCancelled   = "CANCELLED"
+	StatusNeedsAction = "NEEDS-ACTION"
+	StatusCompleted   = "COMPLETED"
+	StatusInProcess   = "IN-PROCESS"
+)
+
+// TranspTransparent is the transparency of the events that don't block time
+// in the free-busy queries.
+const TranspTransparent = "TRANSPARENT"
+
+// Alarm is a reminder for an event or a todo (VALARM).
+type Alarm struct {
+	Action string `json:"action"`
+	// Trigger is either a duration relative to the start (or to the end if
+	// related is END) of the occurrence, like -PT15M, or an absolute date in
+	// the RFC 3339 format.
+	Trigger     string   `json:"trigger"`
+	Related     string   `json:"related,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Extra       []string `json:"ical,omitempty"`
+}
+
+// Component has the fields that are common to the events and the todos.
+type Component struct {
+	UID         string      `json:"uid"`
+	Summary     string      `json:"summary,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Location    string      `json:"location,omitempty"`
+	Status      string      `json:"status,omitempty"`
+	Categories  []string    `json:"categories,omitempty"`
+	Sequence    int         `json:"sequence,omitempty"`
+	Start       *DateTime   `json:"start,omitempty"`
+	RRule       string      `json:"rrule,omitempty"`
+	RDates      []*DateTime `json:"rdates,omitempty"`
+	ExDates     []*DateTime `json:"exdates,omitempty"`
+	Alarms      []*Alarm    `json:"alarms,omitempty"`
+	// Extra has the iCalendar properties that are not mapped to a field, as
+	// content lines. They are kept to be sent back to the CalDAV clients.
+	Extra    []string               `json:"ical,omitempty"`
+	Metadata *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
+}
+
+// Fetch implements the permission.Fetcher interface, for the events and the
+// todos.
+func (c *Component) Fetch(field string) []string {
+	switch field {
+	case "uid":
+		return []string{c.UID}
+	case "status":
+		return []string{c.Status}
+	case "categories":
+		return c.Categories
+	}
+	return nil
+}
+
+func (c *Component) clone() Component {
+	cloned := *c
+	cloned.Categories = append([]string(nil), c.Categories...)
+	cloned.RDates = append([]*DateTime(nil), c.RDates...)
+	cloned.ExDates = append([]*DateTime(nil), c.ExDates...)
+	cloned.Alarms = append([]*Alarm(nil), c.Alarms...)
+	cloned.Extra = append([]string(nil), c.Extra...)
+	if c.Metadata != nil {
+		cloned.Metadata = c.Metadata.Clone()
+	}
+	return cloned
+}
+
+// Event is an io.cozy.calendar.events document. The modified occurrences of
+// a recurring event are kept in the overrides, with their recurrence ID.
+type Event struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+	Component
+	End          *DateTime `json:"end,omitempty"`
+	Transparency string    `json:"transparency,omitempty"`
+	RecurrenceID *DateTime `json:"recurrenceId,omitempty"`
+	Overrides    []*Event  `json:"overrides,omitempty"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (e *Event) ID() string { return e.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (e *Event) Rev() string { return e.DocRev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (e *Event) DocType() string { return consts.CalendarEvents }
+
+// SetID is used to implement the couchdb.Doc interface
+func (e *Event) SetID(id string) { e.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (e *Event) SetRev(rev string) { e.DocRev = rev }
+
+// Clone implements couchdb.Doc
+func (e *Event) Clone() couchdb.Doc {
+	cloned := *e
+	cloned.Component = e.Component.clone()
+	cloned.Overrides = make([]*Event, len(e.Overrides))
+	for i, o := range e.Overrides {
+		cloned.Overrides[i] = o.Clone().(*Event)
+	}
+	return &cloned
+}
+
+// Base returns the fields shared with the todos.
+func (e *Event) Base() *Component { return &e.Component }
+
+// Todo is an io.cozy.calendar.todos document.
+type Todo struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+	Component
+	Due             *DateTime  `json:"due,omitempty"`
+	Completed       *time.Time `json:"completed,omitempty"`
+	Priority        int        `json:"priority,omitempty"`
+	PercentComplete int        `json:"percentComplete,omitempty"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (t *Todo) ID() string { return t.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (t *Todo) Rev() string { return t.DocRev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (t *Todo) DocType() string { return consts.CalendarTodos }
+
+// SetID is used to implement the couchdb.Doc interface
+func (t *Todo) SetID(id string) { t.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (t *Todo) SetRev(rev string) { t.DocRev = rev }
+
+// Clone implements couchdb.Doc
+func (t *Todo) Clone() couchdb.Doc {
+	cloned := *t
+	cloned.Component = t.Component.clone()
+	if t.Completed != nil {
+		completed := *t.Completed
+		cloned.Completed = &completed
+	}
+	return &cloned
+}
+
+// Base returns the fields shared with the events.
+func (t *Todo) Base() *Component { return &t.Component }
+
+// Item is implemented by the events and the todos.
+type Item interface {
+	couchdb.Doc
+	permission.Fetcher
+	Base() *Component
+	// ICal returns the item as an iCalendar object.
+	ICal() []byte
+	// UpdateFromICal replaces the fields of the item by the ones of the
+	// iCalendar object. The identifier and the metadata are kept.
+	UpdateFromICal(data []byte) error
+	// Occurrences returns the occurrences of the item that overlap the given
+	// time range.
+	Occurrences(from, to time.Time) ([]Occurrence, error)
+	// NextReminder returns the first reminder after the given time, or nil.
+	NextReminder(after time.Time) *Reminder
+}
+
+var (
+	_ Item = (*Event)(nil)
+	_ Item = (*Todo)(nil)
+)
+
+// NewItem returns an empty event or todo for the given doctype.
+func NewItem(doctype string) (Item, error) {
+	switch doctype {
+	case consts.CalendarEvents:
+		return &Event{}, nil
+	case consts.CalendarTodos:
+		return &Todo{}, nil
+	}
+	return nil, ErrUnknownDocType
+}
+
+// FindItem returns the event or todo with the given identifier.
+func FindItem(db prefixer.Prefixer, doctype, id string) (Item, error) {
+	item, err := NewItem(doctype)
+	if err != nil {
+		return nil, err
+	}
+	if err := couchdb.GetDoc(db, doctype, id, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// ListItems returns all the events or todos.
+func ListItems(db prefixer.Prefixer, doctype string) ([]Item, error) {
+	if _, err := NewItem(doctype); err != nil {
+		return nil, err
+	}
+	var items []Item
+	err := couchdb.ForeachDocs(db, doctype, func(_ string, data json.RawMessage) error {
+		item, _ := NewItem(doctype)
+		if err := json.Unmarshal(data, item); err != nil {
+			return err
+		}
+		items = append(items, item)
+		return nil
+	})
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,241 @@
+package calendar
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/contentline"
+)
+
+// The layouts of the dates and times, in JSON and in iCalendar.
+const (
+	dateLayout         = "2006-01-02"
+	floatingLayout     = "2006-01-02T15:04:05"
+	icalDateLayout     = "20060102"
+	icalDateTimeLayout = "20060102T150405"
+)
+
+var errInvalidDuration = errors.New("Invalid duration")
+
+// DateTime is a date with a time, or just a date for the all-day events. A
+// time can be in UTC, in a timezone, or floating (the same local time in any
+// timezone):
+//
+//	{"dateTime": "2024-03-01T10:00:00+01:00", "timeZone": "Europe/Paris"}
+//	{"dateTime": "2024-03-01T09:00:00Z"}
+//	{"dateTime": "2024-03-01T10:00:00"}
+//	{"date": "2024-03-01"}
+type DateTime struct {
+	Date     string `json:"date,omitempty"`
+	DateTime string `json:"dateTime,omitempty"`
+	TimeZone string `json:"timeZone,omitempty"`
+}
+
+// NewDate returns an all-day date.
+func NewDate(t time.Time) *DateTime {
+	return &DateTime{Date: t.Format(dateLayout)}
+}
+
+// NewDateTime returns a date with a time in the given timezone, or in UTC if
+// the timezone is empty.
+func NewDateTime(t time.Time, tzid string) *DateTime {
+	if tzid == "" {
+		return &DateTime{DateTime: t.UTC().Format(time.RFC3339)}
+	}
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		t = t.In(loc)
+	}
+	return &DateTime{DateTime: t.Format(time.RFC3339), TimeZone: tzid}
+}
+
+func newFloating(t time.Time) *DateTime {
+	return &DateTime{DateTime: t.Format(floatingLayout)}
+}
+
+// IsDate returns true for the all-day dates.
+func (d *DateTime) IsDate() bool {
+	return d.Date != ""
+}
+
+// IsFloating returns true for the times without a timezone.
+func (d *DateTime) IsFloating() bool {
+	return d.Date == "" && len(d.DateTime) == len(floatingLayout)
+}
+
+// Location returns the timezone of the date. The all-day dates and the
+// floating times are in UTC.
+func (d *DateTime) Location() *time.Location {
+	if d.TimeZone != "" {
+		if loc, err := time.LoadLocation(d.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
+
+// Time returns the date as a time.Time, in its timezone. An all-day date is
+// at midnight.
+func (d *DateTime) Time() (time.Time, error) {
+	if d.IsDate() {
+		return time.Parse(dateLayout, d.Date)
+	}
+	if d.IsFloating() {
+		return time.Parse(floatingLayout, d.DateTime)
+	}
+	t, err := time.Parse(time.RFC3339, d.DateTime)
+	if err != nil {
+		return t, err
+	}
+	return t.In(d.Location()), nil
+}
+
+// at returns a date of the same kind (all-day, floating, or in the same
+// timezone) for the given time.
+func (d *DateTime) at(t time.Time) *DateTime {
+	switch {
+	case d.IsDate():
+		return NewDate(t)
+	case d.IsFloating():
+		return newFloating(t)
+	default:
+		return NewDateTime(t, d.TimeZone)
+	}
+}
+
+// icalProperty returns the iCalendar property for the date.
+func (d *DateTime) icalProperty(name string) *contentline.Property {
+	t, err := d.Time()
+	if err != nil {
+		return nil
+	}
+	switch {
+	case d.IsDate():
+		p := contentline.NewProperty(name, t.Format(icalDateLayout))
+		p.Params.Add("VALUE", "DATE")
+		return p
+	case d.IsFloating():
+		return contentline.NewProperty(name, t.Format(icalDateTimeLayout))
+	case d.TimeZone != "":
+		p := contentline.NewProperty(name, t.Format(icalDateTimeLayout))
+		p.Params.Add("TZID", d.TimeZone)
+		return p
+	default:
+		return contentline.NewProperty(name, t.UTC().Format(icalDateTimeLayout)+"Z")
+	}
+}
+
+// parseDateTimes parses the value of a DTSTART, DTEND, RDATE, etc. property.
+// The values of RDATE and EXDATE can be a list separated by commas.
+func parseDateTimes(p *contentline.Property, tzs timezones) ([]*DateTime, error) {
+	var dates []*DateTime
+	for _, value := range strings.Split(p.Value, ",") {
+		d, err := parseDateTime(value, p.Params, tzs)
+		if err != nil {
+			return nil, err
+		}
+		dates = append(dates, d)
+	}
+	return dates, nil
+}
+
+func parseDateTime(value string, params contentline.Params, tzs timezones) (*DateTime, error) {
+	value = strings.TrimSpace(value)
+	if i := strings.IndexByte(value, '/'); i > 0 {
+		// Only the start of a PERIOD is kept
+		value = value[:i]
+	}
+	if strings.EqualFold(params.Get("VALUE"), "DATE") || len(value) == len(icalDateLayout) {
+		t, err := time.Parse(icalDateLayout, value)
+		if err != nil {
+			return nil, err
+		}
+		return NewDate(t), nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(icalDateTimeLayout, strings.TrimSuffix(value, "Z"))
+		if err != nil {
+			return nil, err
+		}
+		return NewDateTime(t, ""), nil
+	}
+	tzid := params.Get("TZID")
+	if tzid == "" {
+		t, err := time.Parse(icalDateTimeLayout, value)
+		if err != nil {
+			return nil, err
+		}
+		return newFloating(t), nil
+	}
+	loc, name := tzs.resolve(tzid)
+	t, err := time.ParseInLocation(icalDateTimeLayout, value, loc)
+	if err != nil {
+		return nil, err
+	}
+	return NewDateTime(t, name), nil
+}
+
+// duration is a duration of iCalendar. The days and weeks are nominal: they
+// are added to the dates without changing the local time, even when there is
+// a DST change.
+type duration struct {
+	days  int
+	exact time.Duration
+}
+
+// parseDuration parses a duration like -PT15M or P1DT12H.
+func parseDuration(s string) (duration, error) {
+	var d duration
+	sign := 1
+	s = strings.TrimSpace(strings.ToUpper(s))
+	switch {
+	case strings.HasPrefix(s, "-"):
+		sign = -1
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return d, errInvalidDuration
+	}
+	s = s[1:]
+	inTime := false
+	for len(s) > 0 {
+		if s[0] == 'T' {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		i := strings.IndexAny(s, "WDHMS")
+		if i <= 0 {
+			return d, errInvalidDuration
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return d, errInvalidDuration
+		}
+		switch unit := s[i]; {
+		case unit == 'W' && !inTime:
+			d.days += 7 * n
+		case unit == 'D' && !inTime:
+			d.days += n
+		case unit == 'H' && inTime:
+			d.exact += time.Duration(n) * time.Hour
+		case unit == 'M' && inTime:
+			d.exact += time.Duration(n) * time.Minute
+		case unit == 'S' && inTime:
+			d.exact += time.Duration(n) * time.Second
+		default:
+			return d, errInvalidDuration
+		}
+		s = s[i+1:]
+	}
+	d.days *= sign
+	d.exact *= time.Duration(sign)
+	return d, nil
+}
+
+func (d duration) addTo(t time.Time) time.Time {
+	return t.AddDate(0, 0, d.days).Add(d.exact)
+}
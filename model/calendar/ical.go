@@ -0,0 +1,527 @@
+package calendar
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/contentline"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+)
+
+// ProdID is the identifier of the product that has generated the iCalendar
+// objects.
+const ProdID = "-//Cozy Cloud//Cozy Stack//EN"
+
+// component is a component of an iCalendar object, like VEVENT or VALARM.
+type component struct {
+	Name     string
+	Props    []*contentline.Property
+	Children []*component
+}
+
+func (c *component) property(name string) *contentline.Property {
+	for _, p := range c.Props {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// parseComponents parses an iCalendar object, and returns its VCALENDAR
+// component.
+func parseComponents(data []byte) (*component, error) {
+	props, err := contentline.Parse(data)
+	if err != nil {
+		return nil, ErrInvalidICal
+	}
+	var root *component
+	var stack []*component
+	for _, p := range props {
+		switch p.Name {
+		case "BEGIN":
+			stack = append(stack, &component{Name: strings.ToUpper(p.Value)})
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(p.Value) {
+				return nil, ErrInvalidICal
+			}
+			comp := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, ErrInvalidICal
+				}
+				root = comp
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, comp)
+			}
+		default:
+			if len(stack) == 0 {
+				return nil, ErrInvalidICal
+			}
+			top := stack[len(stack)-1]
+			top.Props = append(top.Props, p)
+		}
+	}
+	if len(stack) > 0 || root == nil || root.Name != "VCALENDAR" {
+		return nil, ErrInvalidICal
+	}
+	return root, nil
+}
+
+// decodeICal returns the components with the given name of an iCalendar
+// object, and its timezones. A CalDAV resource has a single event or todo:
+// all the components must have the same UID (the other ones are the modified
+// occurrences of a recurring event).
+func decodeICal(data []byte, name string) ([]*component, timezones, error) {
+	root, err := parseComponents(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	tzs := make(timezones)
+	var comps []*component
+	uid := ""
+	for _, child := range root.Children {
+		switch child.Name {
+		case "VTIMEZONE":
+			if p := child.property("TZID"); p != nil {
+				tzs[p.Value] = child
+			}
+		case name:
+			p := child.property("UID")
+			if p == nil || p.Value == "" || (uid != "" && p.Value != uid) {
+				return nil, nil, ErrInvalidICal
+			}
+			uid = p.Value
+			comps = append(comps, child)
+		}
+	}
+	if len(comps) == 0 {
+		return nil, nil, ErrInvalidICal
+	}
+	return comps, tzs, nil
+}
+
+func parseSingleDate(p *contentline.Property, tzs timezones) (*DateTime, error) {
+	dates, err := parseDateTimes(p, tzs)
+	if err != nil || len(dates) != 1 {
+		return nil, ErrInvalidICal
+	}
+	return dates[0], nil
+}
+
+// decodeProperty fills the field of the component for an iCalendar
+// property. The unknown properties are kept in Extra.
+func (c *Component) decodeProperty(p *contentline.Property, tzs timezones) error {
+	var err error
+	switch p.Name {
+	case "DTSTAMP", "CREATED", "LAST-MODIFIED":
+		// These properties are generated from the cozyMetadata
+	case "UID":
+		c.UID = p.Value
+	case "SUMMARY":
+		c.Summary = contentline.UnescapeText(p.Value)
+	case "DESCRIPTION":
+		c.Description = contentline.UnescapeText(p.Value)
+	case "LOCATION":
+		c.Location = contentline.UnescapeText(p.Value)
+	case "STATUS":
+		c.Status = strings.ToUpper(p.Value)
+	case "CATEGORIES":
+		for _, category := range contentline.SplitText(p.Value, ',') {
+			if category = strings.TrimSpace(category); category != "" {
+				c.Categories = append(c.Categories, category)
+			}
+		}
+	case "SEQUENCE":
+		c.Sequence, err = strconv.Atoi(p.Value)
+	case "DTSTART":
+		c.Start, err = parseSingleDate(p, tzs)
+	case "RRULE":
+		if c.RRule != "" {
+			// Multiple RRULE are deprecated, only the first one is used
+			return nil
+		}
+		c.RRule = p.Value
+	case "RDATE":
+		var dates []*DateTime
+		dates, err = parseDateTimes(p, tzs)
+		c.RDates = append(c.RDates, dates...)
+	case "EXDATE":
+		var dates []*DateTime
+		dates, err = parseDateTimes(p, tzs)
+		c.ExDates = append(c.ExDates, dates...)
+	default:
+		c.Extra = append(c.Extra, p.String())
+	}
+	return err
+}
+
+// decodeAlarms fills the alarms from the VALARM subcomponents. The other
+// subcomponents are ignored.
+func (c *Component) decodeAlarms(comp *component) {
+	for _, child := range comp.Children {
+		if child.Name != "VALARM" {
+			continue
+		}
+		alarm := &Alarm{}
+		for _, p := range child.Props {
+			switch p.Name {
+			case "ACTION":
+				alarm.Action = strings.ToUpper(p.Value)
+			case "TRIGGER":
+				if strings.EqualFold(p.Params.Get("VALUE"), "DATE-TIME") {
+					t, err := time.Parse(icalDateTimeLayout, strings.TrimSuffix(p.Value, "Z"))
+					if err != nil {
+						continue
+					}
+					alarm.Trigger = t.Format(time.RFC3339)
+				} else {
+					alarm.Trigger = p.Value
+				}
+				if strings.EqualFold(p.Params.Get("RELATED"), "END") {
+					alarm.Related = "END"
+				}
+			case "DESCRIPTION":
+				alarm.Description = contentline.UnescapeText(p.Value)
+			default:
+				alarm.Extra = append(alarm.Extra, p.String())
+			}
+		}
+		if alarm.Action != "" && alarm.Trigger != "" {
+			c.Alarms = append(c.Alarms, alarm)
+		}
+	}
+}
+
+// validate checks the fields that are required, and the recurrence rule.
+func (c *Component) validate() error {
+	if c.UID == "" {
+		return ErrInvalidICal
+	}
+	if c.RRule != "" {
+		if c.Start == nil {
+			return ErrInvalidICal
+		}
+		if _, err := ParseRRule(c.RRule, c.Start.Location()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeEvent(comp *component, tzs timezones) (*Event, error) {
+	e := &Event{}
+	var dur *duration
+	for _, p := range comp.Props {
+		var err error
+		switch p.Name {
+		case "DTEND":
+			e.End, err = parseSingleDate(p, tzs)
+		case "DURATION":
+			var d duration
+			d, err = parseDuration(p.Value)
+			dur = &d
+		case "TRANSP":
+			e.Transparency = strings.ToUpper(p.Value)
+		case "RECURRENCE-ID":
+			e.RecurrenceID, err = parseSingleDate(p, tzs)
+		default:
+			err = e.decodeProperty(p, tzs)
+		}
+		if err != nil {
+			return nil, ErrInvalidICal
+		}
+	}
+	e.decodeAlarms(comp)
+	if e.Start == nil {
+		return nil, ErrInvalidICal
+	}
+	if e.End == nil && dur != nil {
+		start, err := e.Start.Time()
+		if err != nil {
+			return nil, ErrInvalidICal
+		}
+		e.End = e.Start.at(dur.addTo(start))
+	}
+	return e, e.validate()
+}
+
+// UpdateFromICal implements the Item interface.
+func (e *Event) UpdateFromICal(data []byte) error {
+	comps, tzs, err := decodeICal(data, "VEVENT")
+	if err != nil {
+		return err
+	}
+	var main *Event
+	var overrides []*Event
+	for _, comp := range comps {
+		ev, err := decodeEvent(comp, tzs)
+		if err != nil {
+			return err
+		}
+		if ev.RecurrenceID != nil {
+			overrides = append(overrides, ev)
+		} else if main == nil {
+			main = ev
+		} else {
+			return ErrInvalidICal
+		}
+	}
+	if main == nil {
+		return ErrInvalidICal
+	}
+	main.Overrides = overrides
+	main.DocID = e.DocID
+	main.DocRev = e.DocRev
+	main.Metadata = e.Metadata
+	*e = *main
+	return nil
+}
+
+func decodeTodo(comp *component, tzs timezones) (*Todo, error) {
+	t := &Todo{}
+	var dur *duration
+	for _, p := range comp.Props {
+		var err error
+		switch p.Name {
+		case "DUE":
+			t.Due, err = parseSingleDate(p, tzs)
+		case "DURATION":
+			var d duration
+			d, err = parseDuration(p.Value)
+			dur = &d
+		case "COMPLETED":
+			var completed time.Time
+			completed, err = time.Parse(icalDateTimeLayout, strings.TrimSuffix(p.Value, "Z"))
+			t.Completed = &completed
+		case "PRIORITY":
+			t.Priority, err = strconv.Atoi(p.Value)
+		case "PERCENT-COMPLETE":
+			t.PercentComplete, err = strconv.Atoi(p.Value)
+		case "RECURRENCE-ID":
+			// The modified occurrences of the recurring todos are not
+			// supported: they are ignored.
+			return nil, nil
+		default:
+			err = t.decodeProperty(p, tzs)
+		}
+		if err != nil {
+			return nil, ErrInvalidICal
+		}
+	}
+	t.decodeAlarms(comp)
+	if t.Due == nil && t.Start != nil && dur != nil {
+		start, err := t.Start.Time()
+		if err != nil {
+			return nil, ErrInvalidICal
+		}
+		t.Due = t.Start.at(dur.addTo(start))
+	}
+	return t, t.validate()
+}
+
+// UpdateFromICal implements the Item interface.
+func (t *Todo) UpdateFromICal(data []byte) error {
+	comps, tzs, err := decodeICal(data, "VTODO")
+	if err != nil {
+		return err
+	}
+	var main *Todo
+	for _, comp := range comps {
+		todo, err := decodeTodo(comp, tzs)
+		if err != nil {
+			return err
+		}
+		if todo == nil {
+			continue
+		}
+		if main != nil {
+			return ErrInvalidICal
+		}
+		main = todo
+	}
+	if main == nil {
+		return ErrInvalidICal
+	}
+	main.DocID = t.DocID
+	main.DocRev = t.DocRev
+	main.Metadata = t.Metadata
+	*t = *main
+	return nil
+}
+
+// ICal implements the Item interface.
+func (e *Event) ICal() []byte {
+	events := append([]*Event{e}, e.Overrides...)
+	var dates []*DateTime
+	for _, ev := range events {
+		dates = append(dates, ev.dates()...)
+		dates = append(dates, ev.End, ev.RecurrenceID)
+	}
+	props := e.calendarHeader(dates)
+	for _, ev := range events {
+		props = append(props, contentline.NewProperty("BEGIN", "VEVENT"))
+		props = append(props, ev.headProperties(e.Metadata)...)
+		props = appendDate(props, "DTSTART", ev.Start)
+		props = appendDate(props, "DTEND", ev.End)
+		props = appendDate(props, "RECURRENCE-ID", ev.RecurrenceID)
+		if ev.Transparency != "" {
+			props = append(props, contentline.NewProperty("TRANSP", ev.Transparency))
+		}
+		props = append(props, ev.bodyProperties()...)
+		props = append(props, contentline.NewProperty("END", "VEVENT"))
+	}
+	props = append(props, contentline.NewProperty("END", "VCALENDAR"))
+	return contentline.Encode(props)
+}
+
+// ICal implements the Item interface.
+func (t *Todo) ICal() []byte {
+	props := t.calendarHeader(append(t.dates(), t.Due))
+	props = append(props, contentline.NewProperty("BEGIN", "VTODO"))
+	props = append(props, t.headProperties(t.Metadata)...)
+	props = appendDate(props, "DTSTART", t.Start)
+	props = appendDate(props, "DUE", t.Due)
+	if t.Completed != nil {
+		props = append(props, contentline.NewProperty("COMPLETED", t.Completed.UTC().Format(icalDateTimeLayout)+"Z"))
+	}
+	if t.Priority > 0 {
+		props = append(props, contentline.NewProperty("PRIORITY", strconv.Itoa(t.Priority)))
+	}
+	if t.PercentComplete > 0 {
+		props = append(props, contentline.NewProperty("PERCENT-COMPLETE", strconv.Itoa(t.PercentComplete)))
+	}
+	props = append(props, t.bodyProperties()...)
+	props = append(props, contentline.NewProperty("END", "VTODO"))
+	props = append(props, contentline.NewProperty("END", "VCALENDAR"))
+	return contentline.Encode(props)
+}
+
+func (c *Component) dates() []*DateTime {
+	dates := []*DateTime{c.Start}
+	dates = append(dates, c.RDates...)
+	return append(dates, c.ExDates...)
+}
+
+// calendarHeader returns the first properties of the iCalendar object, with
+// the VTIMEZONE components for the timezones used by the dates.
+func (c *Component) calendarHeader(dates []*DateTime) []*contentline.Property {
+	props := []*contentline.Property{
+		contentline.NewProperty("BEGIN", "VCALENDAR"),
+		contentline.NewProperty("VERSION", "2.0"),
+		contentline.NewProperty("PRODID", ProdID),
+	}
+	year := time.Now().Year()
+	if c.Start != nil {
+		if t, err := c.Start.Time(); err == nil {
+			year = t.Year()
+		}
+	}
+	seen := make(map[string]bool)
+	var tzids []string
+	for _, d := range dates {
+		if d != nil && d.TimeZone != "" && !seen[d.TimeZone] {
+			seen[d.TimeZone] = true
+			tzids = append(tzids, d.TimeZone)
+		}
+	}
+	sort.Strings(tzids)
+	for _, tzid := range tzids {
+		props = append(props, vtimezone(tzid, year)...)
+	}
+	return props
+}
+
+// headProperties returns the UID and the properties for the dates of
+// creation and modification.
+func (c *Component) headProperties(md *metadata.CozyMetadata) []*contentline.Property {
+	props := []*contentline.Property{contentline.NewProperty("UID", c.UID)}
+	stamp := time.Now()
+	if md != nil && !md.UpdatedAt.IsZero() {
+		stamp = md.UpdatedAt
+	}
+	props = append(props, contentline.NewProperty("DTSTAMP", stamp.UTC().Format(icalDateTimeLayout)+"Z"))
+	if md != nil && !md.CreatedAt.IsZero() {
+		props = append(props,
+			contentline.NewProperty("CREATED", md.CreatedAt.UTC().Format(icalDateTimeLayout)+"Z"),
+			contentline.NewProperty("LAST-MODIFIED", stamp.UTC().Format(icalDateTimeLayout)+"Z"),
+		)
+	}
+	if c.Sequence > 0 {
+		props = append(props, contentline.NewProperty("SEQUENCE", strconv.Itoa(c.Sequence)))
+	}
+	return props
+}
+
+// bodyProperties returns the other properties of the component, the extra
+// ones, and the alarms.
+func (c *Component) bodyProperties() []*contentline.Property {
+	var props []*contentline.Property
+	texts := []struct{ name, value string }{
+		{"SUMMARY", c.Summary},
+		{"DESCRIPTION", c.Description},
+		{"LOCATION", c.Location},
+	}
+	for _, text := range texts {
+		if text.value != "" {
+			props = append(props, contentline.NewProperty(text.name, contentline.EscapeText(text.value)))
+		}
+	}
+	if c.Status != "" {
+		props = append(props, contentline.NewProperty("STATUS", c.Status))
+	}
+	if len(c.Categories) > 0 {
+		props = append(props, contentline.NewProperty("CATEGORIES", contentline.JoinText(c.Categories, ',')))
+	}
+	if c.RRule != "" {
+		props = append(props, contentline.NewProperty("RRULE", c.RRule))
+	}
+	for _, d := range c.RDates {
+		props = appendDate(props, "RDATE", d)
+	}
+	for _, d := range c.ExDates {
+		props = appendDate(props, "EXDATE", d)
+	}
+	props = appendExtra(props, c.Extra)
+	for _, alarm := range c.Alarms {
+		props = append(props, contentline.NewProperty("BEGIN", "VALARM"))
+		props = append(props, contentline.NewProperty("ACTION", alarm.Action))
+		trigger := contentline.NewProperty("TRIGGER", alarm.Trigger)
+		if t, err := time.Parse(time.RFC3339, alarm.Trigger); err == nil {
+			trigger.Value = t.UTC().Format(icalDateTimeLayout) + "Z"
+			trigger.Params.Add("VALUE", "DATE-TIME")
+		} else if alarm.Related == "END" {
+			trigger.Params.Add("RELATED", "END")
+		}
+		props = append(props, trigger)
+		if alarm.Description != "" {
+			props = append(props, contentline.NewProperty("DESCRIPTION", contentline.EscapeText(alarm.Description)))
+		}
+		props = appendExtra(props, alarm.Extra)
+		props = append(props, contentline.NewProperty("END", "VALARM"))
+	}
+	return props
+}
+
+func appendDate(props []*contentline.Property, name string, d *DateTime) []*contentline.Property {
+	if d == nil {
+		return props
+	}
+	if p := d.icalProperty(name); p != nil {
+		props = append(props, p)
+	}
+	return props
+}
+
+func appendExtra(props []*contentline.Property, lines []string) []*contentline.Property {
+	for _, line := range lines {
+		if p, err := contentline.ParseLine(line); err == nil {
+			props = append(props, p)
+		}
+	}
+	return props
+}
@@ -0,0 +1,151 @@
+package calendar
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const weeklyMeeting = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Client//EN\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:/mozilla.org/20050126_1/Europe/Paris\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:19701025T030000\r\n" +
+	"TZOFFSETFROM:+0200\r\n" +
+	"TZOFFSETTO:+0100\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meeting-1@example.com\r\n" +
+	"DTSTAMP:20240101T000000Z\r\n" +
+	"DTSTART;TZID=/mozilla.org/20050126_1/Europe/Paris:20240108T100000\r\n" +
+	"DURATION:PT1H\r\n" +
+	"SUMMARY:Weekly meeting\\, room 2\r\n" +
+	"RRULE:FREQ=WEEKLY;BYDAY=MO\r\n" +
+	"EXDATE;TZID=/mozilla.org/20050126_1/Europe/Paris:20240115T100000\r\n" +
+	"X-CUSTOM;FOO=bar:kept\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:meeting-1@example.com\r\n" +
+	"RECURRENCE-ID;TZID=/mozilla.org/20050126_1/Europe/Paris:20240122T100000\r\n" +
+	"DTSTART;TZID=/mozilla.org/20050126_1/Europe/Paris:20240123T140000\r\n" +
+	"DTEND;TZID=/mozilla.org/20050126_1/Europe/Paris:20240123T150000\r\n" +
+	"SUMMARY:Moved meeting\r\n" +
+	"STATUS:TENTATIVE\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestEventFromICal(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Paris"); err != nil {
+		t.Skip("the timezone database is not available")
+	}
+
+	e := &Event{DocID: "meeting-1"}
+	require.NoError(t, e.UpdateFromICal([]byte(weeklyMeeting)))
+	assert.Equal(t, "meeting-1", e.ID())
+	assert.Equal(t, "meeting-1@example.com", e.UID)
+	assert.Equal(t, "Weekly meeting, room 2", e.Summary)
+	assert.Equal(t, &DateTime{DateTime: "2024-01-08T10:00:00+01:00", TimeZone: "Europe/Paris"}, e.Start)
+	assert.Equal(t, &DateTime{DateTime: "2024-01-08T11:00:00+01:00", TimeZone: "Europe/Paris"}, e.End)
+	assert.Equal(t, "FREQ=WEEKLY;BYDAY=MO", e.RRule)
+	require.Len(t, e.ExDates, 1)
+	assert.Equal(t, []string{"X-CUSTOM;FOO=bar:kept"}, e.Extra)
+	require.Len(t, e.Alarms, 1)
+	assert.Equal(t, &Alarm{Action: "DISPLAY", Trigger: "-PT15M", Description: "Reminder"}, e.Alarms[0])
+	require.Len(t, e.Overrides, 1)
+	assert.Equal(t, "Moved meeting", e.Overrides[0].Summary)
+
+	ical := string(e.ICal())
+	assert.Contains(t, ical, "BEGIN:VTIMEZONE\r\nTZID:Europe/Paris\r\n")
+	assert.Contains(t, ical, "RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU\r\n")
+	assert.Contains(t, ical, "DTSTART;TZID=Europe/Paris:20240108T100000\r\n")
+	assert.Contains(t, ical, "SUMMARY:Weekly meeting\\, room 2\r\n")
+	assert.Contains(t, ical, "X-CUSTOM;FOO=bar:kept\r\n")
+	assert.Contains(t, ical, "BEGIN:VALARM\r\nACTION:DISPLAY\r\nTRIGGER:-PT15M\r\n")
+	assert.Contains(t, ical, "RECURRENCE-ID;TZID=Europe/Paris:20240122T100000\r\n")
+	assert.Equal(t, 2, strings.Count(ical, "BEGIN:VEVENT"))
+
+	again := &Event{}
+	require.NoError(t, again.UpdateFromICal([]byte(ical)))
+	assert.Equal(t, e.Start, again.Start)
+	assert.Equal(t, e.Overrides[0].Start, again.Overrides[0].Start)
+
+	assert.ErrorIs(t, e.UpdateFromICal([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")), ErrInvalidICal)
+	assert.ErrorIs(t, e.UpdateFromICal([]byte("BEGIN:VCARD\r\nEND:VCARD\r\n")), ErrInvalidICal)
+}
+
+func TestOccurrences(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Paris"); err != nil {
+		t.Skip("the timezone database is not available")
+	}
+
+	e := &Event{}
+	require.NoError(t, e.UpdateFromICal([]byte(weeklyMeeting)))
+	from := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+	occs, err := e.Occurrences(from, to)
+	require.NoError(t, err)
+	var starts []string
+	for _, occ := range occs {
+		starts = append(starts, occ.Start.UTC().Format(time.RFC3339))
+	}
+	assert.Equal(t, []string{
+		"2024-01-08T09:00:00Z",
+		"2024-01-23T13:00:00Z",
+		"2024-01-29T09:00:00Z",
+	}, starts)
+	assert.NotNil(t, occs[1].Override)
+
+	periods := FreeBusy([]*Event{e}, from, to)
+	require.Len(t, periods, 3)
+	assert.Equal(t, FreeBusyTentative, periods[1].Type)
+	fb := string(FreeBusyICal(periods, from, to))
+	assert.Contains(t, fb, "FREEBUSY;FBTYPE=BUSY:20240108T090000Z/20240108T100000Z,")
+	assert.Contains(t, fb, "FREEBUSY;FBTYPE=BUSY-TENTATIVE:20240123T130000Z/20240123T140000Z\r\n")
+
+	reminder := e.NextReminder(time.Date(2024, time.January, 16, 0, 0, 0, 0, time.UTC))
+	require.NotNil(t, reminder)
+	assert.Equal(t, "2024-01-29T08:45:00Z", reminder.At.UTC().Format(time.RFC3339))
+	assert.True(t, Overlaps(e, from, to))
+	assert.False(t, Overlaps(e, from.AddDate(-1, 0, 0), from.AddDate(0, 0, -1)))
+}
+
+func TestTodoFromICal(t *testing.T) {
+	data := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VTODO\r\n" +
+		"UID:todo-1\r\n" +
+		"SUMMARY:Buy milk\r\n" +
+		"DUE;VALUE=DATE:20240301\r\n" +
+		"PRIORITY:1\r\n" +
+		"STATUS:NEEDS-ACTION\r\n" +
+		"CATEGORIES:Home,Errands\r\n" +
+		"END:VTODO\r\n" +
+		"END:VCALENDAR\r\n"
+	todo := &Todo{}
+	require.NoError(t, todo.UpdateFromICal([]byte(data)))
+	assert.Equal(t, "Buy milk", todo.Summary)
+	assert.Equal(t, &DateTime{Date: "2024-03-01"}, todo.Due)
+	assert.Equal(t, 1, todo.Priority)
+	assert.Equal(t, []string{"Home", "Errands"}, todo.Categories)
+
+	ical := string(todo.ICal())
+	assert.Contains(t, ical, "BEGIN:VTODO\r\nUID:todo-1\r\n")
+	assert.Contains(t, ical, "DUE;VALUE=DATE:20240301\r\n")
+	assert.Contains(t, ical, "CATEGORIES:Home,Errands\r\n")
+
+	from := time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC)
+	assert.True(t, Overlaps(todo, from, from.AddDate(0, 1, 1)))
+	assert.False(t, Overlaps(todo, from, from.AddDate(0, 0, 7)))
+	assert.True(t, Overlaps(&Todo{}, from, from.AddDate(0, 0, 7)))
+}
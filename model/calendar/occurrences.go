@@ -0,0 +1,287 @@
+package calendar
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/contentline"
+)
+
+// maxOccurrences is the maximal number of occurrences returned for a
+// recurring event on a time range.
+const maxOccurrences = 1000
+
+// The types of the busy periods.
+const (
+	FreeBusyBusy      = "BUSY"
+	FreeBusyTentative = "BUSY-TENTATIVE"
+)
+
+// Occurrence is an instance of an event or todo. For a recurring event, the
+// recurrence ID is the original start of the instance, and the override is
+// set if this instance has been modified.
+type Occurrence struct {
+	Start        time.Time
+	End          time.Time
+	RecurrenceID time.Time
+	Override     *Event
+}
+
+// overlaps returns true if the period overlaps the time range, as defined by
+// RFC 4791. A zero end for the range means no limit.
+func overlaps(start, end, from, to time.Time) bool {
+	if !to.IsZero() && !start.Before(to) {
+		return false
+	}
+	if end.After(start) {
+		return end.After(from)
+	}
+	return !start.Before(from)
+}
+
+// lengthFunc returns the end of an occurrence from its start.
+type lengthFunc func(start time.Time) time.Time
+
+// lengthBetween returns the function that keeps the length between the two
+// dates. For the all-day dates, the length is a number of days.
+func lengthBetween(start, end *DateTime) lengthFunc {
+	if start == nil || end == nil {
+		if start != nil && start.IsDate() {
+			return func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+		}
+		return func(t time.Time) time.Time { return t }
+	}
+	s, err1 := start.Time()
+	e, err2 := end.Time()
+	if err1 != nil || err2 != nil || e.Before(s) {
+		return func(t time.Time) time.Time { return t }
+	}
+	if start.IsDate() {
+		days := int(e.Sub(s).Hours()+12) / 24
+		return func(t time.Time) time.Time { return t.AddDate(0, 0, days) }
+	}
+	d := e.Sub(s)
+	return func(t time.Time) time.Time { return t.Add(d) }
+}
+
+// expand returns the occurrences of the component that overlap the time
+// range. The exceptions are the recurrence IDs of the modified occurrences,
+// that are not generated by the rule.
+func (c *Component) expand(length lengthFunc, exceptions []time.Time, from, to time.Time) ([]Occurrence, error) {
+	start, err := c.Start.Time()
+	if err != nil {
+		return nil, err
+	}
+	excluded := make(map[int64]bool)
+	for _, t := range exceptions {
+		excluded[t.Unix()] = true
+	}
+	for _, d := range c.ExDates {
+		if t, err := d.Time(); err == nil {
+			excluded[t.Unix()] = true
+		}
+	}
+
+	var occs []Occurrence
+	seen := make(map[int64]bool)
+	add := func(t time.Time) {
+		if excluded[t.Unix()] || seen[t.Unix()] {
+			return
+		}
+		seen[t.Unix()] = true
+		end := length(t)
+		if overlaps(t, end, from, to) {
+			occs = append(occs, Occurrence{Start: t, End: end, RecurrenceID: t})
+		}
+	}
+
+	if c.RRule == "" {
+		add(start)
+	} else {
+		rule, err := ParseRRule(c.RRule, start.Location())
+		if err != nil {
+			return nil, err
+		}
+		rule.Iterate(start, func(t time.Time) bool {
+			if !to.IsZero() && !t.Before(to) {
+				return false
+			}
+			add(t)
+			return len(occs) < maxOccurrences
+		})
+	}
+	for _, d := range c.RDates {
+		if t, err := d.Time(); err == nil {
+			add(t)
+		}
+	}
+	return occs, nil
+}
+
+// Occurrences implements the Item interface. The occurrences that have been
+// cancelled by an override are not returned.
+func (e *Event) Occurrences(from, to time.Time) ([]Occurrence, error) {
+	if e.Start == nil {
+		return nil, nil
+	}
+	var exceptions []time.Time
+	for _, o := range e.Overrides {
+		if o.RecurrenceID == nil {
+			continue
+		}
+		if t, err := o.RecurrenceID.Time(); err == nil {
+			exceptions = append(exceptions, t)
+		}
+	}
+	occs, err := e.expand(lengthBetween(e.Start, e.End), exceptions, from, to)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range e.Overrides {
+		if o.Start == nil || o.RecurrenceID == nil || o.Status == StatusCancelled {
+			continue
+		}
+		start, err1 := o.Start.Time()
+		rid, err2 := o.RecurrenceID.Time()
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		end := lengthBetween(o.Start, o.End)(start)
+		if overlaps(start, end, from, to) {
+			occs = append(occs, Occurrence{Start: start, End: end, RecurrenceID: rid, Override: o})
+		}
+	}
+	sortOccurrences(occs)
+	return occs, nil
+}
+
+// Occurrences implements the Item interface. A todo with a due date and
+// without a start has a single occurrence, at the due date.
+func (t *Todo) Occurrences(from, to time.Time) ([]Occurrence, error) {
+	if t.Start == nil {
+		if t.Due == nil {
+			return nil, nil
+		}
+		due, err := t.Due.Time()
+		if err != nil {
+			return nil, err
+		}
+		if !overlaps(due, due, from, to) {
+			return nil, nil
+		}
+		return []Occurrence{{Start: due, End: due, RecurrenceID: due}}, nil
+	}
+	occs, err := t.expand(lengthBetween(t.Start, t.Due), nil, from, to)
+	if err != nil {
+		return nil, err
+	}
+	sortOccurrences(occs)
+	return occs, nil
+}
+
+func sortOccurrences(occs []Occurrence) {
+	sort.SliceStable(occs, func(i, j int) bool { return occs[i].Start.Before(occs[j].Start) })
+}
+
+// Overlaps returns true if the event or todo has an occurrence in the time
+// range. A todo without dates overlaps any time range.
+func Overlaps(item Item, from, to time.Time) bool {
+	if todo, ok := item.(*Todo); ok && todo.Start == nil && todo.Due == nil {
+		return true
+	}
+	occs, err := item.Occurrences(from, to)
+	return err == nil && len(occs) > 0
+}
+
+// BusyPeriod is a period of time where the owner of the calendar is busy.
+type BusyPeriod struct {
+	Start time.Time
+	End   time.Time
+	Type  string
+}
+
+// FreeBusy returns the busy periods in the time range, from the events that
+// are not transparent and not cancelled. The periods are sorted, and the
+// periods that overlap are merged.
+func FreeBusy(events []*Event, from, to time.Time) []BusyPeriod {
+	var periods []BusyPeriod
+	for _, e := range events {
+		occs, err := e.Occurrences(from, to)
+		if err != nil {
+			continue
+		}
+		for _, occ := range occs {
+			ev := e
+			if occ.Override != nil {
+				ev = occ.Override
+			}
+			if ev.Transparency == TranspTransparent || ev.Status == StatusCancelled {
+				continue
+			}
+			start, end := occ.Start, occ.End
+			if start.Before(from) {
+				start = from
+			}
+			if end.After(to) {
+				end = to
+			}
+			if !end.After(start) {
+				continue
+			}
+			kind := FreeBusyBusy
+			if ev.Status == StatusTentative {
+				kind = FreeBusyTentative
+			}
+			periods = append(periods, BusyPeriod{Start: start.UTC(), End: end.UTC(), Type: kind})
+		}
+	}
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Start.Before(periods[j].Start) })
+
+	var merged []BusyPeriod
+	last := make(map[string]int)
+	for _, p := range periods {
+		if i, ok := last[p.Type]; ok && !p.Start.After(merged[i].End) {
+			if p.End.After(merged[i].End) {
+				merged[i].End = p.End
+			}
+			continue
+		}
+		last[p.Type] = len(merged)
+		merged = append(merged, p)
+	}
+	return merged
+}
+
+// FreeBusyICal returns an iCalendar object with a VFREEBUSY component for the
+// busy periods.
+func FreeBusyICal(periods []BusyPeriod, from, to time.Time) []byte {
+	utc := func(t time.Time) string { return t.UTC().Format(icalDateTimeLayout) + "Z" }
+	props := []*contentline.Property{
+		contentline.NewProperty("BEGIN", "VCALENDAR"),
+		contentline.NewProperty("VERSION", "2.0"),
+		contentline.NewProperty("PRODID", ProdID),
+		contentline.NewProperty("BEGIN", "VFREEBUSY"),
+		contentline.NewProperty("DTSTAMP", utc(time.Now())),
+		contentline.NewProperty("DTSTART", utc(from)),
+		contentline.NewProperty("DTEND", utc(to)),
+	}
+	for _, kind := range []string{FreeBusyBusy, FreeBusyTentative} {
+		var values []string
+		for _, p := range periods {
+			if p.Type == kind {
+				values = append(values, utc(p.Start)+"/"+utc(p.End))
+			}
+		}
+		if len(values) > 0 {
+			p := contentline.NewProperty("FREEBUSY", strings.Join(values, ","))
+			p.Params.Add("FBTYPE", kind)
+			props = append(props, p)
+		}
+	}
+	props = append(props,
+		contentline.NewProperty("END", "VFREEBUSY"),
+		contentline.NewProperty("END", "VCALENDAR"),
+	)
+	return contentline.Encode(props)
+}
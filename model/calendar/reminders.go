@@ -0,0 +1,228 @@
+package calendar
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// RemindersWorker is the type of the worker that schedules and sends the
+// notifications for the alarms of the events and todos.
+const RemindersWorker = "calendar-reminders"
+
+// reminderHorizon is how far in the future the next reminder is looked for.
+const reminderHorizon = 366 * 24 * time.Hour
+
+// Reminder is an alarm for an occurrence of an event or todo.
+type Reminder struct {
+	At    time.Time
+	Start time.Time
+	Alarm *Alarm
+}
+
+// ReminderMessage is the message of the triggers for the reminders. The
+// @event triggers only have the doctype, and the @at triggers have the
+// document and the reminder.
+type ReminderMessage struct {
+	DocType string    `json:"doctype"`
+	DocID   string    `json:"doc_id,omitempty"`
+	At      time.Time `json:"at"`
+	Start   time.Time `json:"start"`
+}
+
+// alarmTime returns the time of the alarm for an occurrence. The second
+// value is false for an alarm that can't be used.
+func (a *Alarm) alarmTime(start, end time.Time) (time.Time, bool) {
+	if a.Action == "NONE" {
+		return time.Time{}, false
+	}
+	if !strings.Contains(strings.ToUpper(a.Trigger), "P") {
+		t, err := time.Parse(time.RFC3339, a.Trigger)
+		return t, err == nil
+	}
+	d, err := parseDuration(a.Trigger)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if a.Related == "END" {
+		return d.addTo(end), true
+	}
+	return d.addTo(start), true
+}
+
+// maxDelay returns the longest delay of the alarms after the start or end of
+// the occurrences.
+func maxDelay(alarms []*Alarm) time.Duration {
+	var delay time.Duration
+	for _, a := range alarms {
+		d, err := parseDuration(a.Trigger)
+		if err != nil {
+			continue
+		}
+		ref := time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+		if after := d.addTo(ref).Sub(ref); after > delay {
+			delay = after
+		}
+	}
+	return delay
+}
+
+// nextReminder returns the first reminder after the given time for the
+// occurrences of an item.
+func nextReminder(item Item, after time.Time) *Reminder {
+	alarms := item.Base().Alarms
+	if ev, ok := item.(*Event); ok {
+		for _, o := range ev.Overrides {
+			alarms = append(alarms, o.Alarms...)
+		}
+	}
+	if len(alarms) == 0 {
+		return nil
+	}
+	occs, err := item.Occurrences(after.Add(-maxDelay(alarms)), after.Add(reminderHorizon))
+	if err != nil {
+		return nil
+	}
+	var next *Reminder
+	for _, occ := range occs {
+		alarms := item.Base().Alarms
+		if occ.Override != nil {
+			alarms = occ.Override.Alarms
+		}
+		for _, a := range alarms {
+			at, ok := a.alarmTime(occ.Start, occ.End)
+			if !ok || !at.After(after) {
+				continue
+			}
+			if next == nil || at.Before(next.At) {
+				next = &Reminder{At: at, Start: occ.Start, Alarm: a}
+			}
+		}
+	}
+	return next
+}
+
+// NextReminder implements the Item interface.
+func (e *Event) NextReminder(after time.Time) *Reminder {
+	if e.Status == StatusCancelled {
+		return nil
+	}
+	return nextReminder(e, after)
+}
+
+// NextReminder implements the Item interface. There are no reminders for the
+// completed todos.
+func (t *Todo) NextReminder(after time.Time) *Reminder {
+	if t.Status == StatusCompleted || t.Status == StatusCancelled || t.Completed != nil {
+		return nil
+	}
+	return nextReminder(t, after)
+}
+
+// SetupTrigger creates the @event triggers that schedule the reminders when
+// the events and todos are modified, if they don't exist yet.
+func SetupTrigger(db prefixer.Prefixer) error {
+	sched := job.System()
+	for _, doctype := range []string{consts.CalendarEvents, consts.CalendarTodos} {
+		infos := job.TriggerInfos{
+			Type:       "@event",
+			WorkerType: RemindersWorker,
+			Arguments:  doctype + ":CREATED,UPDATED,DELETED",
+		}
+		if sched.HasTrigger(db, infos) {
+			continue
+		}
+		t, err := job.NewTrigger(db, infos, &ReminderMessage{DocType: doctype})
+		if err != nil {
+			return err
+		}
+		if err := sched.AddTrigger(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetupReminders creates the triggers for the reminders of an instance that
+// already has events and todos: the @event triggers, and the @at trigger for
+// the next reminder of each item.
+func SetupReminders(db prefixer.Prefixer) error {
+	if err := SetupTrigger(db); err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, doctype := range []string{consts.CalendarEvents, consts.CalendarTodos} {
+		items, err := ListItems(db, doctype)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if item.NextReminder(now) == nil {
+				continue
+			}
+			if err := ScheduleReminder(db, doctype, item.ID(), item, now); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ScheduleReminder replaces the @at trigger for the next reminder of an event
+// or todo. The item is nil when the document has been deleted.
+func ScheduleReminder(db prefixer.Prefixer, doctype, id string, item Item, after time.Time) error {
+	sched := job.System()
+	var triggers []*job.TriggerInfos
+	req := &couchdb.FindRequest{
+		UseIndex: "by-worker-type-and-doc-id",
+		Selector: mango.And(
+			mango.Equal("worker", RemindersWorker),
+			mango.Equal("type", "@at"),
+			mango.Equal("message.doc_id", id),
+		),
+		Limit: 100,
+	}
+	err := couchdb.FindDocs(db, consts.Triggers, req, &triggers)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+	for _, infos := range triggers {
+		var msg ReminderMessage
+		if err := infos.Message.Unmarshal(&msg); err != nil {
+			continue
+		}
+		if msg.DocType == doctype {
+			if err := sched.DeleteTrigger(db, infos.TID); err != nil {
+				return err
+			}
+		}
+	}
+
+	if item == nil {
+		return nil
+	}
+	reminder := item.NextReminder(after)
+	if reminder == nil {
+		return nil
+	}
+	msg := &ReminderMessage{
+		DocType: doctype,
+		DocID:   id,
+		At:      reminder.At,
+		Start:   reminder.Start,
+	}
+	t, err := job.NewTrigger(db, job.TriggerInfos{
+		Type:       "@at",
+		WorkerType: RemindersWorker,
+		Arguments:  reminder.At.UTC().Format(time.RFC3339),
+	}, msg)
+	if err != nil {
+		return err
+	}
+	return sched.AddTrigger(t)
+}
@@ -0,0 +1,432 @@
+package calendar
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The frequencies of the recurrence rules.
+const (
+	FreqDaily   = "DAILY"
+	FreqWeekly  = "WEEKLY"
+	FreqMonthly = "MONTHLY"
+	FreqYearly  = "YEARLY"
+)
+
+var (
+	// ErrInvalidRRule is used when a recurrence rule can't be parsed.
+	ErrInvalidRRule = errors.New("Invalid recurrence rule")
+	// ErrUnsupportedRRule is used for the recurrence rules with a frequency
+	// under a day (HOURLY, MINUTELY and SECONDLY).
+	ErrUnsupportedRRule = errors.New("Unsupported recurrence rule")
+)
+
+// maxEmptyPeriods is the number of consecutive periods without occurrences
+// after which the iteration stops, for the rules that can't match any date
+// like FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30.
+const maxEmptyPeriods = 1000
+
+// maxTimesPerDay is the maximal number of times in a day that a rule can
+// generate with its BYHOUR, BYMINUTE and BYSECOND parts. The rules with more
+// times are refused, like the rules with a frequency under a day.
+const maxTimesPerDay = 100
+
+// maxIterations bounds the work of an iteration, whatever the number of
+// occurrences kept by the caller: it counts the days examined and the times
+// generated for them. It protects the stack from the rules with a DTSTART
+// far in the past or with many BYxxx values.
+const maxIterations = 500000
+
+// WeekdayNum is a weekday of a BYDAY part, with an optional ordinal: 2MO is
+// the second Monday, -1FR is the last Friday.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// RRule is a recurrence rule, as defined by RFC 5545.
+type RRule struct {
+	Freq       string
+	Interval   int
+	Count      int
+	Until      time.Time
+	ByMonth    []int
+	ByWeekNo   []int
+	ByYearDay  []int
+	ByMonthDay []int
+	ByDay      []WeekdayNum
+	ByHour     []int
+	ByMinute   []int
+	BySecond   []int
+	BySetPos   []int
+	WeekStart  time.Weekday
+}
+
+// ParseRRule parses a recurrence rule, like FREQ=WEEKLY;BYDAY=MO,WE. The
+// location is used for an UNTIL without timezone.
+func ParseRRule(s string, loc *time.Location) (*RRule, error) {
+	r := &RRule{Interval: 1, WeekStart: time.Monday}
+	for _, part := range strings.Split(strings.TrimPrefix(s, "RRULE:"), ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, ErrInvalidRRule
+		}
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+		var err error
+		switch key {
+		case "FREQ":
+			r.Freq = value
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+			if err == nil && r.Interval < 1 {
+				err = ErrInvalidRRule
+			}
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+			if err == nil && r.Count < 1 {
+				err = ErrInvalidRRule
+			}
+		case "UNTIL":
+			r.Until, err = parseUntil(value, loc)
+		case "BYMONTH":
+			r.ByMonth, err = parseInts(value, 1, 12, false)
+		case "BYWEEKNO":
+			r.ByWeekNo, err = parseInts(value, 1, 53, true)
+		case "BYYEARDAY":
+			r.ByYearDay, err = parseInts(value, 1, 366, true)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(value, 1, 31, true)
+		case "BYDAY":
+			r.ByDay, err = parseWeekdays(value)
+		case "BYHOUR":
+			r.ByHour, err = parseInts(value, 0, 23, false)
+		case "BYMINUTE":
+			r.ByMinute, err = parseInts(value, 0, 59, false)
+		case "BYSECOND":
+			r.BySecond, err = parseInts(value, 0, 60, false)
+		case "BYSETPOS":
+			r.BySetPos, err = parseInts(value, 1, 366, true)
+		case "WKST":
+			var day time.Weekday
+			day, err = parseWeekday(value)
+			r.WeekStart = day
+		}
+		if err != nil {
+			return nil, ErrInvalidRRule
+		}
+	}
+	switch r.Freq {
+	case FreqDaily, FreqWeekly, FreqMonthly, FreqYearly:
+	case "HOURLY", "MINUTELY", "SECONDLY":
+		return nil, ErrUnsupportedRRule
+	default:
+		return nil, ErrInvalidRRule
+	}
+	timesPerDay := max(len(r.ByHour), 1) * max(len(r.ByMinute), 1) * max(len(r.BySecond), 1)
+	if timesPerDay > maxTimesPerDay {
+		return nil, ErrUnsupportedRRule
+	}
+	return r, nil
+}
+
+// parseUntil parses the UNTIL part. A date is inclusive: the occurrences of
+// this day are kept.
+func parseUntil(value string, loc *time.Location) (time.Time, error) {
+	if len(value) == len(icalDateLayout) {
+		t, err := time.ParseInLocation(icalDateLayout, value, loc)
+		if err != nil {
+			return t, err
+		}
+		return t.AddDate(0, 0, 1).Add(-time.Nanosecond), nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(icalDateTimeLayout, strings.TrimSuffix(value, "Z"))
+	}
+	return time.ParseInLocation(icalDateTimeLayout, value, loc)
+}
+
+func parseInts(value string, minimum, maximum int, negative bool) ([]int, error) {
+	var list []int
+	for _, s := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		abs := n
+		if negative && n < 0 {
+			abs = -n
+		}
+		if abs < minimum || abs > maximum {
+			return nil, ErrInvalidRRule
+		}
+		list = append(list, n)
+	}
+	sort.Ints(list)
+	return list, nil
+}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	for i, name := range icalWeekdays {
+		if s == name {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, ErrInvalidRRule
+}
+
+func parseWeekdays(value string) ([]WeekdayNum, error) {
+	var list []WeekdayNum
+	for _, s := range strings.Split(value, ",") {
+		if len(s) < 2 {
+			return nil, ErrInvalidRRule
+		}
+		day, err := parseWeekday(s[len(s)-2:])
+		if err != nil {
+			return nil, err
+		}
+		wd := WeekdayNum{Day: day}
+		if prefix := s[:len(s)-2]; prefix != "" {
+			wd.N, err = strconv.Atoi(prefix)
+			if err != nil || wd.N == 0 || wd.N > 53 || wd.N < -53 {
+				return nil, ErrInvalidRRule
+			}
+		}
+		list = append(list, wd)
+	}
+	return list, nil
+}
+
+// Iterate calls fn with the occurrences of the rule in chronological order,
+// starting with dtstart, until the end of the rule or fn returns false. The
+// occurrences are in the location of dtstart. The iteration also stops after
+// maxIterations days and times have been examined.
+func (r *RRule) Iterate(dtstart time.Time, fn func(time.Time) bool) {
+	count := 0
+	emit := func(t time.Time) bool {
+		if !r.Until.IsZero() && t.After(r.Until) {
+			return false
+		}
+		if r.Count > 0 && count >= r.Count {
+			return false
+		}
+		count++
+		return fn(t)
+	}
+	if !emit(dtstart) {
+		return
+	}
+
+	empty, work := 0, 0
+	for period := 0; empty < maxEmptyPeriods; period++ {
+		days := r.periodDays(dtstart, period)
+		// The days are in UTC, and the timezones can be up to 14 hours
+		// ahead: a margin is kept to not stop too early.
+		if !r.Until.IsZero() && len(days) > 0 && days[0].AddDate(0, 0, -1).After(r.Until) {
+			return
+		}
+		candidates := r.candidates(dtstart, days)
+		work += len(days) + len(candidates)
+		if work > maxIterations {
+			return
+		}
+		found := false
+		for _, t := range r.setPos(candidates) {
+			if !t.After(dtstart) {
+				continue
+			}
+			found = true
+			if !emit(t) {
+				return
+			}
+		}
+		if found {
+			empty = 0
+		} else {
+			empty++
+		}
+	}
+}
+
+// periodDays returns the days of the n-th period of the rule, as times at
+// midnight UTC.
+func (r *RRule) periodDays(dtstart time.Time, n int) []time.Time {
+	start := time.Date(dtstart.Year(), dtstart.Month(), dtstart.Day(), 0, 0, 0, 0, time.UTC)
+	var first, end time.Time
+	switch r.Freq {
+	case FreqDaily:
+		first = start.AddDate(0, 0, n*r.Interval)
+		end = first.AddDate(0, 0, 1)
+	case FreqWeekly:
+		shift := (int(start.Weekday()) - int(r.WeekStart) + 7) % 7
+		first = start.AddDate(0, 0, n*r.Interval*7-shift)
+		end = first.AddDate(0, 0, 7)
+	case FreqMonthly:
+		first = time.Date(start.Year(), start.Month()+time.Month(n*r.Interval), 1, 0, 0, 0, 0, time.UTC)
+		end = first.AddDate(0, 1, 0)
+	default:
+		first = time.Date(start.Year()+n*r.Interval, time.January, 1, 0, 0, 0, 0, time.UTC)
+		end = first.AddDate(1, 0, 0)
+	}
+	days := make([]time.Time, 0, 31)
+	for d := first; d.Before(end); d = d.AddDate(0, 0, 1) {
+		days = append(days, d)
+	}
+	return days
+}
+
+// candidates returns the times of the days that match the BYxxx parts.
+func (r *RRule) candidates(dtstart time.Time, days []time.Time) []time.Time {
+	hours := r.ByHour
+	if len(hours) == 0 {
+		hours = []int{dtstart.Hour()}
+	}
+	minutes := r.ByMinute
+	if len(minutes) == 0 {
+		minutes = []int{dtstart.Minute()}
+	}
+	seconds := r.BySecond
+	if len(seconds) == 0 {
+		seconds = []int{dtstart.Second()}
+	}
+	var list []time.Time
+	for _, d := range days {
+		if !r.matchDay(dtstart, d) {
+			continue
+		}
+		for _, h := range hours {
+			for _, m := range minutes {
+				for _, s := range seconds {
+					list = append(list, time.Date(d.Year(), d.Month(), d.Day(), h, m, s, 0, dtstart.Location()))
+				}
+			}
+		}
+	}
+	return list
+}
+
+// matchDay returns true if the day matches the BYxxx parts of the rule. When
+// there are no such parts, the day of dtstart is used: same weekday for a
+// weekly rule, same day of the month for a monthly rule, etc.
+func (r *RRule) matchDay(dtstart, d time.Time) bool {
+	if len(r.ByMonth) > 0 && !containsInt(r.ByMonth, int(d.Month())) {
+		return false
+	}
+	if len(r.ByWeekNo) > 0 {
+		year, week := d.ISOWeek()
+		_, weeks := time.Date(year, time.December, 28, 0, 0, 0, 0, time.UTC).ISOWeek()
+		if !matchOrdinal(r.ByWeekNo, week, weeks) {
+			return false
+		}
+	}
+	if len(r.ByYearDay) > 0 && !matchOrdinal(r.ByYearDay, d.YearDay(), daysIn(d.Year(), 0)) {
+		return false
+	}
+	if len(r.ByMonthDay) > 0 && !matchOrdinal(r.ByMonthDay, d.Day(), daysIn(d.Year(), d.Month())) {
+		return false
+	}
+	if len(r.ByDay) > 0 && !r.matchWeekday(d) {
+		return false
+	}
+
+	noDayParts := len(r.ByWeekNo) == 0 && len(r.ByYearDay) == 0 &&
+		len(r.ByMonthDay) == 0 && len(r.ByDay) == 0
+	switch r.Freq {
+	case FreqWeekly:
+		if len(r.ByDay) == 0 && d.Weekday() != dtstart.Weekday() {
+			return false
+		}
+	case FreqMonthly:
+		if noDayParts && d.Day() != dtstart.Day() {
+			return false
+		}
+	case FreqYearly:
+		if noDayParts {
+			if d.Day() != dtstart.Day() {
+				return false
+			}
+			if len(r.ByMonth) == 0 && d.Month() != dtstart.Month() {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matchWeekday checks the BYDAY part. The ordinals are relative to the month
+// for a monthly rule, or for a yearly rule with BYMONTH, and to the year for
+// the other yearly rules.
+func (r *RRule) matchWeekday(d time.Time) bool {
+	for _, wd := range r.ByDay {
+		if wd.Day != d.Weekday() {
+			continue
+		}
+		if wd.N == 0 || (r.Freq != FreqMonthly && r.Freq != FreqYearly) || len(r.ByWeekNo) > 0 {
+			return true
+		}
+		pos, length := d.Day(), daysIn(d.Year(), d.Month())
+		if r.Freq == FreqYearly && len(r.ByMonth) == 0 {
+			pos, length = d.YearDay(), daysIn(d.Year(), 0)
+		}
+		if wd.N > 0 && (pos-1)/7+1 == wd.N {
+			return true
+		}
+		if wd.N < 0 && (length-pos)/7+1 == -wd.N {
+			return true
+		}
+	}
+	return false
+}
+
+// setPos applies the BYSETPOS part on the candidates of a period.
+func (r *RRule) setPos(list []time.Time) []time.Time {
+	if len(r.BySetPos) == 0 || len(list) == 0 {
+		return list
+	}
+	var selected []time.Time
+	for _, pos := range r.BySetPos {
+		i := pos - 1
+		if pos < 0 {
+			i = len(list) + pos
+		}
+		if i >= 0 && i < len(list) {
+			selected = append(selected, list[i])
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Before(selected[j]) })
+	return selected
+}
+
+// matchOrdinal returns true if the position (starting at 1) is in the list,
+// where the negative values are counted from the end.
+func matchOrdinal(list []int, pos, length int) bool {
+	for _, n := range list {
+		if n == pos || (n < 0 && length+n+1 == pos) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+// daysIn returns the number of days in the month, or in the year if month is
+// 0.
+func daysIn(year int, month time.Month) int {
+	if month == 0 {
+		return time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	}
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
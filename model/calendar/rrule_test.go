@@ -0,0 +1,143 @@
+package calendar
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func expandRule(t *testing.T, rule string, dtstart time.Time, limit int) []string {
+	t.Helper()
+	r, err := ParseRRule(rule, dtstart.Location())
+	require.NoError(t, err)
+	var list []string
+	r.Iterate(dtstart, func(occ time.Time) bool {
+		list = append(list, occ.Format("2006-01-02 15:04 MST"))
+		return len(list) < limit
+	})
+	return list
+}
+
+func TestParseRRule(t *testing.T) {
+	r, err := ParseRRule("FREQ=MONTHLY;INTERVAL=2;BYDAY=-1FR,2MO;COUNT=10;WKST=SU", time.UTC)
+	require.NoError(t, err)
+	assert.Equal(t, FreqMonthly, r.Freq)
+	assert.Equal(t, 2, r.Interval)
+	assert.Equal(t, 10, r.Count)
+	assert.Equal(t, time.Sunday, r.WeekStart)
+	assert.Equal(t, []WeekdayNum{{N: -1, Day: time.Friday}, {N: 2, Day: time.Monday}}, r.ByDay)
+
+	_, err = ParseRRule("FREQ=HOURLY", time.UTC)
+	assert.ErrorIs(t, err, ErrUnsupportedRRule)
+	_, err = ParseRRule("FREQ=WEEKLY;BYDAY=XX", time.UTC)
+	assert.ErrorIs(t, err, ErrInvalidRRule)
+	_, err = ParseRRule("BYDAY=MO", time.UTC)
+	assert.ErrorIs(t, err, ErrInvalidRRule)
+	_, err = ParseRRule("FREQ=DAILY;INTERVAL=0", time.UTC)
+	assert.ErrorIs(t, err, ErrInvalidRRule)
+
+	// Too many times in a day
+	_, err = ParseRRule("FREQ=DAILY;BYHOUR=8,12,18;BYMINUTE=0,15,30,45", time.UTC)
+	assert.NoError(t, err)
+	_, err = ParseRRule("FREQ=DAILY;BYHOUR=0,1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23;BYMINUTE=0,10,20,30,40,50", time.UTC)
+	assert.ErrorIs(t, err, ErrUnsupportedRRule)
+}
+
+func TestIterate(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("the timezone database is not available")
+	}
+
+	t.Run("Daily", func(t *testing.T) {
+		start := time.Date(2024, time.March, 29, 9, 0, 0, 0, paris)
+		assert.Equal(t, []string{
+			"2024-03-29 09:00 CET",
+			"2024-03-30 09:00 CET",
+			"2024-03-31 09:00 CEST",
+			"2024-04-01 09:00 CEST",
+		}, expandRule(t, "FREQ=DAILY;COUNT=4", start, 10))
+	})
+
+	t.Run("WeeklyByDay", func(t *testing.T) {
+		start := time.Date(2024, time.January, 3, 10, 0, 0, 0, time.UTC) // Wednesday
+		assert.Equal(t, []string{
+			"2024-01-03 10:00 UTC",
+			"2024-01-05 10:00 UTC",
+			"2024-01-15 10:00 UTC",
+			"2024-01-17 10:00 UTC",
+			"2024-01-19 10:00 UTC",
+		}, expandRule(t, "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE,FR;UNTIL=20240119T100000Z", start, 10))
+	})
+
+	t.Run("MonthlyLastFriday", func(t *testing.T) {
+		start := time.Date(2024, time.January, 26, 18, 0, 0, 0, time.UTC)
+		assert.Equal(t, []string{
+			"2024-01-26 18:00 UTC",
+			"2024-02-23 18:00 UTC",
+			"2024-03-29 18:00 UTC",
+		}, expandRule(t, "FREQ=MONTHLY;BYDAY=-1FR", start, 3))
+	})
+
+	t.Run("MonthlySkipsShortMonths", func(t *testing.T) {
+		start := time.Date(2024, time.January, 31, 8, 0, 0, 0, time.UTC)
+		assert.Equal(t, []string{
+			"2024-01-31 08:00 UTC",
+			"2024-03-31 08:00 UTC",
+			"2024-05-31 08:00 UTC",
+		}, expandRule(t, "FREQ=MONTHLY", start, 3))
+	})
+
+	t.Run("MonthlyLastWorkday", func(t *testing.T) {
+		start := time.Date(2024, time.May, 31, 12, 0, 0, 0, time.UTC)
+		assert.Equal(t, []string{
+			"2024-05-31 12:00 UTC",
+			"2024-06-28 12:00 UTC",
+			"2024-07-31 12:00 UTC",
+			"2024-08-30 12:00 UTC",
+		}, expandRule(t, "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", start, 4))
+	})
+
+	t.Run("YearlyBirthday", func(t *testing.T) {
+		start := time.Date(2020, time.February, 29, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, []string{
+			"2020-02-29 00:00 UTC",
+			"2024-02-29 00:00 UTC",
+			"2028-02-29 00:00 UTC",
+		}, expandRule(t, "FREQ=YEARLY", start, 3))
+	})
+
+	t.Run("YearlyThanksgiving", func(t *testing.T) {
+		start := time.Date(2023, time.November, 23, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, []string{
+			"2023-11-23 00:00 UTC",
+			"2024-11-28 00:00 UTC",
+			"2025-11-27 00:00 UTC",
+		}, expandRule(t, "FREQ=YEARLY;BYMONTH=11;BYDAY=4TH", start, 3))
+	})
+
+	t.Run("Impossible", func(t *testing.T) {
+		start := time.Date(2024, time.January, 30, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, []string{
+			"2024-01-30 00:00 UTC",
+		}, expandRule(t, "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", start, 3))
+	})
+}
+
+func TestIterateIsBounded(t *testing.T) {
+	// A daily rule that starts a long time ago stops before reaching today
+	r, err := ParseRRule("FREQ=DAILY;BYHOUR=0,6,12,18;BYMINUTE=0,10,20,30,40,50", time.UTC)
+	require.NoError(t, err)
+	start := time.Date(1800, time.January, 1, 0, 0, 0, 0, time.UTC)
+	var last time.Time
+	calls := 0
+	r.Iterate(start, func(occ time.Time) bool {
+		last = occ
+		calls++
+		return true
+	})
+	assert.Less(t, calls, maxIterations)
+	assert.True(t, last.Before(time.Date(1900, time.January, 1, 0, 0, 0, 0, time.UTC)))
+}
@@ -0,0 +1,230 @@
+package calendar
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/contentline"
+)
+
+// windowsZones maps the names of the most common Windows timezones, used by
+// Outlook and Exchange, to the IANA timezones.
+var windowsZones = map[string]string{
+	"UTC":                            "UTC",
+	"GMT Standard Time":              "Europe/London",
+	"Greenwich Standard Time":        "Atlantic/Reykjavik",
+	"W. Europe Standard Time":        "Europe/Berlin",
+	"Romance Standard Time":          "Europe/Paris",
+	"Central Europe Standard Time":   "Europe/Budapest",
+	"Central European Standard Time": "Europe/Warsaw",
+	"E. Europe Standard Time":        "Europe/Chisinau",
+	"FLE Standard Time":              "Europe/Kiev",
+	"GTB Standard Time":              "Europe/Bucharest",
+	"Russian Standard Time":          "Europe/Moscow",
+	"Eastern Standard Time":          "America/New_York",
+	"Central Standard Time":          "America/Chicago",
+	"Mountain Standard Time":         "America/Denver",
+	"Pacific Standard Time":          "America/Los_Angeles",
+	"India Standard Time":            "Asia/Kolkata",
+	"China Standard Time":            "Asia/Shanghai",
+	"Tokyo Standard Time":            "Asia/Tokyo",
+	"AUS Eastern Standard Time":      "Australia/Sydney",
+}
+
+// timezones are the VTIMEZONE components of an iCalendar object, by TZID.
+type timezones map[string]*component
+
+// resolve returns the location for a TZID, and its IANA name. The TZID is
+// often an IANA name, but some clients add a prefix (like
+// /mozilla.org/20050126_1/Europe/Paris) or use the Windows names. When the
+// timezone is unknown, the offset of the VTIMEZONE is used, and the IANA name
+// is empty.
+func (tzs timezones) resolve(tzid string) (*time.Location, string) {
+	if loc, name, ok := loadLocation(tzid); ok {
+		return loc, name
+	}
+	vtz := tzs[tzid]
+	if vtz == nil {
+		return time.UTC, ""
+	}
+	if p := vtz.property("X-LIC-LOCATION"); p != nil {
+		if loc, name, ok := loadLocation(p.Value); ok {
+			return loc, name
+		}
+	}
+	for _, name := range []string{"STANDARD", "DAYLIGHT"} {
+		for _, child := range vtz.Children {
+			if child.Name != name {
+				continue
+			}
+			if p := child.property("TZOFFSETTO"); p != nil {
+				if offset, err := parseOffset(p.Value); err == nil {
+					return time.FixedZone(tzid, offset), ""
+				}
+			}
+		}
+	}
+	return time.UTC, ""
+}
+
+func loadLocation(tzid string) (*time.Location, string, bool) {
+	tzid = strings.Trim(tzid, `" `)
+	if tzid == "" || tzid == "Local" {
+		return nil, "", false
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		tzid = name
+	}
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc, tzid, true
+	}
+	parts := strings.Split(tzid, "/")
+	for i := 1; i < len(parts); i++ {
+		name := strings.Join(parts[i:], "/")
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc, name, true
+		}
+	}
+	return nil, "", false
+}
+
+// parseOffset parses an UTC offset like +0100 or -053000, and returns it in
+// seconds.
+func parseOffset(s string) (int, error) {
+	var sign, hours, minutes, seconds int
+	if len(s) != 5 && len(s) != 7 {
+		return 0, fmt.Errorf("Invalid offset %q", s)
+	}
+	switch s[0] {
+	case '+':
+		sign = 1
+	case '-':
+		sign = -1
+	default:
+		return 0, fmt.Errorf("Invalid offset %q", s)
+	}
+	if _, err := fmt.Sscanf(s[1:5], "%02d%02d", &hours, &minutes); err != nil {
+		return 0, err
+	}
+	if len(s) == 7 {
+		if _, err := fmt.Sscanf(s[5:], "%02d", &seconds); err != nil {
+			return 0, err
+		}
+	}
+	return sign * (hours*3600 + minutes*60 + seconds), nil
+}
+
+func formatOffset(offset int) string {
+	sign := '+'
+	if offset < 0 {
+		sign = '-'
+		offset = -offset
+	}
+	s := fmt.Sprintf("%c%02d%02d", sign, offset/3600, offset/60%60)
+	if offset%60 != 0 {
+		s += fmt.Sprintf("%02d", offset%60)
+	}
+	return s
+}
+
+// transition is a change of the UTC offset of a timezone.
+type transition struct {
+	at   time.Time
+	from int
+	to   int
+	name string
+}
+
+// transitions returns the changes of offset of the location during a year.
+func transitions(loc *time.Location, year int) []transition {
+	var list []transition
+	prev := time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+	end := time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	_, prevOffset := prev.In(loc).Zone()
+	for t := prev.Add(24 * time.Hour); !t.After(end); t = t.Add(24 * time.Hour) {
+		name, offset := t.In(loc).Zone()
+		if offset != prevOffset {
+			// Bisect to find the exact instant of the change
+			lo, hi := prev, t
+			for hi.Sub(lo) > time.Second {
+				mid := lo.Add(hi.Sub(lo) / 2)
+				if _, o := mid.In(loc).Zone(); o == prevOffset {
+					lo = mid
+				} else {
+					hi = mid
+				}
+			}
+			list = append(list, transition{at: hi, from: prevOffset, to: offset, name: name})
+			prevOffset = offset
+		}
+		prev = t
+	}
+	return list
+}
+
+var icalWeekdays = [...]string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// vtimezone returns the VTIMEZONE component for an IANA timezone, with the
+// rules of the given year. The transitions are described by yearly rules,
+// like the last Sunday of March.
+func vtimezone(tzid string, year int) []*contentline.Property {
+	loc, err := time.LoadLocation(tzid)
+	if err != nil {
+		return nil
+	}
+	props := []*contentline.Property{
+		contentline.NewProperty("BEGIN", "VTIMEZONE"),
+		contentline.NewProperty("TZID", tzid),
+	}
+	list := transitions(loc, year)
+	if len(list) == 0 {
+		name, offset := time.Date(year, time.January, 1, 0, 0, 0, 0, loc).Zone()
+		return append(props,
+			contentline.NewProperty("BEGIN", "STANDARD"),
+			contentline.NewProperty("DTSTART", "19700101T000000"),
+			contentline.NewProperty("TZOFFSETFROM", formatOffset(offset)),
+			contentline.NewProperty("TZOFFSETTO", formatOffset(offset)),
+			contentline.NewProperty("TZNAME", name),
+			contentline.NewProperty("END", "STANDARD"),
+			contentline.NewProperty("END", "VTIMEZONE"),
+		)
+	}
+	maxOffset := list[0].to
+	for _, tr := range list {
+		if tr.to > maxOffset {
+			maxOffset = tr.to
+		}
+	}
+	for _, tr := range list {
+		kind := "STANDARD"
+		if len(list) > 1 && tr.to == maxOffset {
+			kind = "DAYLIGHT"
+		}
+		local := tr.at.In(time.FixedZone("", tr.from))
+		props = append(props,
+			contentline.NewProperty("BEGIN", kind),
+			contentline.NewProperty("DTSTART", local.Format(icalDateTimeLayout)),
+			contentline.NewProperty("TZOFFSETFROM", formatOffset(tr.from)),
+			contentline.NewProperty("TZOFFSETTO", formatOffset(tr.to)),
+		)
+		if len(list) > 1 {
+			props = append(props, contentline.NewProperty("RRULE", yearlyRule(local)))
+		}
+		props = append(props,
+			contentline.NewProperty("TZNAME", tr.name),
+			contentline.NewProperty("END", kind),
+		)
+	}
+	return append(props, contentline.NewProperty("END", "VTIMEZONE"))
+}
+
+// yearlyRule returns a rule for the same weekday of the same month each year:
+// the last one if it is in the last 7 days of the month, or else the n-th.
+func yearlyRule(t time.Time) string {
+	nth := (t.Day()-1)/7 + 1
+	if t.AddDate(0, 0, 7).Month() != t.Month() {
+		nth = -1
+	}
+	return fmt.Sprintf("FREQ=YEARLY;BYMONTH=%d;BYDAY=%d%s", int(t.Month()), nth, icalWeekdays[t.Weekday()])
+}
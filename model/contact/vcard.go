@@ -0,0 +1,509 @@
+package contact
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/contentline"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// VCardKey is the key of the contact document where the vCard properties that
+// have no equivalent in the JSON fields are kept, with the UID of the vCard,
+// so that they can be sent back to the CardDAV clients.
+const VCardKey = "vcard"
+
+// vCardProdID is the product identifier used for the vCards.
+const vCardProdID = "-//Cozy Cloud//Cozy Stack//EN"
+
+// ErrInvalidVCard is used when a vCard can't be parsed.
+var ErrInvalidVCard = errors.New("Invalid vCard")
+
+// convertedProperties are the vCard properties that are converted to and
+// from the JSON fields of the contact. The other properties are kept as is.
+var convertedProperties = map[string]bool{
+	"BEGIN":      true,
+	"END":        true,
+	"VERSION":    true,
+	"PRODID":     true,
+	"UID":        true,
+	"REV":        true,
+	"FN":         true,
+	"N":          true,
+	"EMAIL":      true,
+	"TEL":        true,
+	"ADR":        true,
+	"ORG":        true,
+	"TITLE":      true,
+	"BDAY":       true,
+	"NOTE":       true,
+	"CATEGORIES": true,
+	"X-COZY-URL": true,
+}
+
+var (
+	isoDate   = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	basicDate = regexp.MustCompile(`^\d{8}$`)
+)
+
+// ListGroups returns all the groups of contacts.
+func ListGroups(db prefixer.Prefixer) ([]*Group, error) {
+	var groups []*Group
+	err := couchdb.GetAllDocs(db, consts.Groups, nil, &groups)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// ToVCard returns the contact as a vCard 4.0. The groups are used to convert
+// the relationships to the CATEGORIES property.
+func (c *Contact) ToVCard(groups []*Group) []byte {
+	props := []*contentline.Property{
+		contentline.NewProperty("BEGIN", "VCARD"),
+		contentline.NewProperty("VERSION", "4.0"),
+		contentline.NewProperty("PRODID", vCardProdID),
+		contentline.NewProperty("UID", c.vCardUID()),
+	}
+
+	fn := c.PrimaryName()
+	if fn == "" {
+		fn, _ = c.Get("displayName").(string)
+	}
+	props = append(props, textProperty("FN", fn))
+
+	if name, ok := c.Get("name").(map[string]interface{}); ok {
+		parts := []string{
+			stringField(name, "familyName"),
+			stringField(name, "givenName"),
+			stringField(name, "additionalName"),
+			stringField(name, "namePrefix"),
+			stringField(name, "nameSuffix"),
+		}
+		props = append(props, contentline.NewProperty("N", contentline.JoinText(parts, ';')))
+	}
+
+	for _, email := range objectsField(c.M, "email") {
+		if address := stringField(email, "address"); address != "" {
+			props = append(props, withTypeAndPref(textProperty("EMAIL", address), email))
+		}
+	}
+	for _, phone := range objectsField(c.M, "phone") {
+		if number := stringField(phone, "number"); number != "" {
+			props = append(props, withTypeAndPref(textProperty("TEL", number), phone))
+		}
+	}
+	for _, addr := range objectsField(c.M, "address") {
+		parts := []string{
+			stringField(addr, "pobox"),
+			stringField(addr, "extendedAddress"),
+			stringField(addr, "street"),
+			stringField(addr, "city"),
+			stringField(addr, "region"),
+			stringField(addr, "postcode"),
+			stringField(addr, "country"),
+		}
+		prop := contentline.NewProperty("ADR", contentline.JoinText(parts, ';'))
+		if label := stringField(addr, "formattedAddress"); label != "" {
+			prop.Params.Add("LABEL", label)
+		}
+		props = append(props, withTypeAndPref(prop, addr))
+	}
+	for _, cozy := range objectsField(c.M, "cozy") {
+		if url := stringField(cozy, "url"); url != "" {
+			props = append(props, withTypeAndPref(contentline.NewProperty("X-COZY-URL", url), cozy))
+		}
+	}
+
+	if company := stringField(c.M, "company"); company != "" {
+		props = append(props, textProperty("ORG", company))
+	}
+	if title := stringField(c.M, "jobTitle"); title != "" {
+		props = append(props, textProperty("TITLE", title))
+	}
+	if bday := stringField(c.M, "birthday"); bday != "" {
+		if isoDate.MatchString(bday) {
+			bday = strings.ReplaceAll(bday, "-", "")
+		}
+		props = append(props, contentline.NewProperty("BDAY", bday))
+	}
+	if note := stringField(c.M, "note"); note != "" {
+		props = append(props, textProperty("NOTE", note))
+	}
+
+	if categories := c.groupNames(groups); len(categories) > 0 {
+		props = append(props, contentline.NewProperty("CATEGORIES", contentline.JoinText(categories, ',')))
+	}
+	if meta, ok := c.Get("cozyMetadata").(map[string]interface{}); ok {
+		if updatedAt, err := time.Parse(time.RFC3339, stringField(meta, "updatedAt")); err == nil {
+			props = append(props, contentline.NewProperty("REV", updatedAt.UTC().Format("20060102T150405Z")))
+		}
+	}
+
+	if vcard, ok := c.Get(VCardKey).(map[string]interface{}); ok {
+		if lines, ok := vcard["properties"].([]interface{}); ok {
+			for _, line := range lines {
+				if str, ok := line.(string); ok {
+					if prop, err := contentline.ParseLine(str); err == nil {
+						props = append(props, prop)
+					}
+				}
+			}
+		}
+	}
+
+	props = append(props, contentline.NewProperty("END", "VCARD"))
+	return contentline.Encode(props)
+}
+
+// UpdateFromVCard updates the contact from a vCard (3.0 or 4.0). The JSON
+// fields that have no equivalent in the vCard are kept untouched, and the
+// vCard properties that have no equivalent in JSON are kept in the vcard
+// field. The groups are created for the categories that don't match an
+// existing group. The contact is not saved.
+func (c *Contact) UpdateFromVCard(db prefixer.Prefixer, data []byte) error {
+	props, err := parseVCard(data)
+	if err != nil {
+		return err
+	}
+	groups, err := ListGroups(db)
+	if err != nil {
+		return err
+	}
+	for _, prop := range props {
+		if prop.Name != "CATEGORIES" {
+			continue
+		}
+		for _, name := range contentline.SplitText(prop.Value, ',') {
+			if name == "" || findGroupByName(groups, name) != nil {
+				continue
+			}
+			group := NewGroup()
+			group.M["name"] = name
+			if err := couchdb.CreateDoc(db, group); err != nil {
+				return err
+			}
+			groups = append(groups, group)
+		}
+	}
+	c.applyVCard(props, groups)
+	return nil
+}
+
+func parseVCard(data []byte) ([]*contentline.Property, error) {
+	props, err := contentline.Parse(data)
+	if err != nil || len(props) < 2 {
+		return nil, ErrInvalidVCard
+	}
+	first, last := props[0], props[len(props)-1]
+	if first.Name != "BEGIN" || !strings.EqualFold(first.Value, "VCARD") ||
+		last.Name != "END" || !strings.EqualFold(last.Value, "VCARD") {
+		return nil, ErrInvalidVCard
+	}
+	return props[1 : len(props)-1], nil
+}
+
+// applyVCard replaces the JSON fields that are converted from the vCard
+// properties. When the vCard has no CATEGORIES, the groups of the contact are
+// kept, as some clients don't support them and would remove the contact from
+// its groups.
+func (c *Contact) applyVCard(props []*contentline.Property, groups []*Group) {
+	var emails, phones, addresses, cozys []interface{}
+	var name map[string]interface{}
+	var categories []string
+	hasCategories := false
+	uid := ""
+	var extra []interface{}
+	scalars := map[string]string{}
+
+	for _, prop := range props {
+		switch prop.Name {
+		case "UID":
+			uid = prop.Value
+		case "FN":
+			scalars["fullname"] = contentline.UnescapeText(prop.Value)
+		case "N":
+			parts := contentline.SplitText(prop.Value, ';')
+			for len(parts) < 5 {
+				parts = append(parts, "")
+			}
+			name = map[string]interface{}{}
+			for i, key := range []string{"familyName", "givenName", "additionalName", "namePrefix", "nameSuffix"} {
+				if parts[i] != "" {
+					name[key] = parts[i]
+				}
+			}
+		case "EMAIL":
+			email := map[string]interface{}{"address": contentline.UnescapeText(prop.Value)}
+			emails = append(emails, typeAndPref(prop, email, "INTERNET"))
+		case "TEL":
+			number := strings.TrimPrefix(contentline.UnescapeText(prop.Value), "tel:")
+			phone := map[string]interface{}{"number": number}
+			phones = append(phones, typeAndPref(prop, phone, "VOICE"))
+		case "ADR":
+			parts := contentline.SplitText(prop.Value, ';')
+			for len(parts) < 7 {
+				parts = append(parts, "")
+			}
+			addr := map[string]interface{}{}
+			for i, key := range []string{"pobox", "extendedAddress", "street", "city", "region", "postcode", "country"} {
+				if parts[i] != "" {
+					addr[key] = parts[i]
+				}
+			}
+			if label := prop.Params.Get("LABEL"); label != "" {
+				addr["formattedAddress"] = label
+			}
+			addresses = append(addresses, typeAndPref(prop, addr))
+		case "X-COZY-URL":
+			cozy := map[string]interface{}{"url": prop.Value}
+			cozys = append(cozys, typeAndPref(prop, cozy))
+		case "ORG":
+			scalars["company"] = contentline.SplitText(prop.Value, ';')[0]
+		case "TITLE":
+			scalars["jobTitle"] = contentline.UnescapeText(prop.Value)
+		case "BDAY":
+			bday := prop.Value
+			if basicDate.MatchString(bday) {
+				bday = bday[0:4] + "-" + bday[4:6] + "-" + bday[6:8]
+			}
+			scalars["birthday"] = bday
+		case "NOTE":
+			scalars["note"] = contentline.UnescapeText(prop.Value)
+		case "CATEGORIES":
+			hasCategories = true
+			for _, category := range contentline.SplitText(prop.Value, ',') {
+				if category != "" {
+					categories = append(categories, category)
+				}
+			}
+		default:
+			if !convertedProperties[prop.Name] {
+				extra = append(extra, prop.String())
+			}
+		}
+	}
+
+	for _, key := range []string{"fullname", "company", "jobTitle", "birthday", "note"} {
+		if value := scalars[key]; value != "" {
+			c.M[key] = value
+		} else {
+			delete(c.M, key)
+		}
+	}
+	if fullname := scalars["fullname"]; fullname != "" {
+		c.M["displayName"] = fullname
+	}
+	if len(name) > 0 {
+		c.M["name"] = mergeObject(c.Get("name"), name)
+	} else {
+		delete(c.M, "name")
+	}
+	c.M["email"] = mergeObjects(objectsField(c.M, "email"), emails, "address")
+	c.M["phone"] = mergeObjects(objectsField(c.M, "phone"), phones, "number")
+	c.M["address"] = mergeObjects(objectsField(c.M, "address"), addresses, "street")
+	c.M["cozy"] = mergeObjects(objectsField(c.M, "cozy"), cozys, "url")
+
+	vcard := map[string]interface{}{}
+	if uid != "" {
+		vcard["uid"] = uid
+	}
+	if len(extra) > 0 {
+		vcard["properties"] = extra
+	}
+	if len(vcard) > 0 {
+		c.M[VCardKey] = vcard
+	} else {
+		delete(c.M, VCardKey)
+	}
+
+	if hasCategories {
+		c.setGroups(categories, groups)
+	}
+	c.updateIndexes()
+}
+
+// vCardUID returns the UID of the vCard: the one sent by the CardDAV client
+// if the contact was created by it, or else the identifier of the contact.
+func (c *Contact) vCardUID() string {
+	if vcard, ok := c.Get(VCardKey).(map[string]interface{}); ok {
+		if uid := stringField(vcard, "uid"); uid != "" {
+			return uid
+		}
+	}
+	return c.ID()
+}
+
+func (c *Contact) groupNames(groups []*Group) []string {
+	var names []string
+	for _, id := range c.GroupIDs() {
+		for _, g := range groups {
+			if g.ID() == id && g.Name() != "" {
+				names = append(names, g.Name())
+				break
+			}
+		}
+	}
+	return names
+}
+
+// setGroups replaces the groups relationship of the contact by the groups
+// with the given names.
+func (c *Contact) setGroups(names []string, groups []*Group) {
+	data := []interface{}{}
+	for _, name := range names {
+		if g := findGroupByName(groups, name); g != nil {
+			data = append(data, map[string]interface{}{
+				"_id":   g.ID(),
+				"_type": consts.Groups,
+			})
+		}
+	}
+	rels, ok := c.Get("relationships").(map[string]interface{})
+	if !ok {
+		rels = map[string]interface{}{}
+	}
+	rels["groups"] = map[string]interface{}{"data": data}
+	c.M["relationships"] = rels
+}
+
+// updateIndexes updates the index used for sorting the contacts, like the
+// contacts application does.
+func (c *Contact) updateIndexes() {
+	var index string
+	if name, ok := c.Get("name").(map[string]interface{}); ok {
+		index += stringField(name, "familyName") + stringField(name, "givenName")
+	}
+	if mail, err := c.ToMailAddress(); err == nil {
+		index += mail.Email
+	}
+	index += c.PrimaryCozyURL()
+	indexes, ok := c.Get("indexes").(map[string]interface{})
+	if !ok {
+		indexes = map[string]interface{}{}
+	}
+	indexes["byFamilyNameGivenNameEmailCozyUrl"] = strings.ToLower(index)
+	c.M["indexes"] = indexes
+}
+
+func findGroupByName(groups []*Group, name string) *Group {
+	for _, g := range groups {
+		if strings.EqualFold(g.Name(), name) {
+			return g
+		}
+	}
+	return nil
+}
+
+func textProperty(name, value string) *contentline.Property {
+	return contentline.NewProperty(name, contentline.EscapeText(value))
+}
+
+// withTypeAndPref adds the TYPE and PREF parameters to the property from the
+// type and primary fields.
+func withTypeAndPref(prop *contentline.Property, obj map[string]interface{}) *contentline.Property {
+	if typ := stringField(obj, "type"); typ != "" {
+		prop.Params.Add("TYPE", typ)
+	}
+	if primary, _ := obj["primary"].(bool); primary {
+		prop.Params.Add("PREF", "1")
+	}
+	return prop
+}
+
+// typeAndPref sets the type and primary fields from the TYPE and PREF
+// parameters. The vCard 3.0 TYPE=PREF is also accepted, and the ignored types
+// are the default values that are not useful.
+func typeAndPref(prop *contentline.Property, obj map[string]interface{}, ignored ...string) map[string]interface{} {
+	var types []string
+	for _, typ := range prop.Params.Values("TYPE") {
+		if strings.EqualFold(typ, "PREF") {
+			obj["primary"] = true
+			continue
+		}
+		skip := false
+		for _, ign := range ignored {
+			if strings.EqualFold(typ, ign) {
+				skip = true
+			}
+		}
+		if !skip {
+			types = append(types, strings.ToLower(typ))
+		}
+	}
+	if len(types) > 0 {
+		obj["type"] = strings.Join(types, ",")
+	}
+	if prop.Params.Get("PREF") == "1" {
+		obj["primary"] = true
+	}
+	return obj
+}
+
+// mergeObjects returns the new objects, completed with the fields of the old
+// objects that have the same value for the key field, so that the fields that
+// can't be represented in the vCard (like labels) are not lost.
+func mergeObjects(olds []map[string]interface{}, news []interface{}, key string) []interface{} {
+	if news == nil {
+		return []interface{}{}
+	}
+	for _, n := range news {
+		obj := n.(map[string]interface{})
+		for _, old := range olds {
+			if old[key] != nil && old[key] == obj[key] {
+				for k, v := range old {
+					if _, ok := obj[k]; !ok && k != "primary" && k != "type" {
+						obj[k] = v
+					}
+				}
+				break
+			}
+		}
+	}
+	return news
+}
+
+// mergeObject returns the new object, completed with the fields of the old
+// object that are not set.
+func mergeObject(old interface{}, obj map[string]interface{}) map[string]interface{} {
+	if o, ok := old.(map[string]interface{}); ok {
+		for k, v := range o {
+			if _, ok := obj[k]; !ok && !isNameComponent(k) {
+				obj[k] = v
+			}
+		}
+	}
+	return obj
+}
+
+func isNameComponent(key string) bool {
+	switch key {
+	case "familyName", "givenName", "additionalName", "namePrefix", "nameSuffix":
+		return true
+	}
+	return false
+}
+
+func stringField(obj map[string]interface{}, key string) string {
+	str, _ := obj[key].(string)
+	return str
+}
+
+func objectsField(obj map[string]interface{}, key string) []map[string]interface{} {
+	var objects []map[string]interface{}
+	switch list := obj[key].(type) {
+	case []interface{}:
+		for _, item := range list {
+			if o, ok := item.(map[string]interface{}); ok {
+				objects = append(objects, o)
+			}
+		}
+	case []map[string]interface{}:
+		objects = list
+	}
+	return objects
+}
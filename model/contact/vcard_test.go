@@ -0,0 +1,119 @@
+package contact
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestGroup(id, name string) *Group {
+	g := NewGroup()
+	g.SetID(id)
+	g.M["name"] = name
+	return g
+}
+
+func TestToVCard(t *testing.T) {
+	groups := []*Group{newTestGroup("g1", "Friends"), newTestGroup("g2", "Work")}
+	doc := New()
+	doc.SetID("c1")
+	doc.M["fullname"] = "Alice Martin"
+	doc.M["name"] = map[string]interface{}{"givenName": "Alice", "familyName": "Martin"}
+	doc.M["email"] = []interface{}{
+		map[string]interface{}{"address": "alice@example.com", "type": "work", "primary": true},
+	}
+	doc.M["phone"] = []interface{}{
+		map[string]interface{}{"number": "+33 6 00 00 00 00", "type": "cell"},
+	}
+	doc.M["birthday"] = "1990-04-02"
+	doc.M["note"] = "Met at the conference, in Nantes"
+	doc.M["relationships"] = map[string]interface{}{
+		"groups": map[string]interface{}{
+			"data": []interface{}{
+				map[string]interface{}{"_id": "g1", "_type": consts.Groups},
+			},
+		},
+	}
+
+	card := string(doc.ToVCard(groups))
+	assert.True(t, strings.HasPrefix(card, "BEGIN:VCARD\r\nVERSION:4.0\r\n"))
+	assert.True(t, strings.HasSuffix(card, "END:VCARD\r\n"))
+	assert.Contains(t, card, "UID:c1\r\n")
+	assert.Contains(t, card, "FN:Alice Martin\r\n")
+	assert.Contains(t, card, "N:Martin;Alice;;;\r\n")
+	assert.Contains(t, card, "EMAIL;PREF=1;TYPE=work:alice@example.com\r\n")
+	assert.Contains(t, card, "TEL;TYPE=cell:+33 6 00 00 00 00\r\n")
+	assert.Contains(t, card, "BDAY:19900402\r\n")
+	assert.Contains(t, card, `NOTE:Met at the conference\, in Nantes`)
+	assert.Contains(t, card, "CATEGORIES:Friends\r\n")
+}
+
+func TestApplyVCard(t *testing.T) {
+	groups := []*Group{newTestGroup("g1", "Friends"), newTestGroup("g2", "Work")}
+	doc := New()
+	doc.SetID("c1")
+	doc.M["me"] = true
+	doc.M["email"] = []interface{}{
+		map[string]interface{}{"address": "bob@example.com", "label": "Personal", "type": "home"},
+	}
+	doc.M["name"] = map[string]interface{}{"givenName": "Robert", "nickname": "Bobby"}
+
+	card := "BEGIN:VCARD\r\n" +
+		"VERSION:3.0\r\n" +
+		"UID:ABCD-1234\r\n" +
+		"FN:Bob Dupont\r\n" +
+		"N:Dupont;Bob;;;\r\n" +
+		"EMAIL;TYPE=INTERNET,HOME,PREF:bob@example.com\r\n" +
+		"TEL;TYPE=CELL:0600000000\r\n" +
+		"ORG:Cozy Cloud;R&D\r\n" +
+		"BDAY:1985-12-24\r\n" +
+		"CATEGORIES:Work\r\n" +
+		"X-SOCIALPROFILE;TYPE=twitter:https://twitter.com/bob\r\n" +
+		"END:VCARD\r\n"
+	props, err := parseVCard([]byte(card))
+	require.NoError(t, err)
+	doc.applyVCard(props, groups)
+
+	assert.Equal(t, true, doc.M["me"])
+	assert.Equal(t, "Bob Dupont", doc.M["fullname"])
+	assert.Equal(t, "Bob Dupont", doc.M["displayName"])
+	assert.Equal(t, map[string]interface{}{
+		"familyName": "Dupont",
+		"givenName":  "Bob",
+		"nickname":   "Bobby",
+	}, doc.M["name"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"address": "bob@example.com", "label": "Personal", "type": "home", "primary": true},
+	}, doc.M["email"])
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"number": "0600000000", "type": "cell"},
+	}, doc.M["phone"])
+	assert.Equal(t, "Cozy Cloud", doc.M["company"])
+	assert.Equal(t, "1985-12-24", doc.M["birthday"])
+	assert.Equal(t, []string{"g2"}, doc.GroupIDs())
+	assert.Equal(t, map[string]interface{}{
+		"uid":        "ABCD-1234",
+		"properties": []interface{}{"X-SOCIALPROFILE;TYPE=twitter:https://twitter.com/bob"},
+	}, doc.M[VCardKey])
+
+	// The unknown properties and the UID are sent back to the clients
+	out := string(doc.ToVCard(groups))
+	assert.Contains(t, out, "UID:ABCD-1234\r\n")
+	assert.Contains(t, out, "X-SOCIALPROFILE;TYPE=twitter:https://twitter.com/bob\r\n")
+	assert.Contains(t, out, "CATEGORIES:Work\r\n")
+
+	// Without CATEGORIES, the groups are kept
+	props, err = parseVCard([]byte("BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Bob\r\nEND:VCARD\r\n"))
+	require.NoError(t, err)
+	doc.applyVCard(props, groups)
+	assert.Equal(t, []string{"g2"}, doc.GroupIDs())
+	assert.Equal(t, []interface{}{}, doc.M["email"])
+	assert.NotContains(t, doc.M, "company")
+	assert.NotContains(t, doc.M, VCardKey)
+
+	_, err = parseVCard([]byte("BEGIN:VEVENT\r\nEND:VEVENT\r\n"))
+	assert.ErrorIs(t, err, ErrInvalidVCard)
+}
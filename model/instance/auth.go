@@ -60,6 +60,14 @@ const (
 	// we do ll the check and show controls to enable external 2FA.
 	// This flag doesn't affect any of the internal flows.
 	TwoFactorOIDC
+	// TwoFactorWebAuthn authentication mode, with a passkey or a security key
+	// registered by the user. The clients that cannot use WebAuthn (the
+	// flagship app, the Bitwarden clients, etc.) still receive a passcode by
+	// email.
+	TwoFactorWebAuthn
+	// TwoFactorTOTP authentication mode, with a code from an authenticator
+	// app enrolled by the user, or a recovery code
+	TwoFactorTOTP
 )
 
 // AuthModeToString encode authentication mode in a string
@@ -69,6 +77,10 @@ func AuthModeToString(authMode AuthMode) string {
 		return "two_factor_mail"
 	case TwoFactorOIDC:
 		return "two_factor_oidc"
+	case TwoFactorWebAuthn:
+		return "two_factor_webauthn"
+	case TwoFactorTOTP:
+		return "two_factor_totp"
 	default:
 		return "basic"
 	}
@@ -82,6 +94,10 @@ func StringToAuthMode(authMode string) (AuthMode, error) {
 		return TwoFactorMail, nil
 	case "two_factor_oidc":
 		return TwoFactorOIDC, nil
+	case "two_factor_webauthn":
+		return TwoFactorWebAuthn, nil
+	case "two_factor_totp":
+		return TwoFactorTOTP, nil
 	case "basic":
 		return Basic, nil
 	default:
@@ -95,6 +111,14 @@ func (i *Instance) HasAuthMode(authMode AuthMode) bool {
 	return i.AuthMode == authMode
 }
 
+// HasTwoFactor returns whether or not a second factor is required to log in,
+// by mail, with WebAuthn or with an authenticator app.
+func (i *Instance) HasTwoFactor() bool {
+	return i.AuthMode == TwoFactorMail ||
+		i.AuthMode == TwoFactorWebAuthn ||
+		i.AuthMode == TwoFactorTOTP
+}
+
 // GenerateTwoFactorSecrets generates a (token, passcode) pair that can be
 // used as a two factor authentication secret value. The token is used to allow
 // the two-factor form — meaning the user has correctly entered its passphrase
@@ -124,12 +148,20 @@ func (i *Instance) GenerateTwoFactorSecrets() (token []byte, passcode string, er
 }
 
 // ValidateTwoFactorPasscode validates the given (token, passcode) pair for two
-// factor authentication.
+// factor authentication. With an authenticator app, the passcode is the code
+// from the app or one of the recovery codes. With WebAuthn, no passcode is
+// accepted.
 func (i *Instance) ValidateTwoFactorPasscode(token []byte, passcode string) bool {
+	if i.HasAuthMode(TwoFactorWebAuthn) {
+		return false
+	}
 	salt, err := crypto.DecodeAuthMessage(totpMACConfig, i.SessionSecret(), token, nil)
 	if err != nil {
 		return false
 	}
+	if i.HasAuthMode(TwoFactorTOTP) {
+		return i.ValidateTOTPPasscode(passcode) || i.UseTOTPRecoveryCode(passcode)
+	}
 
 	h := hkdf.New(sha256.New, i.SessionSecret(), salt, nil)
 	key := make([]byte, 32)
@@ -142,6 +174,14 @@ func (i *Instance) ValidateTwoFactorPasscode(token []byte, passcode string) bool
 	return ok && err == nil
 }
 
+// ValidateTwoFactorToken returns true if the given token has been generated
+// for this instance by GenerateTwoFactorSecrets, ie after the first part of
+// the two factor authentication.
+func (i *Instance) ValidateTwoFactorToken(token []byte) bool {
+	_, err := crypto.DecodeAuthMessage(totpMACConfig, i.SessionSecret(), token, nil)
+	return err == nil
+}
+
 // GenerateTwoFactorTrustedDeviceSecret generates a token that can be kept by the
 // user on-demand to avoid having two-factor authentication on a specific
 // machine.
@@ -20,6 +20,9 @@ var (
 	// ErrInvalidTwoFactor is returned when the two-factor authentication
 	// verification is invalid.
 	ErrInvalidTwoFactor = errors.New("Invalid two-factor parameters")
+	// ErrWebAuthnRequired is returned when the second factor can only be a
+	// WebAuthn passkey or security key, and not a passcode.
+	ErrWebAuthnRequired = errors.New("webauthn required")
 	// ErrResetAlreadyRequested is returned when a passphrase reset token is already set and valid
 	ErrResetAlreadyRequested = errors.New("The passphrase reset has already been requested")
 	// ErrUnknownAuthMode is returned when an unknown authentication mode is
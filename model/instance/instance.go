@@ -110,6 +110,12 @@ type Instance struct {
 	OAuthSecret []byte `json:"oauth_secret,omitempty"`
 	// CLISecret is used to authenticate request from the CLI
 	CLISecret []byte `json:"cli_secret,omitempty"`
+	// TOTPSecret is the secret of the authenticator app enrolled for the two
+	// factor authentication, encrypted with a key derived from SessSecret
+	TOTPSecret string `json:"totp_secret,omitempty"`
+	// TOTPRecoveryCodes are the hashes of the single-use recovery codes that
+	// can be used instead of a code from the authenticator app
+	TOTPRecoveryCodes []string `json:"totp_recovery_codes,omitempty"`
 
 	// FeatureFlags is the feature flags that are specific to this instance
 	FeatureFlags map[string]interface{} `json:"feature_flags,omitempty"`
@@ -168,6 +174,9 @@ func (i *Instance) Clone() couchdb.Doc {
 
 	cloned.CLISecret = make([]byte, len(i.CLISecret))
 	copy(cloned.CLISecret, i.CLISecret)
+
+	cloned.TOTPRecoveryCodes = make([]string, len(i.TOTPRecoveryCodes))
+	copy(cloned.TOTPRecoveryCodes, i.TOTPRecoveryCodes)
 	return &cloned
 }
 
@@ -549,6 +558,15 @@ func (i *Instance) DiskQuota() int64 {
 	return i.BytesDiskQuota
 }
 
+// DeduplicatedUsage returns the number of bytes that are not stored on the
+// disk thanks to the deduplication.
+func (i *Instance) DeduplicatedUsage() (int64, error) {
+	if !vfs.DeduplicationEnabled() {
+		return 0, nil
+	}
+	return vfs.DeduplicatedUsage(i)
+}
+
 // WithContextualDomain the current instance context with the given hostname.
 func (i *Instance) WithContextualDomain(domain string) *Instance {
 	if i.HasDomain(domain) {
@@ -2,12 +2,15 @@ package instance_test
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/crypto"
 	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -54,6 +57,119 @@ func TestInstance(t *testing.T) {
 		assert.Equal(t, "my-app", claims["sub"])
 	})
 
+	t.Run("WebAuthnChallenge", func(t *testing.T) {
+		inst := &instance.Instance{
+			Domain:     "alice.example.com:8080",
+			SessSecret: crypto.GenerateRandomBytes(64),
+		}
+		cfg := config.GetConfig()
+		was := cfg.Subdomains
+		defer func() { cfg.Subdomains = was }()
+		cfg.Subdomains = config.FlatSubdomains
+
+		rp := inst.RelyingParty()
+		assert.Equal(t, "example.com", rp.ID)
+		assert.Len(t, rp.Origins, 2)
+		assert.Contains(t, rp.Origins[1], "://alice-settings.example.com:8080")
+
+		cfg.Subdomains = config.NestedSubdomains
+		rp = inst.RelyingParty()
+		assert.Equal(t, "alice.example.com", rp.ID)
+		assert.Len(t, rp.Origins, 2)
+		assert.Contains(t, rp.Origins[1], "://settings.alice.example.com:8080")
+
+		// The settings origin is not allowed if the parent domain is a
+		// public suffix
+		cfg.Subdomains = config.FlatSubdomains
+		rp = (&instance.Instance{Domain: "alice.com"}).RelyingParty()
+		assert.Equal(t, "alice.com", rp.ID)
+		assert.Len(t, rp.Origins, 1)
+
+		challenge, token, err := inst.CreateWebAuthnChallenge(instance.WebAuthnTwoFactor, []byte("2fa-token"))
+		require.NoError(t, err)
+		decoded, err := inst.CheckWebAuthnChallenge(instance.WebAuthnTwoFactor, []byte("2fa-token"), token)
+		require.NoError(t, err)
+		assert.Equal(t, challenge, decoded)
+
+		// A challenge can be used only once
+		_, err = inst.CheckWebAuthnChallenge(instance.WebAuthnTwoFactor, []byte("2fa-token"), token)
+		assert.Error(t, err)
+
+		_, token, err = inst.CreateWebAuthnChallenge(instance.WebAuthnTwoFactor, []byte("2fa-token"))
+		require.NoError(t, err)
+		_, err = inst.CheckWebAuthnChallenge(instance.WebAuthnLogin, []byte("2fa-token"), token)
+		assert.Error(t, err)
+		_, err = inst.CheckWebAuthnChallenge(instance.WebAuthnTwoFactor, []byte("other"), token)
+		assert.Error(t, err)
+	})
+
+	t.Run("TOTPEnrollment", func(t *testing.T) {
+		inst := &instance.Instance{
+			Domain:     "alice.example.com",
+			SessSecret: crypto.GenerateRandomBytes(64),
+		}
+		enrollment, err := inst.GenerateTOTPEnrollment()
+		require.NoError(t, err)
+		assert.Contains(t, enrollment.URI, "otpauth://totp/")
+		assert.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+		assert.True(t, strings.HasPrefix(enrollment.QRCode, "data:image/png;base64,"))
+
+		_, err = inst.ConfirmTOTPEnrollment([]byte(enrollment.Token), "000000x")
+		assert.ErrorIs(t, err, instance.ErrInvalidTOTPPasscode)
+
+		code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+		require.NoError(t, err)
+		encrypted, err := inst.ConfirmTOTPEnrollment([]byte(enrollment.Token), code)
+		require.NoError(t, err)
+		assert.NotContains(t, encrypted, enrollment.Secret)
+
+		assert.False(t, inst.ValidateTOTPPasscode(code))
+		inst.TOTPSecret = encrypted
+		assert.True(t, inst.HasTOTP())
+		assert.True(t, inst.ValidateTOTPPasscode(code))
+		assert.False(t, inst.ValidateTOTPPasscode("abcdef"))
+
+		// A code can't be replayed
+		assert.False(t, inst.ValidateTOTPPasscode(code))
+
+		// The secret can still be decrypted after a rotation of the session
+		// secret (when the passphrase is changed)
+		inst.SessSecret = crypto.GenerateRandomBytes(64)
+		next, err := totp.GenerateCode(enrollment.Secret, time.Now().Add(30*time.Second))
+		require.NoError(t, err)
+		assert.True(t, inst.ValidateTOTPPasscode(next))
+
+		other := &instance.Instance{
+			Domain:     "bob.example.com",
+			SessSecret: crypto.GenerateRandomBytes(64),
+		}
+		_, err = other.ConfirmTOTPEnrollment([]byte(enrollment.Token), code)
+		assert.ErrorIs(t, err, instance.ErrInvalidTOTPPasscode)
+
+		codes, hashes := instance.GenerateTOTPRecoveryCodes()
+		assert.Len(t, codes, instance.TOTPRecoveryCodesCount)
+		assert.Len(t, hashes, instance.TOTPRecoveryCodesCount)
+		for i, c := range codes {
+			assert.Len(t, c, 11)
+			assert.NotEqual(t, c, hashes[i])
+		}
+	})
+
+	t.Run("TwoFactorPasscodeWithWebAuthn", func(t *testing.T) {
+		inst := &instance.Instance{
+			Domain:     "alice.example.com",
+			SessSecret: crypto.GenerateRandomBytes(64),
+			AuthMode:   instance.TwoFactorMail,
+		}
+		token, passcode, err := inst.GenerateTwoFactorSecrets()
+		require.NoError(t, err)
+		assert.True(t, inst.ValidateTwoFactorPasscode(token, passcode))
+
+		// A passcode sent by mail can't replace the passkey
+		inst.AuthMode = instance.TwoFactorWebAuthn
+		assert.False(t, inst.ValidateTwoFactorPasscode(token, passcode))
+	})
+
 	t.Run("GetContextWithSponsorships", func(t *testing.T) {
 		cfg := config.GetConfig()
 		was := cfg.Contexts
@@ -11,6 +11,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/calendar"
 	"github.com/cozy/cozy-stack/model/contact"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/settings/common"
@@ -276,6 +277,12 @@ func Create(opts *Options) (*instance.Instance, error) {
 		}
 	})
 
+	opts.trace("setup calendar reminders", func() {
+		if err := calendar.SetupTrigger(i); err != nil {
+			i.Logger().Errorf("Failed to create the triggers for the reminders: %s", err)
+		}
+	})
+
 	opts.trace("create common settings", func() {
 		if err = common.CreateCommonSettings(i, settings); err != nil {
 			i.Logger().Errorf("Failed to create common settings: %s", err)
@@ -11,6 +11,7 @@ import (
 	"github.com/cozy/cozy-stack/model/instance"
 	job "github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/rag"
+	"github.com/cozy/cozy-stack/model/search"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -80,6 +81,9 @@ func Destroy(domain string) error {
 	if err := rag.CleanInstance(inst); err != nil {
 		return err
 	}
+	if err := search.RemoveIndex(inst); err != nil {
+		inst.Logger().Warnf("Could not delete the search index: %s", err)
+	}
 
 	// Reload the instance, it can have been updated in CouchDB if the instance
 	// had at least one account and was not up-to-date for its indexes/views.
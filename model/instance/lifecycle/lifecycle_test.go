@@ -16,6 +16,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
 	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
@@ -319,6 +320,31 @@ func TestLifecycle(t *testing.T) {
 		assert.Equal(t, 0, settings.PassphraseKdf)
 	})
 
+	t.Run("TOTPAfterPassphraseChange", func(t *testing.T) {
+		i, err := lifecycle.GetInstance("test.cozycloud.cc")
+		require.NoError(t, err)
+
+		enrollment, err := i.GenerateTOTPEnrollment()
+		require.NoError(t, err)
+		code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+		require.NoError(t, err)
+		_, err = lifecycle.EnableTOTP(i, []byte(enrollment.Token), code)
+		require.NoError(t, err)
+		defer func() { _ = lifecycle.ResetTOTP(i) }()
+
+		oldSecret := i.SessSecret
+		params := lifecycle.PassParameters{Iterations: 5000}
+		err = lifecycle.ForceUpdatePassphrase(i, []byte("new-passphrase"), params)
+		require.NoError(t, err)
+		assert.NotEqual(t, oldSecret, i.SessSecret)
+
+		i, err = lifecycle.GetInstance("test.cozycloud.cc")
+		require.NoError(t, err)
+		code, err = totp.GenerateCode(enrollment.Secret, time.Now())
+		require.NoError(t, err)
+		assert.True(t, i.ValidateTOTPPasscode(code))
+	})
+
 	t.Run("RequestPassphraseReset", func(t *testing.T) {
 		in, err := lifecycle.Create(&lifecycle.Options{
 			Domain: "test.cozycloud.cc.pass_reset",
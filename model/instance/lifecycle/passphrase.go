@@ -195,7 +195,7 @@ func UpdatePassphrase(
 	// With two factor authentication, we do not check the validity of the
 	// current passphrase, but the validity of the pair passcode/token which has
 	// been exchanged against the current passphrase.
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		if !inst.ValidateTwoFactorPasscode(twoFactorToken, twoFactorPasscode) {
 			return instance.ErrInvalidTwoFactor
 		}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/search"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"golang.org/x/sync/errgroup"
@@ -26,6 +27,9 @@ func Reset(inst *instance.Instance) error {
 		return err
 	}
 	removeTriggers(inst)
+	if err = search.RemoveIndex(inst); err != nil {
+		return err
+	}
 	if err = inst.VFS().Delete(); err != nil {
 		return err
 	}
@@ -0,0 +1,47 @@
+package lifecycle
+
+import "github.com/cozy/cozy-stack/model/instance"
+
+// EnableTOTP checks the first code of the authenticator app being enrolled,
+// saves its secret, and switches the instance to the 2FA with this app. It
+// returns the recovery codes, that must be shown to the user.
+func EnableTOTP(inst *instance.Instance, token []byte, passcode string) ([]string, error) {
+	secret, err := inst.ConfirmTOTPEnrollment(token, passcode)
+	if err != nil {
+		return nil, err
+	}
+	codes, hashes := instance.GenerateTOTPRecoveryCodes()
+	inst.TOTPSecret = secret
+	inst.TOTPRecoveryCodes = hashes
+	inst.AuthMode = instance.TwoFactorTOTP
+	if err := update(inst); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// RegenerateTOTPRecoveryCodes replaces the recovery codes of the instance by
+// a new set, and returns them.
+func RegenerateTOTPRecoveryCodes(inst *instance.Instance) ([]string, error) {
+	codes, hashes := instance.GenerateTOTPRecoveryCodes()
+	inst.TOTPRecoveryCodes = hashes
+	if err := update(inst); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// ResetTOTP removes the authenticator app and the recovery codes of the
+// instance. If the authenticator app was used for the 2FA, the passcode is
+// sent by mail instead.
+func ResetTOTP(inst *instance.Instance) error {
+	if !inst.HasTOTP() && len(inst.TOTPRecoveryCodes) == 0 {
+		return nil
+	}
+	inst.TOTPSecret = ""
+	inst.TOTPRecoveryCodes = nil
+	if inst.HasAuthMode(instance.TwoFactorTOTP) {
+		inst.AuthMode = instance.TwoFactorMail
+	}
+	return update(inst)
+}
@@ -7,11 +7,21 @@ import (
 
 // SendTwoFactorPasscode sends by mail the two factor secret to the owner of
 // the instance. It returns the generated token.
+//
+// With WebAuthn, a passcode can't be used as the second factor, and
+// ErrWebAuthnRequired is returned. With an authenticator app, the passcode
+// comes from the app, and only the token is returned.
 func SendTwoFactorPasscode(inst *instance.Instance) ([]byte, error) {
+	if inst.HasAuthMode(instance.TwoFactorWebAuthn) {
+		return nil, instance.ErrWebAuthnRequired
+	}
 	token, passcode, err := inst.GenerateTwoFactorSecrets()
 	if err != nil {
 		return nil, err
 	}
+	if inst.HasAuthMode(instance.TwoFactorTOTP) {
+		return token, nil
+	}
 	err = emailer.SendEmail(inst, &emailer.TransactionalEmailCmd{
 		TemplateName:   "two_factor",
 		TemplateValues: map[string]interface{}{"TwoFactorPasscode": passcode},
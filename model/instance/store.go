@@ -2,6 +2,7 @@ package instance
 
 import (
 	"context"
+	"strconv"
 	"sync"
 	"time"
 
@@ -16,6 +17,9 @@ type Store interface {
 	SaveEmailVerfiedCode(db prefixer.Prefixer, code string) error
 	CheckAndClearSessionCode(db prefixer.Prefixer, code string) bool
 	CheckEmailVerifiedCode(db prefixer.Prefixer, code string) bool
+	SaveWebAuthnChallenge(db prefixer.Prefixer, challenge string) error
+	CheckAndClearWebAuthnChallenge(db prefixer.Prefixer, challenge string) bool
+	UseTOTPTimeStep(db prefixer.Prefixer, step int64) bool
 }
 
 // sessionCodeTTL is the time an entry for a session_code stays alive (1 week)
@@ -24,6 +28,13 @@ var sessionCodeTTL = 7 * 24 * time.Hour
 // emailVerifiedCodeTTL is the time an entry for an email_verified_code stays alive
 var emailVerifiedCodeTTL = 15 * time.Minute
 
+// webauthnChallengeTTL is the time a WebAuthn challenge can be used
+var webauthnChallengeTTL = 5 * time.Minute
+
+// totpTimeStepTTL is the time a TOTP time-step is remembered after its use.
+// It must be longer than the window of the accepted codes (with the skew).
+var totpTimeStepTTL = 5 * time.Minute
+
 // storeCleanInterval is the time interval between each cleanup.
 var storeCleanInterval = 1 * time.Hour
 
@@ -112,6 +123,37 @@ func (s *memStore) CheckEmailVerifiedCode(db prefixer.Prefixer, code string) boo
 	return true
 }
 
+func (s *memStore) SaveWebAuthnChallenge(db prefixer.Prefixer, challenge string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := webauthnChallengeKey(db, challenge)
+	s.vals[key] = time.Now().Add(webauthnChallengeTTL)
+	return nil
+}
+
+func (s *memStore) CheckAndClearWebAuthnChallenge(db prefixer.Prefixer, challenge string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := webauthnChallengeKey(db, challenge)
+	exp, ok := s.vals[key]
+	if !ok {
+		return false
+	}
+	delete(s.vals, key)
+	return time.Now().Before(exp)
+}
+
+func (s *memStore) UseTOTPTimeStep(db prefixer.Prefixer, step int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := totpTimeStepKey(db, step)
+	if exp, ok := s.vals[key]; ok && time.Now().Before(exp) {
+		return false
+	}
+	s.vals[key] = time.Now().Add(totpTimeStepTTL)
+	return true
+}
+
 type redisStore struct {
 	c   redis.UniversalClient
 	ctx context.Context
@@ -139,6 +181,23 @@ func (s *redisStore) CheckEmailVerifiedCode(db prefixer.Prefixer, code string) b
 	return err == nil && n > 0
 }
 
+func (s *redisStore) SaveWebAuthnChallenge(db prefixer.Prefixer, challenge string) error {
+	key := webauthnChallengeKey(db, challenge)
+	return s.c.Set(s.ctx, key, "1", webauthnChallengeTTL).Err()
+}
+
+func (s *redisStore) CheckAndClearWebAuthnChallenge(db prefixer.Prefixer, challenge string) bool {
+	key := webauthnChallengeKey(db, challenge)
+	n, err := s.c.Del(s.ctx, key).Result()
+	return err == nil && n > 0
+}
+
+func (s *redisStore) UseTOTPTimeStep(db prefixer.Prefixer, step int64) bool {
+	key := totpTimeStepKey(db, step)
+	ok, err := s.c.SetNX(s.ctx, key, "1", totpTimeStepTTL).Result()
+	return err == nil && ok
+}
+
 func sessionCodeKey(db prefixer.Prefixer, suffix string) string {
 	return db.DBPrefix() + ":sessioncode:" + suffix
 }
@@ -146,3 +205,11 @@ func sessionCodeKey(db prefixer.Prefixer, suffix string) string {
 func emailVerifiedCodeKey(db prefixer.Prefixer, suffix string) string {
 	return db.DBPrefix() + ":emailverifiedcode:" + suffix
 }
+
+func webauthnChallengeKey(db prefixer.Prefixer, suffix string) string {
+	return db.DBPrefix() + ":webauthnchallenge:" + suffix
+}
+
+func totpTimeStepKey(db prefixer.Prefixer, step int64) string {
+	return db.DBPrefix() + ":totpstep:" + strconv.FormatInt(step, 10)
+}
@@ -0,0 +1,239 @@
+package instance
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"image/png"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// TOTPRecoveryCodesCount is the number of recovery codes generated when an
+// authenticator app is enrolled.
+const TOTPRecoveryCodesCount = 10
+
+// ErrInvalidTOTPPasscode is used when the code given for enrolling an
+// authenticator app is not valid.
+var ErrInvalidTOTPPasscode = errors.New("Invalid passcode")
+
+var (
+	errCannotEncryptTOTPSecret = errors.New("cannot encrypt the TOTP secret")
+	errCannotDecryptTOTPSecret = errors.New("cannot decrypt the TOTP secret")
+)
+
+// The options are the default ones for the authenticator apps (RFC 6238 with
+// HMAC-SHA1, 6 digits and a 30 seconds period). A skew of 1 is accepted to
+// allow a small drift of the clock of the phone.
+var authenticatorTOTPOptions = totp.ValidateOpts{
+	Period:    30,
+	Skew:      1,
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+var totpEnrollmentMACConfig = crypto.MACConfig{
+	Name:   "totp-enrollment",
+	MaxAge: 15 * time.Minute,
+	MaxLen: 512,
+}
+
+// TOTPEnrollment is what is given to the user to enroll an authenticator app:
+// the secret, as text, as an otpauth:// URI and as a QR code, and a token to
+// send back with the first code from the app.
+type TOTPEnrollment struct {
+	Token  string `json:"token"`
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+	QRCode string `json:"qr_code"` // data: URL of a PNG image
+}
+
+// HasTOTP returns true if an authenticator app has been enrolled.
+func (i *Instance) HasTOTP() bool {
+	return i.TOTPSecret != ""
+}
+
+// totpNonceLen is the length of the nonce for encrypting the TOTP secret.
+const totpNonceLen = 24
+
+// encryptTOTPSecret encrypts the TOTP secret with the credentials key of the
+// vault, like the accounts credentials. This key does not change with the
+// passphrase, contrary to the session secret of the instance.
+func encryptTOTPSecret(secret string) (string, error) {
+	key := config.GetKeyring().CredentialsEncryptorKey()
+	if key == nil {
+		return "", errCannotEncryptTOTPSecret
+	}
+	var nonce [totpNonceLen]byte
+	copy(nonce[:], crypto.GenerateRandomBytes(totpNonceLen))
+	encrypted := box.Seal(nonce[:], []byte(secret), &nonce, key.PublicKey(), key.PrivateKey())
+	return base64.StdEncoding.EncodeToString(encrypted), nil
+}
+
+func decryptTOTPSecret(encrypted string) (string, error) {
+	key := config.GetKeyring().CredentialsDecryptorKey()
+	if key == nil {
+		return "", errCannotDecryptTOTPSecret
+	}
+	data, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil || len(data) < totpNonceLen {
+		return "", errCannotDecryptTOTPSecret
+	}
+	var nonce [totpNonceLen]byte
+	copy(nonce[:], data[:totpNonceLen])
+	secret, ok := box.Open(nil, data[totpNonceLen:], &nonce, key.PublicKey(), key.PrivateKey())
+	if !ok {
+		return "", errCannotDecryptTOTPSecret
+	}
+	return string(secret), nil
+}
+
+// GenerateTOTPEnrollment creates a new secret for an authenticator app. The
+// secret is not saved on the instance: it is encrypted in the returned token,
+// and it will be saved when the user has confirmed it with a first code.
+func (i *Instance) GenerateTOTPEnrollment() (*TOTPEnrollment, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      i.TemplateTitle(),
+		AccountName: i.Domain,
+		Period:      uint(authenticatorTOTPOptions.Period),
+		Digits:      authenticatorTOTPOptions.Digits,
+		Algorithm:   authenticatorTOTPOptions.Algorithm,
+	})
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := encryptTOTPSecret(key.Secret())
+	if err != nil {
+		return nil, err
+	}
+	token, err := crypto.EncodeAuthMessage(totpEnrollmentMACConfig, i.SessionSecret(), []byte(encrypted), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := key.Image(256, 256)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Token:  string(token),
+		Secret: key.Secret(),
+		URI:    key.URL(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment checks the first code given by the authenticator app
+// for the secret in the enrollment token. If it is valid, the encrypted
+// secret is returned, ready to be saved on the instance.
+func (i *Instance) ConfirmTOTPEnrollment(token []byte, passcode string) (string, error) {
+	encrypted, err := crypto.DecodeAuthMessage(totpEnrollmentMACConfig, i.SessionSecret(), token, nil)
+	if err != nil {
+		return "", ErrInvalidTOTPPasscode
+	}
+	secret, err := decryptTOTPSecret(string(encrypted))
+	if err != nil {
+		return "", ErrInvalidTOTPPasscode
+	}
+	if _, ok := validateAuthenticatorPasscode(secret, passcode); !ok {
+		return "", ErrInvalidTOTPPasscode
+	}
+	return string(encrypted), nil
+}
+
+// ValidateTOTPPasscode returns true if the passcode is the current code of
+// the enrolled authenticator app. A code can be used only once: the time-step
+// of an accepted code is remembered, and the same code is then rejected.
+func (i *Instance) ValidateTOTPPasscode(passcode string) bool {
+	if !i.HasTOTP() {
+		return false
+	}
+	secret, err := decryptTOTPSecret(i.TOTPSecret)
+	if err != nil {
+		i.Logger().WithNamespace("auth").Warnf("Cannot decrypt the TOTP secret: %s", err)
+		return false
+	}
+	step, ok := validateAuthenticatorPasscode(secret, passcode)
+	if !ok {
+		return false
+	}
+	return GetStore().UseTOTPTimeStep(i, step)
+}
+
+// validateAuthenticatorPasscode checks the passcode against the codes of the
+// time-steps in the skew window, and returns the time-step of the matching
+// code.
+func validateAuthenticatorPasscode(secret, passcode string) (int64, bool) {
+	passcode = strings.ReplaceAll(strings.TrimSpace(passcode), " ", "")
+	if len(passcode) != authenticatorTOTPOptions.Digits.Length() {
+		return 0, false
+	}
+	period := int64(authenticatorTOTPOptions.Period)
+	now := time.Now().UTC().Unix() / period
+	skew := int64(authenticatorTOTPOptions.Skew)
+	for step := now - skew; step <= now+skew; step++ {
+		code, err := totp.GenerateCodeCustom(secret, time.Unix(step*period, 0).UTC(), authenticatorTOTPOptions)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(code), []byte(passcode)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateTOTPRecoveryCodes returns a new set of recovery codes, and the
+// hashes of those codes to save on the instance. The codes are only shown
+// once to the user.
+func GenerateTOTPRecoveryCodes() (codes, hashes []string) {
+	codes = make([]string, TOTPRecoveryCodesCount)
+	hashes = make([]string, TOTPRecoveryCodesCount)
+	for k := range codes {
+		code := strings.ToLower(crypto.GenerateRandomString(10))
+		codes[k] = code[:5] + "-" + code[5:]
+		hashes[k] = hashRecoveryCode(codes[k])
+	}
+	return codes, hashes
+}
+
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	code = strings.ReplaceAll(code, " ", "")
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// UseTOTPRecoveryCode returns true if the code is one of the recovery codes
+// of the instance. As a recovery code can be used only once, it is removed
+// from the instance, and the instance is saved.
+func (i *Instance) UseTOTPRecoveryCode(code string) bool {
+	hash := hashRecoveryCode(code)
+	for k, h := range i.TOTPRecoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+		i.TOTPRecoveryCodes = append(i.TOTPRecoveryCodes[:k:k], i.TOTPRecoveryCodes[k+1:]...)
+		if err := Update(i); err != nil {
+			i.Logger().WithNamespace("auth").Errorf("Cannot remove the used recovery code: %s", err)
+			return false
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,336 @@
+package instance
+
+import (
+	"encoding/base64"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/webauthn"
+	"golang.org/x/net/publicsuffix"
+)
+
+// The purposes of the WebAuthn challenges. A challenge created for a purpose
+// cannot be used for another one.
+const (
+	WebAuthnRegistration = "registration"
+	WebAuthnTwoFactor    = "twofactor"
+	WebAuthnLogin        = "login"
+)
+
+// ErrUnknownWebAuthnCredential is used when an assertion is made with a
+// credential that has not been registered on this instance.
+var ErrUnknownWebAuthnCredential = errors.New("Unknown WebAuthn credential")
+
+var webauthnMACConfig = crypto.MACConfig{
+	Name:   "webauthn",
+	MaxAge: 5 * time.Minute,
+	MaxLen: 256,
+}
+
+// WebAuthnCredential is a passkey or a security key that has been registered
+// by the user. It can be used as a second factor, and for the passwordless
+// login if it is a discoverable credential with user verification.
+type WebAuthnCredential struct {
+	DocID        string     `json:"_id,omitempty"`
+	DocRev       string     `json:"_rev,omitempty"`
+	Name         string     `json:"name"`
+	CredentialID string     `json:"credential_id"` // base64url
+	PublicKey    []byte     `json:"public_key"`    // COSE_Key format
+	SignCount    uint32     `json:"sign_count"`
+	AAGUID       []byte     `json:"aaguid,omitempty"`
+	Passwordless bool       `json:"passwordless"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// ID returns the credential qualified identifier
+func (w *WebAuthnCredential) ID() string { return w.DocID }
+
+// Rev returns the credential revision
+func (w *WebAuthnCredential) Rev() string { return w.DocRev }
+
+// DocType returns the credential document type
+func (w *WebAuthnCredential) DocType() string { return consts.WebAuthnCredentials }
+
+// Clone implements couchdb.Doc
+func (w *WebAuthnCredential) Clone() couchdb.Doc {
+	cloned := *w
+	cloned.PublicKey = append([]byte{}, w.PublicKey...)
+	cloned.AAGUID = append([]byte{}, w.AAGUID...)
+	if w.LastUsedAt != nil {
+		last := *w.LastUsedAt
+		cloned.LastUsedAt = &last
+	}
+	return &cloned
+}
+
+// SetID changes the credential qualified identifier
+func (w *WebAuthnCredential) SetID(id string) { w.DocID = id }
+
+// SetRev changes the credential revision
+func (w *WebAuthnCredential) SetRev(rev string) { w.DocRev = rev }
+
+// Included is part of jsonapi.Object interface
+func (w *WebAuthnCredential) Included() []jsonapi.Object { return nil }
+
+// Relationships is part of jsonapi.Object interface
+func (w *WebAuthnCredential) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Links is part of jsonapi.Object interface
+func (w *WebAuthnCredential) Links() *jsonapi.LinksList { return nil }
+
+func (w *WebAuthnCredential) credential() *webauthn.Credential {
+	id, _ := webauthn.DecodeBase64(w.CredentialID)
+	return &webauthn.Credential{
+		ID:        id,
+		PublicKey: w.PublicKey,
+		SignCount: w.SignCount,
+	}
+}
+
+// RelyingParty returns the WebAuthn relying party for this instance. The
+// credentials can be registered from the settings application, so the ID of
+// the relying party is the longest domain that is a registrable suffix of
+// the instance and of the settings sub-domain: the instance domain for
+// nested sub-domains, and its parent domain for flat sub-domains. If the
+// parent domain is a public suffix, the settings origin is not allowed.
+func (i *Instance) RelyingParty() *webauthn.RelyingParty {
+	domain := i.ContextualDomain()
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	rp := &webauthn.RelyingParty{
+		ID:      host,
+		Name:    i.TemplateTitle(),
+		Origins: []string{i.Scheme() + "://" + domain},
+	}
+	settings := i.SubDomain(consts.SettingsSlug)
+	if id, ok := registrableSuffix(host, settings.Hostname()); ok {
+		rp.ID = id
+		rp.Origins = append(rp.Origins, settings.Scheme+"://"+settings.Host)
+	}
+	return rp
+}
+
+// registrableSuffix returns the longest domain that is a suffix of a and b,
+// if it is not a public suffix.
+func registrableSuffix(a, b string) (string, bool) {
+	labelsA := strings.Split(a, ".")
+	labelsB := strings.Split(b, ".")
+	n := 0
+	for n < len(labelsA) && n < len(labelsB) &&
+		labelsA[len(labelsA)-1-n] == labelsB[len(labelsB)-1-n] {
+		n++
+	}
+	if n == 0 {
+		return "", false
+	}
+	suffix := strings.Join(labelsA[len(labelsA)-n:], ".")
+	if _, err := publicsuffix.EffectiveTLDPlusOne(suffix); err != nil {
+		return "", false
+	}
+	return suffix, true
+}
+
+// WebAuthnUser returns the user account for the WebAuthn credentials. The
+// identifier is not a personal information, as required by the spec.
+func (i *Instance) WebAuthnUser() webauthn.User {
+	name := i.Domain
+	displayName := name
+	if publicName, err := i.SettingsPublicName(); err == nil && publicName != "" {
+		displayName = publicName
+	}
+	return webauthn.User{
+		ID:          []byte(i.DocID),
+		Name:        name,
+		DisplayName: displayName,
+	}
+}
+
+// CreateWebAuthnChallenge returns a new random challenge for the given
+// purpose, and a token that must be sent back with the response of the
+// authenticator. The binding is optional data that must also be given when
+// the token is checked (like the two-factor token). The challenge is kept in
+// the store, as it can be used only once.
+func (i *Instance) CreateWebAuthnChallenge(purpose string, binding []byte) (challenge, token []byte, err error) {
+	challenge = crypto.GenerateRandomBytes(webauthn.ChallengeLen)
+	token, err = crypto.EncodeAuthMessage(webauthnMACConfig, i.SessionSecret(), challenge, webauthnAdditionalData(purpose, binding))
+	if err != nil {
+		return nil, nil, err
+	}
+	key := base64.RawURLEncoding.EncodeToString(challenge)
+	if err = GetStore().SaveWebAuthnChallenge(i, key); err != nil {
+		return nil, nil, err
+	}
+	return challenge, token, nil
+}
+
+// CheckWebAuthnChallenge returns the challenge of the given token, if it has
+// been created for this purpose and binding, and is not expired. The
+// challenge is consumed: a second call with the same token will fail.
+func (i *Instance) CheckWebAuthnChallenge(purpose string, binding, token []byte) ([]byte, error) {
+	challenge, err := crypto.DecodeAuthMessage(webauthnMACConfig, i.SessionSecret(), token, webauthnAdditionalData(purpose, binding))
+	if err != nil {
+		return nil, err
+	}
+	key := base64.RawURLEncoding.EncodeToString(challenge)
+	if !GetStore().CheckAndClearWebAuthnChallenge(i, key) {
+		return nil, webauthn.ErrChallengeMismatch
+	}
+	return challenge, nil
+}
+
+func webauthnAdditionalData(purpose string, binding []byte) []byte {
+	return append([]byte(purpose+":"), binding...)
+}
+
+// GetWebAuthnCredentials returns the list of the WebAuthn credentials
+// registered on this instance.
+func GetWebAuthnCredentials(i *Instance) ([]*WebAuthnCredential, error) {
+	var creds []*WebAuthnCredential
+	req := &couchdb.AllDocsRequest{Limit: 1000}
+	if err := couchdb.GetAllDocs(i, consts.WebAuthnCredentials, req, &creds); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return creds, nil
+}
+
+// HasWebAuthnCredentials returns true if at least one credential has been
+// registered, and, if passwordless is true, can be used without the password.
+func (i *Instance) HasWebAuthnCredentials(passwordless bool) bool {
+	creds, err := GetWebAuthnCredentials(i)
+	if err != nil {
+		return false
+	}
+	for _, cred := range creds {
+		if cred.Passwordless || !passwordless {
+			return true
+		}
+	}
+	return false
+}
+
+// WebAuthnCreationOptions returns a challenge token and the options for
+// registering a new credential.
+func (i *Instance) WebAuthnCreationOptions(passwordless bool) ([]byte, *webauthn.CreationOptions, error) {
+	creds, err := GetWebAuthnCredentials(i)
+	if err != nil {
+		return nil, nil, err
+	}
+	exclude := make([][]byte, 0, len(creds))
+	for _, cred := range creds {
+		exclude = append(exclude, cred.credential().ID)
+	}
+	challenge, token, err := i.CreateWebAuthnChallenge(WebAuthnRegistration, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := i.RelyingParty().CreationOptions(i.WebAuthnUser(), challenge, exclude, passwordless)
+	return token, opts, nil
+}
+
+// WebAuthnRequestOptions returns a challenge token and the options for an
+// authentication. For the passwordless login, a discoverable credential is
+// asked, with the verification of the user.
+func (i *Instance) WebAuthnRequestOptions(purpose string, binding []byte) ([]byte, *webauthn.RequestOptions, error) {
+	passwordless := purpose == WebAuthnLogin
+	var allow [][]byte
+	if !passwordless {
+		creds, err := GetWebAuthnCredentials(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, cred := range creds {
+			allow = append(allow, cred.credential().ID)
+		}
+	}
+	challenge, token, err := i.CreateWebAuthnChallenge(purpose, binding)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, i.RelyingParty().RequestOptions(challenge, allow, passwordless), nil
+}
+
+// RegisterWebAuthnCredential checks the response of the authenticator for
+// the given challenge token, and saves the new credential.
+func (i *Instance) RegisterWebAuthnCredential(name string, token []byte, res *webauthn.AttestationResponse, passwordless bool) (*WebAuthnCredential, error) {
+	challenge, err := i.CheckWebAuthnChallenge(WebAuthnRegistration, nil, token)
+	if err != nil {
+		return nil, webauthn.ErrChallengeMismatch
+	}
+	cred, err := i.RelyingParty().VerifyRegistration(challenge, res, passwordless)
+	if err != nil {
+		return nil, err
+	}
+	if name = strings.TrimSpace(name); name == "" {
+		name = "Passkey"
+	}
+	doc := &WebAuthnCredential{
+		Name:         name,
+		CredentialID: base64.RawURLEncoding.EncodeToString(cred.ID),
+		PublicKey:    cred.PublicKey,
+		SignCount:    cred.SignCount,
+		AAGUID:       cred.AAGUID,
+		Passwordless: passwordless,
+		CreatedAt:    time.Now().UTC(),
+	}
+	if err := couchdb.CreateDoc(i, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// CheckWebAuthnAssertion checks the response of the authenticator for the
+// given purpose and challenge token. The credential must have been
+// registered on this instance, and for the passwordless login, it must have
+// been registered for this usage.
+func (i *Instance) CheckWebAuthnAssertion(purpose string, binding, token []byte, credentialID string, res *webauthn.AssertionResponse) error {
+	challenge, err := i.CheckWebAuthnChallenge(purpose, binding, token)
+	if err != nil {
+		return webauthn.ErrChallengeMismatch
+	}
+	creds, err := GetWebAuthnCredentials(i)
+	if err != nil {
+		return err
+	}
+	credentialID = strings.TrimRight(credentialID, "=")
+	var doc *WebAuthnCredential
+	for _, cred := range creds {
+		if cred.CredentialID == credentialID {
+			doc = cred
+		}
+	}
+	passwordless := purpose == WebAuthnLogin
+	if doc == nil || (passwordless && !doc.Passwordless) {
+		return ErrUnknownWebAuthnCredential
+	}
+
+	count, err := i.RelyingParty().VerifyAssertion(challenge, doc.credential(), res, passwordless)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	doc.SignCount = count
+	doc.LastUsedAt = &now
+	return couchdb.UpdateDoc(i, doc)
+}
+
+// DeleteWebAuthnCredential removes a credential.
+func (i *Instance) DeleteWebAuthnCredential(doc *WebAuthnCredential) error {
+	return couchdb.DeleteDoc(i, doc)
+}
+
+var (
+	_ couchdb.Doc    = &WebAuthnCredential{}
+	_ jsonapi.Object = &WebAuthnCredential{}
+)
@@ -203,6 +203,11 @@ func (c *TaskContext) UnmarshalPayload() (map[string]interface{}, error) {
 	return payload, nil
 }
 
+// JobID returns the identifier of the job executed by the worker.
+func (c *TaskContext) JobID() string {
+	return c.job.ID()
+}
+
 // TriggerID returns the possible trigger identifier responsible for launching
 // the job.
 func (c *TaskContext) TriggerID() (string, bool) {
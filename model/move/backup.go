@@ -0,0 +1,543 @@
+package move
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+const (
+	// BackupWorker is the name of the worker that creates the backups.
+	BackupWorker = "backup"
+	// RestoreWorker is the name of the worker that restores a backup.
+	RestoreWorker = "restore"
+)
+
+// backupIDFormat is the layout used for the identifiers of the backups. They
+// are sortable, and the lexical order is the chronological order.
+const backupIDFormat = "20060102T150405Z"
+
+// backupChangesLimit is the number of changes fetched at once from the
+// changes feed of CouchDB.
+const backupChangesLimit = 1000
+
+// BackupOptions contains the options for launching the backup worker.
+type BackupOptions struct {
+	Full bool `json:"full,omitempty"`
+}
+
+// Backup is the manifest of a backup. It is stored next to the zip archive,
+// and it is written last: an archive without a manifest is an incomplete
+// backup and is ignored.
+//
+// A full backup has no parent. An incremental backup only contains the
+// documents changed since its parent, and the content of the files whose
+// md5sum differs from the one saved in the chain. A chain is a full backup
+// and its incremental backups, and is identified by the ID of the full
+// backup.
+type Backup struct {
+	ID        string              `json:"id"`
+	Domain    string              `json:"domain"`
+	Parent    string              `json:"parent,omitempty"`
+	Chain     string              `json:"chain"`
+	CreatedAt time.Time           `json:"created_at"`
+	Sequences map[string]string   `json:"sequences"`
+	Deleted   map[string][]string `json:"deleted,omitempty"`
+	// MD5Sums are the md5sums of the files whose content is in the archive,
+	// indexed by the file ID.
+	MD5Sums map[string][]byte `json:"md5sums,omitempty"`
+	NbDocs  int               `json:"nb_docs"`
+	NbFiles int               `json:"nb_files"`
+	Size    int64             `json:"size"`
+}
+
+func newBackup(domain string, parent *Backup, now time.Time) *Backup {
+	b := &Backup{
+		ID:        now.UTC().Format(backupIDFormat),
+		Domain:    domain,
+		CreatedAt: now,
+		Sequences: make(map[string]string),
+		Deleted:   make(map[string][]string),
+		MD5Sums:   make(map[string][]byte),
+	}
+	b.Chain = b.ID
+	if parent != nil {
+		b.Parent = parent.ID
+		b.Chain = parent.Chain
+	}
+	return b
+}
+
+// IsFull returns true if the backup is not an incremental backup.
+func (b *Backup) IsFull() bool {
+	return b.Parent == ""
+}
+
+func (b *Backup) archiveName() string {
+	return path.Join(b.Domain, b.ID+".zip")
+}
+
+func (b *Backup) manifestName() string {
+	return path.Join(b.Domain, b.ID+".json")
+}
+
+// CreateBackup creates a new backup of the instance on the given target. It
+// is an incremental backup of the last one, unless a full backup is asked or
+// the current chain already has enough incremental backups. The chains that
+// are too old are removed after the backup has been created.
+func CreateBackup(inst *instance.Instance, opts BackupOptions, target BackupTarget) (*Backup, error) {
+	mu := config.Lock().ReadWrite(inst, "backups")
+	if err := mu.Lock(); err != nil {
+		return nil, err
+	}
+	defer mu.Unlock()
+
+	backups, err := ListBackups(target, inst.Domain)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := config.GetConfig().Backups
+	var parent *Backup
+	if !opts.Full {
+		parent = nextBackupParent(backups, cfg.Incrementals)
+	}
+	var known map[string][]byte
+	if parent != nil {
+		chain, err := BackupChain(backups, parent.ID)
+		if err != nil {
+			return nil, err
+		}
+		known = chainMD5Sums(chain)
+	}
+	b := newBackup(inst.Domain, parent, time.Now())
+	if err := writeBackup(inst, b, parent, known, target); err != nil {
+		return nil, err
+	}
+
+	expired := expiredBackups(append(backups, b), cfg.KeepChains)
+	if err := removeBackups(target, expired); err != nil {
+		inst.Logger().WithNamespace("backups").
+			Warnf("Cannot remove old backups: %s", err)
+	}
+	return b, nil
+}
+
+// ListBackups returns the complete backups of the given domain, from the
+// oldest to the most recent.
+func ListBackups(target BackupTarget, domain string) ([]*Backup, error) {
+	names, err := target.List(domain)
+	if err != nil {
+		return nil, err
+	}
+	var backups []*Backup
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		b, err := readBackupManifest(target, name)
+		if err != nil {
+			return nil, err
+		}
+		backups = append(backups, b)
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].ID < backups[j].ID
+	})
+	return backups, nil
+}
+
+func readBackupManifest(target BackupTarget, name string) (*Backup, error) {
+	r, err := target.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	var b Backup
+	err = json.NewDecoder(r).Decode(&b)
+	if errc := r.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// nextBackupParent returns the backup on which the next incremental backup
+// should be based, or nil if the next backup should be a full backup.
+func nextBackupParent(backups []*Backup, incrementals int) *Backup {
+	if len(backups) == 0 {
+		return nil
+	}
+	last := backups[len(backups)-1]
+	inChain := 0
+	for _, b := range backups {
+		if b.Chain == last.Chain {
+			inChain++
+		}
+	}
+	// The chain contains the full backup and its incremental backups
+	if inChain > incrementals {
+		return nil
+	}
+	return last
+}
+
+// expiredBackups returns the backups that are not in the keepChains most
+// recent chains. At least one chain is always kept.
+func expiredBackups(backups []*Backup, keepChains int) []*Backup {
+	if keepChains < 1 {
+		keepChains = 1
+	}
+	var chains []string
+	for _, b := range backups {
+		if b.IsFull() {
+			chains = append(chains, b.Chain)
+		}
+	}
+	sort.Strings(chains)
+	if len(chains) <= keepChains {
+		return nil
+	}
+	oldest := chains[len(chains)-keepChains]
+	var expired []*Backup
+	for _, b := range backups {
+		if b.Chain < oldest {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// BackupChain returns the backups that must be restored, in order, to get
+// the state of the backup with the given ID (or the most recent one if the
+// ID is empty).
+func BackupChain(backups []*Backup, id string) ([]*Backup, error) {
+	if len(backups) == 0 {
+		return nil, ErrBackupNotFound
+	}
+	byID := make(map[string]*Backup, len(backups))
+	for _, b := range backups {
+		byID[b.ID] = b
+	}
+	if id == "" {
+		id = backups[len(backups)-1].ID
+	}
+	var chain []*Backup
+	for id != "" {
+		b, ok := byID[id]
+		if !ok {
+			return nil, ErrBackupNotFound
+		}
+		chain = append(chain, b)
+		id = b.Parent
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// removeBackups removes the archives and the manifests of the given backups.
+// The manifests are removed first, so that an interrupted removal does not
+// leave a manifest without its archive.
+func removeBackups(target BackupTarget, backups []*Backup) error {
+	if len(backups) == 0 {
+		return nil
+	}
+	manifests := make([]string, len(backups))
+	archives := make([]string, len(backups))
+	for i, b := range backups {
+		manifests[i] = b.manifestName()
+		archives[i] = b.archiveName()
+	}
+	if err := target.Remove(manifests); err != nil {
+		return err
+	}
+	return target.Remove(archives)
+}
+
+// chainMD5Sums returns the md5sums of the last content saved for each file in
+// the given chain of backups.
+func chainMD5Sums(chain []*Backup) map[string][]byte {
+	sums := make(map[string][]byte)
+	for _, b := range chain {
+		for id, sum := range b.MD5Sums {
+			sums[id] = sum
+		}
+	}
+	return sums
+}
+
+func writeBackup(inst *instance.Instance, b, parent *Backup, known map[string][]byte, target BackupTarget) error {
+	out, err := target.Create(b.archiveName())
+	if err != nil {
+		return err
+	}
+	cw := &countingWriter{w: out}
+	zw := zip.NewWriter(cw)
+	bw := &backupWriter{
+		inst:   inst,
+		fs:     inst.VFS(),
+		finder: newFileFinderWithCache(inst.VFS()),
+		zw:     zw,
+		backup: b,
+		parent: parent,
+		known:  known,
+	}
+	err = bw.write()
+	if errc := zw.Close(); err == nil {
+		err = errc
+	}
+	if errc := out.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		_ = target.Remove([]string{b.archiveName()})
+		return err
+	}
+	b.Size = cw.n
+
+	manifest, err := target.Create(b.manifestName())
+	if err != nil {
+		_ = target.Remove([]string{b.archiveName()})
+		return err
+	}
+	err = json.NewEncoder(manifest).Encode(b)
+	if errc := manifest.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		_ = target.Remove([]string{b.manifestName(), b.archiveName()})
+	}
+	return err
+}
+
+type backupWriter struct {
+	inst   *instance.Instance
+	fs     vfs.VFS
+	finder *fileFinderWithCache
+	zw     *zip.Writer
+	backup *Backup
+	parent *Backup
+	// known are the md5sums of the contents already saved in the chain
+	known map[string][]byte
+}
+
+func (bw *backupWriter) write() error {
+	_ = note.FlushPendings(bw.inst)
+
+	doctypes, err := couchdb.AllDoctypes(bw.inst)
+	if err != nil {
+		return err
+	}
+	sort.Strings(doctypes)
+	for _, doctype := range doctypes {
+		if err := bw.writeDoctype(doctype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bw *backupWriter) writeDoctype(doctype string) error {
+	since := ""
+	if bw.parent != nil {
+		since = bw.parent.Sequences[doctype]
+	}
+	for {
+		res, err := couchdb.GetChanges(bw.inst, &couchdb.ChangesRequest{
+			DocType:     doctype,
+			Since:       since,
+			Limit:       backupChangesLimit,
+			IncludeDocs: true,
+		})
+		if couchdb.IsNoDatabaseError(err) {
+			// The database may have been deleted since the doctypes were listed
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, change := range res.Results {
+			if strings.HasPrefix(change.DocID, "_design") {
+				continue
+			}
+			if change.Deleted {
+				// The deletions are only useful when restoring an incremental
+				// backup on top of the previous ones.
+				if bw.parent != nil {
+					bw.backup.Deleted[doctype] = append(bw.backup.Deleted[doctype], change.DocID)
+				}
+				continue
+			}
+			if err := bw.writeChange(doctype, change); err != nil {
+				return err
+			}
+		}
+		since = res.LastSeq
+		if res.Pending == 0 || len(res.Results) == 0 {
+			break
+		}
+	}
+	bw.backup.Sequences[doctype] = since
+	return nil
+}
+
+func (bw *backupWriter) writeChange(doctype string, change couchdb.Change) error {
+	doc, err := json.Marshal(change.Doc.M)
+	if err != nil {
+		return err
+	}
+	name := path.Join(ExportDataDir, url.PathEscape(doctype), change.DocID+".json")
+	if err := bw.writeEntry(name, bytes.NewReader(doc), time.Now()); err != nil {
+		return err
+	}
+	bw.backup.NbDocs++
+
+	switch doctype {
+	case consts.Files:
+		return bw.writeFileContent(doc)
+	case consts.FilesVersions:
+		return bw.writeVersionContent(doc)
+	}
+	return nil
+}
+
+// writeFileContent adds the content of a file just after its document, if it
+// is not already saved in the chain.
+func (bw *backupWriter) writeFileContent(raw []byte) error {
+	var doc vfs.DirOrFileDoc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	_, file := doc.Refine()
+	if file == nil || !bw.hasNewContent(file) {
+		return nil
+	}
+	f, err := bw.fs.OpenFile(file)
+	if err != nil {
+		// Ignore missing file, as it may happen that a file is deleted while
+		// a backup is running (or the file system is not clean)
+		return nil
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	bw.backup.NbFiles++
+	if len(file.MD5Sum) > 0 {
+		bw.backup.MD5Sums[file.DocID] = file.MD5Sum
+	}
+	return bw.writeEntry(path.Join(ExportFilesDir, file.DocID), f, file.UpdatedAt)
+}
+
+// hasNewContent returns true if the content of the file must be added to the
+// backup. The timestamps can't be used for that, as the files of a sharing
+// keep the dates of the sender: the md5sums are compared instead.
+func (bw *backupWriter) hasNewContent(file *vfs.FileDoc) bool {
+	if bw.parent == nil || len(file.MD5Sum) == 0 {
+		return true
+	}
+	known, ok := bw.known[file.DocID]
+	return !ok || !bytes.Equal(known, file.MD5Sum)
+}
+
+// writeVersionContent adds the content of an old version of a file just after
+// its document. The versions are immutable, so a new version document always
+// comes with its content.
+func (bw *backupWriter) writeVersionContent(raw []byte) error {
+	var version vfs.Version
+	if err := json.Unmarshal(raw, &version); err != nil {
+		return err
+	}
+	file, err := bw.finder.Find(version.DocID)
+	if err != nil {
+		return nil
+	}
+	f, err := bw.fs.OpenFileVersion(file, &version)
+	if err != nil {
+		return nil
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	return bw.writeEntry(path.Join(ExportVersionsDir, version.DocID), f, version.UpdatedAt)
+}
+
+func (bw *backupWriter) writeEntry(name string, r io.Reader, modified time.Time) error {
+	header := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modified,
+	}
+	header.SetMode(0640)
+	w, err := bw.zw.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// EnsureBackupTrigger creates the @daily trigger that makes the backups of
+// the instance, if it doesn't exist yet.
+func EnsureBackupTrigger(inst *instance.Instance) error {
+	sched := job.System()
+	infos := job.TriggerInfos{
+		Type:       "@daily",
+		WorkerType: BackupWorker,
+		Arguments:  "between 1am and 5am",
+	}
+	if sched.HasTrigger(inst, infos) {
+		return nil
+	}
+	trigger, err := job.NewTrigger(inst, infos, BackupOptions{})
+	if err != nil {
+		return err
+	}
+	return sched.AddTrigger(trigger)
+}
+
+// RemoveBackupTrigger removes the trigger for the scheduled backups of the
+// instance. The existing backups are kept.
+func RemoveBackupTrigger(inst *instance.Instance) error {
+	sched := job.System()
+	triggers, err := sched.GetAllTriggers(inst)
+	if err != nil {
+		return err
+	}
+	for _, t := range triggers {
+		if t.Infos().WorkerType != BackupWorker {
+			continue
+		}
+		if err := sched.DeleteTrigger(inst, t.ID()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package move
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	multierror "github.com/hashicorp/go-multierror"
+	"github.com/ncw/swift/v2"
+	"github.com/spf13/afero"
+)
+
+// BackupTarget is an interface describing where the backups are stored. The
+// names are relative paths, like <domain>/<backup-id>.zip.
+type BackupTarget interface {
+	Create(name string) (io.WriteCloser, error)
+	Open(name string) (io.ReadCloser, error)
+	List(dir string) ([]string, error)
+	Remove(names []string) error
+}
+
+// SystemBackupTarget returns the target configured for the backups, or an
+// error if the backups are not configured.
+func SystemBackupTarget() (BackupTarget, error) {
+	return parseBackupTarget(config.GetConfig().Backups.Target)
+}
+
+func parseBackupTarget(target string) (BackupTarget, error) {
+	if target == "" {
+		return nil, ErrBackupsNotConfigured
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case config.SchemeFile:
+		fs := afero.NewBasePathFs(afero.NewOsFs(), u.Path)
+		return newAferoBackupTarget(fs), nil
+	case config.SchemeSwift:
+		if u.Host == "" {
+			return nil, fmt.Errorf("backups: missing swift container in %s", target)
+		}
+		return newSwiftBackupTarget(u.Host), nil
+	default:
+		return nil, fmt.Errorf("backups: unknown storage provider %s", u.Scheme)
+	}
+}
+
+func newAferoBackupTarget(fs afero.Fs) BackupTarget {
+	return aferoBackupTarget{fs}
+}
+
+type aferoBackupTarget struct {
+	fs afero.Fs
+}
+
+func (t aferoBackupTarget) Create(name string) (io.WriteCloser, error) {
+	f, err := t.fs.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsNotExist(err) {
+		if err = t.fs.MkdirAll(path.Dir(path.Join("/", name)), 0700); err == nil {
+			f, err = t.fs.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		}
+	}
+	return f, err
+}
+
+func (t aferoBackupTarget) Open(name string) (io.ReadCloser, error) {
+	return t.fs.Open(name)
+}
+
+func (t aferoBackupTarget) List(dir string) ([]string, error) {
+	infos, err := afero.ReadDir(t.fs, dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.IsDir() {
+			names = append(names, path.Join(dir, info.Name()))
+		}
+	}
+	return names, nil
+}
+
+func (t aferoBackupTarget) Remove(names []string) error {
+	var errm error
+	for _, name := range names {
+		if err := t.fs.Remove(name); err != nil && !os.IsNotExist(err) {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	return errm
+}
+
+func newSwiftBackupTarget(container string) BackupTarget {
+	return &swiftBackupTarget{
+		c:         config.GetSwiftConnection(),
+		container: container,
+		ctx:       context.Background(),
+	}
+}
+
+type swiftBackupTarget struct {
+	c         *swift.Connection
+	container string
+	ctx       context.Context
+}
+
+func (t *swiftBackupTarget) init() error {
+	if _, _, err := t.c.Container(t.ctx, t.container); errors.Is(err, swift.ContainerNotFound) {
+		if err = t.c.ContainerCreate(t.ctx, t.container, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *swiftBackupTarget) Create(name string) (io.WriteCloser, error) {
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	contentType := "application/zip"
+	if strings.HasSuffix(name, ".json") {
+		contentType = "application/json"
+	}
+	return t.c.ObjectCreate(t.ctx, t.container, name, true, "", contentType, nil)
+}
+
+func (t *swiftBackupTarget) Open(name string) (io.ReadCloser, error) {
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	f, _, err := t.c.ObjectOpen(t.ctx, t.container, name, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (t *swiftBackupTarget) List(dir string) ([]string, error) {
+	if err := t.init(); err != nil {
+		return nil, err
+	}
+	names, err := t.c.ObjectNamesAll(t.ctx, t.container, &swift.ObjectsOpts{
+		Prefix: dir + "/",
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *swiftBackupTarget) Remove(names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	if err := t.init(); err != nil {
+		return err
+	}
+	_, err := t.c.BulkDelete(t.ctx, t.container, names)
+	return err
+}
@@ -0,0 +1,259 @@
+package move
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackups(t *testing.T) {
+	now := time.Date(2024, 3, 11, 3, 15, 0, 0, time.UTC)
+	makeChain := func(start time.Time, n int) []*Backup {
+		var chain []*Backup
+		var parent *Backup
+		for i := 0; i < n; i++ {
+			b := newBackup("alice.example.net", parent, start.Add(time.Duration(i)*24*time.Hour))
+			chain = append(chain, b)
+			parent = b
+		}
+		return chain
+	}
+
+	t.Run("ParseBackupTarget", func(t *testing.T) {
+		_, err := parseBackupTarget("")
+		assert.ErrorIs(t, err, ErrBackupsNotConfigured)
+
+		target, err := parseBackupTarget("file://" + t.TempDir())
+		assert.NoError(t, err)
+		assert.IsType(t, aferoBackupTarget{}, target)
+
+		_, err = parseBackupTarget("swift://")
+		assert.Error(t, err)
+
+		_, err = parseBackupTarget("s3://bucket")
+		assert.Error(t, err)
+	})
+
+	t.Run("NewBackup", func(t *testing.T) {
+		full := newBackup("alice.example.net", nil, now)
+		assert.Equal(t, "20240311T031500Z", full.ID)
+		assert.Equal(t, full.ID, full.Chain)
+		assert.True(t, full.IsFull())
+		assert.Equal(t, "alice.example.net/20240311T031500Z.zip", full.archiveName())
+		assert.Equal(t, "alice.example.net/20240311T031500Z.json", full.manifestName())
+
+		incr := newBackup("alice.example.net", full, now.Add(time.Hour))
+		assert.Equal(t, "20240311T041500Z", incr.ID)
+		assert.Equal(t, full.ID, incr.Parent)
+		assert.Equal(t, full.ID, incr.Chain)
+		assert.False(t, incr.IsFull())
+	})
+
+	t.Run("HasNewContent", func(t *testing.T) {
+		chain := makeChain(now, 2)
+		chain[0].MD5Sums["file1"] = []byte("md5 v1")
+		chain[0].MD5Sums["file2"] = []byte("md5 v1")
+		chain[1].MD5Sums["file1"] = []byte("md5 v2")
+		known := chainMD5Sums(chain)
+		assert.Equal(t, []byte("md5 v2"), known["file1"])
+		assert.Equal(t, []byte("md5 v1"), known["file2"])
+
+		// A shared file keeps the dates of the sender, so only the md5sum
+		// says if its content has changed
+		old := now.Add(-30 * 24 * time.Hour)
+		file := &vfs.FileDoc{DocID: "file1", MD5Sum: []byte("md5 v3"), UpdatedAt: old}
+		full := &backupWriter{backup: newBackup("alice.example.net", nil, now)}
+		assert.True(t, full.hasNewContent(file))
+		incr := &backupWriter{parent: chain[1], known: known}
+		assert.True(t, incr.hasNewContent(file))
+		file.MD5Sum = []byte("md5 v2")
+		assert.False(t, incr.hasNewContent(file))
+		file.DocID = "file2"
+		assert.True(t, incr.hasNewContent(file))
+		file.DocID = "file3"
+		assert.True(t, incr.hasNewContent(file))
+	})
+
+	t.Run("NextBackupParent", func(t *testing.T) {
+		assert.Nil(t, nextBackupParent(nil, 6))
+
+		chain := makeChain(now, 3)
+		assert.Equal(t, chain[2], nextBackupParent(chain, 6))
+		assert.Equal(t, chain[2], nextBackupParent(chain, 3))
+		// The chain has a full backup and 2 incremental backups
+		assert.Nil(t, nextBackupParent(chain, 2))
+		assert.Nil(t, nextBackupParent(chain, 0))
+	})
+
+	t.Run("ExpiredBackups", func(t *testing.T) {
+		chain1 := makeChain(now, 3)
+		chain2 := makeChain(now.Add(7*24*time.Hour), 2)
+		chain3 := makeChain(now.Add(14*24*time.Hour), 1)
+		backups := append(append(append([]*Backup{}, chain1...), chain2...), chain3...)
+
+		assert.Empty(t, expiredBackups(backups, 3))
+		assert.Equal(t, chain1, expiredBackups(backups, 2))
+		assert.Equal(t, append(chain1, chain2...), expiredBackups(backups, 1))
+		assert.Equal(t, append(chain1, chain2...), expiredBackups(backups, 0))
+	})
+
+	t.Run("BackupChain", func(t *testing.T) {
+		_, err := BackupChain(nil, "")
+		assert.ErrorIs(t, err, ErrBackupNotFound)
+
+		chain1 := makeChain(now, 3)
+		chain2 := makeChain(now.Add(7*24*time.Hour), 2)
+		backups := append(append([]*Backup{}, chain1...), chain2...)
+
+		chain, err := BackupChain(backups, "")
+		assert.NoError(t, err)
+		assert.Equal(t, chain2, chain)
+
+		chain, err = BackupChain(backups, chain1[1].ID)
+		assert.NoError(t, err)
+		assert.Equal(t, chain1[:2], chain)
+
+		_, err = BackupChain(backups, "20200101T000000Z")
+		assert.ErrorIs(t, err, ErrBackupNotFound)
+
+		// A missing backup in the chain can't be restored
+		broken := []*Backup{chain1[0], chain1[2]}
+		_, err = BackupChain(broken, "")
+		assert.ErrorIs(t, err, ErrBackupNotFound)
+	})
+
+	t.Run("ListAndRemoveBackups", func(t *testing.T) {
+		target := newAferoBackupTarget(afero.NewMemMapFs())
+		backups, err := ListBackups(target, "alice.example.net")
+		assert.NoError(t, err)
+		assert.Empty(t, backups)
+
+		chain := makeChain(now, 3)
+		for i := len(chain) - 1; i >= 0; i-- {
+			writeTestEntry(t, target, chain[i].archiveName(), []byte("zip"))
+			manifest, err := json.Marshal(chain[i])
+			require.NoError(t, err)
+			writeTestEntry(t, target, chain[i].manifestName(), manifest)
+		}
+		// An archive without manifest is an incomplete backup
+		writeTestEntry(t, target, "alice.example.net/20240320T031500Z.zip", []byte("zip"))
+
+		backups, err = ListBackups(target, "alice.example.net")
+		assert.NoError(t, err)
+		if assert.Len(t, backups, 3) {
+			for i := range chain {
+				assert.Equal(t, chain[i].ID, backups[i].ID)
+				assert.Equal(t, chain[i].Parent, backups[i].Parent)
+			}
+		}
+
+		assert.NoError(t, removeBackups(target, backups[:1]))
+		backups, err = ListBackups(target, "alice.example.net")
+		assert.NoError(t, err)
+		assert.Len(t, backups, 2)
+		names, err := target.List("alice.example.net")
+		assert.NoError(t, err)
+		assert.NotContains(t, names, chain[0].archiveName())
+	})
+
+	t.Run("MergeBackups", func(t *testing.T) {
+		full := newBackup("alice.example.net", nil, now)
+		fullZip := makeTestZip(t, []testZipEntry{
+			{consts.Contacts + "/c1.json", `{"_id":"c1","fullname":"Alice"}`},
+			{consts.Contacts + "/c2.json", `{"_id":"c2","fullname":"Bob"}`},
+			{consts.Files + "/d1.json", `{"_id":"d1","type":"directory","path":"/Photos"}`},
+			{consts.Files + "/d2.json", `{"_id":"d2","type":"directory","path":"/Photos/2024"}`},
+			{consts.Files + "/f1.json", `{"_id":"f1","type":"file","name":"a.jpg"}`},
+			{"!" + path.Join(ExportFilesDir, "f1"), "content f1 v1"},
+			{consts.Files + "/f2.json", `{"_id":"f2","type":"file","name":"b.jpg"}`},
+			{"!" + path.Join(ExportFilesDir, "f2"), "content f2"},
+		})
+
+		incr := newBackup("alice.example.net", full, now.Add(24*time.Hour))
+		incr.Deleted[consts.Contacts] = []string{"c2"}
+		incr.Deleted[consts.Files] = []string{"f2"}
+		incrZip := makeTestZip(t, []testZipEntry{
+			{consts.Contacts + "/c1.json", `{"_id":"c1","fullname":"Alice A."}`},
+			{consts.Files + "/d3.json", `{"_id":"d3","type":"directory","path":"/Docs"}`},
+			{consts.Files + "/f1.json", `{"_id":"f1","type":"file","name":"renamed.jpg"}`},
+			{consts.Files + "/f3.json", `{"_id":"f3","type":"file","name":"c.jpg"}`},
+			{"!" + path.Join(ExportFilesDir, "f3"), "content f3"},
+			{consts.FilesVersions + "/f1/1-abc.json", `{"_id":"f1/1-abc"}`},
+			{"!" + path.Join(ExportVersionsDir, "f1/1-abc"), "content f1 v0"},
+		})
+
+		merger := newBackupMerger()
+		require.NoError(t, merger.add(full, fullZip.File))
+		require.NoError(t, merger.add(incr, incrZip.File))
+
+		var names, contents []string
+		for _, f := range merger.entries() {
+			names = append(names, f.Name)
+			r, err := f.Open()
+			require.NoError(t, err)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			contents = append(contents, string(data))
+		}
+		assert.Equal(t, []string{
+			path.Join(ExportDataDir, consts.Contacts, "c1.json"),
+			path.Join(ExportDataDir, consts.Files, "d1.json"),
+			path.Join(ExportDataDir, consts.Files, "d3.json"),
+			path.Join(ExportDataDir, consts.Files, "d2.json"),
+			path.Join(ExportDataDir, consts.Files, "f1.json"),
+			path.Join(ExportFilesDir, "f1"),
+			path.Join(ExportDataDir, consts.Files, "f3.json"),
+			path.Join(ExportFilesDir, "f3"),
+			path.Join(ExportDataDir, consts.FilesVersions, "f1/1-abc.json"),
+			path.Join(ExportVersionsDir, "f1/1-abc"),
+		}, names)
+		assert.Contains(t, contents[0], "Alice A.")
+		assert.Contains(t, contents[4], "renamed.jpg")
+		assert.Equal(t, "content f1 v1", contents[5])
+		assert.Equal(t, "content f1 v0", contents[9])
+	})
+}
+
+type testZipEntry struct {
+	// name is relative to ExportDataDir, unless it starts with a "!"
+	name    string
+	content string
+}
+
+func makeTestZip(t *testing.T, entries []testZipEntry) *zip.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		name := path.Join(ExportDataDir, entry.name)
+		if entry.name[0] == '!' {
+			name = entry.name[1:]
+		}
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(entry.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	return zr
+}
+
+func writeTestEntry(t *testing.T, target BackupTarget, name string, content []byte) {
+	w, err := target.Create(name)
+	require.NoError(t, err)
+	_, err = w.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+}
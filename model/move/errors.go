@@ -23,4 +23,10 @@ var (
 	ErrExportInvalidCursor = echo.NewHTTPError(http.StatusBadRequest, "export: cursor is invalid")
 	// ErrNotEnoughSpace is used when the quota is too small to import the files
 	ErrNotEnoughSpace = echo.NewHTTPError(http.StatusRequestEntityTooLarge, "import: not enough disk space")
+	// ErrBackupsNotConfigured is used when no target has been configured for
+	// the backups.
+	ErrBackupsNotConfigured = echo.NewHTTPError(http.StatusServiceUnavailable, "backups: no target configured")
+	// ErrBackupNotFound is used when a backup, or one of the backups of its
+	// chain, could not be found.
+	ErrBackupNotFound = echo.NewHTTPError(http.StatusNotFound, "backups: not found")
 )
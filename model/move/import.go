@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"net/http"
 	"net/url"
-	"sort"
 	"strings"
 
 	"github.com/cozy/cozy-stack/model/instance"
@@ -145,13 +144,7 @@ func Import(inst *instance.Instance, options ImportOptions) ([]string, error) {
 		return nil, err
 	}
 
-	if err = GetStore().SetAllowDeleteAccounts(inst); err != nil {
-		return nil, err
-	}
-	if err = lifecycle.Reset(inst); err != nil {
-		return nil, err
-	}
-	if err = GetStore().ClearAllowDeleteAccounts(inst); err != nil {
+	if err = resetInstance(inst); err != nil {
 		return nil, err
 	}
 
@@ -173,13 +166,20 @@ func Import(inst *instance.Instance, options ImportOptions) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	return im.notInstalled(), nil
+}
 
-	var inError []string
-	for slug := range im.servicesInError {
-		inError = append(inError, slug)
+// resetInstance removes the data of the instance before an import. The
+// accounts can be deleted even if their konnector has an on_delete_account
+// hook, as the data will be replaced by the imported ones.
+func resetInstance(inst *instance.Instance) error {
+	if err := GetStore().SetAllowDeleteAccounts(inst); err != nil {
+		return err
+	}
+	if err := lifecycle.Reset(inst); err != nil {
+		return err
 	}
-	sort.Strings(inError)
-	return inError, nil
+	return GetStore().ClearAllowDeleteAccounts(inst)
 }
 
 // ImportIsFinished returns true unless an import is running
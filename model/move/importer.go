@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
@@ -52,7 +53,7 @@ func (im *importer) importPart(cursor string) error {
 	if err != nil {
 		return err
 	}
-	err = im.importZip(&zr.Reader)
+	err = im.importEntries(zr.File)
 	if errc := zr.Close(); err == nil {
 		err = errc
 	}
@@ -88,10 +89,23 @@ func (im *importer) downloadFile(cursor string) error {
 	return err
 }
 
-func (im *importer) importZip(zr *zip.Reader) error {
+// contentEntry returns the entry with the content of the file or version
+// described by the entry at the given index, or nil if there is none.
+func contentEntry(files []*zip.File, i int) *zip.File {
+	if i >= len(files)-1 {
+		return nil
+	}
+	next := files[i+1]
+	if strings.HasPrefix(next.FileHeader.Name, ExportDataDir+"/") {
+		return nil
+	}
+	return next
+}
+
+func (im *importer) importEntries(files []*zip.File) error {
 	var errm error
 
-	for i, file := range zr.File {
+	for i, file := range files {
 		if !strings.HasPrefix(file.FileHeader.Name, ExportDataDir+"/") {
 			continue
 		}
@@ -112,7 +126,8 @@ func (im *importer) importZip(zr *zip.Reader) error {
 			// We don't want to import the sessions from another instance
 			continue
 		case consts.BitwardenCiphers, consts.BitwardenFolders, consts.BitwardenProfiles,
-			consts.BitwardenOrganizations, consts.BitwardenContacts:
+			consts.BitwardenOrganizations, consts.BitwardenContacts, consts.BitwardenSends,
+			consts.BitwardenEmergencyAccess:
 			// Bitwarden documents are encypted E2E, so they cannot be imported
 			// as raw documents
 			continue
@@ -158,19 +173,16 @@ func (im *importer) importZip(zr *zip.Reader) error {
 			}
 			continue
 		case consts.Files:
-			var content *zip.File
-			if i < len(zr.File)-1 {
-				content = zr.File[i+1]
-			}
-			if err := im.importFile(file, content); err != nil {
+			if err := im.importFile(file, contentEntry(files, i)); err != nil {
 				errm = multierror.Append(errm, err)
 			}
 			continue
 		case consts.FilesVersions:
-			if i >= len(zr.File)-1 {
+			content := contentEntry(files, i)
+			if content == nil {
 				continue
 			}
-			if err := im.importFileVersion(file, zr.File[i+1]); err != nil {
+			if err := im.importFileVersion(file, content); err != nil {
 				errm = multierror.Append(errm, err)
 			}
 			continue
@@ -543,3 +555,14 @@ func (im *importer) readPermission(zf *zip.File) (*permission.Permission, error)
 	}
 	return doc, nil
 }
+
+// notInstalled returns the sorted list of slugs for the apps and konnectors
+// that have not been installed.
+func (im *importer) notInstalled() []string {
+	var inError []string
+	for slug := range im.servicesInError {
+		inError = append(inError, slug)
+	}
+	sort.Strings(inError)
+	return inError
+}
@@ -0,0 +1,240 @@
+package move
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+)
+
+// RestoreOptions contains the options for launching the restore worker.
+type RestoreOptions struct {
+	// From is the domain of the instance that has been backed up. It is the
+	// restored instance by default, but it can be another domain to restore
+	// the backups of a destroyed instance on a fresh one.
+	From string `json:"from,omitempty"`
+	// BackupID is the identifier of the backup to restore. The most recent
+	// backup is restored by default.
+	BackupID string `json:"backup_id,omitempty"`
+}
+
+// Restore replaces the data of the instance by the ones from a backup. The
+// full backup and the incremental backups of its chain are merged, and the
+// result is given to the importer. It returns the list of slugs for
+// apps/konnectors that have not been installed.
+func Restore(inst *instance.Instance, opts RestoreOptions, target BackupTarget) ([]string, error) {
+	domain := opts.From
+	if domain == "" {
+		domain = inst.Domain
+	}
+	backups, err := ListBackups(target, domain)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := BackupChain(backups, opts.BackupID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tmpFiles []string
+	var readers []*zip.ReadCloser
+	defer func() {
+		for _, zr := range readers {
+			_ = zr.Close()
+		}
+		for _, tmpFile := range tmpFiles {
+			if err := os.Remove(tmpFile); err != nil {
+				inst.Logger().WithNamespace("backups").
+					Warnf("Cannot remove temp file %s: %s", tmpFile, err)
+			}
+		}
+	}()
+
+	merger := newBackupMerger()
+	for _, b := range chain {
+		tmpFile, err := downloadBackup(target, b)
+		if tmpFile != "" {
+			tmpFiles = append(tmpFiles, tmpFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		zr, err := zip.OpenReader(tmpFile)
+		if err != nil {
+			return nil, err
+		}
+		readers = append(readers, zr)
+		if err := merger.add(b, zr.File); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := resetInstance(inst); err != nil {
+		return nil, err
+	}
+	im := &importer{
+		inst:            inst,
+		fs:              inst.VFS(),
+		servicesInError: make(map[string]bool),
+	}
+	if err := im.importEntries(merger.entries()); err != nil {
+		return nil, err
+	}
+	return im.notInstalled(), nil
+}
+
+// downloadBackup copies the archive of a backup to a temporary file, as
+// reading a zip needs to seek.
+func downloadBackup(target BackupTarget, b *Backup) (string, error) {
+	r, err := target.Open(b.archiveName())
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	f, err := os.CreateTemp("", "backup-*")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, r)
+	if errc := f.Close(); err == nil {
+		err = errc
+	}
+	return f.Name(), err
+}
+
+type restoreEntry struct {
+	doctype string
+	name    string
+	doc     *zip.File
+	content *zip.File
+	dir     bool
+	depth   int
+}
+
+// less is used to sort the entries in an order that the importer can use:
+// the normal documents first, then the directories from the root to the
+// leaves, then the files, and finally the old versions of the files.
+func (e *restoreEntry) less(other *restoreEntry) bool {
+	if r1, r2 := e.rank(), other.rank(); r1 != r2 {
+		return r1 < r2
+	}
+	if e.doctype != other.doctype {
+		return e.doctype < other.doctype
+	}
+	if e.depth != other.depth {
+		return e.depth < other.depth
+	}
+	return e.name < other.name
+}
+
+func (e *restoreEntry) rank() int {
+	switch e.doctype {
+	case consts.Files:
+		if e.dir {
+			return 1
+		}
+		return 2
+	case consts.FilesVersions:
+		return 3
+	default:
+		return 0
+	}
+}
+
+// backupMerger computes the final state of a chain of backups: the most
+// recent document wins, the deleted documents are removed, and a file keeps
+// the content of a previous backup if it has not been uploaded again.
+type backupMerger struct {
+	byName map[string]*restoreEntry
+}
+
+func newBackupMerger() *backupMerger {
+	return &backupMerger{byName: make(map[string]*restoreEntry)}
+}
+
+func (m *backupMerger) add(b *Backup, files []*zip.File) error {
+	for doctype, ids := range b.Deleted {
+		for _, id := range ids {
+			delete(m.byName, path.Join(url.PathEscape(doctype), id+".json"))
+		}
+	}
+
+	for i, file := range files {
+		if !strings.HasPrefix(file.FileHeader.Name, ExportDataDir+"/") {
+			continue
+		}
+		name := strings.TrimPrefix(file.FileHeader.Name, ExportDataDir+"/")
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		entry := &restoreEntry{
+			doctype: parts[0],
+			name:    name,
+			doc:     file,
+			content: contentEntry(files, i),
+		}
+		if entry.doctype == consts.Files {
+			if entry.content == nil {
+				if previous, ok := m.byName[name]; ok {
+					entry.content = previous.content
+				}
+			}
+			if err := entry.readDirInfo(); err != nil {
+				return err
+			}
+		}
+		m.byName[name] = entry
+	}
+	return nil
+}
+
+func (e *restoreEntry) readDirInfo() error {
+	r, err := e.doc.Open()
+	if err != nil {
+		return err
+	}
+	var doc struct {
+		Type string `json:"type"`
+		Path string `json:"path"`
+	}
+	err = json.NewDecoder(r).Decode(&doc)
+	if errc := r.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		return err
+	}
+	if doc.Type == consts.DirType {
+		e.dir = true
+		e.depth = strings.Count(doc.Path, "/")
+	}
+	return nil
+}
+
+// entries returns the zip entries to import, with the content of the files
+// and versions just after their documents.
+func (m *backupMerger) entries() []*zip.File {
+	list := make([]*restoreEntry, 0, len(m.byName))
+	for _, entry := range m.byName {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].less(list[j])
+	})
+	files := make([]*zip.File, 0, 2*len(list))
+	for _, entry := range list {
+		files = append(files, entry.doc)
+		if entry.content != nil {
+			files = append(files, entry.content)
+		}
+	}
+	return files
+}
@@ -0,0 +1,185 @@
+package note
+
+import (
+	"encoding/json"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/diff"
+	"github.com/cozy/prosemirror-go/model"
+)
+
+// The operations for the changes of the blocks of a note.
+const (
+	BlockAdded    = "added"
+	BlockRemoved  = "removed"
+	BlockModified = "modified"
+)
+
+// BlockChange is a change on a top-level block (paragraph, heading, list,
+// table, etc.) of a note.
+type BlockChange struct {
+	Op       string `json:"op"`
+	Type     string `json:"type"`
+	OldIndex *int   `json:"old_index,omitempty"`
+	NewIndex *int   `json:"new_index,omitempty"`
+	OldText  string `json:"old_text,omitempty"`
+	NewText  string `json:"new_text,omitempty"`
+}
+
+// Diff is the structural diff between two versions of a note. The blocks are
+// compared with their ProseMirror JSON, and a block that is replaced by a
+// block of the same type is seen as modified. Unified is a unified diff of
+// the text of the two versions, with Added and Removed lines.
+type Diff struct {
+	OldTitle string         `json:"old_title"`
+	NewTitle string         `json:"new_title"`
+	Changes  []*BlockChange `json:"changes"`
+	Unified  string         `json:"unified"`
+	Added    int            `json:"added"`
+	Removed  int            `json:"removed"`
+}
+
+// DiffVersions returns the diff between two versions of a note. A nil version
+// is the current content of the note.
+func DiffVersions(inst *instance.Instance, file *vfs.FileDoc, from, to *vfs.Version) (*Diff, error) {
+	oldDoc, err := documentAt(inst, file, from)
+	if err != nil {
+		return nil, err
+	}
+	newDoc, err := documentAt(inst, file, to)
+	if err != nil {
+		return nil, err
+	}
+	return diffDocuments(oldDoc, newDoc)
+}
+
+// documentAt returns the note for the given version of the file, or the last
+// version of the note if version is nil.
+func documentAt(inst *instance.Instance, file *vfs.FileDoc, version *vfs.Version) (*Document, error) {
+	if version == nil {
+		lock := inst.NotesLock()
+		if err := lock.Lock(); err != nil {
+			return nil, err
+		}
+		defer lock.Unlock()
+		return get(inst, file)
+	}
+	old := file.Clone().(*vfs.FileDoc)
+	old.Metadata = version.Metadata
+	return fromMetadata(old)
+}
+
+func diffDocuments(oldDoc, newDoc *Document) (*Diff, error) {
+	oldContent, err := oldDoc.Content()
+	if err != nil {
+		return nil, err
+	}
+	newContent, err := newDoc.Content()
+	if err != nil {
+		return nil, err
+	}
+	oldBlocks, oldKeys, err := topLevelBlocks(oldContent)
+	if err != nil {
+		return nil, err
+	}
+	newBlocks, newKeys, err := topLevelBlocks(newContent)
+	if err != nil {
+		return nil, err
+	}
+	edits := diff.Compute(len(oldKeys), len(newKeys), func(i, j int) bool {
+		return oldKeys[i] == newKeys[j]
+	})
+
+	changes := []*BlockChange{}
+	var removed, added []diff.Edit
+	flush := func() {
+		for k := 0; k < len(removed) || k < len(added); k++ {
+			switch {
+			case k < len(removed) && k < len(added) &&
+				oldBlocks[removed[k].OldIndex].Type.Name == newBlocks[added[k].NewIndex].Type.Name:
+				o, n := removed[k].OldIndex, added[k].NewIndex
+				changes = append(changes, &BlockChange{
+					Op:       BlockModified,
+					Type:     newBlocks[n].Type.Name,
+					OldIndex: &o,
+					NewIndex: &n,
+					OldText:  oldBlocks[o].TextContent(),
+					NewText:  newBlocks[n].TextContent(),
+				})
+			default:
+				if k < len(removed) {
+					o := removed[k].OldIndex
+					changes = append(changes, &BlockChange{
+						Op:       BlockRemoved,
+						Type:     oldBlocks[o].Type.Name,
+						OldIndex: &o,
+						OldText:  oldBlocks[o].TextContent(),
+					})
+				}
+				if k < len(added) {
+					n := added[k].NewIndex
+					changes = append(changes, &BlockChange{
+						Op:       BlockAdded,
+						Type:     newBlocks[n].Type.Name,
+						NewIndex: &n,
+						NewText:  newBlocks[n].TextContent(),
+					})
+				}
+			}
+		}
+		removed, added = nil, nil
+	}
+	for _, e := range edits {
+		switch e.Op {
+		case diff.Delete:
+			removed = append(removed, e)
+		case diff.Insert:
+			added = append(added, e)
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	oldText, err := oldDoc.Text()
+	if err != nil {
+		return nil, err
+	}
+	newText, err := newDoc.Text()
+	if err != nil {
+		return nil, err
+	}
+	unified, removedLines, addedLines := diff.UnifiedWithStats("a/"+oldDoc.Title, "b/"+newDoc.Title,
+		oldText, newText, diff.DefaultContext)
+	return &Diff{
+		OldTitle: oldDoc.Title,
+		NewTitle: newDoc.Title,
+		Changes:  changes,
+		Unified:  unified,
+		Added:    addedLines,
+		Removed:  removedLines,
+	}, nil
+}
+
+// topLevelBlocks returns the children of the document, and their JSON
+// serialization used for comparing them, even if the schemas of the two
+// versions are not the same.
+func topLevelBlocks(content *model.Node) ([]*model.Node, []string, error) {
+	count := content.ChildCount()
+	blocks := make([]*model.Node, count)
+	keys := make([]string, count)
+	for i := 0; i < count; i++ {
+		child, err := content.Child(i)
+		if err != nil {
+			return nil, nil, err
+		}
+		buf, err := json.Marshal(child.ToJSON())
+		if err != nil {
+			return nil, nil, err
+		}
+		blocks[i] = child
+		keys[i] = string(buf)
+	}
+	return blocks, keys, nil
+}
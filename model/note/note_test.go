@@ -79,3 +79,41 @@ and another decision
 	md := textSerializer().Serialize(node)
 	assert.Equal(t, expected, md)
 }
+
+func TestDiffDocuments(t *testing.T) {
+	schemaSpecs := DefaultSchemaSpecs()
+	specs := model.SchemaSpecFromJSON(schemaSpecs)
+	schema, err := model.NewSchema(&specs)
+	require.NoError(t, err)
+
+	makeDoc := func(title, md string) *Document {
+		node, err := parseFile(strings.NewReader(md), schema)
+		require.NoError(t, err)
+		doc := &Document{Title: title, SchemaSpec: schemaSpecs}
+		doc.SetContent(node)
+		return doc
+	}
+	oldDoc := makeDoc("Draft", "# Title\n\nfirst paragraph\n\nsecond paragraph\n\nthird paragraph")
+	newDoc := makeDoc("Final", "# Title\n\nfirst paragraph\n\nsecond paragraph, updated\n\n- a list")
+
+	d, err := diffDocuments(oldDoc, newDoc)
+	require.NoError(t, err)
+	assert.Equal(t, "Draft", d.OldTitle)
+	assert.Equal(t, "Final", d.NewTitle)
+	require.Len(t, d.Changes, 3)
+
+	assert.Equal(t, BlockModified, d.Changes[0].Op)
+	assert.Equal(t, "paragraph", d.Changes[0].Type)
+	assert.Equal(t, 2, *d.Changes[0].OldIndex)
+	assert.Equal(t, 2, *d.Changes[0].NewIndex)
+	assert.Equal(t, "second paragraph", d.Changes[0].OldText)
+	assert.Equal(t, "second paragraph, updated", d.Changes[0].NewText)
+
+	assert.Equal(t, BlockRemoved, d.Changes[1].Op)
+	assert.Equal(t, "third paragraph", d.Changes[1].OldText)
+	assert.Equal(t, BlockAdded, d.Changes[2].Op)
+	assert.Equal(t, "bulletList", d.Changes[2].Type)
+
+	assert.Contains(t, d.Unified, "-second paragraph\n")
+	assert.Contains(t, d.Unified, "+second paragraph, updated\n")
+}
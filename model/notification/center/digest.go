@@ -0,0 +1,151 @@
+package center
+
+import (
+	"html"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/mail"
+)
+
+// DigestWorker is the type of the worker that sends the daily digest of the
+// low-priority notifications.
+const DigestWorker = "notifications-digest"
+
+// maxDigestEntries is the maximal number of notifications listed in the mail
+// of a digest. The other notifications are only counted.
+const maxDigestEntries = 50
+
+// ensureDigestTrigger creates the @daily trigger for the digest, if it
+// doesn't exist yet.
+func ensureDigestTrigger(inst *instance.Instance) {
+	sched := job.System()
+	infos := job.TriggerInfos{
+		Type:       "@daily",
+		WorkerType: DigestWorker,
+		Arguments:  "between 6am and 8am",
+	}
+	if sched.HasTrigger(inst, infos) {
+		return
+	}
+	trigger, err := job.NewTrigger(inst, infos, nil)
+	if err != nil {
+		inst.Logger().WithNamespace("notifications").
+			Errorf("Cannot create the digest trigger: %s", err)
+		return
+	}
+	if err := sched.AddTrigger(trigger); err != nil {
+		inst.Logger().WithNamespace("notifications").
+			Errorf("Cannot create the digest trigger: %s", err)
+	}
+}
+
+// SendDigest sends a mail with the low-priority notifications that are still
+// unread and that have not been included in a previous digest. The muted
+// notifications are skipped.
+func SendDigest(inst *instance.Instance) error {
+	notifs, err := findDigestNotifications(inst)
+	if err != nil || len(notifs) == 0 {
+		return err
+	}
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	var entries []*notification.Notification
+	docs := make([]interface{}, len(notifs))
+	olddocs := make([]interface{}, len(notifs))
+	for i, n := range notifs {
+		olddocs[i] = n.Clone()
+		n.DigestedAt = &now
+		docs[i] = n
+		if !prefs.IsMuted(n) {
+			entries = append(entries, n)
+		}
+	}
+
+	if len(entries) > 0 {
+		msg, err := job.NewMessage(buildDigestMail(inst, entries))
+		if err != nil {
+			return err
+		}
+		if err := pushJobOrTrigger(inst, msg, "sendmail", ""); err != nil {
+			return err
+		}
+	}
+	return couchdb.BulkUpdateDocs(inst, consts.Notifications, docs, olddocs)
+}
+
+func findDigestNotifications(inst *instance.Instance) ([]*notification.Notification, error) {
+	var all []*notification.Notification
+	bookmark := ""
+	for {
+		var notifs []*notification.Notification
+		req := &couchdb.FindRequest{
+			UseIndex: "unread-by-created-at",
+			Selector: mango.And(
+				mango.Exists("created_at"),
+				mango.NotExists("read_at"),
+				mango.NotExists("digested_at"),
+				mango.Equal("priority", notification.PriorityLow),
+			),
+			Sort:     mango.SortBy{{Field: "created_at", Direction: mango.Asc}},
+			Limit:    notification.MaxListLimit,
+			Bookmark: bookmark,
+		}
+		res, err := couchdb.FindDocsRaw(inst, consts.Notifications, req, &notifs)
+		if err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		all = append(all, notifs...)
+		if len(notifs) < notification.MaxListLimit {
+			return all, nil
+		}
+		bookmark = res.Bookmark
+	}
+}
+
+func buildDigestMail(inst *instance.Instance, notifs []*notification.Notification) *mail.Options {
+	var text, htmlPart strings.Builder
+	intro := inst.Translate("Notifications Digest Intro", len(notifs))
+	text.WriteString(intro + "\n\n")
+	htmlPart.WriteString("<p>" + html.EscapeString(intro) + "</p><ul>")
+	for i, n := range notifs {
+		if i >= maxDigestEntries {
+			more := inst.Translate("Notifications Digest More", len(notifs)-maxDigestEntries)
+			text.WriteString("\n" + more + "\n")
+			htmlPart.WriteString("</ul><p>" + html.EscapeString(more) + "</p>")
+			break
+		}
+		text.WriteString("- " + n.Title)
+		htmlPart.WriteString("<li><strong>" + html.EscapeString(n.Title) + "</strong>")
+		if n.Message != "" {
+			text.WriteString(": " + n.Message)
+			htmlPart.WriteString("<br>" + html.EscapeString(n.Message))
+		}
+		text.WriteString("\n")
+		htmlPart.WriteString("</li>")
+		if i == len(notifs)-1 {
+			htmlPart.WriteString("</ul>")
+		}
+	}
+	return &mail.Options{
+		Mode:    mail.ModeFromStack,
+		Subject: inst.Translate("Notifications Digest Subject"),
+		Parts: []*mail.Part{
+			{Body: text.String(), Type: "text/plain"},
+			{Body: htmlPart.String(), Type: "text/html"},
+		},
+	}
+}
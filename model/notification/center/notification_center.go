@@ -14,6 +14,7 @@ import (
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
@@ -34,6 +35,15 @@ const (
 	// NotificationAntivirusAlert category for sending alert when antivirus
 	// scanning detects an issue with a file (infected, too large, or error).
 	NotificationAntivirusAlert = "antivirus-alert"
+	// NotificationCalendarReminder category for sending the reminders of the
+	// alarms of the calendar events and todos.
+	NotificationCalendarReminder = "calendar-reminder"
+	// NotificationSharingExpiration category for warning that the access to a
+	// sharing will expire soon.
+	NotificationSharingExpiration = "sharing-expiration"
+	// NotificationBitwardenEmergencyAccess category for warning about the
+	// recovery requests of the emergency accesses of the password manager.
+	NotificationBitwardenEmergencyAccess = "bitwarden-emergency-access"
 )
 
 var (
@@ -63,6 +73,21 @@ var (
 			Stateful:     false,
 			MailTemplate: "notifications_antivirus",
 		},
+		NotificationCalendarReminder: {
+			Description: "Remind the upcoming calendar events and todos",
+			Collapsible: false,
+			Stateful:    false,
+		},
+		NotificationSharingExpiration: {
+			Description: "Warn that the access to a sharing will expire soon",
+			Collapsible: false,
+			Stateful:    false,
+		},
+		NotificationBitwardenEmergencyAccess: {
+			Description: "Warn about the recovery requests of the emergency accesses",
+			Collapsible: false,
+			Stateful:    false,
+		},
 	}
 )
 
@@ -267,6 +292,9 @@ func makePush(inst *instance.Instance, p *notification.Properties, n *notificati
 		}
 	}
 
+	if n.Priority == "" && p != nil {
+		n.Priority = p.DefaultPriority
+	}
 	preferredChannels := ensureMailFallback(n.PreferredChannels)
 	at := n.At
 
@@ -285,8 +313,20 @@ func makePush(inst *instance.Instance, p *notification.Properties, n *notificati
 		return nil
 	}
 
-	var errm error
 	log := inst.Logger().WithNamespace("notifications")
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		log.Warnf("Cannot get the preferences for the notifications: %s", err)
+	} else if prefs.IsMuted(n) {
+		log.Debugf("Notification %s was not sent (muted)", n.ID())
+		return nil
+	}
+	if n.Priority == notification.PriorityLow {
+		ensureDigestTrigger(inst)
+		return nil
+	}
+
+	var errm error
 	for _, channel := range preferredChannels {
 		switch channel {
 		case "mobile":
@@ -347,7 +387,7 @@ func sendPush(inst *instance.Instance,
 	n *notification.Notification,
 	at string,
 ) error {
-	if !hasNotifiableDevice(inst) {
+	if !hasNotifiableDevice(inst) && !hasWebPushSubscription(inst) {
 		return errors.New("No device with push notification")
 	}
 	email := buildMailMessage(p, n)
@@ -459,3 +499,11 @@ func hasNotifiableDevice(inst *instance.Instance) bool {
 	cs, err := oauth.GetNotifiables(inst)
 	return err == nil && len(cs) > 0
 }
+
+func hasWebPushSubscription(inst *instance.Instance) bool {
+	if config.GetConfig().Notifications.VAPID == nil {
+		return false
+	}
+	subs, err := oauth.GetWebPushSubscriptions(inst)
+	return err == nil && len(subs) > 0
+}
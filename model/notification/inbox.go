@@ -0,0 +1,128 @@
+package notification
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// DefaultListLimit is the default number of notifications in a page of the
+// inbox, and MaxListLimit is the maximal number.
+const (
+	DefaultListLimit = 50
+	MaxListLimit     = 200
+)
+
+// ListOptions are the options for listing the notifications of the inbox.
+type ListOptions struct {
+	UnreadOnly bool
+	Limit      int
+	Bookmark   string
+}
+
+// List returns a page of the notifications, the most recent first, and the
+// bookmark for the next page (empty for the last page).
+func List(db prefixer.Prefixer, opts ListOptions) ([]*Notification, string, error) {
+	limit := opts.Limit
+	if limit <= 0 {
+		limit = DefaultListLimit
+	}
+	if limit > MaxListLimit {
+		limit = MaxListLimit
+	}
+	req := &couchdb.FindRequest{
+		UseIndex: "by-created-at",
+		Selector: mango.Exists("created_at"),
+		Sort:     mango.SortBy{{Field: "created_at", Direction: mango.Desc}},
+		Limit:    limit,
+		Bookmark: opts.Bookmark,
+	}
+	if opts.UnreadOnly {
+		req.UseIndex = "unread-by-created-at"
+		req.Selector = mango.And(mango.Exists("created_at"), mango.NotExists("read_at"))
+	}
+	var notifs []*Notification
+	res, err := couchdb.FindDocsRaw(db, consts.Notifications, req, &notifs)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	bookmark := ""
+	if len(notifs) == limit {
+		bookmark = res.Bookmark
+	}
+	return notifs, bookmark, nil
+}
+
+// CountUnread returns the number of notifications that have not been read.
+func CountUnread(db prefixer.Prefixer) (int, error) {
+	var res couchdb.ViewResponse
+	err := couchdb.ExecView(db, couchdb.UnreadNotificationsView, &couchdb.ViewRequest{
+		Reduce: true,
+	}, &res)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	if len(res.Rows) == 0 {
+		return 0, nil
+	}
+	count, _ := res.Rows[0].Value.(float64)
+	return int(count), nil
+}
+
+// Find returns the notification with the given identifier.
+func Find(db prefixer.Prefixer, id string) (*Notification, error) {
+	n := &Notification{}
+	if err := couchdb.GetDoc(db, consts.Notifications, id, n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// MarkAsRead marks the notification as read.
+func MarkAsRead(db prefixer.Prefixer, n *Notification) error {
+	if !n.Unread() {
+		return nil
+	}
+	now := time.Now().UTC()
+	n.ReadAt = &now
+	return couchdb.UpdateDoc(db, n)
+}
+
+// MarkAllAsRead marks all the unread notifications as read, and returns how
+// many notifications have been marked.
+func MarkAllAsRead(db prefixer.Prefixer) (int, error) {
+	now := time.Now().UTC()
+	count := 0
+	for {
+		notifs, _, err := List(db, ListOptions{UnreadOnly: true, Limit: MaxListLimit})
+		if err != nil {
+			return count, err
+		}
+		if len(notifs) == 0 {
+			return count, nil
+		}
+		docs := make([]interface{}, len(notifs))
+		olddocs := make([]interface{}, len(notifs))
+		for i, n := range notifs {
+			olddocs[i] = n.Clone()
+			n.ReadAt = &now
+			docs[i] = n
+		}
+		if err := couchdb.BulkUpdateDocs(db, consts.Notifications, docs, olddocs); err != nil {
+			return count, err
+		}
+		count += len(notifs)
+		if len(notifs) < MaxListLimit {
+			return count, nil
+		}
+	}
+}
@@ -9,6 +9,10 @@ import (
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 )
 
+// PriorityLow is the priority of the notifications that are not sent
+// immediately, but in a daily digest by mail.
+const PriorityLow = "low"
+
 // Properties is a notification type parameters, describing how a specific
 // notification group should behave.
 type Properties struct {
@@ -61,6 +65,10 @@ type Notification struct {
 	// XXX retro-compatible fields for sending rich mail
 	Content     string `json:"content,omitempty"`
 	ContentHTML string `json:"content_html,omitempty"`
+
+	// Fields for the inbox of the notifications
+	ReadAt     *time.Time `json:"read_at,omitempty"`
+	DigestedAt *time.Time `json:"digested_at,omitempty"`
 }
 
 // ID is used to implement the couchdb.Doc interface
@@ -81,6 +89,14 @@ func (n *Notification) Clone() couchdb.Doc {
 	}
 	cloned.PreferredChannels = make([]string, len(n.PreferredChannels))
 	copy(cloned.PreferredChannels, n.PreferredChannels)
+	if n.ReadAt != nil {
+		readAt := *n.ReadAt
+		cloned.ReadAt = &readAt
+	}
+	if n.DigestedAt != nil {
+		digestedAt := *n.DigestedAt
+		cloned.DigestedAt = &digestedAt
+	}
 	return &cloned
 }
 
@@ -93,6 +109,24 @@ func (n *Notification) SetRev(rev string) { n.NRev = rev }
 // Fetch implements permissions.Fetcher
 func (n *Notification) Fetch(field string) []string { return nil }
 
+// Unread returns true if the notification has not been read by the user.
+func (n *Notification) Unread() bool { return n.ReadAt == nil }
+
+// AppKey returns the slug of the application that has sent the notification,
+// or the originator (stack, cli) for the notifications without an app.
+func (n *Notification) AppKey() string {
+	if n.Slug != "" {
+		return n.Slug
+	}
+	return n.Originator
+}
+
+// CategoryKey returns the key used to mute the category of the notification,
+// like "banks/account-balance".
+func (n *Notification) CategoryKey() string {
+	return n.AppKey() + "/" + n.Category
+}
+
 // Source returns the complete normalized source value. This should be recorded
 // in the `source_id` field.
 func (n *Notification) Source() string {
@@ -0,0 +1,97 @@
+package notification
+
+import (
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// Preferences is the document with the choices of the user for the
+// notifications. The muted notifications are kept in the inbox, but they are
+// not sent by push, mail or sms, and they are not included in the digests.
+type Preferences struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+
+	// MutedApps is a list of slugs of applications, or "stack" and "cli".
+	MutedApps []string `json:"muted_apps"`
+	// MutedCategories is a list of categories, prefixed by the slug of their
+	// application, like "banks/account-balance".
+	MutedCategories []string `json:"muted_categories"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (p *Preferences) ID() string { return p.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (p *Preferences) Rev() string { return p.DocRev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (p *Preferences) DocType() string { return consts.Settings }
+
+// Clone implements couchdb.Doc
+func (p *Preferences) Clone() couchdb.Doc {
+	cloned := *p
+	cloned.MutedApps = make([]string, len(p.MutedApps))
+	copy(cloned.MutedApps, p.MutedApps)
+	cloned.MutedCategories = make([]string, len(p.MutedCategories))
+	copy(cloned.MutedCategories, p.MutedCategories)
+	return &cloned
+}
+
+// SetID is used to implement the couchdb.Doc interface
+func (p *Preferences) SetID(id string) { p.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (p *Preferences) SetRev(rev string) { p.DocRev = rev }
+
+// Fetch implements permissions.Fetcher
+func (p *Preferences) Fetch(field string) []string { return nil }
+
+// GetPreferences returns the preferences for the notifications. When the
+// user has not saved preferences yet, nothing is muted.
+func GetPreferences(db prefixer.Prefixer) (*Preferences, error) {
+	p := &Preferences{}
+	err := couchdb.GetDoc(db, consts.Settings, consts.NotificationsSettingsID, p)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		p = &Preferences{DocID: consts.NotificationsSettingsID}
+		err = nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.MutedApps == nil {
+		p.MutedApps = []string{}
+	}
+	if p.MutedCategories == nil {
+		p.MutedCategories = []string{}
+	}
+	return p, nil
+}
+
+// Save persists the preferences in CouchDB.
+func (p *Preferences) Save(db prefixer.Prefixer) error {
+	p.DocID = consts.NotificationsSettingsID
+	if p.DocRev == "" {
+		return couchdb.CreateNamedDocWithDB(db, p)
+	}
+	return couchdb.UpdateDoc(db, p)
+}
+
+// IsMuted returns true if the user has muted the application or the category
+// of the notification.
+func (p *Preferences) IsMuted(n *Notification) bool {
+	app := n.AppKey()
+	for _, slug := range p.MutedApps {
+		if slug == app {
+			return true
+		}
+	}
+	category := n.CategoryKey()
+	for _, key := range p.MutedCategories {
+		if key == category {
+			return true
+		}
+	}
+	return false
+}
@@ -658,6 +658,11 @@ func (c *Client) Delete(i *instance.Instance) *ClientRegistrationError {
 			Error: "internal_server_error",
 		}
 	}
+	if i != nil && clientID != "" {
+		if err := deleteWebPushSubscriptionsOfClient(i, clientID); err != nil {
+			i.Logger().Warnf("Cannot delete the Web Push subscriptions of OAuth client %s: %s", clientID, err)
+		}
+	}
 	if c.OIDCSessionID != "" && i != nil && i.ContextName != "" && clientID != "" {
 		if err := oidcbinding.UnbindOAuthClient(i.ContextName, i.Domain, c.OIDCSessionID, clientID); err != nil {
 			i.Logger().Warnf("Cannot unbind OIDC session %s from OAuth client %s: %s", c.OIDCSessionID, clientID, err)
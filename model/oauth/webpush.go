@@ -0,0 +1,145 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/webpush"
+)
+
+// maxWebPushSubscriptions is the maximal number of subscriptions used for
+// sending a notification.
+const maxWebPushSubscriptions = 100
+
+// WebPushSubscription is the subscription of a browser to the Web Push
+// notifications. It is registered by a web app or by an OAuth client, and it
+// is deleted with the OAuth client.
+type WebPushSubscription struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+
+	webpush.Subscription
+
+	// Slug is the slug of the web app that has registered the subscription
+	Slug string `json:"slug,omitempty"`
+	// ClientID is the identifier of the OAuth client that has registered the
+	// subscription
+	ClientID  string    `json:"client_id,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ID is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) ID() string { return s.DocID }
+
+// Rev is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) Rev() string { return s.DocRev }
+
+// DocType is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) DocType() string { return consts.WebPushSubscriptions }
+
+// Clone implements couchdb.Doc
+func (s *WebPushSubscription) Clone() couchdb.Doc {
+	cloned := *s
+	return &cloned
+}
+
+// SetID is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) SetID(id string) { s.DocID = id }
+
+// SetRev is used to implement the couchdb.Doc interface
+func (s *WebPushSubscription) SetRev(rev string) { s.DocRev = rev }
+
+// WebPushSubscriptionID returns the identifier of the subscription for the
+// given endpoint. It is derived from the endpoint, so that a browser that
+// subscribes again updates its subscription instead of creating a new one.
+func WebPushSubscriptionID(endpoint string) string {
+	sum := sha256.Sum256([]byte(endpoint))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Save validates and persists the subscription.
+func (s *WebPushSubscription) Save(inst *instance.Instance) error {
+	if err := s.Subscription.Validate(); err != nil {
+		return err
+	}
+	s.DocID = WebPushSubscriptionID(s.Endpoint)
+	if s.CreatedAt.IsZero() {
+		s.CreatedAt = time.Now().UTC()
+	}
+	old := &WebPushSubscription{}
+	err := couchdb.GetDoc(inst, consts.WebPushSubscriptions, s.DocID, old)
+	if err == nil {
+		s.DocRev = old.DocRev
+		return couchdb.UpdateDoc(inst, s)
+	}
+	if !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+	s.DocRev = ""
+	return couchdb.CreateNamedDocWithDB(inst, s)
+}
+
+// Delete removes the subscription.
+func (s *WebPushSubscription) Delete(inst *instance.Instance) error {
+	return couchdb.DeleteDoc(inst, s)
+}
+
+// FindWebPushSubscription returns the subscription with the given identifier.
+func FindWebPushSubscription(inst *instance.Instance, id string) (*WebPushSubscription, error) {
+	s := &WebPushSubscription{}
+	if err := couchdb.GetDoc(inst, consts.WebPushSubscriptions, id, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetWebPushSubscriptions returns the Web Push subscriptions of the instance,
+// the most recent first.
+func GetWebPushSubscriptions(inst *instance.Instance) ([]*WebPushSubscription, error) {
+	var subs []*WebPushSubscription
+	req := &couchdb.AllDocsRequest{Limit: maxWebPushSubscriptions}
+	err := couchdb.GetAllDocs(inst, consts.WebPushSubscriptions, req, &subs)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sortWebPushSubscriptions(subs)
+	return subs, nil
+}
+
+func deleteWebPushSubscriptionsOfClient(inst *instance.Instance, clientID string) error {
+	var subs []*WebPushSubscription
+	req := &couchdb.FindRequest{
+		UseIndex: "by-client-id",
+		Selector: mango.Equal("client_id", clientID),
+		Limit:    maxWebPushSubscriptions,
+	}
+	err := couchdb.FindDocs(inst, consts.WebPushSubscriptions, req, &subs)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		return err
+	}
+	for _, s := range subs {
+		if err := s.Delete(inst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortWebPushSubscriptions(subs []*WebPushSubscription) {
+	sort.SliceStable(subs, func(i, j int) bool {
+		return subs[j].CreatedAt.Before(subs[i].CreatedAt)
+	})
+}
@@ -21,28 +21,35 @@ var blockList = map[string]bool{
 	consts.RemoteSecrets:         none,
 
 	// Only stack can manipulate them
-	consts.Sessions:            none,
-	consts.Permissions:         none,
-	consts.Intents:             none,
-	consts.OAuthClients:        none,
-	consts.OAuthAccessCodes:    none,
-	consts.Archives:            none,
-	consts.Sharings:            none,
-	consts.Shared:              none,
-	consts.SoftDeletedAccounts: none,
+	consts.Sessions:                none,
+	consts.Permissions:             none,
+	consts.Intents:                 none,
+	consts.OAuthClients:            none,
+	consts.OAuthAccessCodes:        none,
+	consts.WebPushSubscriptions:    none,
+	consts.WebAuthnCredentials:     none,
+	consts.Archives:                none,
+	consts.FilesUploads:            none,
+	consts.FilesBlobs:              none,
+	consts.FilesVersioningPolicies: none,
+	consts.Sharings:                none,
+	consts.Shared:                  none,
+	consts.SoftDeletedAccounts:     none,
 
 	// Synthetic doctypes (API only)
-	consts.CertifiedCarbonCopy:     none,
-	consts.CertifiedElectronicSafe: none,
-	consts.DirSizes:                none,
-	consts.TriggersState:           none,
-	consts.SharingsAnswer:          none,
-	consts.SharingsMoved:           none,
-	consts.Support:                 none,
-	consts.BitwardenProfiles:       none,
-	consts.OfficeURL:               none,
-	consts.NotesURL:                none,
-	consts.AppsOpenParameters:      none,
+	consts.CertifiedCarbonCopy:      none,
+	consts.CertifiedElectronicSafe:  none,
+	consts.DirSizes:                 none,
+	consts.TriggersState:            none,
+	consts.SharingsAnswer:           none,
+	consts.SharingsMoved:            none,
+	consts.Support:                  none,
+	consts.BitwardenProfiles:        none,
+	consts.BitwardenEmergencyAccess: none,
+	consts.OfficeURL:                none,
+	consts.NotesURL:                 none,
+	consts.SearchResults:            none,
+	consts.AppsOpenParameters:       none,
 
 	// Synthetic doctypes (realtime events only)
 	consts.AuthConfirmations:   none,
@@ -63,9 +70,12 @@ var blockList = map[string]bool{
 	consts.Notifications:     readable,
 	consts.RemoteRequests:    readable,
 	consts.SessionsLogins:    readable,
+	consts.Audit:             readable,
+	consts.ShareLinkAccesses: readable,
 	consts.NotesSteps:        readable,
 	consts.NotesImages:       readable,
 	consts.BitwardenContacts: readable,
+	consts.BitwardenSends:    readable,
 }
 
 // CheckReadable will abort the context and returns false if the doctype
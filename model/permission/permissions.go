@@ -41,6 +41,12 @@ type Permission struct {
 	ShortCodes  map[string]string `json:"shortcodes,omitempty"`
 	Password    interface{}       `json:"password,omitempty"`
 
+	// For a share by link, the number of downloads can be limited, and the
+	// link can be restricted to view the files without downloading them.
+	MaxDownloads   int  `json:"max_downloads,omitempty"`
+	DownloadsCount int  `json:"downloads_count,omitempty"`
+	ViewOnly       bool `json:"view_only,omitempty"`
+
 	Client   interface{}            `json:"-"` // Contains the *oauth.Client client pointer for Oauth permission type
 	Metadata *metadata.CozyMetadata `json:"cozyMetadata,omitempty"`
 }
@@ -164,7 +170,16 @@ func (p *Permission) PatchCodes(codes map[string]string) {
 
 // Revoke destroy a Permission
 func (p *Permission) Revoke(db prefixer.Prefixer) error {
-	return couchdb.DeleteDoc(db, p)
+	if err := couchdb.DeleteDoc(db, p); err != nil {
+		return err
+	}
+	if p.Type == TypeShareByLink {
+		if err := deleteShareLinkAccesses(db, p.PID); err != nil {
+			logger.WithDomain(db.DomainName()).WithNamespace("permissions").
+				Warnf("Cannot delete the access log of %s: %s", p.PID, err)
+		}
+	}
+	return nil
 }
 
 // CanUpdateShareByLink check if the child permissions can be updated by p
@@ -512,7 +527,7 @@ func GetForShareCode(db prefixer.Prefixer, tokenCode string) (*Permission, error
 		return nil, err
 	}
 
-	if perm.Expired() {
+	if perm.Expired() || perm.DownloadsExhausted() {
 		return nil, ErrExpiredToken
 	}
 
@@ -773,6 +788,10 @@ func CreateShareSet(
 		ExpiresAt:   expiresAt,
 		Metadata:    subdoc.Metadata,
 	}
+	if subdoc.MaxDownloads > 0 {
+		doc.MaxDownloads = subdoc.MaxDownloads
+	}
+	doc.ViewOnly = subdoc.ViewOnly
 
 	if pass, ok := subdoc.Password.(string); ok && len(pass) > 0 {
 		hash, err := crypto.GenerateFromPassphrase([]byte(pass))
@@ -0,0 +1,198 @@
+package permission
+
+import (
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// The actions that are saved in the access log of a share by link.
+const (
+	ShareLinkActionView     = "view"
+	ShareLinkActionDownload = "download"
+)
+
+// DefaultAccessesLimit is the default number of entries in a page of the
+// access log of a share by link, and MaxAccessesLimit is the maximal number.
+const (
+	DefaultAccessesLimit = 50
+	MaxAccessesLimit     = 200
+)
+
+const maxAccessesDeletedPerBatch = 200
+
+// ShareLinkAccess is an entry of the access log of a share by link: it
+// records when the link has been used, from where, and for which file.
+type ShareLinkAccess struct {
+	DocID        string    `json:"_id,omitempty"`
+	DocRev       string    `json:"_rev,omitempty"`
+	PermissionID string    `json:"permission_id"`
+	Action       string    `json:"action"`
+	FileID       string    `json:"file_id,omitempty"`
+	Path         string    `json:"path,omitempty"`
+	IP           string    `json:"ip,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ID implements couchdb.Doc
+func (a *ShareLinkAccess) ID() string { return a.DocID }
+
+// Rev implements couchdb.Doc
+func (a *ShareLinkAccess) Rev() string { return a.DocRev }
+
+// DocType implements couchdb.Doc
+func (a *ShareLinkAccess) DocType() string { return consts.ShareLinkAccesses }
+
+// Clone implements couchdb.Doc
+func (a *ShareLinkAccess) Clone() couchdb.Doc {
+	cloned := *a
+	return &cloned
+}
+
+// SetID implements couchdb.Doc
+func (a *ShareLinkAccess) SetID(id string) { a.DocID = id }
+
+// SetRev implements couchdb.Doc
+func (a *ShareLinkAccess) SetRev(rev string) { a.DocRev = rev }
+
+// DownloadsExhausted returns true if the share by link has a quota of
+// downloads, and this quota has been reached.
+func (p *Permission) DownloadsExhausted() bool {
+	return p.MaxDownloads > 0 && p.DownloadsCount >= p.MaxDownloads
+}
+
+// CountDownload increments the number of downloads made with a share by link
+// that has a quota. It returns ErrExpiredToken if the quota has already been
+// reached.
+func CountDownload(db prefixer.Prefixer, p *Permission) error {
+	if p.MaxDownloads <= 0 {
+		return nil
+	}
+	// Several downloads can be made at the same time with the same link, so
+	// we may need to retry on conflicts.
+	for retries := 3; ; retries-- {
+		if p.DownloadsExhausted() {
+			return ErrExpiredToken
+		}
+		p.DownloadsCount++
+		err := couchdb.UpdateDoc(db, p)
+		if err == nil || !couchdb.IsConflictError(err) || retries <= 0 {
+			return err
+		}
+		fresh := &Permission{}
+		if err := couchdb.GetDoc(db, consts.Permissions, p.PID, fresh); err != nil {
+			return err
+		}
+		*p = *fresh
+	}
+}
+
+// ReleaseDownload gives back a download that has been counted by
+// CountDownload, when the content has finally not been sent.
+func ReleaseDownload(db prefixer.Prefixer, p *Permission) error {
+	if p.MaxDownloads <= 0 {
+		return nil
+	}
+	for retries := 3; ; retries-- {
+		if p.DownloadsCount <= 0 {
+			return nil
+		}
+		p.DownloadsCount--
+		err := couchdb.UpdateDoc(db, p)
+		if err == nil || !couchdb.IsConflictError(err) || retries <= 0 {
+			return err
+		}
+		fresh := &Permission{}
+		if err := couchdb.GetDoc(db, consts.Permissions, p.PID, fresh); err != nil {
+			return err
+		}
+		*p = *fresh
+	}
+}
+
+// LogShareLinkAccess saves an entry in the access log of a share by link.
+func LogShareLinkAccess(db prefixer.Prefixer, access *ShareLinkAccess) {
+	access.DocID = ""
+	access.DocRev = ""
+	access.CreatedAt = time.Now().UTC()
+	if err := couchdb.CreateDoc(db, access); err != nil {
+		logger.WithDomain(db.DomainName()).WithNamespace("permissions").
+			Errorf("Cannot save the access to the share by link %s: %s", access.PermissionID, err)
+	}
+}
+
+// ListShareLinkAccesses returns a page of the access log of a share by link,
+// the most recent entries first, and the bookmark for the next page (empty
+// for the last page).
+func ListShareLinkAccesses(db prefixer.Prefixer, permID string, limit int, bookmark string) ([]*ShareLinkAccess, string, error) {
+	if limit <= 0 {
+		limit = DefaultAccessesLimit
+	}
+	if limit > MaxAccessesLimit {
+		limit = MaxAccessesLimit
+	}
+	req := &couchdb.FindRequest{
+		UseIndex: "by-permission-id",
+		Selector: mango.And(
+			mango.Equal("permission_id", permID),
+			mango.Exists("created_at"),
+		),
+		Sort: mango.SortBy{
+			{Field: "permission_id", Direction: mango.Desc},
+			{Field: "created_at", Direction: mango.Desc},
+		},
+		Limit:    limit,
+		Bookmark: bookmark,
+	}
+	var accesses []*ShareLinkAccess
+	res, err := couchdb.FindDocsRaw(db, consts.ShareLinkAccesses, req, &accesses)
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, "", nil
+		}
+		return nil, "", err
+	}
+	next := ""
+	if len(accesses) == limit {
+		next = res.Bookmark
+	}
+	return accesses, next, nil
+}
+
+// deleteShareLinkAccesses removes the access log of a share by link.
+func deleteShareLinkAccesses(db prefixer.Prefixer, permID string) error {
+	for {
+		var accesses []*ShareLinkAccess
+		req := &couchdb.FindRequest{
+			UseIndex: "by-permission-id",
+			Selector: mango.Equal("permission_id", permID),
+			Limit:    maxAccessesDeletedPerBatch,
+		}
+		if err := couchdb.FindDocs(db, consts.ShareLinkAccesses, req, &accesses); err != nil {
+			if couchdb.IsNoDatabaseError(err) {
+				return nil
+			}
+			return err
+		}
+		if len(accesses) == 0 {
+			return nil
+		}
+		docs := make([]couchdb.Doc, len(accesses))
+		for i, a := range accesses {
+			docs[i] = a
+		}
+		if err := couchdb.BulkDeleteDocs(db, consts.ShareLinkAccesses, docs); err != nil {
+			return err
+		}
+		if len(accesses) < maxAccessesDeletedPerBatch {
+			return nil
+		}
+	}
+}
+
+var _ couchdb.Doc = &ShareLinkAccess{}
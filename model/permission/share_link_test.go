@@ -0,0 +1,33 @@
+package permission
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadsExhausted(t *testing.T) {
+	p := &Permission{Type: TypeShareByLink}
+	assert.False(t, p.DownloadsExhausted())
+
+	p.DownloadsCount = 12
+	assert.False(t, p.DownloadsExhausted())
+
+	p.MaxDownloads = 13
+	assert.False(t, p.DownloadsExhausted())
+
+	p.DownloadsCount = 13
+	assert.True(t, p.DownloadsExhausted())
+}
+
+func TestCountDownloadWithoutQuota(t *testing.T) {
+	p := &Permission{Type: TypeShareByLink}
+	assert.NoError(t, CountDownload(nil, p))
+	assert.Equal(t, 0, p.DownloadsCount)
+}
+
+func TestReleaseDownloadWithoutQuota(t *testing.T) {
+	p := &Permission{Type: TypeShareByLink, DownloadsCount: 3}
+	assert.NoError(t, ReleaseDownload(nil, p))
+	assert.Equal(t, 3, p.DownloadsCount)
+}
@@ -0,0 +1,188 @@
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// cacheDuration is how long the schemas declared by the apps of an instance
+// are kept in cache. The cache is cleared when an app is installed, updated
+// or uninstalled.
+const cacheDuration = 10 * time.Minute
+
+func cacheKey(db prefixer.Prefixer) string {
+	return "schemas:" + db.DBPrefix()
+}
+
+// ClearCache must be called when the apps of an instance have changed, as
+// they may declare schemas in their manifests.
+func ClearCache(db prefixer.Prefixer) {
+	config.GetConfig().CacheStorage.Clear(cacheKey(db))
+}
+
+// declaredSchema is a schema declared by an app for a doctype.
+type declaredSchema struct {
+	Slug    string          `json:"slug"`
+	Version string          `json:"version"`
+	Schema  json.RawMessage `json:"schema"`
+}
+
+// declaringApp is the part of the manifest of an app that is used for the
+// schemas.
+type declaringApp struct {
+	Slug        string                     `json:"slug"`
+	Version     string                     `json:"version"`
+	Permissions permission.Set             `json:"permissions"`
+	Schemas     map[string]json.RawMessage `json:"schemas"`
+}
+
+// canWrite returns true if the app has the permission to create or update
+// every document of the doctype.
+func (a *declaringApp) canWrite(doctype string) bool {
+	for _, verb := range []permission.Verb{permission.POST, permission.PUT, permission.PATCH} {
+		if a.Permissions.AllowWholeType(verb, doctype) {
+			return true
+		}
+	}
+	return false
+}
+
+// declaredSchemas returns the schemas declared in the manifests of the
+// webapps and konnectors installed on the instance.
+func declaredSchemas(db prefixer.Prefixer) (map[string]declaredSchema, error) {
+	cache := config.GetConfig().CacheStorage
+	key := cacheKey(db)
+	var declared map[string]declaredSchema
+	if buf, ok := cache.Get(key); ok {
+		if err := json.Unmarshal(buf, &declared); err == nil {
+			return declared, nil
+		}
+	}
+
+	var apps []declaringApp
+	for _, doctype := range []string{consts.Apps, consts.Konnectors} {
+		var docs []declaringApp
+		req := &couchdb.AllDocsRequest{Limit: 1000}
+		err := couchdb.GetAllDocs(db, doctype, req, &docs)
+		if err != nil && !couchdb.IsNoDatabaseError(err) {
+			return nil, err
+		}
+		apps = append(apps, docs...)
+	}
+	log := logger.WithDomain(db.DomainName()).WithNamespace("schema")
+	declared = resolveDeclared(apps, log)
+
+	if buf, err := json.Marshal(declared); err == nil {
+		cache.Set(key, buf, cacheDuration)
+	}
+	return declared, nil
+}
+
+// resolveDeclared picks the schema to use for each doctype. An app can only
+// declare a schema for a doctype on which it has a write permission (POST,
+// PUT or PATCH on the whole doctype), and the other declarations are ignored.
+// When several apps declare different schemas for the same doctype, the
+// conflict is resolved by taking the schema of the first app in the
+// alphabetical order of the slugs, and the other declarations are ignored
+// with a warning.
+func resolveDeclared(apps []declaringApp, log *logger.Entry) map[string]declaredSchema {
+	sort.Slice(apps, func(i, j int) bool {
+		return apps[i].Slug < apps[j].Slug
+	})
+
+	declared := make(map[string]declaredSchema)
+	for i := range apps {
+		app := &apps[i]
+		doctypes := make([]string, 0, len(app.Schemas))
+		for doctype := range app.Schemas {
+			doctypes = append(doctypes, doctype)
+		}
+		sort.Strings(doctypes)
+		for _, doctype := range doctypes {
+			raw := app.Schemas[doctype]
+			if !app.canWrite(doctype) {
+				log.Warnf("Schema for %s declared by %s ignored: no write permission on this doctype",
+					doctype, app.Slug)
+				continue
+			}
+			if prev, ok := declared[doctype]; ok {
+				if !bytes.Equal(prev.Schema, raw) {
+					log.Warnf("Schema for %s declared by %s ignored: conflicts with the schema of %s",
+						doctype, app.Slug, prev.Slug)
+				}
+				continue
+			}
+			declared[doctype] = declaredSchema{
+				Slug:    app.Slug,
+				Version: app.Version,
+				Schema:  raw,
+			}
+		}
+	}
+	return declared
+}
+
+// compiledKey is the key of a compiled schema: the schema of a doctype
+// declared by an app doesn't change for a given version of the app.
+type compiledKey struct {
+	slug, version, doctype string
+}
+
+type compiledSchema struct {
+	raw    json.RawMessage
+	schema *Schema
+}
+
+var (
+	compiledMu    sync.Mutex
+	compiledCache = make(map[compiledKey]compiledSchema)
+)
+
+// compileDeclared returns the compiled schema for a declared schema. The
+// compiled schemas are cached by slug and version of the app, and shared
+// between the instances. The raw schema is checked too, as an app installed
+// from a local directory can change without a new version.
+func compileDeclared(doctype string, d declaredSchema) (*Schema, error) {
+	key := compiledKey{slug: d.Slug, version: d.Version, doctype: doctype}
+	compiledMu.Lock()
+	c, ok := compiledCache[key]
+	compiledMu.Unlock()
+	if ok && bytes.Equal(c.raw, d.Schema) {
+		return c.schema, nil
+	}
+
+	s, err := Compile(d.Schema)
+	if err != nil {
+		return nil, err
+	}
+	compiledMu.Lock()
+	compiledCache[key] = compiledSchema{raw: d.Schema, schema: s}
+	compiledMu.Unlock()
+	return s, nil
+}
+
+// Declared returns the list of the doctypes with a schema declared by an app
+// installed on the instance, and not by the stack.
+func Declared(db prefixer.Prefixer) ([]string, error) {
+	declared, err := declaredSchemas(db)
+	if err != nil {
+		return nil, err
+	}
+	var doctypes []string
+	for doctype := range declared {
+		if builtin(doctype) == nil {
+			doctypes = append(doctypes, doctype)
+		}
+	}
+	return doctypes, nil
+}
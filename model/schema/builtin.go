@@ -0,0 +1,117 @@
+package schema
+
+import (
+	"sync"
+
+	"github.com/cozy/cozy-stack/pkg/consts"
+)
+
+// The schemas registered by the stack are quite permissive: they check the
+// types of the well-known fields, and the fields that the other apps rely on,
+// but they accept additional fields.
+
+const contactsSchema = `{
+  "type": "object",
+  "properties": {
+    "fullname": { "type": "string" },
+    "name": {
+      "type": "object",
+      "properties": {
+        "familyName": { "type": "string" },
+        "givenName": { "type": "string" },
+        "additionalName": { "type": "string" },
+        "namePrefix": { "type": "string" },
+        "nameSuffix": { "type": "string" }
+      }
+    },
+    "email": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["address"],
+        "properties": {
+          "address": { "type": "string" },
+          "type": { "type": "string" },
+          "label": { "type": "string" },
+          "primary": { "type": "boolean" }
+        }
+      }
+    },
+    "phone": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["number"],
+        "properties": {
+          "number": { "type": "string" },
+          "type": { "type": "string" },
+          "label": { "type": "string" },
+          "primary": { "type": "boolean" }
+        }
+      }
+    },
+    "address": {
+      "type": "array",
+      "items": { "type": "object" }
+    },
+    "birthday": { "type": "string" },
+    "company": { "type": "string" },
+    "jobTitle": { "type": "string" },
+    "note": { "type": "string" },
+    "trashed": { "type": "boolean" },
+    "me": { "type": "boolean" }
+  }
+}`
+
+const billsSchema = `{
+  "type": "object",
+  "required": ["amount", "date", "vendor"],
+  "properties": {
+    "amount": { "type": "number" },
+    "originalAmount": { "type": "number" },
+    "groupAmount": { "type": "number" },
+    "currency": { "type": "string" },
+    "date": { "type": "string" },
+    "originalDate": { "type": "string" },
+    "vendor": { "type": "string", "minLength": 1 },
+    "type": { "type": "string" },
+    "subtype": { "type": "string" },
+    "isRefund": { "type": "boolean" },
+    "invoice": { "type": "string" }
+  }
+}`
+
+var builtinSources = map[string]string{
+	consts.Contacts: contactsSchema,
+	consts.Bills:    billsSchema,
+}
+
+var (
+	builtinOnce    sync.Once
+	builtinSchemas map[string]*Schema
+)
+
+// builtin returns the schema registered by the stack for the doctype, or nil.
+func builtin(doctype string) *Schema {
+	builtinOnce.Do(func() {
+		builtinSchemas = make(map[string]*Schema, len(builtinSources))
+		for doctype, source := range builtinSources {
+			s, err := Compile([]byte(source))
+			if err != nil {
+				panic(err)
+			}
+			builtinSchemas[doctype] = s
+		}
+	})
+	return builtinSchemas[doctype]
+}
+
+// Builtin returns the list of the doctypes with a schema registered by the
+// stack.
+func Builtin() []string {
+	doctypes := make([]string, 0, len(builtinSources))
+	for doctype := range builtinSources {
+		doctypes = append(doctypes, doctype)
+	}
+	return doctypes
+}
@@ -0,0 +1,74 @@
+package schema
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// CheckSchemaError is the result of a check of the existing documents: a
+// document that doesn't respect the schema of its doctype.
+type CheckSchemaError struct {
+	Type       string      `json:"type"`
+	Doctype    string      `json:"doctype"`
+	ID         string      `json:"_id,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// CheckDocuments looks for the existing documents of the instance that
+// violate the schema of their doctype, for all the doctypes with a schema (or
+// only the given doctype if it is not empty). The strictness is ignored.
+func CheckDocuments(inst *instance.Instance, doctype string) ([]*CheckSchemaError, error) {
+	doctypes := []string{doctype}
+	if doctype == "" {
+		declared, err := Declared(inst)
+		if err != nil {
+			return nil, err
+		}
+		doctypes = append(Builtin(), declared...)
+		sort.Strings(doctypes)
+	}
+
+	checks := []*CheckSchemaError{}
+	for _, doctype := range doctypes {
+		s, err := Get(inst, doctype)
+		if err != nil {
+			checks = append(checks, &CheckSchemaError{
+				Type:    "invalid_schema",
+				Doctype: doctype,
+				Error:   err.Error(),
+			})
+			continue
+		}
+		if s == nil {
+			continue
+		}
+		err = couchdb.ForeachDocs(inst, doctype, func(id string, data json.RawMessage) error {
+			var doc map[string]interface{}
+			if err := json.Unmarshal(data, &doc); err != nil {
+				checks = append(checks, &CheckSchemaError{
+					Type:    "invalid_json",
+					Doctype: doctype,
+					ID:      id,
+				})
+				return nil
+			}
+			if violations := s.Validate(doc); len(violations) > 0 {
+				checks = append(checks, &CheckSchemaError{
+					Type:       "invalid_document",
+					Doctype:    doctype,
+					ID:         id,
+					Violations: violations,
+				})
+			}
+			return nil
+		})
+		if err != nil && !couchdb.IsNoDatabaseError(err) {
+			return nil, err
+		}
+	}
+	return checks, nil
+}
@@ -0,0 +1,223 @@
+// Package schema is used to check that the documents written via the data API
+// respect the JSON schema of their doctype. The schemas are registered by the
+// stack for some well-known doctypes, and the apps can declare schemas for
+// the other doctypes in their manifests.
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Strictness says what to do with a document that doesn't respect the schema
+// of its doctype.
+type Strictness string
+
+const (
+	// Reject is used to refuse the invalid documents.
+	Reject Strictness = "reject"
+	// Warn is used to accept the invalid documents, but with a warning in the
+	// logs.
+	Warn Strictness = "warn"
+	// Off is used to disable the validation.
+	Off Strictness = "off"
+)
+
+// StrictnessFor returns the strictness of the validation for the given
+// doctype, from the configuration.
+func StrictnessFor(doctype string) Strictness {
+	cfg := config.GetConfig().Schemas
+	level, ok := cfg.Doctypes[doctype]
+	if !ok {
+		level = cfg.Strictness
+	}
+	switch Strictness(level) {
+	case Reject, Off:
+		return Strictness(level)
+	default:
+		return Warn
+	}
+}
+
+// ErrRemoteRef is used when a schema references another schema that is not
+// inside it: the stack won't load it.
+var ErrRemoteRef = errors.New("only the local references are allowed in schemas")
+
+// Schema is a compiled JSON schema for a doctype.
+type Schema struct {
+	schema *gojsonschema.Schema
+}
+
+// Compile checks that the given JSON is a valid JSON schema, and compiles it.
+func Compile(raw []byte) (*Schema, error) {
+	var parsed interface{}
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, err
+	}
+	if hasRemoteRef(parsed) {
+		return nil, ErrRemoteRef
+	}
+	loader := gojsonschema.NewSchemaLoader()
+	loader.Validate = true
+	compiled, err := loader.Compile(gojsonschema.NewGoLoader(parsed))
+	if err != nil {
+		return nil, err
+	}
+	return &Schema{schema: compiled}, nil
+}
+
+func hasRemoteRef(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if ref, ok := item.(string); ok && key == "$ref" && !strings.HasPrefix(ref, "#") {
+				return true
+			}
+			if hasRemoteRef(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasRemoteRef(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Violation is an error found when validating a document. The pointer is a
+// JSON pointer to the invalid field.
+type Violation struct {
+	Pointer string `json:"pointer"`
+	Message string `json:"message"`
+}
+
+// Validate returns the list of the violations of the schema by the document.
+func (s *Schema) Validate(doc map[string]interface{}) []Violation {
+	res, err := s.schema.Validate(gojsonschema.NewGoLoader(doc))
+	if err != nil {
+		return []Violation{{Pointer: "", Message: err.Error()}}
+	}
+	var violations []Violation
+	for _, re := range res.Errors() {
+		pointer := strings.TrimPrefix(re.Context().String("/"), "(root)")
+		switch re.Type() {
+		case "required", "additional_property_not_allowed":
+			if prop, ok := re.Details()["property"].(string); ok {
+				pointer += "/" + prop
+			}
+		}
+		violations = append(violations, Violation{
+			Pointer: pointer,
+			Message: re.Description(),
+		})
+	}
+	return violations
+}
+
+// ValidationError is the error returned when some documents are rejected
+// because they don't respect the schema of their doctype.
+type ValidationError struct {
+	Doctype    string
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	msgs := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		msgs[i] = v.Message
+	}
+	return fmt.Sprintf("invalid document for %s: %s", e.Doctype, strings.Join(msgs, ", "))
+}
+
+// JSONAPIErrors returns the violations as JSON-API errors.
+func (e *ValidationError) JSONAPIErrors() []*jsonapi.Error {
+	errs := make([]*jsonapi.Error, len(e.Violations))
+	for i, v := range e.Violations {
+		errs[i] = &jsonapi.Error{
+			Status: http.StatusUnprocessableEntity,
+			Title:  "Invalid Document",
+			Detail: v.Message,
+			Source: jsonapi.SourceError{Pointer: v.Pointer},
+		}
+	}
+	return errs
+}
+
+// Get returns the schema for the given doctype, or nil if it has no schema.
+// The schemas registered by the stack have the priority over the schemas
+// declared by the apps.
+func Get(inst *instance.Instance, doctype string) (*Schema, error) {
+	if s := builtin(doctype); s != nil {
+		return s, nil
+	}
+	declared, err := declaredSchemas(inst)
+	if err != nil {
+		return nil, err
+	}
+	d, ok := declared[doctype]
+	if !ok {
+		return nil, nil
+	}
+	return compileDeclared(doctype, d)
+}
+
+// Check validates a document that will be written via the data API. With the
+// reject strictness, a *ValidationError is returned for an invalid document.
+// With the warn strictness, the violations are only logged.
+func Check(inst *instance.Instance, doc *couchdb.JSONDoc) error {
+	return CheckBulk(inst, doc.DocType(), []couchdb.JSONDoc{*doc}, "")
+}
+
+// CheckBulk validates several documents of the same doctype, like for
+// _bulk_docs. The JSON pointers of the violations are prefixed by the given
+// prefix, followed by the index of the document. The deleted documents are
+// not checked.
+func CheckBulk(inst *instance.Instance, doctype string, docs []couchdb.JSONDoc, prefix string) error {
+	level := StrictnessFor(doctype)
+	if level == Off {
+		return nil
+	}
+	s, err := Get(inst, doctype)
+	if err != nil || s == nil {
+		return err
+	}
+
+	var violations []Violation
+	for i, doc := range docs {
+		if deleted, _ := doc.M["_deleted"].(bool); deleted {
+			continue
+		}
+		found := s.Validate(doc.M)
+		if len(found) == 0 {
+			continue
+		}
+		if level == Warn {
+			inst.Logger().WithNamespace("schema").
+				Warnf("Invalid document %s for %s: %v", doc.ID(), doctype, found)
+			continue
+		}
+		if prefix != "" {
+			for j := range found {
+				found[j].Pointer = fmt.Sprintf("%s/%d%s", prefix, i, found[j].Pointer)
+			}
+		}
+		violations = append(violations, found...)
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return &ValidationError{Doctype: doctype, Violations: violations}
+}
@@ -0,0 +1,132 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchema(t *testing.T) {
+	config.UseTestFile(t)
+
+	t.Run("Compile", func(t *testing.T) {
+		_, err := Compile([]byte(`{"type": "object", "properties": {"a": {"$ref": "#/definitions/a"}}, "definitions": {"a": {"type": "string"}}}`))
+		assert.NoError(t, err)
+
+		_, err = Compile([]byte(`{"type": "foo"}`))
+		assert.Error(t, err)
+
+		_, err = Compile([]byte(`{"type": "object", "properties": {"a": {"$ref": "http://example.org/a.json"}}}`))
+		assert.Equal(t, ErrRemoteRef, err)
+	})
+
+	t.Run("Validate", func(t *testing.T) {
+		s := builtin(consts.Contacts)
+		require.NotNil(t, s)
+
+		violations := s.Validate(map[string]interface{}{
+			"fullname": "Alice",
+			"email":    []interface{}{map[string]interface{}{"address": "alice@example.org"}},
+			"custom":   42,
+		})
+		assert.Empty(t, violations)
+
+		violations = s.Validate(map[string]interface{}{
+			"fullname": 42,
+			"email":    []interface{}{map[string]interface{}{"label": "work"}},
+		})
+		require.Len(t, violations, 2)
+		pointers := []string{violations[0].Pointer, violations[1].Pointer}
+		assert.ElementsMatch(t, []string{"/fullname", "/email/0/address"}, pointers)
+	})
+
+	t.Run("StrictnessFor", func(t *testing.T) {
+		cfg := config.GetConfig()
+		cfg.Schemas.Strictness = "off"
+		cfg.Schemas.Doctypes = map[string]string{consts.Bills: "reject", consts.Contacts: "foo"}
+		t.Cleanup(func() {
+			cfg.Schemas.Strictness = ""
+			cfg.Schemas.Doctypes = nil
+		})
+
+		assert.Equal(t, Reject, StrictnessFor(consts.Bills))
+		assert.Equal(t, Warn, StrictnessFor(consts.Contacts))
+		assert.Equal(t, Off, StrictnessFor("io.cozy.todos"))
+	})
+
+	t.Run("CheckBulk", func(t *testing.T) {
+		cfg := config.GetConfig()
+		cfg.Schemas.Doctypes = map[string]string{consts.Bills: "reject"}
+		t.Cleanup(func() { cfg.Schemas.Doctypes = nil })
+
+		inst := &instance.Instance{Domain: "schema.example.net"}
+		docs := []couchdb.JSONDoc{
+			{Type: consts.Bills, M: map[string]interface{}{"amount": 1.0, "date": "2024-01-01", "vendor": "EDF"}},
+			{Type: consts.Bills, M: map[string]interface{}{"_id": "deleted", "_deleted": true}},
+			{Type: consts.Bills, M: map[string]interface{}{"amount": 2.0, "date": "2024-01-01"}},
+		}
+		err := CheckBulk(inst, consts.Bills, docs, "/docs")
+		require.Error(t, err)
+		verr, ok := err.(*ValidationError)
+		require.True(t, ok)
+		require.Len(t, verr.Violations, 1)
+		assert.Equal(t, "/docs/2/vendor", verr.Violations[0].Pointer)
+
+		jerrs := verr.JSONAPIErrors()
+		require.Len(t, jerrs, 1)
+		assert.Equal(t, 422, jerrs[0].Status)
+		assert.Equal(t, "/docs/2/vendor", jerrs[0].Source.Pointer)
+
+		assert.NoError(t, CheckBulk(inst, consts.Bills, docs[:2], "/docs"))
+	})
+
+	t.Run("ResolveDeclared", func(t *testing.T) {
+		var apps []declaringApp
+		err := json.Unmarshal([]byte(`[
+			{"slug": "todos", "version": "1.0.0",
+			 "permissions": {"todos": {"type": "io.cozy.todos"}},
+			 "schemas": {"io.cozy.todos": {"type": "object"}}},
+			{"slug": "reader", "version": "1.0.0",
+			 "permissions": {"notes": {"type": "io.cozy.notes", "verbs": ["GET"]}},
+			 "schemas": {"io.cozy.notes": {"type": "object"}}},
+			{"slug": "alpha", "version": "2.0.0",
+			 "permissions": {"todos": {"type": "io.cozy.todos", "verbs": ["GET", "PUT"]}},
+			 "schemas": {"io.cozy.todos": {"type": "object", "required": ["title"]}}},
+			{"slug": "nopermissions", "version": "1.0.0",
+			 "schemas": {"io.cozy.events": {"type": "object"}}}
+		]`), &apps)
+		require.NoError(t, err)
+
+		declared := resolveDeclared(apps, logger.WithNamespace("schema"))
+		require.Len(t, declared, 1)
+		assert.Equal(t, "alpha", declared["io.cozy.todos"].Slug)
+		assert.Equal(t, "2.0.0", declared["io.cozy.todos"].Version)
+	})
+
+	t.Run("CompileDeclared", func(t *testing.T) {
+		d := declaredSchema{Slug: "todos", Version: "1.0.0", Schema: json.RawMessage(`{"type": "object"}`)}
+		s1, err := compileDeclared("io.cozy.todos", d)
+		require.NoError(t, err)
+		s2, err := compileDeclared("io.cozy.todos", d)
+		require.NoError(t, err)
+		assert.Same(t, s1, s2)
+
+		d.Version = "1.0.1"
+		s3, err := compileDeclared("io.cozy.todos", d)
+		require.NoError(t, err)
+		assert.NotSame(t, s1, s3)
+
+		d.Schema = json.RawMessage(`{"type": "object", "required": ["title"]}`)
+		s4, err := compileDeclared("io.cozy.todos", d)
+		require.NoError(t, err)
+		assert.NotSame(t, s3, s4)
+		assert.NotEmpty(t, s4.Validate(map[string]interface{}{}))
+	})
+}
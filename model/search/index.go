@@ -0,0 +1,320 @@
+package search
+
+import (
+	"compress/gzip"
+	"encoding/gob"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// indexFormatVersion is incremented when the serialization of the index
+// changes in a way that is not compatible. An index with another version is
+// rebuilt from scratch.
+const indexFormatVersion = 1
+
+// excerptLength is the maximal number of characters of the excerpt kept for
+// each document.
+const excerptLength = 200
+
+// titleBoost is the number of times the terms of the title are counted, to
+// rank the documents that match in their title first.
+const titleBoost = 2
+
+// Parameters for the BM25 ranking function
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// errIncompatibleIndex is used when the stored index has been written with
+// another format.
+var errIncompatibleIndex = errors.New("search: incompatible index format")
+
+// Document is what is given to the index for a CouchDB document.
+type Document struct {
+	Doctype string
+	ID      string
+	Title   string
+	Texts   []string
+}
+
+// Entry is a document in the index.
+type Entry struct {
+	Doctype string
+	ID      string
+	Title   string
+	Excerpt string
+	Terms   map[string]int
+	Length  int
+}
+
+// Hit is a document that matches a search query.
+type Hit struct {
+	Doctype string
+	ID      string
+	Title   string
+	Excerpt string
+	Score   float64
+}
+
+// Query is a search query on the index.
+type Query struct {
+	// Text is the searched text. All of its terms must be found in a
+	// document, and the last one can be the prefix of a term (for searching
+	// as you type).
+	Text string
+	// Doctypes can be used to restrict the search to some doctypes.
+	Doctypes []string
+}
+
+// Index is an inverted index of the documents of an instance. It also keeps
+// the last sequence of the changes feed of each indexed doctype.
+type Index struct {
+	Sequences map[string]string
+	Entries   map[string]*Entry
+
+	postings    map[string]map[string]int // term -> entry key -> frequency
+	totalLength int
+}
+
+// NewIndex returns an empty index.
+func NewIndex() *Index {
+	return &Index{
+		Sequences: make(map[string]string),
+		Entries:   make(map[string]*Entry),
+		postings:  make(map[string]map[string]int),
+	}
+}
+
+func entryKey(doctype, id string) string {
+	return doctype + "/" + id
+}
+
+// Len returns the number of documents in the index.
+func (idx *Index) Len() int {
+	return len(idx.Entries)
+}
+
+// Put adds a document to the index, or replaces it if it was already indexed.
+func (idx *Index) Put(doc Document) {
+	idx.Delete(doc.Doctype, doc.ID)
+
+	terms := make(map[string]int)
+	length := 0
+	for _, term := range Tokenize(doc.Title) {
+		terms[term] += titleBoost
+		length += titleBoost
+	}
+	for _, text := range doc.Texts {
+		for _, term := range Tokenize(text) {
+			terms[term]++
+			length++
+		}
+	}
+	entry := &Entry{
+		Doctype: doc.Doctype,
+		ID:      doc.ID,
+		Title:   doc.Title,
+		Excerpt: makeExcerpt(doc.Texts),
+		Terms:   terms,
+		Length:  length,
+	}
+	idx.add(entry)
+}
+
+func (idx *Index) add(entry *Entry) {
+	key := entryKey(entry.Doctype, entry.ID)
+	idx.Entries[key] = entry
+	for term, freq := range entry.Terms {
+		docs, ok := idx.postings[term]
+		if !ok {
+			docs = make(map[string]int)
+			idx.postings[term] = docs
+		}
+		docs[key] = freq
+	}
+	idx.totalLength += entry.Length
+}
+
+// Delete removes a document from the index.
+func (idx *Index) Delete(doctype, id string) {
+	key := entryKey(doctype, id)
+	entry, ok := idx.Entries[key]
+	if !ok {
+		return
+	}
+	for term := range entry.Terms {
+		delete(idx.postings[term], key)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLength -= entry.Length
+	delete(idx.Entries, key)
+}
+
+// Search returns the documents that match the query, the most relevant
+// first.
+func (idx *Index) Search(q Query) []*Hit {
+	terms := Tokenize(q.Text)
+	if len(terms) == 0 || len(idx.Entries) == 0 {
+		return nil
+	}
+	var doctypes map[string]bool
+	if len(q.Doctypes) > 0 {
+		doctypes = make(map[string]bool, len(q.Doctypes))
+		for _, doctype := range q.Doctypes {
+			doctypes[doctype] = true
+		}
+	}
+
+	var scores map[string]float64
+	for i, term := range terms {
+		matches := []string{term}
+		if i == len(terms)-1 {
+			matches = idx.termsWithPrefix(term)
+		}
+		termScores := make(map[string]float64)
+		for _, match := range matches {
+			idx.scoreTerm(match, termScores)
+		}
+		if scores == nil {
+			scores = termScores
+			continue
+		}
+		// All the terms must be found in a document
+		for key, score := range scores {
+			if s, ok := termScores[key]; ok {
+				scores[key] = score + s
+			} else {
+				delete(scores, key)
+			}
+		}
+	}
+
+	hits := make([]*Hit, 0, len(scores))
+	for key, score := range scores {
+		entry := idx.Entries[key]
+		if doctypes != nil && !doctypes[entry.Doctype] {
+			continue
+		}
+		hits = append(hits, &Hit{
+			Doctype: entry.Doctype,
+			ID:      entry.ID,
+			Title:   entry.Title,
+			Excerpt: entry.Excerpt,
+			Score:   score,
+		})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if hits[i].Title != hits[j].Title {
+			return hits[i].Title < hits[j].Title
+		}
+		return entryKey(hits[i].Doctype, hits[i].ID) < entryKey(hits[j].Doctype, hits[j].ID)
+	})
+	return hits
+}
+
+func (idx *Index) termsWithPrefix(prefix string) []string {
+	var terms []string
+	for term := range idx.postings {
+		if strings.HasPrefix(term, prefix) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// scoreTerm adds the BM25 score of the term for each document that contains
+// it.
+func (idx *Index) scoreTerm(term string, scores map[string]float64) {
+	docs := idx.postings[term]
+	if len(docs) == 0 {
+		return
+	}
+	n := float64(len(idx.Entries))
+	df := float64(len(docs))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+	avgLength := float64(idx.totalLength) / n
+	if avgLength == 0 {
+		avgLength = 1
+	}
+	for key, freq := range docs {
+		tf := float64(freq)
+		length := float64(idx.Entries[key].Length)
+		scores[key] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/avgLength))
+	}
+}
+
+func makeExcerpt(texts []string) string {
+	var sb strings.Builder
+	n := 0
+	for _, text := range texts {
+		for _, field := range strings.FieldsFunc(text, unicode.IsSpace) {
+			if n > 0 {
+				sb.WriteByte(' ')
+				n++
+			}
+			for _, r := range field {
+				if n >= excerptLength {
+					return sb.String() + "…"
+				}
+				sb.WriteRune(r)
+				n++
+			}
+		}
+	}
+	return sb.String()
+}
+
+type serializedIndex struct {
+	Version   int
+	Sequences map[string]string
+	Entries   map[string]*Entry
+}
+
+// Encode writes the index to w. The postings are not written, as they can be
+// computed from the entries.
+func (idx *Index) Encode(w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	err := gob.NewEncoder(gw).Encode(serializedIndex{
+		Version:   indexFormatVersion,
+		Sequences: idx.Sequences,
+		Entries:   idx.Entries,
+	})
+	if errc := gw.Close(); err == nil {
+		err = errc
+	}
+	return err
+}
+
+// DecodeIndex reads an index written by Encode.
+func DecodeIndex(r io.Reader) (*Index, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gr.Close()
+	var s serializedIndex
+	if err := gob.NewDecoder(gr).Decode(&s); err != nil {
+		return nil, err
+	}
+	if s.Version != indexFormatVersion {
+		return nil, errIncompatibleIndex
+	}
+	idx := NewIndex()
+	if s.Sequences != nil {
+		idx.Sequences = s.Sequences
+	}
+	for _, entry := range s.Entries {
+		idx.add(entry)
+	}
+	return idx, nil
+}
@@ -0,0 +1,141 @@
+package search
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	t.Run("Tokenize", func(t *testing.T) {
+		assert.Equal(t, []string{"ete", "ecole"}, Tokenize("Été à l'école"))
+		assert.Equal(t, []string{"hello", "world", "42"}, Tokenize("Hello, World! 42"))
+		assert.Equal(t, []string{"cafe", "creme"}, Tokenize("  CAFÉ-crème x "))
+		assert.Empty(t, Tokenize("a b ! ?"))
+	})
+
+	makeIndex := func() *Index {
+		idx := NewIndex()
+		idx.Put(Document{Doctype: consts.Files, ID: "f1", Title: "Holidays.cozy-note",
+			Texts: []string{"Plans for the summer: hiking in the Alps"}})
+		idx.Put(Document{Doctype: consts.Files, ID: "f2", Title: "report.txt",
+			Texts: []string{"The quarterly report about the holidays budget"}})
+		idx.Put(Document{Doctype: consts.Contacts, ID: "c1", Title: "Hélène Dupont",
+			Texts: []string{"helene@example.org", "Alps Travel"}})
+		return idx
+	}
+
+	t.Run("Search", func(t *testing.T) {
+		idx := makeIndex()
+		assert.Equal(t, 3, idx.Len())
+
+		hits := idx.Search(Query{Text: "holidays"})
+		require.Len(t, hits, 2)
+		// The match in the title is ranked first
+		assert.Equal(t, "f1", hits[0].ID)
+		assert.Equal(t, "f2", hits[1].ID)
+		assert.Equal(t, "Plans for the summer: hiking in the Alps", hits[0].Excerpt)
+
+		// All the terms must match
+		hits = idx.Search(Query{Text: "holidays alps"})
+		require.Len(t, hits, 1)
+		assert.Equal(t, "f1", hits[0].ID)
+
+		// The last term is a prefix, and the accents are ignored
+		hits = idx.Search(Query{Text: "HELE"})
+		require.Len(t, hits, 1)
+		assert.Equal(t, "c1", hits[0].ID)
+		assert.Empty(t, idx.Search(Query{Text: "hele dupont"}))
+
+		hits = idx.Search(Query{Text: "alps", Doctypes: []string{consts.Contacts}})
+		require.Len(t, hits, 1)
+		assert.Equal(t, consts.Contacts, hits[0].Doctype)
+
+		assert.Empty(t, idx.Search(Query{Text: "unknown"}))
+		assert.Empty(t, idx.Search(Query{Text: "!"}))
+	})
+
+	t.Run("PutAndDelete", func(t *testing.T) {
+		idx := makeIndex()
+		idx.Put(Document{Doctype: consts.Files, ID: "f2", Title: "report.txt",
+			Texts: []string{"Nothing to see"}})
+		assert.Equal(t, 3, idx.Len())
+		assert.Len(t, idx.Search(Query{Text: "holidays"}), 1)
+		assert.Len(t, idx.Search(Query{Text: "nothing"}), 1)
+
+		idx.Delete(consts.Files, "f1")
+		idx.Delete(consts.Files, "unknown")
+		assert.Equal(t, 2, idx.Len())
+		assert.Empty(t, idx.Search(Query{Text: "holidays"}))
+		assert.NotContains(t, idx.postings, "hiking")
+	})
+
+	t.Run("EncodeDecode", func(t *testing.T) {
+		idx := makeIndex()
+		idx.Sequences[consts.Files] = "42-abc"
+
+		var buf bytes.Buffer
+		require.NoError(t, idx.Encode(&buf))
+		decoded, err := DecodeIndex(&buf)
+		require.NoError(t, err)
+		assert.Equal(t, idx.Sequences, decoded.Sequences)
+		assert.Equal(t, idx.Len(), decoded.Len())
+		assert.Equal(t, idx.totalLength, decoded.totalLength)
+		assert.Equal(t, idx.Search(Query{Text: "holidays"}), decoded.Search(Query{Text: "holidays"}))
+	})
+
+	t.Run("FieldsDocument", func(t *testing.T) {
+		fields := []string{"fullname", "email.address", "phone.number"}
+		doc := fieldsDocument(consts.Contacts, "c1", fields, map[string]interface{}{
+			"fullname": "Alice",
+			"email": []interface{}{
+				map[string]interface{}{"address": "alice@example.org"},
+				map[string]interface{}{"address": "alice@work.example"},
+			},
+			"phone": map[string]interface{}{"number": "0123"},
+		})
+		require.NotNil(t, doc)
+		assert.Equal(t, "Alice", doc.Title)
+		assert.Equal(t, []string{"alice@example.org", "alice@work.example", "0123"}, doc.Texts)
+
+		assert.Nil(t, fieldsDocument(consts.Contacts, "c2", fields, map[string]interface{}{}))
+	})
+
+	t.Run("LoadCached", func(t *testing.T) {
+		config.UseTestFile(t)
+		config.GetConfig().Search.Path = t.TempDir()
+		inst := &instance.Instance{Domain: "alice.cozy.localhost"}
+		store := SystemStore()
+
+		idx, err := loadCached(inst)
+		require.NoError(t, err)
+		assert.Equal(t, 0, idx.Len())
+
+		require.NoError(t, store.Save(inst.Domain, makeIndex()))
+		first, err := loadCached(inst)
+		require.NoError(t, err)
+		assert.Equal(t, 3, first.Len())
+		again, err := loadCached(inst)
+		require.NoError(t, err)
+		assert.Same(t, first, again)
+
+		// The index saved by another stack is loaded again
+		bigger := makeIndex()
+		bigger.Put(Document{Doctype: consts.Files, ID: "f3", Title: "todo.txt"})
+		require.NoError(t, store.Save(inst.Domain, bigger))
+		reloaded, err := loadCached(inst)
+		require.NoError(t, err)
+		assert.NotSame(t, first, reloaded)
+		assert.Equal(t, 4, reloaded.Len())
+
+		invalidateCache(inst)
+		afterInvalidate, err := loadCached(inst)
+		require.NoError(t, err)
+		assert.NotSame(t, reloaded, afterInvalidate)
+	})
+}
@@ -0,0 +1,295 @@
+package search
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// IndexWorker is the name of the worker that updates the indexes.
+const IndexWorker = "search-index"
+
+// BatchSize is the maximal number of changes indexed at once by the worker.
+const BatchSize = 100
+
+// maxContentSize is the maximal number of bytes read from the content of a
+// file to index it.
+const maxContentSize = 1024 * 1024 // 1 MB
+
+// defaultDoctypes are the doctypes indexed when the config doesn't say
+// otherwise, with their indexed fields. The first field is used as the title.
+var defaultDoctypes = map[string][]string{
+	consts.Contacts: {"fullname", "email.address", "phone.number", "company", "note"},
+}
+
+// IndexMessage is the message for the search-index worker. An empty doctype
+// means all the indexed doctypes.
+type IndexMessage struct {
+	Doctype string `json:"doctype,omitempty"`
+}
+
+// Doctypes returns the indexed doctypes, with their indexed fields. The
+// files have no fields, as they are indexed with their name and content.
+func Doctypes() map[string][]string {
+	doctypes := config.GetConfig().Search.Doctypes
+	if len(doctypes) == 0 {
+		doctypes = defaultDoctypes
+	}
+	result := make(map[string][]string, len(doctypes)+1)
+	for doctype, fields := range doctypes {
+		result[doctype] = fields
+	}
+	result[consts.Files] = nil
+	return result
+}
+
+// Update indexes the last changes of the given doctype (or all the indexed
+// doctypes). If there are more changes than the batch size, a new job is
+// pushed to continue.
+func Update(inst *instance.Instance, msg IndexMessage) error {
+	mu := config.Lock().ReadWrite(inst, "search-index")
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	idx, err := Load(inst)
+	if err != nil {
+		return err
+	}
+	if len(idx.Sequences) == 0 {
+		// The triggers that keep the index up-to-date are created on the
+		// first build of the index.
+		if err := EnsureTriggers(inst); err != nil {
+			return err
+		}
+	}
+
+	doctypes := Doctypes()
+	var names []string
+	if msg.Doctype != "" {
+		if _, ok := doctypes[msg.Doctype]; !ok {
+			return fmt.Errorf("search: %s is not indexed", msg.Doctype)
+		}
+		names = []string{msg.Doctype}
+	} else {
+		for doctype := range doctypes {
+			names = append(names, doctype)
+		}
+		sort.Strings(names)
+	}
+
+	ix := &indexer{inst: inst, idx: idx}
+	var pending []string
+	for _, doctype := range names {
+		more, err := ix.indexChanges(doctype, doctypes[doctype])
+		if err != nil {
+			return err
+		}
+		if more {
+			pending = append(pending, doctype)
+		}
+	}
+
+	err = SystemStore().Save(inst.Domain, idx)
+	invalidateCache(inst)
+	if err != nil {
+		return err
+	}
+	for _, doctype := range pending {
+		if err := PushIndexJob(inst, doctype); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type indexer struct {
+	inst *instance.Instance
+	idx  *Index
+}
+
+// indexChanges indexes a batch of changes for the doctype, and returns true
+// if there are more changes to index.
+func (ix *indexer) indexChanges(doctype string, fields []string) (bool, error) {
+	res, err := couchdb.GetChanges(ix.inst, &couchdb.ChangesRequest{
+		DocType:     doctype,
+		Since:       ix.idx.Sequences[doctype],
+		IncludeDocs: true,
+		Limit:       BatchSize,
+	})
+	if couchdb.IsNoDatabaseError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, change := range res.Results {
+		if strings.HasPrefix(change.DocID, "_design") {
+			continue
+		}
+		if change.Deleted {
+			ix.idx.Delete(doctype, change.DocID)
+			continue
+		}
+		var doc *Document
+		if doctype == consts.Files {
+			doc = ix.fileDocument(change)
+		} else {
+			doc = fieldsDocument(doctype, change.DocID, fields, change.Doc.M)
+		}
+		if doc == nil {
+			ix.idx.Delete(doctype, change.DocID)
+		} else {
+			ix.idx.Put(*doc)
+		}
+	}
+	ix.idx.Sequences[doctype] = res.LastSeq
+	return res.Pending > 0, nil
+}
+
+// fileDocument returns the document to index for a file or a directory, or
+// nil if it must not be indexed (like the files in the trash).
+func (ix *indexer) fileDocument(change couchdb.Change) *Document {
+	raw, err := json.Marshal(change.Doc.M)
+	if err != nil {
+		return nil
+	}
+	var doc vfs.DirOrFileDoc
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return nil
+	}
+	dir, file := doc.Refine()
+	if dir != nil {
+		if dir.DocID == consts.RootDirID || dir.DocID == consts.TrashDirID ||
+			strings.HasPrefix(dir.Fullpath, vfs.TrashDirName) {
+			return nil
+		}
+		return &Document{Doctype: consts.Files, ID: dir.DocID, Title: dir.DocName}
+	}
+	if file == nil || file.Trashed {
+		return nil
+	}
+	result := &Document{Doctype: consts.Files, ID: file.DocID, Title: file.DocName}
+	text, err := ix.fileText(file)
+	if err != nil {
+		ix.inst.Logger().WithNamespace("search").
+			Infof("Cannot extract the text of %s: %s", file.DocID, err)
+	} else if text != "" {
+		result.Texts = []string{text}
+	}
+	return result
+}
+
+// fileText returns the text of a note or of a text file. For the other
+// files, only their name is indexed.
+func (ix *indexer) fileText(file *vfs.FileDoc) (string, error) {
+	if file.Mime == consts.NoteMimeType {
+		return note.GetText(ix.inst, file)
+	}
+	if file.Class != "text" && !strings.HasPrefix(file.Mime, "text/") {
+		return "", nil
+	}
+	f, err := ix.inst.VFS().OpenFile(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxContentSize))
+	if err != nil {
+		return "", err
+	}
+	return strings.ToValidUTF8(string(data), ""), nil
+}
+
+// fieldsDocument returns the document to index with the values of the given
+// fields. The fields can use the dot notation for nested values, and the
+// arrays are traversed.
+func fieldsDocument(doctype, id string, fields []string, doc map[string]interface{}) *Document {
+	var texts []string
+	for _, field := range fields {
+		texts = append(texts, fieldValues(doc, strings.Split(field, "."))...)
+	}
+	if len(texts) == 0 {
+		return nil
+	}
+	return &Document{Doctype: doctype, ID: id, Title: texts[0], Texts: texts[1:]}
+}
+
+func fieldValues(value interface{}, fieldPath []string) []string {
+	switch v := value.(type) {
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			values = append(values, fieldValues(item, fieldPath)...)
+		}
+		return values
+	case map[string]interface{}:
+		if len(fieldPath) == 0 {
+			return nil
+		}
+		return fieldValues(v[fieldPath[0]], fieldPath[1:])
+	case string:
+		if len(fieldPath) > 0 || v == "" {
+			return nil
+		}
+		return []string{v}
+	case float64, bool:
+		if len(fieldPath) > 0 {
+			return nil
+		}
+		return []string{fmt.Sprintf("%v", v)}
+	default:
+		return nil
+	}
+}
+
+// PushIndexJob adds a job to update the index for the given doctype (or all
+// the indexed doctypes if it is empty).
+func PushIndexJob(inst *instance.Instance, doctype string) error {
+	msg, err := job.NewMessage(&IndexMessage{Doctype: doctype})
+	if err != nil {
+		return err
+	}
+	_, err = job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: IndexWorker,
+		Message:    msg,
+	})
+	return err
+}
+
+// EnsureTriggers creates the @event triggers that update the index when the
+// indexed documents change, if they don't exist yet.
+func EnsureTriggers(inst *instance.Instance) error {
+	sched := job.System()
+	for doctype := range Doctypes() {
+		infos := job.TriggerInfos{
+			Type:       "@event",
+			WorkerType: IndexWorker,
+			Arguments:  doctype,
+			Debounce:   "1m",
+		}
+		if sched.HasTrigger(inst, infos) {
+			continue
+		}
+		trigger, err := job.NewTrigger(inst, infos, &IndexMessage{Doctype: doctype})
+		if err != nil {
+			return err
+		}
+		if err := sched.AddTrigger(trigger); err != nil {
+			return err
+		}
+	}
+	return nil
+}
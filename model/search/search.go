@@ -0,0 +1,151 @@
+// Package search is a built-in full-text search engine. Each instance has an
+// index, fed from the changes feed of CouchDB for the files and some other
+// doctypes, and persisted next to the files.
+package search
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// indexCacheSize is the number of decoded indexes kept in memory for the
+// searches.
+const indexCacheSize = 64
+
+type cachedIndex struct {
+	idx     *Index
+	version string
+}
+
+var (
+	indexCache     *lru.Cache[string, cachedIndex]
+	indexCacheOnce sync.Once
+)
+
+func getIndexCache() *lru.Cache[string, cachedIndex] {
+	indexCacheOnce.Do(func() {
+		c, err := lru.New[string, cachedIndex](indexCacheSize)
+		if err != nil {
+			panic(err)
+		}
+		indexCache = c
+	})
+	return indexCache
+}
+
+// Load returns the index of the instance, or an empty index if it has not
+// been built yet.
+func Load(inst *instance.Instance) (*Index, error) {
+	r, err := SystemStore().Open(inst.Domain)
+	if errors.Is(err, ErrIndexNotFound) {
+		return NewIndex(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	idx, err := DecodeIndex(r)
+	if errors.Is(err, errIncompatibleIndex) {
+		return NewIndex(), nil
+	}
+	return idx, err
+}
+
+// loadCached returns the index of the instance for a search. The decoded
+// index is kept in memory, and reused until the index is saved again, by this
+// stack or by another one as the version of the saved index is checked. The
+// returned index must not be modified.
+func loadCached(inst *instance.Instance) (*Index, error) {
+	version, err := SystemStore().Version(inst.Domain)
+	if errors.Is(err, ErrIndexNotFound) {
+		return NewIndex(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cache := getIndexCache()
+	if cached, ok := cache.Get(inst.Domain); ok && cached.version == version {
+		return cached.idx, nil
+	}
+	idx, err := Load(inst)
+	if err != nil {
+		return nil, err
+	}
+	cache.Add(inst.Domain, cachedIndex{idx: idx, version: version})
+	return idx, nil
+}
+
+// invalidateCache removes the decoded index of the instance from the cache.
+func invalidateCache(inst *instance.Instance) {
+	getIndexCache().Remove(inst.Domain)
+}
+
+// RemoveIndex deletes the index of the instance.
+func RemoveIndex(inst *instance.Instance) error {
+	invalidateCache(inst)
+	return SystemStore().Remove(inst.Domain)
+}
+
+// Search returns the documents that match the query and can be read with
+// the given permission set, at most limit of them. The index is built on the
+// first search.
+func Search(inst *instance.Instance, pset permission.Set, q Query, limit int) ([]*Hit, error) {
+	idx, err := loadCached(inst)
+	if err != nil {
+		return nil, err
+	}
+	if len(idx.Sequences) == 0 {
+		// The index has never been built, let's start it now
+		if err := PushIndexJob(inst, ""); err != nil {
+			inst.Logger().WithNamespace("search").
+				Warnf("Cannot push the index job: %s", err)
+		}
+	}
+
+	var hits []*Hit
+	for _, hit := range idx.Search(q) {
+		if limit > 0 && len(hits) >= limit {
+			break
+		}
+		if allowed(inst, pset, hit) {
+			hits = append(hits, hit)
+		}
+	}
+	return hits, nil
+}
+
+// allowed returns true if the permission set allows to read the document of
+// the hit.
+func allowed(inst *instance.Instance, pset permission.Set, hit *Hit) bool {
+	if pset.IsMaximal() || pset.AllowWholeType(permission.GET, hit.Doctype) {
+		return true
+	}
+	if hit.Doctype == consts.Files {
+		fs := inst.VFS()
+		dir, file, err := fs.DirOrFileByID(hit.ID)
+		if err != nil {
+			return false
+		}
+		var fetcher vfs.Fetcher = file
+		if dir != nil {
+			fetcher = dir
+		}
+		return vfs.Allows(fs, pset, permission.GET, fetcher) == nil
+	}
+	if pset.AllowID(permission.GET, hit.Doctype, hit.ID) {
+		return true
+	}
+	doc := &couchdb.JSONDoc{}
+	if err := couchdb.GetDoc(inst, hit.Doctype, hit.ID, doc); err != nil {
+		return false
+	}
+	doc.Type = hit.Doctype
+	return pset.Allow(permission.GET, doc)
+}
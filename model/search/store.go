@@ -0,0 +1,198 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/ncw/swift/v2"
+	"github.com/spf13/afero"
+)
+
+// ErrIndexNotFound is used when the index of an instance has not been built
+// yet.
+var ErrIndexNotFound = errors.New("search: index not found")
+
+// Store is an interface describing where the indexes are persisted.
+type Store interface {
+	Open(domain string) (io.ReadCloser, error)
+	// Version returns a string that changes each time the index is saved.
+	Version(domain string) (string, error)
+	Save(domain string, idx *Index) error
+	Remove(domain string) error
+}
+
+var (
+	memStoreOnce sync.Once
+	memStore     Store
+)
+
+// SystemStore returns the store for the indexes, corresponding to the user's
+// configuration.
+func SystemStore() Store {
+	if dir := config.GetConfig().Search.Path; dir != "" {
+		return newAferoStore(afero.NewBasePathFs(afero.NewOsFs(), dir))
+	}
+	fsURL := config.FsURL()
+	switch fsURL.Scheme {
+	case config.SchemeFile:
+		fs := afero.NewBasePathFs(afero.NewOsFs(), path.Join(fsURL.Path, "search"))
+		return newAferoStore(fs)
+	case config.SchemeMem:
+		memStoreOnce.Do(func() {
+			memStore = newAferoStore(afero.NewMemMapFs())
+		})
+		return memStore
+	case config.SchemeSwift, config.SchemeSwiftSecure:
+		return newSwiftStore()
+	default:
+		panic(fmt.Errorf("search: unknown storage provider %s", fsURL.Scheme))
+	}
+}
+
+func newAferoStore(fs afero.Fs) Store {
+	return aferoStore{fs}
+}
+
+type aferoStore struct {
+	fs afero.Fs
+}
+
+func (s aferoStore) fileName(domain string) string {
+	return path.Join("/", domain+".idx")
+}
+
+func (s aferoStore) Open(domain string) (io.ReadCloser, error) {
+	f, err := s.fs.Open(s.fileName(domain))
+	if os.IsNotExist(err) {
+		return nil, ErrIndexNotFound
+	}
+	return f, err
+}
+
+func (s aferoStore) Version(domain string) (string, error) {
+	info, err := s.fs.Stat(s.fileName(domain))
+	if os.IsNotExist(err) {
+		return "", ErrIndexNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d-%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// Save writes the index in a temporary file, and then renames it, to not
+// leave a partial index if the stack is stopped while writing.
+func (s aferoStore) Save(domain string, idx *Index) error {
+	name := s.fileName(domain)
+	tmp := name + ".tmp"
+	f, err := s.fs.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if os.IsNotExist(err) {
+		if err = s.fs.MkdirAll("/", 0700); err == nil {
+			f, err = s.fs.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	err = idx.Encode(f)
+	if errc := f.Close(); err == nil {
+		err = errc
+	}
+	if err != nil {
+		_ = s.fs.Remove(tmp)
+		return err
+	}
+	return s.fs.Rename(tmp, name)
+}
+
+func (s aferoStore) Remove(domain string) error {
+	err := s.fs.Remove(s.fileName(domain))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func newSwiftStore() Store {
+	return &swiftStore{
+		c:         config.GetSwiftConnection(),
+		container: "search",
+		ctx:       context.Background(),
+	}
+}
+
+type swiftStore struct {
+	c         *swift.Connection
+	container string
+	ctx       context.Context
+}
+
+func (s *swiftStore) init() error {
+	if _, _, err := s.c.Container(s.ctx, s.container); errors.Is(err, swift.ContainerNotFound) {
+		if err = s.c.ContainerCreate(s.ctx, s.container, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *swiftStore) Open(domain string) (io.ReadCloser, error) {
+	if err := s.init(); err != nil {
+		return nil, err
+	}
+	f, _, err := s.c.ObjectOpen(s.ctx, s.container, domain, false, nil)
+	if errors.Is(err, swift.ObjectNotFound) {
+		return nil, ErrIndexNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (s *swiftStore) Version(domain string) (string, error) {
+	if err := s.init(); err != nil {
+		return "", err
+	}
+	obj, _, err := s.c.Object(s.ctx, s.container, domain)
+	if errors.Is(err, swift.ObjectNotFound) {
+		return "", ErrIndexNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d", obj.Hash, obj.LastModified.UnixNano()), nil
+}
+
+func (s *swiftStore) Save(domain string, idx *Index) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	f, err := s.c.ObjectCreate(s.ctx, s.container, domain, true, "",
+		"application/octet-stream", nil)
+	if err != nil {
+		return err
+	}
+	err = idx.Encode(f)
+	if errc := f.Close(); err == nil {
+		err = errc
+	}
+	return err
+}
+
+func (s *swiftStore) Remove(domain string) error {
+	if err := s.init(); err != nil {
+		return err
+	}
+	err := s.c.ObjectDelete(s.ctx, s.container, domain)
+	if errors.Is(err, swift.ObjectNotFound) {
+		return nil
+	}
+	return err
+}
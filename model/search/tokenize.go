@@ -0,0 +1,43 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// minTermLength is the minimal number of characters for a term to be
+	// indexed. Shorter terms would match too many documents.
+	minTermLength = 2
+	// maxTermLength is the maximal number of characters for a term to be
+	// indexed, to ignore the long base64 strings and other noise.
+	maxTermLength = 64
+)
+
+// Tokenize splits a text in terms that can be indexed and searched: they are
+// in lower case and without accents.
+func Tokenize(text string) []string {
+	// The transformer is stateful, and cannot be shared between goroutines
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	lower := strings.ToLower(text)
+	normalized, _, err := transform.String(t, lower)
+	if err != nil {
+		normalized = lower
+	}
+	fields := strings.FieldsFunc(normalized, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	terms := fields[:0]
+	for _, field := range fields {
+		n := utf8.RuneCountInString(field)
+		if n >= minTermLength && n <= maxTermLength {
+			terms = append(terms, field)
+		}
+	}
+	return terms
+}
@@ -3,6 +3,7 @@ package sharing
 import (
 	"os"
 	"path"
+	"strings"
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/permission"
@@ -13,8 +14,9 @@ import (
 
 // SharingScope describes a single sharing scope that applies to a target file
 // or folder. It is produced by AccessResolver.scopesFor and consumed by
-// Resolve to aggregate an EffectiveAccess. ReadOnly reflects the current
-// instance's membership for that scope (via Sharing.MemberFor).
+// Resolve to aggregate an EffectiveAccess. ReadOnly reflects the membership
+// of the current instance (or of the member the resolver is bound to) for
+// that scope.
 type SharingScope struct {
 	SharingID  string
 	RootID     string
@@ -23,15 +25,17 @@ type SharingScope struct {
 	ReadOnly   bool
 }
 
-// EffectiveAccess is the merged result of all applicable additive sharing
-// scopes for a target, from the perspective of the current instance. CanRead
-// is true if the instance is a member of at least one applicable scope;
-// CanWrite is true if at least one such scope grants write (highest right
-// wins).
+// EffectiveAccess is the merged result of all applicable sharing scopes for a
+// target, from the perspective of the current instance. CanRead is true if the
+// instance is a member of at least one applicable scope; CanWrite is true if
+// at least one such scope grants write (highest right wins). When the target
+// is inside a limited_access boundary, Boundary is this boundary, and the
+// scopes above it are not applicable.
 type EffectiveAccess struct {
 	CanRead          bool
 	CanWrite         bool
 	SourceSharingIDs []string
+	Boundary         *SharingScope
 }
 
 // Can reports whether the effective access satisfies the given verb. GET
@@ -53,7 +57,8 @@ func (ea *EffectiveAccess) Can(verb permission.Verb) bool {
 // covering the target itself (if shared) plus all shared ancestors on its
 // path, filtered to scopes where the current instance is a member.
 type AccessResolver struct {
-	inst *instance.Instance
+	inst   *instance.Instance
+	member *Member
 }
 
 // NewAccessResolver builds an AccessResolver bound to an instance.
@@ -61,17 +66,30 @@ func NewAccessResolver(inst *instance.Instance) *AccessResolver {
 	return &AccessResolver{inst: inst}
 }
 
+// NewAccessResolverForMember builds an AccessResolver for the sharings owned
+// by the instance, but from the perspective of the given member (a recipient
+// making a request on a shared drive for example).
+func NewAccessResolverForMember(inst *instance.Instance, m *Member) *AccessResolver {
+	return &AccessResolver{inst: inst, member: m}
+}
+
 // Resolve returns the effective access for the given file or folder. The
 // target's own share (if it is a shared file) and the shares of every
 // ancestor directory are all considered. Scopes where the current instance
-// is not a member are ignored. The highest permission wins: CanRead if at
-// least one applicable scope, CanWrite if at least one non-read-only scope.
+// is not a member are ignored, and so are the scopes above the nearest
+// limited_access boundary. The highest permission wins: CanRead if at least
+// one applicable scope, CanWrite if at least one non-read-only scope.
 func (r *AccessResolver) Resolve(targetID string) (*EffectiveAccess, error) {
-	scopes, err := r.scopesFor(targetID)
+	candidates, err := r.candidatesFor(targetID)
 	if err != nil {
 		return nil, err
 	}
-	ea := &EffectiveAccess{SourceSharingIDs: make([]string, 0, len(scopes))}
+	boundary := nearestBoundary(candidates)
+	scopes := applicableScopes(candidates, boundary)
+	ea := &EffectiveAccess{
+		SourceSharingIDs: make([]string, 0, len(scopes)),
+		Boundary:         boundary,
+	}
 	for _, sc := range scopes {
 		ea.SourceSharingIDs = append(ea.SourceSharingIDs, sc.SharingID)
 		ea.CanRead = true
@@ -82,11 +100,75 @@ func (r *AccessResolver) Resolve(targetID string) (*EffectiveAccess, error) {
 	return ea, nil
 }
 
-// scopesFor loads the target, builds ancestor paths, finds shared roots on
-// the path, adds the target's own file share if any, bulk-loads sharings,
-// filters to active additive ones where the current instance is a member,
-// and returns the resulting scopes.
+// scopeCandidate is a sharing scope on the path of the target, with a flag
+// to know if the current instance is a member of the sharing.
+type scopeCandidate struct {
+	scope    SharingScope
+	isMember bool
+}
+
+// scopesFor returns the scopes that apply to the target: the scopes of the
+// active sharings on its path where the current instance is a member, below
+// the nearest limited_access boundary if there is one.
 func (r *AccessResolver) scopesFor(targetID string) ([]SharingScope, error) {
+	candidates, err := r.candidatesFor(targetID)
+	if err != nil {
+		return nil, err
+	}
+	return applicableScopes(candidates, nearestBoundary(candidates)), nil
+}
+
+// nearestBoundary returns the deepest limited_access scope of the candidates,
+// or nil if there is none. Membership doesn't matter here: a boundary
+// restricts the access even for the instances that are not a member of it.
+func nearestBoundary(candidates []scopeCandidate) *SharingScope {
+	var boundary *SharingScope
+	for i := range candidates {
+		sc := &candidates[i].scope
+		if sc.AccessMode != AccessModeLimitedAccess {
+			continue
+		}
+		if boundary == nil || len(sc.RootPath) > len(boundary.RootPath) {
+			cloned := *sc
+			boundary = &cloned
+		}
+	}
+	return boundary
+}
+
+// applicableScopes keeps the scopes where the current instance is a member,
+// and when there is a boundary, only those at or below this boundary.
+func applicableScopes(candidates []scopeCandidate, boundary *SharingScope) []SharingScope {
+	scopes := make([]SharingScope, 0, len(candidates))
+	for _, c := range candidates {
+		if !c.isMember {
+			continue
+		}
+		if boundary != nil && !isSameOrUnder(c.scope.RootPath, boundary.RootPath) {
+			continue
+		}
+		scopes = append(scopes, c.scope)
+	}
+	if len(scopes) == 0 {
+		return nil
+	}
+	return scopes
+}
+
+// isSameOrUnder returns true if p is the same path as root, or a path inside
+// root.
+func isSameOrUnder(p, root string) bool {
+	if p == root || root == "/" {
+		return true
+	}
+	return strings.HasPrefix(p, root+"/")
+}
+
+// candidatesFor loads the target, builds ancestor paths, finds shared roots
+// on the path, adds the target's own file share if any, bulk-loads sharings,
+// keeps the active ones, and returns them as candidates with the membership
+// of the current instance.
+func (r *AccessResolver) candidatesFor(targetID string) ([]scopeCandidate, error) {
 	fs := r.inst.VFS()
 
 	dir, file, err := fs.DirOrFileByID(targetID)
@@ -181,25 +263,38 @@ func (r *AccessResolver) scopesFor(targetID string) ([]SharingScope, error) {
 		return nil, err
 	}
 
-	scopes := make([]SharingScope, 0, len(sharings))
+	candidates := make([]scopeCandidate, 0, len(sharings))
 	for _, s := range sharings {
 		info, ok := rootBySharing[s.SID]
 		if !ok {
 			continue
 		}
-		member := s.MemberFor(r.inst)
-		if member == nil {
-			continue
+		member := r.memberFor(s)
+		readOnly := true
+		if member != nil {
+			readOnly = member.ReadOnly
 		}
-		scopes = append(scopes, SharingScope{
-			SharingID:  s.SID,
-			RootID:     info.RootID,
-			RootPath:   info.RootPath,
-			AccessMode: s.EffectiveAccessMode(),
-			ReadOnly:   member.ReadOnly,
+		candidates = append(candidates, scopeCandidate{
+			scope: SharingScope{
+				SharingID:  s.SID,
+				RootID:     info.RootID,
+				RootPath:   info.RootPath,
+				AccessMode: s.EffectiveAccessMode(),
+				ReadOnly:   readOnly,
+			},
+			isMember: member != nil,
 		})
 	}
-	return scopes, nil
+	return candidates, nil
+}
+
+// memberFor returns the member entry of the sharing for the perspective of
+// the resolver: the given member if there is one, or the current instance.
+func (r *AccessResolver) memberFor(s *Sharing) *Member {
+	if r.member != nil {
+		return s.MatchingMember(r.member)
+	}
+	return s.MemberFor(r.inst)
 }
 
 // ancestorPaths returns the directory paths to query for shared roots. When
@@ -228,9 +323,9 @@ func (r *AccessResolver) ancestorPaths(targetPath string, targetIsDir bool) []st
 	return paths
 }
 
-// loadSharings bulk-loads sharings by ID and keeps only the active additive
-// ones. limited_access sharings are dropped in v1. Membership filtering is
-// done by the caller (scopesFor) so this helper stays reusable.
+// loadSharings bulk-loads sharings by ID and keeps only the active ones.
+// Membership filtering is done by the caller (candidatesFor) so this helper
+// stays reusable.
 func (r *AccessResolver) loadSharings(ids []string) ([]*Sharing, error) {
 	sharings, err := FindSharings(r.inst, ids)
 	if err != nil {
@@ -244,22 +339,21 @@ func (r *AccessResolver) loadSharings(ids []string) ([]*Sharing, error) {
 		if !s.Active {
 			continue
 		}
-		if s.EffectiveAccessMode() != AccessModeAdditive {
-			continue
-		}
 		kept = append(kept, s)
 	}
 	return kept, nil
 }
 
 // NearestRestrictiveBoundary returns the closest limited_access boundary
-// applying to the target. v1 has no limited_access mode, so it always
-// returns nil.
-//
-// XXX: reserved for limited_access). v1 returns no boundary; upgrade path is
-// to walk ancestors and stop at the first limited_access scope.
+// applying to the target, by walking its ancestors (and the target itself),
+// or nil if there is none. ReadOnly is true when the current instance is not
+// a member of the boundary.
 func (r *AccessResolver) NearestRestrictiveBoundary(targetID string) (*SharingScope, error) {
-	return nil, nil
+	candidates, err := r.candidatesFor(targetID)
+	if err != nil {
+		return nil, err
+	}
+	return nearestBoundary(candidates), nil
 }
 
 // ChildSharedRootsUnder returns shared roots nested under the given folder.
@@ -104,7 +104,7 @@ func TestAccessResolver_SkipsInactive(t *testing.T) {
 	assert.Empty(t, scopes)
 }
 
-func TestAccessResolver_SkipsLimitedAccess(t *testing.T) {
+func TestAccessResolver_LimitedAccessBoundary(t *testing.T) {
 	if testing.Short() {
 		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
 	}
@@ -114,19 +114,64 @@ func TestAccessResolver_SkipsLimitedAccess(t *testing.T) {
 	inst := setup.GetTestInstance()
 	fs := inst.VFS()
 
-	tree := H{"parent/": H{"child/": H{}}}
+	tree := H{"parent/": H{"child/": H{"grandchild/": H{}}}}
 	parent := createTree(t, fs, tree, consts.RootDirID)
 	child, err := fs.DirByPath(parent.Fullpath + "/child")
 	require.NoError(t, err)
+	grandchild, err := fs.DirByPath(child.Fullpath + "/grandchild")
+	require.NoError(t, err)
 
-	s := createActiveDirSharing(t, inst, parent.ID())
-	s.AccessMode = AccessModeLimitedAccess
-	require.NoError(t, couchdb.UpdateDoc(inst, s))
+	sParent := createActiveDirSharing(t, inst, parent.ID())
+	sParent.Members = append(sParent.Members,
+		Member{Status: MemberStatusReady, Instance: "https://bob.cozy.tools"},
+		Member{Status: MemberStatusReady, Instance: "https://charlie.cozy.tools", ReadOnly: true},
+	)
+	require.NoError(t, couchdb.UpdateDoc(inst, sParent))
+	sChild := createActiveDirSharing(t, inst, child.ID())
+	sChild.AccessMode = AccessModeLimitedAccess
+	sChild.Members = append(sChild.Members,
+		Member{Status: MemberStatusReady, Instance: "https://charlie.cozy.tools"},
+	)
+	require.NoError(t, couchdb.UpdateDoc(inst, sChild))
 
+	// The owner is a member of the boundary
 	resolver := NewAccessResolver(inst)
-	scopes, err := resolver.scopesFor(child.ID())
+	b, err := resolver.NearestRestrictiveBoundary(grandchild.ID())
 	require.NoError(t, err)
-	assert.Empty(t, scopes)
+	require.NotNil(t, b)
+	assert.Equal(t, sChild.SID, b.SharingID)
+	assert.Equal(t, child.Fullpath, b.RootPath)
+	scopes, err := resolver.scopesFor(grandchild.ID())
+	require.NoError(t, err)
+	require.Len(t, scopes, 1)
+	assert.Equal(t, sChild.SID, scopes[0].SharingID)
+
+	// Bob is only a member of the parent drive
+	bob := &Member{Instance: "https://bob.cozy.tools"}
+	ea, err := NewAccessResolverForMember(inst, bob).Resolve(grandchild.ID())
+	require.NoError(t, err)
+	assert.False(t, ea.CanRead)
+	assert.False(t, ea.CanWrite)
+	require.NotNil(t, ea.Boundary)
+	ea, err = NewAccessResolverForMember(inst, bob).Resolve(parent.ID())
+	require.NoError(t, err)
+	assert.True(t, ea.CanRead)
+	assert.True(t, ea.CanWrite)
+	assert.Nil(t, ea.Boundary)
+
+	// Charlie is read-only on the parent drive, but can write in the boundary
+	charlie := &Member{Instance: "https://charlie.cozy.tools"}
+	ea, err = NewAccessResolverForMember(inst, charlie).Resolve(child.ID())
+	require.NoError(t, err)
+	assert.True(t, ea.CanRead)
+	assert.True(t, ea.CanWrite)
+	assert.Equal(t, []string{sChild.SID}, ea.SourceSharingIDs)
+
+	roots, err := LimitedAccessRootsUnder(inst, parent.Fullpath, sParent.SID)
+	require.NoError(t, err)
+	assert.False(t, roots.Allows(grandchild.Fullpath, bob))
+	assert.True(t, roots.Allows(grandchild.Fullpath, charlie))
+	assert.True(t, roots.Allows(parent.Fullpath+"/other", bob))
 }
 
 func TestAccessResolver_FileTargetWithOwnShare(t *testing.T) {
@@ -227,7 +272,7 @@ func TestAccessResolver_NotFound(t *testing.T) {
 	assert.Nil(t, ea)
 }
 
-func TestAccessResolver_NearestRestrictiveBoundaryStub(t *testing.T) {
+func TestAccessResolver_NoRestrictiveBoundary(t *testing.T) {
 	if testing.Short() {
 		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
 	}
@@ -235,9 +280,16 @@ func TestAccessResolver_NearestRestrictiveBoundaryStub(t *testing.T) {
 	testutils.NeedCouchdb(t)
 	setup := testutils.NewSetup(t, t.Name())
 	inst := setup.GetTestInstance()
+	fs := inst.VFS()
+
+	tree := H{"parent/": H{"child/": H{}}}
+	parent := createTree(t, fs, tree, consts.RootDirID)
+	child, err := fs.DirByPath(parent.Fullpath + "/child")
+	require.NoError(t, err)
+	createActiveDirSharing(t, inst, parent.ID())
 
 	resolver := NewAccessResolver(inst)
-	b, err := resolver.NearestRestrictiveBoundary("whatever")
+	b, err := resolver.NearestRestrictiveBoundary(child.ID())
 	require.NoError(t, err)
 	assert.Nil(t, b)
 
@@ -79,4 +79,16 @@ var (
 	ErrFileInTrash = errors.New("Cannot share trashed file")
 	// ErrSystemFolder is used when trying to share a system folder
 	ErrSystemFolder = errors.New("Cannot share system folder")
+	// ErrInvalidAccessMode is used when the access mode of a sharing is not
+	// additive or limited_access
+	ErrInvalidAccessMode = errors.New("Invalid access mode")
+	// ErrNoParentDrive is used when a limited_access drive is created on a
+	// folder that is not inside a shared drive
+	ErrNoParentDrive = errors.New("A limited access folder must be inside a shared drive")
+	// ErrLimitedAccessDenied is used when a member of a shared drive tries to
+	// access a file inside a limited_access folder where they are not invited
+	ErrLimitedAccessDenied = errors.New("Access denied by a limited access folder")
+	// ErrInvalidExpiration is used when the expiration date of a sharing or
+	// of a member is not in the future
+	ErrInvalidExpiration = errors.New("The expiration date must be in the future")
 )
@@ -0,0 +1,317 @@
+package sharing
+
+import (
+	"html"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/audit"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// ExpirationWorker is the type of the worker that warns about the sharings
+// that will expire soon, and revokes the members when their access has
+// expired.
+const ExpirationWorker = "share-expire"
+
+// ExpirationWarningDelay is how long before the expiration the owner and the
+// recipient are warned.
+const ExpirationWarningDelay = 7 * 24 * time.Hour
+
+// MemberExpiresAt returns when the access of the member at the given index
+// expires: the earliest date between the expiration of the sharing and the
+// one of the member. It returns nil if the access doesn't expire.
+func (s *Sharing) MemberExpiresAt(index int) *time.Time {
+	if index <= 0 || index >= len(s.Members) {
+		return nil
+	}
+	at := s.Members[index].ExpiresAt
+	if s.ExpiresAt != nil && (at == nil || s.ExpiresAt.Before(*at)) {
+		at = s.ExpiresAt
+	}
+	return at
+}
+
+// SetExpiresAt changes the expiration date of the whole sharing. A nil date
+// removes the expiration.
+func (s *Sharing) SetExpiresAt(at *time.Time) error {
+	if !s.Owner {
+		return ErrInvalidSharing
+	}
+	if at != nil && !at.After(time.Now()) {
+		return ErrInvalidExpiration
+	}
+	before := s.expirationDates()
+	s.ExpiresAt = at
+	s.resetExpiryWarnings(before)
+	return nil
+}
+
+// SetMemberExpiresAt changes the expiration date of the access of the member
+// at the given index. A nil date removes the expiration.
+func (s *Sharing) SetMemberExpiresAt(index int, at *time.Time) error {
+	if !s.Owner {
+		return ErrInvalidSharing
+	}
+	if index <= 0 || index >= len(s.Members) {
+		return ErrMemberNotFound
+	}
+	if at != nil && !at.After(time.Now()) {
+		return ErrInvalidExpiration
+	}
+	before := s.expirationDates()
+	s.Members[index].ExpiresAt = at
+	s.resetExpiryWarnings(before)
+	return nil
+}
+
+// SaveExpiration persists the expiration dates of the sharing, and makes sure
+// that the triggers for the expirations exist.
+func (s *Sharing) SaveExpiration(inst *instance.Instance) error {
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		return err
+	}
+	if s.hasExpiration() {
+		s.ensureExpirationTriggers(inst)
+	}
+	return nil
+}
+
+// expirationDates returns the effective expiration date of each member.
+func (s *Sharing) expirationDates() []*time.Time {
+	dates := make([]*time.Time, len(s.Members))
+	for i := range s.Members {
+		dates[i] = s.MemberExpiresAt(i)
+	}
+	return dates
+}
+
+// resetExpiryWarnings allows to warn again the members whose expiration date
+// has changed, as the previous warning is no longer accurate.
+func (s *Sharing) resetExpiryWarnings(before []*time.Time) {
+	for i := range s.Members {
+		if i < len(before) && sameExpiration(before[i], s.MemberExpiresAt(i)) {
+			continue
+		}
+		s.Members[i].ExpiryWarned = false
+	}
+}
+
+func (s *Sharing) hasExpiration() bool {
+	if s.ExpiresAt != nil {
+		return true
+	}
+	for _, m := range s.Members {
+		if m.ExpiresAt != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func sameExpiration(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// ensureExpirationTriggers creates the @daily trigger that checks the
+// expiration of the sharings, if it doesn't exist yet, and an @at trigger for
+// each expiration date of the sharing: the members are then revoked as soon
+// as their access has expired, and not on the next daily check.
+func (s *Sharing) ensureExpirationTriggers(inst *instance.Instance) {
+	addExpirationTrigger(inst, job.TriggerInfos{
+		Type:       "@daily",
+		WorkerType: ExpirationWorker,
+		Arguments:  "between 2am and 5am",
+	})
+	for _, at := range s.expirationTriggerDates(time.Now()) {
+		addExpirationTrigger(inst, job.TriggerInfos{
+			Type:       "@at",
+			WorkerType: ExpirationWorker,
+			Arguments:  at,
+		})
+	}
+}
+
+// expirationTriggerDates returns the future expiration dates of the members
+// that have not been revoked, on the owner side, in the format of the @at triggers. The dates
+// are rounded up to the second, as the format of the triggers has no
+// fraction of second and the revocation must not be tried too early.
+func (s *Sharing) expirationTriggerDates(now time.Time) []string {
+	if !s.Owner {
+		return nil
+	}
+	var dates []string
+	seen := make(map[string]bool)
+	for i := 1; i < len(s.Members); i++ {
+		if s.Members[i].Status == MemberStatusRevoked {
+			continue
+		}
+		at := s.MemberExpiresAt(i)
+		if at == nil || !at.After(now) {
+			continue
+		}
+		rounded := at.UTC().Truncate(time.Second)
+		if rounded.Before(*at) {
+			rounded = rounded.Add(time.Second)
+		}
+		date := rounded.Format(time.RFC3339)
+		if !seen[date] {
+			seen[date] = true
+			dates = append(dates, date)
+		}
+	}
+	return dates
+}
+
+func addExpirationTrigger(inst *instance.Instance, infos job.TriggerInfos) {
+	sched := job.System()
+	if sched.HasTrigger(inst, infos) {
+		return
+	}
+	trigger, err := job.NewTrigger(inst, infos, nil)
+	if err != nil {
+		inst.Logger().WithNamespace("sharing").
+			Errorf("Cannot create the %s trigger: %s", ExpirationWorker, err)
+		return
+	}
+	if err := sched.AddTrigger(trigger); err != nil {
+		inst.Logger().WithNamespace("sharing").
+			Errorf("Cannot create the %s trigger: %s", ExpirationWorker, err)
+	}
+}
+
+// CheckExpirations is called by the share-expire worker. On the owner side,
+// it revokes the members whose access has expired and warns the owner of the
+// next expirations. On the recipient side, it warns the user that their
+// access will expire soon.
+func CheckExpirations(inst *instance.Instance, now time.Time) error {
+	sharings, err := FindActive(inst)
+	if err != nil {
+		return err
+	}
+	var errm error
+	for _, s := range sharings {
+		if s.Owner {
+			err = s.checkOwnerExpirations(inst, now)
+		} else {
+			err = s.checkRecipientExpiration(inst, now)
+		}
+		if err != nil {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	return errm
+}
+
+func (s *Sharing) checkOwnerExpirations(inst *instance.Instance, now time.Time) error {
+	var errm error
+	revoked, warned := false, false
+	for i := 1; i < len(s.Members); i++ {
+		m := &s.Members[i]
+		if m.Status == MemberStatusRevoked {
+			continue
+		}
+		at := s.MemberExpiresAt(i)
+		if at == nil {
+			continue
+		}
+		if !at.After(now) {
+			member := *m
+			if err := s.RevokeRecipient(inst, i); err != nil {
+				errm = multierror.Append(errm, err)
+				continue
+			}
+			details := expirationAuditDetails(s, &member)
+			audit.Log(inst, &audit.Entry{
+				Event:   audit.SharingMemberRevoked,
+				Actor:   audit.Actor{Kind: audit.ActorSystem},
+				Details: details,
+			})
+			revoked = true
+			continue
+		}
+		if !m.ExpiryWarned && at.Sub(now) <= ExpirationWarningDelay {
+			if err := sendExpirationWarning(inst, s, m.PrimaryName(), *at); err != nil {
+				errm = multierror.Append(errm, err)
+				continue
+			}
+			m.ExpiryWarned = true
+			warned = true
+		}
+	}
+	if warned {
+		if err := couchdb.UpdateDoc(inst, s); err != nil {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	if revoked {
+		s.NotifyRecipients(inst, nil)
+	}
+	return errm
+}
+
+func (s *Sharing) checkRecipientExpiration(inst *instance.Instance, now time.Time) error {
+	m := s.MemberFor(inst)
+	if m == nil || m.ExpiresAt == nil || m.ExpiryWarned {
+		return nil
+	}
+	if !m.ExpiresAt.After(now) || m.ExpiresAt.Sub(now) > ExpirationWarningDelay {
+		return nil
+	}
+	if err := sendExpirationWarning(inst, s, "", *m.ExpiresAt); err != nil {
+		return err
+	}
+	m.ExpiryWarned = true
+	return couchdb.UpdateDoc(inst, s)
+}
+
+func expirationAuditDetails(s *Sharing, m *Member) map[string]interface{} {
+	details := map[string]interface{}{
+		"sharing_id":  s.SID,
+		"description": s.Description,
+		"member_name": m.PrimaryName(),
+		"read_only":   m.ReadOnly,
+		"reason":      "expired",
+	}
+	if m.Email != "" {
+		details["member_email"] = m.Email
+	}
+	if m.Instance != "" {
+		details["member_instance"] = m.Instance
+	}
+	return details
+}
+
+// sendExpirationWarning pushes a notification to warn that an access to the
+// sharing will expire. The member name is empty when the warning is for the
+// recipient about their own access.
+func sendExpirationWarning(inst *instance.Instance, s *Sharing, memberName string, at time.Time) error {
+	date := at.Format("2006-01-02")
+	title := inst.Translate("Sharing Expiration Title", s.Description)
+	var message string
+	if memberName == "" {
+		message = inst.Translate("Sharing Expiration Recipient Message", s.Description, date)
+	} else {
+		message = inst.Translate("Sharing Expiration Owner Message", memberName, s.Description, date)
+	}
+	n := &notification.Notification{
+		Title:       title,
+		Message:     message,
+		Content:     message,
+		ContentHTML: "<p>" + html.EscapeString(message) + "</p>",
+		Slug:        s.AppSlug,
+		Data: map[string]interface{}{
+			"sharing_id": s.SID,
+			"expires_at": at,
+		},
+		PreferredChannels: []string{"mobile"},
+	}
+	return center.PushStack(inst.DomainName(), center.NotificationSharingExpiration, n)
+}
@@ -0,0 +1,84 @@
+package sharing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemberExpiresAt(t *testing.T) {
+	soon := time.Now().Add(24 * time.Hour)
+	later := time.Now().Add(30 * 24 * time.Hour)
+	s := &Sharing{Owner: true, Members: []Member{
+		{Status: MemberStatusOwner},
+		{Status: MemberStatusReady},
+		{Status: MemberStatusReady, ExpiresAt: &soon},
+	}}
+
+	assert.Nil(t, s.MemberExpiresAt(0))
+	assert.Nil(t, s.MemberExpiresAt(1))
+	assert.Equal(t, &soon, s.MemberExpiresAt(2))
+	assert.Nil(t, s.MemberExpiresAt(3))
+
+	s.ExpiresAt = &later
+	assert.Equal(t, &later, s.MemberExpiresAt(1))
+	assert.Equal(t, &soon, s.MemberExpiresAt(2))
+}
+
+func TestSetExpiration(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	soon := time.Now().Add(24 * time.Hour)
+	later := time.Now().Add(30 * 24 * time.Hour)
+	s := &Sharing{Owner: true, Members: []Member{
+		{Status: MemberStatusOwner},
+		{Status: MemberStatusReady, ExpiresAt: &soon, ExpiryWarned: true},
+		{Status: MemberStatusReady, ExpiryWarned: true},
+	}}
+
+	assert.Equal(t, ErrInvalidExpiration, s.SetExpiresAt(&past))
+	assert.Equal(t, ErrInvalidExpiration, s.SetMemberExpiresAt(1, &past))
+	assert.Equal(t, ErrMemberNotFound, s.SetMemberExpiresAt(0, &later))
+	assert.Equal(t, ErrMemberNotFound, s.SetMemberExpiresAt(3, &later))
+
+	// The first member keeps the earliest date, so their warning is still valid
+	require.NoError(t, s.SetExpiresAt(&later))
+	assert.True(t, s.Members[1].ExpiryWarned)
+	assert.False(t, s.Members[2].ExpiryWarned)
+
+	// Extending the access of the first member allows to warn them again
+	s.Members[2].ExpiryWarned = true
+	require.NoError(t, s.SetMemberExpiresAt(1, nil))
+	assert.Nil(t, s.Members[1].ExpiresAt)
+	assert.False(t, s.Members[1].ExpiryWarned)
+	assert.True(t, s.Members[2].ExpiryWarned)
+
+	recipient := &Sharing{Members: []Member{{Status: MemberStatusOwner}, {}}}
+	assert.Equal(t, ErrInvalidSharing, recipient.SetExpiresAt(&later))
+}
+
+func TestExpirationTriggerDates(t *testing.T) {
+	now := time.Date(2024, 3, 11, 10, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	soon := now.Add(24*time.Hour + 500*time.Millisecond)
+	later := now.Add(30 * 24 * time.Hour)
+	s := &Sharing{Owner: true, ExpiresAt: &later, Members: []Member{
+		{Status: MemberStatusOwner},
+		{Status: MemberStatusReady, ExpiresAt: &soon},
+		{Status: MemberStatusReady},
+		{Status: MemberStatusMailNotSent},
+		{Status: MemberStatusRevoked, ExpiresAt: &soon},
+		{Status: MemberStatusReady, ExpiresAt: &past},
+	}}
+
+	// The dates are rounded up to the second, so that the member is revoked
+	// when the trigger fires
+	assert.Equal(t, []string{
+		"2024-03-12T10:00:01Z",
+		"2024-04-10T10:00:00Z",
+	}, s.expirationTriggerDates(now))
+
+	s.Owner = false
+	assert.Empty(t, s.expirationTriggerDates(now))
+}
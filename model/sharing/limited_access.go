@@ -0,0 +1,316 @@
+package sharing
+
+import (
+	"os"
+	"path"
+	"sort"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+)
+
+// IsValidAccessMode returns true if the access mode can be used for a
+// sharing (an empty string means additive).
+func IsValidAccessMode(mode string) bool {
+	switch mode {
+	case "", AccessModeAdditive, AccessModeLimitedAccess:
+		return true
+	}
+	return false
+}
+
+// CreateLimitedAccessDrive creates a new shared drive for a folder inside an
+// existing shared drive of the instance. This folder becomes a restrictive
+// boundary: only the members of the new sharing (and the owner) can access
+// it, even if they are members of the parent drive.
+func CreateLimitedAccessDrive(inst *instance.Instance, rootID, description, appSlug string) (*Sharing, error) {
+	dir, err := ValidateLimitedAccessRoot(inst, rootID)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Sharing{
+		Drive:         true,
+		DriveRootType: DriveRootTypeDirectory,
+		AccessMode:    AccessModeLimitedAccess,
+		Description:   description,
+		Rules: []Rule{{
+			Title:   dir.DocName,
+			DocType: consts.Files,
+			Values:  []string{rootID},
+			Add:     ActionRuleNone,
+			Update:  ActionRuleNone,
+			Remove:  ActionRuleNone,
+		}},
+	}
+	if s.Description == "" {
+		s.Description = dir.DocName
+	}
+	if err := s.BeOwner(inst, appSlug); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ValidateLimitedAccessRoot checks that the folder can be the root of a
+// limited_access drive: it must be a folder that is not shared yet, inside a
+// shared drive owned by the instance.
+func ValidateLimitedAccessRoot(inst *instance.Instance, rootID string) (*vfs.DirDoc, error) {
+	if rootID == consts.RootDirID ||
+		rootID == consts.TrashDirID ||
+		rootID == consts.SharedWithMeDirID ||
+		rootID == consts.NoLongerSharedDirID ||
+		rootID == consts.SharedDrivesDirID {
+		return nil, ErrSystemFolder
+	}
+
+	fs := inst.VFS()
+	dir, file, err := fs.DirOrFileByID(rootID)
+	if err != nil {
+		return nil, ErrDriveRootNotFound
+	}
+	if file != nil {
+		return nil, ErrNotADirectory
+	}
+	if dir == nil {
+		return nil, ErrDriveRootNotFound
+	}
+	if isSameOrUnder(dir.Fullpath, vfs.TrashDirName) {
+		return nil, ErrSystemFolder
+	}
+	if err := checkRootForSharing(dir.ReferencedBy, ErrFolderAlreadyShared); err != nil {
+		return nil, err
+	}
+	if err := checkDescendantsForSharing(fs, dir); err != nil {
+		return nil, err
+	}
+	parent, err := findParentDrive(inst, path.Dir(dir.Fullpath))
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, ErrNoParentDrive
+	}
+	return dir, nil
+}
+
+// findParentDrive returns the active shared drive owned by the instance that
+// contains the given path, or nil if there is none.
+func findParentDrive(inst *instance.Instance, dirPath string) (*Sharing, error) {
+	var ids []string
+	fs := inst.VFS()
+	for current := dirPath; current != "/" && current != "." && current != ""; current = path.Dir(current) {
+		dir, err := fs.DirByPath(current)
+		if err != nil {
+			break
+		}
+		for _, ref := range dir.ReferencedBy {
+			if ref.Type == consts.Sharings {
+				ids = append(ids, ref.ID)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	sharings, err := FindSharings(inst, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sharings {
+		if s != nil && s.Drive && s.Owner && s.Active && !s.HasFileDriveRoot() {
+			return s, nil
+		}
+	}
+	return nil, nil
+}
+
+// RestrictedTree is the list of the limited_access boundaries inside a
+// folder. It is used to filter the documents of this folder that a member
+// can see, for the listings, the changes feeds and the replications.
+type RestrictedTree struct {
+	roots []restrictedRoot // the deepest first
+}
+
+type restrictedRoot struct {
+	path    string
+	sharing *Sharing
+}
+
+// limitedAccessRootsPageSize is the number of shared folders fetched per
+// request when looking for the limited_access boundaries.
+const limitedAccessRootsPageSize = 1000
+
+// LimitedAccessRootsUnder returns the limited_access boundaries for active
+// sharings that are inside the given folder (the folder itself is excluded).
+// The boundaries for the sharing with the exceptSharingID identifier are
+// ignored.
+func LimitedAccessRootsUnder(inst *instance.Instance, dirPath, exceptSharingID string) (*RestrictedTree, error) {
+	type sharedRootDoc struct {
+		Path         string                 `json:"path"`
+		ReferencedBy []couchdb.DocReference `json:"referenced_by"`
+	}
+	prefix := dirPath + "/"
+	if dirPath == "/" {
+		prefix = "/"
+	}
+	pathBySharing := make(map[string]string)
+	bookmark := ""
+	for {
+		var docs []sharedRootDoc
+		req := &couchdb.FindRequest{
+			UseIndex: "dir-by-path",
+			Selector: mango.And(
+				mango.StartWith("path", prefix),
+				mango.Equal("type", consts.DirType),
+				mango.Exists(couchdb.SelectorReferencedBy),
+			),
+			Fields:   []string{"path", "referenced_by"},
+			Limit:    limitedAccessRootsPageSize,
+			Bookmark: bookmark,
+		}
+		res, err := couchdb.FindDocsRaw(inst, consts.Files, req, &docs)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			for _, ref := range doc.ReferencedBy {
+				if ref.Type == consts.Sharings && ref.ID != exceptSharingID {
+					pathBySharing[ref.ID] = doc.Path
+				}
+			}
+		}
+		if len(docs) < limitedAccessRootsPageSize || res.Bookmark == "" {
+			break
+		}
+		bookmark = res.Bookmark
+	}
+	tree := &RestrictedTree{}
+	if len(pathBySharing) == 0 {
+		return tree, nil
+	}
+	ids := make([]string, 0, len(pathBySharing))
+	for id := range pathBySharing {
+		ids = append(ids, id)
+	}
+	sharings, err := FindSharings(inst, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range sharings {
+		if s == nil || !s.Active || s.EffectiveAccessMode() != AccessModeLimitedAccess {
+			continue
+		}
+		tree.roots = append(tree.roots, restrictedRoot{path: pathBySharing[s.SID], sharing: s})
+	}
+	sort.Slice(tree.roots, func(i, j int) bool {
+		return len(tree.roots[i].path) > len(tree.roots[j].path)
+	})
+	return tree, nil
+}
+
+// IsEmpty returns true if there is no boundary in the tree.
+func (t *RestrictedTree) IsEmpty() bool {
+	return t == nil || len(t.roots) == 0
+}
+
+// Allows returns true if the member can see the document with the given
+// path: the document is not inside a boundary, or the member is also a
+// member of the sharing of the nearest boundary.
+func (t *RestrictedTree) Allows(docPath string, m *Member) bool {
+	if t.IsEmpty() {
+		return true
+	}
+	for _, root := range t.roots {
+		if isSameOrUnder(docPath, root.path) {
+			return root.sharing.MatchingMember(m) != nil
+		}
+	}
+	return true
+}
+
+// AllowsAll returns true if the member can see everything in the tree: the
+// member is invited in the sharings of all the boundaries.
+func (t *RestrictedTree) AllowsAll(m *Member) bool {
+	if t.IsEmpty() {
+		return true
+	}
+	for _, root := range t.roots {
+		if root.sharing.MatchingMember(m) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// restrictedTree returns the limited_access boundaries inside the folder of
+// the sharing, or nil for a sharing that is not on a folder. The boundaries
+// are enforced by the owner, so it is also nil on a recipient.
+func (s *Sharing) restrictedTree(inst *instance.Instance) (*RestrictedTree, error) {
+	if !s.Owner {
+		return nil, nil
+	}
+	rule := s.FirstFilesRule()
+	if rule == nil || rule.Mime != "" || !rule.FilesByID() || len(rule.Values) == 0 {
+		return nil, nil
+	}
+	dir, err := inst.VFS().DirByID(rule.Values[0])
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return LimitedAccessRootsUnder(inst, dir.Fullpath, s.SID)
+}
+
+// filterRestrictedDocs removes from the documents to send to the member the
+// files and folders that are inside a limited_access boundary where the
+// member is not invited. A document whose path can't be known is removed
+// too, as it may be inside a boundary.
+func (s *Sharing) filterRestrictedDocs(inst *instance.Instance, m *Member, docsByDoctype *DocsByDoctype) error {
+	docs, ok := (*docsByDoctype)[consts.Files]
+	if !ok || len(docs) == 0 {
+		return nil
+	}
+	tree, err := s.restrictedTree(inst)
+	if err != nil || tree.IsEmpty() {
+		return err
+	}
+	fp := vfs.NewFilePatherWithCache(inst.VFS())
+	kept := docs[:0]
+	for _, doc := range docs {
+		if deleted, _ := doc["_deleted"].(bool); deleted {
+			kept = append(kept, doc)
+			continue
+		}
+		docPath, ok := restrictedDocPath(fp, doc)
+		if !ok || !tree.Allows(docPath, m) {
+			continue
+		}
+		kept = append(kept, doc)
+	}
+	(*docsByDoctype)[consts.Files] = kept
+	return nil
+}
+
+// restrictedDocPath returns the path of a raw file or directory document.
+func restrictedDocPath(fp vfs.FilePather, doc map[string]interface{}) (string, bool) {
+	if doc["type"] == consts.DirType {
+		p, ok := doc["path"].(string)
+		return p, ok
+	}
+	dirID, _ := doc["dir_id"].(string)
+	name, _ := doc["name"].(string)
+	if dirID == "" {
+		return "", false
+	}
+	p, err := fp.FilePath(&vfs.FileDoc{DirID: dirID, DocName: name})
+	if err != nil {
+		return "", false
+	}
+	return p, true
+}
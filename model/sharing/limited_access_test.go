@@ -0,0 +1,50 @@
+package sharing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsValidAccessMode(t *testing.T) {
+	assert.True(t, IsValidAccessMode(""))
+	assert.True(t, IsValidAccessMode(AccessModeAdditive))
+	assert.True(t, IsValidAccessMode(AccessModeLimitedAccess))
+	assert.False(t, IsValidAccessMode("restricted"))
+}
+
+func TestRestrictedTreeAllows(t *testing.T) {
+	outer := &Sharing{SID: "outer", Members: []Member{
+		{Status: MemberStatusOwner, Instance: "https://alice.cozy.tools"},
+		{Status: MemberStatusReady, Instance: "https://bob.cozy.tools"},
+	}}
+	inner := &Sharing{SID: "inner", Members: []Member{
+		{Status: MemberStatusOwner, Instance: "https://alice.cozy.tools"},
+		{Status: MemberStatusReady, Instance: "https://charlie.cozy.tools"},
+		{Status: MemberStatusRevoked, Instance: "https://bob.cozy.tools"},
+	}}
+	tree := &RestrictedTree{roots: []restrictedRoot{
+		{path: "/Drive/Outer/Inner", sharing: inner},
+		{path: "/Drive/Outer", sharing: outer},
+	}}
+	alice := &Member{Instance: "https://alice.cozy.tools"}
+	bob := &Member{Instance: "https://bob.cozy.tools"}
+	charlie := &Member{Email: "charlie@cozy.tools", Instance: "https://charlie.cozy.tools"}
+
+	assert.True(t, tree.Allows("/Drive/Other", bob))
+	assert.True(t, tree.Allows("/Drive/OuterFile.txt", charlie))
+	assert.True(t, tree.Allows("/Drive/Outer/file.txt", bob))
+	assert.False(t, tree.Allows("/Drive/Outer/file.txt", charlie))
+	assert.False(t, tree.Allows("/Drive/Outer/Inner", bob))
+	assert.True(t, tree.Allows("/Drive/Outer/Inner/file.txt", charlie))
+	assert.True(t, tree.Allows("/Drive/Outer/Inner/file.txt", alice))
+
+	assert.True(t, tree.AllowsAll(alice))
+	assert.False(t, tree.AllowsAll(bob))
+	assert.False(t, tree.AllowsAll(charlie))
+
+	var empty *RestrictedTree
+	assert.True(t, empty.IsEmpty())
+	assert.True(t, empty.Allows("/Drive/Outer", bob))
+	assert.True(t, empty.AllowsAll(bob))
+}
@@ -60,14 +60,16 @@ func maxNumberOfMembers(inst *instance.Instance) int {
 
 // Member contains the information about a recipient (or the sharer) for a sharing
 type Member struct {
-	Status       string `json:"status"`
-	Name         string `json:"name,omitempty"`
-	PublicName   string `json:"public_name,omitempty"`
-	Email        string `json:"email,omitempty"`
-	Instance     string `json:"instance,omitempty"`
-	ReadOnly     bool   `json:"read_only,omitempty"`
-	OnlyInGroups bool   `json:"only_in_groups,omitempty"` // False if the member has been added as an io.cozy.contacts
-	Groups       []int  `json:"groups,omitempty"`         // The indexes of the groups a member is part of
+	Status       string     `json:"status"`
+	Name         string     `json:"name,omitempty"`
+	PublicName   string     `json:"public_name,omitempty"`
+	Email        string     `json:"email,omitempty"`
+	Instance     string     `json:"instance,omitempty"`
+	ReadOnly     bool       `json:"read_only,omitempty"`
+	OnlyInGroups bool       `json:"only_in_groups,omitempty"` // False if the member has been added as an io.cozy.contacts
+	Groups       []int      `json:"groups,omitempty"`         // The indexes of the groups a member is part of
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+	ExpiryWarned bool       `json:"expiry_warned,omitempty"` // True when the expiration warning has been sent
 }
 
 // PrimaryName returns the main name of this member
@@ -591,9 +593,19 @@ func (s *Sharing) UpdateRecipients(inst *instance.Instance, params PutRecipients
 		s.Members[i].PublicName = m.PublicName
 		s.Members[i].Status = m.Status
 		s.Members[i].ReadOnly = m.ReadOnly
+		if !sameExpiration(s.Members[i].ExpiresAt, m.ExpiresAt) {
+			s.Members[i].ExpiresAt = m.ExpiresAt
+			s.Members[i].ExpiryWarned = false
+		}
 	}
 	s.Groups = params.Groups
-	return couchdb.UpdateDoc(inst, s)
+	if err := couchdb.UpdateDoc(inst, s); err != nil {
+		return err
+	}
+	if s.hasExpiration() {
+		s.ensureExpirationTriggers(inst)
+	}
+	return nil
 }
 
 // PersistInstanceURL updates the io.cozy.contacts document with the Cozy
@@ -664,6 +676,29 @@ func (s *Sharing) MemberFor(inst *instance.Instance) *Member {
 	return nil
 }
 
+// MatchingMember returns the member entry of the sharing for the same person
+// as the given member of another sharing, by comparing their Cozy addresses
+// and their email addresses. Revoked members are not returned.
+func (s *Sharing) MatchingMember(other *Member) *Member {
+	if other == nil {
+		return nil
+	}
+	otherHost := other.InstanceHost()
+	for i := range s.Members {
+		m := &s.Members[i]
+		if m.Status == MemberStatusRevoked {
+			continue
+		}
+		if otherHost != "" && m.InstanceHost() == otherHost {
+			return m
+		}
+		if other.Email != "" && m.Email == other.Email {
+			return m
+		}
+	}
+	return nil
+}
+
 // FindMemberBySharecode returns the member that is linked to the sharing by
 // the given sharecode
 func (s *Sharing) FindMemberBySharecode(db prefixer.Prefixer, sharecode string) (*Member, error) {
@@ -1389,6 +1424,7 @@ func (s *Sharing) NotifyRecipients(inst *instance.Instance, except *Member) {
 			PublicName: m.PublicName,
 			Email:      m.Email,
 			ReadOnly:   m.ReadOnly,
+			ExpiresAt:  s.MemberExpiresAt(i),
 			// Instance and name are private
 		}
 	}
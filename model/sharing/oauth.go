@@ -78,6 +78,7 @@ func (m *Member) CreateSharingRequest(inst *instance.Instance, s *Sharing, c *Cr
 			ReadOnly:     m.ReadOnly,
 			OnlyInGroups: m.OnlyInGroups,
 			Groups:       m.Groups,
+			ExpiresAt:    s.MemberExpiresAt(i),
 		}
 		// ... except for the sharer and the recipient of this request
 		if i == 0 || &s.Credentials[i-1] == c {
@@ -114,6 +115,7 @@ func (m *Member) CreateSharingRequest(inst *instance.Instance, s *Sharing, c *Cr
 			PreviewPath:   s.PreviewPath,
 			CreatedAt:     s.CreatedAt,
 			UpdatedAt:     s.UpdatedAt,
+			ExpiresAt:     s.ExpiresAt,
 			Rules:         rules,
 			Members:       members,
 			Groups:        s.Groups,
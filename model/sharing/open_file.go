@@ -124,6 +124,15 @@ func (o *FileOpener) CheckPermission(pdoc *permission.Permission, sharingID stri
 		}
 	}
 
+	// A member of a shared drive can't open a file inside a limited_access
+	// folder where they are not invited.
+	if pdoc.Type == permission.TypeShareInteract && o.Sharing != nil &&
+		o.Sharing.Drive && o.Sharing.Owner {
+		if err := o.checkLimitedAccess(); err != nil {
+			return err
+		}
+	}
+
 	// If a file is opened via a token for cozy-to-cozy sharing, then the file
 	// must be in this sharing, or the stack should refuse to open the file.
 	if sharingID != "" && o.Sharing != nil && o.Sharing.ID() == sharingID {
@@ -135,6 +144,21 @@ func (o *FileOpener) CheckPermission(pdoc *permission.Permission, sharingID stri
 	return vfs.Allows(fs, pdoc.Permissions, permission.GET, o.File)
 }
 
+func (o *FileOpener) checkLimitedAccess() error {
+	member, err := o.Sharing.FindMemberByInteractCode(o.Inst, o.Code)
+	if err != nil || member == nil {
+		return ErrMemberNotFound
+	}
+	ea, err := NewAccessResolverForMember(o.Inst, member).Resolve(o.File.ID())
+	if err != nil {
+		return err
+	}
+	if ea.Boundary != nil && !ea.CanRead {
+		return ErrLimitedAccessDenied
+	}
+	return nil
+}
+
 // ShouldOpenLocally returns true if the file can be opened in the current
 // instance, and false if it is a shared file created on another instance.
 func (o *FileOpener) ShouldOpenLocally() bool {
@@ -218,6 +218,9 @@ func (s *Sharing) ReplicateTo(inst *instance.Instance, m *Member, initial bool)
 		if errb != nil {
 			return false, errb
 		}
+		if errb = s.filterRestrictedDocs(inst, m, docs); errb != nil {
+			return false, errb
+		}
 		inst.Logger().WithNamespace("replicator").Debugf("docs = %#v", docs)
 
 		err = s.sendBulkDocs(inst, m, creds, docs, feed.RuleIndexes)
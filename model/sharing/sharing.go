@@ -49,9 +49,9 @@ const (
 	// AccessModeAdditive is the default access mode for nested shared folders:
 	// parent access applies to children, and child scopes can add access.
 	AccessModeAdditive = "additive"
-	// AccessModeLimitedAccess is reserved for a future feature where a child
-	// folder becomes a restrictive boundary. It is stored but not activated
-	// in v1.
+	// AccessModeLimitedAccess is for a folder inside a shared drive that is a
+	// restrictive boundary: only the members of its own sharing can access
+	// it, the access given by the parent drive stops there.
 	AccessModeLimitedAccess = "limited_access"
 )
 
@@ -68,23 +68,24 @@ type Sharing struct {
 	SID  string `json:"_id,omitempty"`
 	SRev string `json:"_rev,omitempty"`
 
-	Triggers      Triggers  `json:"triggers"`
-	Drive         bool      `json:"drive,omitempty"`
-	DriveRootType string    `json:"drive_root_type,omitempty"`
-	OrgDrive      bool      `json:"org_drive,omitempty"` // True for drives created on an organization instance
-	Active        bool      `json:"active,omitempty"`
-	Owner         bool      `json:"owner,omitempty"`
-	Open          bool      `json:"open_sharing,omitempty"`
-	AccessMode    string    `json:"access_mode,omitempty"` // additive (default) | limited_access (reserved)
-	Description   string    `json:"description,omitempty"`
-	AppSlug       string    `json:"app_slug"`
-	PreviewPath   string    `json:"preview_path,omitempty"`
-	CreatedAt     time.Time `json:"created_at"`
-	UpdatedAt     time.Time `json:"updated_at"`
-	NbFiles       int       `json:"initial_number_of_files_to_sync,omitempty"`
-	Initial       bool      `json:"initial_sync,omitempty"`
-	ShortcutID    string    `json:"shortcut_id,omitempty"`
-	MovedFrom     string    `json:"moved_from,omitempty"`
+	Triggers      Triggers   `json:"triggers"`
+	Drive         bool       `json:"drive,omitempty"`
+	DriveRootType string     `json:"drive_root_type,omitempty"`
+	OrgDrive      bool       `json:"org_drive,omitempty"` // True for drives created on an organization instance
+	Active        bool       `json:"active,omitempty"`
+	Owner         bool       `json:"owner,omitempty"`
+	Open          bool       `json:"open_sharing,omitempty"`
+	AccessMode    string     `json:"access_mode,omitempty"` // additive (default) | limited_access
+	Description   string     `json:"description,omitempty"`
+	AppSlug       string     `json:"app_slug"`
+	PreviewPath   string     `json:"preview_path,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	NbFiles       int        `json:"initial_number_of_files_to_sync,omitempty"`
+	Initial       bool       `json:"initial_sync,omitempty"`
+	ShortcutID    string     `json:"shortcut_id,omitempty"`
+	MovedFrom     string     `json:"moved_from,omitempty"`
 
 	Rules []Rule `json:"rules"`
 
@@ -502,6 +503,12 @@ func (s *Sharing) Create(inst *instance.Instance) (*permission.Permission, error
 	if err := s.ValidateRules(); err != nil {
 		return nil, err
 	}
+	if !IsValidAccessMode(s.AccessMode) {
+		return nil, ErrInvalidAccessMode
+	}
+	if s.ExpiresAt != nil && !s.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidExpiration
+	}
 	if s.Drive {
 		if !IsValidDriveRootType(s.DriveRootType) {
 			return nil, ErrInvalidRule
@@ -517,6 +524,9 @@ func (s *Sharing) Create(inst *instance.Instance) (*permission.Permission, error
 	if err := couchdb.CreateDoc(inst, s); err != nil {
 		return nil, err
 	}
+	if s.hasExpiration() {
+		s.ensureExpirationTriggers(inst)
+	}
 	if rule := s.FirstFilesRule(); rule != nil && rule.Selector != couchdb.SelectorReferencedBy {
 		if err := s.AddReferenceForSharing(inst, rule); err != nil {
 			inst.Logger().WithNamespace("sharing").
@@ -641,6 +651,9 @@ func (s *Sharing) CreateRequest(inst *instance.Instance) error {
 		s.SRev = old.SRev
 		err = couchdb.UpdateDoc(inst, s)
 	}
+	if err == nil && s.hasExpiration() {
+		s.ensureExpirationTriggers(inst)
+	}
 	return err
 }
 
@@ -171,6 +171,14 @@ func (s *Sharing) UploadBatchTo(
 		}
 	}()
 
+	// The files inside a limited_access boundary are uploaded only to the
+	// members of the sharing of this boundary.
+	tree, err := s.restrictedTree(inst)
+	if err != nil {
+		return false, err
+	}
+	fp := vfs.NewFilePatherWithCache(inst.VFS())
+
 	for i := 0; i < BatchSize; i++ {
 		if ctx.Err() == context.Canceled {
 			return true, nil
@@ -182,6 +190,12 @@ func (s *Sharing) UploadBatchTo(
 		if file == nil {
 			return false, nil
 		}
+		if !tree.IsEmpty() {
+			if docPath, ok := restrictedDocPath(fp, file); !ok || !tree.Allows(docPath, m) {
+				batch.CommitedSeq = batch.CandidateSeq
+				continue
+			}
+		}
 		if err = s.uploadFile(inst, m, file, ruleIndex); err != nil {
 			return false, err
 		}
@@ -0,0 +1,353 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// BlobsDirName is the path of the directory where the contents shared by
+// several files are stored on the local file system, when deduplication is
+// enabled.
+const BlobsDirName = "/.cozy_blobs"
+
+// Blob is a document used to keep track of a content that is shared by
+// several files and versions, when the deduplication is enabled. The content
+// is identified by its MD5 checksum and size, and the holders are the keys
+// used by the VFS backend for the files and versions that reference it (the
+// object names for Swift for example). When there is no more holder, the
+// content can be removed from the storage.
+type Blob struct {
+	DocID     string    `json:"_id,omitempty"`
+	DocRev    string    `json:"_rev,omitempty"`
+	ByteSize  int64     `json:"size,string"`
+	MD5Sum    []byte    `json:"md5sum"`
+	Holders   []string  `json:"holders"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ID returns the blob identifier
+func (b *Blob) ID() string { return b.DocID }
+
+// Rev returns the blob revision
+func (b *Blob) Rev() string { return b.DocRev }
+
+// DocType returns the blob document type
+func (b *Blob) DocType() string { return consts.FilesBlobs }
+
+// Clone implements couchdb.Doc
+func (b *Blob) Clone() couchdb.Doc {
+	cloned := *b
+	cloned.MD5Sum = make([]byte, len(b.MD5Sum))
+	copy(cloned.MD5Sum, b.MD5Sum)
+	cloned.Holders = make([]string, len(b.Holders))
+	copy(cloned.Holders, b.Holders)
+	return &cloned
+}
+
+// SetID changes the blob identifier
+func (b *Blob) SetID(id string) { b.DocID = id }
+
+// SetRev changes the blob revision
+func (b *Blob) SetRev(rev string) { b.DocRev = rev }
+
+// HasHolder returns true if the given key is one of the holders of the blob.
+func (b *Blob) HasHolder(key string) bool {
+	for _, holder := range b.Holders {
+		if holder == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Saved returns the number of bytes that are saved on the storage by sharing
+// this content between its holders.
+func (b *Blob) Saved() int64 {
+	if len(b.Holders) < 2 {
+		return 0
+	}
+	return int64(len(b.Holders)-1) * b.ByteSize
+}
+
+// BlobID returns the identifier of the blob for a content with the given MD5
+// checksum and size.
+func BlobID(md5sum []byte, size int64) string {
+	return hex.EncodeToString(md5sum) + "-" + strconv.FormatInt(size, 10)
+}
+
+// sameContentBufferSize is the size of the chunks compared by SameContent.
+const sameContentBufferSize = 32 * 1024
+
+// SameContent returns true if the two readers have exactly the same bytes. It
+// is used before sharing an uploaded content with a blob: the MD5 checksums
+// and sizes are only used to find the candidates, as it is easy to forge two
+// contents with the same MD5 checksum.
+func SameContent(a, b io.Reader) (bool, error) {
+	bufA := make([]byte, sameContentBufferSize)
+	bufB := make([]byte, sameContentBufferSize)
+	for {
+		nA, errA := io.ReadFull(a, bufA)
+		nB, errB := io.ReadFull(b, bufB)
+		if !bytes.Equal(bufA[:nA], bufB[:nB]) {
+			return false, nil
+		}
+		endA := errA == io.EOF || errors.Is(errA, io.ErrUnexpectedEOF)
+		endB := errB == io.EOF || errors.Is(errB, io.ErrUnexpectedEOF)
+		if errA != nil && !endA {
+			return false, errA
+		}
+		if errB != nil && !endB {
+			return false, errB
+		}
+		if endA || endB {
+			return endA == endB, nil
+		}
+	}
+}
+
+// DeduplicationEnabled returns true if the VFS backends should share the
+// contents of the copies of a file.
+func DeduplicationEnabled() bool {
+	return config.GetConfig().Fs.Deduplication
+}
+
+// FindBlob returns the blob for the given content, or nil if the content is
+// not shared.
+func FindBlob(db prefixer.Prefixer, md5sum []byte, size int64) (*Blob, error) {
+	if len(md5sum) == 0 {
+		return nil, nil
+	}
+	b := &Blob{}
+	err := couchdb.GetDoc(db, consts.FilesBlobs, BlobID(md5sum, size), b)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// AddBlobHolders adds holders to the blob for the given content. The blob is
+// created if it does not exist yet.
+func AddBlobHolders(db prefixer.Prefixer, md5sum []byte, size int64, keys ...string) (*Blob, error) {
+	mu := config.Lock().ReadWrite(db, "blobs")
+	if err := mu.Lock(); err != nil {
+		return nil, err
+	}
+	defer mu.Unlock()
+
+	b, err := FindBlob(db, md5sum, size)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if b == nil {
+		b = &Blob{
+			DocID:     BlobID(md5sum, size),
+			ByteSize:  size,
+			MD5Sum:    md5sum,
+			CreatedAt: now,
+		}
+	}
+	for _, key := range keys {
+		if !b.HasHolder(key) {
+			b.Holders = append(b.Holders, key)
+		}
+	}
+	b.UpdatedAt = now
+	if b.DocRev == "" {
+		err = couchdb.CreateNamedDocWithDB(db, b)
+	} else {
+		err = couchdb.UpdateDoc(db, b)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// blobsHoldersBatchSize is the maximal number of keys sent in a request to
+// find the blobs of some holders.
+const blobsHoldersBatchSize = 500
+
+// blobsForHolders returns the blobs that have at least one of the given keys
+// in their holders. The caller must hold the blobs lock.
+func blobsForHolders(db prefixer.Prefixer, keys []string) ([]*Blob, error) {
+	seen := make(map[string]struct{})
+	var blobs []*Blob
+	for start := 0; start < len(keys); start += blobsHoldersBatchSize {
+		end := start + blobsHoldersBatchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := make([]interface{}, end-start)
+		for i, key := range keys[start:end] {
+			batch[i] = key
+		}
+		var res couchdb.ViewResponse
+		err := couchdb.ExecView(db, couchdb.BlobsByHolderView, &couchdb.ViewRequest{
+			Keys:        batch,
+			IncludeDocs: true,
+		}, &res)
+		if couchdb.IsNoDatabaseError(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, row := range res.Rows {
+			if _, ok := seen[row.ID]; ok || len(row.Doc) == 0 {
+				continue
+			}
+			seen[row.ID] = struct{}{}
+			b := &Blob{}
+			if err := json.Unmarshal(row.Doc, b); err != nil {
+				return nil, err
+			}
+			blobs = append(blobs, b)
+		}
+	}
+	return blobs, nil
+}
+
+// ReleaseBlobHolders removes the given keys from the holders of the blobs. It
+// returns the blobs that have no more holders: their documents are deleted,
+// and the VFS backend must remove their content from the storage.
+func ReleaseBlobHolders(db prefixer.Prefixer, keys []string) ([]*Blob, error) {
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	released := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		released[key] = struct{}{}
+	}
+
+	mu := config.Lock().ReadWrite(db, "blobs")
+	if err := mu.Lock(); err != nil {
+		return nil, err
+	}
+	defer mu.Unlock()
+
+	blobs, err := blobsForHolders(db, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []*Blob
+	now := time.Now()
+	for _, b := range blobs {
+		holders := b.Holders[:0]
+		for _, holder := range b.Holders {
+			if _, ok := released[holder]; !ok {
+				holders = append(holders, holder)
+			}
+		}
+		b.Holders = holders
+		if len(b.Holders) > 0 {
+			b.UpdatedAt = now
+			if err := couchdb.UpdateDoc(db, b); err != nil {
+				return orphans, err
+			}
+			continue
+		}
+		if err := couchdb.DeleteDoc(db, b); err != nil {
+			return orphans, err
+		}
+		orphans = append(orphans, b)
+	}
+	return orphans, nil
+}
+
+// MoveBlobHolder replaces the from key by the to key in the holders of the
+// blob that it holds, if any. It must be called when a VFS backend moves a
+// shared content, for example when the content of a file becomes an old
+// version after an upload.
+func MoveBlobHolder(db prefixer.Prefixer, from, to string) error {
+	if from == to {
+		return nil
+	}
+	mu := config.Lock().ReadWrite(db, "blobs")
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	blobs, err := blobsForHolders(db, []string{from})
+	if err != nil {
+		return err
+	}
+	for _, b := range blobs {
+		holders := b.Holders[:0]
+		for _, holder := range b.Holders {
+			if holder != from && holder != to {
+				holders = append(holders, holder)
+			}
+		}
+		b.Holders = append(holders, to)
+		b.UpdatedAt = time.Now()
+		if err := couchdb.UpdateDoc(db, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindFileWithContent returns a file, other than the given one, that has the
+// same content, or nil if there is none. It is used to share the content of a
+// file that has just been uploaded.
+func FindFileWithContent(db prefixer.Prefixer, doc *FileDoc) (*FileDoc, error) {
+	if len(doc.MD5Sum) == 0 {
+		return nil, nil
+	}
+	var docs []*FileDoc
+	req := &couchdb.FindRequest{
+		UseIndex: "by-md5sum",
+		Selector: mango.Equal("md5sum", doc.MD5Sum),
+		Limit:    10,
+	}
+	if err := couchdb.FindDocs(db, consts.Files, req, &docs); err != nil {
+		return nil, err
+	}
+	for _, other := range docs {
+		if other.DocID != doc.DocID && other.ByteSize == doc.ByteSize && !other.Trashed {
+			return other, nil
+		}
+	}
+	return nil, nil
+}
+
+// DeduplicatedUsage returns the number of bytes that are not stored on the
+// storage thanks to the deduplication.
+func DeduplicatedUsage(db prefixer.Prefixer) (int64, error) {
+	var res couchdb.ViewResponse
+	err := couchdb.ExecView(db, couchdb.BlobsSavedView, &couchdb.ViewRequest{
+		Reduce: true,
+	}, &res)
+	if couchdb.IsNoDatabaseError(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(res.Rows) == 0 {
+		return 0, nil
+	}
+	saved, ok := res.Rows[0].Value.(float64)
+	if !ok {
+		return 0, ErrWrongCouchdbState
+	}
+	return int64(saved), nil
+}
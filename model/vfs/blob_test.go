@@ -0,0 +1,32 @@
+package vfs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSameContent(t *testing.T) {
+	same := func(a, b []byte) bool {
+		ok, err := SameContent(bytes.NewReader(a), bytes.NewReader(b))
+		require.NoError(t, err)
+		return ok
+	}
+
+	assert.True(t, same(nil, nil))
+	assert.True(t, same([]byte("foo"), []byte("foo")))
+	assert.False(t, same([]byte("foo"), []byte("bar")))
+	assert.False(t, same([]byte("foo"), []byte("foobar")))
+	assert.False(t, same([]byte("foobar"), []byte("foo")))
+
+	// The contents are compared by chunks
+	long := []byte(strings.Repeat("a", 3*sameContentBufferSize+7))
+	other := bytes.Clone(long)
+	other[2*sameContentBufferSize+1] = 'b'
+	assert.True(t, same(long, bytes.Clone(long)))
+	assert.False(t, same(long, other))
+	assert.False(t, same(long, long[:len(long)-1]))
+}
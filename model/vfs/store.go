@@ -29,6 +29,16 @@ type Store interface {
 	GetVersion(db prefixer.Prefixer, key string) (string, error)
 	GetArchive(db prefixer.Prefixer, key string) (*Archive, error)
 	GetMetadata(db prefixer.Prefixer, key string) (*Metadata, error)
+	// AddShareLink remembers the share by link that was used to create the
+	// secret of a download, so that the download can be counted for this
+	// link. GetShareLink returns an empty string for the other secrets.
+	AddShareLink(db prefixer.Prefixer, secret, permID string) error
+	GetShareLink(db prefixer.Prefixer, secret string) (string, error)
+	// AddShareLinkDownload remembers that the download of a secret has been
+	// counted for its share by link, so that the requests with a Range for
+	// the rest of the content are not counted again.
+	AddShareLinkDownload(db prefixer.Prefixer, secret string) error
+	HasShareLinkDownload(db prefixer.Prefixer, secret string) (bool, error)
 }
 
 // storeTTL is time after which the data in the store will be considered stale.
@@ -150,6 +160,26 @@ func (s *memStore) AddMetadata(db prefixer.Prefixer, metadata *Metadata) (string
 	return key, nil
 }
 
+func (s *memStore) AddShareLink(db prefixer.Prefixer, secret, permID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vals[db.DBPrefix()+":sharelink:"+secret] = &memRef{
+		val: permID,
+		exp: time.Now().Add(storeTTL),
+	}
+	return nil
+}
+
+func (s *memStore) AddShareLinkDownload(db prefixer.Prefixer, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vals[db.DBPrefix()+":sharelink-download:"+secret] = &memRef{
+		val: true,
+		exp: time.Now().Add(storeTTL),
+	}
+	return nil
+}
+
 func (s *memStore) GetFile(db prefixer.Prefixer, key string) (string, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -245,6 +275,37 @@ func (s *memStore) GetMetadata(db prefixer.Prefixer, key string) (*Metadata, err
 	return m, nil
 }
 
+func (s *memStore) GetShareLink(db prefixer.Prefixer, secret string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := db.DBPrefix() + ":sharelink:" + secret
+	ref, ok := s.vals[key]
+	if !ok {
+		return "", nil
+	}
+	if time.Now().After(ref.exp) {
+		delete(s.vals, key)
+		return "", nil
+	}
+	permID, _ := ref.val.(string)
+	return permID, nil
+}
+
+func (s *memStore) HasShareLinkDownload(db prefixer.Prefixer, secret string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := db.DBPrefix() + ":sharelink-download:" + secret
+	ref, ok := s.vals[key]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(ref.exp) {
+		delete(s.vals, key)
+		return false, nil
+	}
+	return true, nil
+}
+
 type redisStore struct {
 	c   redis.UniversalClient
 	ctx context.Context
@@ -317,6 +378,14 @@ func (s *redisStore) AddMetadata(db prefixer.Prefixer, metadata *Metadata) (stri
 	return key, nil
 }
 
+func (s *redisStore) AddShareLink(db prefixer.Prefixer, secret, permID string) error {
+	return s.c.Set(s.ctx, db.DBPrefix()+":sharelink:"+secret, permID, storeTTL).Err()
+}
+
+func (s *redisStore) AddShareLinkDownload(db prefixer.Prefixer, secret string) error {
+	return s.c.Set(s.ctx, db.DBPrefix()+":sharelink-download:"+secret, "1", storeTTL).Err()
+}
+
 func (s *redisStore) GetFile(db prefixer.Prefixer, key string) (string, error) {
 	f, err := s.c.Get(s.ctx, db.DBPrefix()+":"+key).Result()
 	if errors.Is(err, redis.Nil) {
@@ -380,6 +449,22 @@ func (s *redisStore) GetMetadata(db prefixer.Prefixer, key string) (*Metadata, e
 	return meta, nil
 }
 
+func (s *redisStore) GetShareLink(db prefixer.Prefixer, secret string) (string, error) {
+	permID, err := s.c.Get(s.ctx, db.DBPrefix()+":sharelink:"+secret).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	return permID, err
+}
+
+func (s *redisStore) HasShareLinkDownload(db prefixer.Prefixer, secret string) (bool, error) {
+	n, err := s.c.Exists(s.ctx, db.DBPrefix()+":sharelink-download:"+secret).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
 func makeSecret() string {
 	return hex.EncodeToString(crypto.GenerateRandomBytes(8))
 }
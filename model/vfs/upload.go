@@ -0,0 +1,300 @@
+package vfs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	multierror "github.com/hashicorp/go-multierror"
+)
+
+// UploadsDirName is the path of the directory where the parts of the
+// resumable uploads are staged on the local file system.
+const UploadsDirName = "/.cozy_uploads"
+
+// UploadSessionTTL is the duration after which an upload session that has not
+// received new content is considered as abandoned and can be cleaned.
+const UploadSessionTTL = 24 * time.Hour
+
+var (
+	// ErrUploadOffsetMismatch is used when a part of a resumable upload is
+	// sent for an offset that is not the current offset of the session.
+	ErrUploadOffsetMismatch = errors.New("The offset does not match the current offset of the upload")
+	// ErrUploadNotFinished is used when trying to commit an upload whose
+	// content has not been fully sent.
+	ErrUploadNotFinished = errors.New("The content of the upload has not been fully sent")
+	// ErrUploadExpired is used when trying to use an upload session that has
+	// expired.
+	ErrUploadExpired = errors.New("The upload session has expired")
+)
+
+// UploadSession is a document used to keep track of a resumable upload. The
+// content is sent in several parts, that are staged by the VFS backend until
+// the upload is committed. Then, the file is created (or its content is
+// replaced) like for a normal upload.
+type UploadSession struct {
+	DocID      string    `json:"_id,omitempty"`
+	DocRev     string    `json:"_rev,omitempty"`
+	Name       string    `json:"name"`
+	DirID      string    `json:"dir_id,omitempty"`
+	FileID     string    `json:"file_id,omitempty"`
+	ByteSize   int64     `json:"size,string"`
+	MD5Sum     []byte    `json:"md5sum,omitempty"`
+	Mime       string    `json:"mime,omitempty"`
+	Class      string    `json:"class,omitempty"`
+	Executable bool      `json:"executable,omitempty"`
+	Encrypted  bool      `json:"encrypted,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Metadata   Metadata  `json:"metadata,omitempty"`
+	Offset     int64     `json:"offset,string"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ID returns the upload session identifier
+func (u *UploadSession) ID() string { return u.DocID }
+
+// Rev returns the upload session revision
+func (u *UploadSession) Rev() string { return u.DocRev }
+
+// DocType returns the upload session document type
+func (u *UploadSession) DocType() string { return consts.FilesUploads }
+
+// Clone implements couchdb.Doc
+func (u *UploadSession) Clone() couchdb.Doc {
+	cloned := *u
+	cloned.MD5Sum = make([]byte, len(u.MD5Sum))
+	copy(cloned.MD5Sum, u.MD5Sum)
+	cloned.Tags = make([]string, len(u.Tags))
+	copy(cloned.Tags, u.Tags)
+	cloned.Metadata = make(Metadata, len(u.Metadata))
+	for k, val := range u.Metadata {
+		cloned.Metadata[k] = val
+	}
+	return &cloned
+}
+
+// SetID changes the upload session identifier
+func (u *UploadSession) SetID(id string) { u.DocID = id }
+
+// SetRev changes the upload session revision
+func (u *UploadSession) SetRev(rev string) { u.DocRev = rev }
+
+// Included is part of jsonapi.Object interface
+func (u *UploadSession) Included() []jsonapi.Object { return nil }
+
+// Relationships is part of jsonapi.Object interface
+func (u *UploadSession) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Links is part of jsonapi.Object interface
+func (u *UploadSession) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/files/uploads/" + u.DocID}
+}
+
+// Expired returns true if the upload session has expired.
+func (u *UploadSession) Expired() bool {
+	return time.Now().After(u.ExpiresAt)
+}
+
+// NewUploadSession creates an upload session for the given file document. The
+// olddoc is the current version of the file when the upload is for a new
+// content of an existing file, and nil for a new file.
+func NewUploadSession(fs VFS, newdoc, olddoc *FileDoc) (*UploadSession, error) {
+	if newdoc.ByteSize < 0 {
+		return nil, ErrContentLengthMismatch
+	}
+	if olddoc == nil {
+		exists, err := fs.DirChildExists(newdoc.DirID, newdoc.DocName)
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			return nil, os.ErrExist
+		}
+	}
+	if _, _, _, err := CheckAvailableDiskSpace(fs, newdoc); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	u := &UploadSession{
+		Name:       newdoc.DocName,
+		DirID:      newdoc.DirID,
+		ByteSize:   newdoc.ByteSize,
+		MD5Sum:     newdoc.MD5Sum,
+		Mime:       newdoc.Mime,
+		Class:      newdoc.Class,
+		Executable: newdoc.Executable,
+		Encrypted:  newdoc.Encrypted,
+		Tags:       newdoc.Tags,
+		Metadata:   newdoc.Metadata,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		ExpiresAt:  now.Add(UploadSessionTTL),
+	}
+	if olddoc != nil {
+		u.FileID = olddoc.ID()
+	}
+	if err := couchdb.CreateDoc(fs, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetUploadSession returns the upload session with the given identifier.
+func GetUploadSession(db prefixer.Prefixer, id string) (*UploadSession, error) {
+	u := &UploadSession{}
+	if err := couchdb.GetDoc(db, consts.FilesUploads, id, u); err != nil {
+		return nil, err
+	}
+	if u.Expired() {
+		return nil, ErrUploadExpired
+	}
+	return u, nil
+}
+
+// FileDoc returns a file document with the attributes of the upload. It can
+// be used to check the permissions, and then to commit the upload.
+func (u *UploadSession) FileDoc() (*FileDoc, error) {
+	doc, err := NewFileDoc(u.Name, u.DirID, u.ByteSize, u.MD5Sum, u.Mime, u.Class,
+		u.CreatedAt, u.Executable, false, u.Encrypted, u.Tags)
+	if err != nil {
+		return nil, err
+	}
+	doc.Metadata = u.Metadata
+	if u.FileID != "" {
+		doc.SetID(u.FileID)
+	}
+	return doc, nil
+}
+
+// AppendPart adds a part of the content to the upload. The offset must be the
+// current offset of the upload session, as parts are only appended.
+func (u *UploadSession) AppendPart(fs VFS, offset int64, content io.Reader) error {
+	mu := config.Lock().ReadWrite(fs, "uploads/"+u.DocID)
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	// Reload the document inside the lock, as another part may have been
+	// appended in parallel.
+	fresh, err := GetUploadSession(fs, u.DocID)
+	if err != nil {
+		return err
+	}
+	*u = *fresh
+	if offset != u.Offset {
+		return ErrUploadOffsetMismatch
+	}
+
+	remaining := u.ByteSize - u.Offset
+	n, err := fs.StageUploadPart(u.DocID, offset, io.LimitReader(content, remaining+1))
+	if err != nil {
+		return err
+	}
+	if n > remaining {
+		return ErrContentLengthMismatch
+	}
+
+	now := time.Now()
+	u.Offset += n
+	u.UpdatedAt = now
+	u.ExpiresAt = now.Add(UploadSessionTTL)
+	return couchdb.UpdateDoc(fs, u)
+}
+
+// Commit creates the file (or updates its content) from the staged parts. The
+// MD5 checksum is checked on the whole content by the VFS, and the staged
+// parts are removed after that. It takes the same lock as AppendPart, so that
+// a part can't be appended (or the upload committed twice) in parallel.
+func (u *UploadSession) Commit(fs VFS, newdoc, olddoc *FileDoc) error {
+	mu := config.Lock().ReadWrite(fs, "uploads/"+u.DocID)
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+
+	fresh, err := GetUploadSession(fs, u.DocID)
+	if err != nil {
+		return err
+	}
+	*u = *fresh
+	if u.Offset != u.ByteSize {
+		return ErrUploadNotFinished
+	}
+	content, err := fs.OpenStagedUpload(u.DocID)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	newdoc.ByteSize = u.ByteSize
+	newdoc.MD5Sum = u.MD5Sum
+	file, err := fs.CreateFile(newdoc, olddoc)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	if errc := file.Close(); errc != nil && err == nil {
+		err = errc
+	}
+	if err != nil {
+		return err
+	}
+	return u.abort(fs)
+}
+
+// Abort removes the staged parts and the upload session.
+func (u *UploadSession) Abort(fs VFS) error {
+	mu := config.Lock().ReadWrite(fs, "uploads/"+u.DocID)
+	if err := mu.Lock(); err != nil {
+		return err
+	}
+	defer mu.Unlock()
+	return u.abort(fs)
+}
+
+func (u *UploadSession) abort(fs VFS) error {
+	if err := fs.DeleteStagedUpload(u.DocID); err != nil {
+		return err
+	}
+	return couchdb.DeleteDoc(fs, u)
+}
+
+// CleanExpiredUploads removes the upload sessions that have expired, with
+// their staged parts.
+func CleanExpiredUploads(fs VFS) error {
+	var sessions []*UploadSession
+	err := couchdb.ForeachDocs(fs, consts.FilesUploads, func(_ string, data json.RawMessage) error {
+		u := &UploadSession{}
+		if err := json.Unmarshal(data, u); err != nil {
+			return err
+		}
+		if u.Expired() {
+			sessions = append(sessions, u)
+		}
+		return nil
+	})
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		return err
+	}
+	var errm error
+	for _, u := range sessions {
+		if err := u.Abort(fs); err != nil {
+			errm = multierror.Append(errm, err)
+		}
+	}
+	return errm
+}
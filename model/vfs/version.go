@@ -167,13 +167,21 @@ const (
 // the versions to clean or keep are:
 // - the tagged versions are kept
 // - two versions must not be too close in time
-// - there is a maximal number of versions.
+// - there is a maximal number of versions
+// - the versioning policy of the nearest directory, if any, is applied.
 func FindVersionsToClean(db Prefixer, fileID string, candidate *Version) (ActionForCandidateVersion, []*Version, error) {
 	olds, err := VersionsFor(db, fileID)
 	if err != nil {
 		return DoNothingForCandidateVersion, nil, err
 	}
 	maxNumber, minDelay := getVersioningConfig(db.GetContextName())
+	// If the policy cannot be loaded, we fallback on the rules from the
+	// config, as it was done before the policies.
+	policy, class, err := findVersioningPolicyForFile(db, fileID)
+	if err == nil && policy != nil {
+		action, toClean := policy.detectVersionsToClean(candidate, olds, class, maxNumber, minDelay, time.Now())
+		return action, toClean, nil
+	}
 	action, toClean := detectVersionsToClean(candidate, olds, maxNumber, minDelay)
 	return action, toClean, nil
 }
@@ -209,7 +217,7 @@ func detectVersionsToClean(candidate *Version, olds []*Version, maxNumber int, m
 	// race condition on the candidate version, and we can avoid it by checking
 	// the ID.
 	for _, old := range olds {
-		if old.DocID == candidate.DocID {
+		if candidate != nil && old.DocID == candidate.DocID {
 			return DoNothingForCandidateVersion, nil
 		}
 	}
@@ -0,0 +1,147 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// MaxDiffSize is the maximal size of the contents that can be compared.
+const MaxDiffSize = 2 << 20
+
+var (
+	// ErrDiffNotText is used when the content of a file cannot be compared
+	// as a text
+	ErrDiffNotText = errors.New("The content of this file is not a text")
+	// ErrDiffTooLarge is used when the content of a file is too large for
+	// being compared
+	ErrDiffTooLarge = errors.New("The content of this file is too large for a diff")
+)
+
+// CurrentVersionID is the identifier used for the current content of a file,
+// when it is compared with its old versions.
+const CurrentVersionID = "current"
+
+// TimelineEntry is an entry of the history of a file: an old version, or the
+// current content.
+type TimelineEntry struct {
+	// VersionID is the identifier of the version (without the file
+	// identifier), or "current" for the current content
+	VersionID  string           `json:"version_id"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+	UploadedAt *time.Time       `json:"uploaded_at,omitempty"`
+	UploadedBy *UploadedByEntry `json:"uploaded_by,omitempty"`
+	UploadedOn string           `json:"uploaded_on,omitempty"`
+	ByteSize   int64            `json:"size,string"`
+	// SizeDelta is the difference of size with the previous entry
+	SizeDelta int64    `json:"size_delta"`
+	MD5Sum    []byte   `json:"md5sum"`
+	Tags      []string `json:"tags,omitempty"`
+}
+
+// Timeline returns the history of the file, the oldest version first and the
+// current content last.
+func Timeline(db prefixer.Prefixer, doc *FileDoc) ([]*TimelineEntry, error) {
+	versions, err := VersionsFor(db, doc.ID())
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versionDate(versions[i]).Before(versionDate(versions[j]))
+	})
+	entries := make([]*TimelineEntry, 0, len(versions)+1)
+	for _, v := range versions {
+		entries = append(entries, &TimelineEntry{
+			VersionID:  strings.TrimPrefix(v.DocID, doc.ID()+"/"),
+			UpdatedAt:  v.UpdatedAt,
+			UploadedAt: v.CozyMetadata.UploadedAt,
+			UploadedBy: v.CozyMetadata.UploadedBy,
+			UploadedOn: v.CozyMetadata.UploadedOn,
+			ByteSize:   v.ByteSize,
+			MD5Sum:     v.MD5Sum,
+			Tags:       v.Tags,
+		})
+	}
+	current := &TimelineEntry{
+		VersionID: CurrentVersionID,
+		UpdatedAt: doc.UpdatedAt,
+		ByteSize:  doc.ByteSize,
+		MD5Sum:    doc.MD5Sum,
+		Tags:      doc.Tags,
+	}
+	if doc.CozyMetadata != nil {
+		current.UploadedAt = doc.CozyMetadata.UploadedAt
+		current.UploadedBy = doc.CozyMetadata.UploadedBy
+		current.UploadedOn = doc.CozyMetadata.UploadedOn
+	}
+	entries = append(entries, current)
+	for i := 1; i < len(entries); i++ {
+		entries[i].SizeDelta = entries[i].ByteSize - entries[i-1].ByteSize
+	}
+	return entries, nil
+}
+
+func versionDate(v *Version) time.Time {
+	if v.CozyMetadata.UploadedAt != nil {
+		return *v.CozyMetadata.UploadedAt
+	}
+	return v.UpdatedAt
+}
+
+// IsTextLike returns true if the content of the file can be compared as a
+// text.
+func IsTextLike(doc *FileDoc) bool {
+	if doc.Class == "text" || strings.HasPrefix(doc.Mime, "text/") {
+		return true
+	}
+	switch doc.Mime {
+	case "application/json", "application/xml", "application/javascript",
+		"application/x-yaml", "application/yaml", "application/x-sh",
+		"application/sql", "application/x-tex":
+		return true
+	}
+	return strings.HasSuffix(doc.Mime, "+json") || strings.HasSuffix(doc.Mime, "+xml")
+}
+
+// ReadText returns the content of the file as a text, for the given version,
+// or for the current content if the version is nil.
+func ReadText(fs VFS, doc *FileDoc, version *Version) (string, error) {
+	if !IsTextLike(doc) {
+		return "", ErrDiffNotText
+	}
+	size := doc.ByteSize
+	if version != nil {
+		size = version.ByteSize
+	}
+	if size > MaxDiffSize {
+		return "", ErrDiffTooLarge
+	}
+
+	var f File
+	var err error
+	if version != nil {
+		f, err = fs.OpenFileVersion(doc, version)
+	} else {
+		f, err = fs.OpenFile(doc)
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	buf, err := io.ReadAll(io.LimitReader(f, MaxDiffSize+1))
+	if err != nil {
+		return "", err
+	}
+	if len(buf) > MaxDiffSize {
+		return "", ErrDiffTooLarge
+	}
+	if !utf8.Valid(buf) {
+		return "", ErrDiffNotText
+	}
+	return string(buf), nil
+}
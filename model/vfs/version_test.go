@@ -93,6 +93,144 @@ func TestVersion(t *testing.T) {
 	})
 }
 
+func TestVersioningPolicy(t *testing.T) {
+	fileID := uuidv7()
+	now := time.Date(2024, time.May, 10, 12, 30, 0, 0, time.UTC)
+	genVersion := func(timeAgo time.Duration, size int64) *Version {
+		v := &Version{
+			DocID:    fileID + "/" + utils.RandomString(16),
+			ByteSize: size,
+		}
+		v.CozyMetadata.CreatedAt = now.Add(-1 * timeAgo)
+		return v
+	}
+
+	t.Run("Validate", func(t *testing.T) {
+		p := &VersioningPolicy{Schedule: []RetentionRule{
+			{Every: "1h", For: "1D"},
+			{Every: "1D", For: "1M"},
+			{Every: "1M"},
+		}}
+		assert.NoError(t, p.Validate())
+
+		p = &VersioningPolicy{Schedule: []RetentionRule{{Every: "1D", For: "1h"}}}
+		assert.ErrorIs(t, p.Validate(), ErrInvalidVersioningPolicy)
+
+		p = &VersioningPolicy{Schedule: []RetentionRule{{Every: "1M"}, {Every: "1h", For: "1D"}}}
+		assert.ErrorIs(t, p.Validate(), ErrInvalidVersioningPolicy)
+
+		p = &VersioningPolicy{Schedule: []RetentionRule{{Every: "foo"}}}
+		assert.ErrorIs(t, p.Validate(), ErrInvalidVersioningPolicy)
+
+		p = &VersioningPolicy{MaxSizePerFile: -1}
+		assert.ErrorIs(t, p.Validate(), ErrInvalidVersioningPolicy)
+	})
+
+	t.Run("Schedule", func(t *testing.T) {
+		p := &VersioningPolicy{Schedule: []RetentionRule{
+			{Every: "1h", For: "1D"},
+			{Every: "1D", For: "1M"},
+		}}
+		assert.NoError(t, p.Validate())
+
+		recent := genVersion(2*time.Minute, 10)
+		hourly := genVersion(3*time.Hour, 10)
+		daily1 := genVersion(5*24*time.Hour, 10)
+		daily2 := genVersion(5*24*time.Hour+time.Minute, 10)
+		tooOld := genVersion(60*24*time.Hour, 10)
+		tagged := genVersion(90*24*time.Hour, 10)
+		tagged.Tags = []string{"keep"}
+		candidate := genVersion(0, 10)
+
+		olds := []*Version{tooOld, daily2, recent, tagged, hourly, daily1}
+		action, toClean := p.detectVersionsToClean(candidate, olds, "text", 20, 15*time.Minute, now)
+		assert.Equal(t, KeepCandidateVersion, action)
+		// recent and candidate are in the same hour
+		assert.Equal(t, []*Version{tooOld, daily2, recent}, toClean)
+	})
+
+	t.Run("ScheduleWithoutMaxNumber", func(t *testing.T) {
+		p := &VersioningPolicy{Schedule: []RetentionRule{
+			{Every: "1h", For: "1D"},
+			{Every: "1D", For: "1M"},
+			{Every: "1M"},
+		}}
+		assert.NoError(t, p.Validate())
+
+		// 30 versions, one per day: more than the 20 versions of the
+		// configuration, but all of them match the schedule
+		var olds []*Version
+		for i := 30; i > 0; i-- {
+			olds = append(olds, genVersion(time.Duration(i)*24*time.Hour-time.Hour, 10))
+		}
+		candidate := genVersion(0, 10)
+		action, toClean := p.detectVersionsToClean(candidate, olds, "text", 20, 15*time.Minute, now)
+		assert.Equal(t, KeepCandidateVersion, action)
+		assert.Len(t, toClean, 0)
+
+		// The maximal number of the policy is still applied
+		ten := 10
+		p.MaxNumberToKeep = &ten
+		action, toClean = p.detectVersionsToClean(candidate, olds, "text", 20, 15*time.Minute, now)
+		assert.Equal(t, KeepCandidateVersion, action)
+		// 10 versions are kept, with the current content and the candidate
+		assert.Equal(t, olds[:22], toClean)
+	})
+
+	t.Run("MaxSizePerFile", func(t *testing.T) {
+		p := &VersioningPolicy{MaxSizePerFile: 100}
+		assert.NoError(t, p.Validate())
+
+		v1 := genVersion(3*time.Hour, 40)
+		v2 := genVersion(2*time.Hour, 40)
+		v3 := genVersion(1*time.Hour, 40)
+		v1.Tags = []string{"keep"}
+		candidate := genVersion(0, 10)
+
+		olds := []*Version{v1, v2, v3}
+		action, toClean := p.detectVersionsToClean(candidate, olds, "text", 20, 15*time.Minute, now)
+		assert.Equal(t, KeepCandidateVersion, action)
+		assert.Equal(t, []*Version{v2}, toClean)
+
+		big := genVersion(0, 1000)
+		action, toClean = p.detectVersionsToClean(big, []*Version{}, "text", 20, 15*time.Minute, now)
+		assert.Equal(t, CleanCandidateVersion, action)
+		assert.Len(t, toClean, 0)
+	})
+
+	t.Run("ExcludedClasses", func(t *testing.T) {
+		p := &VersioningPolicy{ExcludedClasses: []string{"video"}}
+		assert.NoError(t, p.Validate())
+
+		v1 := genVersion(3*time.Hour, 40)
+		v2 := genVersion(2*time.Hour, 40)
+		v2.Tags = []string{"keep"}
+		candidate := genVersion(0, 10)
+
+		action, toClean := p.detectVersionsToClean(candidate, []*Version{v1, v2}, "video", 20, 15*time.Minute, now)
+		assert.Equal(t, CleanCandidateVersion, action)
+		assert.Equal(t, []*Version{v1}, toClean)
+
+		action, toClean = p.detectVersionsToClean(candidate, []*Version{v1, v2}, "image", 20, 15*time.Minute, now)
+		assert.Equal(t, KeepCandidateVersion, action)
+		assert.Len(t, toClean, 0)
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		two := 2
+		p := &VersioningPolicy{MaxNumberToKeep: &two}
+		assert.NoError(t, p.Validate())
+
+		v1 := genVersion(3*time.Hour, 40)
+		v2 := genVersion(2*time.Hour, 40)
+		candidate := genVersion(0, 10)
+
+		action, toClean := p.detectVersionsToClean(candidate, []*Version{v1, v2}, "text", 20, 15*time.Minute, now)
+		assert.Equal(t, KeepCandidateVersion, action)
+		assert.Equal(t, []*Version{v1, v2}, toClean)
+	})
+}
+
 func uuidv7() string {
 	return uuid.Must(uuid.NewV7()).String()
 }
@@ -0,0 +1,552 @@
+package vfs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/hashicorp/go-multierror"
+	"github.com/justincampbell/bigduration"
+)
+
+// ErrInvalidVersioningPolicy is used when a versioning policy cannot be
+// accepted.
+var ErrInvalidVersioningPolicy = errors.New("Invalid versioning policy")
+
+// maxDirDepth is used to stop walking up the tree of directories if there
+// is a loop.
+const maxDirDepth = 256
+
+// policiesCacheDuration is how long the versioning policies of an instance
+// are kept in cache. The cache is cleared when a policy is saved or deleted.
+const policiesCacheDuration = 10 * time.Minute
+
+func policiesCacheKey(db Prefixer) string {
+	return "versioning-policies:" + db.DBPrefix()
+}
+
+func clearPoliciesCache(db Prefixer) {
+	config.GetConfig().CacheStorage.Clear(policiesCacheKey(db))
+}
+
+// VersioningPolicy is a retention policy for the old versions of the files
+// that can be attached to a directory. It applies to the files inside this
+// directory and its sub-directories, unless a nearer directory has its own
+// policy. Its identifier is the identifier of the directory.
+type VersioningPolicy struct {
+	DocID  string `json:"_id,omitempty"`
+	DocRev string `json:"_rev,omitempty"`
+
+	// MaxNumberToKeep and MinDelayBetweenTwoVersions override the values
+	// from the configuration when they are set.
+	MaxNumberToKeep            *int   `json:"max_number_of_versions_to_keep,omitempty"`
+	MinDelayBetweenTwoVersions string `json:"min_delay_between_two_versions,omitempty"`
+
+	// Schedule is a list of grandfather-father-son rules, like keep one
+	// version per hour for a day, then one per day for a month, etc.
+	Schedule []RetentionRule `json:"schedule,omitempty"`
+
+	// MaxSizePerFile is the maximal number of bytes that the old versions of
+	// a file can use.
+	MaxSizePerFile int64 `json:"max_size_per_file,omitempty"`
+
+	// ExcludedClasses is a list of classes of files (image, video, etc.) for
+	// which no old versions are kept.
+	ExcludedClasses []string `json:"excluded_classes,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// RetentionRule says that one version per period of Every should be kept for
+// the versions that are younger than For. An empty For means forever. The
+// durations can use the D (day), W (week), M (month), and Y (year) suffixes.
+type RetentionRule struct {
+	Every string `json:"every"`
+	For   string `json:"for,omitempty"`
+
+	every time.Duration
+	until time.Duration // 0 means forever
+}
+
+// ID returns the identifier of the directory
+func (p *VersioningPolicy) ID() string { return p.DocID }
+
+// Rev returns the policy revision
+func (p *VersioningPolicy) Rev() string { return p.DocRev }
+
+// DocType returns the policy document type
+func (p *VersioningPolicy) DocType() string { return consts.FilesVersioningPolicies }
+
+// Clone implements couchdb.Doc
+func (p *VersioningPolicy) Clone() couchdb.Doc {
+	cloned := *p
+	if p.MaxNumberToKeep != nil {
+		nb := *p.MaxNumberToKeep
+		cloned.MaxNumberToKeep = &nb
+	}
+	cloned.Schedule = make([]RetentionRule, len(p.Schedule))
+	copy(cloned.Schedule, p.Schedule)
+	cloned.ExcludedClasses = make([]string, len(p.ExcludedClasses))
+	copy(cloned.ExcludedClasses, p.ExcludedClasses)
+	return &cloned
+}
+
+// SetID changes the policy identifier
+func (p *VersioningPolicy) SetID(id string) { p.DocID = id }
+
+// SetRev changes the policy revision
+func (p *VersioningPolicy) SetRev(rev string) { p.DocRev = rev }
+
+// Included is part of jsonapi.Object interface
+func (p *VersioningPolicy) Included() []jsonapi.Object { return nil }
+
+// Relationships is part of jsonapi.Object interface
+func (p *VersioningPolicy) Relationships() jsonapi.RelationshipMap { return nil }
+
+// Links is part of jsonapi.Object interface
+func (p *VersioningPolicy) Links() *jsonapi.LinksList { return nil }
+
+// Validate checks that the policy can be used, and parses its durations.
+func (p *VersioningPolicy) Validate() error {
+	if p.MaxNumberToKeep != nil && *p.MaxNumberToKeep < 0 {
+		return fmt.Errorf("%w: negative max_number_of_versions_to_keep", ErrInvalidVersioningPolicy)
+	}
+	if p.MinDelayBetweenTwoVersions != "" {
+		if _, err := bigduration.ParseDuration(p.MinDelayBetweenTwoVersions); err != nil {
+			return fmt.Errorf("%w: invalid min_delay_between_two_versions", ErrInvalidVersioningPolicy)
+		}
+	}
+	if p.MaxSizePerFile < 0 {
+		return fmt.Errorf("%w: negative max_size_per_file", ErrInvalidVersioningPolicy)
+	}
+	for _, class := range p.ExcludedClasses {
+		if class == "" || strings.Contains(class, "/") {
+			return fmt.Errorf("%w: invalid class %q", ErrInvalidVersioningPolicy, class)
+		}
+	}
+
+	var previous time.Duration
+	for i := range p.Schedule {
+		rule := &p.Schedule[i]
+		every, err := bigduration.ParseDuration(rule.Every)
+		if err != nil || every <= 0 {
+			return fmt.Errorf("%w: invalid every for rule %d", ErrInvalidVersioningPolicy, i)
+		}
+		rule.every = every
+		rule.until = 0
+		if rule.For != "" {
+			until, err := bigduration.ParseDuration(rule.For)
+			if err != nil || until < every {
+				return fmt.Errorf("%w: invalid for for rule %d", ErrInvalidVersioningPolicy, i)
+			}
+			rule.until = until
+		}
+		if i > 0 && (previous == 0 || (rule.until != 0 && rule.until <= previous)) {
+			return fmt.Errorf("%w: the rules must be sorted by their for duration", ErrInvalidVersioningPolicy)
+		}
+		previous = rule.until
+	}
+	return nil
+}
+
+// excludes returns true if the versions must not be kept for the given class.
+func (p *VersioningPolicy) excludes(class string) bool {
+	for _, c := range p.ExcludedClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// GetVersioningPolicy returns the policy attached to the given directory.
+func GetVersioningPolicy(db Prefixer, dirID string) (*VersioningPolicy, error) {
+	doc := &VersioningPolicy{}
+	if err := couchdb.GetDoc(db, consts.FilesVersioningPolicies, dirID, doc); err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// SaveVersioningPolicy validates and persists the policy for a directory.
+func SaveVersioningPolicy(db Prefixer, policy *VersioningPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	policy.UpdatedAt = time.Now().UTC()
+	if policy.CreatedAt.IsZero() {
+		policy.CreatedAt = policy.UpdatedAt
+	}
+	var err error
+	if policy.DocRev == "" {
+		err = couchdb.CreateNamedDocWithDB(db, policy)
+	} else {
+		err = couchdb.UpdateDoc(db, policy)
+	}
+	if err == nil {
+		clearPoliciesCache(db)
+	}
+	return err
+}
+
+// DeleteVersioningPolicy removes the policy of a directory. The files in
+// this directory will use the policy of a parent directory, if any.
+func DeleteVersioningPolicy(db Prefixer, policy *VersioningPolicy) error {
+	if err := couchdb.DeleteDoc(db, policy); err != nil {
+		return err
+	}
+	clearPoliciesCache(db)
+	return nil
+}
+
+// FindEffectiveVersioningPolicy returns the policy that applies to the files
+// in the given directory: the policy of the nearest directory in its
+// ancestors (including itself). It returns nil if there is no such policy.
+func FindEffectiveVersioningPolicy(db Prefixer, dirID string) (*VersioningPolicy, error) {
+	resolver, err := newPolicyResolver(db)
+	if err != nil || resolver == nil {
+		return nil, err
+	}
+	return resolver.forDir(dirID)
+}
+
+// policyResolver finds the policy for a directory by walking its ancestors.
+// It caches the parents of the directories, to avoid fetching the same
+// documents several times when it is used for many files.
+type policyResolver struct {
+	db       Prefixer
+	policies map[string]*VersioningPolicy
+	parents  map[string]string
+}
+
+// newPolicyResolver returns a resolver, or nil if there are no policies for
+// this instance.
+func newPolicyResolver(db Prefixer) (*policyResolver, error) {
+	policies, err := loadVersioningPolicies(db)
+	if err != nil || len(policies) == 0 {
+		return nil, err
+	}
+	return &policyResolver{
+		db:       db,
+		policies: policies,
+		parents:  make(map[string]string),
+	}, nil
+}
+
+// loadVersioningPolicies returns the valid policies of an instance, indexed
+// by the identifiers of their directories. They are kept in cache, as they
+// are needed for each upload of a new version of a file.
+func loadVersioningPolicies(db Prefixer) (map[string]*VersioningPolicy, error) {
+	cache := config.GetConfig().CacheStorage
+	key := policiesCacheKey(db)
+	if buf, ok := cache.Get(key); ok {
+		var policies map[string]*VersioningPolicy
+		if err := json.Unmarshal(buf, &policies); err == nil && validatePolicies(policies) {
+			return policies, nil
+		}
+	}
+
+	policies := make(map[string]*VersioningPolicy)
+	err := couchdb.ForeachDocs(db, consts.FilesVersioningPolicies, func(_ string, data json.RawMessage) error {
+		p := &VersioningPolicy{}
+		if err := json.Unmarshal(data, p); err != nil {
+			return err
+		}
+		if err := p.Validate(); err != nil {
+			return nil
+		}
+		policies[p.DocID] = p
+		return nil
+	})
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+
+	if buf, err := json.Marshal(policies); err == nil {
+		cache.Set(key, buf, policiesCacheDuration)
+	}
+	return policies, nil
+}
+
+// validatePolicies checks the policies read from the cache, which also
+// parses the durations of their schedules.
+func validatePolicies(policies map[string]*VersioningPolicy) bool {
+	for _, p := range policies {
+		if p == nil || p.Validate() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *policyResolver) forDir(dirID string) (*VersioningPolicy, error) {
+	id := dirID
+	for i := 0; i < maxDirDepth && id != ""; i++ {
+		if p, ok := r.policies[id]; ok {
+			return p, nil
+		}
+		if id == consts.RootDirID {
+			break
+		}
+		parent, ok := r.parents[id]
+		if !ok {
+			dir := &DirDoc{}
+			if err := couchdb.GetDoc(r.db, consts.Files, id, dir); err != nil {
+				return nil, err
+			}
+			parent = dir.DirID
+			r.parents[id] = parent
+		}
+		id = parent
+	}
+	return nil, nil
+}
+
+// forFile returns the policy for the given file, and the class of the file.
+func (r *policyResolver) forFile(fileID string) (*VersioningPolicy, string, error) {
+	file := &FileDoc{}
+	if err := couchdb.GetDoc(r.db, consts.Files, fileID, file); err != nil {
+		return nil, "", err
+	}
+	policy, err := r.forDir(file.DirID)
+	return policy, file.Class, err
+}
+
+// findVersioningPolicyForFile returns the policy that applies to the given
+// file, and the class of this file.
+func findVersioningPolicyForFile(db Prefixer, fileID string) (*VersioningPolicy, string, error) {
+	resolver, err := newPolicyResolver(db)
+	if err != nil || resolver == nil {
+		return nil, "", err
+	}
+	return resolver.forFile(fileID)
+}
+
+// detectVersionsToClean applies the policy on top of the rules for the
+// maximal number of versions and the minimal delay between two versions.
+// Then, the schedule and the size cap are applied to the versions that are
+// still kept. The tagged versions are always kept.
+func (p *VersioningPolicy) detectVersionsToClean(
+	candidate *Version,
+	olds []*Version,
+	class string,
+	maxNumber int,
+	minDelay time.Duration,
+	now time.Time,
+) (ActionForCandidateVersion, []*Version) {
+	for _, old := range olds {
+		if candidate != nil && old.DocID == candidate.DocID {
+			return DoNothingForCandidateVersion, nil
+		}
+	}
+
+	if p.excludes(class) {
+		var toClean []*Version
+		for _, old := range olds {
+			if len(old.Tags) == 0 {
+				toClean = append(toClean, old)
+			}
+		}
+		return CleanCandidateVersion, toClean
+	}
+
+	if p.MaxNumberToKeep != nil {
+		maxNumber = *p.MaxNumberToKeep
+	} else if len(p.Schedule) > 0 {
+		// The schedule says how many versions are kept, and the maximal
+		// number of the configuration would drop the oldest ones
+		maxNumber = math.MaxInt
+	}
+	if p.MinDelayBetweenTwoVersions != "" {
+		minDelay, _ = bigduration.ParseDuration(p.MinDelayBetweenTwoVersions)
+	} else if len(p.Schedule) > 0 {
+		// The schedule already thins out the versions that are too close
+		minDelay = 0
+	}
+
+	action, toClean := detectVersionsToClean(candidate, olds, maxNumber, minDelay)
+	if action == DoNothingForCandidateVersion {
+		return action, toClean
+	}
+
+	cleaned := make(map[*Version]bool, len(toClean))
+	for _, v := range toClean {
+		cleaned[v] = true
+	}
+	var kept []*Version
+	for _, old := range olds {
+		if !cleaned[old] {
+			kept = append(kept, old)
+		}
+	}
+	if candidate != nil && action == KeepCandidateVersion {
+		kept = append(kept, candidate)
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].CozyMetadata.CreatedAt.Before(kept[j].CozyMetadata.CreatedAt)
+	})
+
+	for _, v := range p.thin(kept, now) {
+		cleaned[v] = true
+	}
+	if p.MaxSizePerFile > 0 {
+		var total int64
+		for _, v := range kept {
+			if !cleaned[v] {
+				total += v.ByteSize
+			}
+		}
+		for _, v := range kept {
+			if total <= p.MaxSizePerFile {
+				break
+			}
+			if cleaned[v] || len(v.Tags) > 0 {
+				continue
+			}
+			cleaned[v] = true
+			total -= v.ByteSize
+		}
+	}
+
+	if candidate != nil && cleaned[candidate] {
+		action = CleanCandidateVersion
+	}
+	toClean = toClean[:0]
+	for _, old := range olds {
+		if cleaned[old] {
+			toClean = append(toClean, old)
+		}
+	}
+	sort.SliceStable(toClean, func(i, j int) bool {
+		return toClean[i].CozyMetadata.CreatedAt.Before(toClean[j].CozyMetadata.CreatedAt)
+	})
+	return action, toClean
+}
+
+// thin returns the versions that are not kept by the schedule. A version is
+// handled by the first rule that covers its age, and only the most recent
+// version is kept for each period of this rule. The versions that are older
+// than what the rules cover are not kept. The versions must be sorted from
+// the oldest to the most recent.
+func (p *VersioningPolicy) thin(versions []*Version, now time.Time) []*Version {
+	if len(p.Schedule) == 0 {
+		return nil
+	}
+	type bucket struct {
+		rule   int
+		period int64
+	}
+	seen := make(map[bucket]bool)
+	var toClean []*Version
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		if len(v.Tags) > 0 {
+			continue
+		}
+		created := v.CozyMetadata.CreatedAt
+		age := now.Sub(created)
+		rule := -1
+		for j, r := range p.Schedule {
+			if r.until == 0 || age < r.until {
+				rule = j
+				break
+			}
+		}
+		if rule < 0 {
+			toClean = append(toClean, v)
+			continue
+		}
+		b := bucket{rule: rule, period: created.UnixNano() / int64(p.Schedule[rule].every)}
+		if seen[b] {
+			toClean = append(toClean, v)
+			continue
+		}
+		seen[b] = true
+	}
+	return toClean
+}
+
+// EnforceVersioningPolicies checks the old versions of all the files that are
+// covered by a versioning policy, and cleans those that should no longer be
+// kept, as the schedule of a policy can make a version obsolete only because
+// time has passed.
+func EnforceVersioningPolicies(db Prefixer, fs VFS) error {
+	resolver, err := newPolicyResolver(db)
+	if err != nil || resolver == nil {
+		return err
+	}
+	maxNumber, minDelay := getVersioningConfig(db.GetContextName())
+	now := time.Now()
+
+	var errm error
+	toClean := make(map[string][]*Version)
+	var fileID string
+	var versions []*Version
+	flush := func() {
+		if len(versions) == 0 {
+			return
+		}
+		policy, class, err := resolver.forFile(fileID)
+		if err != nil {
+			if !couchdb.IsNotFoundError(err) {
+				errm = multierror.Append(errm, err)
+			}
+			return
+		}
+		if policy == nil {
+			return
+		}
+		_, olds := policy.detectVersionsToClean(nil, versions, class, maxNumber, minDelay, now)
+		if len(olds) > 0 {
+			toClean[fileID] = olds
+		}
+	}
+
+	err = couchdb.ForeachDocs(db, consts.FilesVersions, func(id string, data json.RawMessage) error {
+		v := &Version{}
+		if err := json.Unmarshal(data, v); err != nil {
+			return err
+		}
+		parts := strings.SplitN(id, "/", 2)
+		if parts[0] != fileID {
+			flush()
+			fileID = parts[0]
+			versions = nil
+		}
+		versions = append(versions, v)
+		return nil
+	})
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return nil
+		}
+		return err
+	}
+	flush()
+
+	for id, olds := range toClean {
+		for _, v := range olds {
+			if err := fs.CleanOldVersion(id, v); err != nil {
+				errm = multierror.Append(errm, err)
+			}
+		}
+	}
+	return errm
+}
+
+var (
+	_ couchdb.Doc    = &VersioningPolicy{}
+	_ jsonapi.Object = &VersioningPolicy{}
+)
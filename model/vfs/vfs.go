@@ -131,6 +131,15 @@ type Fs interface {
 	// two instances are on the same stack.
 	CopyFileFromOtherFS(newdoc, olddoc *FileDoc, srcFS Fs, srcDoc *FileDoc) error
 
+	// StageUploadPart writes a part of the content of a resumable upload, at
+	// the given offset. It returns the number of bytes written.
+	StageUploadPart(uploadID string, offset int64, content io.Reader) (int64, error)
+	// OpenStagedUpload returns a reader on the content staged for a resumable
+	// upload.
+	OpenStagedUpload(uploadID string) (io.ReadCloser, error)
+	// DeleteStagedUpload removes the content staged for a resumable upload.
+	DeleteStagedUpload(uploadID string) error
+
 	// Fsck return the list of inconsistencies in the VFS
 	Fsck(func(log *FsckLog), bool) (err error)
 	CheckFilesConsistency(func(*FsckLog), bool) error
@@ -260,6 +269,11 @@ type DiskThresholder interface {
 	// DiskQuota returns the total number of bytes allowed to be stored in the
 	// VFS. If minus or equal to zero, it is considered without limit.
 	DiskQuota() int64
+	// DeduplicatedUsage returns the number of bytes that are shared by
+	// several files and versions, and stored only once, when the
+	// deduplication is enabled. They are not counted in the disk usage
+	// checked against the quota.
+	DeduplicatedUsage() (int64, error)
 }
 
 // Avatarer defines an interface to define an avatar filesystem.
@@ -944,7 +958,7 @@ func CheckAvailableDiskSpace(fs VFS, doc *FileDoc) (newsize, maxsize, capsize in
 
 	diskQuota := fs.DiskQuota()
 	if diskQuota > 0 {
-		diskUsage, err := fs.DiskUsage()
+		diskUsage, err := QuotaUsage(fs)
 		if err != nil {
 			return 0, 0, 0, err
 		}
@@ -960,6 +974,20 @@ func CheckAvailableDiskSpace(fs VFS, doc *FileDoc) (newsize, maxsize, capsize in
 	return newsize, maxsize, capsize, nil
 }
 
+// QuotaUsage returns the disk usage that is checked against the quota: the
+// contents shared with the deduplication are counted only once.
+func QuotaUsage(fs VFS) (int64, error) {
+	used, err := fs.DiskUsage()
+	if err != nil {
+		return 0, err
+	}
+	saved, err := fs.DeduplicatedUsage()
+	if err != nil {
+		return 0, err
+	}
+	return used - saved, nil
+}
+
 // ConflictName generates a new name for a file/folder in conflict with another
 // that has the same path. A conflicted file `foo` will be renamed foo (2),
 // then foo (3), etc.
@@ -688,6 +688,109 @@ func TestVfs(t *testing.T) {
 					assert.Equal(t, vfs.ErrMaxFileSize, err)
 				}
 			})
+
+			t.Run("Deduplication", func(t *testing.T) {
+				if tt.name != "afero" {
+					t.Skip("the swift test server does not support symlinks")
+				}
+				diskQuota = 0
+				config.GetConfig().Fs.Deduplication = true
+				t.Cleanup(func() { config.GetConfig().Fs.Deduplication = false })
+
+				content := []byte("shared content")
+				doc, err := vfs.NewFileDoc("dedup", consts.RootDirID, int64(len(content)), nil, "text/plain", "text", time.Now(), false, false, false, nil)
+				require.NoError(t, err)
+				f, err := fs.CreateFile(doc, nil)
+				require.NoError(t, err)
+				_, err = f.Write(content)
+				require.NoError(t, err)
+				require.NoError(t, f.Close())
+
+				copied := vfs.CreateFileDocCopy(doc, consts.RootDirID, "dedup (copy)")
+				require.NoError(t, fs.CopyFile(doc, copied))
+
+				blob, err := vfs.FindBlob(fs, doc.MD5Sum, doc.ByteSize)
+				require.NoError(t, err)
+				require.NotNil(t, blob)
+				assert.ElementsMatch(t, []string{doc.ID(), copied.ID()}, blob.Holders)
+				saved, err := vfs.DeduplicatedUsage(fs)
+				require.NoError(t, err)
+				assert.EqualValues(t, len(content), saved)
+
+				buf, err := readFileByPath(fs, "/dedup (copy)")
+				require.NoError(t, err)
+				assert.Equal(t, content, buf)
+
+				require.NoError(t, fs.DestroyFile(doc))
+				blob, err = vfs.FindBlob(fs, copied.MD5Sum, copied.ByteSize)
+				require.NoError(t, err)
+				require.NotNil(t, blob)
+				assert.Equal(t, []string{copied.ID()}, blob.Holders)
+
+				buf, err = readFileByPath(fs, "/dedup (copy)")
+				require.NoError(t, err)
+				assert.Equal(t, content, buf)
+
+				require.NoError(t, fs.DestroyFile(copied))
+				blob, err = vfs.FindBlob(fs, copied.MD5Sum, copied.ByteSize)
+				require.NoError(t, err)
+				assert.Nil(t, blob)
+
+				// An uploaded file shares the content of another file
+				upload := func(name string, olddoc *vfs.FileDoc, content []byte) *vfs.FileDoc {
+					doc, err := vfs.NewFileDoc(name, consts.RootDirID, int64(len(content)), nil, "text/plain", "text", time.Now(), false, false, false, nil)
+					require.NoError(t, err)
+					if olddoc != nil {
+						doc.DocID = olddoc.DocID
+						doc.DocRev = olddoc.DocRev
+					}
+					f, err := fs.CreateFile(doc, olddoc)
+					require.NoError(t, err)
+					_, err = f.Write(content)
+					require.NoError(t, err)
+					require.NoError(t, f.Close())
+					return doc
+				}
+				first := upload("dedup-first", nil, content)
+				second := upload("dedup-second", nil, content)
+				blob, err = vfs.FindBlob(fs, second.MD5Sum, second.ByteSize)
+				require.NoError(t, err)
+				require.NotNil(t, blob)
+				assert.ElementsMatch(t, []string{first.ID(), second.ID()}, blob.Holders)
+				buf, err = readFileByPath(fs, "/dedup-second")
+				require.NoError(t, err)
+				assert.Equal(t, content, buf)
+
+				// Changing the mode of a file gives it its own copy of the content
+				exec := second.Clone().(*vfs.FileDoc)
+				exec.Executable = true
+				require.NoError(t, fs.UpdateFileDoc(second, exec))
+				second = exec
+				blob, err = vfs.FindBlob(fs, first.MD5Sum, first.ByteSize)
+				require.NoError(t, err)
+				require.NotNil(t, blob)
+				assert.Equal(t, []string{first.ID()}, blob.Holders)
+				buf, err = readFileByPath(fs, "/dedup-second")
+				require.NoError(t, err)
+				assert.Equal(t, content, buf)
+
+				// The file no longer holds the blob after an overwrite
+				first = upload("dedup-first", first, []byte("other content"))
+				blob, err = vfs.FindBlob(fs, second.MD5Sum, second.ByteSize)
+				require.NoError(t, err)
+				require.NotNil(t, blob)
+				assert.NotContains(t, blob.Holders, first.ID())
+				assert.Contains(t, blob.Holders, second.ID())
+				buf, err = readFileByPath(fs, "/dedup-first")
+				require.NoError(t, err)
+				assert.Equal(t, []byte("other content"), buf)
+
+				require.NoError(t, fs.DestroyFile(first))
+				require.NoError(t, fs.DestroyFile(second))
+				blob, err = vfs.FindBlob(fs, second.MD5Sum, second.ByteSize)
+				require.NoError(t, err)
+				assert.Nil(t, blob)
+			})
 		})
 
 		t.Run("UpdateFileMetadataField", func(t *testing.T) {
@@ -740,6 +843,41 @@ func TestVfs(t *testing.T) {
 			assert.Equal(t, 1, len(updated.Metadata))
 			assert.Equal(t, true, updated.Metadata["only"])
 		})
+
+		t.Run("VersioningPolicyCache", func(t *testing.T) {
+			db := &contexter{fs.DBCluster(), fs.DomainName(), fs.DBPrefix(), "cozy_beta"}
+			t.Cleanup(func() { _ = couchdb.DeleteDB(db, consts.FilesVersioningPolicies) })
+			dir, err := vfs.NewDirDoc(fs, "policy-dir", consts.RootDirID, nil)
+			require.NoError(t, err)
+			require.NoError(t, fs.CreateDir(dir))
+
+			// The absence of policies is cached too
+			policy, err := vfs.FindEffectiveVersioningPolicy(db, dir.ID())
+			require.NoError(t, err)
+			assert.Nil(t, policy)
+
+			// Saving a policy clears the cache
+			keep := 3
+			saved := &vfs.VersioningPolicy{DocID: dir.ID(), MaxNumberToKeep: &keep}
+			require.NoError(t, vfs.SaveVersioningPolicy(db, saved))
+			policy, err = vfs.FindEffectiveVersioningPolicy(db, dir.ID())
+			require.NoError(t, err)
+			require.NotNil(t, policy)
+			assert.Equal(t, 3, *policy.MaxNumberToKeep)
+
+			keep = 5
+			require.NoError(t, vfs.SaveVersioningPolicy(db, saved))
+			policy, err = vfs.FindEffectiveVersioningPolicy(db, dir.ID())
+			require.NoError(t, err)
+			require.NotNil(t, policy)
+			assert.Equal(t, 5, *policy.MaxNumberToKeep)
+
+			// And deleting it too
+			require.NoError(t, vfs.DeleteVersioningPolicy(db, saved))
+			policy, err = vfs.FindEffectiveVersioningPolicy(db, dir.ID())
+			require.NoError(t, err)
+			assert.Nil(t, policy)
+		})
 	}
 }
 
@@ -747,6 +885,10 @@ func (d *diskImpl) DiskQuota() int64 {
 	return diskQuota
 }
 
+func (d *diskImpl) DeduplicatedUsage() (int64, error) {
+	return 0, nil
+}
+
 func (h H) String() string {
 	return printH(h, "", 0)
 }
@@ -863,6 +1005,7 @@ func makeAferoFS(t *testing.T) vfs.VFS {
 	g, _ := errgroup.WithContext(context.Background())
 	couchdb.DefineIndexes(g, db, couchdb.IndexesByDoctype(consts.Files))
 	couchdb.DefineViews(g, db, couchdb.ViewsByDoctype(consts.Files))
+	couchdb.DefineViews(g, db, couchdb.ViewsByDoctype(consts.FilesBlobs))
 
 	require.NoError(t, g.Wait())
 	require.NoError(t, aferoFs.InitFs())
@@ -896,6 +1039,7 @@ func makeSwiftFS(t *testing.T) vfs.VFS {
 	g, _ := errgroup.WithContext(context.Background())
 	couchdb.DefineIndexes(g, db, couchdb.IndexesByDoctype(consts.Files))
 	couchdb.DefineViews(g, db, couchdb.ViewsByDoctype(consts.Files))
+	couchdb.DefineViews(g, db, couchdb.ViewsByDoctype(consts.FilesBlobs))
 	require.NoError(t, g.Wait())
 
 	require.NoError(t, swiftFs.InitFs())
@@ -909,3 +1053,16 @@ func makeSwiftFS(t *testing.T) vfs.VFS {
 
 	return swiftFs
 }
+
+func readFileByPath(fs vfs.VFS, name string) ([]byte, error) {
+	doc, err := fs.FileByPath(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.OpenFile(doc)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
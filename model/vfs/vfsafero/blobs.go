@@ -0,0 +1,367 @@
+package vfsafero
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/gofrs/uuid/v5"
+	"github.com/spf13/afero"
+)
+
+// When the deduplication is enabled, a content shared by several files and
+// versions is stored once on the local file system, and the files and
+// versions are hard links to it. A link to the content is also kept in the
+// blobs directory, so that it can be found from its checksum for the next
+// copies. The holders of a blob are the file IDs for the current content of
+// a file, and the version IDs for the old versions.
+
+func pathForBlob(id string) string {
+	return path.Join(vfs.BlobsDirName, id[:2], id)
+}
+
+// canShare returns true if the contents can be shared with hard links. It is
+// not possible for the in-memory file system.
+func (afs *aferoVFS) canShare() bool {
+	if !vfs.DeduplicationEnabled() {
+		return false
+	}
+	_, ok := afs.fs.(*afero.BasePathFs)
+	return ok
+}
+
+func (afs *aferoVFS) link(oldname, newname string) error {
+	base := afs.fs.(*afero.BasePathFs)
+	oldpath, err := base.RealPath(oldname)
+	if err != nil {
+		return err
+	}
+	newpath, err := base.RealPath(newname)
+	if err != nil {
+		return err
+	}
+	return os.Link(oldpath, newpath)
+}
+
+// shareContent creates dstPath as a link to the blob for the given content,
+// and adds dstKey to the holders of the blob. If there is no blob for this
+// content yet, it is created from srcPath, with srcKey as holder. It returns
+// false if the content can't be shared, and the caller should then copy it.
+func (afs *aferoVFS) shareContent(md5sum []byte, size int64, srcPath, srcKey, dstPath, dstKey string) (bool, error) {
+	if !afs.canShare() || len(md5sum) == 0 {
+		return false, nil
+	}
+	blob, err := vfs.FindBlob(afs, md5sum, size)
+	if err != nil {
+		return false, err
+	}
+
+	blobPath := pathForBlob(vfs.BlobID(md5sum, size))
+	keys := []string{dstKey}
+	if blob == nil {
+		if srcPath == "" {
+			return false, nil
+		}
+		_ = afs.fs.MkdirAll(filepath.Dir(blobPath), 0755)
+		// A link may have been left by a previous blob with the same content
+		_ = afs.fs.Remove(blobPath)
+		if err := afs.link(srcPath, blobPath); err != nil {
+			return false, err
+		}
+		keys = append(keys, srcKey)
+	}
+
+	_ = afs.fs.MkdirAll(filepath.Dir(dstPath), 0755)
+	if err := afs.link(blobPath, dstPath); err != nil {
+		if os.IsNotExist(err) && blob != nil {
+			// The blob has been lost, the content will be copied
+			return false, nil
+		}
+		return false, err
+	}
+	if _, err := vfs.AddBlobHolders(afs, md5sum, size, keys...); err != nil {
+		_ = afs.fs.Remove(dstPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// releaseBlobs removes the given keys from the holders of the blobs, and
+// removes the links to the blobs that are no longer shared. The content is
+// still on disk if a file or version has a link to it.
+func (afs *aferoVFS) releaseBlobs(keys []string) error {
+	if !vfs.DeduplicationEnabled() {
+		return nil
+	}
+	orphans, err := vfs.ReleaseBlobHolders(afs, keys)
+	for _, blob := range orphans {
+		_ = afs.fs.Remove(pathForBlob(blob.DocID))
+	}
+	return err
+}
+
+// moveBlobHolder must be called when a content that may be shared is moved,
+// like when the content of a file becomes a version.
+func (afs *aferoVFS) moveBlobHolder(from, to string) error {
+	if !vfs.DeduplicationEnabled() {
+		return nil
+	}
+	return vfs.MoveBlobHolder(afs, from, to)
+}
+
+// blobKeysFor returns the keys of the file and its versions that may hold a
+// blob. The file is skipped if fileID is empty.
+func blobKeysFor(fileID string, versions []*vfs.Version) []string {
+	keys := make([]string, 0, len(versions)+1)
+	if fileID != "" {
+		keys = append(keys, fileID)
+	}
+	for _, v := range versions {
+		keys = append(keys, v.DocID)
+	}
+	return keys
+}
+
+// deduplicateUpload replaces the content of a file that has just been
+// uploaded by a link to the same content, if this content is already stored
+// for another file or version.
+func (afs *aferoVFS) deduplicateUpload(doc *vfs.FileDoc, docPath string) error {
+	if !afs.canShare() || len(doc.MD5Sum) == 0 ||
+		strings.HasPrefix(docPath, vfs.TrashDirName+"/") {
+		return nil
+	}
+	blob, err := vfs.FindBlob(afs, doc.MD5Sum, doc.ByteSize)
+	if err != nil {
+		return err
+	}
+	var srcPath, srcKey string
+	if blob == nil {
+		other, err := vfs.FindFileWithContent(afs, doc)
+		if err != nil || other == nil {
+			return err
+		}
+		if srcPath, err = afs.Indexer.FilePath(other); err != nil {
+			return err
+		}
+		srcKey = other.DocID
+	}
+	return afs.replaceByLink(doc.MD5Sum, doc.ByteSize, srcPath, srcKey, docPath, doc.DocID)
+}
+
+// deduplicateImportedVersion replaces the content of a version that has just
+// been imported by a link to the same content, if this content is already
+// stored in a blob.
+func (afs *aferoVFS) deduplicateImportedVersion(version *vfs.Version, vPath string) error {
+	if !afs.canShare() || len(version.MD5Sum) == 0 {
+		return nil
+	}
+	blob, err := vfs.FindBlob(afs, version.MD5Sum, version.ByteSize)
+	if err != nil || blob == nil {
+		return err
+	}
+	return afs.replaceByLink(version.MD5Sum, version.ByteSize, "", "", vPath, version.DocID)
+}
+
+// replaceByLink replaces the content at dstPath by a link to the blob for the
+// given content (created from srcPath if there is no blob yet), but only if
+// the bytes are the same: the checksum and the size are used to find the
+// candidates, not to prove that two contents are equal.
+func (afs *aferoVFS) replaceByLink(md5sum []byte, size int64, srcPath, srcKey, dstPath, dstKey string) error {
+	candidate := srcPath
+	if candidate == "" {
+		candidate = pathForBlob(vfs.BlobID(md5sum, size))
+	}
+	if same, err := afs.sameContent(dstPath, candidate); err != nil || !same {
+		return err
+	}
+
+	// The link is created next to the blobs, and then replaces the content,
+	// so that the file always has a content.
+	tmpPath := path.Join(vfs.BlobsDirName, "tmp-"+strings.ReplaceAll(dstKey, "/", "-"))
+	_ = afs.fs.Remove(tmpPath)
+	shared, err := afs.shareContent(md5sum, size, srcPath, srcKey, tmpPath, dstKey)
+	if err != nil || !shared {
+		return err
+	}
+	if err := afs.fs.Rename(tmpPath, dstPath); err != nil {
+		_ = afs.fs.Remove(tmpPath)
+		_ = afs.releaseBlobs([]string{dstKey})
+		return err
+	}
+	return nil
+}
+
+// unshareContent gives its own copy of the content to the file at docPath, if
+// this content is a link to a blob. It must be called before changing the
+// mode of the file, as the mode is shared by all the links to the same
+// content, including the other files and versions.
+func (afs *aferoVFS) unshareContent(doc *vfs.FileDoc, docPath string) error {
+	if !afs.canShare() || len(doc.MD5Sum) == 0 {
+		return nil
+	}
+	blob, err := vfs.FindBlob(afs, doc.MD5Sum, doc.ByteSize)
+	if err != nil || blob == nil || !blob.HasHolder(doc.DocID) {
+		return err
+	}
+
+	tmpPath := path.Join(vfs.BlobsDirName, "tmp-"+doc.DocID)
+	_ = afs.fs.Remove(tmpPath)
+	content, err := afs.fs.Open(docPath)
+	if err != nil {
+		return err
+	}
+	err = afero.WriteReader(afs.fs, tmpPath, content)
+	if errc := content.Close(); err == nil {
+		err = errc
+	}
+	if err == nil {
+		err = afs.fs.Rename(tmpPath, docPath)
+	}
+	if err != nil {
+		_ = afs.fs.Remove(tmpPath)
+		return err
+	}
+	return afs.releaseBlobs([]string{doc.DocID})
+}
+
+// sameContent returns true if the files at the two paths have the same bytes.
+func (afs *aferoVFS) sameContent(pathA, pathB string) (bool, error) {
+	a, err := afs.fs.Open(pathA)
+	if err != nil {
+		return false, err
+	}
+	defer a.Close()
+	b, err := afs.fs.Open(pathB)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer b.Close()
+	return vfs.SameContent(a, b)
+}
+
+// copyFileShared creates the copy of a file as a link to its content. It
+// returns false if the content can't be shared.
+func (afs *aferoVFS) copyFileShared(olddoc, newdoc *vfs.FileDoc) (bool, error) {
+	if !afs.canShare() {
+		return false, nil
+	}
+	srcPath, err := afs.Indexer.FilePath(olddoc)
+	if err != nil {
+		return false, err
+	}
+	newpath, err := afs.Indexer.FilePath(newdoc)
+	if err != nil {
+		return false, err
+	}
+	if strings.HasPrefix(newpath, vfs.TrashDirName+"/") {
+		return false, nil
+	}
+
+	if newdoc.DocID == "" {
+		uid, err := uuid.NewV7()
+		if err != nil {
+			return false, err
+		}
+		newdoc.DocID = uid.String()
+	}
+	newdoc.ByteSize = olddoc.ByteSize
+	newdoc.MD5Sum = olddoc.MD5Sum
+
+	shared, err := afs.shareContent(olddoc.MD5Sum, olddoc.ByteSize, srcPath, olddoc.DocID, newpath, newdoc.DocID)
+	if err != nil || !shared {
+		return false, err
+	}
+	if err := afs.Indexer.CreateNamedFileDoc(newdoc); err != nil {
+		_ = afs.fs.Remove(newpath)
+		_ = afs.releaseBlobs([]string{newdoc.DocID})
+		return false, err
+	}
+	return true, nil
+}
+
+// copyFromOtherFSShared creates a new file as a link to a content that is
+// already shared on this VFS, instead of copying it from the other VFS. It
+// returns false if the content can't be shared.
+func (afs *aferoVFS) copyFromOtherFSShared(newdoc *vfs.FileDoc, srcFS vfs.Fs, srcDoc *vfs.FileDoc) (bool, error) {
+	if !afs.canShare() {
+		return false, nil
+	}
+	blob, err := vfs.FindBlob(afs, srcDoc.MD5Sum, srcDoc.ByteSize)
+	if err != nil || blob == nil {
+		return false, err
+	}
+	if same, err := afs.sameContentAsOtherFS(blob, srcFS, srcDoc); err != nil || !same {
+		return false, err
+	}
+
+	if lockerr := afs.mu.Lock(); lockerr != nil {
+		return false, lockerr
+	}
+	defer afs.mu.Unlock()
+
+	newdoc.ByteSize = srcDoc.ByteSize
+	newdoc.MD5Sum = srcDoc.MD5Sum
+	newsize, _, capsize, err := vfs.CheckAvailableDiskSpace(afs, newdoc)
+	if err != nil {
+		return false, err
+	}
+	exists, err := afs.Indexer.DirChildExists(newdoc.DirID, newdoc.DocName)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		return false, os.ErrExist
+	}
+	newpath, err := afs.Indexer.FilePath(newdoc)
+	if err != nil {
+		return false, err
+	}
+	if strings.HasPrefix(newpath, vfs.TrashDirName+"/") {
+		return false, vfs.ErrParentInTrash
+	}
+	if newdoc.DocID == "" {
+		uid, err := uuid.NewV7()
+		if err != nil {
+			return false, err
+		}
+		newdoc.DocID = uid.String()
+	}
+
+	shared, err := afs.shareContent(srcDoc.MD5Sum, srcDoc.ByteSize, "", "", newpath, newdoc.DocID)
+	if err != nil || !shared {
+		return false, err
+	}
+	if err := afs.Indexer.CreateNamedFileDoc(newdoc); err != nil {
+		_ = afs.fs.Remove(newpath)
+		_ = afs.releaseBlobs([]string{newdoc.DocID})
+		return false, err
+	}
+	if capsize > 0 && newsize >= capsize {
+		vfs.PushDiskQuotaAlert(afs, true)
+	}
+	return true, nil
+}
+
+// sameContentAsOtherFS returns true if the content of the blob is the same as
+// the content of the file on the other VFS.
+func (afs *aferoVFS) sameContentAsOtherFS(blob *vfs.Blob, srcFS vfs.Fs, srcDoc *vfs.FileDoc) (bool, error) {
+	content, err := srcFS.OpenFile(srcDoc)
+	if err != nil {
+		return false, err
+	}
+	defer content.Close()
+	f, err := afs.fs.Open(pathForBlob(blob.DocID))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return vfs.SameContent(content, f)
+}
@@ -75,7 +75,9 @@ func (afs *aferoVFS) checkFiles(
 
 		if fullpath == vfs.WebappsDirName ||
 			fullpath == vfs.KonnectorsDirName ||
-			fullpath == vfs.ThumbsDirName {
+			fullpath == vfs.ThumbsDirName ||
+			fullpath == vfs.UploadsDirName ||
+			fullpath == vfs.BlobsDirName {
 			return filepath.SkipDir
 		}
 
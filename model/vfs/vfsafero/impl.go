@@ -281,6 +281,14 @@ func (afs *aferoVFS) CopyFile(olddoc, newdoc *vfs.FileDoc) (err error) {
 		return err
 	}
 
+	// With deduplication, the copy is a link to the same content
+	if shared, err := afs.copyFileShared(olddoc, newdoc); err != nil || shared {
+		if shared && capsize > 0 && newsize >= capsize {
+			vfs.PushDiskQuotaAlert(afs, true)
+		}
+		return err
+	}
+
 	f, err := afero.TempFile(afs.fs, "/", newdoc.DocName)
 	if err != nil {
 		return err
@@ -350,11 +358,13 @@ func (afs *aferoVFS) DissociateFile(src, dst *vfs.FileDoc) error {
 	if err = afs.Indexer.DeleteFileDoc(src); err != nil {
 		return err
 	}
+	_ = afs.moveBlobHolder(src.DocID, dst.DocID)
 	_ = afs.fs.RemoveAll(pathForVersions(src.DocID))
 	versions, err := vfs.VersionsFor(afs, src.DocID)
 	if err != nil {
 		return nil
 	}
+	_ = afs.releaseBlobs(blobKeysFor("", versions))
 	if from != to {
 		_ = afs.Indexer.BatchDeleteVersions(versions)
 	}
@@ -394,7 +404,7 @@ func (afs *aferoVFS) DestroyDirContent(doc *vfs.DirDoc, push func(vfs.TrashJourn
 		return lockerr
 	}
 	defer afs.mu.Unlock()
-	diskUsage, _ := afs.DiskUsage()
+	diskUsage, _ := vfs.QuotaUsage(afs)
 	files, destroyed, err := afs.Indexer.DeleteDirDocAndContent(doc, true)
 	if err != nil {
 		return err
@@ -416,12 +426,15 @@ func (afs *aferoVFS) DestroyDirContent(doc *vfs.DirDoc, push func(vfs.TrashJourn
 		}
 	}
 	var allVersions []*vfs.Version
+	var blobKeys []string
 	for _, file := range files {
 		_ = afs.fs.RemoveAll(pathForVersions(file.DocID))
 		if versions, err := vfs.VersionsFor(afs, file.DocID); err == nil {
 			allVersions = append(allVersions, versions...)
+			blobKeys = append(blobKeys, blobKeysFor(file.DocID, versions)...)
 		}
 	}
+	_ = afs.releaseBlobs(blobKeys)
 	return afs.Indexer.BatchDeleteVersions(allVersions)
 }
 
@@ -430,7 +443,7 @@ func (afs *aferoVFS) DestroyDirAndContent(doc *vfs.DirDoc, push func(vfs.TrashJo
 		return lockerr
 	}
 	defer afs.mu.Unlock()
-	diskUsage, _ := afs.DiskUsage()
+	diskUsage, _ := vfs.QuotaUsage(afs)
 	files, destroyed, err := afs.Indexer.DeleteDirDocAndContent(doc, false)
 	if err != nil {
 		return err
@@ -440,12 +453,15 @@ func (afs *aferoVFS) DestroyDirAndContent(doc *vfs.DirDoc, push func(vfs.TrashJo
 		return err
 	}
 	var allVersions []*vfs.Version
+	var blobKeys []string
 	for _, file := range files {
 		_ = afs.fs.RemoveAll(pathForVersions(file.DocID))
 		if versions, err := vfs.VersionsFor(afs, file.DocID); err == nil {
 			allVersions = append(allVersions, versions...)
+			blobKeys = append(blobKeys, blobKeysFor(file.DocID, versions)...)
 		}
 	}
+	_ = afs.releaseBlobs(blobKeys)
 	return afs.Indexer.BatchDeleteVersions(allVersions)
 }
 
@@ -454,7 +470,7 @@ func (afs *aferoVFS) DestroyFile(doc *vfs.FileDoc) error {
 		return lockerr
 	}
 	defer afs.mu.Unlock()
-	diskUsage, _ := afs.DiskUsage()
+	diskUsage, _ := vfs.QuotaUsage(afs)
 	name, err := afs.Indexer.FilePath(doc)
 	if err != nil {
 		return err
@@ -472,6 +488,7 @@ func (afs *aferoVFS) DestroyFile(doc *vfs.FileDoc) error {
 		return err
 	}
 	_ = afs.fs.RemoveAll(pathForVersions(doc.DocID))
+	_ = afs.releaseBlobs(blobKeysFor(doc.DocID, versions))
 	return afs.Indexer.BatchDeleteVersions(versions)
 }
 
@@ -519,7 +536,7 @@ func (afs *aferoVFS) ImportFileVersion(version *vfs.Version, content io.ReadClos
 
 	diskQuota := afs.DiskQuota()
 	if diskQuota > 0 {
-		diskUsage, err := afs.DiskUsage()
+		diskUsage, err := vfs.QuotaUsage(afs)
 		if err != nil {
 			return err
 		}
@@ -540,7 +557,15 @@ func (afs *aferoVFS) ImportFileVersion(version *vfs.Version, content io.ReadClos
 		return err
 	}
 
-	return afs.Indexer.CreateVersion(version)
+	// With deduplication, the version can be a link to a known content
+	_ = afs.deduplicateImportedVersion(version, vPath)
+
+	if err := afs.Indexer.CreateVersion(version); err != nil {
+		_ = afs.fs.Remove(vPath)
+		_ = afs.releaseBlobs([]string{version.DocID})
+		return err
+	}
+	return nil
 }
 
 func (afs *aferoVFS) RevertFileVersion(doc *vfs.FileDoc, version *vfs.Version) error {
@@ -577,8 +602,13 @@ func (afs *aferoVFS) RevertFileVersion(doc *vfs.FileDoc, version *vfs.Version) e
 
 	_ = afs.Indexer.DeleteVersion(version)
 
+	// The contents have been swapped, and so their holders
+	_ = afs.moveBlobHolder(doc.DocID, save.DocID)
+	_ = afs.moveBlobHolder(version.DocID, doc.DocID)
+
 	if err = afs.Indexer.CreateVersion(save); err != nil {
 		_ = afs.fs.Remove(savepath)
+		_ = afs.releaseBlobs([]string{save.DocID})
 	}
 
 	return nil
@@ -589,6 +619,13 @@ func (afs *aferoVFS) CopyFileFromOtherFS(
 	srcFS vfs.Fs,
 	srcDoc *vfs.FileDoc,
 ) error {
+	// With deduplication, a known content is not copied again
+	if olddoc == nil {
+		if shared, err := afs.copyFromOtherFSShared(newdoc, srcFS, srcDoc); err != nil || shared {
+			return err
+		}
+	}
+
 	content, err := srcFS.OpenFile(srcDoc)
 	if err != nil {
 		return err
@@ -637,6 +674,9 @@ func (afs *aferoVFS) UpdateFileDoc(olddoc, newdoc *vfs.FileDoc) error {
 		if err != nil {
 			return err
 		}
+		if err = afs.unshareContent(newdoc, newpath); err != nil {
+			return err
+		}
 		err = afs.fs.Chmod(newpath, newdoc.Mode())
 		if err != nil {
 			return err
@@ -928,11 +968,23 @@ func (f *aferoFileCreation) Close() (err error) {
 			vPath := pathForVersion(v)
 			_ = f.afs.fs.Remove(vPath)
 		}
+		// The previous content, if it was shared, is now held by the version,
+		// or has been removed.
+		if actionV == vfs.KeepCandidateVersion {
+			_ = f.afs.moveBlobHolder(olddoc.DocID, v.DocID)
+		} else {
+			_ = f.afs.releaseBlobs([]string{olddoc.DocID})
+		}
 		for _, old := range toClean {
 			_ = cleanOldVersion(f.afs, old)
 		}
+	} else if olddoc != nil {
+		_ = f.afs.releaseBlobs([]string{olddoc.DocID})
 	}
 
+	// With deduplication, the new content can be shared with another file
+	_ = f.afs.deduplicateUpload(newdoc, newpath)
+
 	if f.capsize > 0 && f.size >= f.capsize {
 		vfs.PushDiskQuotaAlert(f.afs, true)
 	}
@@ -1011,6 +1063,7 @@ func cleanOldVersion(afs *aferoVFS, version *vfs.Version) error {
 		return err
 	}
 	vPath := pathForVersion(version)
+	_ = afs.releaseBlobs([]string{version.DocID})
 	return afs.fs.Remove(vPath)
 }
 
@@ -1041,6 +1094,11 @@ func (afs *aferoVFS) ClearOldVersions() error {
 	if err := afs.Indexer.BatchDeleteVersions(versions); err != nil {
 		return err
 	}
+	keys := make([]string, len(versions))
+	for i, v := range versions {
+		keys[i] = v.DocID
+	}
+	_ = afs.releaseBlobs(keys)
 	return afs.fs.RemoveAll(vfs.VersionsDirName)
 }
 
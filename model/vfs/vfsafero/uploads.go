@@ -0,0 +1,59 @@
+package vfsafero
+
+import (
+	"io"
+	"os"
+	"path"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+)
+
+func pathForUpload(uploadID string) string {
+	return path.Join(vfs.UploadsDirName, uploadID)
+}
+
+// StageUploadPart appends a part to the temporary file of a resumable upload.
+// The file is truncated at the given offset, in case a previous part was
+// written but not acknowledged to the client.
+func (afs *aferoVFS) StageUploadPart(uploadID string, offset int64, content io.Reader) (int64, error) {
+	if err := afs.fs.MkdirAll(vfs.UploadsDirName, 0755); err != nil {
+		return 0, err
+	}
+	f, err := afs.fs.OpenFile(pathForUpload(uploadID), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	if info.Size() < offset {
+		_ = f.Close()
+		return 0, vfs.ErrUploadOffsetMismatch
+	}
+	if err = f.Truncate(offset); err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		return 0, err
+	}
+	n, err := io.Copy(f, content)
+	if errc := f.Close(); err == nil {
+		err = errc
+	}
+	return n, err
+}
+
+func (afs *aferoVFS) OpenStagedUpload(uploadID string) (io.ReadCloser, error) {
+	return afs.fs.Open(pathForUpload(uploadID))
+}
+
+func (afs *aferoVFS) DeleteStagedUpload(uploadID string) error {
+	err := afs.fs.Remove(pathForUpload(uploadID))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
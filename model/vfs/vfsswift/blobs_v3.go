@@ -0,0 +1,163 @@
+package vfsswift
+
+import (
+	"errors"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/ncw/swift/v2"
+)
+
+// When the deduplication is enabled, a content shared by several files and
+// versions is stored once in a blob object, and the objects for the files and
+// versions are Swift symlinks to it. The holders of a blob are the names of
+// these symlinks, and the blob is removed when there is no more holder.
+
+// blobsPrefix is the prefix of the objects used for the shared contents.
+const blobsPrefix = "blobs/"
+
+// symlinkContentType is the content-type of the Swift symlinks.
+const symlinkContentType = "application/symlink"
+
+func makeBlobName(id string) string {
+	return blobsPrefix + id
+}
+
+func (sfs *swiftVFSV3) symlink(objName, blobName string) error {
+	_, err := sfs.c.ObjectSymlinkCreate(sfs.ctx, sfs.container, objName, "", sfs.container, blobName, "")
+	return err
+}
+
+// shareObject creates dstName as a symlink to the blob for the given content,
+// and adds it to the holders of the blob. If there is no blob for this content
+// yet, it is created by copying srcName, and srcName is replaced by a symlink
+// to it. It returns false if the content can't be shared, and the caller
+// should then copy it. The holders are added before the symlinks are created,
+// so that a blob is never removed while a symlink points to it.
+func (sfs *swiftVFSV3) shareObject(md5sum []byte, size int64, srcName, dstName string) (bool, error) {
+	if !vfs.DeduplicationEnabled() || len(md5sum) == 0 {
+		return false, nil
+	}
+	blob, err := vfs.FindBlob(sfs, md5sum, size)
+	if err != nil {
+		return false, err
+	}
+
+	blobName := makeBlobName(vfs.BlobID(md5sum, size))
+	if blob == nil {
+		if srcName == "" {
+			return false, nil
+		}
+		if _, err := sfs.c.ObjectCopy(sfs.ctx, sfs.container, srcName, sfs.container, blobName, nil); err != nil {
+			return false, err
+		}
+		if _, err := vfs.AddBlobHolders(sfs, md5sum, size, srcName, dstName); err != nil {
+			_ = sfs.c.ObjectDelete(sfs.ctx, sfs.container, blobName)
+			return false, err
+		}
+		if err := sfs.symlink(srcName, blobName); err != nil {
+			sfs.releaseBlobs([]string{srcName, dstName})
+			return false, err
+		}
+	} else if _, err := vfs.AddBlobHolders(sfs, md5sum, size, dstName); err != nil {
+		return false, err
+	}
+
+	if err := sfs.symlink(dstName, blobName); err != nil {
+		sfs.releaseBlobs([]string{dstName})
+		return false, err
+	}
+	return true, nil
+}
+
+// deduplicateUpload replaces the object of a file that has just been uploaded
+// by a symlink to the same content, if this content is already stored for
+// another file or version.
+func (sfs *swiftVFSV3) deduplicateUpload(doc *vfs.FileDoc, objName string) error {
+	if !vfs.DeduplicationEnabled() || len(doc.MD5Sum) == 0 || doc.Trashed {
+		return nil
+	}
+	blob, err := vfs.FindBlob(sfs, doc.MD5Sum, doc.ByteSize)
+	if err != nil {
+		return err
+	}
+	var srcName string
+	if blob == nil {
+		other, err := vfs.FindFileWithContent(sfs, doc)
+		if err != nil || other == nil {
+			return err
+		}
+		srcName = MakeObjectNameV3(other.DocID, other.InternalID)
+	}
+	return sfs.replaceBySymlink(doc.MD5Sum, doc.ByteSize, srcName, objName)
+}
+
+// deduplicateWithBlob replaces an object that has just been written, like
+// an imported version or a file copied from another instance, by a symlink to
+// the same content, if this content is already stored in a blob.
+func (sfs *swiftVFSV3) deduplicateWithBlob(md5sum []byte, size int64, objName string) error {
+	if !vfs.DeduplicationEnabled() || len(md5sum) == 0 {
+		return nil
+	}
+	blob, err := vfs.FindBlob(sfs, md5sum, size)
+	if err != nil || blob == nil {
+		return err
+	}
+	return sfs.replaceBySymlink(md5sum, size, "", objName)
+}
+
+// replaceBySymlink replaces the object dstName by a symlink to the blob for
+// the given content (created from srcName if there is no blob yet), but only
+// if the bytes are the same: the checksum and the size are used to find the
+// candidates, not to prove that two contents are equal.
+func (sfs *swiftVFSV3) replaceBySymlink(md5sum []byte, size int64, srcName, dstName string) error {
+	candidate := srcName
+	if candidate == "" {
+		candidate = makeBlobName(vfs.BlobID(md5sum, size))
+	}
+	if same, err := sfs.sameContent(dstName, candidate); err != nil || !same {
+		return err
+	}
+	_, err := sfs.shareObject(md5sum, size, srcName, dstName)
+	return err
+}
+
+// sameContent returns true if the two objects have the same bytes.
+func (sfs *swiftVFSV3) sameContent(nameA, nameB string) (bool, error) {
+	a, _, err := sfs.c.ObjectOpen(sfs.ctx, sfs.container, nameA, false, nil)
+	if err != nil {
+		return false, err
+	}
+	defer a.Close()
+	b, _, err := sfs.c.ObjectOpen(sfs.ctx, sfs.container, nameB, false, nil)
+	if errors.Is(err, swift.ObjectNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer b.Close()
+	return vfs.SameContent(a, b)
+}
+
+// releaseBlobs must be called after the objects have been deleted: they are
+// removed from the holders of the blobs, and the blobs that are no longer
+// used are deleted.
+func (sfs *swiftVFSV3) releaseBlobs(objNames []string) {
+	if !vfs.DeduplicationEnabled() {
+		return
+	}
+	orphans, err := vfs.ReleaseBlobHolders(sfs, objNames)
+	if err != nil {
+		sfs.log.Warnf("Cannot release blobs: %s", err)
+	}
+	if len(orphans) == 0 {
+		return
+	}
+	blobNames := make([]string, len(orphans))
+	for i, blob := range orphans {
+		blobNames[i] = makeBlobName(blob.DocID)
+	}
+	if err := deleteContainerFiles(sfs.ctx, sfs.c, sfs.container, blobNames); err != nil {
+		sfs.log.Warnf("Cannot delete blobs: %s", err)
+	}
+}
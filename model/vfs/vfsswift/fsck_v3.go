@@ -91,7 +91,9 @@ func (sfs *swiftVFSV3) checkFiles(
 			return nil, err
 		}
 		for _, obj := range objs {
-			if obj.Name == "avatar" {
+			if obj.Name == "avatar" ||
+				strings.HasPrefix(obj.Name, uploadsPrefix) ||
+				strings.HasPrefix(obj.Name, blobsPrefix) {
 				continue
 			}
 			if strings.HasPrefix(obj.Name, "thumbs/") {
@@ -122,6 +124,18 @@ func (sfs *swiftVFSV3) checkFiles(
 				continue
 			}
 			docID, internalID := makeDocIDV3(obj.Name)
+			// The content of a symlink to a shared blob is not checked
+			if obj.ContentType == symlinkContentType {
+				key := docID + "/" + internalID
+				if _, ok := versions[key]; ok {
+					delete(versions, key)
+					continue
+				}
+				if _, ok := entries[key]; ok {
+					delete(entries, key)
+					continue
+				}
+			}
 			if v, ok := versions[docID+"/"+internalID]; ok {
 				var md5sum []byte
 				md5sum, err = hex.DecodeString(obj.Hash)
@@ -286,16 +286,23 @@ func (sfs *swiftVFSV3) CopyFile(olddoc, newdoc *vfs.FileDoc) error {
 	// Copy the file
 	srcName := MakeObjectNameV3(olddoc.DocID, olddoc.InternalID)
 	dstName := MakeObjectNameV3(newdoc.DocID, newdoc.InternalID)
-	headers := swift.Metadata{
-		"creation-name": newdoc.Name(),
-		"created-at":    newdoc.CreatedAt.Format(time.RFC3339),
-		"copied-from":   olddoc.ID(),
-	}.ObjectHeaders()
-	if _, err := sfs.c.ObjectCopy(sfs.ctx, sfs.container, srcName, sfs.container, dstName, headers); err != nil {
+	shared, err := sfs.shareObject(olddoc.MD5Sum, olddoc.ByteSize, srcName, dstName)
+	if err != nil {
 		return err
 	}
+	if !shared {
+		headers := swift.Metadata{
+			"creation-name": newdoc.Name(),
+			"created-at":    newdoc.CreatedAt.Format(time.RFC3339),
+			"copied-from":   olddoc.ID(),
+		}.ObjectHeaders()
+		if _, err := sfs.c.ObjectCopy(sfs.ctx, sfs.container, srcName, sfs.container, dstName, headers); err != nil {
+			return err
+		}
+	}
 	if err := sfs.Indexer.CreateNamedFileDoc(newdoc); err != nil {
 		_ = sfs.c.ObjectDelete(sfs.ctx, sfs.container, dstName)
+		sfs.releaseBlobs([]string{dstName})
 		return err
 	}
 
@@ -383,7 +390,7 @@ func (sfs *swiftVFSV3) destroyDir(doc *vfs.DirDoc, push func(vfs.TrashJournal) e
 		return lockerr
 	}
 	defer sfs.mu.Unlock()
-	diskUsage, _ := sfs.Indexer.DiskUsage()
+	diskUsage, _ := vfs.QuotaUsage(sfs)
 	files, destroyed, err := sfs.Indexer.DeleteDirDocAndContent(doc, onlyContent)
 	if err != nil {
 		return err
@@ -422,7 +429,7 @@ func (sfs *swiftVFSV3) DestroyFile(doc *vfs.FileDoc) error {
 }
 
 func (sfs *swiftVFSV3) destroyFileLocked(doc *vfs.FileDoc) error {
-	diskUsage, _ := sfs.Indexer.DiskUsage()
+	diskUsage, _ := vfs.QuotaUsage(sfs)
 	objNames := []string{
 		MakeObjectNameV3(doc.DocID, doc.InternalID),
 	}
@@ -455,13 +462,14 @@ func (sfs *swiftVFSV3) destroyFileLocked(doc *vfs.FileDoc) error {
 	if errb != nil {
 		sfs.log.Warnf("DestroyFile failed on BulkDelete: %s", errb)
 	}
+	sfs.releaseBlobs(objNames)
 	vfs.DiskQuotaAfterDestroy(sfs, diskUsage, destroyed)
 	return nil
 }
 
 func (sfs *swiftVFSV3) EnsureErased(journal vfs.TrashJournal) error {
 	// No lock needed
-	diskUsage, _ := sfs.Indexer.DiskUsage()
+	diskUsage, _ := vfs.QuotaUsage(sfs)
 	objNames := journal.ObjectNames
 	var errm error
 	var destroyed int64
@@ -493,6 +501,7 @@ func (sfs *swiftVFSV3) EnsureErased(journal vfs.TrashJournal) error {
 		sfs.log.Warnf("EnsureErased failed on deleteContainerFiles: %s", err)
 		errm = multierror.Append(errm, err)
 	}
+	sfs.releaseBlobs(objNames)
 	vfs.DiskQuotaAfterDestroy(sfs, diskUsage, destroyed)
 	return errm
 }
@@ -541,7 +550,7 @@ func (sfs *swiftVFSV3) ImportFileVersion(version *vfs.Version, content io.ReadCl
 
 	diskQuota := sfs.DiskQuota()
 	if diskQuota > 0 {
-		diskUsage, err := sfs.DiskUsage()
+		diskUsage, err := vfs.QuotaUsage(sfs)
 		if err != nil {
 			return err
 		}
@@ -576,7 +585,15 @@ func (sfs *swiftVFSV3) ImportFileVersion(version *vfs.Version, content io.ReadCl
 		return err
 	}
 
-	return sfs.Indexer.CreateVersion(version)
+	// With deduplication, the version can be a symlink to a known content
+	_ = sfs.deduplicateWithBlob(version.MD5Sum, version.ByteSize, objName)
+
+	if err := sfs.Indexer.CreateVersion(version); err != nil {
+		_ = sfs.c.ObjectDelete(sfs.ctx, sfs.container, objName)
+		sfs.releaseBlobs([]string{objName})
+		return err
+	}
+	return nil
 }
 
 func (sfs *swiftVFSV3) RevertFileVersion(doc *vfs.FileDoc, version *vfs.Version) error {
@@ -656,6 +673,8 @@ func (sfs *swiftVFSV3) CopyFileFromOtherFS(
 	if _, err := sfs.c.ObjectCopy(sfs.ctx, srcContainer, srcName, sfs.container, dstName, nil); err != nil {
 		return err
 	}
+	// With deduplication, a known content is stored only once
+	_ = sfs.deduplicateWithBlob(srcDoc.MD5Sum, srcDoc.ByteSize, dstName)
 
 	var v *vfs.Version
 	if olddoc != nil {
@@ -685,6 +704,7 @@ func (sfs *swiftVFSV3) CopyFileFromOtherFS(
 			}
 			objName := MakeObjectNameV3(newdoc.DocID, internalID)
 			_ = sfs.c.ObjectDelete(sfs.ctx, sfs.container, objName)
+			sfs.releaseBlobs([]string{objName})
 		}
 		for _, old := range toClean {
 			_ = cleanOldVersion(sfs, newdoc.DocID, old)
@@ -991,6 +1011,7 @@ func (f *swiftFileCreationV3) Close() (err error) {
 			if err := f.fs.c.ObjectDelete(f.fs.ctx, f.fs.container, objName); err != nil {
 				f.fs.log.Warnf("Could not delete previous version %q: %s", objName, err.Error())
 			}
+			f.fs.releaseBlobs([]string{objName})
 		}
 		for _, old := range toClean {
 			if err := cleanOldVersion(f.fs, newdoc.DocID, old); err != nil {
@@ -999,6 +1020,12 @@ func (f *swiftFileCreationV3) Close() (err error) {
 		}
 	}
 
+	// With deduplication, the new content can be shared with another file
+	objName := MakeObjectNameV3(newdoc.DocID, newdoc.InternalID)
+	if err := f.fs.deduplicateUpload(newdoc, objName); err != nil {
+		f.fs.log.Warnf("Could not deduplicate %s: %s", objName, err)
+	}
+
 	if f.capsize > 0 && f.size >= f.capsize {
 		vfs.PushDiskQuotaAlert(f.fs, true)
 	}
@@ -1023,7 +1050,11 @@ func cleanOldVersion(sfs *swiftVFSV3, fileID string, v *vfs.Version) error {
 		internalID = parts[1]
 	}
 	objName := MakeObjectNameV3(fileID, internalID)
-	return sfs.c.ObjectDelete(sfs.ctx, sfs.container, objName)
+	if err := sfs.c.ObjectDelete(sfs.ctx, sfs.container, objName); err != nil {
+		return err
+	}
+	sfs.releaseBlobs([]string{objName})
+	return nil
 }
 
 func (sfs *swiftVFSV3) ClearOldVersions() error {
@@ -1031,7 +1062,7 @@ func (sfs *swiftVFSV3) ClearOldVersions() error {
 		return lockerr
 	}
 	defer sfs.mu.Unlock()
-	diskUsage, _ := sfs.Indexer.DiskUsage()
+	diskUsage, _ := vfs.QuotaUsage(sfs)
 	versions, err := sfs.Indexer.AllVersions()
 	if err != nil {
 		return err
@@ -1048,7 +1079,11 @@ func (sfs *swiftVFSV3) ClearOldVersions() error {
 		return err
 	}
 	vfs.DiskQuotaAfterDestroy(sfs, diskUsage, destroyed)
-	return deleteContainerFiles(sfs.ctx, sfs.c, sfs.container, objNames)
+	if err := deleteContainerFiles(sfs.ctx, sfs.c, sfs.container, objNames); err != nil {
+		return err
+	}
+	sfs.releaseBlobs(objNames)
+	return nil
 }
 
 type swiftFileOpenV3 struct {
@@ -0,0 +1,140 @@
+package vfsswift
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ncw/swift/v2"
+)
+
+// uploadsPrefix is the prefix of the objects used to stage the parts of the
+// resumable uploads.
+const uploadsPrefix = "uploads/"
+
+// makeUploadPartName returns the name of the object for a part of a resumable
+// upload. The offset is padded with zeros, so that the objects are listed in
+// the same order as the parts.
+func makeUploadPartName(uploadID string, offset int64) string {
+	return fmt.Sprintf("%s%s/%020d", uploadsPrefix, uploadID, offset)
+}
+
+func (sfs *swiftVFSV3) uploadParts(uploadID string) ([]swift.Object, error) {
+	opts := &swift.ObjectsOpts{Prefix: uploadsPrefix + uploadID + "/"}
+	return sfs.c.ObjectsAll(sfs.ctx, sfs.container, opts)
+}
+
+// StageUploadPart stores a part of a resumable upload as a separate object.
+// If a part was already written for this offset but not acknowledged to the
+// client, it is overwritten.
+func (sfs *swiftVFSV3) StageUploadPart(uploadID string, offset int64, content io.Reader) (int64, error) {
+	counter := &countingReader{r: content}
+	objName := makeUploadPartName(uploadID, offset)
+	_, err := sfs.c.ObjectPut(sfs.ctx, sfs.container, objName, counter, false, "", "application/octet-stream", nil)
+	if err != nil {
+		_ = sfs.c.ObjectDelete(sfs.ctx, sfs.container, objName)
+		return 0, err
+	}
+	return counter.n, nil
+}
+
+// OpenStagedUpload returns a reader that concatenates the parts of a
+// resumable upload. Only the parts that follow each other are read, in case a
+// part was written but not acknowledged before a retry with a different size.
+func (sfs *swiftVFSV3) OpenStagedUpload(uploadID string) (io.ReadCloser, error) {
+	objs, err := sfs.uploadParts(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var next int64
+	for _, obj := range objs {
+		idx := strings.LastIndex(obj.Name, "/")
+		offset, err := strconv.ParseInt(obj.Name[idx+1:], 10, 64)
+		if err != nil || offset != next {
+			continue
+		}
+		names = append(names, obj.Name)
+		next += obj.Bytes
+	}
+	return &swiftStagedUpload{fs: sfs, names: names}, nil
+}
+
+func (sfs *swiftVFSV3) DeleteStagedUpload(uploadID string) error {
+	objs, err := sfs.uploadParts(uploadID)
+	if err != nil {
+		if errors.Is(err, swift.ContainerNotFound) {
+			return nil
+		}
+		return err
+	}
+	if len(objs) == 0 {
+		return nil
+	}
+	objNames := make([]string, len(objs))
+	for i, obj := range objs {
+		objNames[i] = obj.Name
+	}
+	if _, err := sfs.c.BulkDelete(sfs.ctx, sfs.container, objNames); err != nil {
+		for _, objName := range objNames {
+			if err := sfs.c.ObjectDelete(sfs.ctx, sfs.container, objName); err != nil && !errors.Is(err, swift.ObjectNotFound) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// swiftStagedUpload reads the parts of a resumable upload one after the
+// other.
+type swiftStagedUpload struct {
+	fs      *swiftVFSV3
+	names   []string
+	current io.ReadCloser
+}
+
+func (s *swiftStagedUpload) Read(p []byte) (int, error) {
+	for {
+		if s.current == nil {
+			if len(s.names) == 0 {
+				return 0, io.EOF
+			}
+			f, _, err := s.fs.c.ObjectOpen(s.fs.ctx, s.fs.container, s.names[0], false, nil)
+			if err != nil {
+				return 0, err
+			}
+			s.current = f
+			s.names = s.names[1:]
+		}
+		n, err := s.current.Read(p)
+		if err == io.EOF {
+			_ = s.current.Close()
+			s.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (s *swiftStagedUpload) Close() error {
+	if s.current != nil {
+		return s.current.Close()
+	}
+	return nil
+}
@@ -33,6 +33,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/safehttp"
 	"github.com/cozy/cozy-stack/pkg/tlsclient"
 	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/cozy/cozy-stack/pkg/webpush"
 	"github.com/cozy/gomail"
 	"github.com/mitchellh/mapstructure"
 	"github.com/redis/go-redis/v9"
@@ -130,8 +131,12 @@ type Config struct {
 	CampaignMail           *gomail.DialerOptions
 	CampaignMailPerContext map[string]interface{}
 	Move                   Move
+	Backups                Backups
 	Notifications          Notifications
 	Flagship               Flagship
+	Audit                  Audit
+	Search                 Search
+	Schemas                Schemas
 
 	Lock              lock.Getter
 	Limiter           *limits.RateLimiter
@@ -228,6 +233,7 @@ type Fs struct {
 	DefaultLayout         int
 	CanQueryInfo          bool
 	AutoCleanTrashedAfter map[string]string
+	Deduplication         bool
 	Versioning            FsVersioning
 	Contexts              map[string]interface{}
 }
@@ -265,6 +271,33 @@ type Jobs struct {
 	DefaultDurationToKeep string
 }
 
+// Audit contains the configuration for the security audit log
+type Audit struct {
+	// Retention is how long the entries are kept, like "1Y" or "6M"
+	Retention string
+}
+
+// Search contains the configuration for the full-text search index
+type Search struct {
+	// Path is the directory where the indexes are stored. By default, it is
+	// a search directory next to the files when the VFS is on the local
+	// filesystem, and a search container when the VFS is on Swift.
+	Path string
+	// Doctypes are the indexed doctypes (in addition to io.cozy.files), with
+	// the indexed fields for each of them
+	Doctypes map[string][]string
+}
+
+// Schemas contains the configuration for the validation of the documents
+// written via the data API with the JSON schemas of their doctypes
+type Schemas struct {
+	// Strictness is the default strictness for the doctypes with a schema:
+	// reject, warn or off
+	Strictness string
+	// Doctypes can be used to override the strictness for some doctypes
+	Doctypes map[string]string
+}
+
 // Konnectors contains the configuration values for the konnectors
 type Konnectors struct {
 	Cmd string
@@ -275,6 +308,20 @@ type Move struct {
 	URL string
 }
 
+// Backups contains the configuration for the incremental backups of the
+// instances
+type Backups struct {
+	// Target is where the backups are stored, like file:///var/lib/backups
+	// or swift://container
+	Target string
+	// Incrementals is the number of incremental backups made after a full
+	// backup, before the next full backup
+	Incrementals int
+	// KeepChains is the number of chains (a full backup and its incremental
+	// backups) kept for each instance
+	KeepChains int
+}
+
 // Office contains the configuration for collaborative edition of office
 // documents
 type Office struct {
@@ -296,7 +343,7 @@ type CommonSettings struct {
 }
 
 // Notifications contains the configuration for the mobile push-notification
-// center, for Android and iOS
+// center, for Android and iOS, and for the Web Push of the browsers
 type Notifications struct {
 	Development bool
 
@@ -310,6 +357,9 @@ type Notifications struct {
 	HuaweiGetTokenURL     string
 	HuaweiSendMessagesURL string
 
+	// VAPID is the key used for Web Push, nil if Web Push is not configured
+	VAPID *webpush.VAPID
+
 	Contexts map[string]SMS
 }
 
@@ -802,6 +852,10 @@ func applyDefaults(v *viper.Viper) {
 	v.SetDefault("jobs.ghostscript_cmd", "gs")
 	v.SetDefault("jobs.imagemagick_convert_cmd", "convert")
 	v.SetDefault("jobs.defaultDurationToKeep", "2W")
+	v.SetDefault("audit.retention", "1Y")
+	v.SetDefault("schemas.strictness", "warn")
+	v.SetDefault("backups.incrementals", 6)
+	v.SetDefault("backups.keep_chains", 2)
 	v.SetDefault("assets_polling_disabled", false)
 	v.SetDefault("assets_polling_interval", 2*time.Minute)
 	v.SetDefault("fs.versioning.max_number_of_versions_to_keep", 20)
@@ -1157,6 +1211,7 @@ func UseViper(v *viper.Viper) error {
 			DefaultLayout:         defaultLayout,
 			CanQueryInfo:          v.GetBool("fs.can_query_info"),
 			AutoCleanTrashedAfter: v.GetStringMapString("fs.auto_clean_trashed_after"),
+			Deduplication:         v.GetBool("fs.deduplication"),
 			Versioning: FsVersioning{
 				MaxNumberToKeep:            v.GetInt("fs.versioning.max_number_of_versions_to_keep"),
 				MinDelayBetweenTwoVersions: v.GetDuration("fs.versioning.min_delay_between_two_versions"),
@@ -1168,11 +1223,27 @@ func UseViper(v *viper.Viper) error {
 		Konnectors: Konnectors{
 			Cmd: v.GetString("konnectors.cmd"),
 		},
+		Audit: Audit{
+			Retention: v.GetString("audit.retention"),
+		},
+		Search: Search{
+			Path:     v.GetString("search.path"),
+			Doctypes: v.GetStringMapStringSlice("search.doctypes"),
+		},
+		Schemas: Schemas{
+			Strictness: v.GetString("schemas.strictness"),
+			Doctypes:   v.GetStringMapString("schemas.doctypes"),
+		},
 		RAGServers:     rag,
 		CommonSettings: commonSettings,
 		Move: Move{
 			URL: v.GetString("move.url"),
 		},
+		Backups: Backups{
+			Target:       v.GetString("backups.target"),
+			Incrementals: v.GetInt("backups.incrementals"),
+			KeepChains:   v.GetInt("backups.keep_chains"),
+		},
 		Notifications: Notifications{
 			Development: v.GetBool("notifications.development"),
 
@@ -1235,6 +1306,14 @@ func UseViper(v *viper.Viper) error {
 		return fmt.Errorf(`failed to parse the config for "rabbitmq": %w`, err)
 	}
 
+	if key := v.GetString("notifications.vapid_private_key"); key != "" {
+		vapid, err := webpush.NewVAPID(key, v.GetString("notifications.vapid_subject"))
+		if err != nil {
+			return fmt.Errorf("invalid notifications.vapid_private_key config: %w", err)
+		}
+		config.Notifications.VAPID = vapid
+	}
+
 	config.SafeHTTPTrustedNetworks = v.GetStringSlice("safe_http.trusted_private_networks")
 	if err = safehttp.SetTrustedPrivateNetworks(config.SafeHTTPTrustedNetworks); err != nil {
 		return fmt.Errorf("invalid safe_http.trusted_private_networks config: %w", err)
@@ -63,6 +63,10 @@ const (
 	// DefaultFlagsSettingsID is the id of the settings documents with the
 	// default feature flags.
 	DefaultFlagsSettingsID = "io.cozy.settings.flags.default"
+	// NotificationsSettingsID is the id of the settings document with the
+	// preferences of the user for the notifications (muted apps and
+	// categories).
+	NotificationsSettingsID = "io.cozy.settings.notifications"
 )
 
 const (
@@ -36,6 +36,13 @@ const (
 	FilesMetadata = "io.cozy.files.metadata"
 	// FilesVersions doc type for versioning file contents
 	FilesVersions = "io.cozy.files.versions"
+	// FilesVersioningPolicies doc type for the retention policies of the
+	// versions, attached to directories
+	FilesVersioningPolicies = "io.cozy.files.versioning_policies"
+	// FilesUploads doc type for the sessions of resumable uploads
+	FilesUploads = "io.cozy.files.uploads"
+	// FilesBlobs doc type for the contents shared by several files
+	FilesBlobs = "io.cozy.files.blobs"
 	// FilesShortcuts doc type for high-level information about .url files
 	FilesShortcuts = "io.cozy.files.shortcuts"
 	// Thumbnails is a synthetic doctype for thumbnails, used for realtime
@@ -66,18 +73,34 @@ const (
 	OAuthAccessCodes = "io.cozy.oauth.access_codes"
 	// OAuthClients doc type for OAuth2 clients
 	OAuthClients = "io.cozy.oauth.clients"
+	// WebPushSubscriptions doc type for the Web Push subscriptions of the
+	// browsers
+	WebPushSubscriptions = "io.cozy.oauth.webpush_subscriptions"
 	// Permissions doc type for permissions identifying a connection
 	Permissions = "io.cozy.permissions"
 	// Contacts doc type for sharing
 	Contacts = "io.cozy.contacts"
+	// Bills doc type for the bills fetched by the konnectors
+	Bills = "io.cozy.bills"
 	// Groups of contacts doc type for sharing
 	Groups = "io.cozy.contacts.groups"
+	// CalendarEvents doc type for the events of the calendar
+	CalendarEvents = "io.cozy.calendar.events"
+	// CalendarTodos doc type for the tasks of the calendar
+	CalendarTodos = "io.cozy.calendar.todos"
 	// RemoteRequests doc type for logging requests to remote websites
 	RemoteRequests = "io.cozy.remote.requests"
 	// RemoteSecrets doc type for secrets used by remote doctypes
 	RemoteSecrets = "io.cozy.remote.secrets"
+	// Audit doc type for the security audit log of sensitive operations
+	Audit = "io.cozy.audit"
+	// ShareLinkAccesses doc type for the access log of the shares by link
+	ShareLinkAccesses = "io.cozy.permissions.accesses"
 	// Sessions doc type for sessions identifying a connection
 	Sessions = "io.cozy.sessions"
+	// WebAuthnCredentials doc type for the passkeys and security keys
+	// registered by the user
+	WebAuthnCredentials = "io.cozy.webauthn_credentials"
 	// SessionsLogins doc type for sessions identifying a connection
 	SessionsLogins = "io.cozy.sessions.logins"
 	// Settings doc type for settings to customize an instance
@@ -99,6 +122,8 @@ const (
 	Triggers = "io.cozy.triggers"
 	// TriggersState doc type for triggers current state, jobs launchers
 	TriggersState = "io.cozy.triggers.state"
+	// WebhooksDeliveries doc type for the status of the outgoing webhooks
+	WebhooksDeliveries = "io.cozy.webhooks.deliveries"
 	// Accounts doc type for accounts
 	Accounts = "io.cozy.accounts"
 	// SoftDeletedAccounts doc type for old revisions of deleted accounts
@@ -116,6 +141,12 @@ const (
 	// BitwardenContacts doc type for Bitwarden users that can be added to
 	// an organization
 	BitwardenContacts = "com.bitwarden.contacts"
+	// BitwardenSends doc type for Bitwarden sends (texts and files shared via
+	// a link)
+	BitwardenSends = "com.bitwarden.sends"
+	// BitwardenEmergencyAccess doc type for the Bitwarden emergency accesses
+	// (a trusted contact can access the vault after a waiting period)
+	BitwardenEmergencyAccess = "com.bitwarden.emergency_access"
 	// NotesDocuments doc type is used for manipulating the documents that
 	// represents a note before they are persisted to a file.
 	NotesDocuments = "io.cozy.notes.documents"
@@ -151,4 +182,6 @@ const (
 	ChatConversations = "io.cozy.ai.chat.conversations"
 	// ChatEvents doc type is used for RAG events about a chat conversation.
 	ChatEvents = "io.cozy.ai.chat.events"
+	// SearchResults doc type is used for the results of the full-text search.
+	SearchResults = "io.cozy.search.results"
 )
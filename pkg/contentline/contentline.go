@@ -0,0 +1,339 @@
+// Package contentline implements the format of the content lines shared by
+// vCard (RFC 6350) and iCalendar (RFC 5545): each line is a property with a
+// name, some parameters and a value, and the long lines are folded.
+package contentline
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxLineLength is the maximal length in octets of a line, excluding the line
+// break, before it is folded.
+const maxLineLength = 75
+
+// ErrInvalidLine is used when a content line can't be parsed.
+var ErrInvalidLine = errors.New("contentline: invalid content line")
+
+// Params are the parameters of a property. The names are in upper case.
+type Params map[string][]string
+
+// Get returns the first value of the parameter, or an empty string.
+func (p Params) Get(name string) string {
+	if values := p[strings.ToUpper(name)]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Values returns all the values of the parameter. The values separated by
+// commas are split, as vCard 3.0 allows both TYPE=a,b and TYPE=a;TYPE=b.
+func (p Params) Values(name string) []string {
+	var values []string
+	for _, value := range p[strings.ToUpper(name)] {
+		for _, v := range strings.Split(value, ",") {
+			if v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// Has returns true if the parameter has the given value (case insensitive).
+func (p Params) Has(name, value string) bool {
+	for _, v := range p.Values(name) {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add adds a value to the parameter.
+func (p Params) Add(name, value string) {
+	name = strings.ToUpper(name)
+	p[name] = append(p[name], value)
+}
+
+// Property is a content line. The value is kept as is: the caller is
+// responsible for unescaping it, as it depends on the type of the value.
+type Property struct {
+	Group  string
+	Name   string
+	Params Params
+	Value  string
+}
+
+// NewProperty returns a property with the given name and value, and no
+// parameters.
+func NewProperty(name, value string) *Property {
+	return &Property{Name: strings.ToUpper(name), Params: Params{}, Value: value}
+}
+
+// String returns the property as an unfolded content line.
+func (p *Property) String() string {
+	var sb strings.Builder
+	if p.Group != "" {
+		sb.WriteString(p.Group)
+		sb.WriteByte('.')
+	}
+	sb.WriteString(p.Name)
+	for _, name := range sortedKeys(p.Params) {
+		values := p.Params[name]
+		if len(values) == 0 {
+			continue
+		}
+		sb.WriteByte(';')
+		sb.WriteString(name)
+		sb.WriteByte('=')
+		for i, value := range values {
+			if i > 0 {
+				sb.WriteByte(',')
+			}
+			value = paramEncoder.Replace(value)
+			if strings.ContainsAny(value, ":;,") {
+				sb.WriteByte('"')
+				sb.WriteString(value)
+				sb.WriteByte('"')
+			} else {
+				sb.WriteString(value)
+			}
+		}
+	}
+	sb.WriteByte(':')
+	sb.WriteString(p.Value)
+	return sb.String()
+}
+
+// The parameter values can't contain double quotes and line breaks: they are
+// encoded with a caret, as defined by RFC 6868.
+var (
+	paramEncoder = strings.NewReplacer("^", "^^", "\n", "^n", "\r", "", `"`, "^'")
+	paramDecoder = strings.NewReplacer("^^", "^", "^n", "\n", "^N", "\n", "^'", `"`)
+)
+
+// sortedKeys returns the parameter names in a stable order, to have the same
+// output for the same properties.
+func sortedKeys(params Params) []string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ParseLine parses an unfolded content line.
+func ParseLine(line string) (*Property, error) {
+	p := &Property{Params: Params{}}
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return nil, ErrInvalidLine
+	}
+	name := line[:i]
+	if dot := strings.IndexByte(name, '.'); dot >= 0 {
+		p.Group = name[:dot]
+		name = name[dot+1:]
+	}
+	if name == "" {
+		return nil, ErrInvalidLine
+	}
+	p.Name = strings.ToUpper(name)
+	rest := line[i:]
+
+	for strings.HasPrefix(rest, ";") {
+		rest = rest[1:]
+		eq := strings.IndexAny(rest, "=;:")
+		if eq < 0 {
+			return nil, ErrInvalidLine
+		}
+		param := strings.ToUpper(rest[:eq])
+		if rest[eq] != '=' {
+			// vCard 2.1 allows parameters without a name, like TEL;CELL:
+			p.Params.Add("TYPE", param)
+			rest = rest[eq:]
+			continue
+		}
+		rest = rest[eq+1:]
+		for {
+			var value string
+			if strings.HasPrefix(rest, `"`) {
+				end := strings.IndexByte(rest[1:], '"')
+				if end < 0 {
+					return nil, ErrInvalidLine
+				}
+				value = rest[1 : end+1]
+				rest = rest[end+2:]
+			} else {
+				end := strings.IndexAny(rest, ",;:")
+				if end < 0 {
+					return nil, ErrInvalidLine
+				}
+				value = rest[:end]
+				rest = rest[end:]
+			}
+			p.Params.Add(param, paramDecoder.Replace(value))
+			if !strings.HasPrefix(rest, ",") {
+				break
+			}
+			rest = rest[1:]
+		}
+	}
+
+	if !strings.HasPrefix(rest, ":") {
+		return nil, ErrInvalidLine
+	}
+	p.Value = rest[1:]
+	return p, nil
+}
+
+// Parse parses the content lines. The empty lines are ignored.
+func Parse(data []byte) ([]*Property, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') {
+			if len(lines) == 0 {
+				return nil, ErrInvalidLine
+			}
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	props := make([]*Property, 0, len(lines))
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		p, err := ParseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		props = append(props, p)
+	}
+	return props, nil
+}
+
+// Encode returns the properties as content lines, folded and separated by
+// CRLF.
+func Encode(props []*Property) []byte {
+	var buf bytes.Buffer
+	for _, p := range props {
+		fold(&buf, p.String())
+	}
+	return buf.Bytes()
+}
+
+// fold writes the line, folded at 75 octets without splitting the UTF-8
+// characters.
+func fold(buf *bytes.Buffer, line string) {
+	limit := maxLineLength
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buf.WriteString(line[:cut])
+		buf.WriteString("\r\n ")
+		line = line[cut:]
+		// The leading space of the continuation line counts in its length
+		limit = maxLineLength - 1
+	}
+	buf.WriteString(line)
+	buf.WriteString("\r\n")
+}
+
+// EscapeText escapes a text value.
+func EscapeText(s string) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case ';':
+			sb.WriteString(`\;`)
+		case ',':
+			sb.WriteString(`\,`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			// The CR of a CRLF is dropped, the LF is escaped
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// UnescapeText unescapes a text value.
+func UnescapeText(s string) string {
+	if !strings.ContainsRune(s, '\\') {
+		return s
+	}
+	var sb strings.Builder
+	escaped := false
+	for _, r := range s {
+		if !escaped {
+			if r == '\\' {
+				escaped = true
+			} else {
+				sb.WriteRune(r)
+			}
+			continue
+		}
+		escaped = false
+		switch r {
+		case 'n', 'N':
+			sb.WriteByte('\n')
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// SplitText splits a value on the separator (';' for the components of a
+// structured value, or ',' for a list), ignoring the escaped separators, and
+// unescapes the parts.
+func SplitText(s string, sep rune) []string {
+	var parts []string
+	var sb strings.Builder
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == sep:
+			parts = append(parts, UnescapeText(sb.String()))
+			sb.Reset()
+		default:
+			sb.WriteRune(r)
+		}
+	}
+	return append(parts, UnescapeText(sb.String()))
+}
+
+// JoinText escapes the parts and joins them with the separator.
+func JoinText(parts []string, sep rune) string {
+	escaped := make([]string, len(parts))
+	for i, part := range parts {
+		escaped[i] = EscapeText(part)
+	}
+	return strings.Join(escaped, string(sep))
+}
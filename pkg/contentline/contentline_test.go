@@ -0,0 +1,62 @@
+package contentline
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLine(t *testing.T) {
+	p, err := ParseLine(`item1.EMAIL;TYPE=work,pref;LABEL="Office: 3rd floor":alice@example.com`)
+	require.NoError(t, err)
+	assert.Equal(t, "item1", p.Group)
+	assert.Equal(t, "EMAIL", p.Name)
+	assert.Equal(t, []string{"work", "pref"}, p.Params.Values("type"))
+	assert.True(t, p.Params.Has("TYPE", "PREF"))
+	assert.Equal(t, "Office: 3rd floor", p.Params.Get("LABEL"))
+	assert.Equal(t, "alice@example.com", p.Value)
+
+	p, err = ParseLine("TEL;CELL:+33 6 00 00 00 00")
+	require.NoError(t, err)
+	assert.True(t, p.Params.Has("TYPE", "cell"))
+	assert.Equal(t, "+33 6 00 00 00 00", p.Value)
+
+	p, err = ParseLine(`ADR;LABEL="1 rue du Port^n44000 Nantes":;;1 rue du Port;Nantes;;44000;France`)
+	require.NoError(t, err)
+	assert.Equal(t, "1 rue du Port\n44000 Nantes", p.Params.Get("LABEL"))
+	assert.Equal(t, `ADR;LABEL=1 rue du Port^n44000 Nantes:;;1 rue du Port;Nantes;;44000;France`, p.String())
+
+	_, err = ParseLine("no colon")
+	assert.ErrorIs(t, err, ErrInvalidLine)
+}
+
+func TestParseAndEncode(t *testing.T) {
+	note := strings.Repeat("Ça va ? ", 20)
+	props := []*Property{
+		NewProperty("BEGIN", "VCARD"),
+		NewProperty("NOTE", EscapeText(note)),
+		NewProperty("END", "VCARD"),
+	}
+	data := Encode(props)
+	for _, line := range strings.Split(string(data), "\r\n") {
+		assert.LessOrEqual(t, len(line), 75)
+	}
+
+	parsed, err := Parse(data)
+	require.NoError(t, err)
+	require.Len(t, parsed, 3)
+	assert.Equal(t, "NOTE", parsed[1].Name)
+	assert.Equal(t, note, UnescapeText(parsed[1].Value))
+}
+
+func TestText(t *testing.T) {
+	assert.Equal(t, `a\, b\; c\\d\ne`, EscapeText("a, b; c\\d\r\ne"))
+	assert.Equal(t, "a, b; c\\d\ne", UnescapeText(`a\, b\; c\\d\ne`))
+
+	parts := SplitText(`Doe;John\, Jr;;Dr.;`, ';')
+	assert.Equal(t, []string{"Doe", "John, Jr", "", "Dr.", ""}, parts)
+	assert.Equal(t, `Doe;John\, Jr;;Dr.;`, JoinText(parts, ';'))
+	assert.Equal(t, []string{"Friends", "Work, old"}, SplitText(`Friends,Work\, old`, ','))
+}
@@ -14,7 +14,7 @@ import (
 
 // IndexViewsVersion is the version of current definition of views & indexes.
 // This number should be incremented when this file changes.
-const IndexViewsVersion int = 39
+const IndexViewsVersion int = 45
 
 // Indexes is the index list required by an instance to run properly.
 var Indexes = []*mango.Index{
@@ -33,6 +33,8 @@ var Indexes = []*mango.Index{
 	mango.MakeIndex(consts.Files, "by-sharing-status", mango.IndexDef{Fields: []string{"metadata.sharing.status"}}),
 	// Used to find old files and directories in the trashed that should be deleted
 	mango.MakeIndex(consts.Files, "by-dir-id-updated-at", mango.IndexDef{Fields: []string{"dir_id", "updated_at"}}),
+	// Used to find a file with the same content, for the deduplication
+	mango.MakeIndex(consts.Files, "by-md5sum", mango.IndexDef{Fields: []string{"md5sum"}}),
 
 	// Used to lookup a queued and running jobs
 	mango.MakeIndex(consts.Jobs, "by-worker-and-state", mango.IndexDef{Fields: []string{"worker", "state"}}),
@@ -41,6 +43,8 @@ var Indexes = []*mango.Index{
 
 	// Used to lookup a trigger to see if it exists or must be created
 	mango.MakeIndex(consts.Triggers, "by-worker-and-type", mango.IndexDef{Fields: []string{"worker", "type"}}),
+	// Used to find the @at trigger for the next reminder of an event or todo
+	mango.MakeIndex(consts.Triggers, "by-worker-type-and-doc-id", mango.IndexDef{Fields: []string{"worker", "type", "message.doc_id"}}),
 
 	// Used to lookup oauth clients by name
 	mango.MakeIndex(consts.OAuthClients, "by-client-name", mango.IndexDef{Fields: []string{"client_name"}}),
@@ -53,12 +57,27 @@ var Indexes = []*mango.Index{
 		),
 	}),
 
+	// Used to delete the Web Push subscriptions of an OAuth client
+	mango.MakeIndex(consts.WebPushSubscriptions, "by-client-id", mango.IndexDef{Fields: []string{"client_id"}}),
+
 	// Used to lookup login history by OS, browser, and IP
 	mango.MakeIndex(consts.SessionsLogins, "by-os-browser-ip", mango.IndexDef{Fields: []string{"os", "browser", "ip"}}),
 
 	// Used to lookup notifications by their source, ordered by their creation
 	// date
 	mango.MakeIndex(consts.Notifications, "by-source-id", mango.IndexDef{Fields: []string{"source_id", "created_at"}}),
+	// Used to list the notifications of the inbox, and the unread ones
+	mango.MakeIndex(consts.Notifications, "by-created-at", mango.IndexDef{Fields: []string{"created_at"}}),
+	mango.MakeIndex(consts.Notifications, "unread-by-created-at", mango.IndexDef{
+		Fields:        []string{"created_at"},
+		PartialFilter: mango.NotExists("read_at"),
+	}),
+
+	// Used to list the entries of the audit log, and to remove the old ones
+	mango.MakeIndex(consts.Audit, "by-created-at", mango.IndexDef{Fields: []string{"created_at"}}),
+
+	// Used to list the access log of a share by link
+	mango.MakeIndex(consts.ShareLinkAccesses, "by-permission-id", mango.IndexDef{Fields: []string{"permission_id", "created_at"}}),
 
 	// Used to find the myself document
 	mango.MakeIndex(consts.Contacts, "by-me", mango.IndexDef{Fields: []string{"me"}}),
@@ -278,6 +297,49 @@ function(doc) {
 `,
 }
 
+// UnreadNotificationsView is used to count the unread notifications
+var UnreadNotificationsView = &View{
+	Name:    "unread-notifications",
+	Doctype: consts.Notifications,
+	Map: `
+function(doc) {
+	if (!doc.read_at) {
+		emit(doc.created_at);
+	}
+}
+`,
+	Reduce: "_count",
+}
+
+// BlobsByHolderView is used to find the shared contents held by a file or a
+// version, when the deduplication is enabled.
+var BlobsByHolderView = &View{
+	Name:    "blobs-by-holder",
+	Doctype: consts.FilesBlobs,
+	Map: `
+function(doc) {
+  if (isArray(doc.holders)) {
+    for (var i = 0; i < doc.holders.length; i++) {
+      emit(doc.holders[i]);
+    }
+  }
+}`,
+}
+
+// BlobsSavedView is used to compute the number of bytes that are not stored
+// thanks to the deduplication.
+var BlobsSavedView = &View{
+	Name:    "blobs-saved",
+	Doctype: consts.FilesBlobs,
+	Map: `
+function(doc) {
+  if (isArray(doc.holders) && doc.holders.length > 1) {
+    emit(doc._id, (doc.holders.length - 1) * +doc.size);
+  }
+}`,
+	Reduce: "_sum",
+}
+
 // Views is the list of all views that are created by the stack.
 var Views = []*View{
 	DiskUsageView,
@@ -293,6 +355,9 @@ var Views = []*View{
 	SharedDocsBySharingID,
 	SharingsByDocTypeView,
 	ContactByEmail,
+	UnreadNotificationsView,
+	BlobsByHolderView,
+	BlobsSavedView,
 }
 
 // ViewsByDoctype returns the list of views for a specified doc type.
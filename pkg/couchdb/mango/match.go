@@ -0,0 +1,480 @@
+package mango
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// This file provides an evaluation of the mango selectors on documents in
+// memory, for the cases where a query can't be sent to CouchDB (like
+// filtering the realtime events). The strings are compared by their bytes, and
+// not with the UCA algorithm used by CouchDB.
+
+// ValidateSelector returns an error if the selector uses an operator that is
+// not supported by Match, or has an operand of the wrong type.
+func ValidateSelector(selector Map) error {
+	return validateSelector(selector, regexps{})
+}
+
+// regexps are the compiled regular expressions of a selector, indexed by
+// their pattern.
+type regexps map[string]*regexp.Regexp
+
+// Selector is a validated mango selector, with its regular expressions
+// compiled, to be evaluated on many documents.
+type Selector struct {
+	selector Map
+	regexps  regexps
+}
+
+// CompileSelector checks the selector like ValidateSelector, and returns it
+// ready to be matched against documents.
+func CompileSelector(selector Map) (*Selector, error) {
+	res := regexps{}
+	if err := validateSelector(selector, res); err != nil {
+		return nil, err
+	}
+	return &Selector{selector: selector, regexps: res}, nil
+}
+
+// Match returns true if the document matches the selector.
+func (s *Selector) Match(doc map[string]interface{}) bool {
+	return matchSelector(s.selector, doc, s.regexps)
+}
+
+func validateSelector(selector map[string]interface{}, res regexps) error {
+	for key, value := range selector {
+		switch key {
+		case string(and), string(or), string(nor):
+			list, ok := asList(value)
+			if !ok {
+				return fmt.Errorf("%s expects an array of selectors", key)
+			}
+			for _, item := range list {
+				sub, ok := asMap(item)
+				if !ok {
+					return fmt.Errorf("%s expects an array of selectors", key)
+				}
+				if err := validateSelector(sub, res); err != nil {
+					return err
+				}
+			}
+		case string(not):
+			sub, ok := asMap(value)
+			if !ok {
+				return fmt.Errorf("%s expects a selector", key)
+			}
+			if err := validateSelector(sub, res); err != nil {
+				return err
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return fmt.Errorf("unsupported operator %s", key)
+			}
+			if err := validateCondition(value, res); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateCondition(cond interface{}, res regexps) error {
+	m, ok := asMap(cond)
+	if !ok {
+		return nil
+	}
+	for op, operand := range m {
+		if !strings.HasPrefix(op, "$") {
+			// Nested field
+			if err := validateCondition(operand, res); err != nil {
+				return err
+			}
+			continue
+		}
+		switch op {
+		case "$eq", string(ne), string(gt), string(gte), string(lt), string(lte):
+		case string(exists):
+			if _, ok := operand.(bool); !ok {
+				return fmt.Errorf("%s expects a boolean", op)
+			}
+		case string(in), "$nin", "$all":
+			if _, ok := operand.([]interface{}); !ok {
+				return fmt.Errorf("%s expects an array", op)
+			}
+		case "$size":
+			if _, ok := toFloat(operand); !ok {
+				return fmt.Errorf("%s expects a number", op)
+			}
+		case "$type":
+			if _, ok := operand.(string); !ok {
+				return fmt.Errorf("%s expects a string", op)
+			}
+		case "$regex":
+			pattern, ok := operand.(string)
+			if !ok {
+				return fmt.Errorf("%s expects a string", op)
+			}
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return err
+			}
+			res[pattern] = re
+		case string(not), "$elemMatch", "$allMatch":
+			if err := validateCondition(operand, res); err != nil {
+				return err
+			}
+			if _, ok := asMap(operand); !ok {
+				return fmt.Errorf("%s expects an object", op)
+			}
+		default:
+			return fmt.Errorf("unsupported operator %s", op)
+		}
+	}
+	return nil
+}
+
+// Match returns true if the document matches the selector. The selector
+// should have been checked with ValidateSelector before: the unsupported
+// operators don't match any document. For a selector evaluated on many
+// documents, CompileSelector should be preferred, as the regular expressions
+// are compiled by each call of Match.
+func Match(selector Map, doc map[string]interface{}) bool {
+	return matchSelector(selector, doc, nil)
+}
+
+func matchSelector(selector map[string]interface{}, doc map[string]interface{}, res regexps) bool {
+	for key, value := range selector {
+		switch key {
+		case string(and):
+			list, _ := asList(value)
+			for _, item := range list {
+				sub, _ := asMap(item)
+				if !matchSelector(sub, doc, res) {
+					return false
+				}
+			}
+		case string(or), string(nor):
+			list, _ := asList(value)
+			found := false
+			for _, item := range list {
+				sub, _ := asMap(item)
+				if matchSelector(sub, doc, res) {
+					found = true
+					break
+				}
+			}
+			if found != (key == string(or)) {
+				return false
+			}
+		case string(not):
+			sub, _ := asMap(value)
+			if matchSelector(sub, doc, res) {
+				return false
+			}
+		default:
+			if strings.HasPrefix(key, "$") {
+				return false
+			}
+			field, found := fieldValue(doc, key)
+			if !matchCondition(field, found, value, res) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fieldValue returns the value of a field, with the dot notation for the
+// nested fields.
+func fieldValue(doc map[string]interface{}, field string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, part := range strings.Split(field, ".") {
+		m, ok := asMap(value)
+		if !ok {
+			return nil, false
+		}
+		value, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// matchCondition returns true if the value of a field matches the condition.
+// A missing field matches only {"$exists": false}.
+func matchCondition(value interface{}, found bool, cond interface{}, res regexps) bool {
+	m, ok := asMap(cond)
+	if !ok {
+		return found && compare(value, cond) == 0
+	}
+	for op, operand := range m {
+		if !strings.HasPrefix(op, "$") {
+			// Nested field: {"a": {"b": 1}} is the same as {"a.b": 1}
+			sub, ok := asMap(value)
+			if !found || !ok {
+				return false
+			}
+			v, f := sub[op]
+			if !matchCondition(v, f, operand, res) {
+				return false
+			}
+			continue
+		}
+		if op == string(exists) {
+			if want, _ := operand.(bool); want != found {
+				return false
+			}
+			continue
+		}
+		if op == string(not) {
+			if matchCondition(value, found, operand, res) {
+				return false
+			}
+			continue
+		}
+		if !found || !matchOperator(op, value, operand, res) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchOperator(op string, value, operand interface{}, res regexps) bool {
+	switch op {
+	case "$eq":
+		return compare(value, operand) == 0
+	case string(ne):
+		return compare(value, operand) != 0
+	case string(gt):
+		return sameKind(value, operand) && compare(value, operand) > 0
+	case string(gte):
+		return sameKind(value, operand) && compare(value, operand) >= 0
+	case string(lt):
+		return sameKind(value, operand) && compare(value, operand) < 0
+	case string(lte):
+		return sameKind(value, operand) && compare(value, operand) <= 0
+	case string(in), "$nin":
+		list, _ := operand.([]interface{})
+		found := false
+		for _, item := range list {
+			if compare(value, item) == 0 {
+				found = true
+				break
+			}
+		}
+		return found == (op == string(in))
+	case "$all":
+		values, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		list, _ := operand.([]interface{})
+		for _, item := range list {
+			found := false
+			for _, v := range values {
+				if compare(v, item) == 0 {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case "$size":
+		values, ok := value.([]interface{})
+		size, _ := toFloat(operand)
+		return ok && float64(len(values)) == size
+	case "$type":
+		return typeName(value) == operand
+	case "$regex":
+		s, ok := value.(string)
+		if !ok {
+			return false
+		}
+		pattern, _ := operand.(string)
+		re, ok := res[pattern]
+		if !ok {
+			var err error
+			if re, err = regexp.Compile(pattern); err != nil {
+				return false
+			}
+		}
+		return re.MatchString(s)
+	case "$elemMatch", "$allMatch":
+		values, ok := value.([]interface{})
+		if !ok || (op == "$allMatch" && len(values) == 0) {
+			return false
+		}
+		for _, v := range values {
+			matched := matchCondition(v, true, operand, res)
+			if matched && op == "$elemMatch" {
+				return true
+			}
+			if !matched && op == "$allMatch" {
+				return false
+			}
+		}
+		return op == "$allMatch"
+	default:
+		return false
+	}
+}
+
+// asList accepts the arrays of selectors built with the Filter functions of
+// this package too.
+func asList(value interface{}) ([]interface{}, bool) {
+	switch l := value.(type) {
+	case []interface{}:
+		return l, true
+	case []Map:
+		list := make([]interface{}, len(l))
+		for i, m := range l {
+			list[i] = m
+		}
+		return list, true
+	default:
+		return nil, false
+	}
+}
+
+func asMap(value interface{}) (map[string]interface{}, bool) {
+	switch m := value.(type) {
+	case map[string]interface{}:
+		return m, true
+	case Map:
+		return m, true
+	default:
+		return nil, false
+	}
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+func typeName(value interface{}) string {
+	if value == nil {
+		return "null"
+	}
+	if _, ok := toFloat(value); ok {
+		return "number"
+	}
+	if _, ok := asMap(value); ok {
+		return "object"
+	}
+	switch value.(type) {
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "unknown"
+	}
+}
+
+// sameKind is used for the range operators, that compare only values of the
+// same type.
+func sameKind(a, b interface{}) bool {
+	return typeName(a) == typeName(b)
+}
+
+// collationRank gives the order of the types in the CouchDB collation.
+var collationRank = map[string]int{
+	"null":    0,
+	"boolean": 1,
+	"number":  2,
+	"string":  3,
+	"array":   4,
+	"object":  5,
+	"unknown": 6,
+}
+
+// compare returns -1, 0 or 1 if a is less than, equal to, or greater than b,
+// following the order of the CouchDB collation for the types.
+func compare(a, b interface{}) int {
+	ta, tb := typeName(a), typeName(b)
+	if ta != tb {
+		return compareInts(collationRank[ta], collationRank[tb])
+	}
+	switch ta {
+	case "null":
+		return 0
+	case "boolean":
+		ba, bb := a.(bool), b.(bool)
+		if ba == bb {
+			return 0
+		}
+		if !ba {
+			return -1
+		}
+		return 1
+	case "number":
+		fa, _ := toFloat(a)
+		fb, _ := toFloat(b)
+		switch {
+		case fa < fb:
+			return -1
+		case fa > fb:
+			return 1
+		}
+		return 0
+	case "string":
+		return strings.Compare(a.(string), b.(string))
+	case "array":
+		la, lb := a.([]interface{}), b.([]interface{})
+		for i := 0; i < len(la) && i < len(lb); i++ {
+			if c := compare(la[i], lb[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(la), len(lb))
+	default:
+		ma, _ := asMap(a)
+		mb, _ := asMap(b)
+		if reflect.DeepEqual(ma, mb) {
+			return 0
+		}
+		// The objects are not equal, but there is no need to order them
+		if len(ma) < len(mb) {
+			return -1
+		}
+		return 1
+	}
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
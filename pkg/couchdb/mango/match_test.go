@@ -0,0 +1,119 @@
+package mango
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseSelector(t *testing.T, raw string) Map {
+	var selector Map
+	require.NoError(t, json.Unmarshal([]byte(raw), &selector))
+	require.NoError(t, ValidateSelector(selector))
+	return selector
+}
+
+func TestMatch(t *testing.T) {
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"_id": "bill1",
+		"vendor": "Energy",
+		"amount": 42.5,
+		"paid": false,
+		"tags": ["home", "monthly"],
+		"invoice": {"number": "F-001", "pages": 2},
+		"lines": [{"label": "gas", "amount": 30}, {"label": "tax", "amount": 12.5}]
+	}`), &doc))
+
+	matching := []string{
+		`{}`,
+		`{"vendor": "Energy"}`,
+		`{"vendor": {"$eq": "Energy"}, "paid": false}`,
+		`{"amount": {"$gt": 40, "$lte": 42.5}}`,
+		`{"vendor": {"$in": ["Water", "Energy"]}}`,
+		`{"vendor": {"$nin": ["Water"]}}`,
+		`{"vendor": {"$ne": "Water"}}`,
+		`{"vendor": {"$regex": "^En"}}`,
+		`{"invoice.number": "F-001"}`,
+		`{"invoice": {"pages": {"$gte": 2}}}`,
+		`{"note": {"$exists": false}, "tags": {"$exists": true}}`,
+		`{"tags": {"$all": ["monthly"]}, "lines": {"$size": 2}}`,
+		`{"lines": {"$elemMatch": {"label": "tax"}}}`,
+		`{"lines": {"$allMatch": {"amount": {"$gt": 10}}}}`,
+		`{"tags": {"$elemMatch": {"$eq": "home"}}}`,
+		`{"amount": {"$type": "number"}, "tags": {"$type": "array"}}`,
+		`{"$or": [{"vendor": "Water"}, {"paid": false}]}`,
+		`{"$and": [{"vendor": "Energy"}, {"$not": {"paid": true}}]}`,
+		`{"$nor": [{"vendor": "Water"}, {"paid": true}]}`,
+		`{"vendor": {"$not": {"$eq": "Water"}}}`,
+	}
+	for _, raw := range matching {
+		assert.True(t, Match(parseSelector(t, raw), doc), raw)
+		compiled, err := CompileSelector(parseSelector(t, raw))
+		require.NoError(t, err)
+		assert.True(t, compiled.Match(doc), raw)
+	}
+
+	notMatching := []string{
+		`{"vendor": "Water"}`,
+		`{"amount": {"$lt": 40}}`,
+		`{"amount": {"$gt": "40"}}`,
+		`{"note": "foo"}`,
+		`{"note": {"$ne": "foo"}}`,
+		`{"invoice.number.foo": "F-001"}`,
+		`{"tags": {"$all": ["monthly", "yearly"]}}`,
+		`{"lines": {"$elemMatch": {"label": "fee"}}}`,
+		`{"lines": {"$allMatch": {"amount": {"$gt": 20}}}}`,
+		`{"$or": [{"vendor": "Water"}, {"paid": true}]}`,
+		`{"$not": {"vendor": "Energy"}}`,
+	}
+	for _, raw := range notMatching {
+		assert.False(t, Match(parseSelector(t, raw), doc), raw)
+		compiled, err := CompileSelector(parseSelector(t, raw))
+		require.NoError(t, err)
+		assert.False(t, compiled.Match(doc), raw)
+	}
+
+	// The selectors built with this package can be used too
+	assert.True(t, Match(And(Equal("vendor", "Energy"), Gt("amount", 40)).ToMango(), doc))
+	assert.False(t, Match(StartWith("vendor", "Wat").ToMango(), doc))
+}
+
+func TestValidateSelector(t *testing.T) {
+	invalid := []string{
+		`{"$where": "true"}`,
+		`{"vendor": {"$foo": 1}}`,
+		`{"$or": {"vendor": "Water"}}`,
+		`{"vendor": {"$in": "Water"}}`,
+		`{"vendor": {"$exists": "yes"}}`,
+		`{"vendor": {"$regex": "("}}`,
+		`{"lines": {"$elemMatch": "tax"}}`,
+	}
+	for _, raw := range invalid {
+		var selector Map
+		require.NoError(t, json.Unmarshal([]byte(raw), &selector))
+		assert.Error(t, ValidateSelector(selector), raw)
+		_, err := CompileSelector(selector)
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestCompileSelector(t *testing.T) {
+	selector := parseSelector(t, `{"$or": [
+		{"vendor": {"$regex": "^En"}},
+		{"lines": {"$elemMatch": {"label": {"$regex": "ta(x|xes)$"}}}}
+	]}`)
+	compiled, err := CompileSelector(selector)
+	require.NoError(t, err)
+	assert.Len(t, compiled.regexps, 2)
+	assert.Contains(t, compiled.regexps, "^En")
+	assert.Contains(t, compiled.regexps, "ta(x|xes)$")
+
+	assert.True(t, compiled.Match(map[string]interface{}{"vendor": "Energy"}))
+	assert.True(t, compiled.Match(map[string]interface{}{
+		"lines": []interface{}{map[string]interface{}{"label": "taxes"}},
+	}))
+	assert.False(t, compiled.Match(map[string]interface{}{"vendor": "Water"}))
+}
@@ -46,7 +46,10 @@ func Proxy(db prefixer.Prefixer, doctype, path string) *httputil.ReverseProxy {
 // request on the _bulk_docs endpoint. This endpoint is specific since it will
 // mutate many document in database, the stack has to read the response from
 // couch to emit the correct realtime events.
-func ProxyBulkDocs(db prefixer.Prefixer, doctype string, req *http.Request) (*httputil.ReverseProxy, *http.Request, error) {
+//
+// The optional check function is called with the documents before forwarding
+// the request, and its error, if any, is returned as is.
+func ProxyBulkDocs(db prefixer.Prefixer, doctype string, req *http.Request, check func(docs []JSONDoc) error) (*httputil.ReverseProxy, *http.Request, error) {
 	body, err := io.ReadAll(req.Body)
 	if err != nil {
 		return nil, nil, err
@@ -68,6 +71,12 @@ func ProxyBulkDocs(db prefixer.Prefixer, doctype string, req *http.Request) (*ht
 		docs = append(docs, doc)
 	}
 
+	if check != nil {
+		if err := check(docs); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// reset body to proxy
 	req.Body = io.NopCloser(bytes.NewReader(body))
 
@@ -9,8 +9,13 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
+	"strings"
 )
 
+// ErrInvalidCipherString is used when a cipher string cannot be decrypted,
+// because it is malformed or because its MAC is invalid.
+var ErrInvalidCipherString = errors.New("invalid cipher string")
+
 func addPadding(payload []byte) []byte {
 	l := len(payload)
 	p := aes.BlockSize - (l % aes.BlockSize)
@@ -75,6 +80,64 @@ func EncryptWithAES256HMAC(encKey, macKey, payload, iv []byte) (string, error) {
 	return cipherString, nil
 }
 
+// DecryptWithAES256HMAC checks the MAC and decrypts a cipher string made by
+// EncryptWithAES256HMAC.
+func DecryptWithAES256HMAC(encKey, macKey []byte, cipherString string) ([]byte, error) {
+	if !strings.HasPrefix(cipherString, "2.") {
+		return nil, ErrInvalidCipherString
+	}
+	parts := strings.Split(cipherString[2:], "|")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCipherString
+	}
+	iv, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, ErrInvalidCipherString
+	}
+	dst, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil || len(dst) == 0 || len(dst)%aes.BlockSize != 0 {
+		return nil, ErrInvalidCipherString
+	}
+	mac, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCipherString
+	}
+
+	hash := hmac.New(sha256.New, macKey)
+	if _, err := hash.Write(iv); err != nil {
+		return nil, err
+	}
+	if _, err := hash.Write(dst); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, hash.Sum(nil)) {
+		return nil, ErrInvalidCipherString
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, err
+	}
+	payload := make([]byte, len(dst))
+	mode := cipher.NewCBCDecrypter(block, iv)
+	mode.CryptBlocks(payload, dst)
+	return removePadding(payload)
+}
+
+func removePadding(payload []byte) ([]byte, error) {
+	l := len(payload)
+	p := int(payload[l-1])
+	if p == 0 || p > aes.BlockSize || p > l {
+		return nil, ErrInvalidCipherString
+	}
+	for i := l - p; i < l; i++ {
+		if int(payload[i]) != p {
+			return nil, ErrInvalidCipherString
+		}
+	}
+	return payload[:l-p], nil
+}
+
 // UnwrapA256KW decrypts the provided cipher text with the given AES cipher
 // (and corresponding key), using the AES Key Wrap algorithm (RFC-3394). The
 // decrypted cipher text is verified using the default IV and will return an
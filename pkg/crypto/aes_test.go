@@ -36,3 +36,23 @@ func TestEncryptWithAES256CBC(t *testing.T) {
 	// ct << cipher.final
 	// expected = "0." + Base64.strict_encode64(iv) + "|" + Base64.strict_encode64(ct)
 }
+
+func TestDecryptWithAES256HMAC(t *testing.T) {
+	encKey := makeBuf(32)
+	macKey := GenerateRandomBytes(32)
+	payload := []byte("JBSWY3DPEHPK3PXP")
+	iv := GenerateRandomBytes(16)
+	str, err := EncryptWithAES256HMAC(encKey, macKey, payload, iv)
+	assert.NoError(t, err)
+
+	decrypted, err := DecryptWithAES256HMAC(encKey, macKey, str)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, decrypted)
+
+	_, err = DecryptWithAES256HMAC(encKey, makeBuf(32), str)
+	assert.ErrorIs(t, err, ErrInvalidCipherString)
+	_, err = DecryptWithAES256HMAC(encKey, macKey, "0."+str[2:])
+	assert.ErrorIs(t, err, ErrInvalidCipherString)
+	_, err = DecryptWithAES256HMAC(encKey, macKey, "2.foo|bar")
+	assert.ErrorIs(t, err, ErrInvalidCipherString)
+}
@@ -0,0 +1,161 @@
+// Package diff computes the differences between two sequences, with the
+// algorithm of Eugene W. Myers, and formats them as unified diffs.
+package diff
+
+// Op is the kind of an edit.
+type Op int
+
+const (
+	// Equal is for an element that is in both sequences
+	Equal Op = iota
+	// Delete is for an element that is only in the old sequence
+	Delete
+	// Insert is for an element that is only in the new sequence
+	Insert
+)
+
+// maxEditDistance is the maximal number of insertions and deletions searched
+// by the algorithm. When the sequences are more different than that, the
+// whole old sequence is replaced by the new one, to keep the memory bounded.
+const maxEditDistance = 1000
+
+// Edit is an element of the edit script. OldIndex is the index in the old
+// sequence (for Equal and Delete), and NewIndex is the index in the new
+// sequence (for Equal and Insert). The other index is -1.
+type Edit struct {
+	Op       Op
+	OldIndex int
+	NewIndex int
+}
+
+// Compute returns the edit script to transform a sequence of length n into a
+// sequence of length m. The eq function says if the element i of the old
+// sequence is equal to the element j of the new one.
+func Compute(n, m int, eq func(i, j int) bool) []Edit {
+	edits := make([]Edit, 0, n+m)
+
+	// Trim the common prefix and suffix
+	prefix := 0
+	for prefix < n && prefix < m && eq(prefix, prefix) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < n-prefix && suffix < m-prefix && eq(n-1-suffix, m-1-suffix) {
+		suffix++
+	}
+	for i := 0; i < prefix; i++ {
+		edits = append(edits, Edit{Op: Equal, OldIndex: i, NewIndex: i})
+	}
+	edits = myers(edits, prefix, n-suffix, prefix, m-suffix, eq)
+	for k := suffix; k > 0; k-- {
+		edits = append(edits, Edit{Op: Equal, OldIndex: n - k, NewIndex: m - k})
+	}
+	return edits
+}
+
+// myers appends to edits the edit script for old[a0:a1] and new[b0:b1].
+func myers(edits []Edit, a0, a1, b0, b1 int, eq func(i, j int) bool) []Edit {
+	n, m := a1-a0, b1-b0
+	max := n + m
+	if max > maxEditDistance {
+		max = maxEditDistance
+	}
+	if n == 0 || m == 0 {
+		return replace(edits, a0, a1, b0, b1)
+	}
+
+	// v[k+offset] is the furthest x reached on the diagonal k, and trace
+	// keeps a copy of v[-d..d] for each d, to find the path backward.
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+	found := -1
+	for d := 0; d <= max && found < 0; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[k-1+offset] < v[k+1+offset]) {
+				x = v[k+1+offset]
+			} else {
+				x = v[k-1+offset] + 1
+			}
+			y := x - k
+			for x < n && y < m && eq(a0+x, b0+y) {
+				x++
+				y++
+			}
+			v[k+offset] = x
+			if x >= n && y >= m {
+				found = d
+				break
+			}
+		}
+		snapshot := make([]int, 2*d+1)
+		copy(snapshot, v[offset-d:offset+d+1])
+		trace = append(trace, snapshot)
+	}
+	if found < 0 {
+		return replace(edits, a0, a1, b0, b1)
+	}
+
+	// Backtrack to build the edit script, in reverse order
+	var rev []Edit
+	x, y := n, m
+	for d := found; d > 0; d-- {
+		prev := trace[d-1]
+		base := d - 1 // prev[k+base] is the furthest x on the diagonal k
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[k-1+base] < prev[k+1+base]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[prevK+base]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			rev = append(rev, Edit{Op: Equal, OldIndex: a0 + x, NewIndex: b0 + y})
+		}
+		if x == prevX {
+			y--
+			rev = append(rev, Edit{Op: Insert, OldIndex: -1, NewIndex: b0 + y})
+		} else {
+			x--
+			rev = append(rev, Edit{Op: Delete, OldIndex: a0 + x, NewIndex: -1})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		rev = append(rev, Edit{Op: Equal, OldIndex: a0 + x, NewIndex: b0 + y})
+	}
+	for i := len(rev) - 1; i >= 0; i-- {
+		edits = append(edits, rev[i])
+	}
+	return edits
+}
+
+func replace(edits []Edit, a0, a1, b0, b1 int) []Edit {
+	for i := a0; i < a1; i++ {
+		edits = append(edits, Edit{Op: Delete, OldIndex: i, NewIndex: -1})
+	}
+	for j := b0; j < b1; j++ {
+		edits = append(edits, Edit{Op: Insert, OldIndex: -1, NewIndex: j})
+	}
+	return edits
+}
+
+// Stats returns the number of deleted and inserted elements in the edit
+// script.
+func Stats(edits []Edit) (deleted, inserted int) {
+	for _, e := range edits {
+		switch e.Op {
+		case Delete:
+			deleted++
+		case Insert:
+			inserted++
+		}
+	}
+	return deleted, inserted
+}
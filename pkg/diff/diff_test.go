@@ -0,0 +1,62 @@
+package diff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompute(t *testing.T) {
+	a := strings.Split("abcabba", "")
+	b := strings.Split("cbabac", "")
+	edits := Compute(len(a), len(b), func(i, j int) bool { return a[i] == b[j] })
+	deleted, inserted := Stats(edits)
+	assert.Equal(t, 3, deleted)
+	assert.Equal(t, 2, inserted)
+
+	// Applying the edit script to a gives b
+	var got []string
+	for _, e := range edits {
+		switch e.Op {
+		case Equal:
+			assert.Equal(t, a[e.OldIndex], b[e.NewIndex])
+			got = append(got, a[e.OldIndex])
+		case Insert:
+			got = append(got, b[e.NewIndex])
+		}
+	}
+	assert.Equal(t, b, got)
+
+	edits = Compute(0, 2, func(i, j int) bool { return false })
+	assert.Equal(t, []Edit{{Insert, -1, 0}, {Insert, -1, 1}}, edits)
+}
+
+func TestUnified(t *testing.T) {
+	assert.Equal(t, "", Unified("a", "b", "foo\nbar\n", "foo\nbar\n", 3))
+
+	old := "one\ntwo\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\n"
+	new := "one\n2\nthree\nfour\nfive\nsix\nseven\neight\nnine\nten\neleven"
+	expected := `--- a/file.txt
++++ b/file.txt
+@@ -1,4 +1,4 @@
+ one
+-two
++2
+ three
+ four
+@@ -9,2 +9,3 @@
+ nine
+ ten
++eleven
+\ No newline at end of file
+`
+	assert.Equal(t, expected, Unified("a/file.txt", "b/file.txt", old, new, 2))
+
+	expected = `--- a
++++ b
+@@ -0,0 +1 @@
++hello
+`
+	assert.Equal(t, expected, Unified("a", "b", "", "hello\n", 3))
+}
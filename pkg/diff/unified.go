@@ -0,0 +1,164 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DefaultContext is the default number of unchanged lines around the changes
+// in a unified diff.
+const DefaultContext = 3
+
+// Lines splits a text in lines, keeping the line terminators. The last line
+// has no terminator if the text doesn't end with a newline.
+func Lines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Unified returns the differences between the two texts in the unified
+// format, with the given number of context lines. It returns an empty string
+// if the texts are equal.
+func Unified(oldName, newName, oldText, newText string, context int) string {
+	unified, _, _ := UnifiedWithStats(oldName, newName, oldText, newText, context)
+	return unified
+}
+
+// UnifiedWithStats does the same as Unified, but it also returns the number
+// of deleted and inserted lines.
+func UnifiedWithStats(oldName, newName, oldText, newText string, context int) (unified string, deleted, inserted int) {
+	a, b := Lines(oldText), Lines(newText)
+	edits := Compute(len(a), len(b), func(i, j int) bool { return a[i] == b[j] })
+	deleted, inserted = Stats(edits)
+	return FormatUnified(oldName, newName, a, b, edits, context), deleted, inserted
+}
+
+// FormatUnified formats the edit script between the two lists of lines in the
+// unified format.
+func FormatUnified(oldName, newName string, a, b []string, edits []Edit, context int) string {
+	if context < 0 {
+		context = DefaultContext
+	}
+	var sb strings.Builder
+	for _, h := range hunks(edits, context) {
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+		}
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(h.oldStart, h.oldCount), hunkRange(h.newStart, h.newCount))
+		for _, e := range h.edits {
+			switch e.Op {
+			case Equal:
+				writeLine(&sb, ' ', a[e.OldIndex])
+			case Delete:
+				writeLine(&sb, '-', a[e.OldIndex])
+			case Insert:
+				writeLine(&sb, '+', b[e.NewIndex])
+			}
+		}
+	}
+	return sb.String()
+}
+
+type hunk struct {
+	oldStart, oldCount int
+	newStart, newCount int
+	edits              []Edit
+}
+
+// hunks groups the changes of the edit script, with their context lines. Two
+// groups of changes separated by less than 2*context lines are merged.
+func hunks(edits []Edit, context int) []hunk {
+	var result []hunk
+	i := 0
+	for i < len(edits) {
+		// Find the next change
+		for i < len(edits) && edits[i].Op == Equal {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		// Find the end of the hunk
+		end := i
+		for end < len(edits) {
+			if edits[end].Op != Equal {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].Op == Equal {
+				run++
+			}
+			if run == len(edits) || run-end > 2*context {
+				end += min(context, run-end)
+				break
+			}
+			end = run
+		}
+		if end > len(edits) {
+			end = len(edits)
+		}
+
+		h := hunk{edits: edits[start:end]}
+		h.oldStart, h.newStart = positions(edits, start)
+		for _, e := range h.edits {
+			switch e.Op {
+			case Equal:
+				h.oldCount++
+				h.newCount++
+			case Delete:
+				h.oldCount++
+			case Insert:
+				h.newCount++
+			}
+		}
+		result = append(result, h)
+		i = end
+	}
+	return result
+}
+
+// positions returns the number of old and new lines before the edit at the
+// given index.
+func positions(edits []Edit, index int) (int, int) {
+	oldPos, newPos := 0, 0
+	for _, e := range edits[:index] {
+		if e.Op != Insert {
+			oldPos++
+		}
+		if e.Op != Delete {
+			newPos++
+		}
+	}
+	return oldPos, newPos
+}
+
+// hunkRange formats a range for the header of a hunk: the first line is
+// 1-based, except for an empty range where it is the line before.
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func writeLine(sb *strings.Builder, prefix byte, line string) {
+	sb.WriteByte(prefix)
+	sb.WriteString(line)
+	if !strings.HasSuffix(line, "\n") {
+		sb.WriteString("\n\\ No newline at end of file\n")
+	}
+}
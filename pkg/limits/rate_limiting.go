@@ -77,6 +77,9 @@ const (
 	MagicLinkType
 	// ResendOnboardingMailType is used for resending the onboarding link by email
 	ResendOnboardingMailType
+	// BitwardenSendAccessType is used for counting the number of anonymous
+	// accesses to a bitwarden send
+	BitwardenSendAccessType
 )
 
 type counterConfig struct {
@@ -230,6 +233,12 @@ var configs = []counterConfig{
 		Limit:  2,
 		Period: 1 * time.Hour,
 	},
+	// BitwardenSendAccessType
+	{
+		Prefix: "bitwarden-send-access",
+		Limit:  100,
+		Period: 1 * time.Hour,
+	},
 }
 
 // Counter is an interface for counting number of attempts that can be used to
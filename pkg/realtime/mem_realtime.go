@@ -13,21 +13,30 @@ type memHub struct {
 	sync.RWMutex
 	topics        map[string]*topic
 	bySubscribers map[*Subscriber][]string // the list of topic keys by subscriber
+	replays       *memReplay
 }
 
 func newMemHub() *memHub {
 	return &memHub{
 		topics:        make(map[string]*topic),
 		bySubscribers: make(map[*Subscriber][]string),
+		replays:       newMemReplay(),
 	}
 }
 
 func (h *memHub) Publish(db prefixer.Prefixer, verb string, doc, oldDoc Doc) {
+	e := newEvent(db, verb, doc, oldDoc)
+	h.replays.record(e)
+	h.publish(e)
+}
+
+// publish sends an event, that already has its sequence number, to the
+// subscribers.
+func (h *memHub) publish(e *Event) {
 	h.RLock()
 	defer h.RUnlock()
 
-	e := newEvent(db, verb, doc, oldDoc)
-	key := topicKey(db, doc.DocType())
+	key := topicKey(e, e.Doc.DocType())
 	it := h.topics[key]
 	if it != nil {
 		select {
@@ -57,6 +66,10 @@ func (h *memHub) SubscribeFirehose() *Subscriber {
 	return sub
 }
 
+func (h *memHub) Replay(db prefixer.Prefixer, since uint64) ([]*Event, uint64, error) {
+	return h.replays.replay(db, since)
+}
+
 func (h *memHub) subscribe(sub *Subscriber, key string) {
 	h.Lock()
 	go func() {
@@ -64,7 +77,7 @@ func (h *memHub) subscribe(sub *Subscriber, key string) {
 
 		h.addTopic(sub, key)
 
-		w := &toWatch{sub, ""}
+		w := &toWatch{sub: sub}
 		for {
 			it, exists := h.topics[key]
 			if !exists {
@@ -98,7 +111,7 @@ func (h *memHub) unsubscribe(sub *Subscriber, key string) {
 
 		h.removeTopic(sub, key)
 
-		w := &toWatch{sub, ""}
+		w := &toWatch{sub: sub}
 		select {
 		case it.unsubscribe <- w:
 			if running := <-it.running; !running {
@@ -114,14 +127,13 @@ func (h *memHub) unsubscribe(sub *Subscriber, key string) {
 	}()
 }
 
-func (h *memHub) watch(sub *Subscriber, key, id string) {
+func (h *memHub) watch(key string, w *toWatch) {
 	h.Lock()
 	go func() {
 		defer h.Unlock()
 
-		h.addTopic(sub, key)
+		h.addTopic(w.sub, key)
 
-		w := &toWatch{sub, id}
 		for {
 			it, exists := h.topics[key]
 			if !exists {
@@ -143,7 +155,7 @@ func (h *memHub) watch(sub *Subscriber, key, id string) {
 	}()
 }
 
-func (h *memHub) unwatch(sub *Subscriber, key, id string) {
+func (h *memHub) unwatch(key string, w *toWatch) {
 	h.Lock()
 	go func() {
 		defer h.Unlock()
@@ -153,7 +165,6 @@ func (h *memHub) unwatch(sub *Subscriber, key, id string) {
 			return
 		}
 
-		w := &toWatch{sub, id}
 		select {
 		case it.unsubscribe <- w:
 			if running := <-it.running; !running {
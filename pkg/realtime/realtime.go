@@ -21,11 +21,14 @@ type Doc interface {
 	DocType() string
 }
 
-// Event is the basic message structure manipulated by the realtime package
+// Event is the basic message structure manipulated by the realtime package.
+// The events of an instance have a monotonic sequence number, that can be used
+// by the clients as a cursor to replay the events missed while reconnecting.
 type Event struct {
 	Cluster int    `json:"cluster,omitempty"`
 	Domain  string `json:"domain"`
 	Prefix  string `json:"prefix,omitempty"`
+	Seq     uint64 `json:"seq,omitempty"`
 	Verb    string `json:"verb"`
 	Doc     Doc    `json:"doc"`
 	OldDoc  Doc    `json:"old,omitempty"`
@@ -75,10 +78,16 @@ type Hub interface {
 	// cozy-stack process.
 	SubscribeFirehose() *Subscriber
 
+	// Replay returns the events of the instance with a sequence number
+	// greater than since, and the sequence number of the last event. It
+	// returns ErrResyncRequired if some of those events are no longer in the
+	// replay buffer.
+	Replay(db prefixer.Prefixer, since uint64) ([]*Event, uint64, error)
+
 	subscribe(sub *Subscriber, key string)
 	unsubscribe(sub *Subscriber, key string)
-	watch(sub *Subscriber, key, id string)
-	unwatch(sub *Subscriber, key, id string)
+	watch(key string, w *toWatch)
+	unwatch(key string, w *toWatch)
 	close(sub *Subscriber)
 }
 
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
@@ -215,6 +216,113 @@ func TestSubscribeWatchUnwatch(t *testing.T) {
 	assert.Equal(t, "id2", e.Doc.ID())
 }
 
+func TestSubscribeSelector(t *testing.T) {
+	h := newMemHub()
+	sub := h.Subscriber(testingDB)
+	defer sub.Close()
+
+	err := sub.SubscribeSelector("io.cozy.bills", mango.Map{"vendor": mango.Map{"$foo": 1}})
+	assert.Error(t, err)
+
+	selector := mango.Map{"vendor": "Energy"}
+	assert.NoError(t, sub.SubscribeSelector("io.cozy.bills", selector))
+	assert.NoError(t, sub.SubscribeSelector("io.cozy.bills", mango.Map{"amount": mango.Map{"$gt": 100}}))
+	time.Sleep(10 * time.Millisecond)
+
+	bill := func(id, vendor string, amount float64) *JSONDoc {
+		return &JSONDoc{
+			Type: "io.cozy.bills",
+			M:    map[string]interface{}{"_id": id, "vendor": vendor, "amount": amount},
+		}
+	}
+	h.Publish(testingDB, EventCreate, bill("water", "Water", 20), nil)
+	h.Publish(testingDB, EventCreate, bill("energy", "Energy", 30), nil)
+	h.Publish(testingDB, EventCreate, bill("rent", "Home", 500), nil)
+	// The old version of the document matches the selector
+	h.Publish(testingDB, EventUpdate, bill("moved", "Water", 10), bill("moved", "Energy", 10))
+
+	for _, expected := range []string{"energy", "rent", "moved"} {
+		e := <-sub.Channel
+		assert.Equal(t, expected, e.Doc.ID())
+	}
+
+	sub.UnsubscribeSelector("io.cozy.bills", selector)
+	time.Sleep(10 * time.Millisecond)
+	h.Publish(testingDB, EventUpdate, bill("energy", "Energy", 35), nil)
+	h.Publish(testingDB, EventUpdate, bill("rent", "Home", 510), nil)
+	e := <-sub.Channel
+	assert.Equal(t, "rent", e.Doc.ID())
+}
+
+func TestReplay(t *testing.T) {
+	h := newMemHub()
+	otherDB := prefixer.NewPrefixer(0, "other", "other")
+
+	_, cursor, err := h.Replay(testingDB, 0)
+	assert.ErrorIs(t, err, ErrResyncRequired)
+	events, last, err := h.Replay(testingDB, cursor)
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+	assert.Equal(t, cursor, last)
+
+	for _, id := range []string{"one", "two", "three"} {
+		h.Publish(testingDB, EventCreate, &testDoc{doctype: "io.cozy.testobject", id: id}, nil)
+	}
+	h.Publish(otherDB, EventCreate, &testDoc{doctype: "io.cozy.testobject", id: "other"}, nil)
+
+	events, last, err = h.Replay(testingDB, cursor)
+	assert.NoError(t, err)
+	assert.Equal(t, cursor+3, last)
+	if assert.Len(t, events, 3) {
+		assert.Equal(t, "one", events[0].Doc.ID())
+		assert.Equal(t, cursor+1, events[0].Seq)
+		assert.Equal(t, "three", events[2].Doc.ID())
+		assert.Equal(t, cursor+3, events[2].Seq)
+	}
+
+	events, _, err = h.Replay(testingDB, cursor+2)
+	assert.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.Equal(t, "three", events[0].Doc.ID())
+	}
+
+	// A cursor in the future is probably from before a restart
+	_, _, err = h.Replay(testingDB, last+10)
+	assert.ErrorIs(t, err, ErrResyncRequired)
+
+	// The oldest events are removed from the buffer
+	for i := 0; i < ReplayBufferSize; i++ {
+		h.Publish(testingDB, EventUpdate, &testDoc{doctype: "io.cozy.testobject", id: "one"}, nil)
+	}
+	_, last, err = h.Replay(testingDB, cursor)
+	assert.ErrorIs(t, err, ErrResyncRequired)
+	events, _, err = h.Replay(testingDB, last-ReplayBufferSize)
+	assert.NoError(t, err)
+	assert.Len(t, events, ReplayBufferSize)
+}
+
+func TestReplayEviction(t *testing.T) {
+	r := newMemReplay()
+	otherDB := prefixer.NewPrefixer(0, "other", "other")
+	r.record(newEvent(testingDB, EventCreate, &testDoc{doctype: "io.cozy.testobject", id: "one"}, nil))
+	r.record(newEvent(otherDB, EventCreate, &testDoc{doctype: "io.cozy.testobject", id: "two"}, nil))
+	_, cursor, err := r.replay(testingDB, 0)
+	assert.ErrorIs(t, err, ErrResyncRequired)
+
+	// The buffers are swept at most once per replayRetention
+	later := time.Now().Add(2 * replayRetention)
+	r.buffers[otherDB.DBPrefix()].updatedAt = later
+	r.evict(time.Now())
+	assert.Len(t, r.buffers, 2)
+	r.evict(later)
+	assert.Len(t, r.buffers, 1)
+	assert.Contains(t, r.buffers, otherDB.DBPrefix())
+
+	// The events of an evicted instance are no longer available
+	_, _, err = r.replay(testingDB, cursor-1)
+	assert.ErrorIs(t, err, ErrResyncRequired)
+}
+
 func TestRedisRealtime(t *testing.T) {
 	if testing.Short() {
 		t.Skip("a redis is required for this test: test skipped due to the use of --short flag")
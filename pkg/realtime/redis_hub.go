@@ -3,6 +3,8 @@ package realtime
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/cozy/cozy-stack/pkg/logger"
@@ -12,6 +14,46 @@ import (
 
 const eventsRedisKey = "realtime:events"
 
+// The sequence number of the last event of an instance, and its replay buffer
+// (a sorted set with the sequence numbers as scores) are stored in redis with
+// those keys. The prefix of the instance is a hash tag, to keep the two keys
+// in the same slot of a redis cluster, as they are used by the same script.
+func seqRedisKey(db prefixer.Prefixer) string {
+	return "realtime:seq:{" + db.DBPrefix() + "}"
+}
+
+func replayRedisKey(db prefixer.Prefixer) string {
+	return "realtime:replay:{" + db.DBPrefix() + "}"
+}
+
+// recordScript increments the sequence number of an instance, after
+// initializing it if needed, and adds the event to the replay buffer in the
+// same round trip. The members of the buffer are the payloads prefixed by
+// their sequence number, to keep them unique when the same event is
+// published twice. The sequence number is returned as a string, as the Lua
+// numbers are not precise enough for it.
+var recordScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  redis.call('SET', KEYS[1], ARGV[1])
+end
+redis.call('INCR', KEYS[1])
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+local seq = redis.call('GET', KEYS[1])
+redis.call('ZADD', KEYS[2], seq, seq .. ',' .. ARGV[4])
+redis.call('ZREMRANGEBYRANK', KEYS[2], 0, ARGV[3])
+redis.call('EXPIRE', KEYS[2], ARGV[2])
+return seq
+`)
+
+// lastSeqScript returns the sequence number of the last event of an instance,
+// after initializing it if needed.
+var lastSeqScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+  redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+end
+return redis.call('GET', KEYS[1])
+`)
+
 type redisHub struct {
 	c        redis.UniversalClient
 	ctx      context.Context
@@ -71,6 +113,7 @@ type jsonEvent struct {
 	Cluster int
 	Domain  string
 	Prefix  string
+	Seq     uint64
 	Verb    string
 	Doc     *JSONDoc
 	Old     *JSONDoc
@@ -86,6 +129,9 @@ func (j *jsonEvent) UnmarshalJSON(buf []byte) error {
 	}
 	j.Domain, _ = m["domain"].(string)
 	j.Prefix, _ = m["prefix"].(string)
+	if seq, ok := m["seq"].(float64); ok {
+		j.Seq = uint64(seq)
+	}
 	j.Verb, _ = m["verb"].(string)
 	if doc, ok := m["doc"].(map[string]interface{}); ok {
 		j.Doc = toJSONDoc(doc)
@@ -100,55 +146,116 @@ func (h *redisHub) start() {
 	sub := h.c.Subscribe(h.ctx, eventsRedisKey)
 	log := logger.WithNamespace("realtime-redis")
 	for msg := range sub.Channel() {
-		parts := strings.SplitN(msg.Payload, ",", 2)
-		if len(parts) < 2 {
-			log.Warnf("Invalid payload: %s", msg.Payload)
-			continue
-		}
-		// We clone the doctype to allow the GC to collect the payload even if
-		// the jsonEvent is still in use.
-		doctype := strings.Clone(parts[0])
-		r := strings.NewReader(parts[1])
-		je := jsonEvent{}
-		if err := json.NewDecoder(r).Decode(&je); err != nil {
+		e, err := decodeEvent(msg.Payload)
+		if err != nil {
 			log.Warnf("Error on start: %s", err)
 			continue
 		}
-		// Use local variables to avoid passing typed nil pointers as interface values.
-		var doc, old Doc
-		if je.Doc != nil {
-			je.Doc.Type = doctype
-			doc = je.Doc
-		}
-		if je.Old != nil {
-			je.Old.Type = doctype
-			old = je.Old
-		}
-		db := prefixer.NewPrefixer(je.Cluster, je.Domain, je.Prefix)
-		h.mem.Publish(db, je.Verb, doc, old)
+		h.mem.publish(e)
 	}
 	logger.WithNamespace("realtime-redis").Infof("End of subscribe channel")
 }
 
+// decodeEvent parses an event serialized as the doctype and the JSON of the
+// event, separated by a comma.
+func decodeEvent(payload string) (*Event, error) {
+	parts := strings.SplitN(payload, ",", 2)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid payload: %s", payload)
+	}
+	// We clone the doctype to allow the GC to collect the payload even if
+	// the jsonEvent is still in use.
+	doctype := strings.Clone(parts[0])
+	r := strings.NewReader(parts[1])
+	je := jsonEvent{}
+	if err := json.NewDecoder(r).Decode(&je); err != nil {
+		return nil, err
+	}
+	// Use local variables to avoid passing typed nil pointers as interface values.
+	var doc, old Doc
+	if je.Doc != nil {
+		je.Doc.Type = doctype
+		doc = je.Doc
+	}
+	if je.Old != nil {
+		je.Old.Type = doctype
+		old = je.Old
+	}
+	db := prefixer.NewPrefixer(je.Cluster, je.Domain, je.Prefix)
+	e := newEvent(db, je.Verb, doc, old)
+	e.Seq = je.Seq
+	return e, nil
+}
+
 func (h *redisHub) Publish(db prefixer.Prefixer, verb string, doc, oldDoc Doc) {
+	log := logger.WithNamespace("realtime-redis")
 	e := newEvent(db, verb, doc, oldDoc)
-	h.firehose.broadcast <- e
+	// The event is recorded in the replay buffer without its sequence number,
+	// as it is given by the script
 	buf, err := json.Marshal(e)
 	if err != nil {
-		log := logger.WithNamespace("realtime-redis")
 		log.Warnf("Error on publish: %s", err)
 		return
 	}
+	retention := int64(replayRetention.Seconds())
+	keys := []string{seqRedisKey(db), replayRedisKey(db)}
+	res, err := recordScript.Run(h.ctx, h.c, keys, initialSeq(), retention,
+		-ReplayBufferSize-1, e.Doc.DocType()+","+string(buf)).Text()
+	if err == nil {
+		e.Seq, err = strconv.ParseUint(res, 10, 64)
+	}
+	if err != nil {
+		log.Warnf("Error on replay buffer: %s", err)
+	} else if buf, err = json.Marshal(e); err != nil {
+		log.Warnf("Error on publish: %s", err)
+		return
+	}
+	h.firehose.broadcast <- e
 	h.c.Publish(h.ctx, eventsRedisKey, e.Doc.DocType()+","+string(buf))
 }
 
+func (h *redisHub) Replay(db prefixer.Prefixer, since uint64) ([]*Event, uint64, error) {
+	retention := int64(replayRetention.Seconds())
+	last, err := lastSeqScript.Run(h.ctx, h.c, []string{seqRedisKey(db)},
+		initialSeq(), retention).Uint64()
+	if err != nil {
+		return nil, 0, err
+	}
+	if since >= last {
+		return nil, last, checkReplay(since, last, nil)
+	}
+	members, err := h.c.ZRangeByScore(h.ctx, replayRedisKey(db), &redis.ZRangeBy{
+		Min: "(" + strconv.FormatUint(since, 10),
+		Max: "+inf",
+	}).Result()
+	if err != nil {
+		return nil, last, err
+	}
+	events := make([]*Event, 0, len(members))
+	for _, member := range members {
+		seq, payload, _ := strings.Cut(member, ",")
+		e, err := decodeEvent(payload)
+		if err != nil {
+			return nil, last, err
+		}
+		if e.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return nil, last, err
+		}
+		events = append(events, e)
+	}
+	if err := checkReplay(since, last, events); err != nil {
+		return nil, last, err
+	}
+	return events, last, nil
+}
+
 func (h *redisHub) Subscriber(db prefixer.Prefixer) *Subscriber {
 	return h.mem.Subscriber(db)
 }
 
 func (h *redisHub) SubscribeFirehose() *Subscriber {
 	sub := newSubscriber(h, globalPrefixer)
-	h.firehose.subscribe <- &toWatch{sub, ""}
+	h.firehose.subscribe <- &toWatch{sub: sub}
 	return sub
 }
 
@@ -157,15 +264,15 @@ func (h *redisHub) subscribe(sub *Subscriber, key string) {
 }
 
 func (h *redisHub) unsubscribe(sub *Subscriber, key string) {
-	h.firehose.unsubscribe <- &toWatch{sub, ""}
+	h.firehose.unsubscribe <- &toWatch{sub: sub}
 	<-h.firehose.running
 }
 
-func (h *redisHub) watch(sub *Subscriber, key, id string) {
+func (h *redisHub) watch(key string, w *toWatch) {
 	panic("not reachable code")
 }
 
-func (h *redisHub) unwatch(sub *Subscriber, key, id string) {
+func (h *redisHub) unwatch(key string, w *toWatch) {
 	panic("not reachable code")
 }
 
@@ -0,0 +1,128 @@
+package realtime
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// ReplayBufferSize is the number of events kept for each instance, to be
+// replayed to the clients that have been disconnected for a short time.
+const ReplayBufferSize = 200
+
+// replayRetention is how long the replay buffer of an instance is kept (in
+// memory or in redis) after its last event.
+const replayRetention = time.Hour
+
+// ErrResyncRequired is returned when a client asks to replay events that are
+// no longer in the replay buffer. The client should fetch again the documents
+// it is interested in.
+var ErrResyncRequired = errors.New("realtime: some events are no longer available, a full resync is required")
+
+// initialSeq returns the first sequence number for the events of an instance.
+// It is based on the current time, so that the sequence numbers given after a
+// restart of the stack (or an expiration of the buffer in redis) are greater
+// than the previous ones, and a client with an old cursor will be asked to do
+// a full resync.
+func initialSeq() uint64 {
+	return uint64(time.Now().UnixMicro())
+}
+
+// replayBuffer keeps the last events of an instance.
+type replayBuffer struct {
+	seq       uint64 // the sequence number of the last event
+	events    []*Event
+	updatedAt time.Time
+}
+
+// memReplay keeps the replay buffers in memory, for the stacks without redis.
+type memReplay struct {
+	mu        sync.Mutex
+	buffers   map[string]*replayBuffer
+	lastSweep time.Time
+}
+
+func newMemReplay() *memReplay {
+	return &memReplay{
+		buffers:   make(map[string]*replayBuffer),
+		lastSweep: time.Now(),
+	}
+}
+
+func (r *memReplay) buffer(db prefixer.Prefixer, now time.Time) *replayBuffer {
+	buf, ok := r.buffers[db.DBPrefix()]
+	if !ok || now.Sub(buf.updatedAt) > replayRetention {
+		buf = &replayBuffer{seq: initialSeq(), updatedAt: now}
+		r.buffers[db.DBPrefix()] = buf
+	}
+	return buf
+}
+
+// evict removes the buffers of the instances without events for more than
+// replayRetention. The buffers are checked at most once per replayRetention,
+// as it is enough to keep the memory bounded by the instances that are
+// active.
+func (r *memReplay) evict(now time.Time) {
+	if now.Sub(r.lastSweep) < replayRetention {
+		return
+	}
+	r.lastSweep = now
+	for prefix, buf := range r.buffers {
+		if now.Sub(buf.updatedAt) > replayRetention {
+			delete(r.buffers, prefix)
+		}
+	}
+}
+
+// record gives a sequence number to the event and adds it to the buffer.
+func (r *memReplay) record(e *Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	r.evict(now)
+	buf := r.buffer(e, now)
+	buf.updatedAt = now
+	buf.seq++
+	e.Seq = buf.seq
+	if len(buf.events) < ReplayBufferSize {
+		buf.events = append(buf.events, e)
+	} else {
+		copy(buf.events, buf.events[1:])
+		buf.events[len(buf.events)-1] = e
+	}
+}
+
+func (r *memReplay) replay(db prefixer.Prefixer, since uint64) ([]*Event, uint64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf := r.buffer(db, time.Now())
+	var events []*Event
+	for _, e := range buf.events {
+		if e.Seq > since {
+			events = append(events, e)
+		}
+	}
+	if err := checkReplay(since, buf.seq, events); err != nil {
+		return nil, buf.seq, err
+	}
+	return events, buf.seq, nil
+}
+
+// checkReplay returns ErrResyncRequired if the events, with a sequence
+// number greater than since, don't start just after since. As the sequence
+// numbers of an instance are contiguous, it means that some events are
+// missing.
+func checkReplay(since, last uint64, events []*Event) error {
+	if since > last {
+		return ErrResyncRequired
+	}
+	if since == last {
+		return nil
+	}
+	if len(events) == 0 || events[0].Seq != since+1 {
+		return ErrResyncRequired
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package realtime
+
+import (
+	"encoding/json"
+
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+)
+
+// selector is a compiled mango selector, with its JSON serialization used as
+// a key to find it when unsubscribing.
+type selector struct {
+	key    string
+	filter *mango.Selector
+}
+
+func newSelector(filter mango.Map) (*selector, error) {
+	compiled, err := mango.CompileSelector(filter)
+	if err != nil {
+		return nil, err
+	}
+	key, err := json.Marshal(filter)
+	if err != nil {
+		return nil, err
+	}
+	return &selector{key: string(key), filter: compiled}, nil
+}
+
+// DocAsMap returns the document as a map, to look at its fields. It returns
+// nil if the document can't be serialized to JSON.
+func DocAsMap(doc Doc) map[string]interface{} {
+	if j, ok := doc.(*JSONDoc); ok {
+		return j.M
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil
+	}
+	return m
+}
+
+// MatchSelector returns true if the document of the event matches the mango
+// selector. For an update or a deletion, the old version of the document is
+// also checked, so that a client is notified when a document no longer
+// matches its selector.
+func MatchSelector(e *Event, filter mango.Map) bool {
+	compiled, err := mango.CompileSelector(filter)
+	if err != nil {
+		return false
+	}
+	return newEventDocs(e).match(compiled)
+}
+
+// eventDocs keeps the documents of an event as maps, to evaluate several
+// selectors on them.
+type eventDocs struct {
+	doc map[string]interface{}
+	old map[string]interface{}
+}
+
+func newEventDocs(e *Event) *eventDocs {
+	docs := &eventDocs{doc: DocAsMap(e.Doc)}
+	if e.OldDoc != nil {
+		docs.old = DocAsMap(e.OldDoc)
+	}
+	return docs
+}
+
+func (d *eventDocs) match(filter *mango.Selector) bool {
+	if d.doc != nil && filter.Match(d.doc) {
+		return true
+	}
+	return d.old != nil && filter.Match(d.old)
+}
@@ -1,6 +1,7 @@
 package realtime
 
 import (
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
 )
 
@@ -48,7 +49,7 @@ func (sub *Subscriber) Watch(doctype, id string) {
 		return
 	}
 	key := topicKey(sub, doctype)
-	sub.hub.watch(sub, key, id)
+	sub.hub.watch(key, &toWatch{sub: sub, id: id})
 }
 
 // Unwatch removes a listener for events for a specific document (doctype+id)
@@ -57,7 +58,36 @@ func (sub *Subscriber) Unwatch(doctype, id string) {
 		return
 	}
 	key := topicKey(sub, doctype)
-	sub.hub.unwatch(sub, key, id)
+	sub.hub.unwatch(key, &toWatch{sub: sub, id: id})
+}
+
+// SubscribeSelector adds a listener for events on the documents of a doctype
+// that match a mango selector. An error is returned if the selector is not
+// supported.
+func (sub *Subscriber) SubscribeSelector(doctype string, filter mango.Map) error {
+	sel, err := newSelector(filter)
+	if err != nil {
+		return err
+	}
+	if sub.hub == nil {
+		return nil
+	}
+	key := topicKey(sub, doctype)
+	sub.hub.watch(key, &toWatch{sub: sub, selector: sel})
+	return nil
+}
+
+// UnsubscribeSelector removes a listener added with SubscribeSelector.
+func (sub *Subscriber) UnsubscribeSelector(doctype string, filter mango.Map) {
+	if sub.hub == nil {
+		return
+	}
+	sel, err := newSelector(filter)
+	if err != nil {
+		return
+	}
+	key := topicKey(sub, doctype)
+	sub.hub.unwatch(key, &toWatch{sub: sub, selector: sel})
 }
 
 // Close will unsubscribe to all topics and the subscriber should no longer be
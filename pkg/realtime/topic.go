@@ -1,13 +1,15 @@
 package realtime
 
 type filter struct {
-	whole bool // true if the events for the whole doctype should be sent
-	ids   []string
+	whole     bool // true if the events for the whole doctype should be sent
+	ids       []string
+	selectors []*selector
 }
 
 type toWatch struct {
-	sub *Subscriber
-	id  string // empty string means the whole doctype
+	sub      *Subscriber
+	id       string    // empty string means the whole doctype
+	selector *selector // or the documents that match a selector
 }
 
 type topic struct {
@@ -49,6 +51,9 @@ func (t *topic) loop() {
 }
 
 func (t *topic) publish(e *Event) {
+	// The documents are converted to maps only if there is a subscriber with
+	// a selector, and only once for all the subscribers.
+	var docs *eventDocs
 	for s, f := range t.subs {
 		ok := false
 		if f.whole {
@@ -61,6 +66,17 @@ func (t *topic) publish(e *Event) {
 				}
 			}
 		}
+		if !ok && len(f.selectors) > 0 {
+			if docs == nil {
+				docs = newEventDocs(e)
+			}
+			for _, sel := range f.selectors {
+				if docs.match(sel.filter) {
+					ok = true
+					break
+				}
+			}
+		}
 		if ok {
 			select {
 			case s.Channel <- e:
@@ -72,28 +88,46 @@ func (t *topic) publish(e *Event) {
 
 func (t *topic) doSubscribe(w *toWatch) {
 	f := t.subs[w.sub]
-	if w.id == "" {
+	switch {
+	case w.selector != nil:
+		for _, sel := range f.selectors {
+			if sel.key == w.selector.key {
+				return
+			}
+		}
+		f.selectors = append(f.selectors, w.selector)
+	case w.id == "":
 		f.whole = true
-	} else {
+	default:
 		f.ids = append(f.ids, w.id)
 	}
 	t.subs[w.sub] = f
 }
 
 func (t *topic) doUnsubscribe(w *toWatch) {
-	if w.id == "" {
+	if w.id == "" && w.selector == nil {
 		delete(t.subs, w.sub)
 	} else if f, ok := t.subs[w.sub]; ok {
-		ids := f.ids[:0]
-		for _, id := range f.ids {
-			if id != w.id {
-				ids = append(ids, id)
+		if w.selector != nil {
+			selectors := f.selectors[:0]
+			for _, sel := range f.selectors {
+				if sel.key != w.selector.key {
+					selectors = append(selectors, sel)
+				}
+			}
+			f.selectors = selectors
+		} else {
+			ids := f.ids[:0]
+			for _, id := range f.ids {
+				if id != w.id {
+					ids = append(ids, id)
+				}
 			}
+			f.ids = ids
 		}
-		if len(ids) == 0 && !f.whole {
+		if len(f.ids) == 0 && len(f.selectors) == 0 && !f.whole {
 			delete(t.subs, w.sub)
 		} else {
-			f.ids = ids
 			t.subs[w.sub] = f
 		}
 	}
@@ -0,0 +1,140 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+
+	"github.com/ugorji/go/codec"
+)
+
+// The COSE algorithms that are supported, see
+// https://www.iana.org/assignments/cose/cose.xhtml#algorithms
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms is the list of the COSE algorithms accepted for the
+// credentials, by order of preference.
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// The COSE key types and curves
+const (
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// The labels of the COSE key parameters
+const (
+	coseLabelKeyType = 1
+	coseLabelAlg     = 3
+	coseLabelCurve   = -1 // or n for RSA
+	coseLabelX       = -2 // or e for RSA
+	coseLabelY       = -3
+)
+
+// cborHandle is used to decode the CBOR data sent by the authenticators. The
+// integers are decoded as int64, as the COSE labels and algorithms can be
+// negative.
+var cborHandle = &codec.CborHandle{
+	BasicHandle: codec.BasicHandle{
+		DecodeOptions: codec.DecodeOptions{
+			SignedInteger: true,
+			MaxDepth:      16,
+		},
+	},
+}
+
+// decodeCBOR decodes the first item of data in v, and returns the bytes that
+// follow it.
+func decodeCBOR(data []byte, v interface{}) ([]byte, error) {
+	dec := codec.NewDecoderBytes(data, cborHandle)
+	if err := dec.Decode(v); err != nil {
+		return nil, err
+	}
+	return data[dec.NumBytesRead():], nil
+}
+
+// coseKey is a public key of a credential.
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parseCOSEKey parses a public key in the COSE_Key format, and returns it
+// with the bytes that follow it.
+func parseCOSEKey(data []byte) (*coseKey, []byte, error) {
+	var params map[int64]interface{}
+	rest, err := decodeCBOR(data, &params)
+	if err != nil {
+		return nil, nil, ErrUnsupportedKey
+	}
+	kty, _ := params[coseLabelKeyType].(int64)
+	alg, _ := params[coseLabelAlg].(int64)
+
+	switch {
+	case kty == coseKeyTypeEC2 && alg == AlgES256:
+		crv, _ := params[coseLabelCurve].(int64)
+		x, _ := params[coseLabelX].([]byte)
+		y, _ := params[coseLabelY].([]byte)
+		if crv != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, nil, ErrUnsupportedKey
+		}
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, nil, ErrUnsupportedKey
+		}
+		return &coseKey{alg: alg, key: key}, rest, nil
+
+	case kty == coseKeyTypeOKP && alg == AlgEdDSA:
+		crv, _ := params[coseLabelCurve].(int64)
+		x, _ := params[coseLabelX].([]byte)
+		if crv != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, nil, ErrUnsupportedKey
+		}
+		return &coseKey{alg: alg, key: ed25519.PublicKey(x)}, rest, nil
+
+	case kty == coseKeyTypeRSA && alg == AlgRS256:
+		n, _ := params[coseLabelCurve].([]byte)
+		e, _ := params[coseLabelX].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, nil, ErrUnsupportedKey
+		}
+		exp := 0
+		for _, b := range e {
+			exp = exp<<8 | int(b)
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exp}
+		return &coseKey{alg: alg, key: key}, rest, nil
+	}
+	return nil, nil, ErrUnsupportedKey
+}
+
+// verify checks the signature of the message.
+func (k *coseKey) verify(message, signature []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, hash[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, signature)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	}
+	return false
+}
@@ -0,0 +1,360 @@
+// Package webauthn implements the server side of the Web Authentication API
+// (https://www.w3.org/TR/webauthn-2/): the registration of the credentials
+// (passkeys, security keys) and the verification of the assertions made
+// with them.
+//
+// The attestation statements are not verified, as the credentials are
+// requested with the "none" conveyance preference: we trust the
+// authenticator on first use, like for the other passkeys managers.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// ChallengeLen is the number of random bytes of a challenge.
+const ChallengeLen = 32
+
+// Timeout is the time (in milliseconds) given to the user to complete a
+// ceremony.
+const Timeout = 300000
+
+var (
+	// ErrInvalidResponse is used when the response of the authenticator
+	// cannot be parsed.
+	ErrInvalidResponse = errors.New("webauthn: invalid response")
+	// ErrChallengeMismatch is used when the response has not been made for
+	// the expected challenge.
+	ErrChallengeMismatch = errors.New("webauthn: challenge mismatch")
+	// ErrOriginMismatch is used when the response has been made on an origin
+	// that is not allowed.
+	ErrOriginMismatch = errors.New("webauthn: origin mismatch")
+	// ErrRelyingPartyMismatch is used when the response has been made for
+	// another relying party.
+	ErrRelyingPartyMismatch = errors.New("webauthn: relying party mismatch")
+	// ErrUserNotPresent is used when the authenticator has not checked the
+	// presence of the user.
+	ErrUserNotPresent = errors.New("webauthn: user not present")
+	// ErrUserNotVerified is used when a verification of the user (PIN,
+	// biometrics) was required, but has not been done.
+	ErrUserNotVerified = errors.New("webauthn: user not verified")
+	// ErrUnsupportedKey is used when the public key of a credential uses an
+	// algorithm that is not supported.
+	ErrUnsupportedKey = errors.New("webauthn: unsupported public key")
+	// ErrInvalidSignature is used when the signature of an assertion is not
+	// valid.
+	ErrInvalidSignature = errors.New("webauthn: invalid signature")
+	// ErrClonedAuthenticator is used when the signature counter has not
+	// increased, which is a sign that the authenticator may have been cloned.
+	ErrClonedAuthenticator = errors.New("webauthn: the signature counter has not increased")
+)
+
+// The flags of the authenticator data
+const (
+	flagUserPresent   = 0x01
+	flagUserVerified  = 0x04
+	flagBackupElig    = 0x08
+	flagAttestedData  = 0x40
+	authDataMinLength = 37
+)
+
+// RelyingParty is the server that uses WebAuthn to authenticate its users.
+type RelyingParty struct {
+	// ID is the domain for which the credentials are scoped.
+	ID string
+	// Name is displayed by the browser when a credential is created.
+	Name string
+	// Origins is the list of the origins (scheme + host) from which the
+	// ceremonies can be made.
+	Origins []string
+}
+
+// User describes the user account for a new credential.
+type User struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// Bytes is a slice of bytes that is serialized in JSON with the base64url
+// encoding, like in the WebAuthn JSON serialization.
+type Bytes []byte
+
+// MarshalJSON implements the json.Marshaler interface.
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := DecodeBase64(s)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// DecodeBase64 decodes a string in base64url, with or without padding.
+func DecodeBase64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// CredentialDescriptor identifies a credential in the options.
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+// CreationOptions are the options for navigator.credentials.create().
+type CreationOptions struct {
+	RP struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User             User  `json:"user"`
+	Challenge        Bytes `json:"challenge"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions are the options for navigator.credentials.get().
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// AttestationResponse is the response of the authenticator for the creation
+// of a credential, with the fields encoded in base64url.
+type AttestationResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AttestationObject Bytes `json:"attestationObject"`
+}
+
+// AssertionResponse is the response of the authenticator for an
+// authentication, with the fields encoded in base64url.
+type AssertionResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+	UserHandle        Bytes `json:"userHandle,omitempty"`
+}
+
+// attestationObject is the CBOR structure returned by the authenticator for
+// the creation of a credential. The format and the attestation statement are
+// ignored.
+type attestationObject struct {
+	AuthData []byte `codec:"authData"`
+}
+
+// Credential is a public key credential that has been registered.
+type Credential struct {
+	ID             []byte
+	PublicKey      []byte // In the COSE_Key format
+	SignCount      uint32
+	AAGUID         []byte
+	UserVerified   bool
+	BackupEligible bool
+}
+
+// CreationOptions returns the options for registering a new credential. The
+// residentKey option asks for a discoverable credential, that can be used
+// without typing a username or a password.
+func (rp *RelyingParty) CreationOptions(user User, challenge []byte, exclude [][]byte, residentKey bool) *CreationOptions {
+	opts := &CreationOptions{
+		User:        user,
+		Challenge:   challenge,
+		Timeout:     Timeout,
+		Attestation: "none",
+	}
+	opts.RP.ID = rp.ID
+	opts.RP.Name = rp.Name
+	for _, alg := range SupportedAlgorithms {
+		opts.PubKeyCredParams = append(opts.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{Type: "public-key", Alg: alg})
+	}
+	opts.ExcludeCredentials = descriptors(exclude)
+	opts.AuthenticatorSelection.ResidentKey = "discouraged"
+	opts.AuthenticatorSelection.UserVerification = "preferred"
+	if residentKey {
+		opts.AuthenticatorSelection.ResidentKey = "required"
+		opts.AuthenticatorSelection.UserVerification = "required"
+	}
+	return opts
+}
+
+// RequestOptions returns the options for an authentication. An empty list of
+// allowed credentials means that a discoverable credential must be used.
+func (rp *RelyingParty) RequestOptions(challenge []byte, allow [][]byte, userVerification bool) *RequestOptions {
+	opts := &RequestOptions{
+		Challenge:        challenge,
+		Timeout:          Timeout,
+		RPID:             rp.ID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: "preferred",
+	}
+	if userVerification {
+		opts.UserVerification = "required"
+	}
+	return opts
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, len(ids))
+	for i, id := range ids {
+		list[i] = CredentialDescriptor{Type: "public-key", ID: id}
+	}
+	return list
+}
+
+// VerifyRegistration checks the response of the authenticator for the
+// creation of a credential, and returns this credential.
+func (rp *RelyingParty) VerifyRegistration(challenge []byte, res *AttestationResponse, requireUV bool) (*Credential, error) {
+	if err := rp.verifyClientData(res.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	var obj attestationObject
+	if _, err := decodeCBOR(res.AttestationObject, &obj); err != nil {
+		return nil, ErrInvalidResponse
+	}
+	authData := obj.AuthData
+
+	flags, signCount, err := rp.verifyAuthData(authData, requireUV)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagAttestedData == 0 {
+		return nil, ErrInvalidResponse
+	}
+
+	// Attested credential data: aaguid (16) + length (2) + id + public key
+	data := authData[authDataMinLength:]
+	if len(data) < 18 {
+		return nil, ErrInvalidResponse
+	}
+	aaguid := data[:16]
+	idLen := int(binary.BigEndian.Uint16(data[16:18]))
+	data = data[18:]
+	if idLen == 0 || idLen > 1023 || len(data) < idLen {
+		return nil, ErrInvalidResponse
+	}
+	id := data[:idLen]
+	data = data[idLen:]
+	_, rest, err := parseCOSEKey(data)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := data[:len(data)-len(rest)]
+
+	return &Credential{
+		ID:             append([]byte{}, id...),
+		PublicKey:      append([]byte{}, publicKey...),
+		SignCount:      signCount,
+		AAGUID:         append([]byte{}, aaguid...),
+		UserVerified:   flags&flagUserVerified != 0,
+		BackupEligible: flags&flagBackupElig != 0,
+	}, nil
+}
+
+// VerifyAssertion checks the response of the authenticator for an
+// authentication with the given credential, and returns the new value of the
+// signature counter.
+func (rp *RelyingParty) VerifyAssertion(challenge []byte, cred *Credential, res *AssertionResponse, requireUV bool) (uint32, error) {
+	if err := rp.verifyClientData(res.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	_, signCount, err := rp.verifyAuthData(res.AuthenticatorData, requireUV)
+	if err != nil {
+		return 0, err
+	}
+
+	key, _, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	hash := sha256.Sum256(res.ClientDataJSON)
+	message := make([]byte, 0, len(res.AuthenticatorData)+len(hash))
+	message = append(message, res.AuthenticatorData...)
+	message = append(message, hash[:]...)
+	if !key.verify(message, res.Signature) {
+		return 0, ErrInvalidSignature
+	}
+
+	// Some authenticators (like the synced passkeys) always return 0
+	if (signCount != 0 || cred.SignCount != 0) && signCount <= cred.SignCount {
+		return 0, ErrClonedAuthenticator
+	}
+	return signCount, nil
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, typ string, challenge []byte) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return ErrInvalidResponse
+	}
+	if data.Type != typ {
+		return ErrInvalidResponse
+	}
+	received, err := DecodeBase64(data.Challenge)
+	if err != nil || len(challenge) == 0 ||
+		subtle.ConstantTimeCompare(received, challenge) != 1 {
+		return ErrChallengeMismatch
+	}
+	for _, origin := range rp.Origins {
+		if data.Origin == origin {
+			return nil
+		}
+	}
+	return ErrOriginMismatch
+}
+
+func (rp *RelyingParty) verifyAuthData(authData []byte, requireUV bool) (byte, uint32, error) {
+	if len(authData) < authDataMinLength {
+		return 0, 0, ErrInvalidResponse
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return 0, 0, ErrRelyingPartyMismatch
+	}
+	flags := authData[32]
+	if flags&flagUserPresent == 0 {
+		return 0, 0, ErrUserNotPresent
+	}
+	if requireUV && flags&flagUserVerified == 0 {
+		return 0, 0, ErrUserNotVerified
+	}
+	signCount := binary.BigEndian.Uint32(authData[33:37])
+	return flags, signCount, nil
+}
@@ -0,0 +1,209 @@
+package webauthn
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ugorji/go/codec"
+)
+
+func cborEncode(v interface{}) []byte {
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, cborHandle).Encode(v); err != nil {
+		panic(err)
+	}
+	return data
+}
+
+type fakeAuthenticator struct {
+	rp    *RelyingParty
+	id    []byte
+	key   *ecdsa.PrivateKey
+	count uint32
+	flags byte
+}
+
+func newFakeAuthenticator(t *testing.T, rp *RelyingParty) *fakeAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return &fakeAuthenticator{
+		rp:    rp,
+		id:    []byte("credential-identifier"),
+		key:   key,
+		flags: flagUserPresent | flagUserVerified,
+	}
+}
+
+func (a *fakeAuthenticator) coseKey() []byte {
+	pub, err := a.key.PublicKey.ECDH()
+	if err != nil {
+		panic(err)
+	}
+	raw := pub.Bytes() // 0x04 || X || Y
+	return cborEncode(map[int]interface{}{
+		coseLabelKeyType: coseKeyTypeEC2,
+		coseLabelAlg:     AlgES256,
+		coseLabelCurve:   coseCurveP256,
+		coseLabelX:       raw[1:33],
+		coseLabelY:       raw[33:],
+	})
+}
+
+func (a *fakeAuthenticator) authData(flags byte, attested bool) []byte {
+	hash := sha256.Sum256([]byte(a.rp.ID))
+	data := append([]byte{}, hash[:]...)
+	if attested {
+		flags |= flagAttestedData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+func clientDataJSON(typ string, challenge []byte, origin string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        typ,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *fakeAuthenticator) create(challenge []byte, origin string) *AttestationResponse {
+	obj := cborEncode(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authData(a.flags, true),
+	})
+	return &AttestationResponse{
+		ClientDataJSON:    clientDataJSON("webauthn.create", challenge, origin),
+		AttestationObject: obj,
+	}
+}
+
+func (a *fakeAuthenticator) get(challenge []byte, origin string) *AssertionResponse {
+	a.count++
+	authData := a.authData(a.flags, false)
+	cdata := clientDataJSON("webauthn.get", challenge, origin)
+	hash := sha256.Sum256(cdata)
+	msg := sha256.Sum256(append(append([]byte{}, authData...), hash[:]...))
+	sig, err := ecdsa.SignASN1(rand.Reader, a.key, msg[:])
+	if err != nil {
+		panic(err)
+	}
+	return &AssertionResponse{
+		ClientDataJSON:    cdata,
+		AuthenticatorData: authData,
+		Signature:         sig,
+	}
+}
+
+func TestWebAuthn(t *testing.T) {
+	rp := &RelyingParty{
+		ID:      "alice.cozy.example",
+		Name:    "Cozy",
+		Origins: []string{"https://alice.cozy.example"},
+	}
+	origin := rp.Origins[0]
+	challenge := []byte("0123456789abcdef0123456789abcdef")
+	auth := newFakeAuthenticator(t, rp)
+
+	t.Run("Registration", func(t *testing.T) {
+		cred, err := rp.VerifyRegistration(challenge, auth.create(challenge, origin), true)
+		require.NoError(t, err)
+		assert.Equal(t, auth.id, cred.ID)
+		assert.True(t, cred.UserVerified)
+
+		_, err = rp.VerifyRegistration([]byte("other"), auth.create(challenge, origin), false)
+		assert.ErrorIs(t, err, ErrChallengeMismatch)
+
+		_, err = rp.VerifyRegistration(challenge, auth.create(challenge, "https://evil.example"), false)
+		assert.ErrorIs(t, err, ErrOriginMismatch)
+
+		other := &RelyingParty{ID: "bob.cozy.example", Origins: rp.Origins}
+		_, err = other.VerifyRegistration(challenge, auth.create(challenge, origin), false)
+		assert.ErrorIs(t, err, ErrRelyingPartyMismatch)
+
+		auth.flags = flagUserPresent
+		_, err = rp.VerifyRegistration(challenge, auth.create(challenge, origin), true)
+		assert.ErrorIs(t, err, ErrUserNotVerified)
+		auth.flags = flagUserPresent | flagUserVerified
+	})
+
+	t.Run("Assertion", func(t *testing.T) {
+		cred, err := rp.VerifyRegistration(challenge, auth.create(challenge, origin), false)
+		require.NoError(t, err)
+
+		count, err := rp.VerifyAssertion(challenge, cred, auth.get(challenge, origin), true)
+		require.NoError(t, err)
+		cred.SignCount = count
+
+		res := auth.get(challenge, origin)
+		res.Signature[len(res.Signature)-1] ^= 0xff
+		_, err = rp.VerifyAssertion(challenge, cred, res, false)
+		assert.ErrorIs(t, err, ErrInvalidSignature)
+
+		_, err = rp.VerifyAssertion([]byte("other"), cred, auth.get(challenge, origin), false)
+		assert.ErrorIs(t, err, ErrChallengeMismatch)
+
+		auth.count = 0
+		_, err = rp.VerifyAssertion(challenge, cred, auth.get(challenge, origin), false)
+		assert.ErrorIs(t, err, ErrClonedAuthenticator)
+	})
+
+	t.Run("Ed25519", func(t *testing.T) {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		key := cborEncode(map[int]interface{}{
+			coseLabelKeyType: coseKeyTypeOKP,
+			coseLabelAlg:     AlgEdDSA,
+			coseLabelCurve:   coseCurveEd25519,
+			coseLabelX:       []byte(pub),
+		})
+		parsed, rest, err := parseCOSEKey(key)
+		require.NoError(t, err)
+		assert.Len(t, rest, 0)
+		assert.True(t, parsed.verify([]byte("hello"), ed25519.Sign(priv, []byte("hello"))))
+		assert.False(t, parsed.verify([]byte("hello!"), ed25519.Sign(priv, []byte("hello"))))
+	})
+
+	t.Run("InvalidCBOR", func(t *testing.T) {
+		for _, data := range [][]byte{
+			{},
+			{0x5a, 0xff, 0xff, 0xff, 0xff},       // byte string too long
+			{0xbb, 0, 0, 0, 0, 0, 0, 0, 0xff, 1}, // map too long
+			cborEncode([]int{1, 2, 3}),           // not a map
+		} {
+			var params map[int64]interface{}
+			_, err := decodeCBOR(data, &params)
+			assert.Error(t, err)
+			_, _, err = parseCOSEKey(data)
+			assert.ErrorIs(t, err, ErrUnsupportedKey)
+		}
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		var b Bytes
+		require.NoError(t, json.Unmarshal([]byte(`"aGVsbG8="`), &b))
+		assert.Equal(t, "hello", string(b))
+		out, err := json.Marshal(b)
+		require.NoError(t, err)
+		assert.Equal(t, `"aGVsbG8"`, string(out))
+	})
+}
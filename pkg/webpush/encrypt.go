@@ -0,0 +1,111 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// recordSize is the record size announced in the header of the encrypted
+// content. The payloads are small enough to fit in a single record.
+const recordSize = 4096
+
+// MaxPayloadSize is the maximal size of a payload that can be encrypted in a
+// single record (the record size minus the delimiter and the AEAD tag).
+const MaxPayloadSize = recordSize - 1 - 16 - 86
+
+var (
+	// ErrInvalidKeys is used when the keys of a subscription are invalid
+	ErrInvalidKeys = errors.New("webpush: invalid keys for the subscription")
+	// ErrPayloadTooLarge is used when the payload is too large to be sent
+	ErrPayloadTooLarge = errors.New("webpush: payload too large")
+)
+
+// Encrypt encrypts the payload for the user agent with the given public key
+// (p256dh) and authentication secret, as described in RFC 8291, with the
+// aes128gcm content coding of RFC 8188.
+func Encrypt(payload []byte, p256dh, auth string) ([]byte, error) {
+	uaPublic, err := decodeBase64(p256dh)
+	if err != nil {
+		return nil, ErrInvalidKeys
+	}
+	authSecret, err := decodeBase64(auth)
+	if err != nil || len(authSecret) != 16 {
+		return nil, ErrInvalidKeys
+	}
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	return encrypt(payload, uaPublic, authSecret, asPrivate, salt)
+}
+
+func encrypt(payload, uaPublic, authSecret []byte, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, ErrPayloadTooLarge
+	}
+	uaKey, err := ecdh.P256().NewPublicKey(uaPublic)
+	if err != nil {
+		return nil, ErrInvalidKeys
+	}
+	ecdhSecret, err := asPrivate.ECDH(uaKey)
+	if err != nil {
+		return nil, ErrInvalidKeys
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+
+	// IKM = HKDF(auth_secret, ecdh_secret, "WebPush: info" || 0x00 || ua_public || as_public, 32)
+	keyInfo := make([]byte, 0, 14+65+65)
+	keyInfo = append(keyInfo, "WebPush: info\x00"...)
+	keyInfo = append(keyInfo, uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm := hkdf(authSecret, ecdhSecret, keyInfo, 32)
+
+	// CEK and NONCE, from RFC 8188
+	cek := hkdf(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce := hkdf(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// The header is salt (16) || rs (4) || idlen (1) || keyid (as_public)
+	header := make([]byte, 0, 16+4+1+len(asPublic))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asPublic)))
+	header = append(header, asPublic...)
+
+	// A single record, and so the last one, ends with the 0x02 delimiter
+	plaintext := make([]byte, 0, len(payload)+1)
+	plaintext = append(plaintext, payload...)
+	plaintext = append(plaintext, 0x02)
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+// hkdf is the HMAC-based key derivation function of RFC 5869, limited to a
+// single block of output, which is enough for Web Push.
+func hkdf(salt, ikm, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(ikm)
+	prk := extract.Sum(nil)
+	expand := hmac.New(sha256.New, prk)
+	expand.Write(info)
+	expand.Write([]byte{0x01})
+	return expand.Sum(nil)[:length]
+}
@@ -0,0 +1,115 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+)
+
+// vapidTokenTTL is the validity of the JWT sent to the push services (the
+// maximum is 24 hours).
+const vapidTokenTTL = 12 * time.Hour
+
+// ErrInvalidVAPIDKey is used when the VAPID private key cannot be parsed
+var ErrInvalidVAPIDKey = errors.New("webpush: invalid VAPID private key")
+
+// VAPID is the identification of the application server for the push
+// services, as described in RFC 8292.
+type VAPID struct {
+	PrivateKey *ecdsa.PrivateKey
+	// Subject is a contact for the push services, as a mailto: or https: URL
+	Subject string
+
+	publicKey string
+}
+
+// NewVAPID parses the private key, encoded in base64url (the raw 32 bytes of
+// the P-256 scalar), and returns the VAPID for it.
+func NewVAPID(privateKey, subject string) (*VAPID, error) {
+	raw, err := decodeBase64(privateKey)
+	if err != nil || len(raw) != 32 {
+		return nil, ErrInvalidVAPIDKey
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, ErrInvalidVAPIDKey
+	}
+	// The public key is in the uncompressed form: 0x04 || X || Y
+	pub := key.PublicKey().Bytes()
+	ecdsaKey := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(pub[1:33]),
+			Y:     new(big.Int).SetBytes(pub[33:]),
+		},
+		D: new(big.Int).SetBytes(raw),
+	}
+	if subject != "" && !strings.HasPrefix(subject, "mailto:") && !strings.HasPrefix(subject, "https:") {
+		return nil, fmt.Errorf("webpush: invalid VAPID subject %q", subject)
+	}
+	return &VAPID{
+		PrivateKey: ecdsaKey,
+		Subject:    subject,
+		publicKey:  base64.RawURLEncoding.EncodeToString(pub),
+	}, nil
+}
+
+// GenerateVAPIDKeys generates a new key pair for VAPID, and returns the
+// private and public keys encoded in base64url.
+func GenerateVAPIDKeys() (privateKey, publicKey string, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	privateKey = base64.RawURLEncoding.EncodeToString(key.Bytes())
+	publicKey = base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes())
+	return privateKey, publicKey, nil
+}
+
+// PublicKey returns the public key, in the uncompressed form and encoded in
+// base64url. It is the applicationServerKey that the browsers use for their
+// subscriptions.
+func (v *VAPID) PublicKey() string {
+	return v.publicKey
+}
+
+// authorization returns the value of the Authorization header for a request
+// to the given endpoint.
+func (v *VAPID) authorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return "", fmt.Errorf("webpush: invalid endpoint %q", endpoint)
+	}
+	claims := jwt.MapClaims{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidTokenTTL).Unix(),
+	}
+	if v.Subject != "" {
+		claims["sub"] = v.Subject
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodES256, claims).SignedString(v.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	return "vapid t=" + token + ", k=" + v.PublicKey(), nil
+}
+
+// decodeBase64 decodes the keys sent by the browsers, that use the base64url
+// encoding, with or without padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "+/") {
+		return base64.RawStdEncoding.DecodeString(s)
+	}
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,123 @@
+// Package webpush implements the Web Push protocol (RFC 8030), with the
+// encryption of the payloads (RFC 8291) and the identification of the
+// application server with VAPID (RFC 8292).
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Urgency is the urgency of a push message, as defined in RFC 8030.
+type Urgency string
+
+const (
+	// UrgencyVeryLow is for messages that can wait for the device to be on
+	// power and wifi
+	UrgencyVeryLow Urgency = "very-low"
+	// UrgencyLow is for messages that can wait for the device to be on power
+	// or wifi
+	UrgencyLow Urgency = "low"
+	// UrgencyNormal is the default urgency
+	UrgencyNormal Urgency = "normal"
+	// UrgencyHigh is for time-sensitive messages
+	UrgencyHigh Urgency = "high"
+)
+
+// DefaultTTL is the default time during which the push service keeps the
+// message if the user agent is not reachable.
+const DefaultTTL = 24 * time.Hour
+
+// ErrGone is returned when the push service says that the subscription has
+// expired or has been unsubscribed: it should not be used again.
+var ErrGone = errors.New("webpush: subscription is no longer valid")
+
+// Keys are the keys of a subscription, given by the browser.
+type Keys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// Subscription is a push subscription, in the format of the JSON
+// serialization of the PushSubscription of the browsers.
+type Subscription struct {
+	Endpoint string `json:"endpoint"`
+	Keys     Keys   `json:"keys"`
+}
+
+// Validate checks that the subscription has an https endpoint and valid keys.
+func (s *Subscription) Validate() error {
+	if len(s.Endpoint) < 9 || s.Endpoint[:8] != "https://" {
+		return fmt.Errorf("webpush: invalid endpoint %q", s.Endpoint)
+	}
+	pub, err := decodeBase64(s.Keys.P256dh)
+	if err != nil || len(pub) != 65 || pub[0] != 0x04 {
+		return ErrInvalidKeys
+	}
+	auth, err := decodeBase64(s.Keys.Auth)
+	if err != nil || len(auth) != 16 {
+		return ErrInvalidKeys
+	}
+	return nil
+}
+
+// Options are the options for sending a push message.
+type Options struct {
+	TTL     time.Duration
+	Urgency Urgency
+	// Topic can be used to replace a pending message with the same topic. It
+	// must have at most 32 characters from the base64url alphabet.
+	Topic string
+}
+
+// Send encrypts the payload and sends it to the push service of the
+// subscription.
+func Send(ctx context.Context, client *http.Client, vapid *VAPID, sub *Subscription, payload []byte, opts Options) error {
+	body, err := Encrypt(payload, sub.Keys.P256dh, sub.Keys.Auth)
+	if err != nil {
+		return err
+	}
+	authz, err := vapid.authorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	req.Header.Set("Authorization", authz)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	if opts.Urgency != "" {
+		req.Header.Set("Urgency", string(opts.Urgency))
+	}
+	if opts.Topic != "" {
+		req.Header.Set("Topic", opts.Topic)
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return ErrGone
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+	return fmt.Errorf("webpush: unexpected status code %d: %s", res.StatusCode, msg)
+}
@@ -0,0 +1,93 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	require.NoError(t, err)
+	return b
+}
+
+// TestEncrypt uses the example of the appendix A of RFC 8291
+func TestEncrypt(t *testing.T) {
+	asPrivate, err := ecdh.P256().NewPrivateKey(b64(t, "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	require.NoError(t, err)
+	uaPublic := b64(t, "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4")
+	authSecret := b64(t, "BTBZMqHH6r4Tts7J_aSIgg")
+	salt := b64(t, "DGv6ra1nlYgDCS1FRnbzlw")
+
+	body, err := encrypt([]byte("When I grow up, I want to be a watermelon"), uaPublic, authSecret, asPrivate, salt)
+	require.NoError(t, err)
+	expected := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	assert.Equal(t, expected, base64.RawURLEncoding.EncodeToString(body))
+
+	_, err = encrypt(make([]byte, MaxPayloadSize+1), uaPublic, authSecret, asPrivate, salt)
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
+
+func TestSend(t *testing.T) {
+	priv, pub, err := GenerateVAPIDKeys()
+	require.NoError(t, err)
+	vapid, err := NewVAPID(priv, "mailto:admin@example.org")
+	require.NoError(t, err)
+	assert.Equal(t, pub, vapid.PublicKey())
+
+	_, err = NewVAPID("not a key", "")
+	assert.ErrorIs(t, err, ErrInvalidVAPIDKey)
+
+	ua, err := ecdh.P256().GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sub := &Subscription{
+		Keys: Keys{
+			P256dh: base64.RawURLEncoding.EncodeToString(ua.PublicKey().Bytes()),
+			Auth:   base64.RawURLEncoding.EncodeToString([]byte("0123456789abcdef")),
+		},
+	}
+
+	status := http.StatusCreated
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "aes128gcm", r.Header.Get("Content-Encoding"))
+		assert.Equal(t, "60", r.Header.Get("TTL"))
+		assert.Equal(t, "high", r.Header.Get("Urgency"))
+		authz := r.Header.Get("Authorization")
+		require.True(t, strings.HasPrefix(authz, "vapid t="))
+		parts := strings.SplitN(strings.TrimPrefix(authz, "vapid t="), ", k=", 2)
+		require.Len(t, parts, 2)
+		assert.Equal(t, pub, parts[1])
+		token, err := jwt.Parse(parts[0], func(*jwt.Token) (interface{}, error) {
+			return &vapid.PrivateKey.PublicKey, nil
+		}, jwt.WithValidMethods([]string{"ES256"}))
+		require.NoError(t, err)
+		aud, _ := token.Claims.GetAudience()
+		assert.Equal(t, jwt.ClaimStrings{"https://" + r.Host}, aud)
+		body, _ := io.ReadAll(r.Body)
+		assert.Greater(t, len(body), 86+16)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	sub.Endpoint = ts.URL + "/push/abc"
+	require.NoError(t, sub.Validate())
+
+	opts := Options{TTL: time.Minute, Urgency: UrgencyHigh}
+	err = Send(t.Context(), ts.Client(), vapid, sub, []byte(`{"title":"Hello"}`), opts)
+	assert.NoError(t, err)
+
+	status = http.StatusGone
+	err = Send(t.Context(), ts.Client(), vapid, sub, []byte(`{"title":"Hello"}`), opts)
+	assert.ErrorIs(t, err, ErrGone)
+}
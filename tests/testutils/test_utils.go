@@ -20,6 +20,7 @@ import (
 	apps "github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/session"
@@ -34,6 +35,7 @@ import (
 	"github.com/gofrs/uuid/v5"
 	"github.com/labstack/echo/v4"
 	"github.com/ncw/swift/v2/swifttest"
+	"github.com/pquerna/otp/totp"
 	"github.com/spf13/viper"
 	"github.com/stretchr/testify/require"
 )
@@ -618,6 +620,50 @@ func WithFlag(t *testing.T, inst *instance.Instance, name string, value interfac
 	})
 }
 
+// WithTOTP enrolls an authenticator app on the instance, and uses it for the
+// two factor authentication until the end of the test. It returns the
+// recovery codes, that can be used once each as a passcode.
+func WithTOTP(t *testing.T, inst *instance.Instance) []string {
+	was := instance.AuthModeToString(inst.AuthMode)
+	enrollment, err := inst.GenerateTOTPEnrollment()
+	require.NoError(t, err)
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	require.NoError(t, err)
+	codes, err := lifecycle.EnableTOTP(inst, []byte(enrollment.Token), code)
+	require.NoError(t, err)
+
+	t.Cleanup(func() {
+		require.NoError(t, lifecycle.ResetTOTP(inst))
+		require.NoError(t, lifecycle.Patch(inst, &lifecycle.Options{AuthMode: was}))
+	})
+	return codes
+}
+
+// CountMails returns the number of mails that have been queued for the
+// instance with the given template.
+func CountMails(t *testing.T, inst *instance.Instance, template string) int {
+	var jobs []job.Job
+	err := couchdb.GetAllDocs(inst, consts.Jobs, &couchdb.AllDocsRequest{}, &jobs)
+	if couchdb.IsNoDatabaseError(err) {
+		return 0
+	}
+	require.NoError(t, err)
+
+	count := 0
+	for _, j := range jobs {
+		if j.WorkerType != "sendmail" {
+			continue
+		}
+		var msg struct {
+			TemplateName string `json:"template_name"`
+		}
+		if err := json.Unmarshal(j.Message, &msg); err == nil && msg.TemplateName == template {
+			count++
+		}
+	}
+	return count
+}
+
 func WaitForOrFail(t testing.TB, timeout time.Duration, condition func() bool) {
 	t.Helper()
 	deadline := time.Now().Add(timeout)
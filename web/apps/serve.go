@@ -265,6 +265,9 @@ func ServeAppFile(c echo.Context, i *instance.Instance, fs appfs.FileServer, web
 			if err == nil && pdoc.Password != nil && !middlewares.HasCookieForPassword(c, i, pdoc.ID()) {
 				return renderPasswordPage(c, i, pdoc.ID())
 			}
+			if err == nil && pdoc.Type == permission.TypeShareByLink {
+				middlewares.LogShareLinkAccess(c, i, pdoc, permission.ShareLinkActionView)
+			}
 		}
 	}
 
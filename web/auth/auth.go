@@ -216,6 +216,8 @@ func renderLoginForm(c echo.Context, i *instance.Instance, code int, credsErrors
 		magicLink = false
 	}
 
+	webauthnToken, webauthnOptions := webauthnLoginOptions(i)
+
 	dataProxyCleanURL := i.DataProxyCleanURL()
 	csp := c.Response().Header().Get(echo.HeaderContentSecurityPolicy)
 	csp = strings.Replace(csp, "frame-src 'none'", "frame-src "+dataProxyCleanURL+" ", 1)
@@ -241,6 +243,8 @@ func renderLoginForm(c echo.Context, i *instance.Instance, code int, credsErrors
 		"OAuth":             hasOAuth,
 		"FranceConnect":     hasFranceConnect,
 		"DataProxyCleanURL": dataProxyCleanURL,
+		"WebAuthnToken":     webauthnToken,
+		"WebAuthnOptions":   webauthnOptions,
 	})
 }
 
@@ -366,8 +370,8 @@ func login(c echo.Context) error {
 		// activated.
 		// If device is trusted, skip the 2FA.
 		// If the email has already been verified, skip the 2FA too.
-		if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) && !hasEmailVerified(c, inst) {
-			twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+		if inst.HasTwoFactor() && !isTrustedDevice(c, inst) && !hasEmailVerified(c, inst) {
+			twoFactorToken, err := createTwoFactorToken(inst)
 			if err != nil {
 				return err
 			}
@@ -873,6 +877,9 @@ func Routes(router *echo.Group) {
 	router.POST("/magic_link/twofactor", loginWithMagicLinkAndPassword, noCSRF)
 	router.POST("/magic_link/flagship", magicLinkFlagship)
 
+	// Passkeys
+	router.POST("/webauthn/login", loginWithWebAuthn, noCSRF, middlewares.CheckOnboardingNotFinished)
+
 	// Passphrase
 	router.GET("/passphrase_reset", passphraseResetForm, noCSRF)
 	router.POST("/passphrase_reset", passphraseReset, noCSRF)
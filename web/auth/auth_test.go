@@ -273,6 +273,41 @@ func TestAuth(t *testing.T) {
 		assert.False(t, results[0].CreatedAt.IsZero())
 	})
 
+	t.Run("LoginWithTOTP", func(t *testing.T) {
+		codes := testutils.WithTOTP(t, testInstance)
+		mails := testutils.CountMails(t, testInstance, "two_factor")
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		token := getLoginCSRFToken(e)
+
+		location := e.POST("/auth/login").
+			WithHost(domain).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			WithCookie("_csrf", token).
+			WithFormField("csrf_token", token).
+			WithFormField("passphrase", "MyPassphrase").
+			Expect().Status(303).
+			Header("Location").Raw()
+		u, err := url.Parse(location)
+		require.NoError(t, err)
+		assert.Equal(t, "/auth/twofactor", u.Path)
+		twoFactorToken := u.Query().Get("two_factor_token")
+		require.NotEmpty(t, twoFactorToken)
+
+		// The code comes from the authenticator app, not from a mail
+		assert.Equal(t, mails, testutils.CountMails(t, testInstance, "two_factor"))
+
+		e.POST("/auth/twofactor").
+			WithHost(domain).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			WithCookie("_csrf", token).
+			WithFormField("csrf_token", token).
+			WithFormField("two-factor-token", twoFactorToken).
+			WithFormField("two-factor-passcode", codes[0]).
+			Expect().Status(303).
+			Cookie(session.CookieName(testInstance)).Value().NotEmpty()
+	})
+
 	t.Run("LoginWithRedirect", func(t *testing.T) {
 		t.Run("success", func(t *testing.T) {
 			e := testutils.CreateTestClient(t, ts.URL)
@@ -1514,6 +1549,106 @@ func TestAuth(t *testing.T) {
 			obj.ValueEqual("scope", "*")
 			obj.ValueEqual("token_type", "bearer")
 		})
+
+		t.Run("WithWebAuthn", func(t *testing.T) {
+			require.NoError(t, lifecycle.Patch(testInstance, &lifecycle.Options{AuthMode: "two_factor_webauthn"}))
+			defer func() {
+				require.NoError(t, lifecycle.Patch(testInstance, &lifecycle.Options{AuthMode: "basic"}))
+			}()
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			e.POST("/auth/login/flagship").
+				WithHeader("Accept", "application/json").
+				WithJSON(map[string]string{
+					"passphrase":    "MyPassphrase",
+					"client_id":     client.CouchID,
+					"client_secret": client.ClientSecret,
+				}).
+				WithHost(domain).
+				Expect().Status(403).
+				JSON().Object().
+				ValueEqual("error", "webauthn required")
+
+			// A passcode like the ones sent by mail is refused
+			token, passcode, err := testInstance.GenerateTwoFactorSecrets()
+			require.NoError(t, err)
+			e.POST("/auth/login/flagship").
+				WithHeader("Accept", "application/json").
+				WithJSON(map[string]string{
+					"passphrase":          "MyPassphrase",
+					"client_id":           client.CouchID,
+					"client_secret":       client.ClientSecret,
+					"two_factor_token":    string(token),
+					"two_factor_passcode": passcode,
+				}).
+				WithHost(domain).
+				Expect().Status(403).
+				JSON().Object().
+				NotContainsKey("access_token")
+		})
+
+		t.Run("WithTOTP", func(t *testing.T) {
+			codes := testutils.WithTOTP(t, testInstance)
+			mails := testutils.CountMails(t, testInstance, "two_factor")
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			twoFactorToken := e.POST("/auth/login/flagship").
+				WithHeader("Accept", "application/json").
+				WithJSON(map[string]string{
+					"passphrase":    "MyPassphrase",
+					"client_id":     client.CouchID,
+					"client_secret": client.ClientSecret,
+				}).
+				WithHost(domain).
+				Expect().Status(401).
+				JSON().Object().
+				Value("two_factor_token").String().NotEmpty().Raw()
+
+			// The code comes from the authenticator app, not from a mail
+			assert.Equal(t, mails, testutils.CountMails(t, testInstance, "two_factor"))
+
+			e.POST("/auth/login/flagship").
+				WithHeader("Accept", "application/json").
+				WithJSON(map[string]string{
+					"passphrase":          "MyPassphrase",
+					"client_id":           client.CouchID,
+					"client_secret":       client.ClientSecret,
+					"two_factor_token":    twoFactorToken,
+					"two_factor_passcode": codes[0],
+				}).
+				WithHost(domain).
+				Expect().Status(200).
+				JSON().Object().
+				Value("access_token").String().NotEmpty()
+		})
+
+		t.Run("SessionCodeWithTOTP", func(t *testing.T) {
+			codes := testutils.WithTOTP(t, testInstance)
+			mails := testutils.CountMails(t, testInstance, "two_factor")
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			twoFactorToken := e.POST("/auth/session_code").
+				WithHeader("Accept", "application/json").
+				WithJSON(map[string]string{"passphrase": "MyPassphrase"}).
+				WithHost(domain).
+				Expect().Status(403).
+				JSON().Object().
+				Value("two_factor_token").String().NotEmpty().Raw()
+
+			assert.Equal(t, mails, testutils.CountMails(t, testInstance, "two_factor"))
+
+			e.POST("/auth/session_code").
+				WithHeader("Accept", "application/json").
+				WithJSON(map[string]string{
+					"passphrase":          "MyPassphrase",
+					"two_factor_token":    twoFactorToken,
+					"two_factor_passcode": codes[0],
+				}).
+				WithHost(domain).
+				Expect().Status(201).
+				JSON().Object().
+				Value("session_code").String().NotEmpty()
+		})
 	})
 
 	t.Run("LogoutNoToken", func(t *testing.T) {
@@ -7,7 +7,6 @@ import (
 
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
-	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -71,8 +70,8 @@ func confirmAuth(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) {
-		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+	if inst.HasTwoFactor() && !isTrustedDevice(c, inst) {
+		twoFactorToken, err := createTwoFactorToken(inst)
 		if err != nil {
 			return err
 		}
@@ -3,6 +3,7 @@ package auth
 import (
 	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -28,6 +29,9 @@ func CreateSessionCode(c echo.Context) error {
 		// OK
 	case need2FAToCreateSessionCode:
 		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+		if errors.Is(err, instance.ErrWebAuthnRequired) {
+			return webAuthnRequired(c)
+		}
 		if err != nil {
 			return err
 		}
@@ -95,7 +99,7 @@ func canCreateSessionCode(c echo.Context, inst *instance.Instance) canCreateSess
 		return cannotCreateSessionCode
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		token := []byte(args.TwoFactorToken)
 		if ok := inst.ValidateTwoFactorPasscode(token, args.TwoFactorCode); !ok {
 			return need2FAToCreateSessionCode
@@ -203,9 +207,12 @@ func loginFlagship(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) && !inst.CheckEmailVerifiedCode(args.EmailVerifiedCode) {
+	if inst.HasTwoFactor() && !inst.CheckEmailVerifiedCode(args.EmailVerifiedCode) {
 		if len(args.TwoFactorToken) == 0 {
 			twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+			if errors.Is(err, instance.ErrWebAuthnRequired) {
+				return webAuthnRequired(c)
+			}
 			if err != nil {
 				return err
 			}
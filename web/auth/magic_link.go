@@ -69,7 +69,7 @@ func loginWithMagicLink(c echo.Context) error {
 		return renderError(c, http.StatusBadRequest, "Error Invalid magic link")
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		iterations := 0
 		if settings, err := settings.Get(inst); err == nil {
 			iterations = settings.PassphraseKdfIterations
@@ -165,7 +165,7 @@ func magicLinkFlagship(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		if instance.CheckPassphrase(inst, []byte(args.Passphrase)) != nil {
 			err := config.GetRateLimiter().CheckRateLimit(inst, limits.AuthType)
 			if limits.IsLimitReachedOrExceeded(err) {
@@ -732,6 +732,9 @@ func (a *AuthorizeHTTPHandler) authorizeMoveForm(c echo.Context) error {
 			return c.Redirect(http.StatusSeeOther, inst.PageURL("/oidc/start", q))
 		}
 		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+		if errors.Is(err, instance.ErrWebAuthnRequired) {
+			return renderError(c, http.StatusForbidden, "Error WebAuthn required")
+		}
 		if err != nil {
 			return err
 		}
@@ -830,8 +833,8 @@ func (a *AuthorizeHTTPHandler) authorizeMove(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) && !isTrustedDevice(c, inst) {
-		twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+	if inst.HasTwoFactor() && !isTrustedDevice(c, inst) {
+		twoFactorToken, err := createTwoFactorToken(inst)
 		if err != nil {
 			return err
 		}
@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"strconv"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/bitwarden"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
@@ -230,6 +231,8 @@ func passphraseRenew(c echo.Context) error {
 			"error": "invalid_token",
 		})
 	}
+	middlewares.Audit(c, inst, audit.PassphraseReset, nil)
+
 	// Before deleting the ciphers, it will revoke the sharings to avoid deleting
 	// the ciphers on the Cozy instances of the other members.
 	if err := sharing.RevokeCipherSharings(inst); err == nil {
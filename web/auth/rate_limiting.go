@@ -26,7 +26,7 @@ func TwoFactorRateExceeded(i *instance.Instance) error {
 	}
 	// Reset the key and send a new passcode to the user
 	config.GetRateLimiter().ResetCounter(i, limits.TwoFactorType)
-	_, err = lifecycle.SendTwoFactorPasscode(i)
+	_, err = createTwoFactorToken(i)
 	return err
 }
 
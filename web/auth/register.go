@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
@@ -36,6 +37,7 @@ func registerClient(c echo.Context) error {
 	if err := client.Create(instance); err != nil {
 		return c.JSON(err.Code, err)
 	}
+	middlewares.Audit(c, instance, audit.OAuthClientRegistered, middlewares.OAuthClientAuditDetails(client))
 	return c.JSON(http.StatusCreated, client)
 }
 
@@ -107,6 +109,7 @@ func deleteClient(c echo.Context) error {
 	if err := client.Delete(instance); err != nil {
 		return c.JSON(err.Code, err)
 	}
+	middlewares.Audit(c, instance, audit.OAuthClientRevoked, middlewares.OAuthClientAuditDetails(client))
 	return c.NoContent(http.StatusNoContent)
 }
 
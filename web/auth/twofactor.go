@@ -1,11 +1,13 @@
 package auth
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/url"
 	"strconv"
 
 	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/session"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/limits"
@@ -36,6 +38,22 @@ func renderTwoFactorForm(c echo.Context, i *instance.Instance, code int, credsEr
 	oauth := hasRedirectToAuthorize(i, redirect)
 	trustedCheckbox := !oauth && trustedDeviceCheckBox
 
+	// The passkeys can be used instead of the passcode sent by mail, and they
+	// are the only second factor accepted with the WebAuthn mode.
+	var webauthnToken, webauthnOptions string
+	if i.ValidateTwoFactorToken(twoFactorToken) && i.HasWebAuthnCredentials(false) {
+		token, opts, err := i.WebAuthnRequestOptions(instance.WebAuthnTwoFactor, twoFactorToken)
+		if err != nil {
+			return err
+		}
+		options, err := json.Marshal(opts)
+		if err != nil {
+			return err
+		}
+		webauthnToken = string(token)
+		webauthnOptions = string(options)
+	}
+
 	return c.Render(code, "twofactor.html", echo.Map{
 		"Domain":                i.ContextualDomain(),
 		"ContextName":           i.ContextName,
@@ -50,6 +68,10 @@ func renderTwoFactorForm(c echo.Context, i *instance.Instance, code int, credsEr
 		"LongRunSession":        longRunSession,
 		"TwoFactorToken":        string(twoFactorToken),
 		"TrustedDeviceCheckBox": trustedCheckbox,
+		"Passcode":              !i.HasAuthMode(instance.TwoFactorWebAuthn),
+		"TOTP":                  i.HasAuthMode(instance.TwoFactorTOTP),
+		"WebAuthnToken":         webauthnToken,
+		"WebAuthnOptions":       webauthnOptions,
 	})
 }
 
@@ -87,7 +109,7 @@ func twoFactorForm(c echo.Context) error {
 // twoFactor handles a the twoFactor POST request
 func twoFactor(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
-	if !inst.HasAuthMode(instance.TwoFactorMail) {
+	if !inst.HasTwoFactor() {
 		errorMessage := inst.Translate(TwoFactorErrorKey)
 		return c.JSON(http.StatusUnauthorized, echo.Map{
 			"error": errorMessage,
@@ -100,8 +122,13 @@ func twoFactor(c echo.Context) error {
 	generateTrustedDeviceToken, _ := strconv.ParseBool(c.FormValue("two-factor-generate-trusted-device-token"))
 
 	// Handle 2FA failed
-	correctPasscode := inst.ValidateTwoFactorPasscode(token, passcode)
-	if !correctPasscode {
+	if c.FormValue("webauthn-token") != "" {
+		if !checkWebAuthnTwoFactor(c, inst, token) {
+			return twoFactorFailed(c, inst, token)
+		}
+	} else if inst.HasAuthMode(instance.TwoFactorWebAuthn) {
+		return twoFactorFailed(c, inst, token)
+	} else if !inst.ValidateTwoFactorPasscode(token, passcode) {
 		return twoFactorFailed(c, inst, token)
 	}
 
@@ -158,6 +185,52 @@ func twoFactor(c echo.Context) error {
 	return c.Redirect(http.StatusSeeOther, redirect.String())
 }
 
+// createTwoFactorToken starts the second part of the two factor
+// authentication, after the passphrase has been checked. With the 2FA by
+// mail, a passcode is sent to the user. With the WebAuthn mode, the passkey
+// will be asked on the two-factor form, and with the TOTP mode, the code
+// comes from the authenticator app: no mail is sent for them.
+func createTwoFactorToken(inst *instance.Instance) ([]byte, error) {
+	if inst.HasAuthMode(instance.TwoFactorWebAuthn) {
+		token, _, err := inst.GenerateTwoFactorSecrets()
+		return token, err
+	}
+	return lifecycle.SendTwoFactorPasscode(inst)
+}
+
+// CreateTwoFactorToken is the exported version of createTwoFactorToken, for
+// the other login flows that redirect to the two-factor form.
+func CreateTwoFactorToken(inst *instance.Instance) ([]byte, error) {
+	return createTwoFactorToken(inst)
+}
+
+// webAuthnRequired is the response of the JSON endpoints that can't ask for
+// a passkey, when the instance uses WebAuthn for the second factor.
+func webAuthnRequired(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, echo.Map{
+		"error": instance.ErrWebAuthnRequired.Error(),
+	})
+}
+
+// checkWebAuthnTwoFactor returns true if the request has a valid WebAuthn
+// assertion for the given two-factor token.
+func checkWebAuthnTwoFactor(c echo.Context, inst *instance.Instance, token []byte) bool {
+	if !inst.ValidateTwoFactorToken(token) {
+		return false
+	}
+	assertion, err := parseWebAuthnAssertion(c)
+	if err != nil {
+		return false
+	}
+	webauthnToken := []byte(c.FormValue("webauthn-token"))
+	err = inst.CheckWebAuthnAssertion(instance.WebAuthnTwoFactor, token, webauthnToken, assertion.ID, &assertion.Response)
+	if err != nil {
+		inst.Logger().WithNamespace("auth").Infof("Invalid WebAuthn assertion: %s", err)
+		return false
+	}
+	return true
+}
+
 // twoFactorFailed returns the 2FA form with an error message
 func twoFactorFailed(c echo.Context, inst *instance.Instance, token []byte) error {
 	errorMessage := inst.Translate(TwoFactorErrorKey)
@@ -0,0 +1,98 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/session"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/webauthn"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// webauthnAssertion is the PublicKeyCredential returned by the browser for
+// navigator.credentials.get(), serialized in JSON.
+type webauthnAssertion struct {
+	ID       string                     `json:"id"`
+	Response webauthn.AssertionResponse `json:"response"`
+}
+
+func parseWebAuthnAssertion(c echo.Context) (*webauthnAssertion, error) {
+	var assertion webauthnAssertion
+	raw := c.FormValue("webauthn-credential")
+	if raw == "" {
+		return nil, errors.New("missing credential")
+	}
+	if err := json.Unmarshal([]byte(raw), &assertion); err != nil {
+		return nil, err
+	}
+	return &assertion, nil
+}
+
+// webauthnLoginOptions returns the challenge token and the options for the
+// passwordless login, or empty strings if the user has no passkey for that.
+func webauthnLoginOptions(inst *instance.Instance) (string, string) {
+	if !inst.HasWebAuthnCredentials(true) {
+		return "", ""
+	}
+	token, opts, err := inst.WebAuthnRequestOptions(instance.WebAuthnLogin, nil)
+	if err != nil {
+		return "", ""
+	}
+	options, err := json.Marshal(opts)
+	if err != nil {
+		return "", ""
+	}
+	return string(token), string(options)
+}
+
+// loginWithWebAuthn creates a session for a user who has used a passkey,
+// without the passphrase. The passkey must have been registered for the
+// passwordless login, and the user must have been verified by the
+// authenticator (PIN, biometrics), so it is already a multi-factor
+// authentication.
+func loginWithWebAuthn(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	redirect, err := checkRedirectParam(c, inst.DefaultRedirection())
+	if err != nil {
+		return err
+	}
+
+	if _, ok := middlewares.GetSession(c); !ok {
+		assertion, err := parseWebAuthnAssertion(c)
+		if err == nil {
+			token := []byte(c.FormValue("webauthn-token"))
+			err = inst.CheckWebAuthnAssertion(instance.WebAuthnLogin, nil, token, assertion.ID, &assertion.Response)
+		}
+		if err != nil {
+			inst.Logger().WithNamespace("auth").Infof("Invalid WebAuthn assertion: %s", err)
+			errCheck := config.GetRateLimiter().CheckRateLimit(inst, limits.AuthType)
+			if limits.IsLimitReachedOrExceeded(errCheck) {
+				if err = LoginRateExceeded(inst); err != nil {
+					inst.Logger().WithNamespace("auth").Warn(err.Error())
+				}
+			}
+			return c.JSON(http.StatusUnauthorized, echo.Map{
+				"error": inst.Translate(CredentialsErrorKey),
+			})
+		}
+
+		longRunSession, _ := strconv.ParseBool(c.FormValue("long-run-session"))
+		duration := session.NormalRun
+		if longRunSession {
+			duration = session.LongRun
+		}
+		if err := newSession(c, inst, redirect, duration, "webauthn"); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"redirect": redirect.String(),
+	})
+}
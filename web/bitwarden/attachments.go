@@ -0,0 +1,366 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/vault/models/request/attachment.request.ts
+type attachmentRequest struct {
+	FileName     string `json:"fileName"`
+	Key          string `json:"key"`
+	FileSize     int64  `json:"fileSize"`
+	AdminRequest bool   `json:"adminRequest"`
+}
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/vault/models/response/attachment.response.ts
+type attachmentResponse struct {
+	ID       string  `json:"Id"`
+	URL      *string `json:"Url"`
+	FileName string  `json:"FileName"`
+	Key      *string `json:"Key"`
+	Size     int64   `json:"Size,string"`
+	SizeName string  `json:"SizeName"`
+	Object   string  `json:"Object"`
+}
+
+// newAttachmentResponse returns the description of an attachment for the
+// clients. When the content has been uploaded, the URL is a short-lived link
+// for downloading it.
+func newAttachmentResponse(inst *instance.Instance, c *bitwarden.Cipher, a *bitwarden.Attachment) *attachmentResponse {
+	r := attachmentResponse{
+		ID:       a.ID,
+		FileName: a.FileName,
+		Size:     a.Size,
+		SizeName: sizeName(a.Size),
+		Object:   "attachment",
+	}
+	if a.Key != "" {
+		r.Key = &a.Key
+	}
+	if a.IsUploaded() {
+		filepath := bitwarden.AttachmentFilePath(c, a)
+		if secret, err := vfs.GetStore().AddFile(inst, filepath); err == nil {
+			u := inst.PageURL("/files/downloads/"+secret+"/"+a.ID, nil)
+			r.URL = &u
+		}
+	}
+	return &r
+}
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/vault/models/response/attachment-upload-data.response.ts
+type attachmentUploadResponse struct {
+	AttachmentID   string          `json:"AttachmentId"`
+	URL            string          `json:"Url"`
+	FileUploadType int             `json:"FileUploadType"`
+	Cipher         *cipherResponse `json:"CipherResponse"`
+	CipherMini     *cipherResponse `json:"CipherMiniResponse"`
+	Object         string          `json:"Object"`
+}
+
+func newAttachmentUploadResponse(inst *instance.Instance, c *bitwarden.Cipher, a *bitwarden.Attachment, setting *settings.Settings) *attachmentUploadResponse {
+	return &attachmentUploadResponse{
+		AttachmentID:   a.ID,
+		URL:            "/ciphers/" + c.CouchID + "/attachment/" + a.ID,
+		FileUploadType: 0, // Direct upload to the server
+		Cipher:         newCipherResponse(inst, c, setting),
+		Object:         "attachment-fileUpload",
+	}
+}
+
+func getCipher(c echo.Context, inst *instance.Instance, id string) (*bitwarden.Cipher, error) {
+	if id == "" {
+		return nil, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "missing id",
+		})
+	}
+	cipher := &bitwarden.Cipher{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenCiphers, id, cipher); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return nil, c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return cipher, nil
+}
+
+func getAttachment(c echo.Context, inst *instance.Instance) (*bitwarden.Cipher, *bitwarden.Attachment, error) {
+	cipher, err := getCipher(c, inst, c.Param("id"))
+	if cipher == nil {
+		return nil, nil, err
+	}
+	attachment := cipher.FindAttachment(c.Param("attachment-id"))
+	if attachment == nil {
+		return nil, nil, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+	return cipher, attachment, nil
+}
+
+func saveCipherAttachments(inst *instance.Instance, cipher *bitwarden.Cipher) error {
+	if cipher.Metadata != nil {
+		cipher.Metadata.ChangeUpdatedAt()
+	}
+	if err := couchdb.UpdateDoc(inst, cipher); err != nil {
+		return err
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+	return nil
+}
+
+// CreateAttachment is the route for adding an attachment to a cipher. The
+// response gives the URL where the client can upload the (encrypted) content
+// of the file.
+func CreateAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	cipher, err := getCipher(c, inst, c.Param("id"))
+	if cipher == nil {
+		return err
+	}
+
+	var req attachmentRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.FileName == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing file name",
+		})
+	}
+	if req.Key == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing key",
+		})
+	}
+	if req.FileSize <= 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid file size",
+		})
+	}
+	if req.FileSize > bitwarden.MaxAttachmentSize {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "file is too large",
+		})
+	}
+	if err := bitwarden.CheckVaultFileSize(inst, req.FileSize); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Not enough storage available.",
+		})
+	}
+
+	attachment := cipher.AddAttachment(req.FileName, req.Key, req.FileSize)
+	if err := saveCipherAttachments(inst, cipher); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	res := newAttachmentUploadResponse(inst, cipher, attachment, setting)
+	return c.JSON(http.StatusOK, res)
+}
+
+// RenewAttachmentUpload is the route used by the clients to get again the
+// upload URL of an attachment.
+func RenewAttachmentUpload(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	cipher, attachment, err := getAttachment(c, inst)
+	if attachment == nil {
+		return err
+	}
+	if attachment.IsUploaded() {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "attachment has already been uploaded",
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	res := newAttachmentUploadResponse(inst, cipher, attachment, setting)
+	return c.JSON(http.StatusOK, res)
+}
+
+// UploadAttachment is the route for uploading the (encrypted) content of an
+// attachment. The content is sent in the data field of a multipart/form-data
+// body.
+func UploadAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	cipher, attachment, err := getAttachment(c, inst)
+	if attachment == nil {
+		return err
+	}
+
+	err = readMultipartData(c, func(_ map[string]string, content io.Reader) error {
+		return writeAttachmentFile(inst, cipher, attachment, content)
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := saveCipherAttachments(inst, cipher); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// ShareAttachment is the route used by the clients when a cipher is moved to
+// an organization: the content of the attachment is re-encrypted with the key
+// of the organization, and sent again with its new key.
+func ShareAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	cipher, attachment, err := getAttachment(c, inst)
+	if attachment == nil {
+		return err
+	}
+
+	err = readMultipartData(c, func(fields map[string]string, content io.Reader) error {
+		if key := fields["key"]; key != "" {
+			attachment.Key = key
+		}
+		// The size of the re-encrypted content is not known in advance
+		attachment.Size = -1
+		return writeAttachmentFile(inst, cipher, attachment, content)
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := saveCipherAttachments(inst, cipher); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+func writeAttachmentFile(inst *instance.Instance, cipher *bitwarden.Cipher, attachment *bitwarden.Attachment, content io.Reader) error {
+	file, doc, err := bitwarden.CreateAttachmentFile(inst, cipher, attachment)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	attachment.VFSID = doc.ID()
+	attachment.Size = doc.ByteSize
+	return nil
+}
+
+// GetAttachment returns information about an attachment, with a URL for
+// downloading its content.
+func GetAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	cipher, attachment, err := getAttachment(c, inst)
+	if attachment == nil {
+		return err
+	}
+	if !attachment.IsUploaded() {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": bitwarden.ErrAttachmentNotUploaded.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, newAttachmentResponse(inst, cipher, attachment))
+}
+
+// DeleteAttachment is the route for removing an attachment from a cipher.
+func DeleteAttachment(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	cipher, attachment, err := getAttachment(c, inst)
+	if attachment == nil {
+		return err
+	}
+	if err := bitwarden.DeleteAttachmentFile(inst, attachment); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	cipher.RemoveAttachment(attachment.ID)
+	if err := saveCipherAttachments(inst, cipher); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"Cipher": newCipherResponse(inst, cipher, setting),
+	})
+}
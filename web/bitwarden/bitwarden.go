@@ -270,6 +270,9 @@ type AccessTokenReponse struct {
 	PrivateKey interface{} `json:"PrivateKey"`
 	Kdf        int         `json:"Kdf"`
 	Iterations int         `json:"KdfIterations"`
+	// MasterPasswordPolicy is used by the clients to check the master
+	// password on login, when an organization policy requires it.
+	MasterPasswordPolicy map[string]interface{} `json:"MasterPasswordPolicy,omitempty"`
 }
 
 func getInitialCredentials(c echo.Context) error {
@@ -283,12 +286,23 @@ func getInitialCredentials(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		if !checkTwoFactor(c, inst) {
 			return nil
 		}
 	}
 
+	if err := bitwarden.CheckTwoFactorPolicy(inst); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error":             "invalid_grant",
+			"error_description": "policy_requirements_not_met",
+			"ErrorModel": map[string]string{
+				"Message": err.Error(),
+				"Object":  "error",
+			},
+		})
+	}
+
 	return RegisterClientAndReturnTokens(c, inst)
 }
 
@@ -378,6 +392,12 @@ func RegisterClientAndReturnTokens(c echo.Context, inst *instance.Instance) erro
 	if setting.PrivateKey != "" {
 		out.PrivateKey = setting.PrivateKey
 	}
+	if reqs, err := bitwarden.MasterPasswordRequirements(inst); err != nil {
+		log.Warnf("Cannot read the master password policies: %s", err)
+	} else if reqs != nil {
+		reqs["object"] = "masterPasswordPolicy"
+		out.MasterPasswordPolicy = reqs
+	}
 	return c.JSON(http.StatusOK, out)
 }
 
@@ -425,6 +445,13 @@ func checkTwoFactor(c echo.Context, inst *instance.Instance) bool {
 	}
 
 	token, err := lifecycle.SendTwoFactorPasscode(inst)
+	if errors.Is(err, instance.ErrWebAuthnRequired) {
+		_ = c.JSON(http.StatusBadRequest, echo.Map{
+			"error":             "invalid_grant",
+			"error_description": err.Error(),
+		})
+		return false
+	}
 	if err != nil {
 		_ = c.JSON(http.StatusInternalServerError, echo.Map{
 			"error": err.Error(),
@@ -433,15 +460,21 @@ func checkTwoFactor(c echo.Context, inst *instance.Instance) bool {
 	}
 	cache.Set(key, token, 5*time.Minute)
 
+	// 0 means authenticator app, and 1 means email
+	// https://github.com/bitwarden/jslib/blob/master/common/src/enums/twoFactorProviderType.ts
+	providers := []int{1}
+	providers2 := map[string]map[string]string{
+		"1": {"Email": obscured},
+	}
+	if inst.HasAuthMode(instance.TwoFactorTOTP) {
+		providers = []int{0}
+		providers2 = map[string]map[string]string{"0": nil}
+	}
 	_ = c.JSON(http.StatusBadRequest, echo.Map{
-		"error":             "invalid_grant",
-		"error_description": "Two factor required.",
-		// 1 means email
-		// https://github.com/bitwarden/jslib/blob/master/common/src/enums/twoFactorProviderType.ts
-		"TwoFactorProviders": []int{1},
-		"TwoFactorProviders2": map[string]map[string]string{
-			"1": {"Email": obscured},
-		},
+		"error":               "invalid_grant",
+		"error_description":   "Two factor required.",
+		"TwoFactorProviders":  providers,
+		"TwoFactorProviders2": providers2,
 	})
 	return false
 }
@@ -581,6 +614,14 @@ func Routes(router *echo.Group) {
 	ciphers.POST("/:id/share", ShareCipher)
 	ciphers.PUT("/:id/share", ShareCipher)
 
+	ciphers.POST("/:id/attachment/v2", CreateAttachment)
+	ciphers.GET("/:id/attachment/:attachment-id/renew", RenewAttachmentUpload)
+	ciphers.POST("/:id/attachment/:attachment-id", UploadAttachment)
+	ciphers.POST("/:id/attachment/:attachment-id/share", ShareAttachment)
+	ciphers.GET("/:id/attachment/:attachment-id", GetAttachment)
+	ciphers.DELETE("/:id/attachment/:attachment-id", DeleteAttachment)
+	ciphers.POST("/:id/attachment/:attachment-id/delete", DeleteAttachment)
+
 	folders := api.Group("/folders")
 	folders.GET("", ListFolders)
 	folders.POST("", CreateFolder)
@@ -590,6 +631,19 @@ func Routes(router *echo.Group) {
 	folders.DELETE("/:id", DeleteFolder)
 	folders.POST("/:id/delete", DeleteFolder)
 
+	sends := api.Group("/sends")
+	sends.GET("", ListSends)
+	sends.POST("", CreateSend)
+	sends.POST("/file/v2", CreateFileSend)
+	sends.GET("/:id/file/:file-id", GetSendFileUpload)
+	sends.POST("/:id/file/:file-id", UploadSendFile)
+	sends.GET("/:id", GetSend)
+	sends.PUT("/:id", UpdateSend)
+	sends.PUT("/:id/remove-password", RemoveSendPassword)
+	sends.DELETE("/:id", DeleteSend)
+	sends.POST("/access/:access-id", AccessSend)
+	sends.POST("/:access-id/access/file/:file-id", AccessSendFile)
+
 	orgs := api.Group("/organizations")
 	orgs.POST("", CreateOrganization)
 	orgs.GET("/:id", GetOrganization)
@@ -597,12 +651,42 @@ func Routes(router *echo.Group) {
 	orgs.DELETE("/:id", DeleteOrganization)
 	orgs.GET("/:id/users", ListOrganizationUser)
 	orgs.POST("/:id/users/:user-id/confirm", ConfirmUser)
+	orgs.GET("/:id/policies", ListPolicies)
+	orgs.GET("/:id/policies/:type", GetPolicy)
+	orgs.PUT("/:id/policies/:type", PutPolicy)
 
 	router.GET("/organizations/cozy", GetCozy)
 	router.DELETE("/contacts/:id", RefuseContact)
 
 	api.GET("/users/:id/public-key", GetPublicKey)
 
+	emergency := api.Group("/emergency-access")
+	emergency.GET("/trusted", ListTrustedEmergencyAccesses)
+	emergency.GET("/granted", ListGrantedEmergencyAccesses)
+	emergency.POST("/invite", InviteEmergencyAccess)
+	emergency.GET("/:id", GetEmergencyAccess)
+	emergency.PUT("/:id", UpdateEmergencyAccess)
+	emergency.POST("/:id", UpdateEmergencyAccess)
+	emergency.DELETE("/:id", DeleteEmergencyAccess)
+	emergency.POST("/:id/delete", DeleteEmergencyAccess)
+	emergency.POST("/:id/reinvite", ReinviteEmergencyAccess)
+	emergency.POST("/:id/accept", AcceptEmergencyAccess)
+	emergency.POST("/:id/confirm", ConfirmEmergencyAccess)
+	emergency.POST("/:id/initiate", InitiateEmergencyAccess)
+	emergency.POST("/:id/approve", ApproveEmergencyAccess)
+	emergency.POST("/:id/reject", RejectEmergencyAccess)
+	emergency.POST("/:id/view", ViewEmergencyAccess)
+	emergency.POST("/:id/takeover", TakeoverEmergencyAccess)
+	emergency.POST("/:id/password", PasswordEmergencyAccess)
+	emergency.GET("/:id/policies", GetEmergencyAccessPolicies)
+
+	// Routes used by the stack of the other side of an emergency access
+	remote := router.Group("/emergency-access")
+	remote.PUT("/:id", ReceiveEmergencyAccess)
+	remote.DELETE("/:id", ReceiveEmergencyAccessDeletion)
+	remote.POST("/:id/view", SendEmergencyAccessCiphers)
+	remote.POST("/:id/password", ChangeEmergencyAccessPassword)
+
 	hub := router.Group("/notifications/hub")
 	hub.GET("", WebsocketHub)
 	hub.POST("/negotiate", NegotiateHub)
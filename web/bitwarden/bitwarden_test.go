@@ -130,6 +130,85 @@ func TestBitwarden(t *testing.T) {
 		assert.NotEmpty(t, orgKey)
 	})
 
+	t.Run("ConnectWithWebAuthn", func(t *testing.T) {
+		require.NoError(t, lifecycle.Patch(inst, &lifecycle.Options{AuthMode: "two_factor_webauthn"}))
+		defer func() {
+			require.NoError(t, lifecycle.Patch(inst, &lifecycle.Options{AuthMode: "basic"}))
+		}()
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		email := inst.PassphraseSalt()
+		iter := crypto.DefaultPBKDF2Iterations
+		pass, _ := crypto.HashPassWithPBKDF2([]byte("cozy"), email, iter)
+
+		e.POST("/bitwarden/identity/connect/token").
+			WithFormField("grant_type", "password").
+			WithFormField("username", string(email)).
+			WithFormField("password", string(pass)).
+			WithFormField("scope", "api offline_access").
+			WithFormField("client_id", "browser").
+			WithFormField("deviceType", "3").
+			Expect().
+			Status(http.StatusBadRequest).
+			JSON().Object().
+			ValueEqual("error_description", "webauthn required")
+
+		// A passcode like the ones sent by mail is refused
+		twoFactorToken, passcode, err := inst.GenerateTwoFactorSecrets()
+		require.NoError(t, err)
+		config.GetConfig().CacheStorage.Set("bw-2fa:"+inst.Domain, twoFactorToken, time.Minute)
+		e.POST("/bitwarden/identity/connect/token").
+			WithFormField("grant_type", "password").
+			WithFormField("username", string(email)).
+			WithFormField("password", string(pass)).
+			WithFormField("scope", "api offline_access").
+			WithFormField("client_id", "browser").
+			WithFormField("deviceType", "3").
+			WithFormField("twoFactorToken", passcode).
+			Expect().
+			Status(http.StatusBadRequest).
+			JSON().Object().
+			NotContainsKey("access_token")
+	})
+
+	t.Run("ConnectWithTOTP", func(t *testing.T) {
+		codes := testutils.WithTOTP(t, inst)
+		mails := testutils.CountMails(t, inst, "two_factor")
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		email := inst.PassphraseSalt()
+		iter := crypto.DefaultPBKDF2Iterations
+		pass, _ := crypto.HashPassWithPBKDF2([]byte("cozy"), email, iter)
+
+		obj := e.POST("/bitwarden/identity/connect/token").
+			WithFormField("grant_type", "password").
+			WithFormField("username", string(email)).
+			WithFormField("password", string(pass)).
+			WithFormField("scope", "api offline_access").
+			WithFormField("client_id", "browser").
+			WithFormField("deviceType", "3").
+			Expect().
+			Status(http.StatusBadRequest).
+			JSON().Object()
+		obj.ValueEqual("TwoFactorProviders", []int{0})
+
+		// The code comes from the authenticator app, not from a mail
+		assert.Equal(t, mails, testutils.CountMails(t, inst, "two_factor"))
+
+		e.POST("/bitwarden/identity/connect/token").
+			WithFormField("grant_type", "password").
+			WithFormField("username", string(email)).
+			WithFormField("password", string(pass)).
+			WithFormField("scope", "api offline_access").
+			WithFormField("client_id", "browser").
+			WithFormField("deviceType", "3").
+			WithFormField("twoFactorToken", codes[0]).
+			Expect().
+			Status(http.StatusOK).
+			JSON().Object().
+			Value("access_token").String().NotEmpty()
+	})
+
 	t.Run("GetCozyOrg", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
@@ -869,6 +948,60 @@ func TestBitwarden(t *testing.T) {
 			}
 		})
 
+		t.Run("Policies", func(t *testing.T) {
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			obj := e.GET("/bitwarden/api/organizations/"+orgaID+"/policies/5").
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200).
+				JSON().Object()
+			obj.ValueEqual("Enabled", false)
+			obj.ValueEqual("Object", "policy")
+
+			obj = e.PUT("/bitwarden/api/organizations/"+orgaID+"/policies/5").
+				WithHeader("Content-Type", "application/json").
+				WithHeader("Authorization", "Bearer "+token).
+				WithBytes([]byte(`{"type": 5, "enabled": true, "data": null}`)).
+				Expect().Status(200).
+				JSON().Object()
+			obj.ValueEqual("Id", orgaID+"-5")
+			obj.ValueEqual("OrganizationId", orgaID)
+			obj.ValueEqual("Type", 5)
+			obj.ValueEqual("Enabled", true)
+
+			e.PUT("/bitwarden/api/organizations/"+orgaID+"/policies/42").
+				WithHeader("Content-Type", "application/json").
+				WithHeader("Authorization", "Bearer "+token).
+				WithBytes([]byte(`{"type": 42, "enabled": true}`)).
+				Expect().Status(404)
+
+			obj = e.GET("/bitwarden/api/organizations/"+orgaID+"/policies").
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200).
+				JSON().Object()
+			obj.ValueEqual("Object", "list")
+			obj.Value("Data").Array().Length().Equal(1)
+
+			// The owner of the organization is not subject to its policies
+			e.POST("/bitwarden/api/ciphers").
+				WithHeader("Content-Type", "application/json").
+				WithHeader("Authorization", "Bearer "+token).
+				WithBytes([]byte(`{
+        "type": 2,
+        "name": "2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=",
+        "secureNote": {"type": 0}
+      }`)).
+				Expect().Status(200)
+
+			obj = e.GET("/bitwarden/api/sync").
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200).
+				JSON().Object()
+			policies := obj.Value("Policies").Array()
+			policies.Length().Equal(1)
+			policies.First().Object().ValueEqual("Type", 5)
+		})
+
 		t.Run("DeleteOrganization", func(t *testing.T) {
 			e := testutils.CreateTestClient(t, ts.URL)
 
@@ -884,6 +1017,322 @@ func TestBitwarden(t *testing.T) {
 		})
 	})
 
+	t.Run("Sends", func(t *testing.T) {
+		var sendID, accessID string
+		deletion := time.Now().Add(7 * 24 * time.Hour).UTC().Format(time.RFC3339)
+
+		t.Run("CreateTextSend", func(t *testing.T) {
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			obj := e.POST("/bitwarden/api/sends").
+				WithHeader("Content-Type", "application/json").
+				WithHeader("Authorization", "Bearer "+token).
+				WithBytes([]byte(fmt.Sprintf(`{
+  "type": 0,
+  "name": "2.GmWNDqTxFhXisIAyUnJHbQ==|Cq7KUv0Yk7vMvX/ek1ZWMA==|lhpD7mhAa2TOAVMpxglDCRjnVQ+1Pj1Jh9clJ0bJ9Ms=",
+  "key": "2.ZdzqDJgVPvJ+oq3Hp7pVTQ==|5K1c3Ycv7NclWE5TIJMNjg==|ZnOKPLUg0I5KnBwQimYqUtHwNYmGWpRmCDYcHUqHnc8=",
+  "maxAccessCount": 2,
+  "deletionDate": %q,
+  "text": { "text": "2.B7SR2GgqtI4ON8ZvIS06Nw==|zDjxa1mBoHDIF1spwHBiRg==|uxiTXKi5z3/Jg+JOb40D7ZAmJakHcNc0O3Xpxq4t6A4=" },
+  "password": "cY3zOhLAa8hD1W0S/1zF8pYq/3dVQ3hC0H3v3T5VJ0c="
+}`, deletion))).
+				Expect().Status(200).
+				JSON().Object()
+
+			obj.ValueEqual("Object", "send")
+			obj.ValueEqual("Type", 0)
+			obj.ValueEqual("AccessCount", 0)
+			obj.ValueEqual("MaxAccessCount", 2)
+			obj.Value("Password").String().NotEmpty()
+			obj.Value("File").Null()
+			obj.Value("Text").Object().ValueEqual("Text", "2.B7SR2GgqtI4ON8ZvIS06Nw==|zDjxa1mBoHDIF1spwHBiRg==|uxiTXKi5z3/Jg+JOb40D7ZAmJakHcNc0O3Xpxq4t6A4=")
+			obj.Value("RevisionDate").String().DateTime(time.RFC3339)
+			sendID = obj.Value("Id").String().NotEmpty().Raw()
+			accessID = obj.Value("AccessId").String().NotEmpty().Raw()
+		})
+
+		t.Run("CreateSendTooFarDeletionDate", func(t *testing.T) {
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			tooFar := time.Now().Add(60 * 24 * time.Hour).UTC().Format(time.RFC3339)
+			e.POST("/bitwarden/api/sends").
+				WithHeader("Content-Type", "application/json").
+				WithHeader("Authorization", "Bearer "+token).
+				WithBytes([]byte(fmt.Sprintf(`{
+  "type": 0,
+  "name": "2.GmWNDqTxFhXisIAyUnJHbQ==|Cq7KUv0Yk7vMvX/ek1ZWMA==|lhpD7mhAa2TOAVMpxglDCRjnVQ+1Pj1Jh9clJ0bJ9Ms=",
+  "key": "2.ZdzqDJgVPvJ+oq3Hp7pVTQ==|5K1c3Ycv7NclWE5TIJMNjg==|ZnOKPLUg0I5KnBwQimYqUtHwNYmGWpRmCDYcHUqHnc8=",
+  "deletionDate": %q,
+  "text": { "text": "2.B7SR2GgqtI4ON8ZvIS06Nw==|zDjxa1mBoHDIF1spwHBiRg==|uxiTXKi5z3/Jg+JOb40D7ZAmJakHcNc0O3Xpxq4t6A4=" }
+}`, tooFar))).
+				Expect().Status(400)
+		})
+
+		t.Run("AccessTextSend", func(t *testing.T) {
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			e.POST("/bitwarden/api/sends/access/"+accessID).
+				WithHeader("Content-Type", "application/json").
+				WithBytes([]byte(`{}`)).
+				Expect().Status(401)
+
+			e.POST("/bitwarden/api/sends/access/"+accessID).
+				WithHeader("Content-Type", "application/json").
+				WithBytes([]byte(`{ "password": "wrong" }`)).
+				Expect().Status(400)
+
+			for i := 0; i < 2; i++ {
+				obj := e.POST("/bitwarden/api/sends/access/"+accessID).
+					WithHeader("Content-Type", "application/json").
+					WithBytes([]byte(`{ "password": "cY3zOhLAa8hD1W0S/1zF8pYq/3dVQ3hC0H3v3T5VJ0c=" }`)).
+					Expect().Status(200).
+					JSON().Object()
+				obj.ValueEqual("Object", "send-access")
+				obj.ValueEqual("Id", accessID)
+				obj.ValueEqual("CreatorIdentifier", "me@bitwarden.example.net")
+				obj.Value("Text").Object().Value("Text").String().NotEmpty()
+			}
+
+			// The maximal number of accesses has been reached
+			e.POST("/bitwarden/api/sends/access/"+accessID).
+				WithHeader("Content-Type", "application/json").
+				WithBytes([]byte(`{ "password": "cY3zOhLAa8hD1W0S/1zF8pYq/3dVQ3hC0H3v3T5VJ0c=" }`)).
+				Expect().Status(404)
+		})
+
+		t.Run("RemoveSendPassword", func(t *testing.T) {
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			obj := e.PUT("/bitwarden/api/sends/"+sendID+"/remove-password").
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200).
+				JSON().Object()
+			obj.Value("Password").Null()
+			obj.ValueEqual("AccessCount", 2)
+		})
+
+		t.Run("FileSend", func(t *testing.T) {
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			obj := e.POST("/bitwarden/api/sends/file/v2").
+				WithHeader("Content-Type", "application/json").
+				WithHeader("Authorization", "Bearer "+token).
+				WithBytes([]byte(fmt.Sprintf(`{
+  "type": 1,
+  "fileLength": 11,
+  "name": "2.GmWNDqTxFhXisIAyUnJHbQ==|Cq7KUv0Yk7vMvX/ek1ZWMA==|lhpD7mhAa2TOAVMpxglDCRjnVQ+1Pj1Jh9clJ0bJ9Ms=",
+  "key": "2.ZdzqDJgVPvJ+oq3Hp7pVTQ==|5K1c3Ycv7NclWE5TIJMNjg==|ZnOKPLUg0I5KnBwQimYqUtHwNYmGWpRmCDYcHUqHnc8=",
+  "deletionDate": %q,
+  "file": { "fileName": "2.0fbJ8Y6YApRNC4M8KJkWtA==|Ij5jc2VnmOPGIHlPgC9Ylg==|FxfGpF7q8PXR6hWWGePM+2bNvNqg9A1wdUo4HSVbJSA=" }
+}`, deletion))).
+				Expect().Status(200).
+				JSON().Object()
+
+			obj.ValueEqual("Object", "send-fileUpload")
+			obj.ValueEqual("FileUploadType", 0)
+			send := obj.Value("SendResponse").Object()
+			send.ValueEqual("Type", 1)
+			file := send.Value("File").Object()
+			file.ValueEqual("Size", "11")
+			fileID := file.Value("Id").String().NotEmpty().Raw()
+			id := send.Value("Id").String().NotEmpty().Raw()
+			access := send.Value("AccessId").String().NotEmpty().Raw()
+			obj.ValueEqual("Url", "/sends/"+id+"/file/"+fileID)
+
+			// The content has not been uploaded yet
+			e.POST("/bitwarden/api/sends/" + access + "/access/file/" + fileID).
+				Expect().Status(404)
+
+			e.POST("/bitwarden/api/sends/"+id+"/file/"+fileID).
+				WithHeader("Authorization", "Bearer "+token).
+				WithMultipart().
+				WithFileBytes("data", "data", []byte("hello world")).
+				Expect().Status(200)
+
+			e.POST("/bitwarden/api/sends/access/"+access).
+				Expect().Status(200).
+				JSON().Object().
+				Value("File").Object().ValueEqual("Id", fileID)
+
+			obj = e.POST("/bitwarden/api/sends/" + access + "/access/file/" + fileID).
+				Expect().Status(200).
+				JSON().Object()
+			obj.ValueEqual("Object", "send-fileDownload")
+			obj.ValueEqual("Id", fileID)
+			obj.Value("Url").String().Contains("/files/downloads/")
+
+			obj = e.GET("/bitwarden/api/sends/"+id).
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200).
+				JSON().Object()
+			obj.ValueEqual("AccessCount", 1)
+
+			e.DELETE("/bitwarden/api/sends/"+id).
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200)
+
+			_, err := inst.VFS().FileByPath(bitwarden.SendsDirName + "/" + id + "-" + fileID)
+			assert.Error(t, err)
+		})
+
+		t.Run("SyncSends", func(t *testing.T) {
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			obj := e.GET("/bitwarden/api/sync").
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200).
+				JSON().Object()
+
+			sends := obj.Value("Sends").Array()
+			sends.Length().Equal(1)
+			sends.First().Object().ValueEqual("Id", sendID)
+		})
+
+		t.Run("DeleteSend", func(t *testing.T) {
+			e := testutils.CreateTestClient(t, ts.URL)
+
+			e.DELETE("/bitwarden/api/sends/"+sendID).
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200)
+
+			e.GET("/bitwarden/api/sends").
+				WithHeader("Authorization", "Bearer "+token).
+				Expect().Status(200).
+				JSON().Object().
+				Value("Data").Array().Empty()
+		})
+	})
+
+	t.Run("Attachments", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		id := e.POST("/bitwarden/api/ciphers").
+			WithHeader("Content-Type", "application/json").
+			WithHeader("Authorization", "Bearer "+token).
+			WithBytes([]byte(`{
+      "type": 2,
+      "name": "2.d7MttWzJTSSKx1qXjHUxlQ==|01Ath5UqFZHk7csk5DVtkQ==|EMLoLREgCUP5Cu4HqIhcLqhiZHn+NsUDp8dAg1Xu0Io=",
+      "secureNote": { "type": 0 }
+    }`)).
+			Expect().Status(200).
+			JSON().Object().
+			Value("Id").String().NotEmpty().Raw()
+
+		obj := e.POST("/bitwarden/api/ciphers/"+id+"/attachment/v2").
+			WithHeader("Content-Type", "application/json").
+			WithHeader("Authorization", "Bearer "+token).
+			WithBytes([]byte(`{
+  "fileName": "2.0fbJ8Y6YApRNC4M8KJkWtA==|Ij5jc2VnmOPGIHlPgC9Ylg==|FxfGpF7q8PXR6hWWGePM+2bNvNqg9A1wdUo4HSVbJSA=",
+  "key": "2.ZdzqDJgVPvJ+oq3Hp7pVTQ==|5K1c3Ycv7NclWE5TIJMNjg==|ZnOKPLUg0I5KnBwQimYqUtHwNYmGWpRmCDYcHUqHnc8=",
+  "fileSize": 11
+}`)).
+			Expect().Status(200).
+			JSON().Object()
+
+		obj.ValueEqual("Object", "attachment-fileUpload")
+		obj.ValueEqual("FileUploadType", 0)
+		attID := obj.Value("AttachmentId").String().NotEmpty().Raw()
+		obj.ValueEqual("Url", "/ciphers/"+id+"/attachment/"+attID)
+		atts := obj.Value("CipherResponse").Object().Value("Attachments").Array()
+		atts.Length().Equal(1)
+		atts.First().Object().ValueEqual("Id", attID)
+		atts.First().Object().ValueEqual("Size", "11")
+		atts.First().Object().Value("Url").Null()
+
+		e.GET("/bitwarden/api/ciphers/"+id+"/attachment/"+attID+"/renew").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object().
+			ValueEqual("AttachmentId", attID)
+
+		// The size must match the one given on creation
+		e.POST("/bitwarden/api/ciphers/"+id+"/attachment/"+attID).
+			WithHeader("Authorization", "Bearer "+token).
+			WithMultipart().
+			WithFileBytes("data", "data", []byte("hello")).
+			Expect().Status(400)
+
+		e.POST("/bitwarden/api/ciphers/"+id+"/attachment/"+attID).
+			WithHeader("Authorization", "Bearer "+token).
+			WithMultipart().
+			WithFileBytes("data", "data", []byte("hello world")).
+			Expect().Status(200)
+
+		obj = e.GET("/bitwarden/api/ciphers/"+id+"/attachment/"+attID).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		obj.ValueEqual("Object", "attachment")
+		obj.ValueEqual("Id", attID)
+		obj.Value("Url").String().Contains("/files/downloads/")
+		doc, err := inst.VFS().FileByPath(bitwarden.AttachmentsDirName + "/" + id + "-" + attID)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 11, doc.ByteSize)
+
+		obj = e.GET("/bitwarden/api/sync").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		for _, c := range obj.Value("Ciphers").Array().Iter() {
+			if c.Object().Value("Id").Raw() == id {
+				c.Object().Value("Attachments").Array().Length().Equal(1)
+			}
+		}
+
+		// A second attachment, to check that it is removed with the cipher
+		obj = e.POST("/bitwarden/api/ciphers/"+id+"/attachment/v2").
+			WithHeader("Content-Type", "application/json").
+			WithHeader("Authorization", "Bearer "+token).
+			WithBytes([]byte(`{"fileName": "2.foo", "key": "2.bar", "fileSize": 3}`)).
+			Expect().Status(200).
+			JSON().Object()
+		otherID := obj.Value("AttachmentId").String().NotEmpty().Raw()
+		e.POST("/bitwarden/api/ciphers/"+id+"/attachment/"+otherID).
+			WithHeader("Authorization", "Bearer "+token).
+			WithMultipart().
+			WithFileBytes("data", "data", []byte("foo")).
+			Expect().Status(200)
+
+		obj = e.DELETE("/bitwarden/api/ciphers/"+id+"/attachment/"+attID).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		atts = obj.Value("Cipher").Object().Value("Attachments").Array()
+		atts.Length().Equal(1)
+		atts.First().Object().ValueEqual("Id", otherID)
+		_, err = inst.VFS().FileByPath(bitwarden.AttachmentsDirName + "/" + id + "-" + attID)
+		assert.Error(t, err)
+
+		e.DELETE("/bitwarden/api/ciphers/"+id).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200)
+		_, err = inst.VFS().FileByPath(bitwarden.AttachmentsDirName + "/" + id + "-" + otherID)
+		assert.Error(t, err)
+	})
+
+	t.Run("EmergencyAccess", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		obj := e.GET("/bitwarden/api/emergency-access/trusted").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		obj.ValueEqual("Object", "list")
+		obj.Value("Data").Array().Empty()
+
+		e.POST("/bitwarden/api/emergency-access/invite").
+			WithHeader("Content-Type", "application/json").
+			WithHeader("Authorization", "Bearer "+token).
+			WithBytes([]byte(`{"email": "unknown@example.net", "type": 0, "waitTimeDays": 7}`)).
+			Expect().Status(404)
+
+		e.PUT("/bitwarden/emergency-access/2a1f5d40e3c1013b2c8d543d7eb8149c").
+			WithHeader("Content-Type", "application/json").
+			WithHeader("Authorization", "Bearer forged-token").
+			WithBytes([]byte(`{"_id": "2a1f5d40e3c1013b2c8d543d7eb8149c", "status": 4}`)).
+			Expect().Status(400)
+	})
+
 	t.Run("ChangeSecurityStamp", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
@@ -8,6 +8,7 @@ import (
 
 	"github.com/cozy/cozy-stack/model/bitwarden"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
@@ -39,6 +40,30 @@ type cipherRequest struct {
 	SecureNote     bitwarden.MapData    `json:"securenote"`
 	Card           bitwarden.MapData    `json:"card"`
 	Identity       bitwarden.MapData    `json:"identity"`
+	// The clients send the new names and keys of the attachments when they
+	// are encrypted again, for example when the cipher is shared.
+	Attachments2 map[string]attachmentKeyRequest `json:"attachments2"`
+}
+
+type attachmentKeyRequest struct {
+	FileName string `json:"fileName"`
+	Key      string `json:"key"`
+}
+
+// keepAttachments copies the attachments of the old cipher to the new one,
+// as they are not sent by the clients with the other fields of the cipher.
+func (r *cipherRequest) keepAttachments(cipher, old *bitwarden.Cipher) {
+	for _, a := range old.Attachments {
+		if keys, ok := r.Attachments2[a.ID]; ok {
+			if keys.FileName != "" {
+				a.FileName = keys.FileName
+			}
+			if keys.Key != "" {
+				a.Key = keys.Key
+			}
+		}
+		cipher.Attachments = append(cipher.Attachments, a)
+	}
 }
 
 func (r *cipherRequest) toCipher() (*bitwarden.Cipher, error) {
@@ -121,7 +146,7 @@ type cipherResponse struct {
 	OrganizationID *string                `json:"OrganizationId"`
 	CollectionIDs  []string               `json:"CollectionIds"`
 	Fields         interface{}            `json:"Fields"`
-	Attachments    *string                `json:"Attachments"`
+	Attachments    []*attachmentResponse  `json:"Attachments"`
 	Login          *loginResponse         `json:"Login,omitempty"`
 	SecureNote     map[string]interface{} `json:"SecureNote,omitempty"`
 	Card           map[string]interface{} `json:"Card,omitempty"`
@@ -147,7 +172,7 @@ func titleizeKeys(data bitwarden.MapData) map[string]interface{} {
 	return res
 }
 
-func newCipherResponse(c *bitwarden.Cipher, setting *settings.Settings) *cipherResponse {
+func newCipherResponse(inst *instance.Instance, c *bitwarden.Cipher, setting *settings.Settings) *cipherResponse {
 	r := cipherResponse{
 		Object:   "cipher",
 		ID:       c.CouchID,
@@ -191,6 +216,10 @@ func newCipherResponse(c *bitwarden.Cipher, setting *settings.Settings) *cipherR
 		r.Fields = fields
 	}
 
+	for i := range c.Attachments {
+		r.Attachments = append(r.Attachments, newAttachmentResponse(inst, c, &c.Attachments[i]))
+	}
+
 	switch c.Type {
 	case bitwarden.LoginType:
 		if c.Login != nil {
@@ -264,7 +293,7 @@ func ListCiphers(c echo.Context) error {
 
 	res := &ciphersList{Object: "list"}
 	for _, f := range ciphers {
-		res.Data = append(res.Data, newCipherResponse(f, setting))
+		res.Data = append(res.Data, newCipherResponse(inst, f, setting))
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -291,6 +320,11 @@ func CreateCipher(c echo.Context) error {
 			"error": err.Error(),
 		})
 	}
+	if err := bitwarden.CheckPersonalOwnership(inst); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
 
 	if cipher.FolderID != "" {
 		folder := &bitwarden.Folder{}
@@ -315,7 +349,7 @@ func CreateCipher(c echo.Context) error {
 	}
 
 	_ = settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -381,7 +415,7 @@ func CreateSharedCipher(c echo.Context) error {
 	}
 
 	_ = settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -420,7 +454,7 @@ func GetCipher(c echo.Context) error {
 		})
 	}
 
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -474,6 +508,8 @@ func UpdateCipher(c echo.Context) error {
 		}
 	}
 
+	req.keepAttachments(cipher, old)
+
 	// XXX On an update, the client send the OrganizationId but not the
 	// collectionIds.
 	if req.OrganizationID != "" {
@@ -505,7 +541,7 @@ func UpdateCipher(c echo.Context) error {
 	}
 
 	_ = settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -542,6 +578,7 @@ func DeleteCipher(c echo.Context) error {
 			"error": err.Error(),
 		})
 	}
+	bitwarden.DeleteCiphersAttachments(inst, cipher)
 
 	_ = settings.UpdateRevisionDate(inst, nil)
 	return c.NoContent(http.StatusOK)
@@ -671,14 +708,17 @@ func BulkDeleteCiphers(c echo.Context) error {
 		})
 	}
 	docs := make([]couchdb.Doc, len(ciphers))
+	deleted := make([]*bitwarden.Cipher, len(ciphers))
 	for i := range ciphers {
 		docs[i] = ciphers[i].Clone()
+		deleted[i] = &ciphers[i]
 	}
 	if err := couchdb.BulkDeleteDocs(inst, consts.BitwardenCiphers, docs); err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
 			"error": err.Error(),
 		})
 	}
+	bitwarden.DeleteCiphersAttachments(inst, deleted...)
 
 	_ = settings.UpdateRevisionDate(inst, nil)
 	return c.NoContent(http.StatusOK)
@@ -785,7 +825,7 @@ func BulkRestoreCiphers(c echo.Context) error {
 	res := &ciphersList{Object: "list"}
 	for i := range docs {
 		cipher := docs[i].(*bitwarden.Cipher)
-		res.Data = append(res.Data, newCipherResponse(cipher, setting))
+		res.Data = append(res.Data, newCipherResponse(inst, cipher, setting))
 	}
 	return c.JSON(http.StatusOK, res)
 }
@@ -860,6 +900,8 @@ func ShareCipher(c echo.Context) error {
 			"error": "only one collection per organization is supported",
 		})
 	}
+
+	req.Cipher.keepAttachments(cipher, old)
 	for _, id := range req.CollectionIDs {
 		if id == setting.CollectionID {
 			cipher.SharedWithCozy = true
@@ -884,7 +926,7 @@ func ShareCipher(c echo.Context) error {
 	}
 
 	_ = settings.UpdateRevisionDate(inst, setting)
-	res := newCipherResponse(cipher, setting)
+	res := newCipherResponse(inst, cipher, setting)
 	return c.JSON(http.StatusOK, res)
 }
 
@@ -903,6 +945,11 @@ func ImportCiphers(c echo.Context) error {
 			"error": "invalid JSON",
 		})
 	}
+	if err := bitwarden.CheckPersonalOwnership(inst); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
 
 	// Import the folders
 	folders := make([]interface{}, len(req.Folders))
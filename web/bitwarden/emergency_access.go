@@ -0,0 +1,765 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/session"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/clients/blob/main/apps/web/src/app/auth/emergency-access/request/emergency-access-invite.request.ts
+// The fullTakeover field is specific to Cozy.
+type emergencyAccessInviteRequest struct {
+	Email        string                        `json:"email"`
+	Type         bitwarden.EmergencyAccessType `json:"type"`
+	WaitTimeDays int                           `json:"waitTimeDays"`
+	FullTakeover bool                          `json:"fullTakeover"`
+}
+
+// https://github.com/bitwarden/clients/blob/main/apps/web/src/app/auth/emergency-access/request/emergency-access-update.request.ts
+// The fullTakeover field is specific to Cozy.
+type emergencyAccessUpdateRequest struct {
+	Type         bitwarden.EmergencyAccessType `json:"type"`
+	WaitTimeDays int                           `json:"waitTimeDays"`
+	KeyEncrypted string                        `json:"keyEncrypted"`
+	FullTakeover bool                          `json:"fullTakeover"`
+}
+
+// https://github.com/bitwarden/clients/blob/main/apps/web/src/app/auth/emergency-access/response/emergency-access.response.ts
+type emergencyAccessResponse struct {
+	ID           string     `json:"Id"`
+	GranteeID    string     `json:"GranteeId,omitempty"`
+	GrantorID    string     `json:"GrantorId,omitempty"`
+	Name         string     `json:"Name,omitempty"`
+	Email        string     `json:"Email"`
+	Type         int        `json:"Type"`
+	Status       int        `json:"Status"`
+	WaitTimeDays int        `json:"WaitTimeDays"`
+	FullTakeover bool       `json:"FullTakeover"`
+	CreationDate *time.Time `json:"CreationDate,omitempty"`
+	Object       string     `json:"Object"`
+}
+
+func newEmergencyAccessResponse(inst *instance.Instance, e *bitwarden.EmergencyAccess) *emergencyAccessResponse {
+	r := &emergencyAccessResponse{
+		ID:           e.CouchID,
+		Type:         int(e.Type),
+		Status:       int(e.Status),
+		WaitTimeDays: e.WaitTimeDays,
+		FullTakeover: e.FullTakeover,
+	}
+	if e.IsGrantee(inst) {
+		r.GrantorID = e.Grantor.UserID
+		r.Name = e.Grantor.Name
+		r.Email = e.Grantor.Email
+		r.Object = "emergencyAccessGrantorDetails"
+	} else {
+		r.GranteeID = e.Grantee.UserID
+		r.Name = e.Grantee.Name
+		r.Email = e.Grantee.Email
+		r.Object = "emergencyAccessGranteeDetails"
+	}
+	if e.Metadata != nil {
+		date := e.Metadata.CreatedAt.UTC()
+		r.CreationDate = &date
+	}
+	return r
+}
+
+type emergencyAccessesList struct {
+	Data   []*emergencyAccessResponse `json:"Data"`
+	Object string                     `json:"Object"`
+}
+
+// https://github.com/bitwarden/clients/blob/main/apps/web/src/app/auth/emergency-access/response/emergency-access.response.ts
+type emergencyAccessTakeoverResponse struct {
+	KeyEncrypted  string `json:"KeyEncrypted"`
+	Kdf           int    `json:"Kdf"`
+	KdfIterations int    `json:"KdfIterations"`
+	Object        string `json:"Object"`
+}
+
+type emergencyAccessViewResponse struct {
+	KeyEncrypted string          `json:"KeyEncrypted"`
+	Ciphers      json.RawMessage `json:"Ciphers"`
+	Object       string          `json:"Object"`
+}
+
+func getEmergencyAccess(c echo.Context, inst *instance.Instance, id string) (*bitwarden.EmergencyAccess, error) {
+	if id == "" {
+		return nil, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "missing id",
+		})
+	}
+	e := &bitwarden.EmergencyAccess{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenEmergencyAccess, id, e); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return nil, c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return e, nil
+}
+
+// pushAndSaveEmergencyAccess sends the changes to the other Cozy, and saves
+// them only if the other Cozy has accepted them, so that the two documents
+// stay in sync.
+func pushAndSaveEmergencyAccess(c echo.Context, inst *instance.Instance, e *bitwarden.EmergencyAccess) error {
+	if err := bitwarden.PushEmergencyAccess(inst, e); err != nil {
+		return c.JSON(http.StatusBadGateway, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := bitwarden.SaveEmergencyAccess(inst, e); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return nil
+}
+
+func emergencyAccessError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, bitwarden.ErrEmergencyAccessRequestFailed):
+		return c.JSON(http.StatusBadGateway, echo.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, bitwarden.ErrEmergencyAccessInvalidStatus),
+		errors.Is(err, bitwarden.ErrEmergencyAccessInvalidType),
+		errors.Is(err, bitwarden.ErrEmergencyAccessInvalidWaitTime):
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, bitwarden.ErrEmergencyAccessInvalidToken),
+		errors.Is(err, bitwarden.ErrEmergencyAccessTakeoverNotAllowed):
+		return c.JSON(http.StatusForbidden, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusInternalServerError, echo.Map{
+		"error": err.Error(),
+	})
+}
+
+func listEmergencyAccesses(c echo.Context, asGrantor bool) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	accesses, err := bitwarden.FindAllEmergencyAccesses(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	res := &emergencyAccessesList{Data: []*emergencyAccessResponse{}, Object: "list"}
+	for _, e := range accesses {
+		if e.IsGrantor(inst) == asGrantor {
+			res.Data = append(res.Data, newEmergencyAccessResponse(inst, e))
+		}
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// ListTrustedEmergencyAccesses is the route for listing the emergency
+// accesses given by the user to their trusted contacts.
+func ListTrustedEmergencyAccesses(c echo.Context) error {
+	return listEmergencyAccesses(c, true)
+}
+
+// ListGrantedEmergencyAccesses is the route for listing the emergency
+// accesses that other users have given to the user.
+func ListGrantedEmergencyAccesses(c echo.Context) error {
+	return listEmergencyAccesses(c, false)
+}
+
+// InviteEmergencyAccess is the route used by the grantor to invite a trusted
+// contact. The Cozy of the grantee is found via the contacts of the grantor.
+func InviteEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req emergencyAccessInviteRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.Email == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing email",
+		})
+	}
+
+	card, err := contact.FindByEmail(inst, req.Email)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "Unknown contact for this email",
+		})
+	}
+	cozyURL := card.PrimaryCozyURL()
+	if cozyURL == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Unknown Cozy URL for this user",
+		})
+	}
+	grantee := bitwarden.EmergencyAccessUser{
+		Email:    req.Email,
+		Name:     card.PrimaryName(),
+		Instance: cozyURL,
+	}
+
+	e, err := bitwarden.NewEmergencyAccess(inst, grantee, req.Type, req.WaitTimeDays)
+	if err != nil {
+		return emergencyAccessError(c, err)
+	}
+	e.FullTakeover = req.FullTakeover && req.Type == bitwarden.EmergencyAccessTakeover
+	if err := bitwarden.SaveEmergencyAccess(inst, e); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := bitwarden.PushEmergencyAccess(inst, e); err != nil {
+		_ = couchdb.DeleteDoc(inst, e)
+		return emergencyAccessError(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// GetEmergencyAccess is the route for getting an emergency access.
+func GetEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newEmergencyAccessResponse(inst, e))
+}
+
+// UpdateEmergencyAccess is the route used by the grantor to change the type
+// or the waiting period of an emergency access.
+func UpdateEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	if !e.IsGrantor(inst) {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+
+	var req emergencyAccessUpdateRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.WaitTimeDays < 1 || req.WaitTimeDays > bitwarden.MaxEmergencyAccessWaitTimeDays {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidWaitTime)
+	}
+	if req.Type != bitwarden.EmergencyAccessView && req.Type != bitwarden.EmergencyAccessTakeover {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidType)
+	}
+	e.Type = req.Type
+	e.WaitTimeDays = req.WaitTimeDays
+	e.FullTakeover = req.FullTakeover && req.Type == bitwarden.EmergencyAccessTakeover
+	if req.KeyEncrypted != "" && e.Status >= bitwarden.EmergencyAccessConfirmed {
+		e.KeyEncrypted = req.KeyEncrypted
+	}
+	if err := pushAndSaveEmergencyAccess(c, inst, e); err != nil {
+		return err
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+
+	return c.JSON(http.StatusOK, newEmergencyAccessResponse(inst, e))
+}
+
+// DeleteEmergencyAccess is the route used by the grantor or the grantee to
+// remove an emergency access.
+func DeleteEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	if err := bitwarden.DeleteEmergencyAccess(inst, e); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ReinviteEmergencyAccess is the route used by the grantor to send again the
+// invitation to a grantee that has not yet accepted it.
+func ReinviteEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	if !e.IsGrantor(inst) || e.Status != bitwarden.EmergencyAccessInvited {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+	if err := bitwarden.PushEmergencyAccess(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// AcceptEmergencyAccess is the route used by the grantee to accept an
+// invitation.
+func AcceptEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	if !e.IsGrantee(inst) {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := e.Accept(inst, setting); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	if err := pushAndSaveEmergencyAccess(c, inst, e); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ConfirmEmergencyAccess is the route used by the grantor to give the key of
+// their vault, encrypted with the public key of the grantee.
+func ConfirmEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	if !e.IsGrantor(inst) {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+
+	var confirm struct {
+		Key string `json:"key"`
+	}
+	if err := json.NewDecoder(c.Request().Body).Decode(&confirm); err != nil || confirm.Key == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing key",
+		})
+	}
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := e.Confirm(confirm.Key, setting); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	if err := pushAndSaveEmergencyAccess(c, inst, e); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// InitiateEmergencyAccess is the route used by the grantee to ask for an
+// access to the vault of the grantor. The waiting period starts.
+func InitiateEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	if !e.IsGrantee(inst) {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+	if err := e.Initiate(time.Now()); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	if err := pushAndSaveEmergencyAccess(c, inst, e); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ApproveEmergencyAccess is the route used by the grantor to approve a
+// recovery without waiting for the end of the waiting period. The approval
+// is made by a job.
+func ApproveEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	if !e.IsGrantor(inst) || e.Status != bitwarden.EmergencyAccessRecoveryInitiated {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+	if err := bitwarden.PushEmergencyAccessJob(inst, e, bitwarden.EmergencyAccessApproveAction); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// RejectEmergencyAccess is the route used by the grantor to reject a
+// recovery, or to revoke an approved one.
+func RejectEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	if !e.IsGrantor(inst) {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+	if err := e.Reject(); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	if err := bitwarden.PushEmergencyAccess(inst, e); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	bitwarden.UnscheduleEmergencyAccessTimeout(inst, e)
+	if err := bitwarden.SaveEmergencyAccess(inst, e); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// ViewEmergencyAccess is the route used by the grantee to read the ciphers
+// of the grantor, once the recovery has been approved.
+func ViewEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	ciphers, err := bitwarden.FetchEmergencyAccessCiphers(inst, e)
+	if err != nil {
+		return emergencyAccessError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &emergencyAccessViewResponse{
+		KeyEncrypted: e.KeyEncrypted,
+		Ciphers:      ciphers,
+		Object:       "emergencyAccessView",
+	})
+}
+
+// TakeoverEmergencyAccess is the route used by the grantee to get what they
+// need to choose a new master password for the grantor.
+func TakeoverEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	if !e.IsGrantee(inst) || e.Status != bitwarden.EmergencyAccessRecoveryApproved {
+		return emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+	if err := e.CheckTakeover(); err != nil {
+		return emergencyAccessError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, &emergencyAccessTakeoverResponse{
+		KeyEncrypted:  e.KeyEncrypted,
+		Kdf:           e.Kdf,
+		KdfIterations: e.KdfIterations,
+		Object:        "emergencyAccessTakeover",
+	})
+}
+
+// PasswordEmergencyAccess is the route used by the grantee to change the
+// master password of the grantor.
+func PasswordEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return err
+	}
+	var password bitwarden.EmergencyAccessPassword
+	if err := json.NewDecoder(c.Request().Body).Decode(&password); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if password.NewMasterPasswordHash == "" || password.Key == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "missing password or key",
+		})
+	}
+	if err := bitwarden.TakeoverEmergencyAccess(inst, e, &password); err != nil {
+		return emergencyAccessError(c, err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// GetEmergencyAccessPolicies is the route used by the grantee before a
+// takeover to know the master password policies of the grantor. The
+// policies are not shared between the two Cozy instances, so the list is
+// always empty.
+func GetEmergencyAccessPolicies(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenProfiles); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+	return c.JSON(http.StatusOK, &policiesList{
+		Data:   []*policyResponse{},
+		Object: "list",
+	})
+}
+
+// ReceiveEmergencyAccess is the route used by the other Cozy to send the
+// changes of an emergency access. It is authenticated by the token of the
+// emergency access.
+func ReceiveEmergencyAccess(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	var other bitwarden.EmergencyAccess
+	if err := json.NewDecoder(c.Request().Body).Decode(&other); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if other.CouchID != c.Param("id") {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "id mismatch",
+		})
+	}
+
+	token := middlewares.GetRequestToken(c)
+	if err := bitwarden.ReceiveEmergencyAccess(inst, token, &other); err != nil {
+		return emergencyAccessError(c, err)
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ReceiveEmergencyAccessDeletion is the route used by the other Cozy to tell
+// that an emergency access has been deleted.
+func ReceiveEmergencyAccessDeletion(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	token := middlewares.GetRequestToken(c)
+	err := bitwarden.ReceiveEmergencyAccessDeletion(inst, token, c.Param("id"))
+	if err != nil && !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+		return emergencyAccessError(c, err)
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getApprovedEmergencyAccess is used on the Cozy of the grantor, for the
+// requests made by the Cozy of the grantee once the recovery is approved.
+func getApprovedEmergencyAccess(c echo.Context, inst *instance.Instance, typ bitwarden.EmergencyAccessType) (*bitwarden.EmergencyAccess, error) {
+	e, err := getEmergencyAccess(c, inst, c.Param("id"))
+	if e == nil {
+		return nil, err
+	}
+	if !e.CheckToken(middlewares.GetRequestToken(c)) {
+		return nil, emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidToken)
+	}
+	if !e.IsGrantor(inst) || e.Status != bitwarden.EmergencyAccessRecoveryApproved {
+		return nil, emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidStatus)
+	}
+	if e.Type != typ {
+		return nil, emergencyAccessError(c, bitwarden.ErrEmergencyAccessInvalidType)
+	}
+	return e, nil
+}
+
+// SendEmergencyAccessCiphers is the route used by the Cozy of the grantee to
+// get the personal ciphers of the grantor.
+func SendEmergencyAccessCiphers(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getApprovedEmergencyAccess(c, inst, bitwarden.EmergencyAccessView)
+	if e == nil {
+		return err
+	}
+
+	var ciphers []*bitwarden.Cipher
+	req := &couchdb.AllDocsRequest{}
+	err = couchdb.GetAllDocs(inst, consts.BitwardenCiphers, req, &ciphers)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// Only the personal ciphers are given, not the ones of the organizations
+	res := []*cipherResponse{}
+	for _, cipher := range ciphers {
+		if cipher.OrganizationID != "" || cipher.SharedWithCozy || cipher.DeletedDate != nil {
+			continue
+		}
+		res = append(res, newCipherResponse(inst, cipher, setting))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// ChangeEmergencyAccessPassword is the route used by the Cozy of the grantee
+// to change the master password of the grantor.
+func ChangeEmergencyAccessPassword(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	e, err := getApprovedEmergencyAccess(c, inst, bitwarden.EmergencyAccessTakeover)
+	if e == nil {
+		return err
+	}
+	if err := e.CheckTakeover(); err != nil {
+		return emergencyAccessError(c, err)
+	}
+
+	var password bitwarden.EmergencyAccessPassword
+	if err := json.NewDecoder(c.Request().Body).Decode(&password); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	setting, err := settings.Get(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	pass := []byte(password.NewMasterPasswordHash)
+	params := lifecycle.PassParameters{
+		Pass:       pass,
+		Iterations: setting.PassphraseKdfIterations,
+		Key:        password.Key,
+	}
+	if err := lifecycle.ForceUpdatePassphrase(inst, pass, params); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	// The grantor is logged out of all their devices, and notified
+	if err := session.DeleteOthers(inst, ""); err != nil {
+		inst.Logger().WithNamespace("bitwarden").
+			Warnf("Cannot delete the sessions after a takeover: %s", err)
+	}
+	bitwarden.NotifyEmergencyAccessTakeover(inst, e)
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -151,6 +151,7 @@ func readPump(notifier *wsNotifier) {
 	ds.Watch(consts.Settings, consts.BitwardenSettingsID)
 	ds.Subscribe(consts.BitwardenFolders)
 	ds.Subscribe(consts.BitwardenCiphers)
+	ds.Subscribe(consts.BitwardenSends)
 	notifier.Responses <- initialResponse
 
 	// Just send back the pings from the client
@@ -243,6 +244,10 @@ const (
 	hubCipherDelete = 9
 	// hubSettings     = 10
 	hubLogOut = 11
+	// https://github.com/bitwarden/clients/blob/main/libs/common/src/enums/notification-type.enum.ts
+	hubSendCreate = 12
+	hubSendUpdate = 13
+	hubSendDelete = 14
 )
 
 func buildNotification(e *realtime.Event, userID string, setting *settings.Settings) *notification {
@@ -276,6 +281,16 @@ func buildNotification(e *realtime.Event, userID string, setting *settings.Setti
 		case realtime.EventNotify:
 			t = hubVault
 		}
+	case consts.BitwardenSends:
+		payload = buildSendPayload(e, userID)
+		switch e.Verb {
+		case realtime.EventCreate:
+			t = hubSendCreate
+		case realtime.EventUpdate:
+			t = hubSendUpdate
+		case realtime.EventDelete:
+			t = hubSendDelete
+		}
 	case consts.Settings:
 		payload = buildLogoutPayload(e, userID)
 		if len(payload) > 0 {
@@ -330,6 +345,21 @@ func buildFolderPayload(e *realtime.Event, userID string) map[string]interface{}
 	}
 }
 
+func buildSendPayload(e *realtime.Event, userID string) map[string]interface{} {
+	if doc, ok := e.Doc.(*bitwarden.Send); ok {
+		var updatedAt interface{} = time.Now()
+		if doc.Metadata != nil {
+			updatedAt = doc.Metadata.UpdatedAt
+		}
+		return map[string]interface{}{
+			"Id":           doc.ID(),
+			"UserId":       userID,
+			"RevisionDate": updatedAt,
+		}
+	}
+	return buildFolderPayload(e, userID)
+}
+
 func buildCipherPayload(e *realtime.Event, userID string, setting *settings.Settings) map[string]interface{} {
 	if e.Verb == realtime.EventNotify {
 		return map[string]interface{}{
@@ -110,7 +110,7 @@ func newOrganizationResponse(inst *instance.Instance, org *bitwarden.Organizatio
 		UseGroups:      false,
 		UseTotp:        true,
 		UseAPI:         false,
-		UsePolicies:    false,
+		UsePolicies:    true,
 		UseSSO:         false,
 		UseResetPass:   false,
 		HasKeys:        false, // The public/private keys are used for the Admin Reset Password feature, not implemented by us
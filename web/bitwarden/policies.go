@@ -0,0 +1,191 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/admin-console/models/request/policy.request.ts
+type policyRequest struct {
+	Type    bitwarden.PolicyType   `json:"type"`
+	Enabled bool                   `json:"enabled"`
+	Data    map[string]interface{} `json:"data"`
+}
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/admin-console/models/response/policy.response.ts
+type policyResponse struct {
+	ID             string                 `json:"Id"`
+	OrganizationID string                 `json:"OrganizationId"`
+	Type           int                    `json:"Type"`
+	Data           map[string]interface{} `json:"Data"`
+	Enabled        bool                   `json:"Enabled"`
+	Object         string                 `json:"Object"`
+}
+
+func newPolicyResponse(org *bitwarden.Organization, p *bitwarden.Policy) *policyResponse {
+	// The policies are not documents, so their identifier is made from the
+	// organization identifier and the policy type.
+	return &policyResponse{
+		ID:             org.ID() + "-" + strconv.Itoa(int(p.Type)),
+		OrganizationID: org.ID(),
+		Type:           int(p.Type),
+		Data:           p.Data,
+		Enabled:        p.Enabled,
+		Object:         "policy",
+	}
+}
+
+type policiesList struct {
+	Data   []*policyResponse `json:"Data"`
+	Object string            `json:"Object"`
+}
+
+// newEnabledPoliciesResponse returns the enabled policies of the
+// organizations, for the sync.
+func newEnabledPoliciesResponse(orgs []*bitwarden.Organization) []*policyResponse {
+	res := []*policyResponse{}
+	for _, org := range orgs {
+		for i := range org.Policies {
+			if org.Policies[i].Enabled {
+				res = append(res, newPolicyResponse(org, &org.Policies[i]))
+			}
+		}
+	}
+	return res
+}
+
+func getOrganization(c echo.Context, inst *instance.Instance, id string) (*bitwarden.Organization, error) {
+	if id == "" {
+		return nil, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "missing id",
+		})
+	}
+	org := &bitwarden.Organization{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenOrganizations, id, org); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil, c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return nil, c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return org, nil
+}
+
+func parsePolicyType(c echo.Context) (bitwarden.PolicyType, error) {
+	typ, err := strconv.Atoi(c.Param("type"))
+	if err != nil || !bitwarden.IsSupportedPolicy(bitwarden.PolicyType(typ)) {
+		return 0, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "unsupported policy type",
+		})
+	}
+	return bitwarden.PolicyType(typ), nil
+}
+
+// ListPolicies is the route for listing the policies of an organization.
+func ListPolicies(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := getOrganization(c, inst, c.Param("id"))
+	if org == nil {
+		return err
+	}
+
+	res := &policiesList{Object: "list"}
+	for i := range org.Policies {
+		res.Data = append(res.Data, newPolicyResponse(org, &org.Policies[i]))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// GetPolicy is the route for getting a policy of an organization. A policy
+// that has never been set is returned as disabled.
+func GetPolicy(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := getOrganization(c, inst, c.Param("id"))
+	if org == nil {
+		return err
+	}
+	typ, err := parsePolicyType(c)
+	if err != nil {
+		return err
+	}
+
+	p := org.Policy(typ)
+	if p == nil {
+		p = &bitwarden.Policy{Type: typ}
+	}
+	return c.JSON(http.StatusOK, newPolicyResponse(org, p))
+}
+
+// PutPolicy is the route used by the owner of an organization to enable,
+// disable or configure a policy.
+func PutPolicy(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenOrganizations); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	org, err := getOrganization(c, inst, c.Param("id"))
+	if org == nil {
+		return err
+	}
+	if m := org.Member(inst); !m.Owner {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "only the Owner can call this endpoint",
+		})
+	}
+	typ, err := parsePolicyType(c)
+	if err != nil {
+		return err
+	}
+
+	var req policyRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	policy := bitwarden.Policy{
+		Type:    typ,
+		Enabled: req.Enabled,
+		Data:    req.Data,
+	}
+	if err := org.SetPolicy(policy); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	org.Metadata.ChangeUpdatedAt()
+	if err := couchdb.UpdateDoc(inst, org); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, newPolicyResponse(org, org.Policy(typ)))
+}
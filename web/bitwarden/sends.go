@@ -0,0 +1,792 @@
+package bitwarden
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/bitwarden/settings"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/crypto"
+	"github.com/cozy/cozy-stack/pkg/limits"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/tools/send/models/request/send.request.ts
+type sendRequest struct {
+	Type           bitwarden.SendType `json:"type"`
+	FileLength     *int64             `json:"fileLength"`
+	Name           string             `json:"name"`
+	Notes          string             `json:"notes"`
+	Key            string             `json:"key"`
+	MaxAccessCount *int               `json:"maxAccessCount"`
+	ExpirationDate *time.Time         `json:"expirationDate"`
+	DeletionDate   *time.Time         `json:"deletionDate"`
+	Text           *struct {
+		Text   string `json:"text"`
+		Hidden bool   `json:"hidden"`
+	} `json:"text"`
+	File *struct {
+		FileName string `json:"fileName"`
+	} `json:"file"`
+	Password  string `json:"password"`
+	Disabled  bool   `json:"disabled"`
+	HideEmail bool   `json:"hideEmail"`
+}
+
+// validate checks the fields that are common to the creation and the update
+// of a send, and returns an error message for the client if it is invalid.
+func (r *sendRequest) validate() string {
+	if r.Name == "" {
+		return "missing name"
+	}
+	if r.Key == "" {
+		return "missing key"
+	}
+	now := time.Now()
+	if r.DeletionDate == nil || !r.DeletionDate.After(now) {
+		return "invalid deletion date"
+	}
+	if r.DeletionDate.After(now.Add(bitwarden.MaxSendDeletionDelay)) {
+		return "You cannot have a Send with a deletion date that far into the future. Adjust the Deletion Date to a value less than 31 days from now and try again."
+	}
+	if r.ExpirationDate != nil && !r.ExpirationDate.After(now) {
+		return "invalid expiration date"
+	}
+	if r.MaxAccessCount != nil && *r.MaxAccessCount < 0 {
+		return "invalid max access count"
+	}
+	switch r.Type {
+	case bitwarden.SendTypeText:
+		if r.Text == nil {
+			return "missing text"
+		}
+	case bitwarden.SendTypeFile:
+		if r.File == nil || r.File.FileName == "" {
+			return "missing file"
+		}
+	default:
+		return "invalid type"
+	}
+	return ""
+}
+
+// applyTo copies the fields of the request to the send, except the type and
+// the size of the file that cannot be changed after the creation.
+func (r *sendRequest) applyTo(send *bitwarden.Send) error {
+	send.Name = r.Name
+	send.Notes = r.Notes
+	send.Key = r.Key
+	send.MaxAccessCount = r.MaxAccessCount
+	send.ExpirationDate = r.ExpirationDate
+	send.DeletionDate = r.DeletionDate.UTC()
+	send.Disabled = r.Disabled
+	send.HideEmail = r.HideEmail
+	switch send.Type {
+	case bitwarden.SendTypeText:
+		send.Text = &bitwarden.SendText{
+			Text:   r.Text.Text,
+			Hidden: r.Text.Hidden,
+		}
+	case bitwarden.SendTypeFile:
+		send.File.FileName = r.File.FileName
+	}
+	if r.Password != "" {
+		return send.SetPassword(r.Password)
+	}
+	return nil
+}
+
+func (r *sendRequest) toSend() (*bitwarden.Send, error) {
+	send := &bitwarden.Send{Type: r.Type}
+	if r.Type == bitwarden.SendTypeFile {
+		send.File = &bitwarden.SendFile{
+			ID:   crypto.GenerateRandomString(16),
+			Size: *r.FileLength,
+		}
+	}
+	if err := r.applyTo(send); err != nil {
+		return nil, err
+	}
+	md := metadata.New()
+	md.DocTypeVersion = bitwarden.DocTypeVersion
+	send.Metadata = md
+	return send, nil
+}
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/tools/send/models/api/send-text.api.ts
+type sendTextResponse struct {
+	Text   *string `json:"Text"`
+	Hidden bool    `json:"Hidden"`
+}
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/tools/send/models/api/send-file.api.ts
+type sendFileResponse struct {
+	ID       string `json:"Id"`
+	FileName string `json:"FileName"`
+	Size     int64  `json:"Size,string"`
+	SizeName string `json:"SizeName"`
+}
+
+func newSendTextResponse(send *bitwarden.Send) *sendTextResponse {
+	if send.Type != bitwarden.SendTypeText || send.Text == nil {
+		return nil
+	}
+	r := sendTextResponse{Hidden: send.Text.Hidden}
+	if send.Text.Text != "" {
+		r.Text = &send.Text.Text
+	}
+	return &r
+}
+
+func newSendFileResponse(send *bitwarden.Send) *sendFileResponse {
+	if send.Type != bitwarden.SendTypeFile || send.File == nil {
+		return nil
+	}
+	return &sendFileResponse{
+		ID:       send.File.ID,
+		FileName: send.File.FileName,
+		Size:     send.File.Size,
+		SizeName: sizeName(send.File.Size),
+	}
+}
+
+// sizeName returns the size in a human readable format, like the official
+// server does.
+func sizeName(size int64) string {
+	units := []string{"Bytes", "KB", "MB", "GB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.4g %s", value, units[unit])
+}
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/tools/send/models/response/send.response.ts
+type sendResponse struct {
+	ID             string            `json:"Id"`
+	AccessID       string            `json:"AccessId"`
+	Type           int               `json:"Type"`
+	Name           string            `json:"Name"`
+	Notes          *string           `json:"Notes"`
+	File           *sendFileResponse `json:"File"`
+	Text           *sendTextResponse `json:"Text"`
+	Key            string            `json:"Key"`
+	MaxAccessCount *int              `json:"MaxAccessCount"`
+	AccessCount    int               `json:"AccessCount"`
+	Password       *string           `json:"Password"`
+	Disabled       bool              `json:"Disabled"`
+	Date           time.Time         `json:"RevisionDate"`
+	ExpirationDate *time.Time        `json:"ExpirationDate"`
+	DeletionDate   time.Time         `json:"DeletionDate"`
+	HideEmail      bool              `json:"HideEmail"`
+	Object         string            `json:"Object"`
+}
+
+func newSendResponse(send *bitwarden.Send) *sendResponse {
+	r := sendResponse{
+		ID:             send.CouchID,
+		AccessID:       send.AccessID(),
+		Type:           int(send.Type),
+		Name:           send.Name,
+		File:           newSendFileResponse(send),
+		Text:           newSendTextResponse(send),
+		Key:            send.Key,
+		MaxAccessCount: send.MaxAccessCount,
+		AccessCount:    send.AccessCount,
+		Disabled:       send.Disabled,
+		ExpirationDate: send.ExpirationDate,
+		DeletionDate:   send.DeletionDate.UTC(),
+		HideEmail:      send.HideEmail,
+		Object:         "send",
+	}
+	if send.Notes != "" {
+		r.Notes = &send.Notes
+	}
+	if send.HasPassword() {
+		// The clients only check that this field is not null
+		r.Password = &send.PasswordHash
+	}
+	if send.Metadata != nil {
+		r.Date = send.Metadata.UpdatedAt.UTC()
+	}
+	return &r
+}
+
+type sendsList struct {
+	Data   []*sendResponse `json:"Data"`
+	Object string          `json:"Object"`
+}
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/tools/send/models/response/send-file-upload-data.response.ts
+type sendFileUploadResponse struct {
+	URL            string        `json:"Url"`
+	FileUploadType int           `json:"FileUploadType"`
+	Send           *sendResponse `json:"SendResponse"`
+	Object         string        `json:"Object"`
+}
+
+func newSendFileUploadResponse(send *bitwarden.Send) *sendFileUploadResponse {
+	return &sendFileUploadResponse{
+		URL:            "/sends/" + send.CouchID + "/file/" + send.File.ID,
+		FileUploadType: 0, // Direct upload to the server
+		Send:           newSendResponse(send),
+		Object:         "send-fileUpload",
+	}
+}
+
+// https://github.com/bitwarden/clients/blob/main/libs/common/src/tools/send/models/response/send-access.response.ts
+type sendAccessResponse struct {
+	ID                string            `json:"Id"`
+	Type              int               `json:"Type"`
+	Name              string            `json:"Name"`
+	File              *sendFileResponse `json:"File"`
+	Text              *sendTextResponse `json:"Text"`
+	ExpirationDate    *time.Time        `json:"ExpirationDate"`
+	CreatorIdentifier *string           `json:"CreatorIdentifier"`
+	Object            string            `json:"Object"`
+}
+
+func newSendAccessResponse(inst *instance.Instance, send *bitwarden.Send) *sendAccessResponse {
+	r := sendAccessResponse{
+		ID:             send.AccessID(),
+		Type:           int(send.Type),
+		Name:           send.Name,
+		File:           newSendFileResponse(send),
+		Text:           newSendTextResponse(send),
+		ExpirationDate: send.ExpirationDate,
+		Object:         "send-access",
+	}
+	if !send.HideEmail {
+		email := string(inst.PassphraseSalt())
+		r.CreatorIdentifier = &email
+	}
+	return &r
+}
+
+type sendAccessRequest struct {
+	Password string `json:"password"`
+}
+
+func getSend(c echo.Context, inst *instance.Instance, id string) (*bitwarden.Send, error) {
+	if id == "" {
+		return nil, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "missing id",
+		})
+	}
+	send := &bitwarden.Send{}
+	if err := couchdb.GetDoc(inst, consts.BitwardenSends, id, send); err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, c.JSON(http.StatusNotFound, echo.Map{
+				"error": "not found",
+			})
+		}
+		return nil, c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return send, nil
+}
+
+func saveSend(inst *instance.Instance, send *bitwarden.Send) error {
+	var err error
+	if send.CouchID == "" {
+		err = couchdb.CreateDoc(inst, send)
+		if couchdb.IsNoDatabaseError(err) {
+			if err = couchdb.CreateDB(inst, consts.BitwardenSends); err == nil {
+				err = couchdb.CreateDoc(inst, send)
+			}
+		}
+	} else {
+		if send.Metadata == nil {
+			md := metadata.New()
+			md.DocTypeVersion = bitwarden.DocTypeVersion
+			send.Metadata = md
+		}
+		send.Metadata.ChangeUpdatedAt()
+		err = couchdb.UpdateDoc(inst, send)
+	}
+	if err != nil {
+		return err
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+	return nil
+}
+
+// ListSends is the route for listing the Bitwarden sends. The sends are part
+// of the vault, so the permission on the ciphers is checked.
+// No pagination yet.
+func ListSends(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	sends, err := bitwarden.FindAllSends(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	res := &sendsList{Data: []*sendResponse{}, Object: "list"}
+	for _, s := range sends {
+		res.Data = append(res.Data, newSendResponse(s))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// CreateSend is the route to add a text send via the Bitwarden API.
+func CreateSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req sendRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.Type == bitwarden.SendTypeFile {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "file sends must use the /sends/file/v2 endpoint",
+		})
+	}
+	if msg := req.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": msg,
+		})
+	}
+
+	send, err := req.toSend()
+	if err == nil {
+		err = saveSend(inst, send)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// CreateFileSend is the route to add a file send via the Bitwarden API. The
+// response gives the URL where the client can upload the content of the file.
+func CreateFileSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	var req sendRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.Type != bitwarden.SendTypeFile {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "send type is not file",
+		})
+	}
+	if msg := req.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": msg,
+		})
+	}
+	if req.FileLength == nil || *req.FileLength <= 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid file length",
+		})
+	}
+	if *req.FileLength > bitwarden.MaxSendFileSize {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "file is too large",
+		})
+	}
+	if err := bitwarden.CheckVaultFileSize(inst, *req.FileLength); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Not enough storage available.",
+		})
+	}
+
+	send, err := req.toSend()
+	if err == nil {
+		err = saveSend(inst, send)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, newSendFileUploadResponse(send))
+}
+
+// GetSendFileUpload is the route used by the clients to get again the upload
+// URL of a file send.
+func GetSendFileUpload(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := getSend(c, inst, c.Param("id"))
+	if send == nil {
+		return err
+	}
+	if send.Type != bitwarden.SendTypeFile || send.File.ID != c.Param("file-id") {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+	return c.JSON(http.StatusOK, newSendFileUploadResponse(send))
+}
+
+// UploadSendFile is the route for uploading the (encrypted) content of a file
+// send. The content is sent in the data field of a multipart/form-data body.
+func UploadSendFile(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.POST, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := getSend(c, inst, c.Param("id"))
+	if send == nil {
+		return err
+	}
+	if send.Type != bitwarden.SendTypeFile || send.File.ID != c.Param("file-id") {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+
+	err = readMultipartData(c, func(_ map[string]string, content io.Reader) error {
+		return writeSendFile(inst, send, content)
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	if err := saveSend(inst, send); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.NoContent(http.StatusOK)
+}
+
+// readMultipartData reads a multipart/form-data body, and calls fn with the
+// content of its data field. The values of the fields that come before it are
+// given in a map.
+func readMultipartData(c echo.Context, fn func(fields map[string]string, content io.Reader) error) error {
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		return errors.New("invalid multipart body")
+	}
+	fields := make(map[string]string)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return errors.New("missing data")
+		}
+		if err != nil {
+			return errors.New("invalid multipart body")
+		}
+		if part.FormName() == "data" {
+			err = fn(fields, part)
+			_ = part.Close()
+			return err
+		}
+		value, err := io.ReadAll(io.LimitReader(part, maxMultipartFieldSize))
+		_ = part.Close()
+		if err != nil {
+			return errors.New("invalid multipart body")
+		}
+		fields[part.FormName()] = string(value)
+	}
+}
+
+// maxMultipartFieldSize is the maximal size of the fields that are read
+// before the data in a multipart body.
+const maxMultipartFieldSize = 64 * 1024
+
+func writeSendFile(inst *instance.Instance, send *bitwarden.Send, content io.Reader) error {
+	file, doc, err := bitwarden.CreateSendFile(inst, send)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, content)
+	if cerr := file.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	send.File.VFSID = doc.ID()
+	return nil
+}
+
+// GetSend returns information about a single send.
+func GetSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := getSend(c, inst, c.Param("id"))
+	if send == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// UpdateSend is the route for changing a send. Its type cannot be changed.
+func UpdateSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := getSend(c, inst, c.Param("id"))
+	if send == nil {
+		return err
+	}
+
+	var req sendRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "invalid JSON",
+		})
+	}
+	if req.Type != send.Type {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "the type of a send cannot be changed",
+		})
+	}
+	if msg := req.validate(); msg != "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{
+			"error": msg,
+		})
+	}
+
+	err = req.applyTo(send)
+	if err == nil {
+		err = saveSend(inst, send)
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// RemoveSendPassword is the route for removing the password that protects
+// the access to a send.
+func RemoveSendPassword(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := getSend(c, inst, c.Param("id"))
+	if send == nil {
+		return err
+	}
+
+	_ = send.SetPassword("")
+	if err := saveSend(inst, send); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.JSON(http.StatusOK, newSendResponse(send))
+}
+
+// DeleteSend is the handler for the route to delete a send.
+func DeleteSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	if err := middlewares.AllowWholeType(c, permission.DELETE, consts.BitwardenCiphers); err != nil {
+		return c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "invalid token",
+		})
+	}
+
+	send, err := getSend(c, inst, c.Param("id"))
+	if send == nil {
+		return err
+	}
+
+	if err := bitwarden.DeleteSend(inst, send); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+	return c.NoContent(http.StatusOK)
+}
+
+// checkSendAccess loads the send for an anonymous access, and checks that it
+// can be accessed with the password in the request body. When the access is
+// refused, the send is nil and the error is the HTTP response.
+func checkSendAccess(c echo.Context, inst *instance.Instance) (*bitwarden.Send, error) {
+	id, err := bitwarden.SendIDFromAccessID(c.Param("access-id"))
+	if err != nil {
+		return nil, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+
+	err = config.GetRateLimiter().CheckRateLimitKey(id, limits.BitwardenSendAccessType)
+	if limits.IsLimitReachedOrExceeded(err) {
+		return nil, c.JSON(http.StatusTooManyRequests, echo.Map{
+			"error": "too many requests",
+		})
+	}
+
+	send, err := getSend(c, inst, id)
+	if send == nil {
+		return nil, err
+	}
+	if send.IsDeleted(time.Now()) {
+		_ = bitwarden.DeleteSend(inst, send)
+		return nil, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+	if !send.IsAccessible(time.Now()) {
+		return nil, c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+
+	var req sendAccessRequest
+	if c.Request().ContentLength != 0 {
+		if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil && err != io.EOF {
+			return nil, c.JSON(http.StatusBadRequest, echo.Map{
+				"error": "invalid JSON",
+			})
+		}
+	}
+	if send.HasPassword() && req.Password == "" {
+		return nil, c.JSON(http.StatusUnauthorized, echo.Map{
+			"error": "Password not provided.",
+		})
+	}
+	if !send.CheckPassword(req.Password) {
+		return nil, c.JSON(http.StatusBadRequest, echo.Map{
+			"error": "Invalid password.",
+		})
+	}
+	return send, nil
+}
+
+// countSendAccess increments the access count of the send. The revision date
+// of the send is not changed, like on the official server.
+func countSendAccess(inst *instance.Instance, send *bitwarden.Send) error {
+	send.AccessCount++
+	if err := couchdb.UpdateDoc(inst, send); err != nil {
+		return err
+	}
+	_ = settings.UpdateRevisionDate(inst, nil)
+	return nil
+}
+
+// AccessSend is the route used by anyone with the link of a send to read it.
+// It doesn't need a token, but the password of the send if it has one.
+func AccessSend(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	send, err := checkSendAccess(c, inst)
+	if send == nil {
+		return err
+	}
+
+	// For a file send, the access is counted when the file is downloaded
+	if send.Type == bitwarden.SendTypeText {
+		if err := countSendAccess(inst, send); err != nil {
+			return c.JSON(http.StatusInternalServerError, echo.Map{
+				"error": err.Error(),
+			})
+		}
+	}
+	return c.JSON(http.StatusOK, newSendAccessResponse(inst, send))
+}
+
+// AccessSendFile is the route used by anyone with the link of a file send to
+// get a URL where the (encrypted) content of the file can be downloaded.
+func AccessSendFile(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	send, err := checkSendAccess(c, inst)
+	if send == nil {
+		return err
+	}
+	if send.Type != bitwarden.SendTypeFile || send.File.ID != c.Param("file-id") {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+
+	doc, err := bitwarden.OpenSendFile(inst, send)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, echo.Map{
+			"error": "not found",
+		})
+	}
+	fs := inst.VFS()
+	filepath, err := doc.Path(fs)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	secret, err := vfs.GetStore().AddFile(inst, filepath)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+	if err := countSendAccess(inst, send); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"Id":     send.File.ID,
+		"Url":    inst.PageURL("/files/downloads/"+secret+"/"+send.File.ID, nil),
+		"Object": "send-fileDownload",
+	})
+}
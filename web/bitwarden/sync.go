@@ -74,6 +74,8 @@ type syncResponse struct {
 	Folders     []*folderResponse     `json:"Folders"`
 	Ciphers     []*cipherResponse     `json:"Ciphers"`
 	Collections []*collectionResponse `json:"Collections"`
+	Sends       []*sendResponse       `json:"Sends"`
+	Policies    []*policyResponse     `json:"Policies"`
 	Domains     *domainsResponse      `json:"Domains"`
 	Object      string                `json:"Object"`
 }
@@ -85,6 +87,7 @@ func newSyncResponse(
 	ciphers []*bitwarden.Cipher,
 	folders []*bitwarden.Folder,
 	organizations []*bitwarden.Organization,
+	sends []*bitwarden.Send,
 	domains *domainsResponse,
 ) *syncResponse {
 	foldersResponse := make([]*folderResponse, len(folders))
@@ -93,17 +96,23 @@ func newSyncResponse(
 	}
 	ciphersResponse := make([]*cipherResponse, len(ciphers))
 	for i, c := range ciphers {
-		ciphersResponse[i] = newCipherResponse(c, setting)
+		ciphersResponse[i] = newCipherResponse(inst, c, setting)
 	}
 	collectionsResponse := make([]*collectionResponse, len(organizations))
 	for i, o := range organizations {
 		collectionsResponse[i] = newCollectionResponse(inst, o, &o.Collection)
 	}
+	sendsResponse := make([]*sendResponse, len(sends))
+	for i, s := range sends {
+		sendsResponse[i] = newSendResponse(s)
+	}
 	return &syncResponse{
 		Profile:     profile,
 		Folders:     foldersResponse,
 		Ciphers:     ciphersResponse,
 		Collections: collectionsResponse,
+		Sends:       sendsResponse,
+		Policies:    newEnabledPoliciesResponse(organizations),
 		Domains:     domains,
 		Object:      "sync",
 	}
@@ -158,11 +167,18 @@ func Sync(c echo.Context) error {
 		})
 	}
 
+	sends, err := bitwarden.FindAllSends(inst)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
 	var domains *domainsResponse
 	if c.QueryParam("excludeDomains") == "" {
 		domains = newDomainsResponse(setting)
 	}
 
-	res := newSyncResponse(inst, setting, profile, ciphers, folders, organizations, domains)
+	res := newSyncResponse(inst, setting, profile, ciphers, folders, organizations, sends, domains)
 	return c.JSON(http.StatusOK, res)
 }
@@ -10,6 +10,7 @@ import (
 
 	"github.com/cozy/cozy-stack/model/orgdirectory"
 	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/schema"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/couchdb/stream"
@@ -179,6 +180,10 @@ func createDoc(c echo.Context) error {
 		return err
 	}
 
+	if err := schema.Check(instance, &doc); err != nil {
+		return err
+	}
+
 	if err := couchdb.CreateDoc(instance, &doc); err != nil {
 		return err
 	}
@@ -204,6 +209,10 @@ func createNamedDoc(c echo.Context, doc couchdb.JSONDoc) error {
 		return err
 	}
 
+	if err := schema.Check(instance, &doc); err != nil {
+		return err
+	}
+
 	err = couchdb.CreateNamedDocWithDB(instance, &doc)
 	if err != nil {
 		return fixErrorNoDatabaseIsWrongDoctype(err)
@@ -288,6 +297,10 @@ func UpdateDoc(c echo.Context) error {
 		}
 	}
 
+	if err := schema.Check(instance, &doc); err != nil {
+		return err
+	}
+
 	errUpdate := couchdb.UpdateDoc(instance, &doc)
 	if errUpdate != nil {
 		return fixErrorNoDatabaseIsWrongDoctype(errUpdate)
@@ -609,6 +622,10 @@ func couchdbStyleErrorHandler(next echo.HandlerFunc) echo.HandlerFunc {
 			return c.JSON(ce.StatusCode, ce.JSON())
 		}
 
+		if ve, ok := err.(*schema.ValidationError); ok {
+			return jsonapi.DataErrorList(c, ve.JSONAPIErrors()...)
+		}
+
 		if he, ok := err.(*echo.HTTPError); ok {
 			return c.JSON(he.Code, echo.Map{"error": he.Error()})
 		}
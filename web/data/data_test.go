@@ -12,6 +12,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
 	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/gavv/httpexpect/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
@@ -33,7 +34,7 @@ func TestData(t *testing.T) {
 	setup := testutils.NewSetup(t, t.Name())
 	testInstance := setup.GetTestInstance()
 	scope := "io.cozy.doctypes io.cozy.files io.cozy.events " +
-		"io.cozy.anothertype io.cozy.nottype"
+		"io.cozy.anothertype io.cozy.nottype io.cozy.bills"
 
 	_, token := setup.GetTestClient(scope)
 	ts := setup.GetTestServer("/data", Routes)
@@ -1010,6 +1011,60 @@ func TestData(t *testing.T) {
 			ValueEqual("rev", rev)
 	})
 
+	t.Run("SchemaValidation", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		cfg := config.GetConfig()
+		cfg.Schemas.Doctypes = map[string]string{"io.cozy.bills": "reject"}
+		t.Cleanup(func() { cfg.Schemas.Doctypes = nil })
+
+		e.POST("/data/io.cozy.bills/").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{ "amount": 12.3, "date": "2024-01-01", "vendor": "EDF" }`)).
+			Expect().Status(201)
+
+		errs := e.POST("/data/io.cozy.bills/").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{ "amount": "12.3", "date": "2024-01-01" }`)).
+			Expect().Status(422).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Value("errors").Array()
+		errs.Length().Equal(2)
+		pointers := []interface{}{
+			errs.Element(0).Object().Path("$.source.pointer").Raw(),
+			errs.Element(1).Object().Path("$.source.pointer").Raw(),
+		}
+		assert.ElementsMatch(t, []interface{}{"/amount", "/vendor"}, pointers)
+
+		e.PUT("/data/io.cozy.bills/fixed-bill").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{ "amount": 4, "date": "2024-01-01", "vendor": "" }`)).
+			Expect().Status(422)
+
+		e.POST("/data/io.cozy.bills/_bulk_docs").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{ "docs": [
+				{ "amount": 1, "date": "2024-01-01", "vendor": "EDF" },
+				{ "amount": 2, "date": "2024-01-01" }
+			] }`)).
+			Expect().Status(422).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Value("errors").Array().
+			Element(0).Object().Path("$.source.pointer").Equal("/docs/1/vendor")
+
+		// With the warn strictness, the invalid documents are accepted
+		cfg.Schemas.Doctypes = map[string]string{"io.cozy.bills": "warn"}
+		e.POST("/data/io.cozy.bills/").
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{ "amount": "12.3" }`)).
+			Expect().Status(201)
+	})
+
 	t.Run("DeleteDatabase", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
@@ -6,6 +6,7 @@ import (
 	"strconv"
 
 	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/schema"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/config/config"
 	"github.com/cozy/cozy-stack/pkg/consts"
@@ -85,8 +86,14 @@ func bulkDocs(c echo.Context) error {
 	if err := couchdb.EnsureDBExist(instance, doctype); err != nil {
 		return err
 	}
-	p, req, err := couchdb.ProxyBulkDocs(instance, doctype, c.Request())
+	check := func(docs []couchdb.JSONDoc) error {
+		return schema.CheckBulk(instance, doctype, docs, "/docs")
+	}
+	p, req, err := couchdb.ProxyBulkDocs(instance, doctype, c.Request(), check)
 	if err != nil {
+		if _, ok := err.(*schema.ValidationError); ok {
+			return err
+		}
 		var code int
 		if errHTTP, ok := err.(*echo.HTTPError); ok {
 			code = errHTTP.Code
@@ -0,0 +1,482 @@
+package dav
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/calendar"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// The events and the todos are served in two calendars, as most clients
+// expect a calendar to have a single kind of components.
+const (
+	calendarsHome = "/dav/calendars/"
+	iCalExt       = ".ics"
+	iCalMime      = "text/calendar; charset=utf-8"
+)
+
+// maxICalSize is the maximal size of an iCalendar object sent by a client.
+const maxICalSize = 1 << 20
+
+// calMethods is the list of HTTP methods that are handled by the CalDAV
+// server.
+var calMethods = []string{
+	http.MethodOptions,
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPut,
+	http.MethodDelete,
+	"PROPFIND",
+	"REPORT",
+}
+
+// calendarCollection is a calendar, with the doctype of its items.
+type calendarCollection struct {
+	name        string
+	doctype     string
+	component   string
+	displayName string
+	description string
+}
+
+var calendarCollections = []*calendarCollection{
+	{
+		name:        "events",
+		doctype:     consts.CalendarEvents,
+		component:   "VEVENT",
+		displayName: "Cozy",
+		description: "The events of your Cozy",
+	},
+	{
+		name:        "tasks",
+		doctype:     consts.CalendarTodos,
+		component:   "VTODO",
+		displayName: "Tasks",
+		description: "The tasks of your Cozy",
+	},
+}
+
+func (col *calendarCollection) path() string {
+	return calendarsHome + col.name + "/"
+}
+
+func (col *calendarCollection) href(id string) string {
+	return col.path() + url.PathEscape(id) + iCalExt
+}
+
+// idFromHref returns the identifier of the item for an href of the calendar,
+// or an empty string.
+func (col *calendarCollection) idFromHref(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	if !strings.HasPrefix(href, col.path()) || !strings.HasSuffix(href, iCalExt) {
+		return ""
+	}
+	id, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(href, col.path()), iCalExt))
+	if err != nil || strings.Contains(id, "/") {
+		return ""
+	}
+	return id
+}
+
+var (
+	propCalendarHome     = xml.Name{Space: nsCalDAV, Local: "calendar-home-set"}
+	propCalendarDesc     = xml.Name{Space: nsCalDAV, Local: "calendar-description"}
+	propCalendarData     = xml.Name{Space: nsCalDAV, Local: "calendar-data"}
+	propSupportedComps   = xml.Name{Space: nsCalDAV, Local: "supported-calendar-component-set"}
+	propSupportedCalData = xml.Name{Space: nsCalDAV, Local: "supported-calendar-data"}
+	propCalMaxSize       = xml.Name{Space: nsCalDAV, Local: "max-resource-size"}
+	reportCalMultiget    = xml.Name{Space: nsCalDAV, Local: "calendar-multiget"}
+	reportCalQuery       = xml.Name{Space: nsCalDAV, Local: "calendar-query"}
+	reportFreeBusy       = xml.Name{Space: nsCalDAV, Local: "free-busy-query"}
+	condValidCalData     = xml.Name{Space: nsCalDAV, Local: "valid-calendar-data"}
+)
+
+var (
+	calendarProps = []xml.Name{propResourceType, propDisplayName, propPrincipal, propOwner,
+		propPrivileges, propReportSet, propSyncToken, propCTag, propSupportedComps,
+		propSupportedCalData, propCalMaxSize}
+	iCalProps = []xml.Name{propResourceType, propETag, propContentType, propContentLength}
+)
+
+// calDAV is created for each request to the CalDAV server.
+type calDAV struct {
+	c    echo.Context
+	inst *instance.Instance
+	perm *permission.Permission
+}
+
+// checkCalendarPermission ensures that the request has a token with the
+// permission to read all the events or all the todos.
+func checkCalendarPermission(c echo.Context) (*permission.Permission, error) {
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="Cozy"`)
+		return nil, echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	for _, col := range calendarCollections {
+		if pdoc.Permissions.AllowWholeType(permission.GET, col.doctype) {
+			return pdoc, nil
+		}
+	}
+	return nil, middlewares.ErrForbidden
+}
+
+// calendarsHandler serves the calendar home, the calendars, and the
+// iCalendar objects.
+func calendarsHandler(c echo.Context) error {
+	pdoc, err := checkCalendarPermission(c)
+	if err != nil {
+		return err
+	}
+	setDAVHeaders(c, calMethods, "1, 3, calendar-access")
+	if c.Request().Method == http.MethodOptions {
+		return c.NoContent(http.StatusOK)
+	}
+
+	dav := &calDAV{c: c, inst: middlewares.GetInstance(c), perm: pdoc}
+	rest := strings.Trim(c.Param("*"), "/")
+	if rest == "" {
+		return dav.serveHome()
+	}
+	name, resource, _ := strings.Cut(rest, "/")
+	for _, col := range calendarCollections {
+		if col.name != name {
+			continue
+		}
+		if !dav.allowed(col) {
+			return middlewares.ErrForbidden
+		}
+		if resource == "" {
+			return dav.serveCalendar(col)
+		}
+		if !strings.HasSuffix(resource, iCalExt) {
+			break
+		}
+		id, err := url.PathUnescape(strings.TrimSuffix(resource, iCalExt))
+		if err != nil || strings.Contains(id, "/") {
+			break
+		}
+		return dav.serveItem(col, id)
+	}
+	return echo.NewHTTPError(http.StatusNotFound)
+}
+
+func (dav *calDAV) allowed(col *calendarCollection) bool {
+	return dav.perm.Permissions.AllowWholeType(permission.GET, col.doctype)
+}
+
+func (dav *calDAV) serveHome() error {
+	if dav.c.Request().Method != "PROPFIND" {
+		return echo.NewHTTPError(http.StatusMethodNotAllowed)
+	}
+	names, err := propfindNames(dav.c)
+	if err != nil {
+		return err
+	}
+	responses := []*davResponse{dav.homeResponse(names)}
+	if depth(dav.c) > 0 {
+		for _, col := range calendarCollections {
+			if !dav.allowed(col) {
+				continue
+			}
+			r, err := dav.calendarResponse(col, names)
+			if err != nil {
+				return err
+			}
+			responses = append(responses, r)
+		}
+	}
+	return writeMultistatus(dav.c, responses, "")
+}
+
+func (dav *calDAV) serveCalendar(col *calendarCollection) error {
+	switch dav.c.Request().Method {
+	case "PROPFIND":
+		names, err := propfindNames(dav.c)
+		if err != nil {
+			return err
+		}
+		r, err := dav.calendarResponse(col, names)
+		if err != nil {
+			return err
+		}
+		responses := []*davResponse{r}
+		if depth(dav.c) > 0 {
+			items, err := calendar.ListItems(dav.inst, col.doctype)
+			if err != nil {
+				return err
+			}
+			for _, item := range items {
+				responses = append(responses, dav.itemResponse(col, item, names))
+			}
+		}
+		return writeMultistatus(dav.c, responses, "")
+	case "REPORT":
+		return dav.report(col)
+	}
+	return echo.NewHTTPError(http.StatusMethodNotAllowed)
+}
+
+func (dav *calDAV) homeResponse(names propNames) *davResponse {
+	return newResponse(calendarsHome, names, homeProps, func(name xml.Name) (propValue, bool) {
+		switch name {
+		case propResourceType:
+			return xmlValue("<d:collection/>"), true
+		case propDisplayName:
+			return textValue("Calendars"), true
+		case propPrincipal, propOwner:
+			return hrefValue(principalPath), true
+		}
+		return propValue{}, false
+	})
+}
+
+func (dav *calDAV) calendarResponse(col *calendarCollection, names propNames) (*davResponse, error) {
+	token, err := currentSyncToken(dav.inst, col.doctype)
+	if err != nil {
+		return nil, err
+	}
+	return newResponse(col.path(), names, calendarProps, func(name xml.Name) (propValue, bool) {
+		switch name {
+		case propResourceType:
+			return xmlValue("<d:collection/><cal:calendar/>"), true
+		case propDisplayName:
+			return textValue(col.displayName), true
+		case propCalendarDesc:
+			return textValue(col.description), true
+		case propPrincipal, propOwner:
+			return hrefValue(principalPath), true
+		case propPrivileges:
+			return xmlValue(dav.privileges(col)), true
+		case propReportSet:
+			return xmlValue(calendarReports(col)), true
+		case propSyncToken, propCTag:
+			return textValue(token), true
+		case propSupportedComps:
+			return xmlValue(`<cal:comp name="` + col.component + `"/>`), true
+		case propSupportedCalData:
+			return xmlValue(`<cal:calendar-data content-type="text/calendar" version="2.0"/>`), true
+		case propCalMaxSize:
+			return textValue(strconv.Itoa(maxICalSize)), true
+		}
+		return propValue{}, false
+	}), nil
+}
+
+// itemResponse returns the response for an event or todo. The iCalendar
+// object is generated only if the calendar-data property is asked.
+func (dav *calDAV) itemResponse(col *calendarCollection, item calendar.Item, names propNames) *davResponse {
+	var data []byte
+	getData := func() []byte {
+		if data == nil {
+			data = item.ICal()
+		}
+		return data
+	}
+	return newResponse(col.href(item.ID()), names, iCalProps, func(name xml.Name) (propValue, bool) {
+		switch name {
+		case propResourceType:
+			return propValue{}, true
+		case propETag:
+			return textValue(etag(item)), true
+		case propContentType:
+			return textValue(iCalMime), true
+		case propContentLength:
+			return textValue(strconv.Itoa(len(getData()))), true
+		case propLastModified:
+			if md := item.Base().Metadata; md != nil && !md.UpdatedAt.IsZero() {
+				return textValue(md.UpdatedAt.UTC().Format(http.TimeFormat)), true
+			}
+		case propCalendarData:
+			return textValue(string(getData())), true
+		}
+		return propValue{}, false
+	})
+}
+
+// privileges returns the privileges of the current user on a calendar, from
+// the permissions of the token.
+func (dav *calDAV) privileges(col *calendarCollection) string {
+	privileges := "<d:privilege><d:read/></d:privilege>" +
+		"<d:privilege><cal:read-free-busy/></d:privilege>"
+	set := dav.perm.Permissions
+	if set.AllowWholeType(permission.POST, col.doctype) &&
+		set.AllowWholeType(permission.PUT, col.doctype) &&
+		set.AllowWholeType(permission.DELETE, col.doctype) {
+		privileges += "<d:privilege><d:write/></d:privilege>" +
+			"<d:privilege><d:write-content/></d:privilege>" +
+			"<d:privilege><d:bind/></d:privilege>" +
+			"<d:privilege><d:unbind/></d:privilege>"
+	}
+	return privileges
+}
+
+func calendarReports(col *calendarCollection) string {
+	reports := []string{"<d:sync-collection/>", "<cal:calendar-multiget/>", "<cal:calendar-query/>"}
+	if col.doctype == consts.CalendarEvents {
+		reports = append(reports, "<cal:free-busy-query/>")
+	}
+	var sb strings.Builder
+	for _, report := range reports {
+		sb.WriteString("<d:supported-report><d:report>" + report + "</d:report></d:supported-report>")
+	}
+	return sb.String()
+}
+
+func (dav *calDAV) serveItem(col *calendarCollection, id string) error {
+	switch dav.c.Request().Method {
+	case http.MethodGet, http.MethodHead:
+		return dav.getItem(col, id)
+	case http.MethodPut:
+		return dav.putItem(col, id)
+	case http.MethodDelete:
+		return dav.deleteItem(col, id)
+	case "PROPFIND":
+		names, err := propfindNames(dav.c)
+		if err != nil {
+			return err
+		}
+		item, err := findItem(dav.inst, col, id)
+		if err != nil {
+			return err
+		}
+		return writeMultistatus(dav.c, []*davResponse{dav.itemResponse(col, item, names)}, "")
+	}
+	return echo.NewHTTPError(http.StatusMethodNotAllowed)
+}
+
+func (dav *calDAV) getItem(col *calendarCollection, id string) error {
+	item, err := findItem(dav.inst, col, id)
+	if err != nil {
+		return err
+	}
+	h := dav.c.Response().Header()
+	h.Set(echo.HeaderContentType, iCalMime)
+	h.Set("ETag", etag(item))
+	if md := item.Base().Metadata; md != nil && !md.UpdatedAt.IsZero() {
+		h.Set(echo.HeaderLastModified, md.UpdatedAt.UTC().Format(http.TimeFormat))
+	}
+	data := item.ICal()
+	if dav.c.Request().Method == http.MethodHead {
+		h.Set(echo.HeaderContentLength, strconv.Itoa(len(data)))
+		return dav.c.NoContent(http.StatusOK)
+	}
+	return dav.c.Blob(http.StatusOK, iCalMime, data)
+}
+
+// putItem creates or updates an event or todo. Like for the vCards, the ETag
+// is not sent in the response, as the iCalendar object is converted to JSON.
+func (dav *calDAV) putItem(col *calendarCollection, id string) error {
+	req := dav.c.Request()
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxICalSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxICalSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+	}
+
+	item, err := calendar.FindItem(dav.inst, col.doctype, id)
+	exists := err == nil
+	if err != nil && !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+	if err := checkPreconditions(req, item, exists); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if !exists {
+		if !validResourceName.MatchString(id) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid resource name")
+		}
+		item, err = calendar.NewItem(col.doctype)
+		if err != nil {
+			return err
+		}
+		item.SetID(id)
+		md := metadata.New()
+		md.CreatedAt = now
+		md.UpdatedAt = now
+		item.Base().Metadata = md
+	} else {
+		if err := middlewares.Allow(dav.c, permission.PUT, item); err != nil {
+			return err
+		}
+		if item.Base().Metadata == nil {
+			item.Base().Metadata = metadata.New()
+		}
+		item.Base().Metadata.UpdatedAt = now
+	}
+
+	if err := item.UpdateFromICal(data); err != nil {
+		if errors.Is(err, calendar.ErrInvalidICal) || errors.Is(err, calendar.ErrInvalidRRule) ||
+			errors.Is(err, calendar.ErrUnsupportedRRule) {
+			return writeError(dav.c, http.StatusBadRequest, condValidCalData)
+		}
+		return err
+	}
+	if err := calendar.SetupTrigger(dav.inst); err != nil {
+		dav.inst.Logger().WithNamespace("dav").
+			Warnf("Cannot setup the trigger for the reminders: %s", err)
+	}
+
+	if exists {
+		if err := couchdb.UpdateDoc(dav.inst, item); err != nil {
+			return err
+		}
+		return dav.c.NoContent(http.StatusNoContent)
+	}
+	if err := middlewares.Allow(dav.c, permission.POST, item); err != nil {
+		return err
+	}
+	if err := couchdb.CreateNamedDocWithDB(dav.inst, item); err != nil {
+		return err
+	}
+	return dav.c.NoContent(http.StatusCreated)
+}
+
+func (dav *calDAV) deleteItem(col *calendarCollection, id string) error {
+	item, err := findItem(dav.inst, col, id)
+	if err != nil {
+		return err
+	}
+	if err := checkPreconditions(dav.c.Request(), item, true); err != nil {
+		return err
+	}
+	if err := middlewares.Allow(dav.c, permission.DELETE, item); err != nil {
+		return err
+	}
+	if err := couchdb.DeleteDoc(dav.inst, item); err != nil {
+		return err
+	}
+	return dav.c.NoContent(http.StatusNoContent)
+}
+
+// findItem returns the event or todo with the given identifier, or a 404
+// error if it doesn't exist.
+func findItem(inst *instance.Instance, col *calendarCollection, id string) (calendar.Item, error) {
+	item, err := calendar.FindItem(inst, col.doctype, id)
+	if err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, echo.NewHTTPError(http.StatusNotFound)
+		}
+		return nil, err
+	}
+	return item, nil
+}
@@ -0,0 +1,368 @@
+package dav
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/calendar"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/contentline"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/labstack/echo/v4"
+)
+
+// timeRangeLayout is the format of the dates in the time-range elements.
+const timeRangeLayout = "20060102T150405Z"
+
+// calMultigetRequest is the body of a calendar-multiget REPORT.
+type calMultigetRequest struct {
+	XMLName xml.Name  `xml:"urn:ietf:params:xml:ns:caldav calendar-multiget"`
+	Prop    propNames `xml:"DAV: prop"`
+	Hrefs   []string  `xml:"DAV: href"`
+}
+
+// calQueryRequest is the body of a calendar-query REPORT. The filters on the
+// parameters are not supported, and the time ranges are only used on the
+// events and todos, not on the alarms or the properties.
+type calQueryRequest struct {
+	XMLName xml.Name  `xml:"urn:ietf:params:xml:ns:caldav calendar-query"`
+	Prop    propNames `xml:"DAV: prop"`
+	Filter  struct {
+		CompFilter compFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+type compFilter struct {
+	Name         string          `xml:"name,attr"`
+	IsNotDefined *struct{}       `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *timeRange      `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	PropFilters  []calPropFilter `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+	CompFilters  []compFilter    `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+type calPropFilter struct {
+	Name         string     `xml:"name,attr"`
+	IsNotDefined *struct{}  `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TextMatch    *textMatch `xml:"urn:ietf:params:xml:ns:caldav text-match"`
+}
+
+type timeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+// freeBusyRequest is the body of a free-busy-query REPORT.
+type freeBusyRequest struct {
+	XMLName   xml.Name   `xml:"urn:ietf:params:xml:ns:caldav free-busy-query"`
+	TimeRange *timeRange `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+}
+
+// bounds returns the start and end of the time range. A missing start is the
+// beginning of time, and a missing end is no limit (a zero time).
+func (tr *timeRange) bounds() (time.Time, time.Time, error) {
+	var start, end time.Time
+	if tr.Start == "" && tr.End == "" {
+		return start, end, errors.New("Invalid time range")
+	}
+	if tr.Start != "" {
+		t, err := time.Parse(timeRangeLayout, tr.Start)
+		if err != nil {
+			return start, end, err
+		}
+		start = t
+	}
+	if tr.End != "" {
+		t, err := time.Parse(timeRangeLayout, tr.End)
+		if err != nil {
+			return start, end, err
+		}
+		end = t
+	}
+	return start, end, nil
+}
+
+// report serves the REPORT requests on a calendar.
+func (dav *calDAV) report(col *calendarCollection) error {
+	body, err := readBody(dav.c)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		return badRequest(errInvalidXML)
+	}
+	root, err := rootName(body)
+	if err != nil {
+		return badRequest(err)
+	}
+	switch root {
+	case reportSyncCollection:
+		var req syncCollectionRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			return badRequest(errInvalidXML)
+		}
+		return dav.syncCollection(col, &req)
+	case reportCalMultiget:
+		var req calMultigetRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			return badRequest(errInvalidXML)
+		}
+		return dav.multiget(col, &req)
+	case reportCalQuery:
+		var req calQueryRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			return badRequest(errInvalidXML)
+		}
+		return dav.query(col, &req)
+	case reportFreeBusy:
+		if col.doctype != consts.CalendarEvents {
+			break
+		}
+		var req freeBusyRequest
+		if err := xml.Unmarshal(body, &req); err != nil || req.TimeRange == nil {
+			return badRequest(errInvalidXML)
+		}
+		return dav.freeBusy(&req)
+	}
+	return echo.NewHTTPError(http.StatusForbidden, "Unsupported report")
+}
+
+// syncCollection sends the items that have changed since the sync token.
+// Without a sync token, all the items are sent.
+func (dav *calDAV) syncCollection(col *calendarCollection, req *syncCollectionRequest) error {
+	if req.SyncToken == "" {
+		token, err := currentSyncToken(dav.inst, col.doctype)
+		if err != nil {
+			return err
+		}
+		items, err := calendar.ListItems(dav.inst, col.doctype)
+		if err != nil {
+			return err
+		}
+		responses := make([]*davResponse, 0, len(items))
+		for _, item := range items {
+			responses = append(responses, dav.itemResponse(col, item, req.Prop))
+		}
+		return writeMultistatus(dav.c, responses, token)
+	}
+
+	res, err := changesSince(dav.inst, col.doctype, req.SyncToken, req.Limit.NResults)
+	if err != nil {
+		if errors.Is(err, errInvalidSyncToken) {
+			return writeError(dav.c, http.StatusForbidden, condValidSyncToken)
+		}
+		return err
+	}
+	responses := make([]*davResponse, 0, len(res.Results)+1)
+	for _, change := range res.Results {
+		if change.Deleted {
+			responses = append(responses, statusResponse(col.href(change.DocID), http.StatusNotFound))
+			continue
+		}
+		item, err := calendar.NewItem(col.doctype)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(&change.Doc)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, item); err != nil {
+			continue
+		}
+		responses = append(responses, dav.itemResponse(col, item, req.Prop))
+	}
+	if res.Pending > 0 {
+		responses = append(responses, statusResponse(col.path(), http.StatusInsufficientStorage))
+	}
+	return writeMultistatus(dav.c, responses, syncTokenPrefix+res.LastSeq)
+}
+
+// multiget sends the items for the given hrefs.
+func (dav *calDAV) multiget(col *calendarCollection, req *calMultigetRequest) error {
+	responses := make([]*davResponse, 0, len(req.Hrefs))
+	for _, href := range req.Hrefs {
+		id := col.idFromHref(href)
+		if id == "" {
+			responses = append(responses, statusResponse(href, http.StatusNotFound))
+			continue
+		}
+		item, err := calendar.FindItem(dav.inst, col.doctype, id)
+		if err != nil {
+			if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+				responses = append(responses, statusResponse(href, http.StatusNotFound))
+				continue
+			}
+			return err
+		}
+		responses = append(responses, dav.itemResponse(col, item, req.Prop))
+	}
+	return writeMultistatus(dav.c, responses, "")
+}
+
+// query sends the items that match the filter.
+func (dav *calDAV) query(col *calendarCollection, req *calQueryRequest) error {
+	filter := &req.Filter.CompFilter
+	if !strings.EqualFold(filter.Name, "VCALENDAR") {
+		return badRequest(errInvalidXML)
+	}
+	items, err := calendar.ListItems(dav.inst, col.doctype)
+	if err != nil {
+		return err
+	}
+	responses := []*davResponse{}
+	for _, item := range items {
+		root, err := parseICalTree(item.ICal())
+		if err != nil {
+			continue
+		}
+		ok, err := filter.match(root, item)
+		if err != nil {
+			return badRequest(err)
+		}
+		if ok {
+			responses = append(responses, dav.itemResponse(col, item, req.Prop))
+		}
+	}
+	return writeMultistatus(dav.c, responses, "")
+}
+
+// freeBusy sends the busy periods of the events in the time range.
+func (dav *calDAV) freeBusy(req *freeBusyRequest) error {
+	from, to, err := req.TimeRange.bounds()
+	if err != nil {
+		return badRequest(err)
+	}
+	if to.IsZero() {
+		return badRequest(errors.New("The end of the time range is required"))
+	}
+	items, err := calendar.ListItems(dav.inst, consts.CalendarEvents)
+	if err != nil {
+		return err
+	}
+	events := make([]*calendar.Event, 0, len(items))
+	for _, item := range items {
+		if ev, ok := item.(*calendar.Event); ok {
+			events = append(events, ev)
+		}
+	}
+	periods := calendar.FreeBusy(events, from, to)
+	return dav.c.Blob(http.StatusOK, iCalMime, calendar.FreeBusyICal(periods, from, to))
+}
+
+// iCalTree is a component of an iCalendar object, with its properties and
+// its sub-components.
+type iCalTree struct {
+	name     string
+	props    []*contentline.Property
+	children []*iCalTree
+}
+
+func parseICalTree(data []byte) (*iCalTree, error) {
+	props, err := contentline.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	var root *iCalTree
+	var stack []*iCalTree
+	for _, p := range props {
+		switch strings.ToUpper(p.Name) {
+		case "BEGIN":
+			comp := &iCalTree{name: strings.ToUpper(p.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, comp)
+			} else if root == nil {
+				root = comp
+			}
+			stack = append(stack, comp)
+		case "END":
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		default:
+			if len(stack) > 0 {
+				comp := stack[len(stack)-1]
+				comp.props = append(comp.props, p)
+			}
+		}
+	}
+	if root == nil {
+		return nil, calendar.ErrInvalidICal
+	}
+	return root, nil
+}
+
+// match returns true if the component matches the filter: all the property
+// filters and all the sub-component filters must match.
+func (f *compFilter) match(comp *iCalTree, item calendar.Item) (bool, error) {
+	if !strings.EqualFold(f.Name, comp.name) {
+		return false, nil
+	}
+	if f.TimeRange != nil && (comp.name == "VEVENT" || comp.name == "VTODO") {
+		from, to, err := f.TimeRange.bounds()
+		if err != nil {
+			return false, err
+		}
+		if !calendar.Overlaps(item, from, to) {
+			return false, nil
+		}
+	}
+	for i := range f.PropFilters {
+		if !f.PropFilters[i].match(comp.props) {
+			return false, nil
+		}
+	}
+	for i := range f.CompFilters {
+		sub := &f.CompFilters[i]
+		found := false
+		for _, child := range comp.children {
+			if !strings.EqualFold(sub.Name, child.name) {
+				continue
+			}
+			if sub.IsNotDefined != nil {
+				found = true
+				break
+			}
+			ok, err := sub.match(child, item)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				found = true
+				break
+			}
+		}
+		if found == (sub.IsNotDefined != nil) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (f *calPropFilter) match(props []*contentline.Property) bool {
+	var values []string
+	for _, prop := range props {
+		if strings.EqualFold(prop.Name, f.Name) {
+			values = append(values, contentline.UnescapeText(prop.Value))
+		}
+	}
+	if f.IsNotDefined != nil {
+		return len(values) == 0
+	}
+	if len(values) == 0 {
+		return false
+	}
+	if f.TextMatch == nil {
+		return true
+	}
+	for _, value := range values {
+		if f.TextMatch.match(value) {
+			return true
+		}
+	}
+	return false
+}
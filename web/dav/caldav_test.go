@@ -0,0 +1,206 @@
+package dav
+
+import (
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/calendar"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const standup = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Client//EN\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:standup@example.com\r\n" +
+	"DTSTART:20240102T090000Z\r\n" +
+	"DTEND:20240102T091500Z\r\n" +
+	"RRULE:FREQ=DAILY;COUNT=5\r\n" +
+	"SUMMARY:Stand-up\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT5M\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestCompFilter(t *testing.T) {
+	ev := &calendar.Event{}
+	require.NoError(t, ev.UpdateFromICal([]byte(standup)))
+	root, err := parseICalTree(ev.ICal())
+	require.NoError(t, err)
+
+	query := func(filter string) bool {
+		t.Helper()
+		var req calQueryRequest
+		body := `<cal:calendar-query xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav"><cal:filter>` +
+			filter + `</cal:filter></cal:calendar-query>`
+		require.NoError(t, xml.Unmarshal([]byte(body), &req))
+		ok, err := req.Filter.CompFilter.match(root, ev)
+		require.NoError(t, err)
+		return ok
+	}
+
+	assert.True(t, query(`<cal:comp-filter name="VCALENDAR"/>`))
+	assert.True(t, query(`<cal:comp-filter name="VCALENDAR"><cal:comp-filter name="VEVENT"/></cal:comp-filter>`))
+	assert.False(t, query(`<cal:comp-filter name="VCALENDAR"><cal:comp-filter name="VTODO"/></cal:comp-filter>`))
+	assert.True(t, query(`<cal:comp-filter name="VCALENDAR"><cal:comp-filter name="VTODO"><cal:is-not-defined/></cal:comp-filter></cal:comp-filter>`))
+	assert.True(t, query(`<cal:comp-filter name="VCALENDAR"><cal:comp-filter name="VEVENT">`+
+		`<cal:time-range start="20240105T000000Z" end="20240106T000000Z"/></cal:comp-filter></cal:comp-filter>`))
+	assert.False(t, query(`<cal:comp-filter name="VCALENDAR"><cal:comp-filter name="VEVENT">`+
+		`<cal:time-range start="20240110T000000Z" end="20240111T000000Z"/></cal:comp-filter></cal:comp-filter>`))
+	assert.True(t, query(`<cal:comp-filter name="VCALENDAR"><cal:comp-filter name="VEVENT">`+
+		`<cal:prop-filter name="SUMMARY"><cal:text-match>stand</cal:text-match></cal:prop-filter>`+
+		`<cal:comp-filter name="VALARM"/></cal:comp-filter></cal:comp-filter>`))
+	assert.False(t, query(`<cal:comp-filter name="VCALENDAR"><cal:comp-filter name="VEVENT">`+
+		`<cal:prop-filter name="LOCATION"/></cal:comp-filter></cal:comp-filter>`))
+}
+
+func TestCalDAV(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	testInstance := setup.GetTestInstance()
+	_, token := setup.GetTestClient(consts.CalendarEvents + " " + consts.CalendarTodos)
+	_, contactsToken := setup.GetTestClient(consts.Contacts)
+	ts := setup.GetTestServer("/dav", Routes)
+	t.Cleanup(ts.Close)
+
+	var syncToken string
+	tokenRegexp := regexp.MustCompile(`<d:sync-token>([^<]+)</d:sync-token>`)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.Request("PROPFIND", "/dav/calendars/").
+			Expect().Status(401).
+			Header("WWW-Authenticate").Contains("Basic")
+
+		e.Request("PROPFIND", "/dav/calendars/").
+			WithBasicAuth("", contactsToken).
+			Expect().Status(403)
+	})
+
+	t.Run("Discovery", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.Request("PROPFIND", "/dav/principal/").
+			WithBasicAuth("", token).
+			WithHeader("Depth", "0").
+			WithBytes([]byte(`<d:propfind xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav"><d:prop><cal:calendar-home-set/></d:prop></d:propfind>`)).
+			Expect().Status(207).
+			Body().Contains("<cal:calendar-home-set><d:href>/dav/calendars/</d:href></cal:calendar-home-set>")
+
+		body := e.Request("PROPFIND", "/dav/calendars/").
+			WithBasicAuth("", token).
+			WithHeader("Depth", "1").
+			Expect().Status(207).
+			Body()
+		body.Contains("<d:href>/dav/calendars/events/</d:href>")
+		body.Contains("<d:href>/dav/calendars/tasks/</d:href>")
+		body.Contains(`<cal:comp name="VTODO"/>`)
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		body := e.Request("REPORT", "/dav/calendars/events/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<d:sync-collection xmlns:d="DAV:"><d:sync-token/><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`)).
+			Expect().Status(207).
+			Body()
+		matches := tokenRegexp.FindStringSubmatch(body.Raw())
+		require.Len(t, matches, 2)
+		syncToken = matches[1]
+
+		e.PUT("/dav/calendars/events/standup.ics").
+			WithBasicAuth("", token).
+			WithHeader("If-None-Match", "*").
+			WithHeader("Content-Type", "text/calendar").
+			WithBytes([]byte(standup)).
+			Expect().Status(201)
+
+		e.PUT("/dav/calendars/events/invalid.ics").
+			WithBasicAuth("", token).
+			WithBytes([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n")).
+			Expect().Status(400).
+			Body().Contains("valid-calendar-data")
+
+		// A rule with too many times in a day is refused
+		flood := strings.Replace(standup, "RRULE:FREQ=DAILY;COUNT=5",
+			"RRULE:FREQ=DAILY;BYHOUR=0,1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23;"+
+				"BYMINUTE=0,5,10,15,20,25,30,35,40,45,50,55;BYSECOND=0,30", 1)
+		flood = strings.Replace(flood, "UID:standup@example.com", "UID:flood@example.com", 1)
+		e.PUT("/dav/calendars/events/flood.ics").
+			WithBasicAuth("", token).
+			WithBytes([]byte(flood)).
+			Expect().Status(400).
+			Body().Contains("valid-calendar-data")
+
+		item, err := calendar.FindItem(testInstance, consts.CalendarEvents, "standup")
+		require.NoError(t, err)
+		assert.Equal(t, "Stand-up", item.Base().Summary)
+
+		res := e.GET("/dav/calendars/events/standup.ics").
+			WithBasicAuth("", token).
+			Expect().Status(200)
+		res.Header("ETag").IsEqual(`"` + item.Rev() + `"`)
+		res.Body().Contains("RRULE:FREQ=DAILY;COUNT=5\r\n")
+
+		e.Request("REPORT", "/dav/calendars/events/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<d:sync-collection xmlns:d="DAV:"><d:sync-token>` + syncToken + `</d:sync-token><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`)).
+			Expect().Status(207).
+			Body().Contains("<d:href>/dav/calendars/events/standup.ics</d:href>")
+	})
+
+	t.Run("Reports", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.Request("REPORT", "/dav/calendars/events/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<cal:calendar-multiget xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav"><d:prop><cal:calendar-data/></d:prop><d:href>/dav/calendars/events/standup.ics</d:href></cal:calendar-multiget>`)).
+			Expect().Status(207).
+			Body().Contains("SUMMARY:Stand-up")
+
+		e.Request("REPORT", "/dav/calendars/events/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<cal:calendar-query xmlns:d="DAV:" xmlns:cal="urn:ietf:params:xml:ns:caldav"><d:prop><d:getetag/></d:prop><cal:filter><cal:comp-filter name="VCALENDAR"><cal:comp-filter name="VEVENT"><cal:time-range start="20240103T000000Z" end="20240104T000000Z"/></cal:comp-filter></cal:comp-filter></cal:filter></cal:calendar-query>`)).
+			Expect().Status(207).
+			Body().Contains("standup.ics")
+
+		e.Request("REPORT", "/dav/calendars/events/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<cal:free-busy-query xmlns:cal="urn:ietf:params:xml:ns:caldav"><cal:time-range start="20240101T000000Z" end="20240104T000000Z"/></cal:free-busy-query>`)).
+			Expect().Status(200).
+			Body().Contains("FREEBUSY;FBTYPE=BUSY:20240102T090000Z/20240102T091500Z,")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.DELETE("/dav/calendars/events/standup.ics").
+			WithBasicAuth("", token).
+			WithHeader("If-Match", `"1-nope"`).
+			Expect().Status(412)
+
+		e.DELETE("/dav/calendars/events/standup.ics").
+			WithBasicAuth("", token).
+			Expect().Status(http.StatusNoContent)
+
+		e.GET("/dav/calendars/events/standup.ics").
+			WithBasicAuth("", token).
+			Expect().Status(404)
+	})
+}
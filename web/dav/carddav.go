@@ -0,0 +1,596 @@
+package dav
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/metadata"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// The contacts are served in a single address book, and the groups of
+// contacts are converted to the CATEGORIES of the vCards.
+const (
+	principalPath   = "/dav/principal/"
+	contactsHome    = "/dav/contacts/"
+	addressBookName = "default"
+	addressBookPath = contactsHome + addressBookName + "/"
+	vCardExt        = ".vcf"
+	vCardMime       = "text/vcard; charset=utf-8"
+)
+
+// maxVCardSize is the maximal size of a vCard sent by a client.
+const maxVCardSize = 1 << 20
+
+// cardMethods is the list of HTTP methods that are handled by the CardDAV
+// server.
+var cardMethods = []string{
+	http.MethodOptions,
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPut,
+	http.MethodDelete,
+	"PROPFIND",
+	"REPORT",
+}
+
+// validResourceName is used to check the names of the vCards created by the
+// clients, as they are used for the identifiers of the contacts.
+var validResourceName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@+-]{0,199}$`)
+
+var (
+	propResourceType     = xml.Name{Space: nsDAV, Local: "resourcetype"}
+	propDisplayName      = xml.Name{Space: nsDAV, Local: "displayname"}
+	propPrincipal        = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+	propPrincipalURL     = xml.Name{Space: nsDAV, Local: "principal-URL"}
+	propOwner            = xml.Name{Space: nsDAV, Local: "owner"}
+	propPrivileges       = xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}
+	propReportSet        = xml.Name{Space: nsDAV, Local: "supported-report-set"}
+	propSyncToken        = xml.Name{Space: nsDAV, Local: "sync-token"}
+	propETag             = xml.Name{Space: nsDAV, Local: "getetag"}
+	propContentType      = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+	propContentLength    = xml.Name{Space: nsDAV, Local: "getcontentlength"}
+	propLastModified     = xml.Name{Space: nsDAV, Local: "getlastmodified"}
+	propCTag             = xml.Name{Space: nsCS, Local: "getctag"}
+	propAddressBookHome  = xml.Name{Space: nsCardDAV, Local: "addressbook-home-set"}
+	propAddressBookDesc  = xml.Name{Space: nsCardDAV, Local: "addressbook-description"}
+	propAddressData      = xml.Name{Space: nsCardDAV, Local: "address-data"}
+	propSupportedData    = xml.Name{Space: nsCardDAV, Local: "supported-address-data"}
+	propMaxResourceSize  = xml.Name{Space: nsCardDAV, Local: "max-resource-size"}
+	reportSyncCollection = xml.Name{Space: nsDAV, Local: "sync-collection"}
+	reportMultiget       = xml.Name{Space: nsCardDAV, Local: "addressbook-multiget"}
+	reportQuery          = xml.Name{Space: nsCardDAV, Local: "addressbook-query"}
+	condValidSyncToken   = xml.Name{Space: nsDAV, Local: "valid-sync-token"}
+	condValidAddressData = xml.Name{Space: nsCardDAV, Local: "valid-address-data"}
+)
+
+var (
+	principalProps   = []xml.Name{propResourceType, propDisplayName, propPrincipal, propPrincipalURL, propAddressBookHome, propCalendarHome}
+	homeProps        = []xml.Name{propResourceType, propDisplayName, propPrincipal, propOwner}
+	addressBookProps = []xml.Name{propResourceType, propDisplayName, propPrincipal, propOwner,
+		propPrivileges, propReportSet, propSyncToken, propCTag, propSupportedData, propMaxResourceSize}
+	vCardProps = []xml.Name{propResourceType, propETag, propContentType, propContentLength}
+)
+
+// cardDAV is created for each request to the CardDAV server.
+type cardDAV struct {
+	c    echo.Context
+	inst *instance.Instance
+	perm *permission.Permission
+}
+
+// checkContactsPermission ensures that the request has a token with the
+// permission to read all the contacts.
+func checkContactsPermission(c echo.Context) (*permission.Permission, error) {
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="Cozy"`)
+		return nil, echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	if !pdoc.Permissions.AllowWholeType(permission.GET, consts.Contacts) {
+		return nil, middlewares.ErrForbidden
+	}
+	return pdoc, nil
+}
+
+func setDAVHeaders(c echo.Context, methods []string, features string) {
+	h := c.Response().Header()
+	h.Set("DAV", features)
+	h.Set(echo.HeaderAllow, strings.Join(methods, ", "))
+}
+
+// principalHandler serves the principal resource, used by the clients to
+// discover the home collections.
+func principalHandler(c echo.Context) error {
+	if _, err := middlewares.GetPermission(c); err != nil {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="Cozy"`)
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	inst := middlewares.GetInstance(c)
+	setDAVHeaders(c, []string{http.MethodOptions, "PROPFIND"}, "1, 3, addressbook, calendar-access")
+	if c.Request().Method == http.MethodOptions {
+		return c.NoContent(http.StatusOK)
+	}
+	body, err := readBody(c)
+	if err != nil {
+		return err
+	}
+	names, err := parsePropfind(body)
+	if err != nil {
+		return badRequest(err)
+	}
+	publicName, _ := inst.SettingsPublicName()
+	r := newResponse(principalPath, names, principalProps, func(name xml.Name) (propValue, bool) {
+		switch name {
+		case propResourceType:
+			return xmlValue("<d:principal/>"), true
+		case propDisplayName:
+			return textValue(publicName), true
+		case propPrincipal, propPrincipalURL:
+			return hrefValue(principalPath), true
+		case propAddressBookHome:
+			return hrefValue(contactsHome), true
+		case propCalendarHome:
+			return hrefValue(calendarsHome), true
+		}
+		return propValue{}, false
+	})
+	return writeMultistatus(c, []*davResponse{r}, "")
+}
+
+// contactsHandler serves the address book home, the address book, and the
+// vCards.
+func contactsHandler(c echo.Context) error {
+	pdoc, err := checkContactsPermission(c)
+	if err != nil {
+		return err
+	}
+	setDAVHeaders(c, cardMethods, "1, 3, addressbook")
+	if c.Request().Method == http.MethodOptions {
+		return c.NoContent(http.StatusOK)
+	}
+
+	dav := &cardDAV{c: c, inst: middlewares.GetInstance(c), perm: pdoc}
+	rest := strings.Trim(c.Param("*"), "/")
+	switch {
+	case rest == "":
+		return dav.serveHome()
+	case rest == addressBookName:
+		return dav.serveAddressBook()
+	case strings.HasPrefix(rest, addressBookName+"/") && strings.HasSuffix(rest, vCardExt):
+		id, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(rest, addressBookName+"/"), vCardExt))
+		if err != nil || strings.Contains(id, "/") {
+			return echo.NewHTTPError(http.StatusNotFound)
+		}
+		return dav.serveVCard(id)
+	}
+	return echo.NewHTTPError(http.StatusNotFound)
+}
+
+func (dav *cardDAV) serveHome() error {
+	if dav.c.Request().Method != "PROPFIND" {
+		return echo.NewHTTPError(http.StatusMethodNotAllowed)
+	}
+	names, err := propfindNames(dav.c)
+	if err != nil {
+		return err
+	}
+	responses := []*davResponse{dav.homeResponse(names)}
+	if depth(dav.c) > 0 {
+		r, err := dav.addressBookResponse(names)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, r)
+	}
+	return writeMultistatus(dav.c, responses, "")
+}
+
+func (dav *cardDAV) serveAddressBook() error {
+	switch dav.c.Request().Method {
+	case "PROPFIND":
+		names, err := propfindNames(dav.c)
+		if err != nil {
+			return err
+		}
+		r, err := dav.addressBookResponse(names)
+		if err != nil {
+			return err
+		}
+		responses := []*davResponse{r}
+		if depth(dav.c) > 0 {
+			contacts, err := listContacts(dav.inst)
+			if err != nil {
+				return err
+			}
+			groups, err := contact.ListGroups(dav.inst)
+			if err != nil {
+				return err
+			}
+			for _, doc := range contacts {
+				responses = append(responses, dav.vCardResponse(doc, names, groups))
+			}
+		}
+		return writeMultistatus(dav.c, responses, "")
+	case "REPORT":
+		return dav.report()
+	}
+	return echo.NewHTTPError(http.StatusMethodNotAllowed)
+}
+
+// propfindNames returns the names of the properties asked in the body of a
+// PROPFIND request.
+func propfindNames(c echo.Context) (propNames, error) {
+	body, err := readBody(c)
+	if err != nil {
+		return nil, err
+	}
+	names, err := parsePropfind(body)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	return names, nil
+}
+
+func (dav *cardDAV) homeResponse(names propNames) *davResponse {
+	return newResponse(contactsHome, names, homeProps, func(name xml.Name) (propValue, bool) {
+		switch name {
+		case propResourceType:
+			return xmlValue("<d:collection/>"), true
+		case propDisplayName:
+			return textValue("Contacts"), true
+		case propPrincipal, propOwner:
+			return hrefValue(principalPath), true
+		}
+		return propValue{}, false
+	})
+}
+
+func (dav *cardDAV) addressBookResponse(names propNames) (*davResponse, error) {
+	token, err := currentSyncToken(dav.inst, consts.Contacts)
+	if err != nil {
+		return nil, err
+	}
+	return newResponse(addressBookPath, names, addressBookProps, func(name xml.Name) (propValue, bool) {
+		switch name {
+		case propResourceType:
+			return xmlValue("<d:collection/><card:addressbook/>"), true
+		case propDisplayName:
+			return textValue("Cozy"), true
+		case propAddressBookDesc:
+			return textValue("The contacts of your Cozy"), true
+		case propPrincipal, propOwner:
+			return hrefValue(principalPath), true
+		case propPrivileges:
+			return xmlValue(dav.privileges()), true
+		case propReportSet:
+			return xmlValue(supportedReports()), true
+		case propSyncToken, propCTag:
+			return textValue(token), true
+		case propSupportedData:
+			return xmlValue(`<card:address-data-type content-type="text/vcard" version="4.0"/>` +
+				`<card:address-data-type content-type="text/vcard" version="3.0"/>`), true
+		case propMaxResourceSize:
+			return textValue(strconv.Itoa(maxVCardSize)), true
+		}
+		return propValue{}, false
+	}), nil
+}
+
+// vCardResponse returns the response for a contact. The vCard is generated
+// only if the address-data property is asked.
+func (dav *cardDAV) vCardResponse(doc *contact.Contact, names propNames, groups []*contact.Group) *davResponse {
+	var card []byte
+	getCard := func() []byte {
+		if card == nil {
+			card = doc.ToVCard(groups)
+		}
+		return card
+	}
+	return newResponse(vCardHref(doc.ID()), names, vCardProps, func(name xml.Name) (propValue, bool) {
+		switch name {
+		case propResourceType:
+			return propValue{}, true
+		case propETag:
+			return textValue(etag(doc)), true
+		case propContentType:
+			return textValue(vCardMime), true
+		case propContentLength:
+			return textValue(strconv.Itoa(len(getCard()))), true
+		case propLastModified:
+			if t, ok := updatedAt(doc); ok {
+				return textValue(t.UTC().Format(http.TimeFormat)), true
+			}
+		case propAddressData:
+			return textValue(string(getCard())), true
+		}
+		return propValue{}, false
+	})
+}
+
+// privileges returns the privileges of the current user on the address book,
+// from the permissions of the token.
+func (dav *cardDAV) privileges() string {
+	privileges := "<d:privilege><d:read/></d:privilege>"
+	set := dav.perm.Permissions
+	if set.AllowWholeType(permission.POST, consts.Contacts) &&
+		set.AllowWholeType(permission.PUT, consts.Contacts) &&
+		set.AllowWholeType(permission.DELETE, consts.Contacts) {
+		privileges += "<d:privilege><d:write/></d:privilege>" +
+			"<d:privilege><d:write-content/></d:privilege>" +
+			"<d:privilege><d:bind/></d:privilege>" +
+			"<d:privilege><d:unbind/></d:privilege>"
+	}
+	return privileges
+}
+
+func supportedReports() string {
+	var sb strings.Builder
+	for _, report := range []string{"<d:sync-collection/>", "<card:addressbook-multiget/>", "<card:addressbook-query/>"} {
+		sb.WriteString("<d:supported-report><d:report>" + report + "</d:report></d:supported-report>")
+	}
+	return sb.String()
+}
+
+func (dav *cardDAV) serveVCard(id string) error {
+	switch dav.c.Request().Method {
+	case http.MethodGet, http.MethodHead:
+		return dav.getVCard(id)
+	case http.MethodPut:
+		return dav.putVCard(id)
+	case http.MethodDelete:
+		return dav.deleteVCard(id)
+	case "PROPFIND":
+		names, err := propfindNames(dav.c)
+		if err != nil {
+			return err
+		}
+		doc, err := findContact(dav.inst, id)
+		if err != nil {
+			return err
+		}
+		groups, err := contact.ListGroups(dav.inst)
+		if err != nil {
+			return err
+		}
+		return writeMultistatus(dav.c, []*davResponse{dav.vCardResponse(doc, names, groups)}, "")
+	}
+	return echo.NewHTTPError(http.StatusMethodNotAllowed)
+}
+
+func (dav *cardDAV) getVCard(id string) error {
+	doc, err := findContact(dav.inst, id)
+	if err != nil {
+		return err
+	}
+	groups, err := contact.ListGroups(dav.inst)
+	if err != nil {
+		return err
+	}
+	h := dav.c.Response().Header()
+	h.Set(echo.HeaderContentType, vCardMime)
+	h.Set("ETag", etag(doc))
+	if t, ok := updatedAt(doc); ok {
+		h.Set(echo.HeaderLastModified, t.UTC().Format(http.TimeFormat))
+	}
+	card := doc.ToVCard(groups)
+	if dav.c.Request().Method == http.MethodHead {
+		h.Set(echo.HeaderContentLength, strconv.Itoa(len(card)))
+		return dav.c.NoContent(http.StatusOK)
+	}
+	return dav.c.Blob(http.StatusOK, vCardMime, card)
+}
+
+// putVCard creates or updates a contact. As the vCard is converted to JSON,
+// the ETag is not sent in the response: the clients must fetch the vCard to
+// know how it has been stored.
+func (dav *cardDAV) putVCard(id string) error {
+	req := dav.c.Request()
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxVCardSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxVCardSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+	}
+
+	doc, err := contact.Find(dav.inst, id)
+	exists := err == nil
+	if err != nil && !couchdb.IsNotFoundError(err) && !couchdb.IsNoDatabaseError(err) {
+		return err
+	}
+	// A contact in the trash can be created again by the clients
+	restored := exists && isTrashed(doc)
+	if err := checkPreconditions(req, doc, exists && !restored); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	if !exists {
+		if !validResourceName.MatchString(id) {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid resource name")
+		}
+		doc = contact.New()
+		doc.SetID(id)
+		md := metadata.New()
+		md.CreatedAt = now
+		md.UpdatedAt = now
+		doc.M["cozyMetadata"] = toMap(md)
+	} else {
+		if err := middlewares.Allow(dav.c, permission.PUT, doc); err != nil {
+			return err
+		}
+		delete(doc.M, "trashed")
+		touchContact(doc, now)
+	}
+
+	if err := doc.UpdateFromVCard(dav.inst, data); err != nil {
+		if errors.Is(err, contact.ErrInvalidVCard) {
+			return writeError(dav.c, http.StatusBadRequest, condValidAddressData)
+		}
+		return err
+	}
+
+	if exists {
+		if err := couchdb.UpdateDoc(dav.inst, doc); err != nil {
+			return err
+		}
+		if restored {
+			return dav.c.NoContent(http.StatusCreated)
+		}
+		return dav.c.NoContent(http.StatusNoContent)
+	}
+	if err := middlewares.Allow(dav.c, permission.POST, doc); err != nil {
+		return err
+	}
+	if err := couchdb.CreateNamedDocWithDB(dav.inst, doc); err != nil {
+		return err
+	}
+	return dav.c.NoContent(http.StatusCreated)
+}
+
+func (dav *cardDAV) deleteVCard(id string) error {
+	doc, err := findContact(dav.inst, id)
+	if err != nil {
+		return err
+	}
+	if err := checkPreconditions(dav.c.Request(), doc, true); err != nil {
+		return err
+	}
+	if err := middlewares.Allow(dav.c, permission.DELETE, doc); err != nil {
+		return err
+	}
+	// Like the contacts app, the contact is moved to the trash, so that it
+	// can be restored and the other apps see the deletion.
+	doc.M["trashed"] = true
+	touchContact(doc, time.Now().UTC())
+	if err := couchdb.UpdateDoc(dav.inst, doc); err != nil {
+		return err
+	}
+	return dav.c.NoContent(http.StatusNoContent)
+}
+
+// touchContact sets the date of the last update in the metadata of the
+// contact.
+func touchContact(doc *contact.Contact, now time.Time) {
+	meta, ok := doc.Get("cozyMetadata").(map[string]interface{})
+	if !ok {
+		meta = toMap(metadata.New())
+	}
+	meta["updatedAt"] = now
+	doc.M["cozyMetadata"] = meta
+}
+
+// checkPreconditions checks the If-Match and If-None-Match headers, used by
+// the clients to avoid overwriting the changes made by another client.
+func checkPreconditions(req *http.Request, doc couchdb.Doc, exists bool) error {
+	if match := req.Header.Get("If-Match"); match != "" {
+		if !exists || (match != "*" && match != etag(doc)) {
+			return echo.NewHTTPError(http.StatusPreconditionFailed)
+		}
+	}
+	if req.Header.Get("If-None-Match") == "*" && exists {
+		return echo.NewHTTPError(http.StatusPreconditionFailed)
+	}
+	return nil
+}
+
+// findContact returns the contact with the given identifier, or a 404 error
+// if it doesn't exist or is in the trash.
+func findContact(inst *instance.Instance, id string) (*contact.Contact, error) {
+	doc, err := contact.Find(inst, id)
+	if err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, echo.NewHTTPError(http.StatusNotFound)
+		}
+		return nil, err
+	}
+	if isTrashed(doc) {
+		return nil, echo.NewHTTPError(http.StatusNotFound)
+	}
+	return doc, nil
+}
+
+// listContacts returns all the contacts that are not in the trash.
+func listContacts(inst *instance.Instance) ([]*contact.Contact, error) {
+	var contacts []*contact.Contact
+	err := couchdb.ForeachDocs(inst, consts.Contacts, func(_ string, data json.RawMessage) error {
+		doc := contact.New()
+		if err := json.Unmarshal(data, doc); err != nil {
+			return err
+		}
+		if !isTrashed(doc) {
+			contacts = append(contacts, doc)
+		}
+		return nil
+	})
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		return nil, err
+	}
+	return contacts, nil
+}
+
+func isTrashed(doc *contact.Contact) bool {
+	trashed, _ := doc.Get("trashed").(bool)
+	return trashed
+}
+
+func vCardHref(id string) string {
+	return addressBookPath + url.PathEscape(id) + vCardExt
+}
+
+// contactIDFromHref returns the identifier of the contact for an href of the
+// address book, or an empty string.
+func contactIDFromHref(href string) string {
+	if u, err := url.Parse(href); err == nil {
+		href = u.Path
+	}
+	if !strings.HasPrefix(href, addressBookPath) || !strings.HasSuffix(href, vCardExt) {
+		return ""
+	}
+	id, err := url.PathUnescape(strings.TrimSuffix(strings.TrimPrefix(href, addressBookPath), vCardExt))
+	if err != nil || strings.Contains(id, "/") {
+		return ""
+	}
+	return id
+}
+
+func etag(doc couchdb.Doc) string {
+	return `"` + doc.Rev() + `"`
+}
+
+func updatedAt(doc *contact.Contact) (time.Time, bool) {
+	meta, ok := doc.Get("cozyMetadata").(map[string]interface{})
+	if !ok {
+		return time.Time{}, false
+	}
+	str, _ := meta["updatedAt"].(string)
+	t, err := time.Parse(time.RFC3339, str)
+	return t, err == nil
+}
+
+func toMap(v interface{}) map[string]interface{} {
+	var m map[string]interface{}
+	if data, err := json.Marshal(v); err == nil {
+		_ = json.Unmarshal(data, &m)
+	}
+	return m
+}
+
+func badRequest(err error) error {
+	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+}
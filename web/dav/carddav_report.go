@@ -0,0 +1,273 @@
+package dav
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/contentline"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/labstack/echo/v4"
+)
+
+// multigetRequest is the body of an addressbook-multiget REPORT.
+type multigetRequest struct {
+	XMLName xml.Name  `xml:"urn:ietf:params:xml:ns:carddav addressbook-multiget"`
+	Prop    propNames `xml:"DAV: prop"`
+	Hrefs   []string  `xml:"DAV: href"`
+}
+
+// queryRequest is the body of an addressbook-query REPORT. Only the filters
+// on the properties are supported, not the filters on the parameters.
+type queryRequest struct {
+	XMLName xml.Name  `xml:"urn:ietf:params:xml:ns:carddav addressbook-query"`
+	Prop    propNames `xml:"DAV: prop"`
+	Filter  struct {
+		Test        string       `xml:"test,attr"`
+		PropFilters []propFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+	} `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Limit struct {
+		NResults int `xml:"urn:ietf:params:xml:ns:carddav nresults"`
+	} `xml:"urn:ietf:params:xml:ns:carddav limit"`
+}
+
+type propFilter struct {
+	Name         string      `xml:"name,attr"`
+	Test         string      `xml:"test,attr"`
+	IsNotDefined *struct{}   `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []textMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+}
+
+type textMatch struct {
+	Value     string `xml:",chardata"`
+	MatchType string `xml:"match-type,attr"`
+	Negate    string `xml:"negate-condition,attr"`
+}
+
+// report serves the REPORT requests on the address book.
+func (dav *cardDAV) report() error {
+	body, err := readBody(dav.c)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		return badRequest(errInvalidXML)
+	}
+	root, err := rootName(body)
+	if err != nil {
+		return badRequest(err)
+	}
+	switch root {
+	case reportSyncCollection:
+		var req syncCollectionRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			return badRequest(errInvalidXML)
+		}
+		return dav.syncCollection(&req)
+	case reportMultiget:
+		var req multigetRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			return badRequest(errInvalidXML)
+		}
+		return dav.multiget(&req)
+	case reportQuery:
+		var req queryRequest
+		if err := xml.Unmarshal(body, &req); err != nil {
+			return badRequest(errInvalidXML)
+		}
+		return dav.query(&req)
+	}
+	return echo.NewHTTPError(http.StatusForbidden, "Unsupported report")
+}
+
+// syncCollection sends the contacts that have changed since the sync token.
+// Without a sync token, all the contacts are sent.
+func (dav *cardDAV) syncCollection(req *syncCollectionRequest) error {
+	groups, err := contact.ListGroups(dav.inst)
+	if err != nil {
+		return err
+	}
+
+	if req.SyncToken == "" {
+		// The token is computed before listing the contacts, so that the
+		// changes made in the meantime will be sent on the next sync.
+		token, err := currentSyncToken(dav.inst, consts.Contacts)
+		if err != nil {
+			return err
+		}
+		contacts, err := listContacts(dav.inst)
+		if err != nil {
+			return err
+		}
+		responses := make([]*davResponse, 0, len(contacts))
+		for _, doc := range contacts {
+			responses = append(responses, dav.vCardResponse(doc, req.Prop, groups))
+		}
+		return writeMultistatus(dav.c, responses, token)
+	}
+
+	res, err := changesSince(dav.inst, consts.Contacts, req.SyncToken, req.Limit.NResults)
+	if err != nil {
+		if errors.Is(err, errInvalidSyncToken) {
+			return writeError(dav.c, http.StatusForbidden, condValidSyncToken)
+		}
+		return err
+	}
+	responses := make([]*davResponse, 0, len(res.Results)+1)
+	for _, change := range res.Results {
+		doc := &contact.Contact{JSONDoc: change.Doc}
+		if change.Deleted || isTrashed(doc) {
+			responses = append(responses, statusResponse(vCardHref(change.DocID), http.StatusNotFound))
+			continue
+		}
+		responses = append(responses, dav.vCardResponse(doc, req.Prop, groups))
+	}
+	if res.Pending > 0 {
+		responses = append(responses, statusResponse(addressBookPath, http.StatusInsufficientStorage))
+	}
+	return writeMultistatus(dav.c, responses, syncTokenPrefix+res.LastSeq)
+}
+
+// multiget sends the contacts for the given hrefs.
+func (dav *cardDAV) multiget(req *multigetRequest) error {
+	groups, err := contact.ListGroups(dav.inst)
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, href := range req.Hrefs {
+		if id := contactIDFromHref(href); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	found := make(map[string]*contact.Contact, len(ids))
+	if len(ids) > 0 {
+		var docs []*contact.Contact
+		err := couchdb.GetAllDocs(dav.inst, consts.Contacts, &couchdb.AllDocsRequest{Keys: ids}, &docs)
+		if err != nil && !couchdb.IsNoDatabaseError(err) {
+			return err
+		}
+		for _, doc := range docs {
+			if doc != nil && !isTrashed(doc) {
+				found[doc.ID()] = doc
+			}
+		}
+	}
+
+	responses := make([]*davResponse, 0, len(req.Hrefs))
+	for _, href := range req.Hrefs {
+		doc, ok := found[contactIDFromHref(href)]
+		if !ok {
+			responses = append(responses, statusResponse(href, http.StatusNotFound))
+			continue
+		}
+		responses = append(responses, dav.vCardResponse(doc, req.Prop, groups))
+	}
+	return writeMultistatus(dav.c, responses, "")
+}
+
+// query sends the contacts that match the filter.
+func (dav *cardDAV) query(req *queryRequest) error {
+	groups, err := contact.ListGroups(dav.inst)
+	if err != nil {
+		return err
+	}
+	contacts, err := listContacts(dav.inst)
+	if err != nil {
+		return err
+	}
+	var responses []*davResponse
+	for _, doc := range contacts {
+		if req.Limit.NResults > 0 && len(responses) >= req.Limit.NResults {
+			responses = append(responses, statusResponse(addressBookPath, http.StatusInsufficientStorage))
+			break
+		}
+		props, err := contentline.Parse(doc.ToVCard(groups))
+		if err != nil {
+			continue
+		}
+		if matchFilters(props, req.Filter.Test, req.Filter.PropFilters) {
+			responses = append(responses, dav.vCardResponse(doc, req.Prop, groups))
+		}
+	}
+	return writeMultistatus(dav.c, responses, "")
+}
+
+// matchFilters returns true if the vCard properties match the filters: all
+// of them when test is allof, or at least one of them when test is anyof.
+func matchFilters(props []*contentline.Property, test string, filters []propFilter) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	allof := test == "allof"
+	for _, filter := range filters {
+		ok := filter.match(props)
+		if ok && !allof {
+			return true
+		}
+		if !ok && allof {
+			return false
+		}
+	}
+	return allof
+}
+
+func (f *propFilter) match(props []*contentline.Property) bool {
+	var values []string
+	for _, prop := range props {
+		if strings.EqualFold(prop.Name, f.Name) {
+			values = append(values, contentline.UnescapeText(prop.Value))
+		}
+	}
+	if f.IsNotDefined != nil {
+		return len(values) == 0
+	}
+	if len(values) == 0 {
+		return false
+	}
+	if len(f.TextMatches) == 0 {
+		return true
+	}
+	allof := f.Test == "allof"
+	for _, tm := range f.TextMatches {
+		ok := false
+		for _, value := range values {
+			if tm.match(value) {
+				ok = true
+				break
+			}
+		}
+		if ok && !allof {
+			return true
+		}
+		if !ok && allof {
+			return false
+		}
+	}
+	return allof
+}
+
+// match compares the value with the text, with the i;unicode-casemap
+// collation, approximated by a case-insensitive comparison.
+func (tm *textMatch) match(value string) bool {
+	value = strings.ToLower(value)
+	text := strings.ToLower(strings.TrimSpace(tm.Value))
+	var ok bool
+	switch tm.MatchType {
+	case "equals":
+		ok = value == text
+	case "starts-with":
+		ok = strings.HasPrefix(value, text)
+	case "ends-with":
+		ok = strings.HasSuffix(value, text)
+	default:
+		ok = strings.Contains(value, text)
+	}
+	if tm.Negate == "yes" {
+		return !ok
+	}
+	return ok
+}
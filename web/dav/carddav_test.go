@@ -0,0 +1,218 @@
+package dav
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/contact"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCardDAV(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	testInstance := setup.GetTestInstance()
+	_, token := setup.GetTestClient(consts.Contacts)
+	_, filesToken := setup.GetTestClient(consts.Files)
+	ts := setup.GetTestServer("/dav", Routes)
+	t.Cleanup(ts.Close)
+
+	card := "BEGIN:VCARD\r\n" +
+		"VERSION:4.0\r\n" +
+		"UID:urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1\r\n" +
+		"FN:Alice Martin\r\n" +
+		"N:Martin;Alice;;;\r\n" +
+		"EMAIL;TYPE=work:alice@example.com\r\n" +
+		"CATEGORIES:Friends\r\n" +
+		"X-PHONETIC-FIRST-NAME:Alisse\r\n" +
+		"END:VCARD\r\n"
+	var syncToken string
+	tokenRegexp := regexp.MustCompile(`<d:sync-token>([^<]+)</d:sync-token>`)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.Request("PROPFIND", "/dav/contacts/").
+			Expect().Status(401).
+			Header("WWW-Authenticate").Contains("Basic")
+
+		e.Request("PROPFIND", "/dav/contacts/").
+			WithBasicAuth("", filesToken).
+			Expect().Status(403)
+	})
+
+	t.Run("Discovery", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.Request("PROPFIND", "/dav/principal/").
+			WithBasicAuth("", token).
+			WithHeader("Depth", "0").
+			WithBytes([]byte(`<d:propfind xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav"><d:prop><card:addressbook-home-set/></d:prop></d:propfind>`)).
+			Expect().Status(207).
+			Body().Contains("<card:addressbook-home-set><d:href>/dav/contacts/</d:href></card:addressbook-home-set>")
+
+		body := e.Request("PROPFIND", "/dav/contacts/").
+			WithBasicAuth("", token).
+			WithHeader("Depth", "1").
+			Expect().Status(207).
+			Body()
+		body.Contains("<d:href>/dav/contacts/default/</d:href>")
+		body.Contains("<card:addressbook/>")
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.Request("REPORT", "/dav/contacts/default/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<d:sync-collection xmlns:d="DAV:"><d:sync-token/><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`)).
+			Expect().Status(207).
+			Body().Contains("<d:sync-token>")
+
+		e.PUT("/dav/contacts/default/alice.vcf").
+			WithBasicAuth("", token).
+			WithHeader("If-None-Match", "*").
+			WithHeader("Content-Type", "text/vcard").
+			WithBytes([]byte(card)).
+			Expect().Status(201)
+
+		e.PUT("/dav/contacts/default/alice.vcf").
+			WithBasicAuth("", token).
+			WithHeader("If-None-Match", "*").
+			WithBytes([]byte(card)).
+			Expect().Status(412)
+
+		doc, err := contact.Find(testInstance, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, "Alice Martin", doc.M["fullname"])
+		require.Len(t, doc.GroupIDs(), 1)
+
+		res := e.GET("/dav/contacts/default/alice.vcf").
+			WithBasicAuth("", token).
+			Expect().Status(200)
+		res.Header("ETag").IsEqual(`"` + doc.Rev() + `"`)
+		body := res.Body()
+		body.Contains("FN:Alice Martin\r\n")
+		body.Contains("UID:urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1\r\n")
+		body.Contains("CATEGORIES:Friends\r\n")
+		body.Contains("X-PHONETIC-FIRST-NAME:Alisse\r\n")
+	})
+
+	t.Run("SyncCollection", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		body := e.Request("REPORT", "/dav/contacts/default/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<d:sync-collection xmlns:d="DAV:"><d:sync-token/><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`)).
+			Expect().Status(207).
+			Body()
+		body.Contains("<d:href>/dav/contacts/default/alice.vcf</d:href>")
+		matches := tokenRegexp.FindStringSubmatch(body.Raw())
+		require.Len(t, matches, 2)
+		syncToken = matches[1]
+
+		doc, err := contact.Find(testInstance, "alice")
+		require.NoError(t, err)
+		e.PUT("/dav/contacts/default/alice.vcf").
+			WithBasicAuth("", token).
+			WithHeader("If-Match", `"`+doc.Rev()+`"`).
+			WithBytes([]byte(card)).
+			Expect().Status(http.StatusNoContent)
+
+		body = e.Request("REPORT", "/dav/contacts/default/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<d:sync-collection xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav"><d:sync-token>` + syncToken + `</d:sync-token><d:sync-level>1</d:sync-level><d:prop><d:getetag/><card:address-data/></d:prop></d:sync-collection>`)).
+			Expect().Status(207).
+			Body()
+		body.Contains("<d:href>/dav/contacts/default/alice.vcf</d:href>")
+		body.Contains("FN:Alice Martin")
+		matches = tokenRegexp.FindStringSubmatch(body.Raw())
+		require.Len(t, matches, 2)
+		syncToken = matches[1]
+
+		e.Request("REPORT", "/dav/contacts/default/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<d:sync-collection xmlns:d="DAV:"><d:sync-token>http://example.org/invalid</d:sync-token><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`)).
+			Expect().Status(403).
+			Body().Contains("valid-sync-token")
+	})
+
+	t.Run("MultigetAndQuery", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		body := e.Request("REPORT", "/dav/contacts/default/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<card:addressbook-multiget xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav"><d:prop><card:address-data/></d:prop><d:href>/dav/contacts/default/alice.vcf</d:href><d:href>/dav/contacts/default/nobody.vcf</d:href></card:addressbook-multiget>`)).
+			Expect().Status(207).
+			Body()
+		body.Contains("FN:Alice Martin")
+		body.Contains("<d:href>/dav/contacts/default/nobody.vcf</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+
+		e.Request("REPORT", "/dav/contacts/default/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<card:addressbook-query xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav"><d:prop><d:getetag/></d:prop><card:filter><card:prop-filter name="EMAIL"><card:text-match match-type="contains">ALICE@</card:text-match></card:prop-filter></card:filter></card:addressbook-query>`)).
+			Expect().Status(207).
+			Body().Contains("alice.vcf")
+
+		e.Request("REPORT", "/dav/contacts/default/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<card:addressbook-query xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav"><d:prop><d:getetag/></d:prop><card:filter><card:prop-filter name="FN"><card:text-match match-type="equals">Bob</card:text-match></card:prop-filter></card:filter></card:addressbook-query>`)).
+			Expect().Status(207).
+			Body().NotContains("alice.vcf")
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.DELETE("/dav/contacts/default/alice.vcf").
+			WithBasicAuth("", token).
+			WithHeader("If-Match", `"1-nope"`).
+			Expect().Status(412)
+
+		e.DELETE("/dav/contacts/default/alice.vcf").
+			WithBasicAuth("", token).
+			Expect().Status(http.StatusNoContent)
+
+		e.GET("/dav/contacts/default/alice.vcf").
+			WithBasicAuth("", token).
+			Expect().Status(404)
+
+		// The contact is moved to the trash, not destroyed
+		doc, err := contact.Find(testInstance, "alice")
+		require.NoError(t, err)
+		assert.Equal(t, true, doc.Get("trashed"))
+		meta, _ := doc.Get("cozyMetadata").(map[string]interface{})
+		assert.NotEmpty(t, meta["updatedAt"])
+
+		e.DELETE("/dav/contacts/default/alice.vcf").
+			WithBasicAuth("", token).
+			Expect().Status(404)
+
+		e.Request("REPORT", "/dav/contacts/default/").
+			WithBasicAuth("", token).
+			WithBytes([]byte(`<d:sync-collection xmlns:d="DAV:"><d:sync-token>` + syncToken + `</d:sync-token><d:sync-level>1</d:sync-level><d:prop><d:getetag/></d:prop></d:sync-collection>`)).
+			Expect().Status(207).
+			Body().Contains("<d:href>/dav/contacts/default/alice.vcf</d:href><d:status>HTTP/1.1 404 Not Found</d:status>")
+
+		// And it can be created again
+		e.PUT("/dav/contacts/default/alice.vcf").
+			WithBasicAuth("", token).
+			WithHeader("If-None-Match", "*").
+			WithHeader("Content-Type", "text/vcard").
+			WithBytes([]byte(card)).
+			Expect().Status(http.StatusCreated)
+		doc, err = contact.Find(testInstance, "alice")
+		require.NoError(t, err)
+		assert.Nil(t, doc.Get("trashed"))
+	})
+}
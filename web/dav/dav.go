@@ -0,0 +1,142 @@
+// Package dav exposes the VFS of an instance as a WebDAV server, so that the
+// files can be mounted on a desktop with Finder, Nautilus, davfs2, etc. It
+// also exposes the contacts as a CardDAV server, and the events and todos as a
+// CalDAV server, to synchronize them with the address books and calendars of
+// the phones.
+package dav
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/webdav"
+)
+
+// filesPrefix is the URL prefix of the WebDAV resources for the files.
+const filesPrefix = "/dav/files"
+
+// methods is the list of HTTP methods that are handled by the WebDAV server.
+var methods = []string{
+	http.MethodOptions,
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPut,
+	http.MethodDelete,
+	"PROPFIND",
+	"PROPPATCH",
+	"MKCOL",
+	"COPY",
+	"MOVE",
+	"LOCK",
+	"UNLOCK",
+}
+
+// locksIdleTimeout is the duration after which the lock system of an
+// instance is forgotten if it has not been used.
+const locksIdleTimeout = 1 * time.Hour
+
+type instanceLocks struct {
+	ls       webdav.LockSystem
+	lastUsed time.Time
+}
+
+var (
+	locksMu        sync.Mutex
+	locks          = make(map[string]*instanceLocks)
+	locksLastSweep time.Time
+)
+
+// lockSystem returns the lock system for the given instance. The locks are
+// only kept in memory: they are used by the desktop clients for a short
+// period of time, and losing them on a restart of the stack is acceptable.
+// For the same reason, the lock systems of the instances that have not used
+// WebDAV for some time are removed.
+func lockSystem(inst *instance.Instance) webdav.LockSystem {
+	locksMu.Lock()
+	defer locksMu.Unlock()
+	now := time.Now()
+	evictLocks(now)
+	l, ok := locks[inst.Domain]
+	if !ok {
+		l = &instanceLocks{ls: webdav.NewMemLS()}
+		locks[inst.Domain] = l
+	}
+	l.lastUsed = now
+	return l.ls
+}
+
+// evictLocks removes the idle lock systems. It runs at most once per
+// locksIdleTimeout, and must be called with locksMu held.
+func evictLocks(now time.Time) {
+	if now.Sub(locksLastSweep) < locksIdleTimeout {
+		return
+	}
+	locksLastSweep = now
+	for domain, l := range locks {
+		if now.Sub(l.lastUsed) >= locksIdleTimeout {
+			delete(locks, domain)
+		}
+	}
+}
+
+// checkFilesPermission ensures that the request has a token (OAuth access
+// token or app token, possibly given as the password of a Basic auth) with
+// some permissions on io.cozy.files. The codes of the sharings by link and
+// of the previews of a sharing are refused: they are made to show some files
+// in a browser, not to mount the shared tree on a desktop.
+func checkFilesPermission(c echo.Context) error {
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="Cozy"`)
+		return echo.NewHTTPError(http.StatusUnauthorized)
+	}
+	if pdoc.Type == permission.TypeShareByLink || pdoc.Type == permission.TypeSharePreview {
+		return middlewares.ErrForbidden
+	}
+	for _, rule := range pdoc.Permissions {
+		if permission.MatchType(rule, consts.Files) {
+			return nil
+		}
+	}
+	return middlewares.ErrForbidden
+}
+
+func filesHandler(c echo.Context) error {
+	if err := checkFilesPermission(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	h := &webdav.Handler{
+		Prefix:     filesPrefix,
+		FileSystem: &fileSystem{c: c, inst: inst},
+		LockSystem: lockSystem(inst),
+		Logger: func(req *http.Request, err error) {
+			if err != nil {
+				inst.Logger().WithNamespace("dav").
+					Infof("%s %s: %s", req.Method, req.URL.Path, err)
+			}
+		},
+	}
+	h.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// Routes sets the routing for the WebDAV server.
+func Routes(router *echo.Group) {
+	router.Match(methods, "/files", filesHandler)
+	router.Match(methods, "/files/*", filesHandler)
+
+	principalMethods := []string{http.MethodOptions, "PROPFIND"}
+	router.Match(principalMethods, "/principal", principalHandler)
+	router.Match(principalMethods, "/principal/", principalHandler)
+	router.Match(cardMethods, "/contacts", contactsHandler)
+	router.Match(cardMethods, "/contacts/*", contactsHandler)
+	router.Match(calMethods, "/calendars", calendarsHandler)
+	router.Match(calMethods, "/calendars/*", calendarsHandler)
+}
@@ -0,0 +1,201 @@
+package dav
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDav(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+
+	config.GetConfig().Fs.URL = &url.URL{
+		Scheme: "file",
+		Host:   "localhost",
+		Path:   t.TempDir(),
+	}
+
+	testInstance := setup.GetTestInstance()
+	_, token := setup.GetTestClient(consts.Files)
+	_, contactsToken := setup.GetTestClient(consts.Contacts)
+	ts := setup.GetTestServer("/dav", Routes)
+	t.Cleanup(ts.Close)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.Request("PROPFIND", "/dav/files/").
+			Expect().Status(401).
+			Header("WWW-Authenticate").Contains("Basic")
+
+		e.Request("PROPFIND", "/dav/files/").
+			WithHeader("Authorization", "Bearer "+contactsToken).
+			Expect().Status(403)
+
+		// The code of a sharing by link can't be used to mount the files
+		code := newShareLinkCode(t, testInstance, false)
+		e.Request("PROPFIND", "/dav/files/").
+			WithBasicAuth("", code).
+			Expect().Status(403)
+
+		// Nor can a view-only link be used to download the files
+		code = newShareLinkCode(t, testInstance, true)
+		e.GET("/dav/files/Photos/hello.txt").
+			WithBasicAuth("", code).
+			Expect().Status(403)
+		e.GET("/dav/files/Photos/hello.txt").
+			WithHeader("Authorization", "Bearer "+code).
+			Expect().Status(403)
+	})
+
+	t.Run("Mkcol", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.Request("MKCOL", "/dav/files/Photos").
+			WithBasicAuth("", token).
+			Expect().Status(201)
+
+		dir, err := testInstance.VFS().DirByPath("/Photos")
+		require.NoError(t, err)
+		assert.Equal(t, "Photos", dir.DocName)
+
+		e.Request("MKCOL", "/dav/files/Nope/Photos").
+			WithBasicAuth("", token).
+			Expect().Status(409)
+	})
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.PUT("/dav/files/Photos/hello.txt").
+			WithBasicAuth("", token).
+			WithBytes([]byte("Hello, WebDAV!")).
+			Expect().Status(201)
+
+		e.GET("/dav/files/Photos/hello.txt").
+			WithBasicAuth("", token).
+			Expect().Status(200).
+			Body().IsEqual("Hello, WebDAV!")
+
+		e.PUT("/dav/files/Photos/hello.txt").
+			WithBasicAuth("", token).
+			WithBytes([]byte("Hello again")).
+			Expect().Status(201)
+
+		file, err := testInstance.VFS().FileByPath("/Photos/hello.txt")
+		require.NoError(t, err)
+		assert.EqualValues(t, 11, file.ByteSize)
+		assert.Equal(t, "text/plain", file.Mime)
+	})
+
+	t.Run("Propfind", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		body := e.Request("PROPFIND", "/dav/files/Photos/").
+			WithBasicAuth("", token).
+			WithHeader("Depth", "1").
+			Expect().Status(207).
+			Body()
+		body.Contains("/dav/files/Photos/")
+		body.Contains("/dav/files/Photos/hello.txt")
+
+		// The trash is not listed
+		e.Request("PROPFIND", "/dav/files/").
+			WithBasicAuth("", token).
+			WithHeader("Depth", "1").
+			Expect().Status(207).
+			Body().NotContains(".cozy_trash")
+	})
+
+	t.Run("CopyAndMove", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.Request("COPY", "/dav/files/Photos/hello.txt").
+			WithBasicAuth("", token).
+			WithHeader("Destination", ts.URL+"/dav/files/copy.txt").
+			Expect().Status(201)
+
+		e.Request("MOVE", "/dav/files/copy.txt").
+			WithBasicAuth("", token).
+			WithHeader("Destination", ts.URL+"/dav/files/Photos/moved.txt").
+			Expect().Status(201)
+
+		_, err := testInstance.VFS().FileByPath("/copy.txt")
+		assert.Error(t, err)
+		moved, err := testInstance.VFS().FileByPath("/Photos/moved.txt")
+		require.NoError(t, err)
+		assert.EqualValues(t, 11, moved.ByteSize)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.DELETE("/dav/files/Photos/moved.txt").
+			WithBasicAuth("", token).
+			Expect().Status(http.StatusNoContent)
+
+		e.GET("/dav/files/Photos/moved.txt").
+			WithBasicAuth("", token).
+			Expect().Status(404)
+
+		trashed, err := testInstance.VFS().FileByPath(vfs.TrashDirName + "/moved.txt")
+		require.NoError(t, err)
+		assert.True(t, trashed.Trashed)
+	})
+}
+
+func TestLockSystemEviction(t *testing.T) {
+	alice := &instance.Instance{Domain: "alice.cozy.localhost"}
+	bob := &instance.Instance{Domain: "bob.cozy.localhost"}
+
+	ls := lockSystem(alice)
+	assert.Same(t, ls, lockSystem(alice))
+	lockSystem(bob)
+
+	// The lock system of alice has not been used for a long time
+	locksMu.Lock()
+	locks[alice.Domain].lastUsed = time.Now().Add(-2 * locksIdleTimeout)
+	locksLastSweep = time.Time{}
+	locksMu.Unlock()
+
+	lockSystem(bob)
+	locksMu.Lock()
+	_, ok := locks[alice.Domain]
+	locksMu.Unlock()
+	assert.False(t, ok)
+	assert.NotSame(t, ls, lockSystem(alice))
+}
+
+// newShareLinkCode creates a sharing by link on the files, and returns its
+// code.
+func newShareLinkCode(t *testing.T, inst *instance.Instance, viewOnly bool) string {
+	code, err := inst.MakeJWT(consts.ShareAudience, "email", consts.Files, "", time.Now())
+	require.NoError(t, err)
+	rules := permission.Set{
+		permission.Rule{
+			Type:  consts.Files,
+			Verbs: permission.Verbs(permission.GET),
+		},
+	}
+	parent := &permission.Permission{Type: permission.TypeWebapp, Permissions: rules}
+	subdoc := permission.Permission{Permissions: rules, ViewOnly: viewOnly}
+	_, err = permission.CreateShareSet(inst, parent, "", map[string]string{"email": code}, nil, subdoc, nil, false)
+	require.NoError(t, err)
+	return code
+}
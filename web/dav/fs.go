@@ -0,0 +1,330 @@
+package dav
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/web/files"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/webdav"
+)
+
+// fileSystem implements the webdav.FileSystem interface on top of the VFS of
+// an instance. It is created for each request, as the permissions are
+// checked with the token of the request for each operation.
+type fileSystem struct {
+	c    echo.Context
+	inst *instance.Instance
+}
+
+func (fs *fileSystem) vfs() vfs.VFS {
+	return fs.inst.VFS()
+}
+
+func (fs *fileSystem) checkPerm(v permission.Verb, d *vfs.DirDoc, f *vfs.FileDoc) error {
+	if d != nil {
+		return middlewares.AllowVFS(fs.c, v, d)
+	}
+	return middlewares.AllowVFS(fs.c, v, f)
+}
+
+// Stat is part of the webdav.FileSystem interface.
+func (fs *fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	dir, file, err := fs.vfs().DirOrFileByPath(path.Clean(name))
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.checkPerm(permission.GET, dir, file); err != nil {
+		return nil, err
+	}
+	if dir != nil {
+		return dir, nil
+	}
+	return &fileInfo{file}, nil
+}
+
+// Mkdir is part of the webdav.FileSystem interface.
+func (fs *fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = path.Clean(name)
+	if name == "/" {
+		return os.ErrExist
+	}
+	parent, err := fs.vfs().DirByPath(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	doc, err := vfs.NewDirDocWithParent(path.Base(name), parent, nil)
+	if err != nil {
+		return err
+	}
+	doc.CozyMetadata, _ = files.CozyMetadataFromClaims(fs.c, false)
+	if err := fs.checkPerm(permission.POST, doc, nil); err != nil {
+		return err
+	}
+	return fs.vfs().CreateDir(doc)
+}
+
+// OpenFile is part of the webdav.FileSystem interface.
+func (fs *fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = path.Clean(name)
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+		return fs.create(name, flag)
+	}
+
+	dir, file, err := fs.vfs().DirOrFileByPath(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.checkPerm(permission.GET, dir, file); err != nil {
+		return nil, err
+	}
+	if dir != nil {
+		return &dirHandle{fs: fs, doc: dir}, nil
+	}
+	if err := files.CheckAntivirusAction(fs.inst, file, files.ActionDownload); err != nil {
+		return nil, err
+	}
+	f, err := fs.vfs().OpenFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return &fileHandle{File: f, doc: file}, nil
+}
+
+// create opens a file for writing. The content of the file is replaced if
+// the file already exists, and a new version is kept like for an upload via
+// the /files API.
+func (fs *fileSystem) create(name string, flag int) (webdav.File, error) {
+	var newdoc *vfs.FileDoc
+	olddoc, err := fs.vfs().FileByPath(name)
+	if err == nil {
+		if flag&os.O_EXCL != 0 {
+			return nil, os.ErrExist
+		}
+		mime, class := vfs.ExtractMimeAndClassFromFilename(olddoc.DocName)
+		newdoc, err = vfs.NewFileDoc(olddoc.DocName, olddoc.DirID, -1, nil, mime, class,
+			time.Now(), olddoc.Executable, false, olddoc.Encrypted, olddoc.Tags)
+		if err != nil {
+			return nil, err
+		}
+		newdoc.ReferencedBy = olddoc.ReferencedBy
+		if olddoc.CozyMetadata != nil {
+			newdoc.CozyMetadata = olddoc.CozyMetadata.Clone()
+		}
+		files.UpdateFileCozyMetadata(fs.c, newdoc, true)
+		if err := fs.checkPerm(permission.PUT, nil, olddoc); err != nil {
+			return nil, err
+		}
+		newdoc.SetID(olddoc.ID())
+		if err := fs.checkPerm(permission.PUT, nil, newdoc); err != nil {
+			return nil, err
+		}
+	} else if os.IsNotExist(err) {
+		parent, err := fs.vfs().DirByPath(path.Dir(name))
+		if err != nil {
+			return nil, err
+		}
+		filename := path.Base(name)
+		mime, class := vfs.ExtractMimeAndClassFromFilename(filename)
+		newdoc, err = vfs.NewFileDoc(filename, parent.ID(), -1, nil, mime, class,
+			time.Now(), false, false, false, nil)
+		if err != nil {
+			return nil, err
+		}
+		newdoc.CozyMetadata, _ = files.CozyMetadataFromClaims(fs.c, true)
+		if err := fs.checkPerm(permission.POST, nil, newdoc); err != nil {
+			return nil, err
+		}
+	} else {
+		return nil, err
+	}
+
+	// For a PUT, the Content-Length can be used to check the disk quota
+	// before writing the content.
+	if req := fs.c.Request(); req.Method == http.MethodPut && req.ContentLength >= 0 {
+		newdoc.ByteSize = req.ContentLength
+	}
+
+	f, err := fs.vfs().CreateFile(newdoc, olddoc)
+	if err != nil {
+		return nil, err
+	}
+	return &fileHandle{File: f, doc: newdoc}, nil
+}
+
+// RemoveAll is part of the webdav.FileSystem interface. The files and
+// directories are moved to the trash, like in the drive application.
+func (fs *fileSystem) RemoveAll(ctx context.Context, name string) error {
+	dir, file, err := fs.vfs().DirOrFileByPath(path.Clean(name))
+	if err != nil {
+		return err
+	}
+	if dir != nil && dir.ID() == consts.RootDirID {
+		return os.ErrPermission
+	}
+	if err := fs.checkPerm(permission.PATCH, dir, file); err != nil {
+		return err
+	}
+	if dir != nil {
+		files.UpdateDirTrashCozyMetadata(fs.c, dir)
+		_, err = vfs.TrashDir(fs.vfs(), dir)
+		return err
+	}
+	if err := files.CheckAntivirusAction(fs.inst, file, files.ActionDelete); err != nil {
+		return err
+	}
+	files.UpdateFileTrashCozyMetadata(fs.c, file)
+	_, err = vfs.TrashFile(fs.vfs(), file)
+	return err
+}
+
+// Rename is part of the webdav.FileSystem interface.
+func (fs *fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	dir, file, err := fs.vfs().DirOrFileByPath(path.Clean(oldName))
+	if err != nil {
+		return err
+	}
+	newName = path.Clean(newName)
+	parent, err := fs.vfs().DirByPath(path.Dir(newName))
+	if err != nil {
+		return err
+	}
+	if err := fs.checkPerm(permission.PATCH, dir, file); err != nil {
+		return err
+	}
+	if err := fs.checkPerm(permission.POST, parent, nil); err != nil {
+		return err
+	}
+
+	filename := path.Base(newName)
+	dirID := parent.ID()
+	patch := &vfs.DocPatch{Name: &filename, DirID: &dirID}
+	if dir != nil {
+		files.UpdateDirCozyMetadata(fs.c, dir)
+		_, err = vfs.ModifyDirMetadata(fs.vfs(), dir, patch)
+		return err
+	}
+	files.UpdateFileCozyMetadata(fs.c, file, false)
+	_, err = vfs.ModifyFileMetadata(fs.vfs(), file, patch)
+	return err
+}
+
+// fileInfo adds the ETag and content type of the VFS to the os.FileInfo
+// interface implemented by FileDoc.
+type fileInfo struct {
+	*vfs.FileDoc
+}
+
+// ContentType is part of the webdav.ContentTyper interface.
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.Mime == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.Mime, nil
+}
+
+// ETag is part of the webdav.ETager interface.
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if len(fi.MD5Sum) == 0 {
+		return "", webdav.ErrNotImplemented
+	}
+	return `"` + hex.EncodeToString(fi.MD5Sum) + `"`, nil
+}
+
+// fileHandle is a webdav.File for reading or writing the content of a file.
+type fileHandle struct {
+	vfs.File
+	doc *vfs.FileDoc
+}
+
+func (f *fileHandle) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (f *fileHandle) Stat() (os.FileInfo, error) {
+	return &fileInfo{f.doc}, nil
+}
+
+// dirHandle is a webdav.File for listing the children of a directory.
+type dirHandle struct {
+	fs       *fileSystem
+	doc      *vfs.DirDoc
+	children []os.FileInfo
+	loaded   bool
+}
+
+func (d *dirHandle) Read(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *dirHandle) Write(p []byte) (int, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *dirHandle) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *dirHandle) Close() error {
+	return nil
+}
+
+func (d *dirHandle) Stat() (os.FileInfo, error) {
+	return d.doc, nil
+}
+
+func (d *dirHandle) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.loaded {
+		if err := d.load(); err != nil {
+			return nil, err
+		}
+	}
+	if count <= 0 {
+		children := d.children
+		d.children = nil
+		return children, nil
+	}
+	if len(d.children) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.children) {
+		count = len(d.children)
+	}
+	children := d.children[:count]
+	d.children = d.children[count:]
+	return children, nil
+}
+
+// load fetches the children of the directory. The trash is not listed, as
+// the drive application does not show it in the tree.
+func (d *dirHandle) load() error {
+	iter := d.fs.vfs().DirIterator(d.doc, nil)
+	for {
+		dir, file, err := iter.Next()
+		if err == vfs.ErrIteratorDone {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if dir != nil {
+			if dir.ID() != consts.TrashDirID {
+				d.children = append(d.children, dir)
+			}
+		} else {
+			d.children = append(d.children, &fileInfo{file})
+		}
+	}
+	d.loaded = true
+	return nil
+}
@@ -0,0 +1,85 @@
+package dav
+
+import (
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+// syncTokenPrefix is used to make an URI from the sequence number of the
+// CouchDB changes feed, as the sync tokens must be URIs.
+const syncTokenPrefix = "https://cozy.io/ns/sync/"
+
+// maxSyncResults is the maximal number of changes sent in the response to a
+// sync-collection REPORT. The client is told to send another request for
+// the next changes.
+const maxSyncResults = 500
+
+var errInvalidSyncToken = errors.New("Invalid sync token")
+
+// syncCollectionRequest is the body of a sync-collection REPORT (RFC 6578).
+type syncCollectionRequest struct {
+	XMLName   xml.Name `xml:"DAV: sync-collection"`
+	SyncToken string   `xml:"DAV: sync-token"`
+	SyncLevel string   `xml:"DAV: sync-level"`
+	Limit     struct {
+		NResults int `xml:"DAV: nresults"`
+	} `xml:"DAV: limit"`
+	Prop propNames `xml:"DAV: prop"`
+}
+
+// currentSyncToken returns a sync token for the current state of the
+// documents of the given doctype.
+func currentSyncToken(inst *instance.Instance, doctype string) (string, error) {
+	res, err := couchdb.GetChanges(inst, &couchdb.ChangesRequest{
+		DocType: doctype,
+		Since:   "now",
+		Limit:   1,
+	})
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return syncTokenPrefix + "0", nil
+		}
+		return "", err
+	}
+	return syncTokenPrefix + res.LastSeq, nil
+}
+
+// changesSince returns the changes of the documents of the given doctype
+// since the state of the sync token, with the documents. The design docs are
+// filtered out.
+func changesSince(inst *instance.Instance, doctype, token string, limit int) (*couchdb.ChangesResponse, error) {
+	if !strings.HasPrefix(token, syncTokenPrefix) {
+		return nil, errInvalidSyncToken
+	}
+	if limit <= 0 || limit > maxSyncResults {
+		limit = maxSyncResults
+	}
+	res, err := couchdb.GetChanges(inst, &couchdb.ChangesRequest{
+		DocType:     doctype,
+		Since:       strings.TrimPrefix(token, syncTokenPrefix),
+		IncludeDocs: true,
+		Limit:       limit,
+	})
+	if err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return &couchdb.ChangesResponse{LastSeq: strings.TrimPrefix(token, syncTokenPrefix)}, nil
+		}
+		if couchErr, ok := couchdb.IsCouchError(err); ok && couchErr.StatusCode == http.StatusBadRequest {
+			return nil, errInvalidSyncToken
+		}
+		return nil, err
+	}
+	results := res.Results[:0]
+	for _, change := range res.Results {
+		if !strings.HasPrefix(change.DocID, "_design") {
+			results = append(results, change)
+		}
+	}
+	res.Results = results
+	return res, nil
+}
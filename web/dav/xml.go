@@ -0,0 +1,264 @@
+package dav
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// The XML namespaces used by the CardDAV and CalDAV servers.
+const (
+	nsDAV     = "DAV:"
+	nsCardDAV = "urn:ietf:params:xml:ns:carddav"
+	nsCalDAV  = "urn:ietf:params:xml:ns:caldav"
+	nsCS      = "http://calendarserver.org/ns/"
+)
+
+// prefixes are the prefixes used in the responses for the known namespaces.
+var prefixes = map[string]string{
+	nsDAV:     "d",
+	nsCardDAV: "card",
+	nsCalDAV:  "cal",
+	nsCS:      "cs",
+}
+
+// maxBodySize is the maximal size of the XML body of a PROPFIND or REPORT
+// request.
+const maxBodySize = 1 << 20
+
+var errInvalidXML = errors.New("Invalid XML body")
+
+// propNames is the list of the properties asked in a <prop> element.
+type propNames []xml.Name
+
+// UnmarshalXML implements the xml.Unmarshaler interface: the names of the
+// children elements are kept, and their content is ignored.
+func (p *propNames) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch elem := t.(type) {
+		case xml.StartElement:
+			*p = append(*p, elem.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type propfindRequest struct {
+	XMLName  xml.Name  `xml:"DAV: propfind"`
+	AllProp  *struct{} `xml:"DAV: allprop"`
+	PropName *struct{} `xml:"DAV: propname"`
+	Prop     propNames `xml:"DAV: prop"`
+}
+
+// readBody reads the XML body of a request. An empty body is accepted, and
+// returned as nil.
+func readBody(c echo.Context) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodySize {
+		return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return nil, nil
+	}
+	return body, nil
+}
+
+// parsePropfind returns the names of the properties asked by a PROPFIND
+// request. A nil list means all the properties.
+func parsePropfind(body []byte) (propNames, error) {
+	if body == nil {
+		return nil, nil
+	}
+	var req propfindRequest
+	if err := xml.Unmarshal(body, &req); err != nil {
+		return nil, errInvalidXML
+	}
+	if req.AllProp != nil || req.PropName != nil {
+		return nil, nil
+	}
+	return req.Prop, nil
+}
+
+// rootName returns the name of the root element of an XML document.
+func rootName(body []byte) (xml.Name, error) {
+	d := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return xml.Name{}, errInvalidXML
+		}
+		if elem, ok := t.(xml.StartElement); ok {
+			return elem.Name, nil
+		}
+	}
+}
+
+// depth returns the value of the Depth header: 0 or 1 (infinity is handled as
+// 1, as the collections have no sub-collections).
+func depth(c echo.Context) int {
+	if c.Request().Header.Get("Depth") == "0" {
+		return 0
+	}
+	return 1
+}
+
+// propValue is the value of a property in a response. It is either a text,
+// that is escaped, or some XML, that has been generated by the server.
+type propValue struct {
+	text  string
+	inner string
+}
+
+func textValue(text string) propValue {
+	return propValue{text: text}
+}
+
+func xmlValue(inner string) propValue {
+	return propValue{inner: inner}
+}
+
+// hrefValue returns the value for a property that is an URL.
+func hrefValue(href string) propValue {
+	return xmlValue("<d:href>" + escape(href) + "</d:href>")
+}
+
+// propGetter returns the value of a property for a resource, and false if the
+// resource has no such property.
+type propGetter func(name xml.Name) (propValue, bool)
+
+// davResponse is a <response> element of a multistatus response.
+type davResponse struct {
+	href    string
+	status  int
+	found   []xml.Name
+	values  []propValue
+	missing []xml.Name
+}
+
+// newResponse returns the response for a resource, with the asked properties.
+// When no property is asked, the properties listed in all are returned.
+func newResponse(href string, names propNames, all []xml.Name, get propGetter) *davResponse {
+	if names == nil {
+		names = all
+	}
+	r := &davResponse{href: href}
+	for _, name := range names {
+		if value, ok := get(name); ok {
+			r.found = append(r.found, name)
+			r.values = append(r.values, value)
+		} else {
+			r.missing = append(r.missing, name)
+		}
+	}
+	return r
+}
+
+// statusResponse returns a response with only a status, like for a resource
+// that has been deleted.
+func statusResponse(href string, status int) *davResponse {
+	return &davResponse{href: href, status: status}
+}
+
+func escape(s string) string {
+	var buf bytes.Buffer
+	_ = xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+func statusLine(code int) string {
+	return fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code))
+}
+
+// writeName writes the opening (or self-closing) tag for a property. The
+// namespaces that don't have a known prefix are declared on the element.
+func writeName(sb *strings.Builder, name xml.Name, closing string) string {
+	tag := name.Local
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+		sb.WriteString("<" + tag + closing)
+		return tag
+	}
+	if name.Space == "" {
+		sb.WriteString("<" + tag + closing)
+		return tag
+	}
+	tag = "x:" + name.Local
+	sb.WriteString(`<` + tag + ` xmlns:x="` + escape(name.Space) + `"` + closing)
+	return tag
+}
+
+func writeProps(sb *strings.Builder, names []xml.Name, values []propValue, status int) {
+	sb.WriteString("<d:propstat><d:prop>")
+	for i, name := range names {
+		var value propValue
+		if values != nil {
+			value = values[i]
+		}
+		if value.text == "" && value.inner == "" {
+			writeName(sb, name, "/>")
+			continue
+		}
+		tag := writeName(sb, name, ">")
+		if value.inner != "" {
+			sb.WriteString(value.inner)
+		} else {
+			sb.WriteString(escape(value.text))
+		}
+		sb.WriteString("</" + tag + ">")
+	}
+	sb.WriteString("</d:prop><d:status>" + statusLine(status) + "</d:status></d:propstat>")
+}
+
+// writeMultistatus sends a 207 Multi-Status response. The sync token is added
+// for the responses to a sync-collection REPORT.
+func writeMultistatus(c echo.Context, responses []*davResponse, syncToken string) error {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:card="` + nsCardDAV + `" xmlns:cal="` + nsCalDAV + `" xmlns:cs="` + nsCS + `">`)
+	for _, r := range responses {
+		sb.WriteString("<d:response><d:href>" + escape(r.href) + "</d:href>")
+		if r.status != 0 {
+			sb.WriteString("<d:status>" + statusLine(r.status) + "</d:status>")
+		}
+		if len(r.found) > 0 {
+			writeProps(&sb, r.found, r.values, http.StatusOK)
+		}
+		if len(r.missing) > 0 {
+			writeProps(&sb, r.missing, nil, http.StatusNotFound)
+		}
+		sb.WriteString("</d:response>")
+	}
+	if syncToken != "" {
+		sb.WriteString("<d:sync-token>" + escape(syncToken) + "</d:sync-token>")
+	}
+	sb.WriteString("</d:multistatus>")
+	return c.Blob(http.StatusMultiStatus, "application/xml; charset=utf-8", []byte(sb.String()))
+}
+
+// writeError sends an error with a precondition element in the body, as
+// defined by RFC 4918.
+func writeError(c echo.Context, status int, condition xml.Name) error {
+	var sb strings.Builder
+	sb.WriteString(xml.Header)
+	sb.WriteString(`<d:error xmlns:d="DAV:" xmlns:card="` + nsCardDAV + `" xmlns:cal="` + nsCalDAV + `">`)
+	writeName(&sb, condition, "/>")
+	sb.WriteString("</d:error>")
+	return c.Blob(status, "application/xml; charset=utf-8", []byte(sb.String()))
+}
@@ -964,20 +964,7 @@ func ReadFileContentFromIDHandler(c echo.Context) error {
 		disposition = "attachment"
 	}
 
-	if page := c.QueryParam("Page"); page != "" {
-		p, errp := strconv.Atoi(page)
-		if errp != nil {
-			return jsonapi.InvalidParameter("Page", errp)
-		}
-		err = vfs.ServePDFPage(instance.VFS(), doc, disposition, p, c.Request(), c.Response())
-	} else {
-		err = vfs.ServeFileContent(instance.VFS(), doc, nil, "", disposition, c.Request(), c.Response())
-	}
-	if err != nil {
-		return WrapVfsError(err)
-	}
-
-	return nil
+	return serveFileContent(c, instance, doc, disposition, middlewares.ShareLinkFromRequest(c))
 }
 
 // ReadFileContentFromVersion handles the download of an old version of the
@@ -1008,12 +995,9 @@ func ReadFileContentFromVersion(c echo.Context) error {
 	if c.QueryParam("Dl") == "1" {
 		disposition = "attachment"
 	}
-	err = vfs.ServeFileContent(instance.VFS(), doc, version, "", disposition, c.Request(), c.Response())
-	if err != nil {
-		return WrapVfsError(err)
-	}
-
-	return nil
+	return middlewares.ServeShareLinkDownload(c, instance, middlewares.ShareLinkFromRequest(c), func() error {
+		return WrapVfsError(vfs.ServeFileContent(instance.VFS(), doc, version, "", disposition, c.Request(), c.Response()))
+	})
 }
 
 // RevertFileVersion restores an old version of the file content.
@@ -1125,6 +1109,10 @@ func serveThumbnailPlaceholder(res http.ResponseWriter, req *http.Request, doc *
 // SendFileFromDoc sends the file content to the client.
 // It does permissions, inlining, CSP rules, and PDF single page serving
 func SendFileFromDoc(instance *instance.Instance, c echo.Context, doc *vfs.FileDoc, checkPermission bool) error {
+	return sendFileFromDoc(instance, c, doc, checkPermission, middlewares.ShareLinkFromRequest(c))
+}
+
+func sendFileFromDoc(instance *instance.Instance, c echo.Context, doc *vfs.FileDoc, checkPermission bool, link *permission.Permission) error {
 	var err error
 	if checkPermission {
 		err = middlewares.Allow(c, permission.GET, doc)
@@ -1149,30 +1137,35 @@ func SendFileFromDoc(instance *instance.Instance, c echo.Context, doc *vfs.FileD
 		addCSPRuleForDirectLink(c, doc.Class, doc.Mime)
 	}
 
+	return serveFileContent(c, instance, doc, disposition, link)
+}
+
+// serveFileContent sends the content of the file, or of one of its pages for
+// a PDF, with the rules of the share by link used for the download.
+func serveFileContent(c echo.Context, instance *instance.Instance, doc *vfs.FileDoc, disposition string, link *permission.Permission) error {
+	serve := func() error {
+		return WrapVfsError(vfs.ServeFileContent(instance.VFS(), doc, nil, "", disposition, c.Request(), c.Response()))
+	}
 	if page := c.QueryParam("Page"); page != "" {
 		p, errp := strconv.Atoi(page)
 		if errp != nil {
 			return jsonapi.InvalidParameter("Page", errp)
 		}
-		err = vfs.ServePDFPage(instance.VFS(), doc, disposition, p, c.Request(), c.Response())
-	} else {
-		err = vfs.ServeFileContent(instance.VFS(), doc, nil, "", disposition, c.Request(), c.Response())
-	}
-	if err != nil {
-		return WrapVfsError(err)
+		serve = func() error {
+			return WrapVfsError(vfs.ServePDFPage(instance.VFS(), doc, disposition, p, c.Request(), c.Response()))
+		}
 	}
-
-	return nil
+	return middlewares.ServeShareLinkDownload(c, instance, link, serve)
 }
 
-func sendFileFromPath(c echo.Context, path string, checkPermission bool) error {
+func sendFileFromPath(c echo.Context, path string, checkPermission bool, link *permission.Permission) error {
 	instance := middlewares.GetInstance(c)
 
 	doc, err := instance.VFS().FileByPath(path)
 	if err != nil {
 		return WrapVfsError(err)
 	}
-	return SendFileFromDoc(instance, c, doc, checkPermission)
+	return sendFileFromDoc(instance, c, doc, checkPermission, link)
 }
 
 func addCSPRuleForDirectLink(c echo.Context, class, mime string) {
@@ -1189,11 +1182,11 @@ func addCSPRuleForDirectLink(c echo.Context, class, mime string) {
 // aiming at downloading a file given its path. It serves the file in in
 // attachment mode.
 func ReadFileContentFromPathHandler(c echo.Context) error {
-	return sendFileFromPath(c, c.QueryParam("Path"), true)
+	return sendFileFromPath(c, c.QueryParam("Path"), true, middlewares.ShareLinkFromRequest(c))
 }
 
 func ReadFileContentFromSharingFileID(c echo.Context, fileID string) error {
-	return sendFileFromPath(c, c.QueryParam("Path"), true)
+	return sendFileFromPath(c, c.QueryParam("Path"), true, middlewares.ShareLinkFromRequest(c))
 }
 
 // ArchiveDownload stores the archive parameters into a secret usable for the
@@ -1235,13 +1228,18 @@ func ArchiveDownload(c echo.Context, sharedDrive *sharing.Sharing) error {
 
 	// if accept header is application/zip, send the archive immediately
 	if c.Request().Header.Get(echo.HeaderAccept) == "application/zip" {
-		return archive.Serve(instance.VFS(), c.Response())
+		return middlewares.ServeShareLinkDownload(c, instance, middlewares.ShareLinkFromRequest(c), func() error {
+			return archive.Serve(instance.VFS(), c.Response())
+		})
 	}
 
 	secret, err := vfs.GetStore().AddArchive(instance, archive)
 	if err != nil {
 		return WrapVfsError(err)
 	}
+	if err := middlewares.RememberShareLink(c, instance, secret); err != nil {
+		return err
+	}
 	archive.Secret = secret
 
 	fakeName := url.PathEscape(archive.Name)
@@ -1312,6 +1310,9 @@ func FileDownload(c echo.Context, sharedDrive *sharing.Sharing) error {
 	if err != nil {
 		return WrapVfsError(err)
 	}
+	if err := middlewares.RememberShareLink(c, instance, secret); err != nil {
+		return err
+	}
 
 	filename := c.QueryParam("Filename")
 	if filename == "" {
@@ -1358,10 +1359,13 @@ func ArchiveDownloadHandler(c echo.Context) error {
 		}
 	}
 
-	if err := archive.Serve(instance.VFS(), c.Response()); err != nil {
-		return WrapVfsError(err)
+	link, err := middlewares.ShareLinkFromSecret(c, instance, secret)
+	if err != nil {
+		return err
 	}
-	return nil
+	return middlewares.ServeShareLinkDownload(c, instance, link, func() error {
+		return WrapVfsError(archive.Serve(instance.VFS(), c.Response()))
+	})
 }
 
 // FileDownloadHandler send a file that have previously be defined
@@ -1376,7 +1380,11 @@ func FileDownloadHandler(c echo.Context) error {
 	if err != nil {
 		return WrapVfsError(err)
 	}
-	return sendFileFromPath(c, path, false)
+	link, err := middlewares.ShareLinkFromSecret(c, instance, secret)
+	if err != nil {
+		return err
+	}
+	return sendFileFromPath(c, path, false, link)
 }
 
 func versionDownloadHandler(c echo.Context, secret string) error {
@@ -1408,12 +1416,14 @@ func versionDownloadHandler(c echo.Context, secret string) error {
 		addCSPRuleForDirectLink(c, doc.Class, doc.Mime)
 	}
 
-	filename := c.Param("fake-name")
-	err = vfs.ServeFileContent(instance.VFS(), doc, version, filename, disposition, c.Request(), c.Response())
+	link, err := middlewares.ShareLinkFromSecret(c, instance, "v-"+secret)
 	if err != nil {
-		return WrapVfsError(err)
+		return err
 	}
-	return nil
+	filename := c.Param("fake-name")
+	return middlewares.ServeShareLinkDownload(c, instance, link, func() error {
+		return WrapVfsError(vfs.ServeFileContent(instance.VFS(), doc, version, filename, disposition, c.Request(), c.Response()))
+	})
 }
 
 // Trash handles all DELETE requests on /files/:file-id and
@@ -1823,6 +1833,7 @@ func changesFeed(
 	inst *instance.Instance,
 	sharedDir *vfs.DirDoc,
 	sharedFile *vfs.FileDoc,
+	hidden func(path string) bool,
 ) error {
 	switch {
 	case sharedDir != nil:
@@ -1868,6 +1879,7 @@ func changesFeed(
 
 	if sharedDir != nil {
 		filter.SharedDir = sharedDir
+		filter.Hidden = hidden
 		// These are required to check if documents are in the shared directory
 		filter.IncludePath = true
 		includeDocs = true
@@ -1915,7 +1927,19 @@ func changesFeed(
 // ChangesFeed is the handler for GET /files/_changes, and indirectly, the
 // directory-root shared-drive changes feed.
 func ChangesFeed(c echo.Context, inst *instance.Instance, sharedDir *vfs.DirDoc) error {
-	return changesFeed(c, inst, sharedDir, nil)
+	return changesFeed(c, inst, sharedDir, nil, nil)
+}
+
+// ChangesFeedWithHidden is the directory-root shared-drive changes feed for a
+// member who cannot see some parts of the shared drive: the documents for
+// which hidden returns true are sent as deleted.
+func ChangesFeedWithHidden(
+	c echo.Context,
+	inst *instance.Instance,
+	sharedDir *vfs.DirDoc,
+	hidden func(path string) bool,
+) error {
+	return changesFeed(c, inst, sharedDir, nil, hidden)
 }
 
 // ChangesFeedForSharedFile is the file-root shared-drive changes feed.
@@ -1924,13 +1948,13 @@ func ChangesFeedForSharedFile(
 	inst *instance.Instance,
 	sharedFile *vfs.FileDoc,
 ) error {
-	return changesFeed(c, inst, nil, sharedFile)
+	return changesFeed(c, inst, nil, sharedFile, nil)
 }
 
 func ChangesFeedForFiles(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 
-	return changesFeed(c, inst, nil, nil)
+	return changesFeed(c, inst, nil, nil, nil)
 }
 
 type changesFilter struct {
@@ -1940,6 +1964,7 @@ type changesFilter struct {
 	SkipTrashed bool
 	SharedDir   *vfs.DirDoc
 	SharedFile  *vfs.FileDoc
+	Hidden      func(path string) bool
 	reader      io.Reader
 }
 
@@ -2008,6 +2033,9 @@ func (filter *changesFilter) SharedDriveChange(change *couchdb.Change) *couchdb.
 	path := change.Doc.M["path"].(string)
 	sharedDirPath := utils.EnsureHasSuffix(filter.SharedDir.Fullpath, "/")
 	if strings.HasPrefix(path, sharedDirPath) {
+		if filter.Hidden != nil && filter.Hidden(path) {
+			return couchdb.MakeChangeForDeletion(change.DocID, change.Doc.M["_rev"].(string), change.Seq)
+		}
 		change.Doc.M["driveId"] = filter.SharedDir.SharingID()
 		return change
 	}
@@ -2161,6 +2189,11 @@ func Routes(router *echo.Group) {
 	router.PATCH("/:file-id/:version-id", ModifyFileVersionMetadata)
 	router.DELETE("/:file-id/:version-id", DeleteFileVersionMetadata)
 	router.POST("/:file-id/versions", CopyVersionHandler)
+	router.GET("/:file-id/versions", VersionsTimelineHandler)
+	router.GET("/:file-id/versions/diff", VersionsDiffHandler)
+	router.GET("/:file-id/versioning", GetVersioningPolicyHandler)
+	router.PUT("/:file-id/versioning", PutVersioningPolicyHandler)
+	router.DELETE("/:file-id/versioning", DeleteVersioningPolicyHandler)
 	router.DELETE("/versions", ClearOldVersions)
 
 	router.POST("/_all_docs", GetAllDocs)
@@ -2183,6 +2216,12 @@ func Routes(router *echo.Group) {
 	router.POST("/:file-id", CreationHandler)
 	router.PUT("/:file-id", OverwriteFileContentHandler)
 	router.POST("/upload/metadata", UploadMetadataHandler)
+	router.POST("/uploads", CreateUploadHandler)
+	router.HEAD("/uploads/:upload-id", GetUploadHandler)
+	router.GET("/uploads/:upload-id", GetUploadHandler)
+	router.PATCH("/uploads/:upload-id", PatchUploadHandler)
+	router.POST("/uploads/:upload-id/commit", CommitUploadHandler)
+	router.DELETE("/uploads/:upload-id", AbortUploadHandler)
 	router.POST("/:file-id/copy", FileCopyHandler)
 
 	router.GET("/:file-id/thumbnails/:secret/:format", ThumbnailHandler)
@@ -2261,6 +2300,16 @@ func wrapVfsError(err error) *jsonapi.Error {
 		return jsonapi.BadRequest(err)
 	case vfs.ErrInvalidMetadataID:
 		return jsonapi.InvalidParameter("MetadataID", err)
+	case vfs.ErrUploadOffsetMismatch:
+		return jsonapi.Conflict(err)
+	case vfs.ErrUploadNotFinished:
+		return jsonapi.PreconditionFailed(UploadOffsetHeader, err)
+	case vfs.ErrUploadExpired:
+		return jsonapi.Errorf(http.StatusGone, "%s", err)
+	case vfs.ErrDiffNotText:
+		return jsonapi.Errorf(http.StatusUnprocessableEntity, "%s", err)
+	case vfs.ErrDiffTooLarge:
+		return jsonapi.Errorf(http.StatusRequestEntityTooLarge, "%s", err)
 	}
 	if _, ok := err.(*jsonapi.Error); !ok {
 		logger.WithNamespace("files").Warnf("Not wrapped error: %s", err)
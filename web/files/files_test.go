@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -666,6 +667,103 @@ func TestFiles(t *testing.T) {
 		assert.Equal(t, "foo", string(buf))
 	})
 
+	t.Run("ResumableUpload", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		res := e.POST("/files/uploads").
+			WithQuery("Name", "resumable").
+			WithQuery("DirID", consts.RootDirID).
+			WithQuery("Size", "6").
+			WithHeader("Content-MD5", "OFj2IjCsPJFfMAxmQxLGPw==").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(201)
+		res.Header("Upload-Offset").IsEqual("0")
+		uploadID := res.JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.id").String().NotEmpty().Raw()
+
+		e.PATCH("/files/uploads/"+uploadID).
+			WithHeader("Upload-Offset", "0").
+			WithHeader("Authorization", "Bearer "+token).
+			WithBytes([]byte("foo")).
+			Expect().Status(204).
+			Header("Upload-Offset").IsEqual("3")
+
+		// The commit is refused while the content is incomplete
+		e.POST("/files/uploads/"+uploadID+"/commit").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(412)
+
+		// A part sent for a wrong offset is refused
+		e.PATCH("/files/uploads/"+uploadID).
+			WithHeader("Upload-Offset", "1").
+			WithHeader("Authorization", "Bearer "+token).
+			WithBytes([]byte("bar")).
+			Expect().Status(409)
+
+		e.HEAD("/files/uploads/"+uploadID).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			Header("Upload-Offset").IsEqual("3")
+
+		e.PATCH("/files/uploads/"+uploadID).
+			WithHeader("Upload-Offset", "3").
+			WithHeader("Authorization", "Bearer "+token).
+			WithBytes([]byte("bar")).
+			Expect().Status(204).
+			Header("Upload-Offset").IsEqual("6")
+
+		e.POST("/files/uploads/"+uploadID+"/commit").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.attributes.name").IsEqual("resumable")
+
+		buf, err := readFile(testInstance.VFS(), "/resumable")
+		assert.NoError(t, err)
+		assert.Equal(t, "foobar", string(buf))
+
+		e.GET("/files/uploads/"+uploadID).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(404)
+
+		// Two commits sent in parallel create the file only once
+		uploadID = e.POST("/files/uploads").
+			WithQuery("Name", "resumable-twice").
+			WithQuery("DirID", consts.RootDirID).
+			WithQuery("Size", "6").
+			WithHeader("Content-MD5", "OFj2IjCsPJFfMAxmQxLGPw==").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.id").String().NotEmpty().Raw()
+		e.PATCH("/files/uploads/"+uploadID).
+			WithHeader("Upload-Offset", "0").
+			WithHeader("Authorization", "Bearer "+token).
+			WithBytes([]byte("foobar")).
+			Expect().Status(204)
+
+		var wg sync.WaitGroup
+		statuses := make([]int, 2)
+		for i := range statuses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				req, _ := http.NewRequest(http.MethodPost, ts.URL+"/files/uploads/"+uploadID+"/commit", nil)
+				req.Header.Set("Authorization", "Bearer "+token)
+				res, err := http.DefaultClient.Do(req)
+				if assert.NoError(t, err) {
+					statuses[i] = res.StatusCode
+					res.Body.Close()
+				}
+			}(i)
+		}
+		wg.Wait()
+		assert.ElementsMatch(t, []int{201, 404}, statuses)
+		buf, err = readFile(testInstance.VFS(), "/resumable-twice")
+		assert.NoError(t, err)
+		assert.Equal(t, "foobar", string(buf))
+	})
+
 	t.Run("UploadExceedingQuota", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
@@ -2045,6 +2143,134 @@ func TestFiles(t *testing.T) {
 		vTwo.Path("$.attributes.md5sum").Equal(sum2)
 	})
 
+	t.Run("VersionsTimelineAndDiff", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		_, otherToken := setup.GetTestClient(consts.Contacts)
+
+		cfg := config.GetConfig()
+		oldDelay := cfg.Fs.Versioning.MinDelayBetweenTwoVersions
+		cfg.Fs.Versioning.MinDelayBetweenTwoVersions = 10 * time.Millisecond
+		t.Cleanup(func() { cfg.Fs.Versioning.MinDelayBetweenTwoVersions = oldDelay })
+
+		id := e.POST("/files/").
+			WithQuery("Name", "timeline.txt").
+			WithQuery("Type", "file").
+			WithHeader("Content-Type", "text/plain").
+			WithHeader("Authorization", "Bearer "+token).
+			WithBytes([]byte("one\ntwo\n")).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.id").String().NotEmpty().Raw()
+
+		// Without old versions, there is nothing to compare
+		e.GET("/files/"+id+"/versions/diff").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(404)
+
+		for _, content := range []string{"one\nthree\n", "one\nthree\nfour\n"} {
+			time.Sleep(20 * time.Millisecond)
+			e.PUT("/files/"+id).
+				WithHeader("Content-Type", "text/plain").
+				WithHeader("Authorization", "Bearer "+token).
+				WithBytes([]byte(content)).
+				Expect().Status(200)
+		}
+
+		// The permissions are checked, and the file must exist
+		e.GET("/files/" + id + "/versions").
+			Expect().Status(401)
+		e.GET("/files/"+id+"/versions").
+			WithHeader("Authorization", "Bearer "+otherToken).
+			Expect().Status(403)
+		e.GET("/files/"+id+"/versions/diff").
+			WithHeader("Authorization", "Bearer "+otherToken).
+			Expect().Status(403)
+		e.GET("/files/unknown-file/versions").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(404)
+		e.GET("/files/unknown-file/versions/diff").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(404)
+
+		data := e.GET("/files/"+id+"/versions").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Value("data").Array()
+		data.Length().IsEqual(3)
+		first := data.Value(0).Object()
+		first.HasValue("type", consts.FilesVersions)
+		firstVersion := first.Path("$.attributes.version_id").String().NotEmpty().Raw()
+		first.HasValue("id", id+"/"+firstVersion)
+		first.Path("$.attributes.size").IsEqual("8")
+		secondVersion := data.Value(1).Path("$.attributes.version_id").String().NotEmpty().Raw()
+		data.Value(1).Path("$.attributes.size_delta").IsEqual(2)
+		current := data.Value(2).Object()
+		current.HasValue("id", id+"/"+vfs.CurrentVersionID)
+		current.Path("$.attributes.size").IsEqual("15")
+		current.Path("$.attributes.size_delta").IsEqual(5)
+
+		// By default, the last version is compared with the current content
+		obj := e.GET("/files/"+id+"/versions/diff").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		obj.HasValue("file_id", id)
+		obj.HasValue("from", secondVersion)
+		obj.HasValue("to", vfs.CurrentVersionID)
+		obj.HasValue("format", "text")
+		obj.Path("$.stats.added").IsEqual(1)
+		obj.Path("$.stats.removed").IsEqual(0)
+		obj.Value("unified").String().Contains("+four")
+
+		obj = e.GET("/files/"+id+"/versions/diff").
+			WithQuery("from", firstVersion).
+			WithQuery("to", secondVersion).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		obj.Path("$.stats.added").IsEqual(1)
+		obj.Path("$.stats.removed").IsEqual(1)
+		unified := obj.Value("unified").String()
+		unified.Contains("--- a/timeline.txt")
+		unified.Contains("-two")
+		unified.Contains("+three")
+
+		res := e.GET("/files/"+id+"/versions/diff").
+			WithQuery("from", firstVersion).
+			WithHeader("Authorization", "Bearer "+token).
+			WithHeader("Accept", "text/x-diff").
+			Expect().Status(200)
+		res.Header("Content-Type").HasPrefix("text/x-diff")
+		res.Body().Contains("+four")
+
+		e.GET("/files/"+id+"/versions/diff").
+			WithQuery("from", "unknown-version").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(404)
+
+		// A view-only link can see the timeline, but not the content of the
+		// versions
+		code, err := testInstance.MakeJWT(consts.ShareAudience, "email", consts.Files, "", time.Now())
+		require.NoError(t, err)
+		rules := permission.Set{
+			permission.Rule{
+				Type:   consts.Files,
+				Verbs:  permission.Verbs(permission.GET),
+				Values: []string{id},
+			},
+		}
+		_, err = permission.CreateShareSet(testInstance, &permission.Permission{Type: "app", Permissions: rules}, "",
+			map[string]string{"email": code}, nil, permission.Permission{Permissions: rules, ViewOnly: true}, nil, false)
+		require.NoError(t, err)
+		e.GET("/files/"+id+"/versions").
+			WithHeader("Authorization", "Bearer "+code).
+			Expect().Status(200)
+		e.GET("/files/"+id+"/versions/diff").
+			WithHeader("Authorization", "Bearer "+code).
+			Expect().Status(403)
+	})
+
 	t.Run("PatchVersion", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
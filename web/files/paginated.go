@@ -3,6 +3,7 @@ package files
 // Links is used to generate a JSON-API link for the directory (part of
 import (
 	"encoding/json"
+	"path"
 	"time"
 
 	"github.com/cozy/cozy-stack/model/instance"
@@ -138,11 +139,28 @@ func getDirData(c echo.Context, doc *vfs.DirDoc) (int, couchdb.Cursor, []vfs.Dir
 }
 
 func DirData(c echo.Context, statusCode int, doc *vfs.DirDoc, sharedDrive *sharing.Sharing) error {
+	return DirDataWithHidden(c, statusCode, doc, sharedDrive, nil)
+}
+
+// DirDataWithHidden is like DirData, but the children for which hidden
+// returns true (with their path) are not included in the response.
+func DirDataWithHidden(c echo.Context, statusCode int, doc *vfs.DirDoc, sharedDrive *sharing.Sharing, hidden func(path string) bool) error {
 	instance := middlewares.GetInstance(c)
 	count, cursor, children, err := getDirData(c, doc)
 	if err != nil {
 		return err
 	}
+	if hidden != nil {
+		kept := children[:0]
+		for _, child := range children {
+			if hidden(path.Join(doc.Fullpath, child.DocName)) {
+				count--
+				continue
+			}
+			kept = append(kept, child)
+		}
+		children = kept
+	}
 
 	// Create secrets for thumbnail links in batch for performance reasons
 	var thumbIDs []string
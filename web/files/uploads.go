@@ -0,0 +1,232 @@
+package files
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// UploadOffsetHeader is the header used for the offset of a part of a
+// resumable upload.
+const UploadOffsetHeader = "Upload-Offset"
+
+// UploadLengthHeader is the header used for the total size of a resumable
+// upload.
+const UploadLengthHeader = "Upload-Length"
+
+// CreateUploadHandler creates a session for a resumable upload. The file is
+// identified by the DirID and Name query parameters for a new file, or by the
+// FileID query parameter for a new content of an existing file.
+func CreateUploadHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fs := inst.VFS()
+
+	size, err := strconv.ParseInt(c.QueryParam("Size"), 10, 64)
+	if err != nil || size < 0 {
+		return jsonapi.InvalidParameter("Size", fmt.Errorf("Invalid size"))
+	}
+
+	var newdoc, olddoc *vfs.FileDoc
+	if fileID := c.QueryParam("FileID"); fileID != "" {
+		olddoc, err = fs.FileByID(fileID)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		if err := CheckIfMatch(c, olddoc.Rev()); err != nil {
+			return WrapVfsError(err)
+		}
+		newdoc, err = FileDocFromReq(c, olddoc.DocName, olddoc.DirID)
+	} else {
+		newdoc, err = FileDocFromReq(c, c.QueryParam("Name"), c.QueryParam("DirID"))
+	}
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	newdoc.ByteSize = size
+
+	if _, _, err := checkUploadPerm(c, newdoc, olddoc); err != nil {
+		return err
+	}
+
+	ensureCleanExpiredUploadsTrigger(inst)
+
+	u, err := vfs.NewUploadSession(fs, newdoc, olddoc)
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	setUploadHeaders(c, u)
+	return jsonapi.Data(c, http.StatusCreated, u, nil)
+}
+
+// GetUploadHandler returns the state of a resumable upload.
+func GetUploadHandler(c echo.Context) error {
+	u, err := getUploadSession(c)
+	if err != nil {
+		return err
+	}
+	setUploadHeaders(c, u)
+	if c.Request().Method == http.MethodHead {
+		return c.NoContent(http.StatusOK)
+	}
+	return jsonapi.Data(c, http.StatusOK, u, nil)
+}
+
+// PatchUploadHandler appends a part to the content of a resumable upload. The
+// Upload-Offset header must be the current offset of the upload.
+func PatchUploadHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	u, err := getUploadSession(c)
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.ParseInt(c.Request().Header.Get(UploadOffsetHeader), 10, 64)
+	if err != nil {
+		return jsonapi.InvalidParameter(UploadOffsetHeader, err)
+	}
+	if err := u.AppendPart(inst.VFS(), offset, c.Request().Body); err != nil {
+		return WrapVfsError(err)
+	}
+	setUploadHeaders(c, u)
+	return c.NoContent(http.StatusNoContent)
+}
+
+// CommitUploadHandler creates the file from the content of a resumable upload.
+func CommitUploadHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	fs := inst.VFS()
+	u, err := getUploadSession(c)
+	if err != nil {
+		return err
+	}
+	newdoc, olddoc, err := uploadDocs(c, u)
+	if err != nil {
+		return err
+	}
+	if olddoc != nil {
+		newdoc.ReferencedBy = olddoc.ReferencedBy
+		if olddoc.CozyMetadata != nil {
+			newdoc.CozyMetadata = olddoc.CozyMetadata.Clone()
+		}
+		UpdateFileCozyMetadata(c, newdoc, true)
+	} else {
+		newdoc.CozyMetadata, _ = CozyMetadataFromClaims(c, true)
+	}
+
+	if err := u.Commit(fs, newdoc, olddoc); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			// The upload has already been committed or aborted
+			return jsonapi.NotFound(err)
+		}
+		return WrapVfsError(err)
+	}
+	if olddoc != nil {
+		return FileData(c, http.StatusOK, newdoc, true, nil, nil)
+	}
+	maybeNotifyShareByLinkUpload(c, inst, newdoc.DocName, newdoc.ID(), newdoc.DirID, false)
+	return FileData(c, http.StatusCreated, newdoc, false, nil, nil)
+}
+
+// AbortUploadHandler cancels a resumable upload.
+func AbortUploadHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	u, err := getUploadSession(c)
+	if err != nil {
+		return err
+	}
+	if err := u.Abort(inst.VFS()); err != nil {
+		return WrapVfsError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// getUploadSession loads the upload session from the request, and checks
+// that the permissions allow to write the file.
+func getUploadSession(c echo.Context) (*vfs.UploadSession, error) {
+	inst := middlewares.GetInstance(c)
+	u, err := vfs.GetUploadSession(inst, c.Param("upload-id"))
+	if err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return nil, jsonapi.NotFound(err)
+		}
+		return nil, WrapVfsError(err)
+	}
+	if _, _, err := uploadDocs(c, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// uploadDocs returns the new and old documents for the file of an upload
+// session, after checking the permissions.
+func uploadDocs(c echo.Context, u *vfs.UploadSession) (*vfs.FileDoc, *vfs.FileDoc, error) {
+	inst := middlewares.GetInstance(c)
+	newdoc, err := u.FileDoc()
+	if err != nil {
+		return nil, nil, WrapVfsError(err)
+	}
+	var olddoc *vfs.FileDoc
+	if u.FileID != "" {
+		olddoc, err = inst.VFS().FileByID(u.FileID)
+		if err != nil {
+			return nil, nil, WrapVfsError(err)
+		}
+	}
+	return checkUploadPerm(c, newdoc, olddoc)
+}
+
+func checkUploadPerm(c echo.Context, newdoc, olddoc *vfs.FileDoc) (*vfs.FileDoc, *vfs.FileDoc, error) {
+	if olddoc == nil {
+		if err := checkPerm(c, permission.POST, nil, newdoc); err != nil {
+			return nil, nil, err
+		}
+		return newdoc, nil, nil
+	}
+	if err := checkPerm(c, permission.PUT, nil, olddoc); err != nil {
+		return nil, nil, err
+	}
+	newdoc.SetID(olddoc.ID())
+	if err := checkPerm(c, permission.PUT, nil, newdoc); err != nil {
+		return nil, nil, err
+	}
+	return newdoc, olddoc, nil
+}
+
+func setUploadHeaders(c echo.Context, u *vfs.UploadSession) {
+	h := c.Response().Header()
+	h.Set(UploadOffsetHeader, strconv.FormatInt(u.Offset, 10))
+	h.Set(UploadLengthHeader, strconv.FormatInt(u.ByteSize, 10))
+	h.Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+	h.Set(echo.HeaderCacheControl, "no-store")
+}
+
+func ensureCleanExpiredUploadsTrigger(inst *instance.Instance) {
+	sched := job.System()
+	infos := job.TriggerInfos{
+		Type:       "@cron",
+		WorkerType: "clean-expired-uploads",
+	}
+	if sched.HasTrigger(inst, infos) {
+		return
+	}
+
+	now := time.Now()
+	infos.Arguments = fmt.Sprintf("0 %d %d * * *", now.Minute(), now.Hour())
+	trigger, err := job.NewTrigger(inst, infos, nil)
+	if err != nil {
+		inst.Logger().Errorf("Cannot create clean-expired-uploads trigger: %s", err)
+		return
+	}
+	if err = sched.AddTrigger(trigger); err != nil {
+		inst.Logger().Errorf("Cannot create clean-expired-uploads trigger: %s", err)
+	}
+}
@@ -0,0 +1,118 @@
+package files
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// GetVersioningPolicyHandler returns the versioning policy that applies to
+// the files of a directory. It can be the policy of the directory itself, or
+// the one inherited from a parent directory: the id of the policy is the id
+// of the directory where it has been set.
+func GetVersioningPolicyHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	dir, err := inst.VFS().DirByID(c.Param("file-id"))
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	if err := checkPerm(c, permission.GET, dir, nil); err != nil {
+		return err
+	}
+	policy, err := vfs.FindEffectiveVersioningPolicy(inst, dir.ID())
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	if policy == nil {
+		return jsonapi.NotFound(errors.New("No versioning policy for this directory"))
+	}
+	return jsonapi.Data(c, http.StatusOK, policy, nil)
+}
+
+// PutVersioningPolicyHandler attaches a versioning policy to a directory, or
+// replaces its current policy.
+func PutVersioningPolicyHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	dir, err := inst.VFS().DirByID(c.Param("file-id"))
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	if err := checkPerm(c, permission.PUT, dir, nil); err != nil {
+		return err
+	}
+
+	policy := &vfs.VersioningPolicy{}
+	if _, err := jsonapi.Bind(c.Request().Body, policy); err != nil {
+		return jsonapi.BadJSON()
+	}
+	policy.DocID = dir.ID()
+	policy.DocRev = ""
+	policy.CreatedAt = time.Time{}
+	old := &vfs.VersioningPolicy{}
+	if err := couchdb.GetDoc(inst, old.DocType(), dir.ID(), old); err == nil {
+		policy.DocRev = old.DocRev
+		policy.CreatedAt = old.CreatedAt
+	} else if !couchdb.IsNotFoundError(err) {
+		return WrapVfsError(err)
+	}
+
+	if err := vfs.SaveVersioningPolicy(inst, policy); err != nil {
+		if errors.Is(err, vfs.ErrInvalidVersioningPolicy) {
+			return jsonapi.BadRequest(err)
+		}
+		return WrapVfsError(err)
+	}
+	ensureCleanOldVersionsTrigger(inst)
+	return jsonapi.Data(c, http.StatusOK, policy, nil)
+}
+
+// DeleteVersioningPolicyHandler removes the versioning policy of a directory.
+func DeleteVersioningPolicyHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	dir, err := inst.VFS().DirByID(c.Param("file-id"))
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	if err := checkPerm(c, permission.DELETE, dir, nil); err != nil {
+		return err
+	}
+	policy := &vfs.VersioningPolicy{}
+	if err := couchdb.GetDoc(inst, policy.DocType(), dir.ID(), policy); err != nil {
+		return WrapVfsError(err)
+	}
+	if err := vfs.DeleteVersioningPolicy(inst, policy); err != nil {
+		return WrapVfsError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ensureCleanOldVersionsTrigger creates the @daily trigger that applies the
+// versioning policies to the existing versions, if it doesn't exist yet.
+func ensureCleanOldVersionsTrigger(inst *instance.Instance) {
+	sched := job.System()
+	infos := job.TriggerInfos{
+		Type:       "@daily",
+		WorkerType: "clean-old-versions",
+		Arguments:  "between 2am and 5am",
+	}
+	if sched.HasTrigger(inst, infos) {
+		return
+	}
+	trigger, err := job.NewTrigger(inst, infos, nil)
+	if err != nil {
+		inst.Logger().Errorf("Cannot create clean-old-versions trigger: %s", err)
+		return
+	}
+	if err = sched.AddTrigger(trigger); err != nil {
+		inst.Logger().Errorf("Cannot create clean-old-versions trigger: %s", err)
+	}
+}
@@ -0,0 +1,176 @@
+package files
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/note"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/diff"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// diffMimeType is the content-type for the unified diffs.
+const diffMimeType = "text/x-diff"
+
+var errNoVersion = errors.New("This file has no old version")
+
+type apiTimelineEntry struct {
+	*vfs.TimelineEntry
+	fileID string
+}
+
+func (e *apiTimelineEntry) ID() string                             { return e.fileID + "/" + e.VersionID }
+func (e *apiTimelineEntry) Rev() string                            { return "" }
+func (e *apiTimelineEntry) DocType() string                        { return consts.FilesVersions }
+func (e *apiTimelineEntry) Clone() couchdb.Doc                     { cloned := *e; return &cloned }
+func (e *apiTimelineEntry) SetID(_ string)                         {}
+func (e *apiTimelineEntry) SetRev(_ string)                        {}
+func (e *apiTimelineEntry) Relationships() jsonapi.RelationshipMap { return nil }
+func (e *apiTimelineEntry) Included() []jsonapi.Object             { return nil }
+func (e *apiTimelineEntry) Links() *jsonapi.LinksList              { return nil }
+
+// versionDiff is the response for the diff between two versions of a file.
+type versionDiff struct {
+	FileID  string `json:"file_id"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Format  string `json:"format"`
+	Unified string `json:"unified"`
+	Stats   struct {
+		Added   int `json:"added"`
+		Removed int `json:"removed"`
+	} `json:"stats"`
+	// Only for the notes
+	OldTitle string              `json:"old_title,omitempty"`
+	NewTitle string              `json:"new_title,omitempty"`
+	Changes  []*note.BlockChange `json:"changes,omitempty"`
+}
+
+// VersionsTimelineHandler returns the history of a file: its old versions and
+// its current content, with who has uploaded them.
+func VersionsTimelineHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	doc, err := inst.VFS().FileByID(c.Param("file-id"))
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	if err := checkPerm(c, permission.GET, nil, doc); err != nil {
+		return err
+	}
+	entries, err := vfs.Timeline(inst, doc)
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	objs := make([]jsonapi.Object, len(entries))
+	for i, entry := range entries {
+		objs[i] = &apiTimelineEntry{TimelineEntry: entry, fileID: doc.ID()}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// VersionsDiffHandler returns the differences between two versions of a file.
+// The from and to query parameters are the identifiers of the versions, or
+// "current" for the current content. By default, the last version is
+// compared with the current content.
+func VersionsDiffHandler(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	doc, err := inst.VFS().FileByID(c.Param("file-id"))
+	if err != nil {
+		return WrapVfsError(err)
+	}
+	if err := checkPerm(c, permission.GET, nil, doc); err != nil {
+		return err
+	}
+	if err := CheckAntivirusAction(inst, doc, ActionDownload); err != nil {
+		return err
+	}
+
+	// The diff sends the content of the versions, like a download
+	link := middlewares.ShareLinkFromRequest(c)
+	return middlewares.ServeShareLinkDownload(c, inst, link, func() error {
+		return serveVersionsDiff(c, inst, doc)
+	})
+}
+
+// serveVersionsDiff computes the diff between the versions given by the from
+// and to query parameters, and sends it.
+func serveVersionsDiff(c echo.Context, inst *instance.Instance, doc *vfs.FileDoc) error {
+	from, to := c.QueryParam("from"), c.QueryParam("to")
+	if to == "" {
+		to = vfs.CurrentVersionID
+	}
+	if from == "" {
+		entries, err := vfs.Timeline(inst, doc)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		if len(entries) < 2 {
+			return jsonapi.NotFound(errNoVersion)
+		}
+		from = entries[len(entries)-2].VersionID
+	}
+	fromVersion, err := findVersionForDiff(inst, doc, from)
+	if err != nil {
+		return err
+	}
+	toVersion, err := findVersionForDiff(inst, doc, to)
+	if err != nil {
+		return err
+	}
+
+	res := &versionDiff{FileID: doc.ID(), From: from, To: to}
+	if doc.Mime == consts.NoteMimeType {
+		d, err := note.DiffVersions(inst, doc, fromVersion, toVersion)
+		if err != nil {
+			if errors.Is(err, note.ErrInvalidFile) || errors.Is(err, note.ErrInvalidSchema) {
+				return jsonapi.Errorf(http.StatusUnprocessableEntity, "%s", err)
+			}
+			return WrapVfsError(err)
+		}
+		res.Format = "note"
+		res.Unified = d.Unified
+		res.Stats.Added = d.Added
+		res.Stats.Removed = d.Removed
+		res.OldTitle = d.OldTitle
+		res.NewTitle = d.NewTitle
+		res.Changes = d.Changes
+	} else {
+		oldText, err := vfs.ReadText(inst.VFS(), doc, fromVersion)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		newText, err := vfs.ReadText(inst.VFS(), doc, toVersion)
+		if err != nil {
+			return WrapVfsError(err)
+		}
+		res.Format = "text"
+		res.Unified, res.Stats.Removed, res.Stats.Added = diff.UnifiedWithStats("a/"+doc.DocName, "b/"+doc.DocName,
+			oldText, newText, diff.DefaultContext)
+	}
+
+	if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), diffMimeType) {
+		return c.Blob(http.StatusOK, diffMimeType, []byte(res.Unified))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// findVersionForDiff returns the version with the given identifier, or nil
+// for the current content.
+func findVersionForDiff(inst *instance.Instance, doc *vfs.FileDoc, versionID string) (*vfs.Version, error) {
+	if versionID == vfs.CurrentVersionID {
+		return nil, nil
+	}
+	version, err := vfs.FindVersion(inst, doc.DocID+"/"+versionID)
+	if err != nil {
+		return nil, WrapVfsError(err)
+	}
+	return version, nil
+}
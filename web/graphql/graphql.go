@@ -0,0 +1,200 @@
+// Package graphql is for the /graphql route, a gateway that exposes the
+// documents, their references and the files as a typed graph.
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	typeTextEventStream = "text/event-stream"
+
+	// Send a comment line with this period to keep the SSE connection open
+	pingPeriod = 50 * time.Second
+
+	// maxBodySize is the maximal size of the body of a POST request.
+	maxBodySize = 1 << 20
+)
+
+// params are the parameters of a GraphQL request, in the body of a POST or
+// in the query-string of a GET.
+type params struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// Query is the handler for GET and POST /graphql. The queries are executed
+// and their results are sent as JSON, and the subscriptions are streamed
+// with Server-Sent Events.
+func Query(c echo.Context) error {
+	var p params
+	if c.Request().Method == http.MethodGet {
+		p.Query = c.QueryParam("query")
+		p.OperationName = c.QueryParam("operationName")
+		if vars := c.QueryParam("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &p.Variables); err != nil {
+				return jsonapi.InvalidParameter("variables", err)
+			}
+		}
+	} else {
+		body := http.MaxBytesReader(c.Response(), c.Request().Body, maxBodySize)
+		if err := json.NewDecoder(body).Decode(&p); err != nil {
+			var maxErr *http.MaxBytesError
+			if errors.As(err, &maxErr) {
+				return jsonapi.NewError(http.StatusRequestEntityTooLarge, "The body is too large")
+			}
+			return jsonapi.BadJSON()
+		}
+	}
+
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return middlewares.ErrForbidden
+	}
+	inst := middlewares.GetInstance(c)
+	r := &request{
+		c:      c,
+		inst:   inst,
+		perms:  pdoc.Permissions,
+		loader: newLoader(inst),
+	}
+
+	doc, op, res := prepare(p)
+	if res != nil {
+		return c.JSON(http.StatusBadRequest, res)
+	}
+	exec := graphql.ExecuteParams{
+		Schema:        schema,
+		AST:           doc,
+		OperationName: p.OperationName,
+		Args:          p.Variables,
+		Context:       withRequest(c.Request().Context(), r),
+	}
+
+	if op.Operation != ast.OperationTypeSubscription {
+		res = graphql.Execute(exec)
+		sortErrors(res)
+		return c.JSON(http.StatusOK, res)
+	}
+
+	if !strings.Contains(c.Request().Header.Get(echo.HeaderAccept), typeTextEventStream) {
+		res = &graphql.Result{Errors: []gqlerrors.FormattedError{gqlerrors.NewFormattedError(
+			"The subscriptions are only available with the " + typeTextEventStream + " content type",
+		)}}
+		return c.JSON(http.StatusNotAcceptable, res)
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, typeTextEventStream)
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	results := graphql.ExecuteSubscription(exec)
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case res, ok := <-results:
+			if !ok {
+				_ = writeSSE(w, "complete", nil)
+				return nil
+			}
+			sortErrors(res)
+			if err := writeSSE(w, "next", res); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return nil
+			}
+			w.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// prepare parses and validates the query, and checks that it is not too
+// expensive. It returns the operation to execute, or a result with the
+// errors.
+func prepare(p params) (*ast.Document, *ast.OperationDefinition, *graphql.Result) {
+	src := source.NewSource(&source.Source{
+		Body: []byte(p.Query),
+		Name: "GraphQL request",
+	})
+	doc, err := parser.Parse(parser.ParseParams{Source: src})
+	if err != nil {
+		return nil, nil, &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	validation := graphql.ValidateDocument(&schema, doc, nil)
+	if !validation.IsValid {
+		return nil, nil, &graphql.Result{Errors: validation.Errors}
+	}
+	op, err := checkLimits(doc, p.OperationName, p.Variables)
+	if err != nil {
+		return nil, nil, &graphql.Result{Errors: gqlerrors.FormatErrors(err)}
+	}
+	return doc, op, nil
+}
+
+// sortErrors sorts the errors by their position in the query, as the fields
+// are not resolved in a predictable order.
+func sortErrors(res *graphql.Result) {
+	sort.SliceStable(res.Errors, func(i, j int) bool {
+		a, b := res.Errors[i].Locations, res.Errors[j].Locations
+		if len(a) == 0 || len(b) == 0 {
+			return len(a) > len(b)
+		}
+		if a[0].Line != b[0].Line {
+			return a[0].Line < b[0].Line
+		}
+		return a[0].Column < b[0].Column
+	})
+}
+
+// writeSSE writes an event on the stream, with the format of the GraphQL
+// over Server-Sent Events protocol.
+func writeSSE(w *echo.Response, event string, res *graphql.Result) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\n", event)
+	if res != nil {
+		data, err := json.Marshal(res)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&buf, "data: %s\n", data)
+	} else {
+		buf.WriteString("data: \n")
+	}
+	buf.WriteString("\n")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
+// Routes sets the routing for the GraphQL gateway.
+func Routes(router *echo.Group) {
+	router.GET("", Query)
+	router.POST("", Query)
+}
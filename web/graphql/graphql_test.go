@@ -0,0 +1,219 @@
+package graphql
+
+import (
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphQL(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	const Type = "io.cozy.events"
+
+	config.UseTestFile(t)
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	testInstance := setup.GetTestInstance()
+
+	_, token := setup.GetTestClient(Type + " " + consts.Files)
+	ts := setup.GetTestServer("/graphql", Routes)
+	t.Cleanup(ts.Close)
+
+	_ = couchdb.ResetDB(testInstance, Type)
+	for _, id := range []string{"event-1", "event-2"} {
+		_ = couchdb.CreateNamedDoc(testInstance, &couchdb.JSONDoc{
+			Type: Type,
+			M: map[string]interface{}{
+				"_id":   id,
+				"title": "Title of " + id,
+				"place": map[string]interface{}{"city": "Paris"},
+			},
+		})
+	}
+
+	t.Run("Document", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		obj := e.POST("/graphql").
+			WithHeader("Authorization", "Bearer "+token).
+			WithJSON(map[string]interface{}{
+				"query": `query($id: ID!) {
+					document(doctype: "io.cozy.events", id: $id) { id doctype title: field(path: "title") city: field(path: "place.city") }
+				}`,
+				"variables": map[string]interface{}{"id": "event-1"},
+			}).
+			Expect().Status(200).
+			JSON().Object()
+
+		obj.NotContainsKey("errors")
+		doc := obj.Path("$.data.document").Object()
+		doc.ValueEqual("id", "event-1")
+		doc.ValueEqual("doctype", Type)
+		doc.ValueEqual("title", "Title of event-1")
+		doc.ValueEqual("city", "Paris")
+	})
+
+	t.Run("Documents", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		obj := e.GET("/graphql").
+			WithQuery("query", `{ documents(doctype: "io.cozy.events", ids: ["event-2", "missing", "event-1"]) { id } }`).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+
+		obj.NotContainsKey("errors")
+		docs := obj.Path("$.data.documents").Array()
+		docs.Length().Equal(3)
+		docs.Element(0).Object().ValueEqual("id", "event-2")
+		docs.Element(1).Null()
+		docs.Element(2).Object().ValueEqual("id", "event-1")
+
+		obj = e.GET("/graphql").
+			WithQuery("query", `{ documents(doctype: "io.cozy.events", limit: 10) { id } }`).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		obj.Path("$.data.documents").Array().Length().Equal(2)
+
+		// The design docs are skipped by _all_docs, and the other documents
+		// must still be found with the right ids
+		index := mango.MakeIndex(Type, "by-title", mango.IndexDef{Fields: []string{"title"}})
+		require.NoError(t, couchdb.DefineIndex(testInstance, index))
+		obj = e.GET("/graphql").
+			WithQuery("query", `{ documents(doctype: "io.cozy.events", ids: ["_design/by-title", "event-1", "event-2"]) { id } }`).
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON().Object()
+		docs = obj.Path("$.data.documents").Array()
+		docs.Length().Equal(3)
+		docs.Element(0).Null()
+		docs.Element(1).Object().ValueEqual("id", "event-1")
+		docs.Element(2).Object().ValueEqual("id", "event-2")
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.POST("/graphql").
+			WithJSON(map[string]interface{}{"query": `{ document(doctype: "io.cozy.events", id: "event-1") { id } }`}).
+			Expect().Status(401)
+
+		obj := e.POST("/graphql").
+			WithHeader("Authorization", "Bearer "+token).
+			WithJSON(map[string]interface{}{"query": `{
+				notes: document(doctype: "io.cozy.notes", id: "foo") { id }
+				accounts: document(doctype: "io.cozy.accounts", id: "foo") { id }
+			}`}).
+			Expect().Status(200).
+			JSON().Object()
+
+		obj.Path("$.data.notes").Null()
+		obj.Path("$.data.accounts").Null()
+		errors := obj.Value("errors").Array()
+		errors.Length().Equal(2)
+		errors.Element(0).Object().ValueEqual("message", "Forbidden")
+		errors.Element(0).Object().ValueEqual("path", []string{"notes"})
+		errors.Element(1).Object().ValueEqual("path", []string{"accounts"})
+	})
+
+	t.Run("InvalidQuery", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		obj := e.POST("/graphql").
+			WithHeader("Authorization", "Bearer "+token).
+			WithJSON(map[string]interface{}{"query": `{ document(doctype: "io.cozy.events") { foo } }`}).
+			Expect().Status(400).
+			JSON().Object()
+
+		obj.NotContainsKey("data")
+		obj.Value("errors").Array().Length().Equal(2)
+	})
+
+	t.Run("Files", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		obj := e.POST("/graphql").
+			WithHeader("Authorization", "Bearer "+token).
+			WithJSON(map[string]interface{}{"query": `{
+				root: file(path: "/") { id type path children { id } }
+				files(ids: ["` + consts.RootDirID + `"]) { path parent { id } }
+			}`}).
+			Expect().Status(200).
+			JSON().Object()
+
+		obj.NotContainsKey("errors")
+		root := obj.Path("$.data.root").Object()
+		root.ValueEqual("id", consts.RootDirID)
+		root.ValueEqual("type", consts.DirType)
+		root.ValueEqual("path", "/")
+		for _, child := range root.Value("children").Array().Iter() {
+			child.Object().Value("id").NotEqual(consts.TrashDirID)
+		}
+		file := obj.Path("$.data.files").Array().Element(0).Object()
+		file.ValueEqual("path", "/")
+		file.Value("parent").Null()
+	})
+
+	t.Run("ChildrenOfReferencedDir", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		fs := testInstance.VFS()
+		dir, err := vfs.NewDirDoc(fs, "referenced-dir", consts.RootDirID, nil)
+		require.NoError(t, err)
+		dir.ReferencedBy = []couchdb.DocReference{{ID: "event-1", Type: Type}}
+		require.NoError(t, fs.CreateDir(dir))
+		child, err := vfs.NewDirDoc(fs, "child", dir.ID(), nil)
+		require.NoError(t, err)
+		require.NoError(t, fs.CreateDir(child))
+
+		// A token without the permission on the files can't list the
+		// content of a directory that references a document
+		_, eventsToken := setup.GetTestClient(Type)
+		obj := e.POST("/graphql").
+			WithHeader("Authorization", "Bearer "+eventsToken).
+			WithJSON(map[string]interface{}{"query": `{
+				document(doctype: "io.cozy.events", id: "event-1") { referencedFiles { id children { id } } }
+			}`}).
+			Expect().Status(200).
+			JSON().Object()
+
+		files := obj.Path("$.data.document.referencedFiles").Array()
+		files.Length().Equal(1)
+		files.Element(0).Object().ValueEqual("id", dir.ID())
+		files.Element(0).Object().Value("children").Null()
+		errors := obj.Value("errors").Array()
+		errors.Length().Equal(1)
+		errors.Element(0).Object().ValueEqual("message", "Forbidden")
+
+		obj = e.POST("/graphql").
+			WithHeader("Authorization", "Bearer "+token).
+			WithJSON(map[string]interface{}{"query": `{
+				document(doctype: "io.cozy.events", id: "event-1") { referencedFiles { children { id } } }
+			}`}).
+			Expect().Status(200).
+			JSON().Object()
+		obj.NotContainsKey("errors")
+		children := obj.Path("$.data.document.referencedFiles").Array().Element(0).Object().Value("children").Array()
+		children.Length().Equal(1)
+		children.Element(0).Object().ValueEqual("id", child.ID())
+	})
+
+	t.Run("SubscriptionWithoutSSE", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.POST("/graphql").
+			WithHeader("Authorization", "Bearer "+token).
+			WithJSON(map[string]interface{}{"query": `subscription { events(doctype: "io.cozy.events") { verb id } }`}).
+			Expect().Status(406)
+	})
+}
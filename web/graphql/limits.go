@@ -0,0 +1,176 @@
+package graphql
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+const (
+	// maxDepth is the maximal number of nested selections in a query.
+	maxDepth = 10
+
+	// maxCost is the maximal estimated number of values that a query can
+	// resolve. The lists count for their limit (or the number of ids), and
+	// their sub-selections are multiplied by this number.
+	maxCost = 25000
+)
+
+// listFields are the fields that return a list paginated with the limit and
+// skip arguments.
+var listFields = map[string]bool{
+	"documents":       true,
+	"children":        true,
+	"referencedFiles": true,
+}
+
+var errUnknownOperation = errors.New("The operation to execute can't be found")
+
+// checkLimits returns the operation to execute, or an error if the query is
+// too deep or too expensive. The introspection fields are not checked, as
+// their cost is bounded by the size of the schema.
+func checkLimits(doc *ast.Document, operationName string, variables map[string]interface{}) (*ast.OperationDefinition, error) {
+	var op *ast.OperationDefinition
+	fragments := make(map[string]*ast.FragmentDefinition)
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.OperationDefinition:
+			if operationName == "" || (def.Name != nil && def.Name.Value == operationName) {
+				if op != nil && operationName == "" {
+					return nil, errors.New("The operationName is required for a query with several operations")
+				}
+				op = def
+			}
+		case *ast.FragmentDefinition:
+			fragments[def.Name.Value] = def
+		}
+	}
+	if op == nil {
+		return nil, errUnknownOperation
+	}
+
+	a := &analyzer{fragments: fragments, variables: variables}
+	if err := a.walk(op.SelectionSet, 0, 1); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+type analyzer struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	visiting  map[string]bool
+	cost      int
+}
+
+func (a *analyzer) walk(set *ast.SelectionSet, depth, multiplier int) error {
+	if set == nil {
+		return nil
+	}
+	if depth > maxDepth {
+		return fmt.Errorf("The query is too deep: the maximal depth is %d", maxDepth)
+	}
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			if strings.HasPrefix(sel.Name.Value, "__") {
+				continue
+			}
+			n := multiplier * a.listSize(sel)
+			a.cost += n
+			if a.cost > maxCost {
+				return fmt.Errorf("The query is too expensive: the maximal cost is %d, with the limits of the lists multiplied for the nested fields", maxCost)
+			}
+			if err := a.walk(sel.SelectionSet, depth+1, n); err != nil {
+				return err
+			}
+		case *ast.InlineFragment:
+			if err := a.walk(sel.SelectionSet, depth, multiplier); err != nil {
+				return err
+			}
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			frag, ok := a.fragments[name]
+			if !ok || a.visiting[name] {
+				continue
+			}
+			if a.visiting == nil {
+				a.visiting = make(map[string]bool)
+			}
+			a.visiting[name] = true
+			err := a.walk(frag.SelectionSet, depth, multiplier)
+			delete(a.visiting, name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// listSize returns the maximal number of values for a field.
+func (a *analyzer) listSize(field *ast.Field) int {
+	var limit, ids ast.Value
+	for _, arg := range field.Arguments {
+		switch arg.Name.Value {
+		case "limit":
+			limit = arg.Value
+		case "ids":
+			ids = arg.Value
+		}
+	}
+	if ids != nil {
+		return a.idsCount(ids)
+	}
+	if !listFields[field.Name.Value] {
+		return 1
+	}
+	n := defaultLimit
+	if limit != nil {
+		if v, ok := a.intValue(limit); ok {
+			n = v
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	if n > maxLimit {
+		n = maxLimit
+	}
+	return n
+}
+
+func (a *analyzer) idsCount(value ast.Value) int {
+	n := 1
+	switch v := value.(type) {
+	case *ast.ListValue:
+		n = len(v.Values)
+	case *ast.Variable:
+		if list, ok := a.variables[v.Name.Value].([]interface{}); ok {
+			n = len(list)
+		}
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+func (a *analyzer) intValue(value ast.Value) (int, bool) {
+	switch v := value.(type) {
+	case *ast.IntValue:
+		n, err := strconv.Atoi(v.Value)
+		return n, err == nil
+	case *ast.Variable:
+		switch n := a.variables[v.Name.Value].(type) {
+		case float64:
+			return int(n), true
+		case int:
+			return n, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,113 @@
+package graphql
+
+import (
+	"encoding/json"
+	"sync"
+
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+)
+
+// thunk is a value resolved later by the executor. The executor calls the
+// thunks level by level, after all the resolvers of a level have been
+// called.
+type thunk = func() (interface{}, error)
+
+// loader batches the reads of documents by their ids. The resolvers call load
+// and return the thunk, and the first thunk called for a doctype fetches all
+// the pending documents of this doctype: there is then a single request to
+// _all_docs per doctype and level of the response.
+type loader struct {
+	mu      sync.Mutex
+	db      prefixer.Prefixer
+	pending map[string][]string
+	docs    map[string]map[string]json.RawMessage
+	errors  map[string]map[string]error
+}
+
+func newLoader(db prefixer.Prefixer) *loader {
+	l := &loader{db: db}
+	l.reset()
+	return l
+}
+
+// reset forgets the fetched documents. It is called for each event of a
+// subscription, to avoid serving stale documents.
+func (l *loader) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.pending = make(map[string][]string)
+	l.docs = make(map[string]map[string]json.RawMessage)
+	l.errors = make(map[string]map[string]error)
+}
+
+// load returns a thunk for the raw JSON of a document. The value is nil if
+// the document doesn't exist (or has been deleted).
+func (l *loader) load(doctype, id string) thunk {
+	l.mu.Lock()
+	l.pending[doctype] = append(l.pending[doctype], id)
+	l.mu.Unlock()
+	return func() (interface{}, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		_, fetched := l.docs[doctype][id]
+		if !fetched && l.errors[doctype][id] == nil {
+			ids := append(l.pending[doctype], id)
+			delete(l.pending, doctype)
+			l.fetch(doctype, ids)
+		}
+		if err := l.errors[doctype][id]; err != nil {
+			return nil, err
+		}
+		if doc := l.docs[doctype][id]; doc != nil {
+			return doc, nil
+		}
+		return nil, nil
+	}
+}
+
+func (l *loader) fetch(doctype string, ids []string) {
+	if l.docs[doctype] == nil {
+		l.docs[doctype] = make(map[string]json.RawMessage)
+	}
+	var keys []string
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if _, ok := l.docs[doctype][id]; !ok && !seen[id] {
+			seen[id] = true
+			keys = append(keys, id)
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	var results []json.RawMessage
+	req := &couchdb.AllDocsRequest{Keys: keys}
+	err := couchdb.GetAllDocs(l.db, doctype, req, &results)
+	if err != nil && !couchdb.IsNoDatabaseError(err) {
+		if l.errors[doctype] == nil {
+			l.errors[doctype] = make(map[string]error)
+		}
+		for _, key := range keys {
+			l.errors[doctype][key] = err
+		}
+		return
+	}
+	// The results are indexed by their _id, as GetAllDocs skips the rows of
+	// the design docs, and the results are then not aligned with the keys.
+	for _, key := range keys {
+		l.docs[doctype][key] = nil
+	}
+	for _, result := range results {
+		var doc struct {
+			ID string `json:"_id"`
+		}
+		if err := json.Unmarshal(result, &doc); err != nil || doc.ID == "" {
+			continue
+		}
+		if _, ok := seen[doc.ID]; ok {
+			l.docs[doctype][doc.ID] = result
+		}
+	}
+}
@@ -0,0 +1,754 @@
+package graphql
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	webRealtime "github.com/cozy/cozy-stack/web/realtime"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultLimit = 100
+	maxLimit     = 1000
+)
+
+var (
+	errAccounts    = errors.New("The accounts can't be read with GraphQL")
+	errMissingFile = errors.New("The id or path argument is mandatory")
+	errForbidden   = errors.New("Forbidden")
+)
+
+// request is the state of a GraphQL request, given to the resolvers via the
+// context.
+type request struct {
+	c      echo.Context
+	inst   *instance.Instance
+	perms  permission.Set
+	loader *loader
+}
+
+type requestKey struct{}
+
+func withRequest(ctx context.Context, r *request) context.Context {
+	return context.WithValue(ctx, requestKey{}, r)
+}
+
+func getRequest(p graphql.ResolveParams) *request {
+	return p.Context.Value(requestKey{}).(*request)
+}
+
+// httpError converts the errors from the permission middlewares to errors
+// with a readable message.
+func httpError(err error) error {
+	if he, ok := err.(*echo.HTTPError); ok {
+		if err == middlewares.ErrForbidden {
+			return errForbidden
+		}
+		return fmt.Errorf("%v", he.Message)
+	}
+	return err
+}
+
+// document is a CouchDB document of any doctype.
+type document struct {
+	doctype string
+	m       map[string]interface{}
+}
+
+func (d *document) id() string {
+	id, _ := d.m["_id"].(string)
+	return id
+}
+
+func (d *document) jsonDoc() *couchdb.JSONDoc {
+	return &couchdb.JSONDoc{M: d.m, Type: d.doctype}
+}
+
+func newDocument(doctype string, raw json.RawMessage) (*document, error) {
+	doc := &document{doctype: doctype}
+	if err := json.Unmarshal(raw, &doc.m); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// file is a directory or a file of the VFS.
+type file struct {
+	dir  *vfs.DirDoc
+	file *vfs.FileDoc
+}
+
+func (f *file) fetcher() vfs.Fetcher {
+	if f.dir != nil {
+		return f.dir
+	}
+	return f.file
+}
+
+func newFile(raw json.RawMessage) (*file, error) {
+	var dof vfs.DirOrFileDoc
+	if err := json.Unmarshal(raw, &dof); err != nil {
+		return nil, err
+	}
+	dir, f := dof.Refine()
+	return &file{dir: dir, file: f}, nil
+}
+
+// checkReadableDoctype refuses the doctypes that can't be read via the data
+// API, and the accounts as their credentials would be sent in clear.
+func checkReadableDoctype(doctype string) error {
+	if doctype == consts.Accounts {
+		return errAccounts
+	}
+	return httpError(permission.CheckReadable(doctype))
+}
+
+// loadDocument returns a thunk for a document, with the permissions checked
+// like for GET /data/:doctype/:id.
+func loadDocument(r *request, doctype, id string) thunk {
+	thunk := r.loader.load(doctype, id)
+	return func() (interface{}, error) {
+		raw, err := thunk()
+		if err != nil {
+			return nil, err
+		}
+		if raw == nil {
+			empty := &couchdb.JSONDoc{M: map[string]interface{}{}, Type: doctype}
+			if err := middlewares.Allow(r.c, permission.GET, empty); err != nil {
+				return nil, httpError(err)
+			}
+			return nil, nil
+		}
+		doc, err := newDocument(doctype, raw.(json.RawMessage))
+		if err != nil {
+			return nil, err
+		}
+		if err := middlewares.Allow(r.c, permission.GET, doc.jsonDoc()); err != nil {
+			return nil, httpError(err)
+		}
+		return doc, nil
+	}
+}
+
+// loadFile returns a thunk for a file or directory, with the permissions
+// checked like for GET /files/:id.
+func loadFile(r *request, id string) thunk {
+	thunk := r.loader.load(consts.Files, id)
+	return func() (interface{}, error) {
+		raw, err := thunk()
+		if err != nil || raw == nil {
+			return nil, err
+		}
+		f, err := newFile(raw.(json.RawMessage))
+		if err != nil {
+			return nil, err
+		}
+		if err := middlewares.AllowVFS(r.c, permission.GET, f.fetcher()); err != nil {
+			return nil, httpError(err)
+		}
+		return f, nil
+	}
+}
+
+// forceAll returns a thunk for the list of values of the given thunks.
+func forceAll(thunks []thunk) thunk {
+	return func() (interface{}, error) {
+		values := make([]interface{}, 0, len(thunks))
+		for _, thunk := range thunks {
+			value, err := thunk()
+			if err != nil {
+				return nil, err
+			}
+			if value != nil {
+				values = append(values, value)
+			}
+		}
+		return values, nil
+	}
+}
+
+func limitAndSkip(args map[string]interface{}) (int, int, error) {
+	limit, _ := args["limit"].(int)
+	skip, _ := args["skip"].(int)
+	if limit <= 0 || skip < 0 {
+		return 0, 0, errors.New("The limit must be positive and the skip must not be negative")
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	return limit, skip, nil
+}
+
+func stringArgs(args map[string]interface{}, name string) []string {
+	list, _ := args[name].([]interface{})
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		if s, ok := v.(string); ok {
+			values = append(values, s)
+		}
+	}
+	return values
+}
+
+// JSON is a scalar for any JSON value, used for the attributes of the
+// documents.
+var JSON = graphql.NewScalar(graphql.ScalarConfig{
+	Name:        "JSON",
+	Description: "Any JSON value",
+	Serialize: func(v interface{}) interface{} {
+		return v
+	},
+	ParseValue: func(v interface{}) interface{} {
+		return v
+	},
+	ParseLiteral: func(v ast.Value) interface{} {
+		return v.GetValue()
+	},
+})
+
+func formatTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+var schema graphql.Schema
+
+func nonNull(t graphql.Type) graphql.Type { return graphql.NewNonNull(t) }
+
+func listOf(t graphql.Type) graphql.Type { return graphql.NewList(t) }
+
+func init() {
+	var documentType, fileType, referenceType *graphql.Object
+	limitArgs := func(args graphql.FieldConfigArgument) graphql.FieldConfigArgument {
+		if args == nil {
+			args = graphql.FieldConfigArgument{}
+		}
+		args["limit"] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultLimit}
+		args["skip"] = &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: 0}
+		return args
+	}
+
+	documentType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Document",
+		Description: "A CouchDB document",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id": {Type: nonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*document).id(), nil
+				}},
+				"rev": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*document).m["_rev"], nil
+				}},
+				"doctype": {Type: nonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*document).doctype, nil
+				}},
+				"attributes": {
+					Description: "The whole document",
+					Type:        nonNull(JSON),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						return p.Source.(*document).m, nil
+					},
+				},
+				"field": {
+					Description: "The value of a field, with a dotted path for the nested fields",
+					Type:        JSON,
+					Args: graphql.FieldConfigArgument{
+						"path": {Type: nonNull(graphql.String)},
+					},
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						var value interface{} = p.Source.(*document).m
+						for _, part := range strings.Split(p.Args["path"].(string), ".") {
+							obj, ok := value.(map[string]interface{})
+							if !ok {
+								return nil, nil
+							}
+							value = obj[part]
+						}
+						return value, nil
+					},
+				},
+				"referencedFiles": {
+					Description: "The files that reference this document in their referenced_by",
+					Type:        nonNull(listOf(nonNull(fileType))),
+					Args:        limitArgs(nil),
+					Resolve:     resolveReferencedFiles,
+				},
+			}
+		}),
+	})
+
+	fileType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "File",
+		Description: "A file or a directory of the VFS",
+		Fields: graphql.FieldsThunk(func() graphql.Fields {
+			return graphql.Fields{
+				"id": {Type: nonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*file).fetcher().ID(), nil
+				}},
+				"rev": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if f := p.Source.(*file); f.dir != nil {
+						return f.dir.Rev(), nil
+					}
+					return p.Source.(*file).file.Rev(), nil
+				}},
+				"type": {Type: nonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if p.Source.(*file).dir != nil {
+						return consts.DirType, nil
+					}
+					return consts.FileType, nil
+				}},
+				"name": {Type: nonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if f := p.Source.(*file); f.dir != nil {
+						return f.dir.DocName, nil
+					}
+					return p.Source.(*file).file.DocName, nil
+				}},
+				"path": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					f := p.Source.(*file)
+					if f.dir != nil {
+						return f.dir.Fullpath, nil
+					}
+					return f.file.Path(getRequest(p).inst.VFS())
+				}},
+				"parent": {Type: fileType, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					f := p.Source.(*file)
+					var dirID string
+					if f.dir != nil {
+						dirID = f.dir.DirID
+					} else {
+						dirID = f.file.DirID
+					}
+					if dirID == "" {
+						return nil, nil
+					}
+					return loadFile(getRequest(p), dirID), nil
+				}},
+				"mime":  {Type: graphql.String, Resolve: fileAttr(func(f *vfs.FileDoc) interface{} { return f.Mime })},
+				"class": {Type: graphql.String, Resolve: fileAttr(func(f *vfs.FileDoc) interface{} { return f.Class })},
+				"size": {
+					Description: "The size in bytes, as a string like in the JSON-API of the files",
+					Type:        graphql.String,
+					Resolve: fileAttr(func(f *vfs.FileDoc) interface{} {
+						return fmt.Sprintf("%d", f.ByteSize)
+					}),
+				},
+				"md5sum": {Type: graphql.String, Resolve: fileAttr(func(f *vfs.FileDoc) interface{} {
+					if len(f.MD5Sum) == 0 {
+						return nil
+					}
+					return base64.StdEncoding.EncodeToString(f.MD5Sum)
+				})},
+				"trashed": {Type: nonNull(graphql.Boolean), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					f := p.Source.(*file)
+					if f.dir != nil {
+						return strings.HasPrefix(f.dir.Fullpath, vfs.TrashDirName), nil
+					}
+					return f.file.Trashed, nil
+				}},
+				"tags": {Type: nonNull(listOf(nonNull(graphql.String))), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					f := p.Source.(*file)
+					var tags []string
+					if f.dir != nil {
+						tags = f.dir.Tags
+					} else {
+						tags = f.file.Tags
+					}
+					if tags == nil {
+						tags = []string{}
+					}
+					return tags, nil
+				}},
+				"metadata": {Type: JSON, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					f := p.Source.(*file)
+					if f.dir != nil {
+						return mapOrNil(f.dir.Metadata), nil
+					}
+					return mapOrNil(f.file.Metadata), nil
+				}},
+				"createdAt": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if f := p.Source.(*file); f.dir != nil {
+						return formatTime(f.dir.CreatedAt), nil
+					}
+					return formatTime(p.Source.(*file).file.CreatedAt), nil
+				}},
+				"updatedAt": {Type: graphql.String, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if f := p.Source.(*file); f.dir != nil {
+						return formatTime(f.dir.UpdatedAt), nil
+					}
+					return formatTime(p.Source.(*file).file.UpdatedAt), nil
+				}},
+				"children": {
+					Description: "The content of a directory (null for a file)",
+					Type:        listOf(nonNull(fileType)),
+					Args:        limitArgs(nil),
+					Resolve:     resolveChildren,
+				},
+				"referencedBy": {
+					Description: "The documents that are referenced by this file",
+					Type:        nonNull(listOf(nonNull(referenceType))),
+					Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+						f := p.Source.(*file)
+						var refs []couchdb.DocReference
+						if f.dir != nil {
+							refs = f.dir.ReferencedBy
+						} else {
+							refs = f.file.ReferencedBy
+						}
+						if refs == nil {
+							refs = []couchdb.DocReference{}
+						}
+						return refs, nil
+					},
+				},
+			}
+		}),
+	})
+
+	referenceType = graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Reference",
+		Description: "A document that references a file",
+		Fields: graphql.Fields{
+			"doctype": {Type: nonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(couchdb.DocReference).Type, nil
+			}},
+			"id": {Type: nonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(couchdb.DocReference).ID, nil
+			}},
+			"document": {Type: documentType, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				ref := p.Source.(couchdb.DocReference)
+				if err := checkReadableDoctype(ref.Type); err != nil {
+					return nil, err
+				}
+				return loadDocument(getRequest(p), ref.Type, ref.ID), nil
+			}},
+		},
+	})
+
+	eventType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "Event",
+		Description: "A realtime event",
+		Fields: graphql.Fields{
+			"verb": {Type: nonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*realtime.Event).Verb, nil
+			}},
+			"doctype": {Type: nonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*realtime.Event).Doc.DocType(), nil
+			}},
+			"id": {Type: nonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				return p.Source.(*realtime.Event).Doc.ID(), nil
+			}},
+			"document": {Type: documentType, Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+				e := p.Source.(*realtime.Event)
+				m := realtime.DocAsMap(e.Doc)
+				if m == nil {
+					return nil, nil
+				}
+				return &document{doctype: e.Doc.DocType(), m: m}, nil
+			}},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"document": {
+				Description: "A document, or null if it doesn't exist",
+				Type:        documentType,
+				Args: graphql.FieldConfigArgument{
+					"doctype": {Type: nonNull(graphql.String)},
+					"id":      {Type: nonNull(graphql.ID)},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					doctype := p.Args["doctype"].(string)
+					if err := checkReadableDoctype(doctype); err != nil {
+						return nil, err
+					}
+					return loadDocument(getRequest(p), doctype, p.Args["id"].(string)), nil
+				},
+			},
+			"documents": {
+				Description: "The documents with the given ids, or all the documents of the doctype",
+				Type:        nonNull(listOf(documentType)),
+				Args: limitArgs(graphql.FieldConfigArgument{
+					"doctype": {Type: nonNull(graphql.String)},
+					"ids":     {Type: listOf(nonNull(graphql.ID))},
+				}),
+				Resolve: resolveDocuments,
+			},
+			"file": {
+				Description: "A file or directory, by its id or path",
+				Type:        fileType,
+				Args: graphql.FieldConfigArgument{
+					"id":   {Type: graphql.ID},
+					"path": {Type: graphql.String},
+				},
+				Resolve: resolveFile,
+			},
+			"files": {
+				Description: "The files and directories with the given ids",
+				Type:        nonNull(listOf(fileType)),
+				Args: graphql.FieldConfigArgument{
+					"ids": {Type: nonNull(listOf(nonNull(graphql.ID)))},
+				},
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					r := getRequest(p)
+					ids := stringArgs(p.Args, "ids")
+					thunks := make([]thunk, len(ids))
+					for i, id := range ids {
+						thunks[i] = loadFile(r, id)
+					}
+					return thunks, nil
+				},
+			},
+		},
+	})
+
+	subscription := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Subscription",
+		Fields: graphql.Fields{
+			"events": {
+				Description: "The realtime events for a doctype, or a document if the id is given",
+				Type:        nonNull(eventType),
+				Args: graphql.FieldConfigArgument{
+					"doctype": {Type: nonNull(graphql.String)},
+					"id":      {Type: graphql.ID},
+				},
+				Subscribe: subscribeEvents,
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					getRequest(p).loader.reset()
+					return p.Source, nil
+				},
+			},
+		},
+	})
+
+	var err error
+	schema, err = graphql.NewSchema(graphql.SchemaConfig{
+		Query:        query,
+		Subscription: subscription,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func fileAttr(fn func(f *vfs.FileDoc) interface{}) graphql.FieldResolveFn {
+	return func(p graphql.ResolveParams) (interface{}, error) {
+		if f := p.Source.(*file); f.file != nil {
+			return fn(f.file), nil
+		}
+		return nil, nil
+	}
+}
+
+func mapOrNil(m vfs.Metadata) interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	return map[string]interface{}(m)
+}
+
+func resolveDocuments(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p)
+	doctype := p.Args["doctype"].(string)
+	if err := checkReadableDoctype(doctype); err != nil {
+		return nil, err
+	}
+
+	if _, ok := p.Args["ids"]; ok {
+		ids := stringArgs(p.Args, "ids")
+		thunks := make([]thunk, len(ids))
+		for i, id := range ids {
+			thunks[i] = loadDocument(r, doctype, id)
+		}
+		return thunks, nil
+	}
+
+	// Listing the documents requires a permission on the whole doctype, like
+	// for GET /data/:doctype/_all_docs
+	if err := middlewares.AllowWholeType(r.c, permission.GET, doctype); err != nil {
+		return nil, httpError(err)
+	}
+	limit, skip, err := limitAndSkip(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	var results []json.RawMessage
+	req := &couchdb.AllDocsRequest{Limit: limit, Skip: skip}
+	if err := couchdb.GetAllDocs(r.inst, doctype, req, &results); err != nil {
+		if couchdb.IsNoDatabaseError(err) {
+			return []interface{}{}, nil
+		}
+		return nil, err
+	}
+	docs := make([]interface{}, 0, len(results))
+	for _, raw := range results {
+		doc, err := newDocument(doctype, raw)
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+func resolveFile(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p)
+	if id, ok := p.Args["id"].(string); ok {
+		return loadFile(r, id), nil
+	}
+	path, ok := p.Args["path"].(string)
+	if !ok {
+		return nil, errMissingFile
+	}
+	dir, f, err := r.inst.VFS().DirOrFileByPath(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	result := &file{dir: dir, file: f}
+	if err := middlewares.AllowVFS(r.c, permission.GET, result.fetcher()); err != nil {
+		return nil, httpError(err)
+	}
+	return result, nil
+}
+
+// resolveChildren lists the content of a directory, without the trash for
+// the root directory, like GET /files/:dir-id. The permissions are checked
+// on the directory, as it may come from referencedFiles, which doesn't check
+// them.
+func resolveChildren(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p)
+	f := p.Source.(*file)
+	if f.dir == nil {
+		return nil, nil
+	}
+	if err := middlewares.AllowVFS(r.c, permission.GET, f.dir); err != nil {
+		return nil, httpError(err)
+	}
+	limit, skip, err := limitAndSkip(p.Args)
+	if err != nil {
+		return nil, err
+	}
+	children, err := r.inst.VFS().DirBatch(f.dir, couchdb.NewSkipCursor(limit, skip))
+	if err != nil {
+		return nil, err
+	}
+	files := make([]interface{}, 0, len(children))
+	for _, child := range children {
+		if child.ID() == consts.TrashDirID {
+			continue
+		}
+		dir, doc := child.Refine()
+		files = append(files, &file{dir: dir, file: doc})
+	}
+	return files, nil
+}
+
+// resolveReferencedFiles lists the files that reference a document, with the
+// same permissions as GET /data/:doctype/:id/relationships/references.
+func resolveReferencedFiles(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p)
+	doc := p.Source.(*document)
+	if err := middlewares.AllowTypeAndID(r.c, permission.GET, doc.doctype, doc.id()); err != nil {
+		if middlewares.AllowWholeType(r.c, permission.GET, consts.Files) != nil {
+			return nil, httpError(err)
+		}
+	}
+	limit, skip, err := limitAndSkip(p.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	mu := config.Lock().ReadWrite(r.inst, "vfs")
+	_ = mu.RLock()
+	defer mu.RUnlock()
+
+	key := []string{doc.doctype, doc.id()}
+	req := &couchdb.ViewRequest{
+		StartKey: key,
+		EndKey:   []string{key[0], key[1], couchdb.MaxString},
+		Limit:    limit,
+		Skip:     skip,
+		Reduce:   false,
+	}
+	var res couchdb.ViewResponse
+	if err := couchdb.ExecView(r.inst, couchdb.FilesReferencedByView, req, &res); err != nil {
+		return nil, err
+	}
+	thunks := make([]thunk, len(res.Rows))
+	for i, row := range res.Rows {
+		thunk := r.loader.load(consts.Files, row.ID)
+		thunks[i] = func() (interface{}, error) {
+			raw, err := thunk()
+			if err != nil || raw == nil {
+				return nil, err
+			}
+			return newFile(raw.(json.RawMessage))
+		}
+	}
+	return forceAll(thunks), nil
+}
+
+// subscribeEvents listens to the realtime hub, with the same permissions as
+// the realtime websockets.
+func subscribeEvents(p graphql.ResolveParams) (interface{}, error) {
+	r := getRequest(p)
+	doctype := p.Args["doctype"].(string)
+	id, _ := p.Args["id"].(string)
+	if doctype == consts.Accounts {
+		return nil, errAccounts
+	}
+	if !webRealtime.CanSubscribe(r.inst, r.perms, doctype, id) {
+		return nil, fmt.Errorf("The application can't subscribe to %s", doctype)
+	}
+
+	ds := realtime.GetHub().Subscriber(r.inst)
+	if id == "" {
+		ds.Subscribe(doctype)
+	} else {
+		ds.Watch(doctype, id)
+	}
+	events := make(chan interface{})
+	go func() {
+		defer close(events)
+		defer ds.Close()
+		for {
+			select {
+			case e := <-ds.Channel:
+				if !webRealtime.AllowedEvent(r.inst, r.perms, e) {
+					continue
+				}
+				select {
+				case events <- e:
+				case <-p.Context.Done():
+					return
+				}
+			case <-p.Context.Done():
+				return
+			}
+		}
+	}()
+	return events, nil
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/schema"
 	"github.com/cozy/cozy-stack/model/sharing"
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
@@ -245,3 +246,17 @@ func checkSharings(c echo.Context) error {
 	}
 	return c.JSON(http.StatusOK, results)
 }
+
+func checkSchemas(c echo.Context) error {
+	domain := c.Param("domain")
+	i, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	results, err := schema.CheckDocuments(i, c.QueryParam("Doctype"))
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusOK, results)
+}
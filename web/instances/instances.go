@@ -8,11 +8,13 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/cozy/cozy-stack/model/app"
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/notification"
@@ -26,6 +28,7 @@ import (
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
 	"github.com/cozy/cozy-stack/pkg/utils"
+	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/labstack/echo/v4"
 )
 
@@ -321,11 +324,18 @@ func setAuthMode(c echo.Context) error {
 		return jsonapi.BadRequest(err)
 	}
 
+	if authMode == instance.TwoFactorTOTP && !inst.HasTOTP() {
+		return jsonapi.Errorf(http.StatusUnprocessableEntity, "No authenticator app has been enrolled")
+	}
+
 	if !inst.HasAuthMode(authMode) {
 		inst.AuthMode = authMode
 		if err = instance.Update(inst); err != nil {
 			return err
 		}
+		auditAsAdmin(c, inst, audit.AuthModeUpdated, map[string]interface{}{
+			"auth_mode": authModeString,
+		})
 	} else {
 		alreadyAuthMode := fmt.Sprintf("Instance has already %s auth mode", authModeString)
 		return c.JSON(http.StatusOK, alreadyAuthMode)
@@ -430,7 +440,7 @@ func createEmailVerifiedCode(c echo.Context) error {
 		return err
 	}
 
-	if !inst.HasAuthMode(instance.TwoFactorMail) {
+	if !inst.HasTwoFactor() {
 		return jsonapi.BadRequest(errors.New("2FA by email is not enabled on this instance"))
 	}
 
@@ -457,6 +467,47 @@ func createEmailVerifiedCode(c echo.Context) error {
 	})
 }
 
+// resetTOTP removes the authenticator app and the recovery codes of an
+// instance, for a user who has lost them.
+func resetTOTP(c echo.Context) error {
+	domain := c.Param("domain")
+	inst, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return err
+	}
+	if err := lifecycle.ResetTOTP(inst); err != nil {
+		return err
+	}
+	auditAsAdmin(c, inst, audit.TOTPRemoved, nil)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func listAuditEntries(c echo.Context) error {
+	domain := c.Param("domain")
+	inst, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return err
+	}
+	opts, err := middlewares.AuditListOptions(c)
+	if err != nil {
+		return err
+	}
+	entries, bookmark, err := audit.List(inst, opts)
+	if err != nil {
+		return err
+	}
+	path := "/instances/" + url.PathEscape(domain) + "/audit"
+	return middlewares.AuditEntries(c, path, opts, entries, bookmark)
+}
+
+// auditAsAdmin saves an entry in the audit log of the instance for an
+// operation made via the admin API.
+func auditAsAdmin(c echo.Context, inst *instance.Instance, event string, details map[string]interface{}) {
+	entry := middlewares.NewAuditEntry(c, event, details)
+	entry.Actor = audit.Actor{Kind: audit.ActorAdmin}
+	audit.Log(inst, entry)
+}
+
 func cleanSessions(c echo.Context) error {
 	domain := c.Param("domain")
 	inst, err := lifecycle.GetInstance(domain)
@@ -764,12 +815,19 @@ func Routes(router *echo.Group) {
 	router.POST("/:domain/session_code/check", checkSessionCode)
 	router.POST("/:domain/email_verified_code", createEmailVerifiedCode)
 	router.DELETE("/:domain/sessions", cleanSessions)
+	router.DELETE("/:domain/totp", resetTOTP)
+	router.GET("/:domain/audit", listAuditEntries)
 
 	// Advanced features for instances
 	router.GET("/:domain/last-activity", lastActivity)
 	router.POST("/:domain/export", exporter)
 	router.GET("/:domain/exports/:export-id/data", dataExporter)
 	router.POST("/:domain/import", importer)
+	router.GET("/:domain/backups", listBackups)
+	router.POST("/:domain/backups", backup)
+	router.POST("/:domain/backups/schedule", scheduleBackups)
+	router.DELETE("/:domain/backups/schedule", unscheduleBackups)
+	router.POST("/:domain/restore", restore)
 	router.GET("/:domain/disk-usage", diskUsage)
 	router.GET("/:domain/prefix", showPrefix)
 	router.GET("/:domain/swift-prefix", getSwiftBucketName)
@@ -790,6 +848,7 @@ func Routes(router *echo.Group) {
 	router.POST("/:domain/checks/triggers", checkTriggers)
 	router.POST("/:domain/checks/shared", checkShared)
 	router.POST("/:domain/checks/sharings", checkSharings)
+	router.POST("/:domain/checks/schemas", checkSchemas)
 
 	// Fixers
 	router.POST("/:domain/fixers/password-defined", passwordDefinedFixer)
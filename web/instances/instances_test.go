@@ -1,16 +1,22 @@
 package instances
 
 import (
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
 	"testing"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/crypto"
 	"github.com/cozy/cozy-stack/tests/testutils"
 	"github.com/cozy/cozy-stack/web/errors"
 	"github.com/cozy/cozy-stack/web/middlewares"
 	"github.com/gavv/httpexpect/v2"
 	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/require"
 )
 
 func TestInstances(t *testing.T) {
@@ -97,4 +103,58 @@ func TestInstances(t *testing.T) {
 			attrs.HasValue("feature_sets", []string{"71df3022-abd9-11ee-b79b-9cb6d0907fa3", "790789f8-abd9-11ee-ae09-9cb6d0907fa3"})
 		})
 	})
+
+	t.Run("ListAuditEntries", func(t *testing.T) {
+		inst := setup.GetTestInstance()
+		for _, event := range []string{audit.PassphraseUpdated, audit.TOTPRemoved, audit.TOTPRemoved} {
+			audit.Log(inst, &audit.Entry{Event: event, Actor: audit.Actor{Kind: audit.ActorAdmin}})
+		}
+
+		// The admin API is protected by the admin passphrase
+		dir := t.TempDir()
+		hash, err := crypto.GenerateFromPassphrase([]byte("admin-passphrase"))
+		require.NoError(t, err)
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "test-admin-secret"), hash, 0600))
+		paths := config.Paths
+		config.Paths = []string{dir}
+		t.Cleanup(func() { config.Paths = paths })
+
+		handler := echo.New()
+		handler.HTTPErrorHandler = errors.ErrorHandler
+		Routes(handler.Group("/instances", middlewares.BasicAuth("test-admin-secret")))
+		admin := httptest.NewServer(handler)
+		t.Cleanup(admin.Close)
+
+		e := testutils.CreateTestClient(t, admin.URL)
+		path := "/instances/" + inst.Domain + "/audit"
+
+		e.GET(path).Expect().Status(401)
+		e.GET(path).WithBasicAuth("", "wrong-passphrase").Expect().Status(403)
+		e.GET("/instances/unknown.cozy.localhost/audit").
+			WithBasicAuth("", "admin-passphrase").
+			Expect().Status(404)
+
+		obj := e.GET(path).
+			WithQuery("filter[event]", audit.TOTPRemoved).
+			WithQuery("page[limit]", 1).
+			WithBasicAuth("", "admin-passphrase").
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		data := obj.Value("data").Array()
+		data.Length().IsEqual(1)
+		attrs := data.Value(0).Path("$.attributes").Object()
+		attrs.HasValue("event", audit.TOTPRemoved)
+		attrs.Value("actor").Object().HasValue("kind", audit.ActorAdmin)
+
+		next := obj.Path("$.links.next").String()
+		next.HasPrefix(path + "?")
+		obj = e.GET(next.Raw()).
+			WithBasicAuth("", "admin-passphrase").
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		obj.Value("data").Array().Length().IsEqual(1)
+		obj.Path("$.data[0].attributes.event").IsEqual(audit.TOTPRemoved)
+	})
 }
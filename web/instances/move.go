@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/move"
@@ -42,6 +43,7 @@ func exporter(c echo.Context) error {
 	if err != nil {
 		return wrapError(err)
 	}
+	auditAsAdmin(c, inst, audit.InstanceExported, nil)
 
 	return c.JSON(http.StatusAccepted, j)
 }
@@ -106,6 +108,122 @@ func importer(c echo.Context) error {
 	if err != nil {
 		return wrapError(err)
 	}
+	auditAsAdmin(c, inst, audit.InstanceImported, map[string]interface{}{
+		"manifest_url": options.ManifestURL,
+	})
+
+	return c.NoContent(http.StatusNoContent)
+}
 
+func listBackups(c echo.Context) error {
+	target, err := move.SystemBackupTarget()
+	if err != nil {
+		return wrapError(err)
+	}
+	backups, err := move.ListBackups(target, c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if backups == nil {
+		backups = []*move.Backup{}
+	}
+	return c.JSON(http.StatusOK, backups)
+}
+
+func backup(c echo.Context) error {
+	domain := c.Param("domain")
+	full, _ := strconv.ParseBool(c.QueryParam("full"))
+
+	inst, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return wrapError(err)
+	}
+	if _, err := move.SystemBackupTarget(); err != nil {
+		return wrapError(err)
+	}
+
+	msg, err := job.NewMessage(move.BackupOptions{Full: full})
+	if err != nil {
+		return wrapError(err)
+	}
+	j, err := job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: move.BackupWorker,
+		Message:    msg,
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	return c.JSON(http.StatusAccepted, j)
+}
+
+func scheduleBackups(c echo.Context) error {
+	inst, err := lifecycle.GetInstance(c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if _, err := move.SystemBackupTarget(); err != nil {
+		return wrapError(err)
+	}
+	if err := move.EnsureBackupTrigger(inst); err != nil {
+		return wrapError(err)
+	}
 	return c.NoContent(http.StatusNoContent)
 }
+
+func unscheduleBackups(c echo.Context) error {
+	inst, err := lifecycle.GetInstance(c.Param("domain"))
+	if err != nil {
+		return wrapError(err)
+	}
+	if err := move.RemoveBackupTrigger(inst); err != nil {
+		return wrapError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func restore(c echo.Context) error {
+	domain := c.Param("domain")
+	inst, err := lifecycle.GetInstance(domain)
+	if err != nil {
+		return wrapError(err)
+	}
+
+	options := move.RestoreOptions{
+		From:     c.QueryParam("from"),
+		BackupID: c.QueryParam("backup"),
+	}
+	target, err := move.SystemBackupTarget()
+	if err != nil {
+		return wrapError(err)
+	}
+	from := options.From
+	if from == "" {
+		from = domain
+	}
+	// Check that the chain can be restored before resetting the instance
+	backups, err := move.ListBackups(target, from)
+	if err != nil {
+		return wrapError(err)
+	}
+	if _, err := move.BackupChain(backups, options.BackupID); err != nil {
+		return wrapError(err)
+	}
+
+	msg, err := job.NewMessage(options)
+	if err != nil {
+		return wrapError(err)
+	}
+	j, err := job.System().PushJob(inst, &job.JobRequest{
+		WorkerType: move.RestoreWorker,
+		Message:    msg,
+	})
+	if err != nil {
+		return wrapError(err)
+	}
+	auditAsAdmin(c, inst, audit.InstanceRestored, map[string]interface{}{
+		"from":   from,
+		"backup": options.BackupID,
+	})
+
+	return c.JSON(http.StatusAccepted, j)
+}
@@ -31,19 +31,25 @@ import (
 	// import workers
 	_ "github.com/cozy/cozy-stack/worker/antivirus"
 	_ "github.com/cozy/cozy-stack/worker/archive"
+	_ "github.com/cozy/cozy-stack/worker/audit"
+	_ "github.com/cozy/cozy-stack/worker/bitwarden"
 	"github.com/cozy/cozy-stack/worker/exec"
 	_ "github.com/cozy/cozy-stack/worker/log"
 	_ "github.com/cozy/cozy-stack/worker/mails"
 	_ "github.com/cozy/cozy-stack/worker/migrations"
 	_ "github.com/cozy/cozy-stack/worker/moves"
 	_ "github.com/cozy/cozy-stack/worker/notes"
+	_ "github.com/cozy/cozy-stack/worker/notifications"
 	_ "github.com/cozy/cozy-stack/worker/oauth"
 	_ "github.com/cozy/cozy-stack/worker/push"
 	_ "github.com/cozy/cozy-stack/worker/rag"
+	_ "github.com/cozy/cozy-stack/worker/reminders"
+	_ "github.com/cozy/cozy-stack/worker/search"
 	_ "github.com/cozy/cozy-stack/worker/share"
 	_ "github.com/cozy/cozy-stack/worker/sms"
 	_ "github.com/cozy/cozy-stack/worker/thumbnail"
 	_ "github.com/cozy/cozy-stack/worker/trash"
+	"github.com/cozy/cozy-stack/worker/webhook"
 )
 
 type (
@@ -354,6 +360,11 @@ func (h *HTTPHandler) newTrigger(c echo.Context) error {
 			return err
 		}
 	}
+	if req.WorkerType == webhook.WorkerType {
+		if err := checkWebhookTrigger(c, t.Infos(), msg); err != nil {
+			return err
+		}
+	}
 
 	if err = sched.AddTrigger(t); err != nil {
 		return wrapJobsError(err)
@@ -460,6 +471,11 @@ func (h *HTTPHandler) patchTrigger(c echo.Context) error {
 	}
 
 	if len(req.Message) > 0 {
+		if infos.WorkerType == webhook.WorkerType {
+			if err := checkWebhookTrigger(c, infos, req.Message); err != nil {
+				return err
+			}
+		}
 		if err := sched.UpdateMessage(inst, t, req.Message); err != nil {
 			return wrapJobsError(err)
 		}
@@ -909,6 +925,32 @@ func checkReservedWorker(worker string) error {
 	return nil
 }
 
+// checkWebhookTrigger checks that an outgoing webhook is sent on events, with
+// a valid URL and secret, and that the client is allowed to read the documents
+// that will be sent to the remote URL.
+func checkWebhookTrigger(c echo.Context, infos *job.TriggerInfos, msg json.RawMessage) error {
+	if infos.Type != "@event" {
+		return jsonapi.InvalidAttribute("Type", errors.New("Outgoing webhooks can only be used with @event triggers"))
+	}
+	var m webhook.Message
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return jsonapi.InvalidAttribute("message", err)
+	}
+	if err := m.Validate(); err != nil {
+		return jsonapi.InvalidAttribute("message", err)
+	}
+	for _, arg := range strings.Fields(infos.Arguments) {
+		rule, err := permission.UnmarshalRuleString(arg)
+		if err != nil {
+			return jsonapi.InvalidAttribute("arguments", err)
+		}
+		if err := middlewares.AllowWholeType(c, permission.GET, rule.Type); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func allowKonnectorForItsOwnTrigger(c echo.Context, infos *job.TriggerInfos) bool {
 	if infos.WorkerType != "konnector" {
 		return false
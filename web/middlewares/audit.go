@@ -0,0 +1,145 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/audit"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/labstack/echo/v4"
+)
+
+// Audit saves an entry in the audit log of the instance for the current
+// request.
+func Audit(c echo.Context, inst *instance.Instance, event string, details map[string]interface{}) {
+	audit.Log(inst, NewAuditEntry(c, event, details))
+}
+
+// NewAuditEntry returns an entry for the audit log, with the actor, the IP
+// address and the user-agent of the current request.
+func NewAuditEntry(c echo.Context, event string, details map[string]interface{}) *audit.Entry {
+	req := c.Request()
+	return &audit.Entry{
+		Event:     event,
+		Actor:     auditActor(c),
+		IP:        clientIP(c),
+		UserAgent: req.UserAgent(),
+		Details:   details,
+	}
+}
+
+// OAuthClientAuditDetails returns the details of an OAuth client for an entry
+// of the audit log.
+func OAuthClientAuditDetails(client *oauth.Client) map[string]interface{} {
+	id := client.ClientID
+	if id == "" {
+		id = client.CouchID
+	}
+	return map[string]interface{}{
+		"client_id":   id,
+		"client_name": client.ClientName,
+		"client_kind": client.ClientKind,
+		"software_id": client.SoftwareID,
+	}
+}
+
+// AuditListOptions reads the filter and pagination parameters for listing the
+// audit log from the query-string.
+func AuditListOptions(c echo.Context) (audit.ListOptions, error) {
+	opts := audit.ListOptions{
+		Event:    c.QueryParam("filter[event]"),
+		Bookmark: c.QueryParam("page[cursor]"),
+	}
+	if limit := c.QueryParam("page[limit]"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return opts, jsonapi.BadRequest(err)
+		}
+		opts.Limit = l
+	}
+	return opts, nil
+}
+
+// AuditEntries sends a page of the audit log as a JSON-API response, with a
+// link to the next page when there is one.
+func AuditEntries(c echo.Context, path string, opts audit.ListOptions, entries []*audit.Entry, bookmark string) error {
+	var links jsonapi.LinksList
+	if bookmark != "" {
+		query := url.Values{"page[cursor]": {bookmark}}
+		if opts.Event != "" {
+			query.Set("filter[event]", opts.Event)
+		}
+		if opts.Limit > 0 {
+			query.Set("page[limit]", strconv.Itoa(opts.Limit))
+		}
+		links.Next = path + "?" + query.Encode()
+	}
+
+	objs := make([]jsonapi.Object, len(entries))
+	for i, e := range entries {
+		objs[i] = e
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, &links)
+}
+
+func clientIP(c echo.Context) string {
+	req := c.Request()
+	if forwardedFor := req.Header.Get(echo.HeaderXForwardedFor); forwardedFor != "" {
+		if ip := strings.TrimSpace(strings.SplitN(forwardedFor, ",", 2)[0]); ip != "" {
+			return ip
+		}
+	}
+	return strings.Split(req.RemoteAddr, ":")[0]
+}
+
+// auditActor uses the permission of the request, or the session when there is
+// no token, to know who has made the operation.
+func auditActor(c echo.Context) audit.Actor {
+	pdoc, _ := c.Get(contextPermissionDoc).(*permission.Permission)
+	if pdoc == nil {
+		if _, ok := GetSession(c); ok {
+			actor := audit.Actor{Kind: audit.ActorOwner}
+			if inst, ok := GetInstanceSafe(c); ok {
+				if a := actorFromInstance(inst); a != nil {
+					actor.Name = a.DisplayName
+					actor.Domain = a.Domain
+				}
+			}
+			return actor
+		}
+		return audit.Actor{Kind: audit.ActorAnonymous}
+	}
+
+	var actor audit.Actor
+	switch pdoc.Type {
+	case permission.TypeWebapp:
+		actor.Kind = audit.ActorApp
+		actor.Client = strings.TrimPrefix(pdoc.SourceID, consts.Apps+"/")
+	case permission.TypeKonnector:
+		actor.Kind = audit.ActorKonnector
+		actor.Client = strings.TrimPrefix(pdoc.SourceID, consts.Konnectors+"/")
+	case permission.TypeOauth:
+		actor.Kind = audit.ActorOAuth
+		actor.Client = pdoc.SourceID
+		if client, ok := pdoc.Client.(*oauth.Client); ok {
+			actor.Name = client.ClientName
+		}
+	case permission.TypeCLI:
+		actor.Kind = audit.ActorCLI
+	case permission.TypeShareInteract:
+		actor.Kind = audit.ActorMember
+	default:
+		actor.Kind = audit.ActorAnonymous
+	}
+	if a, ok := GetActor(c); ok && actor.Name == "" {
+		actor.Name = a.DisplayName
+		actor.Domain = a.Domain
+	}
+	return actor
+}
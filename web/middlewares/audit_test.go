@@ -0,0 +1,99 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/audit"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/session"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditActor(t *testing.T) {
+	newContext := func() echo.Context {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "http://alice.cozy.localhost/settings/audit", nil)
+		return e.NewContext(req, httptest.NewRecorder())
+	}
+	withPermission := func(pdoc *permission.Permission) echo.Context {
+		c := newContext()
+		c.Set(contextPermissionDoc, pdoc)
+		return c
+	}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		assert.Equal(t, audit.Actor{Kind: audit.ActorAnonymous}, auditActor(newContext()))
+
+		pdoc := &permission.Permission{Type: permission.TypeShareByLink}
+		assert.Equal(t, audit.Actor{Kind: audit.ActorAnonymous}, auditActor(withPermission(pdoc)))
+	})
+
+	t.Run("Owner", func(t *testing.T) {
+		c := newContext()
+		c.Set(sessionKey, &session.Session{})
+		assert.Equal(t, audit.Actor{Kind: audit.ActorOwner}, auditActor(c))
+	})
+
+	t.Run("App", func(t *testing.T) {
+		pdoc := &permission.Permission{
+			Type:     permission.TypeWebapp,
+			SourceID: consts.Apps + "/drive",
+		}
+		c := withPermission(pdoc)
+		SetActor(c, &Actor{DisplayName: "Alice", Domain: "alice.cozy.localhost"})
+		assert.Equal(t, audit.Actor{
+			Kind:   audit.ActorApp,
+			Name:   "Alice",
+			Domain: "alice.cozy.localhost",
+			Client: "drive",
+		}, auditActor(c))
+	})
+
+	t.Run("Konnector", func(t *testing.T) {
+		pdoc := &permission.Permission{
+			Type:     permission.TypeKonnector,
+			SourceID: consts.Konnectors + "/ameli",
+		}
+		assert.Equal(t, audit.Actor{
+			Kind:   audit.ActorKonnector,
+			Client: "ameli",
+		}, auditActor(withPermission(pdoc)))
+	})
+
+	t.Run("OAuth", func(t *testing.T) {
+		pdoc := &permission.Permission{
+			Type:     permission.TypeOauth,
+			SourceID: "client-id",
+			Client:   &oauth.Client{ClientName: "Cozy Desktop"},
+		}
+		c := withPermission(pdoc)
+		// The name of the client wins over the name of the actor
+		SetActor(c, &Actor{DisplayName: "Alice", Domain: "alice.cozy.localhost"})
+		assert.Equal(t, audit.Actor{
+			Kind:   audit.ActorOAuth,
+			Name:   "Cozy Desktop",
+			Client: "client-id",
+		}, auditActor(c))
+	})
+
+	t.Run("CLI", func(t *testing.T) {
+		pdoc := &permission.Permission{Type: permission.TypeCLI}
+		assert.Equal(t, audit.Actor{Kind: audit.ActorCLI}, auditActor(withPermission(pdoc)))
+	})
+
+	t.Run("Member", func(t *testing.T) {
+		pdoc := &permission.Permission{Type: permission.TypeShareInteract}
+		c := withPermission(pdoc)
+		SetActor(c, &Actor{DisplayName: "Bob", Domain: "bob.cozy.localhost"})
+		assert.Equal(t, audit.Actor{
+			Kind:   audit.ActorMember,
+			Name:   "Bob",
+			Domain: "bob.cozy.localhost",
+		}, auditActor(c))
+	})
+}
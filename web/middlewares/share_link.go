@@ -0,0 +1,134 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrViewOnlyShareLink is used when a share by link in view-only mode is used
+// to download a file. The handlers that send the content of the files are
+// responsible for applying the rules of the share links, with
+// ServeShareLinkDownload or RememberShareLink: there is no list of routes
+// in the permission middleware, so a new handler must call them.
+var ErrViewOnlyShareLink = echo.NewHTTPError(http.StatusForbidden,
+	"This link only allows to view the files")
+
+// ShareLinkFromRequest returns the share by link used for the request, or nil
+// if the request is not made with a share by link.
+func ShareLinkFromRequest(c echo.Context) *permission.Permission {
+	pdoc, err := GetPermission(c)
+	if err != nil || pdoc.Type != permission.TypeShareByLink {
+		return nil
+	}
+	return pdoc
+}
+
+// ShareLinkFromSecret returns the share by link that was used to create the
+// secret of a download, or the share by link of the request if the secret
+// was not created with one.
+func ShareLinkFromSecret(c echo.Context, inst *instance.Instance, secret string) (*permission.Permission, error) {
+	permID, err := vfs.GetStore().GetShareLink(inst, secret)
+	if err != nil {
+		return nil, err
+	}
+	if permID == "" {
+		return ShareLinkFromRequest(c), nil
+	}
+	pdoc, err := permission.GetByID(inst, permID)
+	if err != nil {
+		// The link has been revoked since the creation of the secret
+		return nil, permission.ErrExpiredToken
+	}
+	return pdoc, nil
+}
+
+// RememberShareLink saves the share by link used for the request with the
+// secret of a download, so that the download will be counted for this link.
+func RememberShareLink(c echo.Context, inst *instance.Instance, secret string) error {
+	link := ShareLinkFromRequest(c)
+	if link == nil {
+		return nil
+	}
+	if err := checkShareLinkDownload(link); err != nil {
+		return err
+	}
+	return vfs.GetStore().AddShareLink(inst, secret, link.ID())
+}
+
+func checkShareLinkDownload(link *permission.Permission) error {
+	if link.ViewOnly {
+		return ErrViewOnlyShareLink
+	}
+	if link.DownloadsExhausted() {
+		return permission.ErrExpiredToken
+	}
+	return nil
+}
+
+// ServeShareLinkDownload sends the content of a download with the serve
+// function, and applies the rules of the share by link used for it: the
+// view-only mode is checked, and a download is reserved in the quota before
+// sending the content, and given back if nothing has been sent. A request
+// with a Range for a secret whose download has already been counted is the
+// continuation of this download, and is not counted again. The other
+// requests with a body are all counted, even for a part of the content.
+func ServeShareLinkDownload(c echo.Context, inst *instance.Instance, link *permission.Permission, serve func() error) error {
+	req := c.Request()
+	if link == nil || req.Method == http.MethodHead {
+		return serve()
+	}
+	if link.ViewOnly {
+		return ErrViewOnlyShareLink
+	}
+	secret := c.Param("secret")
+	if secret != "" && req.Header.Get("Range") != "" {
+		counted, err := vfs.GetStore().HasShareLinkDownload(inst, secret)
+		if err != nil {
+			return err
+		}
+		if counted {
+			return serve()
+		}
+	}
+
+	if err := permission.CountDownload(inst, link); err != nil {
+		return err
+	}
+	err := serve()
+	res := c.Response()
+	if err != nil || !res.Committed || res.Status < 200 || res.Status >= 300 {
+		if errr := permission.ReleaseDownload(inst, link); errr != nil {
+			inst.Logger().WithNamespace("permissions").
+				Warnf("Cannot release the download for the share by link %s: %s", link.ID(), errr)
+		}
+		return err
+	}
+	if secret != "" {
+		if err := vfs.GetStore().AddShareLinkDownload(inst, secret); err != nil {
+			inst.Logger().WithNamespace("permissions").
+				Warnf("Cannot remember the download for the share by link %s: %s", link.ID(), err)
+		}
+	}
+	LogShareLinkAccess(c, inst, link, permission.ShareLinkActionDownload)
+	return nil
+}
+
+// LogShareLinkAccess saves the use of a share by link in its access log.
+func LogShareLinkAccess(c echo.Context, inst *instance.Instance, pdoc *permission.Permission, action string) {
+	fileID := c.Param("file-id")
+	if fileID == "" {
+		fileID = c.QueryParam("Id")
+	}
+	permission.LogShareLinkAccess(inst, &permission.ShareLinkAccess{
+		PermissionID: pdoc.ID(),
+		Action:       action,
+		FileID:       fileID,
+		Path:         c.QueryParam("Path"),
+		IP:           clientIP(c),
+		UserAgent:    c.Request().UserAgent(),
+	})
+}
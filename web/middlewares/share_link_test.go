@@ -0,0 +1,150 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeShareLinkDownload(t *testing.T) {
+	inst := &instance.Instance{Domain: "alice.cozy.localhost"}
+
+	serve := func(method string, link *permission.Permission) (bool, error) {
+		e := echo.New()
+		req := httptest.NewRequest(method, "http://alice.cozy.localhost/files/download/123", nil)
+		c := e.NewContext(req, httptest.NewRecorder())
+		called := false
+		err := ServeShareLinkDownload(c, inst, link, func() error {
+			called = true
+			return nil
+		})
+		return called, err
+	}
+
+	called, err := serve(http.MethodGet, nil)
+	assert.True(t, called)
+	assert.NoError(t, err)
+
+	viewOnly := &permission.Permission{Type: permission.TypeShareByLink, ViewOnly: true}
+	called, err = serve(http.MethodGet, viewOnly)
+	assert.False(t, called)
+	assert.Equal(t, ErrViewOnlyShareLink, err)
+
+	// A HEAD request is not counted
+	exhausted := &permission.Permission{Type: permission.TypeShareByLink, MaxDownloads: 2, DownloadsCount: 2}
+	called, err = serve(http.MethodHead, exhausted)
+	assert.True(t, called)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, exhausted.DownloadsCount)
+}
+
+func TestServeShareLinkDownloadQuota(t *testing.T) {
+	if testing.Short() {
+		t.Skip("a couchdb is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	inst := setup.GetTestInstance()
+
+	newLink := func(maxDownloads int) *permission.Permission {
+		link := &permission.Permission{
+			Type:         permission.TypeShareByLink,
+			MaxDownloads: maxDownloads,
+			Permissions:  permission.Set{permission.Rule{Type: consts.Files, Values: []string{"123"}}},
+		}
+		require.NoError(t, couchdb.CreateDoc(inst, link))
+		return link
+	}
+	downloadsCount := func(link *permission.Permission) int {
+		fresh, err := permission.GetByID(inst, link.ID())
+		require.NoError(t, err)
+		return fresh.DownloadsCount
+	}
+	serve := func(link *permission.Permission, secret, rng string, status int, fail error) (bool, error) {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "http://"+inst.Domain+"/files/downloads/"+secret+"/foo.txt", nil)
+		if rng != "" {
+			req.Header.Set("Range", rng)
+		}
+		c := e.NewContext(req, httptest.NewRecorder())
+		if secret != "" {
+			c.SetParamNames("secret", "fake-name")
+			c.SetParamValues(secret, "foo.txt")
+		}
+		called := false
+		err := ServeShareLinkDownload(c, inst, link, func() error {
+			called = true
+			if fail != nil {
+				return fail
+			}
+			c.Response().WriteHeader(status)
+			_, err := c.Response().Write([]byte("foo"))
+			return err
+		})
+		return called, err
+	}
+
+	t.Run("CountAndRelease", func(t *testing.T) {
+		link := newLink(3)
+
+		_, err := serve(link, "", "", http.StatusOK, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, downloadsCount(link))
+
+		// A failed download gives back its reservation
+		failure := errors.New("cannot read the file")
+		_, err = serve(link, "", "", 0, failure)
+		assert.Equal(t, failure, err)
+		_, err = serve(link, "", "", http.StatusNotFound, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, downloadsCount(link))
+
+		// A partial content is counted, whatever its range
+		_, err = serve(link, "", "bytes=1-", http.StatusPartialContent, nil)
+		assert.NoError(t, err)
+		_, err = serve(link, "", "bytes=0-1,5-6", http.StatusPartialContent, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, 3, downloadsCount(link))
+
+		called, err := serve(link, "", "", http.StatusOK, nil)
+		assert.False(t, called)
+		assert.Equal(t, permission.ErrExpiredToken, err)
+		assert.Equal(t, 3, downloadsCount(link))
+	})
+
+	t.Run("RangeContinuation", func(t *testing.T) {
+		link := newLink(1)
+		secret := "continuation-" + link.ID()
+
+		// The first range is counted, and the next ones for the same secret
+		// are the continuation of this download
+		_, err := serve(link, secret, "bytes=0-9", http.StatusPartialContent, nil)
+		assert.NoError(t, err)
+		called, err := serve(link, secret, "bytes=10-", http.StatusPartialContent, nil)
+		assert.True(t, called)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, downloadsCount(link))
+
+		// A new download of the same secret, or a range of another secret,
+		// is refused
+		called, err = serve(link, secret, "", http.StatusOK, nil)
+		assert.False(t, called)
+		assert.Equal(t, permission.ErrExpiredToken, err)
+		called, err = serve(link, "other-"+link.ID(), "bytes=10-", http.StatusPartialContent, nil)
+		assert.False(t, called)
+		assert.Equal(t, permission.ErrExpiredToken, err)
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
@@ -61,6 +62,9 @@ func createExport(c echo.Context) error {
 	if err != nil {
 		return err
 	}
+	middlewares.Audit(c, inst, audit.InstanceExported, map[string]interface{}{
+		"with_doctypes": exportOptions.WithDoctypes,
+	})
 	return c.NoContent(http.StatusCreated)
 }
 
@@ -154,6 +158,7 @@ func createImport(c echo.Context) error {
 			"SupportPageURL": inst.SupportPageURL(),
 		})
 	}
+	middlewares.Audit(c, inst, audit.InstanceImported, nil)
 
 	to := inst.PageURL("/move/importing", nil)
 	return c.Redirect(http.StatusSeeOther, to)
@@ -3,10 +3,13 @@ package notifications
 import (
 	"encoding/json"
 	"net/http"
+	"net/url"
+	"strconv"
 
 	"github.com/cozy/cozy-stack/model/app"
 	"github.com/cozy/cozy-stack/model/notification"
 	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
@@ -34,6 +37,16 @@ func (n *apiNotif) MarshalJSON() ([]byte, error) {
 	return json.Marshal(n.n)
 }
 
+type apiPreferences struct {
+	*notification.Preferences
+}
+
+func (p *apiPreferences) Relationships() jsonapi.RelationshipMap { return nil }
+func (p *apiPreferences) Included() []jsonapi.Object             { return nil }
+func (p *apiPreferences) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/notifications/preferences"}
+}
+
 func createHandler(c echo.Context) error {
 	inst := middlewares.GetInstance(c)
 	n := &notification.Notification{}
@@ -50,6 +63,121 @@ func createHandler(c echo.Context) error {
 	return jsonapi.Data(c, http.StatusCreated, &apiNotif{n}, nil)
 }
 
+// listHandler returns the notifications of the inbox, the most recent first.
+// The number of unread notifications is sent in the meta.
+func listHandler(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Notifications); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+
+	opts := notification.ListOptions{
+		UnreadOnly: c.QueryParam("filter[unread]") == "true",
+		Bookmark:   c.QueryParam("page[cursor]"),
+	}
+	if limit := c.QueryParam("page[limit]"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil {
+			return jsonapi.BadRequest(err)
+		}
+		opts.Limit = l
+	}
+	notifs, bookmark, err := notification.List(inst, opts)
+	if err != nil {
+		return err
+	}
+	count, err := notification.CountUnread(inst)
+	if err != nil {
+		return err
+	}
+
+	var links jsonapi.LinksList
+	if bookmark != "" {
+		query := url.Values{"page[cursor]": {bookmark}}
+		if opts.UnreadOnly {
+			query.Set("filter[unread]", "true")
+		}
+		if opts.Limit > 0 {
+			query.Set("page[limit]", strconv.Itoa(opts.Limit))
+		}
+		links.Next = "/notifications?" + query.Encode()
+	}
+
+	objs := make([]jsonapi.Object, len(notifs))
+	for i, n := range notifs {
+		objs[i] = &apiNotif{n}
+	}
+	return jsonapi.DataListWithMeta(c, http.StatusOK, jsonapi.Meta{Count: &count}, objs, &links)
+}
+
+// markAsReadHandler marks a notification as read.
+func markAsReadHandler(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PATCH, consts.Notifications); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	n, err := notification.Find(inst, c.Param("id"))
+	if err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return jsonapi.NotFound(err)
+		}
+		return err
+	}
+	if err := notification.MarkAsRead(inst, n); err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiNotif{n}, nil)
+}
+
+// markAllAsReadHandler marks all the notifications as read.
+func markAllAsReadHandler(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PATCH, consts.Notifications); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if _, err := notification.MarkAllAsRead(inst); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func getPreferencesHandler(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiPreferences{prefs}, nil)
+}
+
+func updatePreferencesHandler(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.PUT, consts.Settings); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	prefs, err := notification.GetPreferences(inst)
+	if err != nil {
+		return err
+	}
+	var attrs notification.Preferences
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
+		return err
+	}
+	if attrs.MutedApps != nil {
+		prefs.MutedApps = attrs.MutedApps
+	}
+	if attrs.MutedCategories != nil {
+		prefs.MutedCategories = attrs.MutedCategories
+	}
+	if err := prefs.Save(inst); err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusOK, &apiPreferences{prefs}, nil)
+}
+
 func wrapErrors(err error) error {
 	if err == nil {
 		return nil
@@ -71,5 +199,13 @@ func wrapErrors(err error) error {
 
 // Routes sets the routing for the notification service.
 func Routes(router *echo.Group) {
+	router.GET("", listHandler)
 	router.POST("", createHandler)
+	router.POST("/read", markAllAsReadHandler)
+	router.POST("/:id/read", markAsReadHandler)
+	router.GET("/preferences", getPreferencesHandler)
+	router.PUT("/preferences", updatePreferencesHandler)
+	router.GET("/webpush/key", webPushKeyHandler)
+	router.POST("/webpush/subscriptions", subscribeWebPushHandler)
+	router.DELETE("/webpush/subscriptions/:id", unsubscribeWebPushHandler)
 }
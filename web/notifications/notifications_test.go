@@ -0,0 +1,262 @@
+package notifications_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/cozy/cozy-stack/web/errors"
+	"github.com/cozy/cozy-stack/web/notifications"
+	"github.com/gavv/httpexpect/v2"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	_ "github.com/cozy/cozy-stack/worker/mails"
+)
+
+func TestNotifications(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	inst := setup.GetTestInstance()
+
+	_, token := setup.GetTestClient(consts.Notifications + " " + consts.Settings)
+	_, inboxToken := setup.GetTestClient(consts.Notifications)
+	_, settingsToken := setup.GetTestClient(consts.Settings)
+
+	ts := setup.GetTestServer("/notifications", notifications.Routes)
+	ts.Config.Handler.(*echo.Echo).HTTPErrorHandler = errors.ErrorHandler
+	t.Cleanup(ts.Close)
+
+	now := time.Now().UTC()
+	create := func(slug, title, priority string, createdAt time.Time) *notification.Notification {
+		n := &notification.Notification{
+			SourceID:   "cozy/app/" + slug + "/test",
+			Originator: "app",
+			Slug:       slug,
+			Category:   "test",
+			Title:      title,
+			Priority:   priority,
+			CreatedAt:  createdAt,
+			LastSent:   createdAt,
+		}
+		require.NoError(t, couchdb.CreateDoc(inst, n))
+		return n
+	}
+	balance := create("banks", "Low balance", notification.PriorityLow, now.Add(-3*time.Hour))
+	shared := create("drive", "A folder has been shared", notification.PriorityLow, now.Add(-2*time.Hour))
+	uploaded := create("drive", "Your upload is finished", "", now.Add(-1*time.Hour))
+
+	t.Run("List", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.GET("/notifications").Expect().Status(401)
+		e.GET("/notifications").
+			WithHeader("Authorization", "Bearer "+settingsToken).
+			Expect().Status(403)
+
+		obj := e.GET("/notifications").
+			WithQuery("page[limit]", 2).
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		obj.Path("$.meta.count").IsEqual(3)
+		data := obj.Value("data").Array()
+		data.Length().IsEqual(2)
+		first := data.Value(0).Object()
+		first.HasValue("type", consts.Notifications)
+		first.HasValue("id", uploaded.ID())
+		first.Path("$.attributes.title").IsEqual(uploaded.Title)
+		first.Path("$.attributes").Object().NotContainsKey("read_at")
+		data.Value(1).Object().HasValue("id", shared.ID())
+
+		next := obj.Path("$.links.next").String()
+		next.HasPrefix("/notifications?")
+		obj = e.GET(next.Raw()).
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		data = obj.Value("data").Array()
+		data.Length().IsEqual(1)
+		data.Value(0).Object().HasValue("id", balance.ID())
+		obj.Path("$.links").Object().NotContainsKey("next")
+
+		e.GET("/notifications").
+			WithQuery("page[limit]", "foo").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(400)
+	})
+
+	t.Run("MarkAsRead", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.POST("/notifications/"+uploaded.ID()+"/read").
+			WithHeader("Authorization", "Bearer "+settingsToken).
+			Expect().Status(403)
+		e.POST("/notifications/unknown/read").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(404)
+
+		attrs := e.POST("/notifications/"+uploaded.ID()+"/read").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.attributes").Object()
+		attrs.HasValue("title", uploaded.Title)
+		readAt := attrs.Value("read_at").String().Raw()
+
+		// Marking it again keeps the first date
+		e.POST("/notifications/"+uploaded.ID()+"/read").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.attributes.read_at").IsEqual(readAt)
+
+		obj := e.GET("/notifications").
+			WithQuery("filter[unread]", "true").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		obj.Path("$.meta.count").IsEqual(2)
+		data := obj.Value("data").Array()
+		data.Length().IsEqual(2)
+		data.Value(0).Object().HasValue("id", shared.ID())
+		data.Value(1).Object().HasValue("id", balance.ID())
+	})
+
+	t.Run("Preferences", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.GET("/notifications/preferences").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(403)
+
+		attrs := e.GET("/notifications/preferences").
+			WithHeader("Authorization", "Bearer "+settingsToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.attributes").Object()
+		attrs.Value("muted_apps").Array().IsEmpty()
+		attrs.Value("muted_categories").Array().IsEmpty()
+
+		body := `{"data": {"type": "io.cozy.settings", "id": "io.cozy.settings.notifications",
+			"attributes": {"muted_apps": ["banks"]}}}`
+		e.PUT("/notifications/preferences").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(body)).
+			Expect().Status(403)
+		attrs = e.PUT("/notifications/preferences").
+			WithHeader("Authorization", "Bearer "+settingsToken).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(body)).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.attributes").Object()
+		attrs.Value("muted_apps").Array().ContainsOnly("banks")
+		attrs.Value("muted_categories").Array().IsEmpty()
+
+		// The fields that are not sent are kept
+		body = `{"data": {"type": "io.cozy.settings", "id": "io.cozy.settings.notifications",
+			"attributes": {"muted_categories": ["drive/sharing"]}}}`
+		e.PUT("/notifications/preferences").
+			WithHeader("Authorization", "Bearer "+settingsToken).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(body)).
+			Expect().Status(200)
+
+		attrs = e.GET("/notifications/preferences").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.attributes").Object()
+		attrs.Value("muted_apps").Array().ContainsOnly("banks")
+		attrs.Value("muted_categories").Array().ContainsOnly("drive/sharing")
+	})
+
+	t.Run("Digest", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		// The messages of the mails that have been queued
+		sentMails := func() []string {
+			var jobs []job.Job
+			err := couchdb.GetAllDocs(inst, consts.Jobs, &couchdb.AllDocsRequest{}, &jobs)
+			if couchdb.IsNoDatabaseError(err) {
+				return nil
+			}
+			require.NoError(t, err)
+			var msgs []string
+			for _, j := range jobs {
+				if j.WorkerType == "sendmail" {
+					msgs = append(msgs, string(j.Message))
+				}
+			}
+			return msgs
+		}
+		before := len(sentMails())
+
+		// The notification of the muted app is not in the mail, and the
+		// notification that has been read is not digested
+		require.NoError(t, center.SendDigest(inst))
+		msgs := sentMails()
+		require.Len(t, msgs, before+1)
+		var digest string
+		for _, msg := range msgs {
+			if strings.Contains(msg, shared.Title) {
+				digest = msg
+			}
+		}
+		require.NotEmpty(t, digest)
+		assert.NotContains(t, digest, balance.Title)
+		assert.NotContains(t, digest, uploaded.Title)
+
+		data := e.GET("/notifications").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Value("data").Array()
+		data.Length().IsEqual(3)
+		data.Value(0).Path("$.attributes").Object().NotContainsKey("digested_at")
+		data.Value(1).Path("$.attributes").Object().ContainsKey("digested_at")
+		data.Value(2).Path("$.attributes").Object().ContainsKey("digested_at")
+
+		// The notifications are sent only once in a digest
+		require.NoError(t, center.SendDigest(inst))
+		assert.Len(t, sentMails(), before+1)
+	})
+
+	t.Run("MarkAllAsRead", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.POST("/notifications/read").
+			WithHeader("Authorization", "Bearer "+settingsToken).
+			Expect().Status(403)
+		e.POST("/notifications/read").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(204)
+
+		obj := e.GET("/notifications").
+			WithQuery("filter[unread]", "true").
+			WithHeader("Authorization", "Bearer "+inboxToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		obj.Path("$.meta.count").IsEqual(0)
+		obj.Value("data").Array().IsEmpty()
+	})
+}
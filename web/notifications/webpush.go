@@ -0,0 +1,99 @@
+package notifications
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/webpush"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+var errWebPushNotConfigured = errors.New("Web Push is not configured")
+
+type apiWebPushSubscription struct {
+	*oauth.WebPushSubscription
+}
+
+func (s *apiWebPushSubscription) Relationships() jsonapi.RelationshipMap { return nil }
+func (s *apiWebPushSubscription) Included() []jsonapi.Object             { return nil }
+func (s *apiWebPushSubscription) Links() *jsonapi.LinksList {
+	return &jsonapi.LinksList{Self: "/notifications/webpush/subscriptions/" + s.ID()}
+}
+
+// webPushKeyHandler returns the public key that the browsers must use for
+// subscribing to the Web Push notifications (applicationServerKey).
+func webPushKeyHandler(c echo.Context) error {
+	if _, err := middlewares.GetPermission(c); err != nil {
+		return err
+	}
+	vapid := config.GetConfig().Notifications.VAPID
+	if vapid == nil {
+		return jsonapi.NotFound(errWebPushNotConfigured)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"public_key": vapid.PublicKey()})
+}
+
+// subscribeWebPushHandler registers the subscription of a browser to the Web
+// Push notifications.
+func subscribeWebPushHandler(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Notifications); err != nil {
+		return err
+	}
+	if config.GetConfig().Notifications.VAPID == nil {
+		return jsonapi.NotFound(errWebPushNotConfigured)
+	}
+	inst := middlewares.GetInstance(c)
+	perm, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+
+	var sub webpush.Subscription
+	if _, err := jsonapi.Bind(c.Request().Body, &sub); err != nil {
+		return err
+	}
+	if err := sub.Validate(); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	doc := &oauth.WebPushSubscription{
+		Subscription: sub,
+		UserAgent:    c.Request().UserAgent(),
+	}
+	switch perm.Type {
+	case permission.TypeWebapp:
+		doc.Slug = strings.TrimPrefix(perm.SourceID, consts.Apps+"/")
+	case permission.TypeOauth:
+		doc.ClientID = perm.SourceID
+	}
+	if err := doc.Save(inst); err != nil {
+		return err
+	}
+	return jsonapi.Data(c, http.StatusCreated, &apiWebPushSubscription{doc}, nil)
+}
+
+// unsubscribeWebPushHandler deletes a Web Push subscription.
+func unsubscribeWebPushHandler(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Notifications); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	sub, err := oauth.FindWebPushSubscription(inst, c.Param("id"))
+	if err != nil {
+		if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+			return jsonapi.NotFound(err)
+		}
+		return err
+	}
+	if err := sub.Delete(inst); err != nil {
+		return err
+	}
+	return c.NoContent(http.StatusNoContent)
+}
@@ -408,7 +408,7 @@ func Login(c echo.Context) error {
 
 		// Check 2FA if enabled, and if yes, render an HTML page to check if
 		// the browser has a trusted device token in its local storage.
-		if inst.HasAuthMode(instance.TwoFactorMail) {
+		if inst.HasTwoFactor() {
 			return c.Render(http.StatusOK, "oidc_twofactor.html", echo.Map{
 				"Domain":      inst.ContextualDomain(),
 				"AccessToken": token,
@@ -565,7 +565,7 @@ func TwoFactor(c echo.Context) error {
 		return createSessionAndRedirect(c, inst, redirect, confirm, "")
 	}
 
-	twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+	twoFactorToken, err := auth.CreateTwoFactorToken(inst)
 	if err != nil {
 		return err
 	}
@@ -1029,10 +1029,15 @@ func AccessToken(c echo.Context) error {
 		})
 	}
 
-	if inst.HasAuthMode(instance.TwoFactorMail) {
+	if inst.HasTwoFactor() {
 		token := []byte(reqBody.TwoFactorToken)
 		if len(token) == 0 {
 			twoFactorToken, err := lifecycle.SendTwoFactorPasscode(inst)
+			if errors.Is(err, instance.ErrWebAuthnRequired) {
+				return c.JSON(http.StatusForbidden, echo.Map{
+					"error": err.Error(),
+				})
+			}
 			if err != nil {
 				return err
 			}
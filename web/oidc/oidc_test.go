@@ -364,6 +364,185 @@ func TestOidc(t *testing.T) {
 		require.Equal(t, oauthClient.ClientID, boundClients[0].OAuthClientID)
 	})
 
+	t.Run("DelegatedCodeWithWebAuthn", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		onboardingFinished := true
+		_ = lifecycle.Patch(testInstance, &lifecycle.Options{
+			OnboardingFinished: &onboardingFinished,
+			FranceConnectID:    "fc_sub",
+			AuthMode:           "two_factor_webauthn",
+		})
+		defer func() {
+			_ = lifecycle.Patch(testInstance, &lifecycle.Options{AuthMode: "basic"})
+		}()
+
+		oauthClient := &oauth.Client{
+			RedirectURIs:    []string{"cozy://flagship"},
+			ClientName:      "Cozy Flagship",
+			ClientKind:      "mobile",
+			SoftwareID:      "cozy-flagship",
+			SoftwareVersion: "0.1.0",
+		}
+		require.Nil(t, oauthClient.Create(testInstance))
+		client, err := oauth.FindClient(testInstance, oauthClient.ClientID)
+		require.NoError(t, err)
+		client.CertifiedFromStore = true
+		require.NoError(t, client.SetFlagship(testInstance))
+
+		getCode := func() string {
+			return e.POST("/admin-oidc/"+testInstance.ContextName+"/franceconnect/code").
+				WithHeader("Content-Type", "application/json").
+				WithJSON(map[string]string{"access_token": "fc_token"}).
+				Expect().Status(200).
+				JSON().Object().
+				Value("delegated_code").String().NotEmpty().Raw()
+		}
+
+		e.POST("/oidc/access_token").
+			WithHost(testInstance.Domain).
+			WithJSON(map[string]string{
+				"client_id":     oauthClient.ClientID,
+				"client_secret": oauthClient.ClientSecret,
+				"scope":         "*",
+				"code":          getCode(),
+			}).
+			Expect().Status(403).
+			JSON(httpexpect.ContentOpts{MediaType: "application/json"}).
+			Object().
+			ValueEqual("error", "webauthn required")
+
+		// A passcode like the ones sent by mail is refused
+		token, passcode, err := testInstance.GenerateTwoFactorSecrets()
+		require.NoError(t, err)
+		e.POST("/oidc/access_token").
+			WithHost(testInstance.Domain).
+			WithJSON(map[string]string{
+				"client_id":           oauthClient.ClientID,
+				"client_secret":       oauthClient.ClientSecret,
+				"scope":               "*",
+				"code":                getCode(),
+				"two_factor_token":    string(token),
+				"two_factor_passcode": passcode,
+			}).
+			Expect().Status(403).
+			JSON(httpexpect.ContentOpts{MediaType: "application/json"}).
+			Object().
+			NotContainsKey("access_token")
+	})
+
+	t.Run("DelegatedCodeWithTOTP", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		onboardingFinished := true
+		_ = lifecycle.Patch(testInstance, &lifecycle.Options{
+			OnboardingFinished: &onboardingFinished,
+			FranceConnectID:    "fc_sub",
+		})
+		codes := testutils.WithTOTP(t, testInstance)
+		mails := testutils.CountMails(t, testInstance, "two_factor")
+
+		oauthClient := &oauth.Client{
+			RedirectURIs:    []string{"cozy://flagship"},
+			ClientName:      "Cozy Flagship",
+			ClientKind:      "mobile",
+			SoftwareID:      "cozy-flagship",
+			SoftwareVersion: "0.1.0",
+		}
+		require.Nil(t, oauthClient.Create(testInstance))
+		client, err := oauth.FindClient(testInstance, oauthClient.ClientID)
+		require.NoError(t, err)
+		client.CertifiedFromStore = true
+		require.NoError(t, client.SetFlagship(testInstance))
+
+		getCode := func() string {
+			return e.POST("/admin-oidc/"+testInstance.ContextName+"/franceconnect/code").
+				WithHeader("Content-Type", "application/json").
+				WithJSON(map[string]string{"access_token": "fc_token"}).
+				Expect().Status(200).
+				JSON().Object().
+				Value("delegated_code").String().NotEmpty().Raw()
+		}
+
+		twoFactorToken := e.POST("/oidc/access_token").
+			WithHost(testInstance.Domain).
+			WithJSON(map[string]string{
+				"client_id":     oauthClient.ClientID,
+				"client_secret": oauthClient.ClientSecret,
+				"scope":         "*",
+				"code":          getCode(),
+			}).
+			Expect().Status(401).
+			JSON(httpexpect.ContentOpts{MediaType: "application/json"}).
+			Object().
+			Value("two_factor_token").String().NotEmpty().Raw()
+
+		// The code comes from the authenticator app, not from a mail
+		assert.Equal(t, mails, testutils.CountMails(t, testInstance, "two_factor"))
+
+		e.POST("/oidc/access_token").
+			WithHost(testInstance.Domain).
+			WithJSON(map[string]string{
+				"client_id":           oauthClient.ClientID,
+				"client_secret":       oauthClient.ClientSecret,
+				"scope":               "*",
+				"code":                getCode(),
+				"two_factor_token":    twoFactorToken,
+				"two_factor_passcode": codes[0],
+			}).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/json"}).
+			Object().
+			Value("access_token").String().NotEmpty()
+	})
+
+	t.Run("LoginWithTOTP", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		onboardingFinished := true
+		_ = lifecycle.Patch(testInstance, &lifecycle.Options{OnboardingFinished: &onboardingFinished})
+		testutils.WithTOTP(t, testInstance)
+		mails := testutils.CountMails(t, testInstance, "two_factor")
+
+		u := e.GET("/oidc/start").
+			WithHost(testInstance.Domain).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			Expect().Status(303).
+			Header("Location").Raw()
+		redirectURL, err := url.Parse(u)
+		require.NoError(t, err)
+
+		queryWithToken := redirectURL.Query()
+		queryWithToken.Add("token", "foo")
+		body := e.GET("/oidc/login").
+			WithHost(testInstance.Domain).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			WithQueryString(queryWithToken.Encode()).
+			Expect().Status(200).
+			ContentType("text/html").
+			Body()
+		matches := body.Match(`name="access-token" value="(\w+)"`)
+		matches.Length().Equal(2)
+		accessToken := matches.Index(1).NotEmpty().Raw()
+
+		u = e.POST("/oidc/twofactor").
+			WithHost(testInstance.Domain).
+			WithRedirectPolicy(httpexpect.DontFollowRedirects).
+			WithFormField("access-token", accessToken).
+			WithFormField("trusted-device-token", "").
+			WithFormField("redirect", "").
+			WithFormField("confirm", "").
+			Expect().Status(303).
+			Header("Location").Raw()
+		redirectURL, err = url.Parse(u)
+		require.NoError(t, err)
+		assert.Equal(t, "/auth/twofactor", redirectURL.Path)
+		assert.NotEmpty(t, redirectURL.Query().Get("two_factor_token"))
+
+		// The code comes from the authenticator app, not from a mail
+		assert.Equal(t, mails, testutils.CountMails(t, testInstance, "two_factor"))
+	})
+
 	t.Run("LoginHintWithOIDCID", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
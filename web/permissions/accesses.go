@@ -0,0 +1,73 @@
+package permissions
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+type apiShareLinkAccess struct {
+	*permission.ShareLinkAccess
+}
+
+func (a *apiShareLinkAccess) Clone() couchdb.Doc {
+	cloned := *a.ShareLinkAccess
+	return &apiShareLinkAccess{&cloned}
+}
+func (a *apiShareLinkAccess) Relationships() jsonapi.RelationshipMap { return nil }
+func (a *apiShareLinkAccess) Included() []jsonapi.Object             { return nil }
+func (a *apiShareLinkAccess) Links() *jsonapi.LinksList              { return nil }
+
+// listShareLinkAccesses returns a page of the access log of a share by link,
+// the most recent entries first.
+func listShareLinkAccesses(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	current, err := middlewares.GetPermission(c)
+	if err != nil {
+		return err
+	}
+
+	doc, err := permission.GetPermissionByIDIncludingExpired(inst, c.Param("permdocid"))
+	if err != nil {
+		return err
+	}
+	if doc.Type != permission.TypeShareByLink {
+		return jsonapi.NotFound(errNotShareByLink)
+	}
+	if current.Type == permission.TypeShareByLink || doc.SourceID != current.SourceID {
+		if err := middlewares.AllowMaximal(c); err != nil {
+			return middlewares.ErrForbidden
+		}
+	}
+
+	limit := 0
+	if l := c.QueryParam("page[limit]"); l != "" {
+		if limit, err = strconv.Atoi(l); err != nil {
+			return jsonapi.BadRequest(err)
+		}
+	}
+	accesses, bookmark, err := permission.ListShareLinkAccesses(inst, doc.ID(), limit, c.QueryParam("page[cursor]"))
+	if err != nil {
+		return err
+	}
+
+	var links jsonapi.LinksList
+	if bookmark != "" {
+		query := url.Values{"page[cursor]": {bookmark}}
+		if limit > 0 {
+			query.Set("page[limit]", strconv.Itoa(limit))
+		}
+		links.Next = "/permissions/" + doc.ID() + "/accesses?" + query.Encode()
+	}
+	objs := make([]jsonapi.Object, len(accesses))
+	for i, a := range accesses {
+		objs[i] = &apiShareLinkAccess{a}
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, &links)
+}
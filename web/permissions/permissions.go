@@ -4,12 +4,14 @@ package permissions
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/oauth"
 	"github.com/cozy/cozy-stack/model/permission"
@@ -30,6 +32,17 @@ import (
 var ErrPatchCodeOrSet = echo.NewHTTPError(http.StatusBadRequest,
 	"The patch doc should have property 'codes' or 'permissions', not both")
 
+var errNotShareByLink = errors.New("This permission is not a share by link")
+
+var errNegativeMaxDownloads = errors.New("The maximal number of downloads cannot be negative")
+
+// shareLinkLimits are the attributes of a patch that restrict how a share by
+// link can be used. They are pointers to know if they have been given.
+type shareLinkLimits struct {
+	MaxDownloads *int  `json:"max_downloads"`
+	ViewOnly     *bool `json:"view_only"`
+}
+
 // ContextPermissionSet is the key used in echo context to store permissions set
 const ContextPermissionSet = "permissions_set"
 
@@ -151,6 +164,9 @@ func HandleCreateShareByLink(c echo.Context, inst *instance.Instance, opts Creat
 	if _, err := jsonapi.Bind(c.Request().Body, &subdoc); err != nil {
 		return err
 	}
+	if subdoc.MaxDownloads < 0 {
+		return jsonapi.InvalidAttribute("max_downloads", errNegativeMaxDownloads)
+	}
 
 	// Run additional validation if provided (e.g., for shared drives)
 	if opts.ValidatePermissions != nil {
@@ -269,6 +285,7 @@ func HandleCreateShareByLink(c echo.Context, inst *instance.Instance, opts Creat
 	if err != nil {
 		return err
 	}
+	auditPermissionCreation(c, inst, pdoc, c.QueryParam("codes") != "")
 
 	// Don't send the password hash to the client
 	if pdoc.Password != nil {
@@ -278,6 +295,29 @@ func HandleCreateShareByLink(c echo.Context, inst *instance.Instance, opts Creat
 	return jsonapi.Data(c, http.StatusOK, &APIPermission{Permission: pdoc}, nil)
 }
 
+// auditPermissionCreation saves the creation of a permission in the audit log.
+// It is a share by link when codes have been asked.
+func auditPermissionCreation(c echo.Context, inst *instance.Instance, pdoc *permission.Permission, withCodes bool) {
+	event := audit.PermissionCreated
+	if withCodes {
+		event = audit.ShareLinkCreated
+	}
+	doctypes := make([]string, 0, len(pdoc.Permissions))
+	for _, rule := range pdoc.Permissions {
+		doctypes = append(doctypes, rule.Type)
+	}
+	details := map[string]interface{}{
+		"permission_id": pdoc.ID(),
+		"source_id":     pdoc.SourceID,
+		"doctypes":      doctypes,
+		"password":      pdoc.Password != nil,
+	}
+	if pdoc.ExpiresAt != nil {
+		details["expires_at"] = pdoc.ExpiresAt
+	}
+	middlewares.Audit(c, inst, event, details)
+}
+
 func createShortCode(tiny bool) string {
 	if tiny {
 		return crypto.GenerateRandomSixDigits()
@@ -426,9 +466,17 @@ func patchPermission(getPerms getPermsFunc, paramName string) echo.HandlerFunc {
 		}
 
 		var patch permission.Permission
-		if _, err = jsonapi.Bind(c.Request().Body, &patch); err != nil {
+		obj, err := jsonapi.Bind(c.Request().Body, &patch)
+		if err != nil {
 			return err
 		}
+		var limits shareLinkLimits
+		if obj.Attributes != nil {
+			if err := json.Unmarshal(*obj.Attributes, &limits); err != nil {
+				return jsonapi.BadJSON()
+			}
+		}
+		patchLimits := limits.MaxDownloads != nil || limits.ViewOnly != nil
 
 		patchSet := patch.Permissions != nil && len(patch.Permissions) > 0
 		patchCodes := len(patch.Codes) > 0
@@ -446,7 +494,7 @@ func patchPermission(getPerms getPermsFunc, paramName string) echo.HandlerFunc {
 			if patchSet {
 				return ErrPatchCodeOrSet
 			}
-			if patch.Password == nil && patch.ExpiresAt == nil {
+			if patch.Password == nil && patch.ExpiresAt == nil && !patchLimits {
 				return ErrPatchCodeOrSet
 			}
 		}
@@ -474,6 +522,21 @@ func patchPermission(getPerms getPermsFunc, paramName string) echo.HandlerFunc {
 			}
 		}
 
+		if patchLimits {
+			if toPatch.Type != permission.TypeShareByLink {
+				return jsonapi.BadRequest(errNotShareByLink)
+			}
+			if limits.MaxDownloads != nil {
+				if *limits.MaxDownloads < 0 {
+					return jsonapi.InvalidAttribute("max_downloads", errNegativeMaxDownloads)
+				}
+				toPatch.MaxDownloads = *limits.MaxDownloads
+			}
+			if limits.ViewOnly != nil {
+				toPatch.ViewOnly = *limits.ViewOnly
+			}
+		}
+
 		if patchCodes {
 			toPatch.PatchCodes(patch.Codes)
 		}
@@ -554,6 +617,7 @@ func Routes(router *echo.Group) {
 	router.GET("/:permdocid", showPermissions)
 	router.PATCH("/:permdocid", patchPermission(permission.GetPermissionByIDIncludingExpired, "permdocid"))
 	router.DELETE("/:permdocid", revokePermission)
+	router.GET("/:permdocid/accesses", listShareLinkAccesses)
 
 	router.PATCH("/apps/:slug", patchPermission(permission.GetForWebapp, "slug"))
 	router.PATCH("/konnectors/:slug", patchPermission(permission.GetForKonnector, "slug"))
@@ -1,9 +1,13 @@
 package realtime
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -12,6 +16,7 @@ import (
 	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/couchdb/mango"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
 	"github.com/cozy/cozy-stack/pkg/logger"
 	"github.com/cozy/cozy-stack/pkg/prefixer"
@@ -31,8 +36,13 @@ const (
 	// Send pings to peer with this period (must be less than pongWait)
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer
-	maxMessageSize = 1024
+	// Maximum message size allowed from peer (a SUBSCRIBE can have a selector)
+	maxMessageSize = 4 * 1024
+
+	// The event sent when the missed events can't be replayed
+	eventResync = "RESYNC"
+
+	typeTextEventStream = "text/event-stream"
 )
 
 var upgrader = websocket.Upgrader{
@@ -48,12 +58,19 @@ type command struct {
 	Payload struct {
 		Type string `json:"type"`
 		ID   string `json:"id"`
+		// Selector is a mango selector to receive only the events for the
+		// documents that match it.
+		Selector mango.Map `json:"selector,omitempty"`
+		// Since is the sequence number of the last event received by the
+		// client before a reconnection, to replay the missed events.
+		Since *uint64 `json:"since,omitempty"`
 	} `json:"payload"`
 }
 
 type wsResponsePayload struct {
 	Type string      `json:"type"`
 	ID   string      `json:"id"`
+	Seq  uint64      `json:"seq,omitempty"`
 	Doc  interface{} `json:"doc,omitempty"`
 }
 
@@ -110,6 +127,18 @@ func unknownMethod(method string, cmd interface{}) *wsError {
 	}
 }
 
+func invalidSelector(cmd *command, err error) *wsError {
+	return &wsError{
+		Event: "error",
+		Payload: wsErrorPayload{
+			Status: "400 Bad Request",
+			Code:   "bad request",
+			Title:  fmt.Sprintf("The selector is not valid: %s", err),
+			Source: cmd,
+		},
+	}
+}
+
 func missingType(cmd *command) *wsError {
 	return &wsError{
 		Event: "error",
@@ -122,13 +151,82 @@ func missingType(cmd *command) *wsError {
 	}
 }
 
-func sendErr(ctx context.Context, errc chan *wsError, e *wsError) {
+// send gives a message to the goroutine that writes on the websocket.
+func send(ctx context.Context, outc chan interface{}, msg interface{}) {
 	select {
-	case errc <- e:
+	case outc <- msg:
 	case <-ctx.Done():
 	}
 }
 
+func eventResponse(e *realtime.Event) *wsResponse {
+	return &wsResponse{
+		Event: e.Verb,
+		Payload: wsResponsePayload{
+			Type: e.Doc.DocType(),
+			ID:   e.Doc.ID(),
+			Seq:  e.Seq,
+			Doc:  e.Doc,
+		},
+	}
+}
+
+// resyncResponse is sent to the client when the events it has missed can't be
+// replayed. The sequence number can be used as the new cursor, after the
+// client has fetched again the documents.
+func resyncResponse(doctype, id string, last uint64) *wsResponse {
+	return &wsResponse{
+		Event: eventResync,
+		Payload: wsResponsePayload{
+			Type: doctype,
+			ID:   id,
+			Seq:  last,
+		},
+	}
+}
+
+// subscription is a doctype, or a document if the id is not empty, or the
+// documents that match a selector, that a client listens to.
+type subscription struct {
+	doctype  string
+	id       string
+	selector mango.Map
+}
+
+func (s subscription) matches(e *realtime.Event) bool {
+	if e.Doc.DocType() != s.doctype {
+		return false
+	}
+	if s.id != "" {
+		return e.Doc.ID() == s.id
+	}
+	return s.selector == nil || realtime.MatchSelector(e, s.selector)
+}
+
+// replay returns the events for the subscriptions that have been missed by
+// the client since the given sequence number. The permissions are checked if
+// the instance is not nil.
+func replay(i *instance.Instance, perms permission.Set, db prefixer.Prefixer,
+	subs []subscription, since uint64) ([]*realtime.Event, uint64, error) {
+	events, last, err := realtime.GetHub().Replay(db, since)
+	if err != nil {
+		return nil, last, err
+	}
+	var kept []*realtime.Event
+	for _, e := range events {
+		if i != nil && !AllowedEvent(i, perms, e) {
+			continue
+		}
+		for _, sub := range subs {
+			if sub.matches(e) {
+				kept = append(kept, e)
+				break
+			}
+		}
+	}
+	return kept, last, nil
+}
+
 func authorized(i *instance.Instance, perms permission.Set, permType, id string) bool {
 	if perms.AllowWholeType(permission.GET, permType) {
 		return true
@@ -148,9 +246,112 @@ func authorized(i *instance.Instance, perms permission.Set, permType, id string)
 	}
 }
 
+// noPermissionRequired returns true for the synthetic doctypes that can be
+// listened to without permissions.
+func noPermissionRequired(doctype string) bool {
+	// XXX: no permissions are required for io.cozy.sharings.initial_sync
+	// and io.cozy.auth.confirmations
+	return doctype == consts.SharingsInitialSync || doctype == consts.AuthConfirmations
+}
+
+// permTypeAndID returns the doctype and id to check in the permissions for
+// listening to the events of a doctype (and id if not empty).
+func permTypeAndID(doctype, id string) (string, string) {
+	// XXX: thumbnails is a synthetic doctype, listening to its events
+	// requires a permissions on io.cozy.files. Same for note events.
+	if doctype == consts.Thumbnails || doctype == consts.NotesEvents {
+		doctype = consts.Files
+	}
+	// XXX: the passphrase settings document is synthetic, and a
+	// permission on the instance settings is enough to watch it.
+	if doctype == consts.Settings && id == consts.PassphraseParametersID {
+		id = consts.InstanceSettingsID
+	}
+	return doctype, id
+}
+
+// canSubscribe returns true if the permissions allow to listen to the events
+// for the given subscription. For a selector, a permission on some documents
+// of the doctype is enough, as the events are then checked one by one.
+func canSubscribe(i *instance.Instance, perms permission.Set, sub subscription) bool {
+	if noPermissionRequired(sub.doctype) {
+		return true
+	}
+	permType, permID := permTypeAndID(sub.doctype, sub.id)
+	if sub.selector != nil && permID == "" {
+		return perms.Some(func(r permission.Rule) bool {
+			return r.Verbs.Contains(permission.GET) && permission.MatchType(r, permType)
+		})
+	}
+	return authorized(i, perms, permType, permID)
+}
+
+// AllowedEvent returns true if the permissions allow to read the document of
+// the event. It is needed as a client can subscribe with a selector while
+// having a permission on only some documents of the doctype.
+func AllowedEvent(i *instance.Instance, perms permission.Set, e *realtime.Event) bool {
+	doctype := e.Doc.DocType()
+	if noPermissionRequired(doctype) {
+		return true
+	}
+	permType, permID := permTypeAndID(doctype, e.Doc.ID())
+	if perms.AllowWholeType(permission.GET, permType) {
+		return true
+	}
+	if permType == consts.Files {
+		if doctype != consts.Files {
+			return authorized(i, perms, permType, permID)
+		}
+		fetcher := fileFetcher(e.Doc)
+		return fetcher != nil && vfs.Allows(i.VFS(), perms, permission.GET, fetcher) == nil
+	}
+	if perms.AllowID(permission.GET, permType, permID) {
+		return true
+	}
+	m := realtime.DocAsMap(e.Doc)
+	if m == nil {
+		return false
+	}
+	return perms.Allow(permission.GET, &couchdb.JSONDoc{M: m, Type: permType})
+}
+
+// CanSubscribe returns true if the permissions allow to listen to the events
+// of a doctype, or of a single document if id is not empty. It is also used
+// for the subscriptions of the GraphQL gateway.
+func CanSubscribe(i *instance.Instance, perms permission.Set, doctype, id string) bool {
+	return canSubscribe(i, perms, subscription{doctype: doctype, id: id})
+}
+
+// fileFetcher returns the file or directory of an event, as it can be a
+// document from the VFS or a JSON document when it comes from redis.
+func fileFetcher(doc realtime.Doc) vfs.Fetcher {
+	switch d := doc.(type) {
+	case *vfs.FileDoc:
+		return d
+	case *vfs.DirDoc:
+		return d
+	}
+	buf, err := json.Marshal(doc)
+	if err != nil {
+		return nil
+	}
+	var fd vfs.DirOrFileDoc
+	if err := json.Unmarshal(buf, &fd); err != nil {
+		return nil
+	}
+	dir, file := fd.Refine()
+	if dir != nil {
+		return dir
+	}
+	if file != nil {
+		return file
+	}
+	return nil
+}
+
 func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *websocket.Conn,
-	ds *realtime.Subscriber, errc chan *wsError, withAuthentication bool) {
-	defer close(errc)
+	ds *realtime.Subscriber, outc chan interface{}, withAuthentication bool) {
+	defer close(outc)
 
 	var err error
 	var pdoc *permission.Permission
@@ -158,22 +359,25 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 	if withAuthentication {
 		var auth map[string]string
 		if err = ws.ReadJSON(&auth); err != nil {
-			sendErr(ctx, errc, unknownMethod(auth["method"], auth))
+			send(ctx, outc, unknownMethod(auth["method"], auth))
 			return
 		}
 		if strings.ToUpper(auth["method"]) != "AUTH" {
-			sendErr(ctx, errc, unknownMethod(auth["method"], auth))
+			send(ctx, outc, unknownMethod(auth["method"], auth))
 			return
 		}
 		if auth["payload"] == "" {
-			sendErr(ctx, errc, unauthorized(auth))
+			send(ctx, outc, unauthorized(auth))
 			return
 		}
 		pdoc, err = middlewares.ParseJWT(c, i, auth["payload"])
 		if err != nil {
-			sendErr(ctx, errc, unauthorized(auth))
+			send(ctx, outc, unauthorized(auth))
 			return
 		}
+		// The permissions are given to the writer to check them on each
+		// event.
+		send(ctx, outc, pdoc.Permissions)
 	}
 
 	for {
@@ -190,52 +394,81 @@ func readPump(ctx context.Context, c echo.Context, i *instance.Instance, ws *web
 
 		method := strings.ToUpper(cmd.Method)
 		if method != "SUBSCRIBE" && method != "UNSUBSCRIBE" {
-			sendErr(ctx, errc, unknownMethod(cmd.Method, cmd))
+			send(ctx, outc, unknownMethod(cmd.Method, cmd))
 			continue
 		}
 		if cmd.Payload.Type == "" {
-			sendErr(ctx, errc, missingType(cmd))
+			send(ctx, outc, missingType(cmd))
 			continue
 		}
-		permType := cmd.Payload.Type
-		permID := cmd.Payload.ID
-		// XXX: thumbnails is a synthetic doctype, listening to its events
-		// requires a permissions on io.cozy.files. Same for note events.
-		if permType == consts.Thumbnails || permType == consts.NotesEvents {
-			permType = consts.Files
+		sub := subscription{
+			doctype:  cmd.Payload.Type,
+			id:       cmd.Payload.ID,
+			selector: cmd.Payload.Selector,
 		}
-		// XXX: the passphrase settings document is synthetic, and a
-		// permission on the instance settings is enough to watch it.
-		if permType == consts.Settings && permID == consts.PassphraseParametersID {
-			permID = consts.InstanceSettingsID
-		}
-		// XXX: no permissions are required for io.cozy.sharings.initial_sync
-		// and io.cozy.auth.confirmations
-		if withAuthentication &&
-			cmd.Payload.Type != consts.SharingsInitialSync &&
-			cmd.Payload.Type != consts.AuthConfirmations {
-			if !authorized(i, pdoc.Permissions, permType, permID) {
-				sendErr(ctx, errc, forbidden(cmd))
-				continue
-			}
+		if withAuthentication && !canSubscribe(i, pdoc.Permissions, sub) {
+			send(ctx, outc, forbidden(cmd))
+			continue
 		}
 
 		if method == "SUBSCRIBE" {
-			if cmd.Payload.ID == "" {
-				ds.Subscribe(cmd.Payload.Type)
-			} else {
-				ds.Watch(cmd.Payload.Type, cmd.Payload.ID)
+			switch {
+			case sub.id != "":
+				ds.Watch(sub.doctype, sub.id)
+			case sub.selector != nil:
+				if err := ds.SubscribeSelector(sub.doctype, sub.selector); err != nil {
+					send(ctx, outc, invalidSelector(cmd, err))
+					continue
+				}
+			default:
+				ds.Subscribe(sub.doctype)
+			}
+			if cmd.Payload.Since != nil {
+				var perms permission.Set
+				if withAuthentication {
+					perms = pdoc.Permissions
+				}
+				replayEvents(ctx, i, perms, ds, sub, *cmd.Payload.Since, outc)
 			}
 		} else if method == "UNSUBSCRIBE" {
-			if cmd.Payload.ID == "" {
-				ds.Unsubscribe(cmd.Payload.Type)
-			} else {
-				ds.Unwatch(cmd.Payload.Type, cmd.Payload.ID)
+			switch {
+			case sub.id != "":
+				ds.Unwatch(sub.doctype, sub.id)
+			case sub.selector != nil:
+				ds.UnsubscribeSelector(sub.doctype, sub.selector)
+			default:
+				ds.Unsubscribe(sub.doctype)
 			}
 		}
 	}
 }
 
+// replayEvents sends to the client the events it has missed since its last
+// connection, or asks it to resync if they are no longer available. The
+// subscription is made before, so an event can be sent twice: the clients
+// should ignore the events with a sequence number they have already seen.
+func replayEvents(ctx context.Context, i *instance.Instance, perms permission.Set,
+	ds *realtime.Subscriber, sub subscription, since uint64, outc chan interface{}) {
+	events, last, err := replay(i, perms, ds, []subscription{sub}, since)
+	if err != nil {
+		logReplayError(ds, err)
+		send(ctx, outc, resyncResponse(sub.doctype, sub.id, last))
+		return
+	}
+	for _, e := range events {
+		send(ctx, outc, eventResponse(e))
+	}
+}
+
+func logReplayError(db prefixer.Prefixer, err error) {
+	if !errors.Is(err, realtime.ErrResyncRequired) {
+		logger.
+			WithDomain(db.DomainName()).
+			WithNamespace("realtime").
+			Warnf("Cannot replay events: %s", err)
+	}
+}
+
 // Ws is the API handler for realtime via a websocket connection.
 func Ws(c echo.Context) error {
 	var db prefixer.Prefixer
@@ -268,37 +501,37 @@ func Ws(c echo.Context) error {
 	defer ds.Close()
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	errc := make(chan *wsError)
-	go readPump(ctx, c, inst, ws, ds, errc, withAuthentication)
+	outc := make(chan interface{})
+	go readPump(ctx, c, inst, ws, ds, outc, withAuthentication)
 
 	ticker := time.NewTicker(pingPeriod)
 	defer ticker.Stop()
 
+	var perms permission.Set
 	for {
 		select {
-		case e, ok := <-errc:
+		case msg, ok := <-outc:
 			if !ok { // Websocket has been closed by the client
 				return nil
 			}
+			if set, ok := msg.(permission.Set); ok {
+				perms = set
+				continue
+			}
 			if err := ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 				return nil
 			}
-			if err := ws.WriteJSON(e); err != nil {
+			if err := ws.WriteJSON(msg); err != nil {
 				return nil
 			}
 		case e := <-ds.Channel:
+			if withAuthentication && !AllowedEvent(inst, perms, e) {
+				continue
+			}
 			if err := ws.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
 				return err
 			}
-			res := wsResponse{
-				Event: e.Verb,
-				Payload: wsResponsePayload{
-					Type: e.Doc.DocType(),
-					ID:   e.Doc.ID(),
-					Doc:  e.Doc,
-				},
-			}
-			if err := ws.WriteJSON(res); err != nil {
+			if err := ws.WriteJSON(eventResponse(e)); err != nil {
 				return nil
 			}
 		case <-ticker.C:
@@ -312,6 +545,137 @@ func Ws(c echo.Context) error {
 	}
 }
 
+// SSE is the API handler for realtime via Server-Sent Events, for the clients
+// that can't use websockets. The subscriptions are given in the query-string,
+// and the Last-Event-ID header (or the since parameter) can be used to replay
+// the events missed while reconnecting.
+func SSE(c echo.Context) error {
+	var db prefixer.Prefixer
+	var perms permission.Set
+
+	// Like for the websockets, no authentication is needed when there is no
+	// instance (the administration server).
+	inst, withAuthentication := middlewares.GetInstanceSafe(c)
+	if !withAuthentication {
+		db = prefixer.GlobalPrefixer
+	} else {
+		pdoc, err := middlewares.GetPermission(c)
+		if err != nil {
+			return middlewares.ErrForbidden
+		}
+		perms = pdoc.Permissions
+		db = inst
+	}
+
+	params := c.QueryParams()["subscribe"]
+	if len(params) == 0 {
+		return jsonapi.BadRequest(errors.New("The subscribe parameter is mandatory"))
+	}
+	subs := make([]subscription, 0, len(params))
+	for _, param := range params {
+		doctype, id, _ := strings.Cut(param, "/")
+		if doctype == "" {
+			return jsonapi.InvalidParameter("subscribe", errors.New("The doctype is missing"))
+		}
+		sub := subscription{doctype: doctype, id: id}
+		if withAuthentication && !canSubscribe(inst, perms, sub) {
+			return jsonapi.Forbidden(fmt.Errorf("The application can't subscribe to %s", doctype))
+		}
+		subs = append(subs, sub)
+	}
+
+	var since *uint64
+	cursor := c.Request().Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = c.QueryParam("since")
+	}
+	if cursor != "" {
+		seq, err := strconv.ParseUint(cursor, 10, 64)
+		if err != nil {
+			return jsonapi.InvalidParameter("since", err)
+		}
+		since = &seq
+	}
+
+	ds := realtime.GetHub().Subscriber(db)
+	defer ds.Close()
+	for _, sub := range subs {
+		if sub.id == "" {
+			ds.Subscribe(sub.doctype)
+		} else {
+			ds.Watch(sub.doctype, sub.id)
+		}
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, typeTextEventStream)
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.WriteHeader(http.StatusOK)
+	w.Flush()
+
+	if since != nil {
+		events, last, err := replay(inst, perms, db, subs, *since)
+		if err != nil {
+			logReplayError(db, err)
+			if err := writeSSE(w, eventResync, last, wsResponsePayload{Seq: last}); err != nil {
+				return nil
+			}
+		}
+		for _, e := range events {
+			if err := writeSSEEvent(w, e); err != nil {
+				return nil
+			}
+		}
+	}
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case e := <-ds.Channel:
+			if withAuthentication && !AllowedEvent(inst, perms, e) {
+				continue
+			}
+			if err := writeSSEEvent(w, e); err != nil {
+				return nil
+			}
+		case <-ticker.C:
+			// A comment line is enough to keep the connection open
+			if _, err := w.Write([]byte(": ping\n\n")); err != nil {
+				return nil
+			}
+			w.Flush()
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+func writeSSEEvent(w *echo.Response, e *realtime.Event) error {
+	return writeSSE(w, e.Verb, e.Seq, eventResponse(e).Payload)
+}
+
+// writeSSE writes an event on the stream. The sequence number is used as the
+// id of the event, so that the browser will send it in the Last-Event-ID
+// header when reconnecting.
+func writeSSE(w *echo.Response, event string, seq uint64, payload wsResponsePayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if seq > 0 {
+		fmt.Fprintf(&buf, "id: %d\n", seq)
+	}
+	fmt.Fprintf(&buf, "event: %s\ndata: %s\n\n", event, data)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	w.Flush()
+	return nil
+}
+
 // Notify is the API handler for POST /realtime/:doctype/:id: this route can be
 // used to send documents in the real-time without having to persist them in
 // CouchDB.
@@ -341,5 +705,6 @@ func Notify(c echo.Context) error {
 // Routes set the routing for the realtime service
 func Routes(router *echo.Group) {
 	router.GET("/", Ws)
+	router.GET("/sse", SSE)
 	router.POST("/:doctype/:id", Notify)
 }
@@ -1,14 +1,20 @@
 package realtime
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/realtime"
 	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type testDoc struct {
@@ -169,6 +175,155 @@ func TestRealtime(t *testing.T) {
 		payload.ValueEqual("id", "bar-two")
 	})
 
+	t.Run("WSSelector", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+		// This token can read only the bills of a vendor
+		_, billsToken := setup.GetTestClient("io.cozy.bills:GET:Energy:vendor")
+
+		ws := e.GET("/realtime/").
+			WithWebsocketUpgrade().
+			Expect().Status(http.StatusSwitchingProtocols).
+			Websocket()
+		defer ws.Disconnect()
+
+		ws.WriteText(fmt.Sprintf(`{"method": "AUTH", "payload": "%s"}`, billsToken))
+
+		obj := ws.WriteText(`{"method": "SUBSCRIBE", "payload": { "type": "io.cozy.bills", "selector": { "amount": { "$foo": 1 } } }}`).
+			Expect().TextMessage().
+			JSON().Object()
+		obj.ValueEqual("event", "error")
+		obj.Value("payload").Object().ValueEqual("status", "400 Bad Request")
+
+		ws.WriteText(`{"method": "SUBSCRIBE", "payload": { "type": "io.cozy.bills", "selector": { "amount": { "$gt": 100 } } }}`)
+		time.Sleep(30 * time.Millisecond)
+
+		h := realtime.GetHub()
+		bill := func(id, vendor string, amount float64) *couchdb.JSONDoc {
+			return &couchdb.JSONDoc{
+				Type: "io.cozy.bills",
+				M:    map[string]interface{}{"_id": id, "vendor": vendor, "amount": amount},
+			}
+		}
+		// Not matched by the selector
+		h.Publish(inst, realtime.EventCreate, bill("bill-one", "Energy", 50), nil)
+		// Not allowed by the permissions
+		h.Publish(inst, realtime.EventCreate, bill("bill-two", "Water", 150), nil)
+		h.Publish(inst, realtime.EventCreate, bill("bill-three", "Energy", 250), nil)
+
+		obj = ws.Expect().TextMessage().JSON().Object()
+		obj.ValueEqual("event", "CREATED")
+		payload := obj.Value("payload").Object()
+		payload.ValueEqual("type", "io.cozy.bills")
+		payload.ValueEqual("id", "bill-three")
+	})
+
+	t.Run("WSReplay", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		ws := e.GET("/realtime/").
+			WithWebsocketUpgrade().
+			Expect().Status(http.StatusSwitchingProtocols).
+			Websocket()
+
+		ws.WriteText(fmt.Sprintf(`{"method": "AUTH", "payload": "%s"}`, token))
+
+		// A client without a valid cursor is asked to resync
+		obj := ws.WriteText(`{"method": "SUBSCRIBE", "payload": { "type": "io.cozy.foos", "since": 0 }}`).
+			Expect().TextMessage().
+			JSON().Object()
+		obj.ValueEqual("event", "RESYNC")
+		payload := obj.Value("payload").Object()
+		payload.ValueEqual("type", "io.cozy.foos")
+		cursor := uint64(payload.Value("seq").Number().Raw())
+		ws.Disconnect()
+
+		h := realtime.GetHub()
+		h.Publish(inst, realtime.EventCreate, &testDoc{
+			doctype: "io.cozy.bars",
+			id:      "bar-missed",
+		}, nil)
+		h.Publish(inst, realtime.EventUpdate, &testDoc{
+			doctype: "io.cozy.foos",
+			id:      "foo-missed",
+		}, nil)
+
+		ws = e.GET("/realtime/").
+			WithWebsocketUpgrade().
+			Expect().Status(http.StatusSwitchingProtocols).
+			Websocket()
+		defer ws.Disconnect()
+
+		ws.WriteText(fmt.Sprintf(`{"method": "AUTH", "payload": "%s"}`, token))
+
+		obj = ws.WriteText(fmt.Sprintf(`{"method": "SUBSCRIBE", "payload": { "type": "io.cozy.foos", "since": %d }}`, cursor)).
+			Expect().TextMessage().
+			JSON().Object()
+		obj.ValueEqual("event", "UPDATED")
+		payload = obj.Value("payload").Object()
+		payload.ValueEqual("type", "io.cozy.foos")
+		payload.ValueEqual("id", "foo-missed")
+		payload.ValueEqual("seq", cursor+2)
+	})
+
+	t.Run("SSE", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, ts.URL)
+
+		e.GET("/realtime/sse").
+			WithQuery("subscribe", "io.cozy.contacts").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(403)
+
+		_, cursor, _ := realtime.GetHub().Replay(inst, 0)
+		realtime.GetHub().Publish(inst, realtime.EventDelete, &testDoc{
+			doctype: "io.cozy.foos",
+			id:      "foo-sse",
+		}, nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			ts.URL+"/realtime/sse?subscribe=io.cozy.foos&subscribe=io.cozy.bars/bar-one", nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Last-Event-ID", strconv.FormatUint(cursor, 10))
+		res, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+		scanner := bufio.NewScanner(res.Body)
+		readEvent := func() []string {
+			var lines []string
+			for scanner.Scan() {
+				if scanner.Text() == "" {
+					return lines
+				}
+				lines = append(lines, scanner.Text())
+			}
+			return lines
+		}
+
+		// The missed event is replayed
+		lines := readEvent()
+		require.Len(t, lines, 3)
+		assert.Equal(t, fmt.Sprintf("id: %d", cursor+1), lines[0])
+		assert.Equal(t, "event: DELETED", lines[1])
+		assert.Contains(t, lines[2], `"id":"foo-sse"`)
+
+		time.Sleep(30 * time.Millisecond)
+		realtime.GetHub().Publish(inst, realtime.EventCreate, &testDoc{
+			doctype: "io.cozy.bars",
+			id:      "bar-one",
+		}, nil)
+
+		lines = readEvent()
+		require.Len(t, lines, 3)
+		assert.Equal(t, fmt.Sprintf("id: %d", cursor+2), lines[0])
+		assert.Equal(t, "event: CREATED", lines[1])
+		assert.Contains(t, lines[2], `"type":"io.cozy.bars"`)
+	})
+
 	t.Run("WSNotify", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, ts.URL)
 
@@ -24,9 +24,11 @@ import (
 	"github.com/cozy/cozy-stack/web/conncheck"
 	"github.com/cozy/cozy-stack/web/contacts"
 	"github.com/cozy/cozy-stack/web/data"
+	"github.com/cozy/cozy-stack/web/dav"
 	"github.com/cozy/cozy-stack/web/editor"
 	"github.com/cozy/cozy-stack/web/errors"
 	"github.com/cozy/cozy-stack/web/files"
+	"github.com/cozy/cozy-stack/web/graphql"
 	"github.com/cozy/cozy-stack/web/instances"
 	"github.com/cozy/cozy-stack/web/intents"
 	"github.com/cozy/cozy-stack/web/jobs"
@@ -42,6 +44,7 @@ import (
 	"github.com/cozy/cozy-stack/web/realtime"
 	"github.com/cozy/cozy-stack/web/registry"
 	"github.com/cozy/cozy-stack/web/remote"
+	"github.com/cozy/cozy-stack/web/search"
 	"github.com/cozy/cozy-stack/web/settings"
 	"github.com/cozy/cozy-stack/web/sharings"
 	"github.com/cozy/cozy-stack/web/shortcuts"
@@ -244,6 +247,9 @@ func SetupRoutes(router *echo.Echo, services *stack.Services) error {
 		bitwarden.Routes(router.Group("/bitwarden", mws...))
 		shortcuts.Routes(router.Group("/shortcuts", mws...))
 		ai.Routes(router.Group("/ai", mws...))
+		search.Routes(router.Group("/search", mws...))
+		graphql.Routes(router.Group("/graphql", mws...))
+		dav.Routes(router.Group("/dav", mws...))
 
 		// The settings routes needs not to be blocked
 		apps.WebappsRoutes(router.Group("/apps", mwsNotBlocked...))
@@ -0,0 +1,102 @@
+// Package search is for the /search route, used for the full-text search on
+// the files, the notes and some other documents.
+package search
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/cozy/cozy-stack/model/search"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+var (
+	errMissingQuery = errors.New("The q parameter is mandatory")
+	errInvalidLimit = errors.New("The limit must be a positive integer")
+)
+
+const (
+	defaultLimit = 30
+	maxLimit     = 100
+)
+
+// result is a jsonapi.Object for a document that matches a search query.
+type result struct {
+	Doctype string  `json:"doctype"`
+	DocID   string  `json:"doc_id"`
+	Title   string  `json:"title"`
+	Excerpt string  `json:"excerpt,omitempty"`
+	Score   float64 `json:"score"`
+}
+
+func newResult(hit *search.Hit) *result {
+	return &result{
+		Doctype: hit.Doctype,
+		DocID:   hit.ID,
+		Title:   hit.Title,
+		Excerpt: hit.Excerpt,
+		Score:   hit.Score,
+	}
+}
+
+func (r *result) ID() string                             { return r.Doctype + "/" + r.DocID }
+func (r *result) Rev() string                            { return "" }
+func (r *result) DocType() string                        { return consts.SearchResults }
+func (r *result) SetID(id string)                        {}
+func (r *result) SetRev(id string)                       {}
+func (r *result) Clone() couchdb.Doc                     { panic("search result should not be cloned") }
+func (r *result) Included() []jsonapi.Object             { return nil }
+func (r *result) Relationships() jsonapi.RelationshipMap { return nil }
+func (r *result) Links() *jsonapi.LinksList              { return nil }
+
+var _ jsonapi.Object = (*result)(nil)
+
+// Search is the handler for GET /search. It returns the documents that match
+// the query and that the caller is allowed to read.
+func Search(c echo.Context) error {
+	text := strings.TrimSpace(c.QueryParam("q"))
+	if text == "" {
+		return jsonapi.BadRequest(errMissingQuery)
+	}
+	query := search.Query{Text: text}
+	if doctypes := c.QueryParam("doctypes"); doctypes != "" {
+		query.Doctypes = strings.Split(doctypes, ",")
+	}
+	limit := defaultLimit
+	if param := c.QueryParam("limit"); param != "" {
+		l, err := strconv.Atoi(param)
+		if err != nil || l <= 0 {
+			return jsonapi.InvalidParameter("limit", errInvalidLimit)
+		}
+		limit = l
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	pdoc, err := middlewares.GetPermission(c)
+	if err != nil {
+		return middlewares.ErrForbidden
+	}
+	inst := middlewares.GetInstance(c)
+	hits, err := search.Search(inst, pdoc.Permissions, query, limit)
+	if err != nil {
+		return jsonapi.InternalServerError(err)
+	}
+	objs := make([]jsonapi.Object, len(hits))
+	for i, hit := range hits {
+		objs[i] = newResult(hit)
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// Routes sets the routing for the search.
+func Routes(router *echo.Group) {
+	router.GET("", Search)
+}
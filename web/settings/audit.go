@@ -0,0 +1,28 @@
+package settings
+
+import (
+	"github.com/cozy/cozy-stack/model/audit"
+	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+// listAuditEntries returns a page of the audit log, the most recent entries
+// first.
+func (h *HTTPHandler) listAuditEntries(c echo.Context) error {
+	if err := middlewares.AllowWholeType(c, permission.GET, consts.Audit); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+
+	opts, err := middlewares.AuditListOptions(c)
+	if err != nil {
+		return err
+	}
+	entries, bookmark, err := audit.List(inst, opts)
+	if err != nil {
+		return err
+	}
+	return middlewares.AuditEntries(c, "/settings/audit", opts, entries, bookmark)
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/feature"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/oauth"
@@ -96,6 +97,7 @@ func (h *HTTPHandler) revokeClient(c echo.Context) error {
 	if err := client.Delete(instance); err != nil {
 		return errors.New(err.Error)
 	}
+	middlewares.Audit(c, instance, audit.OAuthClientRevoked, middlewares.OAuthClientAuditDetails(client))
 	return c.NoContent(http.StatusNoContent)
 }
 
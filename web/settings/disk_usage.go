@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/cozy/cozy-stack/model/permission"
+	"github.com/cozy/cozy-stack/model/vfs"
 	"github.com/cozy/cozy-stack/pkg/consts"
 	"github.com/cozy/cozy-stack/pkg/couchdb"
 	"github.com/cozy/cozy-stack/pkg/jsonapi"
@@ -14,11 +15,13 @@ import (
 )
 
 type apiDiskUsage struct {
-	Used     int64  `json:"used,string"`
-	Quota    int64  `json:"quota,string,omitempty"`
-	Files    int64  `json:"files,string"`
-	Trash    *int64 `json:"trash,string,omitempty"`
-	Versions int64  `json:"versions,string"`
+	Used         int64  `json:"used,string"`
+	Quota        int64  `json:"quota,string,omitempty"`
+	Files        int64  `json:"files,string"`
+	Trash        *int64 `json:"trash,string,omitempty"`
+	Versions     int64  `json:"versions,string"`
+	Stored       *int64 `json:"stored,string,omitempty"`
+	Deduplicated *int64 `json:"deduplicated,string,omitempty"`
 }
 
 func (j *apiDiskUsage) ID() string                             { return consts.DiskUsageID }
@@ -80,6 +83,18 @@ func (h *HTTPHandler) diskUsage(c echo.Context) error {
 	used := files + versions
 	quota := fs.DiskQuota()
 
+	// With deduplication, the contents shared by several files and versions
+	// are stored only once, and counted only once for the quota.
+	if vfs.DeduplicationEnabled() {
+		saved, err := fs.DeduplicatedUsage()
+		if err != nil {
+			return err
+		}
+		stored := used - saved
+		result.Stored = &stored
+		result.Deduplicated = &saved
+	}
+
 	result.Used = used
 	result.Quota = quota
 	result.Files = files
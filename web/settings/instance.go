@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
 	"github.com/cozy/cozy-stack/model/permission"
@@ -230,12 +231,21 @@ func (h *HTTPHandler) updateInstanceAuthMode(c echo.Context) error {
 		if ok := inst.ValidateMailConfirmationCode(args.TwoFactorActivationCode); !ok {
 			return c.NoContent(http.StatusUnprocessableEntity)
 		}
+	case instance.TwoFactorWebAuthn:
+		if !inst.HasWebAuthnCredentials(false) {
+			return jsonapi.Errorf(http.StatusUnprocessableEntity, "A passkey must be registered first")
+		}
+	case instance.TwoFactorTOTP:
+		if !inst.HasTOTP() {
+			return jsonapi.Errorf(http.StatusUnprocessableEntity, "An authenticator app must be enrolled first")
+		}
 	}
 
 	err = lifecycle.Patch(inst, &lifecycle.Options{AuthMode: args.AuthMode})
 	if err != nil {
 		return err
 	}
+	middlewares.Audit(c, inst, audit.AuthModeUpdated, map[string]interface{}{"auth_mode": args.AuthMode})
 
 	return c.NoContent(http.StatusNoContent)
 }
@@ -10,6 +10,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
@@ -300,6 +301,7 @@ func (h *HTTPHandler) updatePassphrase(c echo.Context) error {
 		if err != nil {
 			return err
 		}
+		middlewares.Audit(c, inst, audit.PassphraseUpdated, map[string]interface{}{"forced": true})
 		go func() {
 			_ = sharing.SendPublicKey(inst, params.PublicKey)
 		}()
@@ -310,10 +312,13 @@ func (h *HTTPHandler) updatePassphrase(c echo.Context) error {
 	}
 
 	// Else, we keep going on the standard checks (2FA, current passphrase, ...)
-	if inst.HasAuthMode(instance.TwoFactorMail) && len(args.TwoFactorToken) == 0 {
+	if inst.HasTwoFactor() && len(args.TwoFactorToken) == 0 {
 		if instance.CheckPassphrase(inst, currentPassphrase) == nil {
 			var twoFactorToken []byte
 			twoFactorToken, err = lifecycle.SendTwoFactorPasscode(inst)
+			if errors.Is(err, instance.ErrWebAuthnRequired) {
+				return jsonapi.Forbidden(err)
+			}
 			if err != nil {
 				return err
 			}
@@ -343,6 +348,7 @@ func (h *HTTPHandler) updatePassphrase(c echo.Context) error {
 	if err != nil {
 		return jsonapi.BadRequest(err)
 	}
+	middlewares.Audit(c, inst, audit.PassphraseUpdated, nil)
 
 	duration := session.LongRun
 	if hasSession {
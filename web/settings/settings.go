@@ -359,6 +359,19 @@ func (h *HTTPHandler) Register(router *echo.Group) {
 
 	router.GET("/flags", h.getFlags)
 
+	router.GET("/webauthn/credentials", h.listWebAuthnCredentials)
+	router.POST("/webauthn/registrations", h.startWebAuthnRegistration)
+	router.POST("/webauthn/credentials", h.finishWebAuthnRegistration)
+	router.DELETE("/webauthn/credentials/:id", h.deleteWebAuthnCredential)
+
+	router.GET("/totp", h.getTOTP)
+	router.POST("/totp/enrollment", h.startTOTPEnrollment)
+	router.POST("/totp", h.finishTOTPEnrollment)
+	router.POST("/totp/recovery_codes", h.regenerateTOTPRecoveryCodes)
+	router.DELETE("/totp", h.deleteTOTP)
+
+	router.GET("/audit", h.listAuditEntries)
+
 	router.GET("/sessions", h.getSessions)
 	router.GET("/sessions/current", h.getCurrentSession)
 
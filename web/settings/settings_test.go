@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/bitwarden/settings"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
@@ -771,6 +772,35 @@ func TestSettings(t *testing.T) {
 			Expect().Status(204)
 	})
 
+	t.Run("UpdatePassphraseWithTOTP", func(t *testing.T) {
+		codes := testutils.WithTOTP(t, testInstance)
+		mails := testutils.CountMails(t, testInstance, "two_factor")
+		e := testutils.CreateTestClient(t, tsURL)
+
+		twoFactorToken := e.PUT("/settings/passphrase").
+			WithCookie(sessCookie, "connected").
+			WithHeader("Authorization", "Bearer "+token).
+			WithJSON(map[string]interface{}{
+				"current_passphrase": "MyLastPassphrase",
+			}).
+			Expect().Status(200).
+			JSON().Object().
+			Value("two_factor_token").String().NotEmpty().Raw()
+
+		// The code comes from the authenticator app, not from a mail
+		assert.Equal(t, mails, testutils.CountMails(t, testInstance, "two_factor"))
+
+		e.PUT("/settings/passphrase").
+			WithCookie(sessCookie, "connected").
+			WithHeader("Authorization", "Bearer "+token).
+			WithJSON(map[string]interface{}{
+				"new_passphrase":      "MyLastPassphrase",
+				"two_factor_token":    twoFactorToken,
+				"two_factor_passcode": codes[0],
+			}).
+			Expect().Status(204)
+	})
+
 	t.Run("ListClients", func(t *testing.T) {
 		e := testutils.CreateTestClient(t, tsURL)
 
@@ -1621,6 +1651,68 @@ func TestSettings(t *testing.T) {
 			assert.Greater(t, reloaded.CommonSettingsVersion, 0)
 		})
 	})
+
+	t.Run("ListAuditEntries", func(t *testing.T) {
+		e := testutils.CreateTestClient(t, tsURL)
+		_, auditToken := setup.GetTestClient(consts.Audit)
+
+		for i := 0; i < 3; i++ {
+			audit.Log(testInstance, &audit.Entry{
+				Event:   "test.listed",
+				Actor:   audit.Actor{Kind: audit.ActorOwner},
+				Details: map[string]interface{}{"index": i},
+			})
+		}
+
+		// Without a token, or without the permission on io.cozy.audit
+		e.GET("/settings/audit").
+			WithCookie(sessCookie, "connected").
+			Expect().Status(401)
+		e.GET("/settings/audit").
+			WithCookie(sessCookie, "connected").
+			WithHeader("Authorization", "Bearer "+token).
+			Expect().Status(403)
+
+		obj := e.GET("/settings/audit").
+			WithQuery("filter[event]", "test.listed").
+			WithQuery("page[limit]", 2).
+			WithCookie(sessCookie, "connected").
+			WithHeader("Authorization", "Bearer "+auditToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+
+		data := obj.Value("data").Array()
+		data.Length().IsEqual(2)
+		first := data.Value(0).Object()
+		first.HasValue("type", consts.Audit)
+		attrs := first.Value("attributes").Object()
+		attrs.HasValue("event", "test.listed")
+		attrs.Value("actor").Object().HasValue("kind", audit.ActorOwner)
+		// The most recent entries come first
+		attrs.Value("details").Object().HasValue("index", 2)
+
+		next := obj.Path("$.links.next").String()
+		next.HasPrefix("/settings/audit?")
+		next.Contains("filter%5Bevent%5D=test.listed")
+
+		obj = e.GET(next.Raw()).
+			WithCookie(sessCookie, "connected").
+			WithHeader("Authorization", "Bearer "+auditToken).
+			Expect().Status(200).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object()
+		data = obj.Value("data").Array()
+		data.Length().IsEqual(1)
+		data.Value(0).Path("$.attributes.details.index").IsEqual(0)
+		obj.Path("$.links").Object().NotContainsKey("next")
+
+		e.GET("/settings/audit").
+			WithQuery("page[limit]", "foo").
+			WithCookie(sessCookie, "connected").
+			WithHeader("Authorization", "Bearer "+auditToken).
+			Expect().Status(400)
+	})
 }
 
 func TestRegisterPassphraseForFlagshipApp(t *testing.T) {
@@ -0,0 +1,128 @@
+package settings
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/audit"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *HTTPHandler) getTOTP(c echo.Context) error {
+	if err := middlewares.RequireSettingsApp(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	return c.JSON(http.StatusOK, echo.Map{
+		"enrolled":             inst.HasTOTP(),
+		"enabled":              inst.HasAuthMode(instance.TwoFactorTOTP),
+		"recovery_codes_count": len(inst.TOTPRecoveryCodes),
+	})
+}
+
+// startTOTPEnrollment returns a new secret for an authenticator app, with its
+// otpauth:// URI and a QR code, and a token to send back with the first code
+// from the app.
+func (h *HTTPHandler) startTOTPEnrollment(c echo.Context) error {
+	if err := middlewares.RequireSettingsApp(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	enrollment, err := inst.GenerateTOTPEnrollment()
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, enrollment)
+}
+
+// finishTOTPEnrollment checks the first code from the authenticator app, and
+// enables the 2FA with this app. The recovery codes are returned, and they
+// won't be shown again.
+func (h *HTTPHandler) finishTOTPEnrollment(c echo.Context) error {
+	if err := middlewares.RequireSettingsApp(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	args := struct {
+		Token      string `json:"token"`
+		Passcode   string `json:"passcode"`
+		Passphrase string `json:"current_passphrase"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := checkCurrentPassphrase(inst, args.Passphrase); err != nil {
+		return err
+	}
+
+	codes, err := lifecycle.EnableTOTP(inst, []byte(args.Token), args.Passcode)
+	if err != nil {
+		if errors.Is(err, instance.ErrInvalidTOTPPasscode) {
+			return jsonapi.Errorf(http.StatusUnprocessableEntity, "%s", err)
+		}
+		return err
+	}
+	middlewares.Audit(c, inst, audit.TOTPEnrolled, nil)
+	return c.JSON(http.StatusCreated, echo.Map{"recovery_codes": codes})
+}
+
+// regenerateTOTPRecoveryCodes replaces the recovery codes, for example when
+// the user has lost them or used most of them.
+func (h *HTTPHandler) regenerateTOTPRecoveryCodes(c echo.Context) error {
+	if err := middlewares.RequireSettingsApp(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	args := struct {
+		Passphrase string `json:"current_passphrase"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := checkCurrentPassphrase(inst, args.Passphrase); err != nil {
+		return err
+	}
+	if !inst.HasTOTP() {
+		return jsonapi.NotFound(errors.New("No authenticator app has been enrolled"))
+	}
+
+	codes, err := lifecycle.RegenerateTOTPRecoveryCodes(inst)
+	if err != nil {
+		return err
+	}
+	middlewares.Audit(c, inst, audit.TOTPRecoveryCodesRenewed, nil)
+	return c.JSON(http.StatusOK, echo.Map{"recovery_codes": codes})
+}
+
+func (h *HTTPHandler) deleteTOTP(c echo.Context) error {
+	if err := middlewares.RequireSettingsApp(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	if !inst.HasTOTP() {
+		return jsonapi.NotFound(errors.New("No authenticator app has been enrolled"))
+	}
+	// The authenticator app cannot be removed while it is used for 2FA
+	if inst.HasAuthMode(instance.TwoFactorTOTP) {
+		return jsonapi.Conflict(errors.New("The authenticator app is needed for the two factor authentication"))
+	}
+	if err := lifecycle.ResetTOTP(inst); err != nil {
+		return err
+	}
+	middlewares.Audit(c, inst, audit.TOTPRemoved, nil)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func checkCurrentPassphrase(inst *instance.Instance, passphrase string) error {
+	if len(inst.PassphraseHash) == 0 {
+		return nil
+	}
+	if err := instance.CheckPassphrase(inst, []byte(passphrase)); err != nil {
+		return jsonapi.Forbidden(errors.New("Invalid passphrase"))
+	}
+	return nil
+}
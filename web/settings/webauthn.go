@@ -0,0 +1,125 @@
+package settings
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/cozy/cozy-stack/model/audit"
+	"github.com/cozy/cozy-stack/model/instance"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/jsonapi"
+	"github.com/cozy/cozy-stack/pkg/webauthn"
+	"github.com/cozy/cozy-stack/web/middlewares"
+	"github.com/labstack/echo/v4"
+)
+
+func (h *HTTPHandler) listWebAuthnCredentials(c echo.Context) error {
+	if err := middlewares.RequireSettingsApp(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	creds, err := instance.GetWebAuthnCredentials(inst)
+	if err != nil {
+		return err
+	}
+	objs := make([]jsonapi.Object, len(creds))
+	for i, cred := range creds {
+		objs[i] = cred
+	}
+	return jsonapi.DataList(c, http.StatusOK, objs, nil)
+}
+
+// startWebAuthnRegistration returns the options for
+// navigator.credentials.create(), and a token to send back with the response
+// of the authenticator.
+func (h *HTTPHandler) startWebAuthnRegistration(c echo.Context) error {
+	if err := middlewares.RequireSettingsApp(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	args := struct {
+		Passwordless bool `json:"passwordless"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	token, opts, err := inst.WebAuthnCreationOptions(args.Passwordless)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"token":     string(token),
+		"publicKey": opts,
+	})
+}
+
+// finishWebAuthnRegistration checks the response of the authenticator and
+// saves the new credential. The current passphrase is required, as a
+// credential can be used to log in.
+func (h *HTTPHandler) finishWebAuthnRegistration(c echo.Context) error {
+	if err := middlewares.RequireSettingsApp(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	args := struct {
+		Token        string `json:"token"`
+		Name         string `json:"name"`
+		Passwordless bool   `json:"passwordless"`
+		Passphrase   string `json:"current_passphrase"`
+		Credential   struct {
+			ID       string                       `json:"id"`
+			Response webauthn.AttestationResponse `json:"response"`
+		} `json:"credential"`
+	}{}
+	if err := c.Bind(&args); err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	if err := checkCurrentPassphrase(inst, args.Passphrase); err != nil {
+		return err
+	}
+
+	cred, err := inst.RegisterWebAuthnCredential(args.Name, []byte(args.Token), &args.Credential.Response, args.Passwordless)
+	if err != nil {
+		if errors.Is(err, webauthn.ErrUnsupportedKey) {
+			return jsonapi.BadRequest(err)
+		}
+		return jsonapi.Errorf(http.StatusUnprocessableEntity, "%s", err)
+	}
+	middlewares.Audit(c, inst, audit.WebAuthnCredentialAdded, map[string]interface{}{
+		"name":         cred.Name,
+		"passwordless": cred.Passwordless,
+	})
+	return jsonapi.Data(c, http.StatusCreated, cred, nil)
+}
+
+func (h *HTTPHandler) deleteWebAuthnCredential(c echo.Context) error {
+	if err := middlewares.RequireSettingsApp(c); err != nil {
+		return err
+	}
+	inst := middlewares.GetInstance(c)
+	creds, err := instance.GetWebAuthnCredentials(inst)
+	if err != nil {
+		return err
+	}
+	var cred *instance.WebAuthnCredential
+	for _, doc := range creds {
+		if doc.ID() == c.Param("id") {
+			cred = doc
+		}
+	}
+	if cred == nil {
+		return jsonapi.NotFound(errors.New("Credential not found"))
+	}
+	// The last credential cannot be removed while it is used for 2FA
+	if len(creds) == 1 && inst.HasAuthMode(instance.TwoFactorWebAuthn) {
+		return jsonapi.Conflict(errors.New("The last credential is needed for the two factor authentication"))
+	}
+	if err := inst.DeleteWebAuthnCredential(cred); err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return jsonapi.NotFound(err)
+		}
+		return err
+	}
+	middlewares.Audit(c, inst, audit.WebAuthnCredentialRemoved, map[string]interface{}{"name": cred.Name})
+	return c.NoContent(http.StatusNoContent)
+}
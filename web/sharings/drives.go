@@ -1,7 +1,9 @@
 package sharings
 
 import (
+	"bytes"
 	"errors"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
@@ -13,6 +15,7 @@ import (
 
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/instance/lifecycle"
+	"github.com/cozy/cozy-stack/model/note"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/sharing"
 	"github.com/cozy/cozy-stack/model/vfs"
@@ -71,6 +74,7 @@ func CreateSharedDrive(c echo.Context) error {
 		FolderID    string `json:"folder_id"`
 		FileID      string `json:"file_id"`
 		Name        string `json:"name"`
+		AccessMode  string `json:"access_mode"`
 	}
 	obj, err := jsonapi.Bind(c.Request().Body, &attrs)
 	if err != nil {
@@ -98,11 +102,23 @@ func CreateSharedDrive(c echo.Context) error {
 		attrs.FolderID = newDir.DocID
 	}
 
+	if !sharing.IsValidAccessMode(attrs.AccessMode) {
+		return jsonapi.InvalidAttribute("access_mode", sharing.ErrInvalidAccessMode)
+	}
+	if attrs.AccessMode == sharing.AccessModeLimitedAccess && attrs.FolderID == "" {
+		return jsonapi.BadRequest(errors.New("folder_id is required for a limited_access drive"))
+	}
+
 	rootID := attrs.FileID
 	if rootID == "" {
 		rootID = attrs.FolderID
 	}
-	newSharing, err := sharing.CreateDrive(inst, rootID, attrs.Description, "")
+	var newSharing *sharing.Sharing
+	if attrs.AccessMode == sharing.AccessModeLimitedAccess {
+		newSharing, err = sharing.CreateLimitedAccessDrive(inst, rootID, attrs.Description, "")
+	} else {
+		newSharing, err = sharing.CreateDrive(inst, rootID, attrs.Description, "")
+	}
 	if err != nil {
 		return wrapDriveRootErrors(err)
 	}
@@ -169,6 +185,8 @@ func wrapDriveRootErrors(err error) error {
 		return jsonapi.Conflict(err)
 	case sharing.ErrSystemFolder, sharing.ErrFileInTrash:
 		return jsonapi.BadRequest(err)
+	case sharing.ErrNoParentDrive, sharing.ErrNotADirectory:
+		return jsonapi.InvalidParameter("folder_id", err)
 	default:
 		return wrapErrors(err)
 	}
@@ -255,6 +273,9 @@ func HeadDirOrFile(c echo.Context, inst *instance.Instance, s *sharing.Sharing)
 
 // ReadMetadataFromPath allows to get file/dir information for a path.
 func ReadMetadataFromPath(c echo.Context, inst *instance.Instance, s *sharing.Sharing) error {
+	if err := checkLimitedAccessToPath(c, inst, s, c.QueryParam("Path"), permission.GET); err != nil {
+		return err
+	}
 	return files.ReadMetadataFromPath(c, s)
 }
 
@@ -267,7 +288,11 @@ func GetDirOrFileData(c echo.Context, inst *instance.Instance, s *sharing.Sharin
 		return err
 	}
 	if dir != nil {
-		return files.DirData(c, http.StatusOK, dir, s)
+		hidden, err := limitedAccessHidden(c, inst, s, dir)
+		if err != nil {
+			return err
+		}
+		return files.DirDataWithHidden(c, http.StatusOK, dir, s, hidden)
 	}
 	return files.FileData(c, http.StatusOK, file, true, nil, s)
 }
@@ -330,6 +355,9 @@ func ModifyMetadataByIDHandler(c echo.Context, inst *instance.Instance, s *shari
 		if err == nil && c.Param("file-id") == rootID {
 			return jsonapi.NewError(http.StatusUnprocessableEntity, "cannot move the root of a shared drive")
 		}
+		if err := checkLimitedAccessTo(c, inst, s, *patch.DirID, permission.PUT); err != nil {
+			return err
+		}
 	}
 	if err = applyPatch(c, inst.VFS(), patch); err != nil {
 		return files.WrapVfsError(err)
@@ -446,7 +474,11 @@ func ChangesFeed(c echo.Context, inst *instance.Instance, s *sharing.Sharing) er
 	if err != nil {
 		return jsonapi.NotFound(errors.New("shared drive not found"))
 	}
-	return files.ChangesFeed(c, inst, sharedDir)
+	hidden, err := limitedAccessHidden(c, inst, s, sharedDir)
+	if err != nil {
+		return err
+	}
+	return files.ChangesFeedWithHidden(c, inst, sharedDir, hidden)
 }
 
 // CopyFile copies a single file from a shared drive to itself using parameters
@@ -458,13 +490,29 @@ func CopyFile(c echo.Context, inst *instance.Instance, s *sharing.Sharing) error
 	if err := ensureDirectoryBackedSharedDrive(s); err != nil {
 		return err
 	}
+	if dirID := c.QueryParam("DirID"); dirID != "" {
+		if err := checkLimitedAccessTo(c, inst, s, dirID, permission.PUT); err != nil {
+			return err
+		}
+	}
 	return files.CopyFile(c, inst, s)
 }
 
+// CreationHandler handles POST requests to create a file or a directory,
+// inside the directory with the file-id, or at the given Path.
 func CreationHandler(c echo.Context, inst *instance.Instance, s *sharing.Sharing) error {
 	if err := ensureDirectoryBackedSharedDrive(s); err != nil {
 		return err
 	}
+	if p := c.QueryParam("Path"); p != "" && c.QueryParam("Type") == consts.DirType {
+		if err := checkLimitedAccessToPath(c, inst, s, p, permission.PUT); err != nil {
+			return err
+		}
+	} else if c.Param("file-id") == "" {
+		if err := checkLimitedAccessTo(c, inst, s, consts.RootDirID, permission.PUT); err != nil {
+			return err
+		}
+	}
 	return files.Create(c, s)
 }
 
@@ -511,6 +559,17 @@ func ThumbnailHandler(c echo.Context, inst *instance.Instance, s *sharing.Sharin
 func FileDownloadCreateHandler(c echo.Context, inst *instance.Instance, s *sharing.Sharing) error {
 	// TODO: The route contract can be broader than the drive root, especially
 	// for owner requests. To fix it, we need explicit shared-drive scope check here.
+	var err error
+	if p := c.QueryParam("Path"); p != "" {
+		err = checkLimitedAccessToPath(c, inst, s, p, permission.GET)
+	} else if id := c.QueryParam("Id"); id != "" {
+		err = checkLimitedAccessTo(c, inst, s, id, permission.GET)
+	} else if versionID := c.QueryParam("VersionId"); versionID != "" {
+		err = checkLimitedAccessTo(c, inst, s, strings.Split(versionID, "/")[0], permission.GET)
+	}
+	if err != nil {
+		return err
+	}
 	return files.FileDownload(c, s)
 }
 
@@ -525,6 +584,18 @@ func ArchiveDownloadCreateHandler(c echo.Context, inst *instance.Instance, s *sh
 	if err := ensureDirectoryBackedSharedDrive(s); err != nil {
 		return err
 	}
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	archive := &vfs.Archive{}
+	if _, err := jsonapi.Bind(bytes.NewReader(body), archive); err != nil {
+		return err
+	}
+	if err := checkLimitedAccessToArchive(c, inst, s, archive); err != nil {
+		return err
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
 	return files.ArchiveDownload(c, s)
 }
 
@@ -538,6 +609,18 @@ func CreateNote(c echo.Context, inst *instance.Instance, s *sharing.Sharing) err
 	if err := ensureDirectoryBackedSharedDrive(s); err != nil {
 		return err
 	}
+	body, err := io.ReadAll(c.Request().Body)
+	if err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	doc := &note.Document{}
+	if _, err := jsonapi.Bind(bytes.NewReader(body), doc); err != nil {
+		return err
+	}
+	if err := checkLimitedAccessTo(c, inst, s, doc.DirID, permission.PUT); err != nil {
+		return err
+	}
+	c.Request().Body = io.NopCloser(bytes.NewReader(body))
 	return notes.CreateNote(c)
 }
 
@@ -567,6 +650,9 @@ func OpenNoteURL(c echo.Context) error {
 		return jsonapi.NotFound(errors.New("not a drive"))
 	}
 	if s.Owner {
+		if err := checkLimitedAccess(c, inst, s); err != nil {
+			return err
+		}
 		return notes.OpenNoteURL(c)
 	}
 
@@ -602,6 +688,9 @@ func OpenOffice(c echo.Context) error {
 		return jsonapi.NotFound(errors.New("not a drive"))
 	}
 	if s.Owner {
+		if err := checkLimitedAccess(c, inst, s); err != nil {
+			return err
+		}
 		return office.Open(c)
 	}
 
@@ -637,6 +726,9 @@ func OpenEditor(c echo.Context) error {
 		return jsonapi.NotFound(errors.New("not a drive"))
 	}
 	if s.Owner {
+		if err := checkLimitedAccess(c, inst, s); err != nil {
+			return err
+		}
 		return editor.OpenURL(c)
 	}
 
@@ -674,6 +766,9 @@ func CreateSharedDriveShareByLinkHandler(c echo.Context, inst *instance.Instance
 			if err := validateSharedDrivePermission(c, inst, s, perms); err != nil {
 				return err
 			}
+			if err := checkLimitedAccessToPermission(c, inst, s, perms); err != nil {
+				return err
+			}
 			return ensureNoSharedDriveShareByLinkConflict(inst, perms)
 		},
 	}
@@ -741,6 +836,21 @@ func validateSharedDrivePermission(c echo.Context, inst *instance.Instance, s *s
 	return validateSharedDrivePermissionTargetID(c, inst, s, fileID)
 }
 
+// checkLimitedAccessToPermission checks that the member can create a share
+// by link on the target of the permission set: read access is needed for a
+// read-only link, and write access for the others.
+func checkLimitedAccessToPermission(c echo.Context, inst *instance.Instance, s *sharing.Sharing, perms permission.Set) error {
+	fileID, err := getSharedDrivePermissionTargetID(perms)
+	if err != nil {
+		return jsonapi.BadRequest(err)
+	}
+	verb := permission.PUT
+	if isSharedDrivePermissionSetReadOnly(perms) {
+		verb = permission.GET
+	}
+	return checkLimitedAccessTo(c, inst, s, fileID, verb)
+}
+
 func getSharedDrivePermissionTargetID(perms permission.Set) (string, error) {
 	if len(perms) != 1 {
 		return "", errors.New("shared drive permissions must target exactly one file or folder")
@@ -1383,6 +1493,9 @@ func proxy(fn func(c echo.Context, inst *instance.Instance, s *sharing.Sharing)
 		}
 
 		if s.Owner {
+			if err := checkLimitedAccess(c, inst, s); err != nil {
+				return err
+			}
 			return fn(c, inst, s)
 		}
 
@@ -1425,6 +1538,9 @@ func proxy(fn func(c echo.Context, inst *instance.Instance, s *sharing.Sharing)
 			middlewares.ForcePermission(c, nil)
 			c.Set("claims", nil)
 			middlewares.SetInstance(c, owner)
+			if err := checkLimitedAccess(c, owner, s); err != nil {
+				return err
+			}
 			return fn(c, owner, s)
 		}
 
@@ -1445,6 +1561,195 @@ func proxy(fn func(c echo.Context, inst *instance.Instance, s *sharing.Sharing)
 	}
 }
 
+// ownedSharedDrive returns the sharing document of the owner for the shared
+// drive. When a handler is called directly for a recipient on the same stack,
+// the given sharing is the one of the recipient, and the owner's document is
+// loaded from the owner's instance.
+func ownedSharedDrive(inst *instance.Instance, s *sharing.Sharing) *sharing.Sharing {
+	if s.Owner {
+		return s
+	}
+	owned, err := sharing.FindSharing(inst, s.SID)
+	if err != nil || !owned.Owner {
+		return nil
+	}
+	return owned
+}
+
+// sharedDriveCallerMember returns the member of the shared drive that makes
+// the request with a shared-drive token, on the owner's instance. It returns
+// nil for the other requests, like those from the owner itself. When the
+// request is made with a shared-drive token whose member can't be found, the
+// access is denied, as the limited_access boundaries can't be checked.
+func sharedDriveCallerMember(c echo.Context, inst *instance.Instance, s *sharing.Sharing) (*sharing.Member, error) {
+	perm, err := middlewares.GetPermission(c)
+	if err != nil || perm.Type != permission.TypeShareInteract {
+		return nil, nil
+	}
+	token := middlewares.GetRequestToken(c)
+	if token == "" {
+		return nil, jsonapi.Forbidden(sharing.ErrLimitedAccessDenied)
+	}
+	member, err := s.FindMemberByInteractCode(inst, token)
+	if err != nil || member == nil {
+		return nil, jsonapi.Forbidden(sharing.ErrLimitedAccessDenied)
+	}
+	return member, nil
+}
+
+// limitedAccessMember returns the sharing of the owner and the member for
+// whom the limited_access boundaries must be enforced, or a nil member when
+// there is nothing to enforce.
+func limitedAccessMember(c echo.Context, inst *instance.Instance, s *sharing.Sharing) (*sharing.Sharing, *sharing.Member, error) {
+	s = ownedSharedDrive(inst, s)
+	if s == nil {
+		return nil, nil, nil
+	}
+	member, err := sharedDriveCallerMember(c, inst, s)
+	if err != nil || member == nil {
+		return nil, nil, err
+	}
+	return s, member, nil
+}
+
+// checkLimitedAccess enforces the limited_access boundaries inside a shared
+// drive for the requests made by a member on a file or folder: when the
+// target is inside a boundary, the member must be invited in the sharing of
+// this boundary, with write access for the requests that modify it. For the
+// routes without a file-id, the handler checks its own inputs.
+func checkLimitedAccess(c echo.Context, inst *instance.Instance, s *sharing.Sharing) error {
+	fileID := c.Param("file-id")
+	if fileID == "" {
+		return nil
+	}
+	verb := permission.GET
+	if _, requireWrite := sharedDrivePermissionCheck(c.Request().Method, c.Request().URL.Path); requireWrite {
+		verb = permission.PUT
+	}
+	return checkLimitedAccessTo(c, inst, s, fileID, verb)
+}
+
+// checkLimitedAccessTo checks that the member who makes the request can use
+// the verb on the target, if it is inside a limited_access boundary.
+func checkLimitedAccessTo(c echo.Context, inst *instance.Instance, s *sharing.Sharing, targetID string, verb permission.Verb) error {
+	_, member, err := limitedAccessMember(c, inst, s)
+	if err != nil || member == nil {
+		return err
+	}
+	return checkMemberLimitedAccessTo(inst, member, targetID, verb)
+}
+
+func checkMemberLimitedAccessTo(inst *instance.Instance, member *sharing.Member, targetID string, verb permission.Verb) error {
+	ea, err := sharing.NewAccessResolverForMember(inst, member).Resolve(targetID)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return files.WrapVfsError(err)
+	}
+	if ea.Boundary == nil {
+		return nil
+	}
+	if !ea.Can(verb) {
+		return jsonapi.Forbidden(sharing.ErrLimitedAccessDenied)
+	}
+	return nil
+}
+
+// checkLimitedAccessToPath is like checkLimitedAccessTo, but for a path. The
+// nearest file or folder that exists on this path is checked, so that a path
+// where something will be created is checked too.
+func checkLimitedAccessToPath(c echo.Context, inst *instance.Instance, s *sharing.Sharing, p string, verb permission.Verb) error {
+	_, member, err := limitedAccessMember(c, inst, s)
+	if err != nil || member == nil {
+		return err
+	}
+	return checkMemberLimitedAccessToPath(inst, member, p, verb)
+}
+
+func checkMemberLimitedAccessToPath(inst *instance.Instance, member *sharing.Member, p string, verb permission.Verb) error {
+	fs := inst.VFS()
+	for current := path.Clean("/" + p); ; current = path.Dir(current) {
+		dir, file, err := fs.DirOrFileByPath(current)
+		if err == nil {
+			if dir != nil {
+				return checkMemberLimitedAccessTo(inst, member, dir.ID(), verb)
+			}
+			return checkMemberLimitedAccessTo(inst, member, file.ID(), verb)
+		}
+		if !os.IsNotExist(err) {
+			return files.WrapVfsError(err)
+		}
+		if current == "/" {
+			return nil
+		}
+	}
+}
+
+// checkLimitedAccessToArchive checks the files and folders of an archive: the
+// member must be able to read all of them, and the folders can't contain a
+// limited_access boundary where the member is not invited, as their whole
+// content is put in the archive.
+func checkLimitedAccessToArchive(c echo.Context, inst *instance.Instance, s *sharing.Sharing, archive *vfs.Archive) error {
+	owned, member, err := limitedAccessMember(c, inst, s)
+	if err != nil || member == nil {
+		return err
+	}
+	fs := inst.VFS()
+	var dirs []*vfs.DirDoc
+	for _, id := range archive.IDs {
+		if err := checkMemberLimitedAccessTo(inst, member, id, permission.GET); err != nil {
+			return err
+		}
+		if dir, err := fs.DirByID(id); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, p := range archive.Files {
+		if err := checkMemberLimitedAccessToPath(inst, member, p, permission.GET); err != nil {
+			return err
+		}
+		if dir, err := fs.DirByPath(p); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	for _, page := range archive.Pages {
+		if err := checkMemberLimitedAccessTo(inst, member, page.ID, permission.GET); err != nil {
+			return err
+		}
+	}
+	for _, dir := range dirs {
+		tree, err := sharing.LimitedAccessRootsUnder(inst, dir.Fullpath, owned.SID)
+		if err != nil {
+			return wrapErrors(err)
+		}
+		if !tree.AllowsAll(member) {
+			return jsonapi.Forbidden(sharing.ErrLimitedAccessDenied)
+		}
+	}
+	return nil
+}
+
+// limitedAccessHidden returns a function that tells which paths inside the
+// directory cannot be seen by the member who makes the request, because of
+// the limited_access boundaries. It returns nil when nothing is hidden.
+func limitedAccessHidden(c echo.Context, inst *instance.Instance, s *sharing.Sharing, dir *vfs.DirDoc) (func(path string) bool, error) {
+	s, member, err := limitedAccessMember(c, inst, s)
+	if err != nil || member == nil {
+		return nil, err
+	}
+	tree, err := sharing.LimitedAccessRootsUnder(inst, dir.Fullpath, s.SID)
+	if err != nil {
+		return nil, wrapErrors(err)
+	}
+	if tree.IsEmpty() {
+		return nil, nil
+	}
+	return func(path string) bool {
+		return !tree.Allows(path, member)
+	}, nil
+}
+
 func sharedDrivePermissionCheck(method, path string) (shouldCheck bool, requireWrite bool) {
 	if method != http.MethodPost &&
 		method != http.MethodPut &&
@@ -5654,3 +5654,180 @@ func addContactToExistingGroup(t *testing.T, inst *instance.Instance, c *contact
 	}
 	require.NoError(t, couchdb.UpdateDoc(inst, c))
 }
+
+func TestSharedDriveLimitedAccessInputs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	env := setupSharedDrivesEnv(t)
+	eA, _, _ := env.createClients(t)
+
+	// A limited access folder inside the shared drive, where Betty is not
+	// invited
+	secretDirID := createDirectory(t, eA, env.firstRootDirID, "Secret", env.acmeToken)
+	secretFileID := createFile(t, eA, secretDirID, "Secret.txt", env.acmeToken)
+	_, err := sharing.CreateLimitedAccessDrive(env.acme, secretDirID, "", "")
+	require.NoError(t, err)
+	secretPath := "/" + env.rootDirName + "/Secret"
+
+	bettySharing, err := sharing.FindSharing(env.betty, env.firstSharingID)
+	require.NoError(t, err)
+	driveToken := bettySharing.Credentials[0].DriveToken
+
+	t.Run("MetadataByPath", func(t *testing.T) {
+		_, eB, _ := env.createClients(t)
+		eB.GET("/sharings/drives/"+env.firstSharingID+"/metadata").
+			WithQuery("Path", secretPath+"/Secret.txt").
+			WithHeader("Authorization", "Bearer "+env.bettyToken).
+			Expect().Status(403)
+	})
+
+	t.Run("Downloads", func(t *testing.T) {
+		_, eB, _ := env.createClients(t)
+		eB.POST("/sharings/drives/"+env.firstSharingID+"/downloads").
+			WithQuery("Id", secretFileID).
+			WithHeader("Authorization", "Bearer "+env.bettyToken).
+			Expect().Status(403)
+		eB.POST("/sharings/drives/"+env.firstSharingID+"/downloads").
+			WithQuery("Path", secretPath+"/Secret.txt").
+			WithHeader("Authorization", "Bearer "+env.bettyToken).
+			Expect().Status(403)
+	})
+
+	t.Run("Archive", func(t *testing.T) {
+		_, eB, _ := env.createClients(t)
+		for _, body := range []string{
+			fmt.Sprintf(`{"data":{"attributes":{"ids":[%q]}}}`, secretFileID),
+			fmt.Sprintf(`{"data":{"attributes":{"files":[%q]}}}`, secretPath),
+			// The root of the drive contains the limited access folder
+			fmt.Sprintf(`{"data":{"attributes":{"ids":[%q]}}}`, env.firstRootDirID),
+		} {
+			eB.POST("/sharings/drives/"+env.firstSharingID+"/archive").
+				WithHeader("Authorization", "Bearer "+env.bettyToken).
+				WithHeader("Content-Type", "application/vnd.api+json").
+				WithBytes([]byte(body)).
+				Expect().Status(403)
+		}
+
+		body := fmt.Sprintf(`{"data":{"attributes":{"ids":[%q]}}}`, env.meetingsDirID)
+		eB.POST("/sharings/drives/"+env.firstSharingID+"/archive").
+			WithHeader("Authorization", "Bearer "+env.bettyToken).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(body)).
+			Expect().Status(200)
+	})
+
+	t.Run("Open", func(t *testing.T) {
+		eA.GET("/sharings/drives/"+env.firstSharingID+"/editor/"+secretFileID+"/open").
+			WithHeader("Authorization", "Bearer "+driveToken).
+			Expect().Status(403)
+		eA.GET("/editor/"+secretFileID+"/open").
+			WithQuery("SharingID", env.firstSharingID).
+			WithHeader("Authorization", "Bearer "+driveToken).
+			Expect().Status(403)
+	})
+
+	t.Run("ShareByLink", func(t *testing.T) {
+		_, eB, _ := env.createClients(t)
+		payload := makeSharedDrivePermissionPayload(t, consts.Files, []string{secretFileID}, "", nil)
+		createSharedDrivePermissionExpectStatus(t, eB, env.firstSharingID, env.bettyToken,
+			"secret-link", "", payload, http.StatusForbidden)
+	})
+
+	t.Run("CreateWithPath", func(t *testing.T) {
+		_, eB, _ := env.createClients(t)
+		eB.POST("/sharings/drives/"+env.firstSharingID+"/").
+			WithQuery("Type", consts.DirType).
+			WithQuery("Path", secretPath+"/Sub/Dir").
+			WithQuery("Recursive", "true").
+			WithHeader("Authorization", "Bearer "+env.bettyToken).
+			Expect().Status(403)
+	})
+
+	t.Run("UploadWithMetadata", func(t *testing.T) {
+		_, eB, _ := env.createClients(t)
+		secret := eB.POST("/sharings/drives/"+env.firstSharingID+"/upload/metadata").
+			WithHeader("Authorization", "Bearer "+env.bettyToken).
+			WithHeader("Content-Type", "application/vnd.api+json").
+			WithBytes([]byte(`{"data":{"type":"io.cozy.files.metadata","attributes":{"category":"report"}}}`)).
+			Expect().Status(201).
+			JSON(httpexpect.ContentOpts{MediaType: "application/vnd.api+json"}).
+			Object().Path("$.data.id").String().NotEmpty().Raw()
+
+		eB.POST("/sharings/drives/"+env.firstSharingID+"/"+secretDirID).
+			WithQuery("Type", consts.FileType).
+			WithQuery("Name", "upload.txt").
+			WithQuery("MetadataID", secret).
+			WithHeader("Authorization", "Bearer "+env.bettyToken).
+			WithHeader("Content-Type", "text/plain").
+			WithBytes([]byte("foo")).
+			Expect().Status(403)
+	})
+
+	t.Run("CopyDestination", func(t *testing.T) {
+		_, eB, _ := env.createClients(t)
+		eB.POST("/sharings/drives/"+env.firstSharingID+"/"+env.checklistID+"/copy").
+			WithQuery("DirID", secretDirID).
+			WithHeader("Authorization", "Bearer "+env.bettyToken).
+			Expect().Status(403)
+	})
+
+	t.Run("NoteDestination", func(t *testing.T) {
+		_, eB, _ := env.createClients(t)
+		eB.POST("/sharings/drives/"+env.firstSharingID+"/notes").
+			WithHeader("Authorization", "Bearer "+env.bettyToken).
+			WithHeader("Content-Type", "application/json").
+			WithBytes([]byte(`{
+				"data": {
+					"type": "io.cozy.notes.documents",
+					"attributes": {
+						"title": "Secret Note",
+						"dir_id": "` + secretDirID + `",
+						"schema": {
+							"nodes": [
+								["doc", { "content": "block+" }],
+								["paragraph", { "content": "inline*", "group": "block" }],
+								["text", { "group": "inline" }]
+							],
+							"marks": [],
+							"topNode": "doc"
+						}
+					}
+				}
+			}`)).
+			Expect().Status(403)
+	})
+
+	t.Run("Realtime", func(t *testing.T) {
+		_, eB, _ := env.createClients(t)
+		ws := eB.GET("/sharings/drives/" + env.firstSharingID + "/realtime").
+			WithWebsocketUpgrade().
+			Expect().Status(http.StatusSwitchingProtocols).
+			Websocket()
+		defer ws.Disconnect()
+
+		ws.WriteText(fmt.Sprintf(`{"method": "AUTH", "payload": "%s"}`, env.bettyToken))
+		time.Sleep(50 * time.Millisecond)
+
+		// The events inside the limited access folder are not sent to Betty
+		hiddenID := createFile(t, eA, secretDirID, "RealtimeSecret.txt", env.acmeToken)
+		visibleID := createFile(t, eA, env.meetingsDirID, "RealtimeVisible.txt", env.acmeToken)
+
+		raw := ws.Raw()
+		require.NoError(t, raw.SetReadDeadline(time.Now().Add(5*time.Second)))
+		for {
+			var msg struct {
+				Payload struct {
+					ID string `json:"id"`
+				} `json:"payload"`
+			}
+			require.NoError(t, raw.ReadJSON(&msg))
+			require.NotEqual(t, hiddenID, msg.Payload.ID)
+			require.NotEqual(t, secretDirID, msg.Payload.ID)
+			if msg.Payload.ID == visibleID {
+				break
+			}
+		}
+	})
+}
@@ -114,22 +114,24 @@ func upgradeWs(c echo.Context) (*websocket.Conn, error) {
 	return ws, nil
 }
 
-func getPermission(c echo.Context, i *instance.Instance, ws *websocket.Conn) (*permission.Permission, *wsError) {
+// getPermission reads the AUTH message, and returns the permission and the
+// token that it contains.
+func getPermission(c echo.Context, i *instance.Instance, ws *websocket.Conn) (*permission.Permission, string, *wsError) {
 	var auth map[string]string
 	if err := ws.ReadJSON(&auth); err != nil {
-		return nil, unknownMethod(auth["method"], auth)
+		return nil, "", unknownMethod(auth["method"], auth)
 	}
 	if strings.ToUpper(auth["method"]) != "AUTH" {
-		return nil, unknownMethod(auth["method"], auth)
+		return nil, "", unknownMethod(auth["method"], auth)
 	}
 	if auth["payload"] == "" {
-		return nil, unauthorized(auth)
+		return nil, "", unauthorized(auth)
 	}
 	pdoc, err := middlewares.ParseJWT(c, i, auth["payload"])
 	if err != nil {
-		return nil, unauthorized(auth)
+		return nil, "", unauthorized(auth)
 	}
-	return pdoc, nil
+	return pdoc, auth["payload"], nil
 }
 
 func wsWrite(ws *websocket.Conn, ch chan *wsResponse, errc chan *wsError) error {
@@ -166,7 +168,41 @@ func wsWrite(ws *websocket.Conn, ch chan *wsResponse, errc chan *wsError) error
 	}
 }
 
-func filterMapEvents(ds *realtime.Subscriber, ch chan *wsResponse, inst *instance.Instance, s *sharing.Sharing) {
+// limitedAccessVisible returns true if the member can see the document of a
+// realtime event, with the same limited_access checks as the HTTP handlers. A
+// file is checked via its parent directory, as the boundaries are directories
+// and the file may have been deleted, and a trashed file or directory is
+// checked via the path where it was before.
+func limitedAccessVisible(inst *instance.Instance, member *sharing.Member, doc realtime.Doc) bool {
+	var targetID, restorePath string
+	switch d := doc.(type) {
+	case *vfs.DirDoc:
+		targetID, restorePath = d.DocID, d.RestorePath
+	case *vfs.FileDoc:
+		targetID, restorePath = d.DirID, d.RestorePath
+	case *realtime.JSONDoc:
+		if docType, _ := d.M["type"].(string); docType == consts.DirType {
+			targetID = d.ID()
+		} else {
+			targetID, _ = d.M["dir_id"].(string)
+		}
+		restorePath, _ = d.M["restore_path"].(string)
+	default:
+		return false
+	}
+	if restorePath != "" {
+		return checkMemberLimitedAccessToPath(inst, member, restorePath, permission.GET) == nil
+	}
+	if targetID == "" {
+		return false
+	}
+	return checkMemberLimitedAccessTo(inst, member, targetID, permission.GET) == nil
+}
+
+// filterMapEvents sends the events for the files of the sharing. When member
+// is not nil, the events for the files inside a limited_access boundary where
+// this member is not invited are skipped.
+func filterMapEvents(ds *realtime.Subscriber, ch chan *wsResponse, inst *instance.Instance, s *sharing.Sharing, member *sharing.Member) {
 	log := inst.Logger().WithNamespace("sharing-realtime")
 
 	if s.HasFileDriveRoot() {
@@ -181,6 +217,8 @@ func filterMapEvents(ds *realtime.Subscriber, ch chan *wsResponse, inst *instanc
 			return doc != nil && doc.ID() == rootFileID
 		}
 
+		// There is no folder, and so no limited_access boundary, inside a
+		// shared drive with a file as root.
 		for e := range ds.Channel {
 			if match(e.Doc) || match(e.OldDoc) {
 				ch <- &wsResponse{
@@ -258,15 +296,19 @@ func filterMapEvents(ds *realtime.Subscriber, ch chan *wsResponse, inst *instanc
 	}
 
 	for e := range ds.Channel {
-		if match(e) {
-			ch <- &wsResponse{
-				Event: e.Verb,
-				Payload: wsResponsePayload{
-					Type: e.Doc.DocType(),
-					ID:   e.Doc.ID(),
-					Doc:  e.Doc,
-				},
-			}
+		if !match(e) {
+			continue
+		}
+		if member != nil && !limitedAccessVisible(inst, member, e.Doc) {
+			continue
+		}
+		ch <- &wsResponse{
+			Event: e.Verb,
+			Payload: wsResponsePayload{
+				Type: e.Doc.DocType(),
+				ID:   e.Doc.ID(),
+				Doc:  e.Doc,
+			},
 		}
 	}
 }
@@ -290,12 +332,23 @@ func wsOwner(c echo.Context, inst *instance.Instance, s *sharing.Sharing) error
 	go func() {
 		defer close(ch)
 		defer close(errc)
-		pdoc, wsErr := getPermission(c, inst, ws)
+		pdoc, token, wsErr := getPermission(c, inst, ws)
 		if wsErr != nil {
 			log.Warnf("wsOwner: AUTH failed for sharing %s", s.SID)
 			sendErr(ctx, errc, wsErr)
 			return
 		}
+		// The members of a shared drive use a share-interact token, and the
+		// limited_access boundaries must be enforced for them.
+		var member *sharing.Member
+		if pdoc.Type == permission.TypeShareInteract {
+			member, _ = s.FindMemberByInteractCode(inst, token)
+			if member == nil {
+				log.Warnf("wsOwner: member not found for sharing %s", s.SID)
+				sendErr(ctx, errc, unauthorized(pdoc))
+				return
+			}
+		}
 		for _, rule := range s.Rules {
 			for _, value := range rule.Values {
 				if !pdoc.Permissions.AllowID(permission.GET, rule.DocType, value) {
@@ -306,7 +359,7 @@ func wsOwner(c echo.Context, inst *instance.Instance, s *sharing.Sharing) error
 			}
 		}
 		ds.Subscribe(consts.Files)
-		filterMapEvents(ds, ch, inst, s)
+		filterMapEvents(ds, ch, inst, s, member)
 	}()
 
 	return wsWrite(ws, ch, errc)
@@ -381,7 +434,7 @@ func wsHijack(c echo.Context, inst, owner *instance.Instance, s *sharing.Sharing
 
 	go func() {
 		defer close(errc)
-		pdoc, wsErr := getPermission(c, inst, ws)
+		pdoc, _, wsErr := getPermission(c, inst, ws)
 		if wsErr != nil {
 			log.Warnf("wsHijack: AUTH failed for sharing %s", s.SID)
 			sendErr(ctx, errc, wsErr)
@@ -392,8 +445,22 @@ func wsHijack(c echo.Context, inst, owner *instance.Instance, s *sharing.Sharing
 			sendErr(ctx, errc, unauthorized(pdoc))
 			return
 		}
+		// The events are filtered like for the member connected via the
+		// owner's websocket with its shared-drive token.
+		owned := ownedSharedDrive(owner, s)
+		if owned == nil || len(s.Credentials) == 0 {
+			log.Warnf("wsHijack: sharing %s not found on the owner", s.SID)
+			sendErr(ctx, errc, unauthorized(pdoc))
+			return
+		}
+		member, _ := owned.FindMemberByInteractCode(owner, s.Credentials[0].DriveToken)
+		if member == nil {
+			log.Warnf("wsHijack: member not found for sharing %s", s.SID)
+			sendErr(ctx, errc, unauthorized(pdoc))
+			return
+		}
 		ds.Subscribe(consts.Files)
-		filterMapEvents(ds, ch, owner, s)
+		filterMapEvents(ds, ch, owner, owned, member)
 	}()
 
 	return wsWrite(ws, ch, errc)
@@ -416,7 +483,7 @@ func wsProxy(c echo.Context, inst *instance.Instance, s *sharing.Sharing, token
 	go func() {
 		defer close(ch)
 		defer close(errc)
-		pdoc, wsErr := getPermission(c, inst, ws)
+		pdoc, _, wsErr := getPermission(c, inst, ws)
 		if wsErr != nil {
 			log.Warnf("wsProxy: AUTH failed for sharing %s", s.SID)
 			sendErr(ctx, errc, wsErr)
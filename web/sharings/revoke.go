@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/permission"
 	"github.com/cozy/cozy-stack/model/sharing"
@@ -29,6 +30,9 @@ func RevokeSharing(c echo.Context) error {
 	if err = s.Revoke(inst); err != nil {
 		return wrapErrors(err)
 	}
+	details := sharingAuditDetails(s, nil)
+	details["all_members"] = true
+	middlewares.Audit(c, inst, audit.SharingMemberRevoked, details)
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -51,9 +55,11 @@ func RevokeRecipient(c echo.Context) error {
 		return err
 	}
 	if s.Owner {
+		member := s.Members[index]
 		if err = s.RevokeRecipient(inst, index); err != nil {
 			return wrapErrors(err)
 		}
+		middlewares.Audit(c, inst, audit.SharingMemberRevoked, sharingAuditDetails(s, &member))
 		go s.NotifyRecipients(inst, nil)
 		return c.NoContent(http.StatusNoContent)
 	}
@@ -137,9 +143,13 @@ func RevokeGroup(c echo.Context) error {
 	if index >= len(s.Groups) {
 		return jsonapi.InvalidParameter("index", errors.New("Invalid index"))
 	}
+	group := s.Groups[index].Name
 	if err = s.RevokeGroup(inst, index); err != nil {
 		return wrapErrors(err)
 	}
+	details := sharingAuditDetails(s, nil)
+	details["group"] = group
+	middlewares.Audit(c, inst, audit.SharingMemberRevoked, details)
 	go s.NotifyRecipients(inst, nil)
 	return c.NoContent(http.StatusNoContent)
 }
@@ -232,5 +242,8 @@ func RevokeRecipientBySelf(c echo.Context) error {
 	if err = s.RevokeRecipientBySelf(inst, sharing.SharingDirNotTrashed); err != nil {
 		return wrapErrors(err)
 	}
+	details := sharingAuditDetails(s, nil)
+	details["self"] = true
+	middlewares.Audit(c, inst, audit.SharingMemberRevoked, details)
 	return c.NoContent(http.StatusNoContent)
 }
@@ -16,6 +16,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/audit"
 	"github.com/cozy/cozy-stack/model/contact"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/oauth"
@@ -214,17 +215,72 @@ func PatchSharing(c echo.Context) error {
 		return wrapErrors(err)
 	}
 
-	req := sharing.APISharing{}
-	if _, err := jsonapi.Bind(c.Request().Body, &req); err != nil {
+	var attrs map[string]json.RawMessage
+	if _, err := jsonapi.Bind(c.Request().Body, &attrs); err != nil {
 		return wrapErrors(err)
 	}
-	if err := s.PatchDescription(inst, req.Description); err != nil {
-		return wrapErrors(err)
+	if raw, ok := attrs["description"]; ok {
+		var description string
+		if err := json.Unmarshal(raw, &description); err != nil {
+			return jsonapi.InvalidAttribute("description", err)
+		}
+		if err := s.PatchDescription(inst, description); err != nil {
+			return wrapErrors(err)
+		}
+	}
+	if err := patchExpiration(inst, s, attrs); err != nil {
+		return err
 	}
 
 	return jsonapiSharingWithDocs(c, s)
 }
 
+// patchExpiration changes the expiration dates of the sharing and of its
+// members, from the expires_at and members_expires_at attributes. A null date
+// removes the expiration.
+func patchExpiration(inst *instance.Instance, s *sharing.Sharing, attrs map[string]json.RawMessage) error {
+	rawSharing, hasSharing := attrs["expires_at"]
+	rawMembers, hasMembers := attrs["members_expires_at"]
+	if !hasSharing && !hasMembers {
+		return nil
+	}
+	if !s.Owner {
+		return jsonapi.Forbidden(errors.New("Only the owner can change the expiration"))
+	}
+	if hasSharing {
+		var at *time.Time
+		if err := json.Unmarshal(rawSharing, &at); err != nil {
+			return jsonapi.InvalidAttribute("expires_at", err)
+		}
+		if err := s.SetExpiresAt(at); err != nil {
+			return wrapErrors(err)
+		}
+	}
+	if hasMembers {
+		var members map[string]*time.Time
+		if err := json.Unmarshal(rawMembers, &members); err != nil {
+			return jsonapi.InvalidAttribute("members_expires_at", err)
+		}
+		for key, at := range members {
+			index, err := strconv.Atoi(key)
+			if err != nil {
+				return jsonapi.InvalidAttribute("members_expires_at", err)
+			}
+			if err := s.SetMemberExpiresAt(index, at); err != nil {
+				if errors.Is(err, sharing.ErrMemberNotFound) {
+					return jsonapi.InvalidAttribute("members_expires_at", err)
+				}
+				return wrapErrors(err)
+			}
+		}
+	}
+	if err := s.SaveExpiration(inst); err != nil {
+		return wrapErrors(err)
+	}
+	go s.NotifyRecipients(inst, nil)
+	return nil
+}
+
 // CountNewShortcuts returns the number of shortcuts to a sharing that have not
 // been seen.
 func CountNewShortcuts(c echo.Context) error {
@@ -365,6 +421,34 @@ func ChangeCozyAddress(c echo.Context) error {
 	return c.NoContent(http.StatusNoContent)
 }
 
+// sharingAuditDetails returns the details of a sharing, and optionally of one
+// of its members, for an entry of the audit log.
+func sharingAuditDetails(s *sharing.Sharing, m *sharing.Member) map[string]interface{} {
+	details := map[string]interface{}{
+		"sharing_id":  s.SID,
+		"description": s.Description,
+	}
+	if m != nil {
+		details["member_name"] = m.PrimaryName()
+		if m.Email != "" {
+			details["member_email"] = m.Email
+		}
+		if m.Instance != "" {
+			details["member_instance"] = m.Instance
+		}
+		details["read_only"] = m.ReadOnly
+	}
+	return details
+}
+
+// auditAddedMembers saves in the audit log the members that have been added
+// to the sharing after the given index.
+func auditAddedMembers(c echo.Context, inst *instance.Instance, s *sharing.Sharing, from int) {
+	for i := from; i < len(s.Members); i++ {
+		middlewares.Audit(c, inst, audit.SharingMemberAdded, sharingAuditDetails(s, &s.Members[i]))
+	}
+}
+
 func addRecipientsToSharing(inst *instance.Instance, s *sharing.Sharing, rel *jsonapi.Relationship, readOnly bool) error {
 	var err error
 	if data, ok := rel.Data.([]interface{}); ok {
@@ -403,6 +487,7 @@ func AddRecipients(c echo.Context) error {
 	if err != nil {
 		return jsonapi.BadJSON()
 	}
+	before := len(s.Members)
 	if rel, ok := obj.GetRelationship("recipients"); ok {
 		if err = addRecipientsToSharing(inst, s, rel, false); err != nil {
 			return wrapErrors(err)
@@ -413,6 +498,7 @@ func AddRecipients(c echo.Context) error {
 			return wrapErrors(err)
 		}
 	}
+	auditAddedMembers(c, inst, s, before)
 	return jsonapiSharingWithDocs(c, s)
 }
 
@@ -549,10 +635,12 @@ func AddRecipientsDelegated(c echo.Context) error {
 		return jsonapi.BadJSON()
 	}
 
+	before := len(s.Members)
 	states, hasShortcutInvitations, err := persistDelegatedRecipients(c, inst, s, &body)
 	if err != nil {
 		return err
 	}
+	auditAddedMembers(c, inst, s, before)
 
 	if hasShortcutInvitations {
 		var perms *permission.Permission
@@ -681,6 +769,9 @@ func RemoveMemberFromGroup(c echo.Context) error {
 	if err := s.DelegatedRemoveMemberFromGroup(inst, groupIndex, memberIndex); err != nil {
 		return wrapErrors(err)
 	}
+	details := sharingAuditDetails(s, &s.Members[memberIndex])
+	details["group"] = s.Groups[groupIndex].Name
+	middlewares.Audit(c, inst, audit.SharingMemberRevoked, details)
 	return c.NoContent(http.StatusNoContent)
 }
 
@@ -1341,6 +1432,10 @@ func wrapErrors(err error) error {
 		return jsonapi.Conflict(err)
 	case sharing.ErrNotADirectory, sharing.ErrSystemFolder:
 		return jsonapi.InvalidParameter("folder_id", err)
+	case sharing.ErrInvalidAccessMode:
+		return jsonapi.InvalidAttribute("access_mode", err)
+	case sharing.ErrInvalidExpiration:
+		return jsonapi.InvalidAttribute("expires_at", err)
 	}
 	logger.WithNamespace("sharing").Warnf("Not wrapped error: %s", err)
 	return err
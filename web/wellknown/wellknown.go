@@ -15,8 +15,33 @@ func ChangePassword(c echo.Context) error {
 	return c.Redirect(http.StatusFound, inst.ChangePasswordURL())
 }
 
+// CardDAV is an handler that redirects the CardDAV clients to the address
+// book home, as defined by RFC 6764.
+func CardDAV(c echo.Context) error {
+	return c.Redirect(http.StatusMovedPermanently, "/dav/contacts/")
+}
+
+// CalDAV is an handler that redirects the CalDAV clients to the calendar
+// home, as defined by RFC 6764.
+func CalDAV(c echo.Context) error {
+	return c.Redirect(http.StatusMovedPermanently, "/dav/calendars/")
+}
+
+// WebAuthn is an handler that lists the origins that can use the passkeys of
+// the instance, like the settings application on its own sub-domain.
+// See https://w3c.github.io/webauthn/#sctn-related-origins
+func WebAuthn(c echo.Context) error {
+	inst := middlewares.GetInstance(c)
+	return c.JSON(http.StatusOK, echo.Map{
+		"origins": inst.RelyingParty().Origins,
+	})
+}
+
 // Routes sets the routing for the status service
 func Routes(router *echo.Group) {
 	router.GET("/change-password", ChangePassword)
 	router.HEAD("/change-password", ChangePassword)
+	router.GET("/webauthn", WebAuthn)
+	router.Match([]string{http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND"}, "/carddav", CardDAV)
+	router.Match([]string{http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND"}, "/caldav", CalDAV)
 }
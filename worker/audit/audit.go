@@ -0,0 +1,30 @@
+package audit
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/audit"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   audit.CleanWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      30 * time.Minute,
+		WorkerFunc:   WorkerClean,
+	})
+}
+
+// WorkerClean removes the entries of the audit log that are older than the
+// retention, configured with the audit.retention parameter.
+func WorkerClean(ctx *job.TaskContext) error {
+	count, err := audit.CleanOldEntries(ctx.Instance, time.Now())
+	if count > 0 {
+		ctx.Logger().Infof("%d entries of the audit log have been removed", count)
+	}
+	return err
+}
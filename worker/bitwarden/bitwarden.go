@@ -0,0 +1,32 @@
+package bitwarden
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/bitwarden"
+	"github.com/cozy/cozy-stack/model/job"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   bitwarden.EmergencyAccessWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      30 * time.Second,
+		WorkerFunc:   Worker,
+	})
+}
+
+// Worker is used for the emergency accesses of the password manager. It is
+// called when the grantor approves a recovery request, or by an @at trigger
+// when the waiting period is over, and it sends the approval to the Cozy of
+// the grantee.
+func Worker(ctx *job.TaskContext) error {
+	var msg bitwarden.EmergencyAccessMessage
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	return bitwarden.RunEmergencyAccessJob(ctx.Instance, &msg)
+}
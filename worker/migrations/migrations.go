@@ -8,6 +8,7 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/cozy/cozy-stack/model/calendar"
 	"github.com/cozy/cozy-stack/model/instance"
 	"github.com/cozy/cozy-stack/model/job"
 	"github.com/cozy/cozy-stack/model/note"
@@ -40,6 +41,7 @@ const (
 	accountsToOrganization = "accounts-to-organization"
 	notesMimeType          = "notes-mime-type"
 	unwantedFolders        = "remove-unwanted-folders"
+	calendarReminders      = "calendar-reminders"
 )
 
 // maxSimultaneousCalls is the maximal number of simultaneous calls to Swift
@@ -82,6 +84,8 @@ func worker(ctx *job.TaskContext) error {
 		return migrateNotesMimeType(ctx.Instance.Domain)
 	case unwantedFolders:
 		return removeUnwantedFolders(ctx.Instance.Domain)
+	case calendarReminders:
+		return setupCalendarReminders(ctx.Instance.Domain)
 	default:
 		return fmt.Errorf("unknown migration type %q", msg.Type)
 	}
@@ -198,6 +202,14 @@ func removeUnwantedFolders(domain string) error {
 	return errf
 }
 
+func setupCalendarReminders(domain string) error {
+	inst, err := instance.Get(domain)
+	if err != nil {
+		return err
+	}
+	return calendar.SetupReminders(inst)
+}
+
 func migrateNotesMimeType(domain string) error {
 	inst, err := instance.Get(domain)
 	if err != nil {
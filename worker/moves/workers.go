@@ -26,6 +26,22 @@ func init() {
 		Timeout:      3 * time.Hour,
 		WorkerFunc:   ImportWorker,
 	})
+
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   move.BackupWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 1,
+		Timeout:      3 * time.Hour,
+		WorkerFunc:   BackupWorker,
+	})
+
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   move.RestoreWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 1,
+		Timeout:      6 * time.Hour,
+		WorkerFunc:   RestoreWorker,
+	})
 }
 
 // ExportWorker is the worker responsible for creating an export of the
@@ -120,3 +136,69 @@ func ImportWorker(c *job.TaskContext) error {
 	}
 	return move.NotifySharings(c.Instance)
 }
+
+// BackupWorker is the worker responsible for creating a full or incremental
+// backup of the instance.
+func BackupWorker(c *job.TaskContext) error {
+	var opts move.BackupOptions
+	if err := c.UnmarshalMessage(&opts); err != nil {
+		return err
+	}
+
+	target, err := move.SystemBackupTarget()
+	if err != nil {
+		return err
+	}
+	backup, err := move.CreateBackup(c.Instance, opts, target)
+	if err != nil {
+		c.Instance.Logger().WithNamespace("backups").
+			Warnf("Backup failed: %s", err)
+		return err
+	}
+	c.Instance.Logger().WithNamespace("backups").
+		Infof("Backup %s created (%d documents, %d files)", backup.ID, backup.NbDocs, backup.NbFiles)
+	return nil
+}
+
+// RestoreWorker is the worker responsible for replacing the data of the
+// instance by the ones from a chain of backups.
+func RestoreWorker(c *job.TaskContext) error {
+	var opts move.RestoreOptions
+	if err := c.UnmarshalMessage(&opts); err != nil {
+		return err
+	}
+
+	target, err := move.SystemBackupTarget()
+	if err != nil {
+		return err
+	}
+
+	if err := lifecycle.Block(c.Instance, instance.BlockedImporting.Code); err != nil {
+		return err
+	}
+
+	inError, err := move.Restore(c.Instance, opts, target)
+
+	if erru := lifecycle.Unblock(c.Instance); erru != nil {
+		// Try again
+		time.Sleep(10 * time.Second)
+		inst, errg := instance.Get(c.Instance.Domain)
+		if errg == nil {
+			erru = lifecycle.Unblock(inst)
+		}
+		if err == nil {
+			err = erru
+		}
+	}
+
+	if err != nil {
+		c.Instance.Logger().WithNamespace("backups").
+			Warnf("Restore failed: %s", err)
+		return err
+	}
+	if len(inError) > 0 {
+		c.Instance.Logger().WithNamespace("backups").
+			Warnf("Restore: apps and konnectors not installed: %v", inError)
+	}
+	return nil
+}
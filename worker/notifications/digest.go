@@ -0,0 +1,25 @@
+package notifications
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification/center"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   center.DigestWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      60 * time.Second,
+		WorkerFunc:   Worker,
+	})
+}
+
+// Worker sends the daily digest of the low-priority notifications by mail.
+func Worker(ctx *job.TaskContext) error {
+	return center.SendDigest(ctx.Instance)
+}
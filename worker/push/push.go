@@ -1,4 +1,5 @@
-// Package push is the worker that sends push notifications to mobile apps.
+// Package push is the worker that sends push notifications to mobile apps,
+// and to the browsers with Web Push.
 package push
 
 import (
@@ -196,6 +197,11 @@ func Worker(ctx *job.TaskContext) error {
 		return nil
 	}
 
+	// If no mobile app, try to send the notification to the browsers
+	if pushToWebPush(ctx, &msg) > 0 {
+		return nil
+	}
+
 	// Else, we fallback to send the notifiation by email
 	sendFallbackMail(ctx.Instance, msg.MailFallback)
 	return nil
@@ -0,0 +1,83 @@
+package push
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/model/oauth"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/logger"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+	"github.com/cozy/cozy-stack/pkg/webpush"
+)
+
+// webPushPayload is the JSON sent to the service worker of the browser, that
+// is responsible for displaying the notification.
+type webPushPayload struct {
+	NotificationID string                 `json:"notification_id,omitempty"`
+	Slug           string                 `json:"slug,omitempty"`
+	Title          string                 `json:"title"`
+	Body           string                 `json:"body,omitempty"`
+	Tag            string                 `json:"tag"`
+	Data           map[string]interface{} `json:"data,omitempty"`
+}
+
+// pushToWebPush sends the notification to the browsers that have subscribed
+// to the Web Push notifications, and returns the number of browsers that
+// have received it.
+func pushToWebPush(ctx *job.TaskContext, msg *center.PushMessage) int {
+	vapid := config.GetConfig().Notifications.VAPID
+	if vapid == nil {
+		return 0
+	}
+	subs, err := oauth.GetWebPushSubscriptions(ctx.Instance)
+	if err != nil {
+		ctx.Logger().Warnf("Cannot get the Web Push subscriptions: %s", err)
+		return 0
+	}
+
+	var hashedSource []byte
+	if msg.Collapsible {
+		hashedSource = hashSource(msg.Source)
+	} else {
+		hashedSource = hashSource(msg.Source + msg.NotificationID)
+	}
+	payload, err := json.Marshal(webPushPayload{
+		NotificationID: msg.NotificationID,
+		Slug:           msg.Slug(),
+		Title:          msg.Title,
+		Body:           msg.Message,
+		Tag:            hex.EncodeToString(hashedSource),
+		Data:           msg.Data,
+	})
+	if err != nil {
+		return 0
+	}
+	opts := webpush.Options{Urgency: webpush.UrgencyNormal}
+	if msg.Priority == "high" {
+		opts.Urgency = webpush.UrgencyHigh
+	}
+	if msg.Collapsible {
+		opts.Topic = base64.RawURLEncoding.EncodeToString(hashedSource)
+	}
+
+	nbSent := 0
+	for _, sub := range subs {
+		err := webpush.Send(ctx, safehttp.DefaultClient, vapid, &sub.Subscription, payload, opts)
+		if err == nil {
+			nbSent++
+			continue
+		}
+		if errors.Is(err, webpush.ErrGone) {
+			_ = sub.Delete(ctx.Instance)
+		}
+		ctx.Logger().
+			WithFields(logger.Fields{"subscription_id": sub.ID()}).
+			Warnf("could not send Web Push notification: %s", err)
+	}
+	return nbSent
+}
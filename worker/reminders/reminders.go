@@ -0,0 +1,111 @@
+package reminders
+
+import (
+	"html"
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/calendar"
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/notification"
+	"github.com/cozy/cozy-stack/model/notification/center"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   calendar.RemindersWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      30 * time.Second,
+		WorkerFunc:   Worker,
+	})
+}
+
+// Worker is used for the reminders of the calendar. When an event or todo is
+// modified, it schedules an @at trigger for its next reminder. And when this
+// @at trigger fires, it sends the notification and schedules the reminder
+// after it.
+func Worker(ctx *job.TaskContext) error {
+	var msg calendar.ReminderMessage
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+
+	var evt struct {
+		Doc struct {
+			ID string `json:"_id"`
+		} `json:"doc"`
+	}
+	if err := ctx.UnmarshalEvent(&evt); err == nil {
+		return schedule(ctx, msg.DocType, evt.Doc.ID, time.Now())
+	}
+
+	if msg.DocID == "" {
+		return nil
+	}
+	item, err := calendar.FindItem(ctx.Instance, msg.DocType, msg.DocID)
+	if err != nil {
+		if couchdb.IsNotFoundError(err) {
+			return nil
+		}
+		return err
+	}
+	if err := notify(ctx, item, msg); err != nil {
+		ctx.Instance.Logger().WithNamespace("reminders").
+			Warnf("Cannot send the reminder for %s: %s", msg.DocID, err)
+	}
+	after := time.Now()
+	if msg.At.After(after) {
+		after = msg.At
+	}
+	return calendar.ScheduleReminder(ctx.Instance, msg.DocType, msg.DocID, item, after)
+}
+
+func schedule(ctx *job.TaskContext, doctype, id string, after time.Time) error {
+	if id == "" {
+		return nil
+	}
+	item, err := calendar.FindItem(ctx.Instance, doctype, id)
+	if couchdb.IsNotFoundError(err) {
+		return calendar.ScheduleReminder(ctx.Instance, doctype, id, nil, after)
+	}
+	if err != nil {
+		return err
+	}
+	return calendar.ScheduleReminder(ctx.Instance, doctype, id, item, after)
+}
+
+func notify(ctx *job.TaskContext, item calendar.Item, msg calendar.ReminderMessage) error {
+	base := item.Base()
+	title := base.Summary
+	if title == "" {
+		title = ctx.Instance.Translate("Calendar Reminder Title")
+	}
+	start := msg.Start
+	if base.Start != nil {
+		start = start.In(base.Start.Location())
+	}
+	when := start.Format("Mon 2 Jan 2006 15:04")
+	if base.Start != nil && base.Start.IsDate() {
+		when = start.Format("Mon 2 Jan 2006")
+	}
+	content := when
+	if base.Location != "" {
+		content += " - " + base.Location
+	}
+	n := &notification.Notification{
+		Title:       title,
+		Message:     when,
+		Content:     content,
+		ContentHTML: "<p><strong>" + html.EscapeString(title) + "</strong></p><p>" + html.EscapeString(content) + "</p>",
+		Data: map[string]interface{}{
+			"doctype": msg.DocType,
+			"id":      msg.DocID,
+			"start":   msg.Start,
+		},
+		PreferredChannels: []string{"mobile"},
+	}
+	return center.PushStack(ctx.Instance.DomainName(), center.NotificationCalendarReminder, n)
+}
@@ -0,0 +1,30 @@
+package search
+
+import (
+	"runtime"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/search"
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   search.IndexWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 1,
+		Reserved:     true,
+		Timeout:      15 * time.Minute,
+		WorkerFunc:   WorkerIndex,
+	})
+}
+
+// WorkerIndex updates the full-text search index of an instance.
+func WorkerIndex(ctx *job.TaskContext) error {
+	var msg search.IndexMessage
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	ctx.Logger().Debugf("Search: index %q", msg.Doctype)
+	return search.Update(ctx.Instance, msg)
+}
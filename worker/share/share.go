@@ -70,6 +70,15 @@ func init() {
 		Timeout:      30 * time.Second,
 		WorkerFunc:   WorkerUpdate,
 	})
+
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   sharing.ExpirationWorker,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      10 * time.Minute,
+		WorkerFunc:   WorkerExpire,
+	})
 }
 
 // WorkerGroup is used to update the list of members of sharings for a group
@@ -167,3 +176,9 @@ func WorkerUpdate(ctx *job.TaskContext) error {
 	}
 	return s.PatchDescription(ctx.Instance, msg.NewDescription)
 }
+
+// WorkerExpire is used to warn about the sharings that will expire soon, and
+// to revoke the members whose access has expired.
+func WorkerExpire(ctx *job.TaskContext) error {
+	return sharing.CheckExpirations(ctx.Instance, time.Now())
+}
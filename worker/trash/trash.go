@@ -33,6 +33,24 @@ func init() {
 		Timeout:      2 * time.Hour,
 		WorkerFunc:   WorkerCleanOldTrashed,
 	})
+
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "clean-expired-uploads",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      30 * time.Minute,
+		WorkerFunc:   WorkerCleanExpiredUploads,
+	})
+
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   "clean-old-versions",
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 2,
+		Reserved:     true,
+		Timeout:      1 * time.Hour,
+		WorkerFunc:   WorkerCleanOldVersions,
+	})
 }
 
 // WorkerTrashFiles is a worker to remove files in Swift after they have been
@@ -108,3 +126,16 @@ func pushTrashJob(fs vfs.VFS) func(vfs.TrashJournal) error {
 		return fs.EnsureErased(journal)
 	}
 }
+
+// WorkerCleanExpiredUploads is a worker used to remove the upload sessions
+// (and their staged parts) that have not received new content for too long.
+func WorkerCleanExpiredUploads(ctx *job.TaskContext) error {
+	return vfs.CleanExpiredUploads(ctx.Instance.VFS())
+}
+
+// WorkerCleanOldVersions is a worker used to apply the versioning policies of
+// the directories to the old versions of their files, as a version can
+// become obsolete only because time has passed.
+func WorkerCleanOldVersions(ctx *job.TaskContext) error {
+	return vfs.EnforceVersioningPolicies(ctx.Instance, ctx.Instance.VFS())
+}
@@ -0,0 +1,374 @@
+// Package webhook is for the webhook-out worker, that sends a signed HTTP
+// request to a remote URL when a document is created, updated or deleted in
+// the Cozy.
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/model/permission"
+	build "github.com/cozy/cozy-stack/pkg/config"
+	"github.com/cozy/cozy-stack/pkg/consts"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/prefixer"
+	"github.com/cozy/cozy-stack/pkg/safehttp"
+	"github.com/labstack/echo/v4"
+)
+
+// WorkerType is the type of the worker for the outgoing webhooks.
+const WorkerType = "webhook-out"
+
+// SignatureHeader is the HTTP header used for the HMAC signature of the body.
+const SignatureHeader = "X-Cozy-Signature"
+
+// DeliveryHeader is the HTTP header used for the identifier of the delivery.
+// It is the same for all the attempts of a delivery.
+const DeliveryHeader = "X-Cozy-Delivery"
+
+// EventHeader is the HTTP header used for the kind of event (the doctype and
+// verb).
+const EventHeader = "X-Cozy-Event"
+
+// States of a delivery. A delivery is retrying after a failed attempt, and
+// it is failed only when there is no more attempts to make.
+const (
+	StatePending   = "pending"
+	StateRetrying  = "retrying"
+	StateDelivered = "delivered"
+	StateFailed    = "failed"
+)
+
+// maxErrorLength is the maximal length of the error message kept for an
+// attempt.
+const maxErrorLength = 256
+
+var (
+	// ErrInvalidURL is used when the URL of the webhook is missing or is not
+	// an http(s) URL.
+	ErrInvalidURL = errors.New("The webhook URL must be an absolute http or https URL")
+	// ErrMissingSecret is used when there is no secret to sign the payload.
+	ErrMissingSecret = errors.New("The webhook secret is missing")
+)
+
+func init() {
+	job.AddWorker(&job.WorkerConfig{
+		WorkerType:   WorkerType,
+		Concurrency:  runtime.NumCPU(),
+		MaxExecCount: 5,
+		Timeout:      30 * time.Second,
+		RetryDelay:   30 * time.Second,
+		WorkerFunc:   Worker,
+		WorkerCommit: commit,
+	})
+}
+
+// Message is the message of the webhook-out jobs.
+type Message struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// Validate checks that the message can be used to send webhooks.
+func (m *Message) Validate() error {
+	u, err := url.Parse(m.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return ErrInvalidURL
+	}
+	if m.Secret == "" {
+		return ErrMissingSecret
+	}
+	return nil
+}
+
+// Payload is the body of the requests sent to the webhook URL.
+type Payload struct {
+	DeliveryID string          `json:"delivery_id"`
+	Domain     string          `json:"domain"`
+	TriggerID  string          `json:"trigger_id,omitempty"`
+	DocType    string          `json:"doctype,omitempty"`
+	Verb       string          `json:"verb"`
+	Doc        json.RawMessage `json:"doc"`
+	OldDoc     json.RawMessage `json:"old,omitempty"`
+	Date       time.Time       `json:"date"`
+}
+
+// Attempt is the result of one try to send a webhook.
+type Attempt struct {
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	Date       time.Time `json:"date"`
+}
+
+// Delivery is a document used to keep track of the attempts to send a
+// webhook. Its identifier is the identifier of the job.
+type Delivery struct {
+	DocID      string    `json:"_id,omitempty"`
+	DocRev     string    `json:"_rev,omitempty"`
+	TriggerID  string    `json:"trigger_id,omitempty"`
+	URL        string    `json:"url"`
+	Watched    string    `json:"doctype,omitempty"`
+	DocumentID string    `json:"doc_id,omitempty"`
+	Verb       string    `json:"verb"`
+	State      string    `json:"state"`
+	Attempts   []Attempt `json:"attempts"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// ID returns the delivery identifier
+func (d *Delivery) ID() string { return d.DocID }
+
+// Rev returns the delivery revision
+func (d *Delivery) Rev() string { return d.DocRev }
+
+// DocType returns the delivery document type
+func (d *Delivery) DocType() string { return consts.WebhooksDeliveries }
+
+// Clone implements couchdb.Doc
+func (d *Delivery) Clone() couchdb.Doc {
+	cloned := *d
+	cloned.Attempts = make([]Attempt, len(d.Attempts))
+	copy(cloned.Attempts, d.Attempts)
+	return &cloned
+}
+
+// SetID changes the delivery identifier
+func (d *Delivery) SetID(id string) { d.DocID = id }
+
+// SetRev changes the delivery revision
+func (d *Delivery) SetRev(rev string) { d.DocRev = rev }
+
+type event struct {
+	Domain string          `json:"domain"`
+	Verb   string          `json:"verb"`
+	Doc    json.RawMessage `json:"doc"`
+	OldDoc json.RawMessage `json:"old,omitempty"`
+}
+
+// Worker is the worker that sends the outgoing webhooks.
+func Worker(ctx *job.TaskContext) error {
+	var msg Message
+	if err := ctx.UnmarshalMessage(&msg); err != nil {
+		return err
+	}
+	if err := msg.Validate(); err != nil {
+		ctx.SetNoRetry()
+		return err
+	}
+	var evt event
+	if err := ctx.UnmarshalEvent(&evt); err != nil {
+		ctx.SetNoRetry()
+		return err
+	}
+
+	inst := ctx.Instance
+	triggerID, _ := ctx.TriggerID()
+	delivery, err := getDelivery(inst, ctx.JobID())
+	if err != nil {
+		return err
+	}
+	if delivery == nil {
+		now := time.Now().UTC()
+		delivery = &Delivery{
+			DocID:      ctx.JobID(),
+			TriggerID:  triggerID,
+			URL:        msg.URL,
+			Watched:    watchedDocType(inst, triggerID),
+			DocumentID: docID(evt.Doc),
+			Verb:       evt.Verb,
+			State:      StatePending,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+	}
+
+	body, err := json.Marshal(Payload{
+		DeliveryID: delivery.DocID,
+		Domain:     inst.Domain,
+		TriggerID:  triggerID,
+		DocType:    delivery.Watched,
+		Verb:       evt.Verb,
+		Doc:        evt.Doc,
+		OldDoc:     evt.OldDoc,
+		Date:       delivery.CreatedAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	attempt, err := send(ctx, &msg, delivery, body)
+	delivery.Attempts = append(delivery.Attempts, *attempt)
+	delivery.UpdatedAt = attempt.Date
+	delivery.State = attemptState(err, ctx.NoRetry())
+	if err != nil {
+		ctx.Logger().Infof("webhook %s failed: %s", delivery.DocID, err)
+	}
+	if errs := saveDelivery(inst, delivery); errs != nil {
+		ctx.Logger().Warnf("cannot save delivery %s: %s", delivery.DocID, errs)
+	}
+	return err
+}
+
+// attemptState returns the state of a delivery after an attempt. A failed
+// attempt will be retried by the job system, unless the error can't be fixed
+// by sending the same request again.
+func attemptState(err error, noRetry bool) string {
+	switch {
+	case err == nil:
+		return StateDelivered
+	case noRetry:
+		return StateFailed
+	default:
+		return StateRetrying
+	}
+}
+
+// commit is called when the job is finished, after the last attempt. If it
+// has failed, the delivery won't be retried anymore.
+func commit(ctx *job.TaskContext, err error) error {
+	if err == nil {
+		return nil
+	}
+	delivery, errg := getDelivery(ctx.Instance, ctx.JobID())
+	if errg != nil || delivery == nil {
+		return errg
+	}
+	if !finishDelivery(delivery) {
+		return nil
+	}
+	return saveDelivery(ctx.Instance, delivery)
+}
+
+// finishDelivery marks a delivery that is still retrying as failed, and
+// returns true if it has been changed.
+func finishDelivery(d *Delivery) bool {
+	if d.State != StatePending && d.State != StateRetrying {
+		return false
+	}
+	d.State = StateFailed
+	return true
+}
+
+func send(ctx *job.TaskContext, msg *Message, delivery *Delivery, body []byte) (*Attempt, error) {
+	start := time.Now()
+	attempt := &Attempt{Date: start.UTC()}
+	defer func() {
+		attempt.DurationMS = time.Since(start).Milliseconds()
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg.URL, bytes.NewReader(body))
+	if err != nil {
+		ctx.SetNoRetry()
+		attempt.Error = truncate(err.Error())
+		return attempt, err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("User-Agent", build.UserAgent())
+	req.Header.Set(SignatureHeader, Sign(msg.Secret, body))
+	req.Header.Set(DeliveryHeader, delivery.DocID)
+	req.Header.Set(EventHeader, strings.TrimPrefix(delivery.Watched+":"+delivery.Verb, ":"))
+
+	res, err := safehttp.DefaultClient.Do(req)
+	if err != nil {
+		attempt.Error = truncate(err.Error())
+		return attempt, err
+	}
+	defer res.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(res.Body, 1<<16))
+
+	attempt.StatusCode = res.StatusCode
+	if res.StatusCode >= 200 && res.StatusCode < 300 {
+		return attempt, nil
+	}
+	err = fmt.Errorf("unexpected status code %d", res.StatusCode)
+	attempt.Error = err.Error()
+	// The client errors won't be fixed by sending the same request again,
+	// except for timeouts and rate-limiting.
+	if res.StatusCode >= 400 && res.StatusCode < 500 &&
+		res.StatusCode != http.StatusRequestTimeout &&
+		res.StatusCode != http.StatusTooManyRequests {
+		ctx.SetNoRetry()
+	}
+	return attempt, err
+}
+
+// Sign returns the value of the signature header for the given body: it is
+// the hex-encoded HMAC-SHA256 of the body with the secret, prefixed by the
+// name of the algorithm.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// watchedDocType returns the doctype watched by the trigger, or an empty
+// string if the trigger watches several doctypes.
+func watchedDocType(db prefixer.Prefixer, triggerID string) string {
+	if triggerID == "" {
+		return ""
+	}
+	t, err := job.System().GetTrigger(db, triggerID)
+	if err != nil {
+		return ""
+	}
+	doctype := ""
+	for _, arg := range strings.Fields(t.Infos().Arguments) {
+		rule, err := permission.UnmarshalRuleString(arg)
+		if err != nil {
+			return ""
+		}
+		if doctype != "" && doctype != rule.Type {
+			return ""
+		}
+		doctype = rule.Type
+	}
+	return doctype
+}
+
+func docID(doc json.RawMessage) string {
+	var d struct {
+		ID string `json:"_id"`
+	}
+	_ = json.Unmarshal(doc, &d)
+	return d.ID
+}
+
+func truncate(msg string) string {
+	if len(msg) > maxErrorLength {
+		return msg[:maxErrorLength]
+	}
+	return msg
+}
+
+func getDelivery(db prefixer.Prefixer, id string) (*Delivery, error) {
+	d := &Delivery{}
+	err := couchdb.GetDoc(db, consts.WebhooksDeliveries, id, d)
+	if couchdb.IsNotFoundError(err) || couchdb.IsNoDatabaseError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func saveDelivery(db prefixer.Prefixer, d *Delivery) error {
+	if d.DocRev == "" {
+		return couchdb.CreateNamedDocWithDB(db, d)
+	}
+	return couchdb.UpdateDoc(db, d)
+}
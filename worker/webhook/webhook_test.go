@@ -0,0 +1,210 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cozy/cozy-stack/model/job"
+	"github.com/cozy/cozy-stack/pkg/config/config"
+	"github.com/cozy/cozy-stack/pkg/couchdb"
+	"github.com/cozy/cozy-stack/pkg/realtime"
+	"github.com/cozy/cozy-stack/tests/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"verb":"CREATED"}`)
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write(body)
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	assert.Equal(t, expected, Sign("s3cr3t", body))
+	assert.NotEqual(t, expected, Sign("other", body))
+	assert.NotEqual(t, expected, Sign("s3cr3t", []byte(`{"verb":"DELETED"}`)))
+}
+
+func TestValidateMessage(t *testing.T) {
+	msg := Message{URL: "https://example.org/hooks/cozy", Secret: "s3cr3t"}
+	assert.NoError(t, msg.Validate())
+
+	msg = Message{URL: "https://example.org/hooks/cozy"}
+	assert.ErrorIs(t, msg.Validate(), ErrMissingSecret)
+
+	for _, u := range []string{"", "/hooks/cozy", "ftp://example.org/", "javascript:alert(1)"} {
+		msg = Message{URL: u, Secret: "s3cr3t"}
+		assert.ErrorIs(t, msg.Validate(), ErrInvalidURL, u)
+	}
+}
+
+func TestDeliveryStates(t *testing.T) {
+	failure := errors.New("unexpected status code 503")
+
+	// A failed attempt is retried, until the job system stops retrying
+	d := &Delivery{State: StatePending}
+	d.State = attemptState(failure, false)
+	assert.Equal(t, StateRetrying, d.State)
+	d.State = attemptState(failure, false)
+	assert.Equal(t, StateRetrying, d.State)
+	assert.True(t, finishDelivery(d))
+	assert.Equal(t, StateFailed, d.State)
+	assert.False(t, finishDelivery(d))
+
+	// A retry can succeed
+	d = &Delivery{State: StatePending}
+	d.State = attemptState(failure, false)
+	d.State = attemptState(nil, false)
+	assert.Equal(t, StateDelivered, d.State)
+	assert.False(t, finishDelivery(d))
+	assert.Equal(t, StateDelivered, d.State)
+
+	// An error that won't be fixed by a retry fails the delivery at once
+	d = &Delivery{State: StatePending}
+	d.State = attemptState(failure, true)
+	assert.Equal(t, StateFailed, d.State)
+	assert.False(t, finishDelivery(d))
+
+	// A job stopped before saving the delivery of its first attempt
+	d = &Delivery{State: StatePending}
+	assert.True(t, finishDelivery(d))
+	assert.Equal(t, StateFailed, d.State)
+}
+
+func TestDelivery(t *testing.T) {
+	if testing.Short() {
+		t.Skip("an instance is required for this test: test skipped due to the use of --short flag")
+	}
+
+	config.UseTestFile(t)
+	testutils.NeedCouchdb(t)
+	setup := testutils.NewSetup(t, t.Name())
+	inst := setup.GetTestInstance()
+
+	var statusCode atomic.Int32
+	var received []*http.Request
+	var bodies [][]byte
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received = append(received, r)
+		bodies = append(bodies, body)
+		mu.Unlock()
+		w.WriteHeader(int(statusCode.Load()))
+	}))
+	t.Cleanup(ts.Close)
+
+	runJob := func(t *testing.T, jobID string) (*job.TaskContext, error) {
+		msg, err := job.NewMessage(&Message{URL: ts.URL + "/hooks", Secret: "s3cr3t"})
+		require.NoError(t, err)
+		evt, err := job.NewEvent(&realtime.Event{
+			Domain: inst.Domain,
+			Verb:   "CREATED",
+			Doc: &couchdb.JSONDoc{
+				Type: "io.cozy.files",
+				M:    map[string]interface{}{"_id": "file-" + jobID, "name": "foo.txt"},
+			},
+		})
+		require.NoError(t, err)
+		j := &job.Job{
+			JobID:      jobID,
+			Domain:     inst.Domain,
+			WorkerType: WorkerType,
+			Message:    msg,
+			Event:      evt,
+		}
+		ctx, cancel := job.NewTaskContext("test", j, inst)
+		t.Cleanup(cancel)
+		return ctx, Worker(ctx)
+	}
+	reset := func(code int) {
+		mu.Lock()
+		received, bodies = nil, nil
+		mu.Unlock()
+		statusCode.Store(int32(code))
+	}
+
+	t.Run("Delivered", func(t *testing.T) {
+		reset(http.StatusOK)
+		_, err := runJob(t, "delivered")
+		require.NoError(t, err)
+
+		require.Len(t, received, 1)
+		req := received[0]
+		assert.Equal(t, http.MethodPost, req.Method)
+		assert.Equal(t, "/hooks", req.URL.Path)
+		assert.Equal(t, Sign("s3cr3t", bodies[0]), req.Header.Get(SignatureHeader))
+		assert.Equal(t, "delivered", req.Header.Get(DeliveryHeader))
+		assert.Equal(t, "CREATED", req.Header.Get(EventHeader))
+
+		var payload Payload
+		require.NoError(t, json.Unmarshal(bodies[0], &payload))
+		assert.Equal(t, "delivered", payload.DeliveryID)
+		assert.Equal(t, inst.Domain, payload.Domain)
+		assert.Equal(t, "CREATED", payload.Verb)
+
+		delivery, err := getDelivery(inst, "delivered")
+		require.NoError(t, err)
+		require.NotNil(t, delivery)
+		assert.Equal(t, StateDelivered, delivery.State)
+		assert.Equal(t, "file-delivered", delivery.DocumentID)
+		require.Len(t, delivery.Attempts, 1)
+		assert.Equal(t, http.StatusOK, delivery.Attempts[0].StatusCode)
+		assert.Empty(t, delivery.Attempts[0].Error)
+	})
+
+	t.Run("RetriedThenFailed", func(t *testing.T) {
+		reset(http.StatusServiceUnavailable)
+		var ctx *job.TaskContext
+		var err error
+		for i := 0; i < 3; i++ {
+			ctx, err = runJob(t, "retried")
+			require.Error(t, err)
+			assert.False(t, ctx.NoRetry())
+
+			delivery, errg := getDelivery(inst, "retried")
+			require.NoError(t, errg)
+			require.NotNil(t, delivery)
+			assert.Equal(t, StateRetrying, delivery.State)
+			require.Len(t, delivery.Attempts, i+1)
+			assert.Equal(t, http.StatusServiceUnavailable, delivery.Attempts[i].StatusCode)
+			assert.Contains(t, delivery.Attempts[i].Error, "503")
+		}
+
+		// All the attempts are signed and share the delivery identifier
+		require.Len(t, received, 3)
+		for i, req := range received {
+			assert.Equal(t, Sign("s3cr3t", bodies[i]), req.Header.Get(SignatureHeader))
+			assert.Equal(t, "retried", req.Header.Get(DeliveryHeader))
+		}
+
+		// The job system calls commit after the last attempt
+		require.NoError(t, commit(ctx, err))
+		delivery, err := getDelivery(inst, "retried")
+		require.NoError(t, err)
+		assert.Equal(t, StateFailed, delivery.State)
+		assert.Len(t, delivery.Attempts, 3)
+	})
+
+	t.Run("ClientErrorIsNotRetried", func(t *testing.T) {
+		reset(http.StatusGone)
+		ctx, err := runJob(t, "gone")
+		require.Error(t, err)
+		assert.True(t, ctx.NoRetry())
+
+		delivery, err := getDelivery(inst, "gone")
+		require.NoError(t, err)
+		assert.Equal(t, StateFailed, delivery.State)
+		require.Len(t, delivery.Attempts, 1)
+		assert.Equal(t, http.StatusGone, delivery.Attempts[0].StatusCode)
+	})
+}